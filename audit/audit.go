@@ -0,0 +1,166 @@
+// Package audit records the token validation decisions asynchronously in a Sink. The events are chained by
+// their HMACs, so that removed or changed events can be detected with Verify by the holders of the key
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// the decisions of the events
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// maxBatchSize is the maximum number of events written to the sink at once
+const maxBatchSize = 100
+
+// headInterval is how often the head of the chain is logged. With the planb.audit.seq gauge, it's recorded
+// outside of the sink, so that removed events at the end of the chain can be detected
+const headInterval = time.Minute
+
+// ErrBrokenChain is returned by Verify when the events are not chained by their hashes
+var ErrBrokenChain = errors.New("broken hash chain")
+
+// An Event is the record of one token validation. Seq, PrevHash and Hash are set by the Logger: Hash is the
+// HMAC-SHA256 of the JSON encoded event with an empty Hash, and PrevHash is the Hash of the previous event
+type Event struct {
+	Time      string `json:"time"`
+	Seq       uint64 `json:"seq"`
+	RequestID string `json:"request_id,omitempty"`
+	Path      string `json:"path"`
+	TokenHash string `json:"token_hash,omitempty"`
+	UID       string `json:"uid,omitempty"`
+	Realm     string `json:"realm,omitempty"`
+	Decision  string `json:"decision"`
+	Reason    string `json:"reason,omitempty"`
+	CallerIP  string `json:"caller_ip,omitempty"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+}
+
+// A Sink stores the events, in order
+type Sink interface {
+	Write(events []*Event) error
+}
+
+// A Logger queues the events and writes them in batches to a Sink. When the queue is full, the events are
+// dropped instead of slowing down the validations
+type Logger struct {
+	sink     Sink
+	key      []byte
+	queue    chan *Event
+	interval time.Duration
+	seq      uint64
+	prevHash string
+	logged   time.Time
+}
+
+// NewLogger returns a Logger that queues up to queueSize events, chains them with key and writes them to sink
+// at least every interval
+func NewLogger(sink Sink, key []byte, queueSize int, interval time.Duration) *Logger {
+	l := &Logger{sink: sink, key: key, queue: make(chan *Event, queueSize), interval: interval}
+	go l.run()
+	return l
+}
+
+// Record queues the event without waiting. It's counted in planb.audit.dropped when the queue is full
+func (l *Logger) Record(e *Event) {
+	select {
+	case l.queue <- e:
+	default:
+		incCounter("planb.audit.dropped")
+	}
+}
+
+func (l *Logger) run() {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	batch := make([]*Event, 0, maxBatchSize)
+	for {
+		select {
+		case e := <-l.queue:
+			batch = append(batch, l.chain(e))
+			if len(batch) < maxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		l.write(batch)
+		batch = batch[:0]
+	}
+}
+
+// chain sets the sequence number and the hashes of the event
+func (l *Logger) chain(e *Event) *Event {
+	l.seq++
+	e.Seq = l.seq
+	e.PrevHash = l.prevHash
+	e.Hash = hash(l.key, e)
+	l.prevHash = e.Hash
+	return e
+}
+
+// write writes the batch to the sink. Failed batches are counted and logged, they are not retried. The head
+// of the chain is published in the planb.audit.seq gauge and logged every headInterval
+func (l *Logger) write(batch []*Event) {
+	if err := l.sink.Write(batch); err != nil {
+		incCounter("planb.audit.failures")
+		slog.Warn("Failed to write audit events", "events", len(batch), "error", err)
+		return
+	}
+	if c, ok := metrics.DefaultRegistry.GetOrRegister("planb.audit.written", metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(int64(len(batch)))
+	}
+	head := batch[len(batch)-1]
+	if g, ok := metrics.DefaultRegistry.GetOrRegister("planb.audit.seq", metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(int64(head.Seq))
+	}
+	if now := time.Now(); now.Sub(l.logged) >= headInterval {
+		slog.Info("Audit log head", "seq", head.Seq, "hash", head.Hash)
+		l.logged = now
+	}
+}
+
+func hash(key []byte, e *Event) string {
+	unhashed := *e
+	unhashed.Hash = ""
+	b, _ := json.Marshal(&unhashed)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks with the key of the Logger that every event has the hash of its content and the hash of the
+// previous event, so that the events were not changed, removed or reordered. The first event may follow
+// events that are not passed, and events after the last one are only detected by comparing its Seq with the
+// recorded head of the chain
+func Verify(events []*Event, key []byte) error {
+	for i, e := range events {
+		if !hmac.Equal([]byte(e.Hash), []byte(hash(key, e))) {
+			return fmt.Errorf("%w: event %d was changed", ErrBrokenChain, e.Seq)
+		}
+		if i > 0 && (e.PrevHash != events[i-1].Hash || e.Seq != events[i-1].Seq+1) {
+			return fmt.Errorf("%w: event %d doesn't follow event %d", ErrBrokenChain, e.Seq, events[i-1].Seq)
+		}
+	}
+	return nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+var testKey = []byte("secret")
+
+type chanSink chan []*Event
+
+func (s chanSink) Write(events []*Event) error {
+	s <- append([]*Event(nil), events...)
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	sink := make(chanSink, 10)
+	l := NewLogger(sink, testKey, 10, 10*time.Millisecond)
+	l.Record(&Event{Path: "/oauth2/tokeninfo", Decision: Allow, UID: "foo"})
+	l.Record(&Event{Path: "/oauth2/tokeninfo", Decision: Deny, Reason: "expired"})
+
+	var events []*Event
+	for len(events) < 2 {
+		select {
+		case batch := <-sink:
+			events = append(events, batch...)
+		case <-time.After(time.Second):
+			t.Fatal("The events should be written after the flush interval")
+		}
+	}
+	if events[0].Seq != 1 || events[1].Seq != 2 || events[0].PrevHash != "" || events[1].PrevHash != events[0].Hash {
+		t.Errorf("The events should be chained, got %+v %+v", events[0], events[1])
+	}
+	if err := Verify(events, testKey); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+	if seq := metrics.GetOrRegisterGauge("planb.audit.seq", metrics.DefaultRegistry).Value(); seq != 2 {
+		t.Errorf("The head of the chain should be recorded, got %d", seq)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	dropped := metrics.GetOrRegisterCounter("planb.audit.dropped", metrics.DefaultRegistry)
+	before := dropped.Count()
+	// without the writer goroutine the queue is never emptied
+	l := &Logger{queue: make(chan *Event, 1)}
+	l.Record(&Event{})
+	l.Record(&Event{})
+	if dropped.Count() != before+1 {
+		t.Errorf("The event should be dropped when the queue is full, got %d", dropped.Count()-before)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	chained := func() []*Event {
+		l := &Logger{key: testKey}
+		return []*Event{
+			l.chain(&Event{Decision: Allow, UID: "foo"}),
+			l.chain(&Event{Decision: Deny, Reason: "expired"}),
+			l.chain(&Event{Decision: Allow, UID: "bar"}),
+		}
+	}
+	for _, test := range []struct {
+		name   string
+		change func(events []*Event) []*Event
+		key    []byte
+		valid  bool
+	}{
+		{"unchanged", func(events []*Event) []*Event { return events }, testKey, true},
+		{"tail", func(events []*Event) []*Event { return events[1:] }, testKey, true},
+		{"changed", func(events []*Event) []*Event { events[1].Decision = Allow; return events }, testKey, false},
+		{"removed", func(events []*Event) []*Event { return append(events[:1], events[2]) }, testKey, false},
+		{"reordered", func(events []*Event) []*Event { events[1], events[2] = events[2], events[1]; return events }, testKey, false},
+		{"rehashed", func(events []*Event) []*Event {
+			events[1].Decision = Allow
+			events[1].Hash = hash(testKey, events[1])
+			return events
+		}, testKey, false},
+		{"rehashed without the key", func(events []*Event) []*Event {
+			l := &Logger{prevHash: events[0].Hash, seq: 1}
+			return append(events[:1], l.chain(&Event{Decision: Allow, UID: "foo"}))
+		}, testKey, false},
+		{"other key", func(events []*Event) []*Event { return events }, []byte("other"), false},
+	} {
+		err := Verify(test.change(chained()), test.key)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrBrokenChain) {
+			t.Errorf("%s: the broken chain should be detected, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+// responses bigger than this are not inspected for the uid, realm or error fields
+const maxInspectedBody = 64 * 1024
+
+type handler struct {
+	next   http.Handler
+	logger *Logger
+}
+
+// NewHandler returns an http.Handler that records the decision of every token info response of next with
+// the logger: tokens are allowed with a 200 response and denied otherwise, with the error reason. The
+// requests of the batch, introspection and token review endpoints for each token are recorded too
+func NewHandler(next http.Handler, logger *Logger) http.Handler {
+	return &handler{next: next, logger: logger}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(rw, r)
+
+	e := &Event{
+		Time:      start.UTC().Format(time.RFC3339Nano),
+		RequestID: requestid.FromContext(r.Context()),
+		Path:      r.URL.Path,
+		Decision:  Deny,
+		CallerIP:  clientip.FromRequest(r),
+	}
+	// the next handler already parsed the form, so the body is not read again here
+	if token := tokeninfo.AccessTokenFromRequest(r); token != "" {
+		e.TokenHash = tokeninfo.HashPrefix(token)
+	}
+	rw.inspect(e)
+	h.logger.Record(e)
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.body.Len()+len(b) <= maxInspectedBody {
+		rw.body.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// inspect sets the decision, uid and realm of successful responses and the reason of failed ones
+func (rw *responseWriter) inspect(e *Event) {
+	var body struct {
+		Realm       string `json:"realm"`
+		UID         string `json:"uid"`
+		Error       string `json:"error"`
+		ErrorReason string `json:"error_reason"`
+	}
+	json.Unmarshal(rw.body.Bytes(), &body)
+	switch {
+	case rw.status == http.StatusOK:
+		e.Decision, e.UID, e.Realm = Allow, body.UID, body.Realm
+	case body.ErrorReason != "":
+		e.Reason = body.ErrorReason
+	case body.Error != "":
+		e.Reason = body.Error
+	default:
+		e.Reason = http.StatusText(rw.status)
+	}
+}
@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+func TestHandler(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		body   string
+		want   Event
+	}{
+		{http.StatusOK, `{"uid":"foo","realm":"/services"}`, Event{Decision: Allow, UID: "foo", Realm: "/services"}},
+		{http.StatusUnauthorized, `{"error":"invalid_token","error_reason":"expired"}`, Event{Decision: Deny, Reason: "expired"}},
+		{http.StatusBadRequest, `{"error":"invalid_request"}`, Event{Decision: Deny, Reason: "invalid_request"}},
+		{http.StatusServiceUnavailable, ``, Event{Decision: Deny, Reason: "Service Unavailable"}},
+	} {
+		l := &Logger{queue: make(chan *Event, 1)}
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		})
+		r, _ := http.NewRequest("GET", "/oauth2/tokeninfo", nil)
+		r.Header.Set("Authorization", "Bearer foo")
+		r.Header.Set(requestid.Header, "r1")
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		requestid.NewHandler(NewHandler(next, l)).ServeHTTP(w, r)
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("The response should be passed on, got %d %q", w.Code, w.Body.String())
+		}
+
+		e := <-l.queue
+		if e.Decision != test.want.Decision || e.UID != test.want.UID || e.Realm != test.want.Realm || e.Reason != test.want.Reason {
+			t.Errorf("Wrong event for %d %s. Wanted %+v, got %+v", test.status, test.body, test.want, e)
+		}
+		if e.CallerIP != "10.0.0.1" || e.RequestID != "r1" || e.Path != "/oauth2/tokeninfo" || len(e.TokenHash) != 12 || e.Time == "" {
+			t.Errorf("Missing request details: %+v", e)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/zalando/planb-tokeninfo/ht"
+)
+
+// sinkTimeout is the timeout of the requests of the HTTP and Kafka sinks
+const sinkTimeout = 10 * time.Second
+
+type fileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink returns a Sink that appends the events as JSON lines to the file at path
+func NewFileSink(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(events []*Event) error {
+	body, err := jsonLines(events)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(body)
+	return err
+}
+
+type httpSink struct {
+	url         string
+	client      *http.Client
+	contentType string
+	encode      func(events []*Event) ([]byte, error)
+}
+
+// NewHTTPSink returns a Sink that posts the events as JSON lines (application/x-ndjson) to url
+func NewHTTPSink(url string) Sink {
+	return &httpSink{url: url, client: &http.Client{Timeout: sinkTimeout, Transport: ht.NewTransport()},
+		contentType: "application/x-ndjson", encode: jsonLines}
+}
+
+// NewKafkaSink returns a Sink that produces the events to a Kafka topic with the REST Proxy API v2, at url
+// like http://kafka-rest:8082/topics/audit
+func NewKafkaSink(url string) Sink {
+	return &httpSink{url: url, client: &http.Client{Timeout: sinkTimeout, Transport: ht.NewTransport()},
+		contentType: "application/vnd.kafka.json.v2+json", encode: kafkaRecords}
+}
+
+func (s *httpSink) Write(events []*Event) error {
+	body, err := s.encode(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	req.Header.Set("User-Agent", ht.UserAgent)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func jsonLines(events []*Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// kafkaRecords encodes the events as the records of a produce request, keyed by token hash so that the
+// events of a token stay in one partition
+func kafkaRecords(events []*Event) ([]byte, error) {
+	type record struct {
+		Key   string `json:"key,omitempty"`
+		Value *Event `json:"value"`
+	}
+	records := make([]record, len(events))
+	for i, e := range events {
+		records[i] = record{Key: e.TokenHash, Value: e}
+	}
+	return json.Marshal(map[string]interface{}{"records": records})
+}
+
+type kafkaNativeSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaNativeSink returns a Sink that produces the events as JSON with w, straight to the brokers, keyed by
+// token hash like the ones of NewKafkaSink
+func NewKafkaNativeSink(w *kafka.Writer) Sink {
+	return &kafkaNativeSink{writer: w}
+}
+
+func (s *kafkaNativeSink) Write(events []*Event) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msgs[i].Value = b
+		if e.TokenHash != "" {
+			msgs[i].Key = []byte(e.TokenHash)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+	return s.writer.WriteMessages(ctx, msgs...)
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := &Logger{key: testKey}
+	for i := 0; i < 2; i++ {
+		// the events of previous processes are kept
+		s, err := NewFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write([]*Event{l.chain(&Event{Decision: Allow}), l.chain(&Event{Decision: Deny})}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if fi, _ := f.Stat(); fi.Mode().Perm() != 0600 {
+		t.Errorf("The audit log should only be readable by the owner, got %v", fi.Mode().Perm())
+	}
+	var events []*Event
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		e := new(Event)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Wanted 4 events, got %d", len(events))
+	}
+	if err := Verify(events, testKey); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+}
+
+func TestHTTPSinks(t *testing.T) {
+	for _, test := range []struct {
+		sink        func(url string) Sink
+		contentType string
+		wantBody    string
+	}{
+		{NewHTTPSink, "application/x-ndjson",
+			`{"time":"","seq":1,"path":"","token_hash":"abc","decision":"allow","prev_hash":"","hash":"h"}` + "\n"},
+		{NewKafkaSink, "application/vnd.kafka.json.v2+json",
+			`{"records":[{"key":"abc","value":{"time":"","seq":1,"path":"","token_hash":"abc","decision":"allow","prev_hash":"","hash":"h"}}]}`},
+	} {
+		var contentType, body string
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			contentType = req.Header.Get("Content-Type")
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			w.WriteHeader(status)
+		}))
+		s := test.sink(server.URL)
+		events := []*Event{{Seq: 1, TokenHash: "abc", Decision: Allow, Hash: "h"}}
+		if err := s.Write(events); err != nil {
+			t.Error("Unexpected error: ", err)
+		}
+		if contentType != test.contentType || body != test.wantBody {
+			t.Errorf("Wrong request. Wanted %s %s, got %s %s", test.contentType, test.wantBody, contentType, body)
+		}
+		status = http.StatusInternalServerError
+		if err := s.Write(events); err == nil {
+			t.Error("The failed request should return an error")
+		}
+		server.Close()
+	}
+}
@@ -17,11 +17,21 @@ func Get(name string, url string) (*http.Response, error) {
 
 // GetWithFallback will fetch the HTTP resource from url using a GET method, wrapped in a circuit breaker named name.
 // If the operation fails, the fallback function f is called with the previous error as an argument
-func GetWithFallback(name string, url string, f func(error) error) (resp *http.Response, err error) {
+func GetWithFallback(name string, url string, f func(error) error) (*http.Response, error) {
+	return call(name, func() (*http.Response, error) { return ht.Default.Get(url) }, f)
+}
+
+// Do will send the HTTP request req, wrapped in a circuit breaker named name. Use it when the request needs
+// custom headers, like conditional requests
+func Do(name string, req *http.Request) (*http.Response, error) {
+	return call(name, func() (*http.Response, error) { return ht.Default.Do(req) }, nil)
+}
+
+func call(name string, send func() (*http.Response, error), f func(error) error) (resp *http.Response, err error) {
 	err = hystrix.Do(name, func() error {
 		start := time.Now()
 		var internalError error
-		if resp, internalError = ht.Default.Get(url); internalError == nil {
+		if resp, internalError = send(); internalError == nil {
 			measureRequest(start, fmt.Sprintf("planb.breaker.%s", name))
 		} else {
 			registerFailure(name)
@@ -31,6 +41,23 @@ func GetWithFallback(name string, url string, f func(error) error) (resp *http.R
 	return
 }
 
+// IsOpen reports whether the circuit breaker named name is currently open, i.e., rejecting calls
+func IsOpen(name string) bool {
+	c, _, err := hystrix.GetCircuit(name)
+	if err != nil {
+		return false
+	}
+	return c.IsOpen()
+}
+
+// State returns a human readable state of the circuit breaker named name: "open" or "closed"
+func State(name string) string {
+	if IsOpen(name) {
+		return "open"
+	}
+	return "closed"
+}
+
 func measureRequest(start time.Time, key string) {
 	if t, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewTimer).(metrics.Timer); ok {
 		t.UpdateSince(start)
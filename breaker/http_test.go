@@ -78,4 +78,12 @@ func TestCircuitBreakerFailures(t *testing.T) {
 	if err != hystrix.ErrCircuitOpen {
 		t.Error("Error is not circuit open: ", err)
 	}
+
+	if !IsOpen("fail") || State("fail") != "open" {
+		t.Error("Circuit `fail` should be reported as open")
+	}
+
+	if IsOpen("fallback") || State("fallback") != "closed" {
+		t.Error("Circuit `fallback` should be reported as closed")
+	}
 }
@@ -0,0 +1,157 @@
+// Package clientip finds the IP address of the client of a request. Behind load balancers and other proxies
+// the remote address of the connection is the one of the nearest proxy, the client is in the Forwarded or
+// X-Forwarded-For headers added by the proxies. These headers are only read for the trusted proxies, anyone
+// else could send them to pass for another client
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type contextKey struct{}
+
+type handler struct {
+	next    http.Handler
+	trusted []netip.Prefix
+}
+
+// NewHandler returns an http.Handler that finds the client IP of the Request with Resolve before calling next.
+// The IP is in the Request context, see FromRequest
+func NewHandler(next http.Handler, trusted []netip.Prefix) http.Handler {
+	return &handler{next: next, trusted: trusted}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, Resolve(r, h.trusted))))
+}
+
+// FromRequest returns the client IP of r found by the handler or, for requests that didn't go through it,
+// the host of the remote address
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// Resolve returns the IP of the client of r. If the remote address is in one of the trusted networks, the
+// addresses of the forwarding headers are read from right to left, the nearest proxy first, and the first one
+// that is not trusted is the client. The Forwarded header is preferred to X-Forwarded-For. An unknown or
+// obfuscated address ends the chain, the last proxy stands for the client then
+func Resolve(r *http.Request, trusted []netip.Prefix) string {
+	remote := remoteHost(r.RemoteAddr)
+	addr, ok := parseAddr(remote)
+	if !ok {
+		return remote
+	}
+	if !isTrusted(addr, trusted) {
+		return addr.String()
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, ok := parseAddr(hops[i])
+		if !ok {
+			break
+		}
+		addr = a
+		if !isTrusted(a, trusted) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// FromTrustedProxy returns true if the remote address of r is in one of the trusted networks, so that the headers
+// added by the proxies, like X-Forwarded-Proto, can be read
+func FromTrustedProxy(r *http.Request, trusted []netip.Prefix) bool {
+	addr, ok := parseAddr(remoteHost(r.RemoteAddr))
+	return ok && isTrusted(addr, trusted)
+}
+
+// ParsePrefix parses a network in CIDR notation, for ex. 10.0.0.0/8 or fd00::/8. A single address is a
+// network of its own
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+func isTrusted(a netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the addresses of the for parameters of the Forwarded headers or, without them, of the
+// X-Forwarded-For headers, the nearest proxy last. Elements of Forwarded without a for parameter are kept
+// as empty addresses
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				var hop string
+				for _, pair := range strings.Split(element, ";") {
+					if k, v, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(k, "for") {
+						hop = v
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an address of a forwarding header or of the remote address: an IPv4 address, an IPv6
+// address, with brackets or not, with an optional port and between quotes. IPv4 addresses mapped to IPv6
+// are returned as IPv4 addresses, so that both forms of an address are the same client
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return netip.Addr{}, false
+		}
+		s = s[1:end]
+	} else if strings.Count(s, ":") == 1 {
+		s, _, _ = strings.Cut(s, ":")
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a.Unmap().WithZone(""), true
+}
+
+// remoteHost returns the host of the remote address, or the remote address itself if it has no port, for
+// ex. for Unix sockets
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,111 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	var trusted []netip.Prefix
+	for _, s := range []string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"} {
+		p, err := ParsePrefix(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, p)
+	}
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"untrusted remote", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"IPv6 remote", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"mapped IPv4 remote", "[::ffff:203.0.113.7]:1234", nil, "203.0.113.7"},
+		{"Unix socket", "@", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "@"},
+		{"trusted without headers", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"X-Forwarded-For", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.9, 10.0.0.1, 192.0.2.1"}, "203.0.113.9"},
+		{"only proxies", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "10.0.0.2, 10.0.0.1"}, "10.0.0.2"},
+		{"IPv6 X-Forwarded-For", "[fd00::1]:1234", map[string]string{"X-Forwarded-For": "2001:db8::7"}, "2001:db8::7"},
+		{"invalid X-Forwarded-For", "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, garbage"}, "10.1.2.3"},
+		{"Forwarded", "10.1.2.3:1234", map[string]string{"Forwarded": `for=198.51.100.1;proto=https, For="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"Forwarded with IPv4 port", "10.1.2.3:1234", map[string]string{"Forwarded": `for="198.51.100.1:8080";by=10.1.2.3`}, "198.51.100.1"},
+		{"Forwarded before X-Forwarded-For", "10.1.2.3:1234", map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "203.0.113.9"}, "198.51.100.1"},
+		{"obfuscated Forwarded", "10.1.2.3:1234", map[string]string{"Forwarded": "for=198.51.100.1, for=_hidden, for=10.0.0.1"}, "10.0.0.1"},
+		{"Forwarded without for", "10.1.2.3:1234", map[string]string{"Forwarded": "proto=https"}, "10.1.2.3"},
+	} {
+		r := httptest.NewRequest("GET", "/oauth2/tokeninfo", nil)
+		r.RemoteAddr = test.remoteAddr
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		if got := Resolve(r, trusted); got != test.want {
+			t.Errorf("Wrong client IP for %s. Wanted %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestFromTrustedProxy(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, test := range []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:4711", true},
+		{"192.0.2.1:4711", false},
+		{"@", false},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if got := FromTrustedProxy(r, trusted); got != test.want {
+			t.Errorf("Wrong result for %s. Wanted %v, got %v", test.remoteAddr, test.want, got)
+		}
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	for s, want := range map[string]string{
+		"10.1.2.3/8":       "10.0.0.0/8",
+		"192.0.2.1":        "192.0.2.1/32",
+		"::ffff:192.0.2.1": "192.0.2.1/32",
+		"2001:db8::1":      "2001:db8::1/128",
+		"2001:db8::/32":    "2001:db8::/32",
+		"example.com":      "",
+		"10.0.0.0/33":      "",
+	} {
+		p, err := ParsePrefix(s)
+		if want == "" {
+			if err == nil {
+				t.Errorf("Parsing %q should fail, got %v", s, p)
+			}
+			continue
+		}
+		if err != nil || p.String() != want {
+			t.Errorf("Wrong network for %q. Wanted %s, got %v (%v)", s, want, p, err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	var got string
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromRequest(r)
+	}), trusted)
+	r := httptest.NewRequest("GET", "/oauth2/tokeninfo", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.1" {
+		t.Errorf("Wrong client IP in the context. Wanted 198.51.100.1, got %q", got)
+	}
+
+	// without the handler the forwarding headers are ignored
+	if ip := FromRequest(r); ip != "10.1.2.3" {
+		t.Errorf("Wrong client IP without the handler. Wanted 10.1.2.3, got %q", ip)
+	}
+}
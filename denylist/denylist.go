@@ -0,0 +1,188 @@
+// Package denylist implements lists of denied token IDs, the jti claims of JWT tokens, so that single
+// tokens can be rejected before they expire when only their ID is known
+package denylist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/ht"
+)
+
+// ErrUnsupportedSource is returned for deny list URLs with an unknown scheme
+var ErrUnsupportedSource = errors.New("Unsupported deny list source")
+
+// A List tells whether the token ID jti is denied
+type List interface {
+	Contains(jti string) (bool, error)
+}
+
+// New returns the List for the source u:
+//
+//	file:///etc/tokeninfo/denylist.txt  IDs in a local file, reloaded when modified
+//	https://example.org/denylist        IDs from an HTTP endpoint, downloaded again every interval
+//	redis://:password@host:6379/0       IDs in the Redis set redisKey, looked up for every token
+//
+// Files and HTTP responses have one ID per line, empty lines and lines starting with # are ignored, or
+// a JSON array of IDs. An error is returned if the initial list can't be loaded; later failures are
+// logged and the previous list is kept
+func New(u *url.URL, interval time.Duration, redisKey string) (List, error) {
+	switch u.Scheme {
+	case "file":
+		return newReloadingList(func() ([]byte, error) { return ioutil.ReadFile(u.Path) }, u.Path, interval)
+	case "http", "https":
+		return newReloadingList(func() ([]byte, error) { return download(u.String()) }, u.Redacted(), interval)
+	case "redis", "rediss":
+		return NewRedisList(u, redisKey)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedSource, u.Scheme)
+	}
+}
+
+// setList is a List of IDs held in memory
+type setList struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+// NewList returns a List of the given IDs
+func NewList(ids ...string) List {
+	l := &setList{}
+	l.reset(ids)
+	return l
+}
+
+func (l *setList) Contains(jti string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, has := l.ids[jti]
+	return has, nil
+}
+
+func (l *setList) reset(ids []string) {
+	m := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		m[id] = struct{}{}
+	}
+	l.mu.Lock()
+	l.ids = m
+	l.mu.Unlock()
+	updateGauge("planb.tokeninfo.denylist.size", int64(len(m)))
+}
+
+// reloadingList is a setList that is loaded again every interval
+type reloadingList struct {
+	setList
+	load   func() ([]byte, error)
+	source string
+	last   []byte
+}
+
+func newReloadingList(load func() ([]byte, error), source string, interval time.Duration) (List, error) {
+	l := &reloadingList{load: load, source: source}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := l.reload(); err != nil {
+				slog.Warn("Failed to reload the deny list, keeping the previous one", "source", l.source, "error", err)
+				incCounter("planb.tokeninfo.denylist.reload.failures")
+			}
+		}
+	}()
+	return l, nil
+}
+
+// reload loads the IDs and replaces the list if they changed
+func (l *reloadingList) reload() error {
+	data, err := l.load()
+	if err != nil {
+		return err
+	}
+	if l.last != nil && bytes.Equal(data, l.last) {
+		return nil
+	}
+	ids, err := ParseIDs(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", l.source, err)
+	}
+	l.reset(ids)
+	l.last = data
+	slog.Info("Loaded the denied token IDs", "source", l.source, "ids", len(ids))
+	incCounter("planb.tokeninfo.denylist.reload.success")
+	return nil
+}
+
+// ParseIDs returns the token IDs in data, either a JSON array or one ID per line
+func ParseIDs(data []byte) ([]string, error) {
+	data = bytes.TrimSpace(data)
+	var ids []string
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &ids); err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			ids = append(ids, line)
+		}
+	}
+	return ids, s.Err()
+}
+
+func download(u string) ([]byte, error) {
+	resp, err := ht.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+type redisList struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisList returns a List backed by the Redis set key on the server at u
+func NewRedisList(u *url.URL, key string) (List, error) {
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return &redisList{client: redis.NewClient(opts), key: key}, nil
+}
+
+func (l *redisList) Contains(jti string) (bool, error) {
+	return l.client.SIsMember(l.key, jti).Result()
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+func updateGauge(key string, value int64) {
+	if g, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(value)
+	}
+}
@@ -0,0 +1,129 @@
+package denylist
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testContains(t *testing.T, l List, jti string, want bool) {
+	if denied, err := l.Contains(jti); err != nil {
+		t.Errorf("Failed to check %q: %v", jti, err)
+	} else if denied != want {
+		t.Errorf("Wrong result for %q. Wanted %t, got %t", jti, want, denied)
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	for _, test := range []struct {
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"foo\nbar\n", []string{"foo", "bar"}, false},
+		{"# denied tokens\n\n  foo  \r\nbar", []string{"foo", "bar"}, false},
+		{`["foo", "bar"]`, []string{"foo", "bar"}, false},
+		{`["foo", 42]`, nil, true},
+	} {
+		ids, err := ParseIDs([]byte(test.data))
+		if test.wantErr != (err != nil) {
+			t.Errorf("Unexpected error status for %q: %v", test.data, err)
+		}
+		if !test.wantErr && !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("Wrong IDs for %q. Wanted %v, got %v", test.data, test.want, ids)
+		}
+	}
+}
+
+func TestFileList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	os.WriteFile(path, []byte("foo\n"), 0600)
+	l, err := New(&url.URL{Scheme: "file", Path: path}, 10*time.Millisecond, "")
+	if err != nil {
+		t.Fatal("Failed to load the deny list: ", err)
+	}
+	testContains(t, l, "foo", true)
+	testContains(t, l, "bar", false)
+
+	os.WriteFile(path, []byte("bar\n"), 0600)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if denied, _ := l.Contains("bar"); denied {
+			break
+		}
+	}
+	testContains(t, l, "foo", false)
+	testContains(t, l, "bar", true)
+
+	// invalid lists are ignored
+	os.WriteFile(path, []byte(`["foo", 42]`), 0600)
+	time.Sleep(50 * time.Millisecond)
+	testContains(t, l, "bar", true)
+
+	if _, err := New(&url.URL{Scheme: "file", Path: filepath.Join(t.TempDir(), "missing")}, time.Minute, ""); err == nil {
+		t.Error("Wanted an error for a missing file")
+	}
+}
+
+func TestHTTPList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/denylist" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `["foo","bar"]`)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/denylist")
+	l, err := New(u, time.Minute, "")
+	if err != nil {
+		t.Fatal("Failed to load the deny list: ", err)
+	}
+	testContains(t, l, "foo", true)
+	testContains(t, l, "bar", true)
+	testContains(t, l, "baz", false)
+
+	u, _ = url.Parse(server.URL + "/missing")
+	if _, err := New(u, time.Minute, ""); err == nil {
+		t.Error("Wanted an error for a failed download")
+	}
+}
+
+func TestRedisList(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("Failed to start redis server: ", err)
+	}
+	defer s.Close()
+	s.SAdd("denied", "foo")
+
+	u, _ := url.Parse("redis://" + s.Addr())
+	l, err := New(u, time.Minute, "denied")
+	if err != nil {
+		t.Fatal("Failed to create the deny list: ", err)
+	}
+	testContains(t, l, "foo", true)
+	testContains(t, l, "bar", false)
+
+	s.Close()
+	if _, err := l.Contains("foo"); err == nil {
+		t.Error("Wanted an error without a redis server")
+	}
+}
+
+func TestUnsupportedSource(t *testing.T) {
+	u, _ := url.Parse("ftp://example.com/denylist")
+	if _, err := New(u, time.Minute, ""); !errors.Is(err, ErrUnsupportedSource) {
+		t.Errorf("Wanted an unsupported source error, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package extauthz implements the Envoy external authorization gRPC service (ext_authz). Envoy sends
+// the headers of every request and the Bearer token in the Authorization header is validated by the
+// same Token Info handlers as the /oauth2/tokeninfo endpoint, including the upstream cache
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Headers added to the authorized requests before Envoy sends them to the upstream service
+const (
+	HeaderUID   = "x-tokeninfo-uid"
+	HeaderRealm = "x-tokeninfo-realm"
+	HeaderScope = "x-tokeninfo-scope"
+)
+
+type server struct {
+	authv3.UnimplementedAuthorizationServer
+	tokenInfo http.Handler
+}
+
+// the subset of the Token Info response forwarded to the upstream service
+type tokenInfoResponse struct {
+	UID   string   `json:"uid"`
+	Scope []string `json:"scope"`
+	Realm string   `json:"realm"`
+}
+
+// NewServer returns an Envoy AuthorizationServer that validates tokens with the tokenInfo http.Handler
+func NewServer(tokenInfo http.Handler) authv3.AuthorizationServer {
+	return &server{tokenInfo: tokenInfo}
+}
+
+// NewGRPCServer returns a grpc.Server with the AuthorizationServer registered
+func NewGRPCServer(tokenInfo http.Handler) *grpc.Server {
+	s := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(s, NewServer(tokenInfo))
+	return s
+}
+
+// Check allows requests with a valid Bearer token. Denied requests get the Token Info error response
+func (s *server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	rec := tokeninfo.NewResponseRecorder()
+	authorization := header(req.GetAttributes().GetRequest().GetHttp(), "authorization")
+	if strings.HasPrefix(strings.ToLower(authorization), "bearer ") {
+		r, _ := http.NewRequest(http.MethodGet, "/oauth2/tokeninfo", nil)
+		r.Header.Set("Authorization", authorization)
+		// the per IP rate limit applies to the client of Envoy, not to Envoy
+		if addr := req.GetAttributes().GetSource().GetAddress().GetSocketAddress(); addr != nil {
+			r.RemoteAddr = net.JoinHostPort(addr.GetAddress(), strconv.Itoa(int(addr.GetPortValue())))
+		}
+		s.tokenInfo.ServeHTTP(rec, r.WithContext(ctx))
+	} else {
+		tokeninfo.ErrInvalidRequest.Write(rec, nil)
+	}
+
+	if rec.Status != http.StatusOK {
+		incCounter("planb.tokeninfo.extauthz.denied")
+		return deniedResponse(rec.Status, rec.Header(), rec.Body.String()), nil
+	}
+
+	ti := new(tokenInfoResponse)
+	if err := json.Unmarshal(rec.Body.Bytes(), ti); err != nil {
+		incCounter("planb.tokeninfo.extauthz.denied")
+		return deniedResponse(http.StatusInternalServerError, http.Header{"Content-Type": {"text/plain"}}, http.StatusText(http.StatusInternalServerError)), nil
+	}
+
+	incCounter("planb.tokeninfo.extauthz.allowed")
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{
+					headerValue(HeaderUID, ti.UID),
+					headerValue(HeaderRealm, ti.Realm),
+					headerValue(HeaderScope, strings.Join(ti.Scope, " ")),
+				},
+			},
+		},
+	}, nil
+}
+
+// header returns the value of the lower case header name from either the header map or the raw headers
+func header(req *authv3.AttributeContext_HttpRequest, name string) string {
+	if v, has := req.GetHeaders()[name]; has {
+		return v
+	}
+	for _, h := range req.GetHeaderMap().GetHeaders() {
+		if strings.ToLower(h.GetKey()) != name {
+			continue
+		}
+		if h.GetValue() != "" {
+			return h.GetValue()
+		}
+		return string(h.GetRawValue())
+	}
+	return ""
+}
+
+func headerValue(key string, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{Header: &corev3.HeaderValue{Key: key, Value: value}}
+}
+
+func deniedResponse(httpStatus int, header http.Header, body string) *authv3.CheckResponse {
+	code := codes.Unauthenticated
+	switch {
+	case httpStatus >= http.StatusInternalServerError:
+		code = codes.Unavailable
+	case httpStatus == http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	headers := []*corev3.HeaderValueOption{headerValue("content-type", header.Get("Content-Type"))}
+	if httpStatus == http.StatusUnauthorized {
+		headers = append(headers, headerValue("www-authenticate", "Bearer"))
+	}
+	if retry := header.Get("Retry-After"); retry != "" {
+		headers = append(headers, headerValue("retry-after", retry))
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode(httpStatus)},
+				Headers: headers,
+				Body:    body,
+			},
+		},
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
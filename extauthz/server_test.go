@@ -0,0 +1,132 @@
+package extauthz
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"google.golang.org/grpc/codes"
+)
+
+func checkRequest(headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: headers},
+			},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ti := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch tokeninfo.AccessTokenFromRequest(r) {
+		case "good":
+			w.Write([]byte(`{"uid":"foo","realm":"/services","scope":["uid","cn"]}`))
+		case "broken":
+			w.Write([]byte(`{`))
+		case "down":
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		default:
+			tokeninfo.ErrInvalidToken.Write(w, r)
+		}
+	})
+	s := NewServer(ti)
+
+	for _, test := range []struct {
+		headers    map[string]string
+		wantCode   codes.Code
+		wantStatus int
+	}{
+		{nil, codes.Unauthenticated, http.StatusBadRequest},
+		{map[string]string{"authorization": "Basic Zm9vOmJhcg=="}, codes.Unauthenticated, http.StatusBadRequest},
+		{map[string]string{"authorization": "Bearer bad"}, codes.Unauthenticated, http.StatusUnauthorized},
+		{map[string]string{"authorization": "Bearer down"}, codes.Unavailable, http.StatusServiceUnavailable},
+		{map[string]string{"authorization": "Bearer broken"}, codes.Unavailable, http.StatusInternalServerError},
+		{map[string]string{"authorization": "Bearer good"}, codes.OK, 0},
+	} {
+		resp, err := s.Check(context.Background(), checkRequest(test.headers))
+		if err != nil {
+			t.Fatal("Check failed: ", err)
+		}
+		if codes.Code(resp.GetStatus().GetCode()) != test.wantCode {
+			t.Errorf("Wrong status for %v. Wanted %v, got %v", test.headers, test.wantCode, codes.Code(resp.GetStatus().GetCode()))
+		}
+		if test.wantCode == codes.OK {
+			continue
+		}
+		if int(resp.GetDeniedResponse().GetStatus().GetCode()) != test.wantStatus {
+			t.Errorf("Wrong HTTP status for %v. Wanted %d, got %v", test.headers, test.wantStatus, resp.GetDeniedResponse().GetStatus().GetCode())
+		}
+	}
+
+	resp, _ := s.Check(context.Background(), checkRequest(map[string]string{"authorization": "Bearer good"}))
+	got := make(map[string]string)
+	for _, h := range resp.GetOkResponse().GetHeaders() {
+		got[h.GetHeader().GetKey()] = h.GetHeader().GetValue()
+	}
+	want := map[string]string{HeaderUID: "foo", HeaderRealm: "/services", HeaderScope: "uid cn"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong headers for the upstream. Wanted %v, got %v", want, got)
+	}
+
+	resp, _ = s.Check(context.Background(), checkRequest(map[string]string{"authorization": "Bearer bad"}))
+	if !strings.Contains(resp.GetDeniedResponse().GetBody(), "invalid_token") {
+		t.Errorf("Denied response should have the token info error, got %q", resp.GetDeniedResponse().GetBody())
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	var remoteAddr string
+	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		w.Header().Set("Retry-After", "1")
+		tokeninfo.ErrRateLimited.Write(w, r)
+	}))
+	req := checkRequest(map[string]string{"authorization": "Bearer good"})
+	req.Attributes.Source = &authv3.AttributeContext_Peer{Address: &corev3.Address{Address: &corev3.Address_SocketAddress{
+		SocketAddress: &corev3.SocketAddress{Address: "10.0.0.1", PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 4711}},
+	}}}
+	resp, err := s.Check(context.Background(), req)
+	if err != nil {
+		t.Fatal("Check failed: ", err)
+	}
+	if remoteAddr != "10.0.0.1:4711" {
+		t.Errorf("The address of the client of Envoy should be the remote address, got %q", remoteAddr)
+	}
+	if codes.Code(resp.GetStatus().GetCode()) != codes.ResourceExhausted || resp.GetDeniedResponse().GetStatus().GetCode() != http.StatusTooManyRequests {
+		t.Errorf("Wrong status of a rate limited request: %v %v", codes.Code(resp.GetStatus().GetCode()), resp.GetDeniedResponse().GetStatus().GetCode())
+	}
+	retry := ""
+	for _, h := range resp.GetDeniedResponse().GetHeaders() {
+		if h.GetHeader().GetKey() == "retry-after" {
+			retry = h.GetHeader().GetValue()
+		}
+	}
+	if retry != "1" {
+		t.Errorf("The Retry-After header should be sent to the client, got %q", retry)
+	}
+}
+
+func TestHeaderMap(t *testing.T) {
+	req := &authv3.AttributeContext_HttpRequest{
+		HeaderMap: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{
+			{Key: "Authorization", RawValue: []byte("Bearer raw")},
+			{Key: "x-foo", Value: "bar"},
+		}},
+	}
+	if h := header(req, "authorization"); h != "Bearer raw" {
+		t.Errorf("Wrong raw header value. Got %q", h)
+	}
+	if h := header(req, "x-foo"); h != "bar" {
+		t.Errorf("Wrong header value. Got %q", h)
+	}
+	if h := header(req, "x-missing"); h != "" {
+		t.Errorf("Missing header should be empty. Got %q", h)
+	}
+}
@@ -0,0 +1,128 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+// responses bigger than this are not inspected for the realm, uid or error fields
+const maxInspectedBody = 64 * 1024
+
+type handler struct {
+	next       http.Handler
+	out        io.Writer
+	sampleRate float64
+	mu         sync.Mutex
+}
+
+// Entry is the structure of one access log line
+type Entry struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"request_id,omitempty"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	ClientIP  string  `json:"client_ip,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Cache     string  `json:"cache,omitempty"`
+	TokenHash string  `json:"token_hash,omitempty"`
+	Realm     string  `json:"realm,omitempty"`
+	UID       string  `json:"uid,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+var sample = rand.Float64
+
+// NewHandler returns an http.Handler that writes one JSON line per request to out after calling next.
+// Only a sampleRate fraction (between 0 and 1) of the requests is logged
+func NewHandler(next http.Handler, out io.Writer, sampleRate float64) http.Handler {
+	return &handler{next: next, out: out, sampleRate: sampleRate}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(rw, r)
+
+	if h.sampleRate < 1 && sample() >= h.sampleRate {
+		return
+	}
+
+	e := &Entry{
+		Time:      start.UTC().Format(time.RFC3339Nano),
+		RequestID: rw.Header().Get(requestid.Header),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		ClientIP:  clientip.FromRequest(r),
+		Status:    rw.status,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+		Cache:     rw.Header().Get("X-Cache"),
+	}
+	// the next handler already parsed the form, so the body is not read again here
+	if token := tokeninfo.AccessTokenFromRequest(r); token != "" {
+		e.TokenHash = tokeninfo.HashPrefix(token)
+	}
+	rw.inspect(e)
+	h.write(e)
+}
+
+func (h *handler) write(e *Entry) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		slog.Warn("Failed to serialize the access log entry", "error", err)
+		return
+	}
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.out.Write(buf); err != nil {
+		slog.Warn("Failed to write the access log entry", "error", err)
+	}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.body.Len()+len(b) <= maxInspectedBody {
+		rw.body.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// inspect fills the realm and uid of successful responses and the error of failed ones
+func (rw *responseWriter) inspect(e *Entry) {
+	var body struct {
+		Realm string `json:"realm"`
+		UID   string `json:"uid"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(rw.body.Bytes(), &body) != nil {
+		return
+	}
+	if rw.status == http.StatusOK {
+		e.Realm = body.Realm
+		e.UID = body.UID
+	} else {
+		e.Error = body.Error
+	}
+}
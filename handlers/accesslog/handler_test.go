@@ -0,0 +1,104 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+func TestHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch tokeninfo.AccessTokenFromRequest(r) {
+		case "good":
+			w.Header().Set("X-Cache", "HIT")
+			w.Write([]byte(`{"uid":"foo","realm":"/services","scope":["uid"]}`))
+		default:
+			tokeninfo.ErrInvalidToken.Write(w, r)
+		}
+	})
+
+	for _, test := range []struct {
+		url  string
+		want Entry
+	}{
+		{"http://example.com/oauth2/tokeninfo?access_token=good", Entry{Method: "GET", Path: "/oauth2/tokeninfo", ClientIP: "192.0.2.1", Status: http.StatusOK, Cache: "HIT", TokenHash: tokeninfo.HashToken("good")[:12], Realm: "/services", UID: "foo"}},
+		{"http://example.com/oauth2/tokeninfo?access_token=bad", Entry{Method: "GET", Path: "/oauth2/tokeninfo", ClientIP: "192.0.2.1", Status: http.StatusUnauthorized, TokenHash: tokeninfo.HashToken("bad")[:12], Error: "invalid_token"}},
+		{"http://example.com/oauth2/tokeninfo", Entry{Method: "GET", Path: "/oauth2/tokeninfo", ClientIP: "192.0.2.1", Status: http.StatusUnauthorized, Error: "invalid_token"}},
+	} {
+		out := new(bytes.Buffer)
+		h := NewHandler(next, out, 1)
+		req, _ := http.NewRequest("GET", test.url, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !strings.HasSuffix(out.String(), "}\n") || strings.Count(out.String(), "\n") != 1 {
+			t.Errorf("Access log should be a single JSON line, got %q", out.String())
+		}
+
+		var e Entry
+		if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+			t.Fatal("Failed to parse the access log entry: ", err)
+		}
+		if e.Time == "" || e.LatencyMs < 0 {
+			t.Errorf("Missing time or latency in %+v", e)
+		}
+		e.Time, e.LatencyMs = "", 0
+		if e != test.want {
+			t.Errorf("Wrong access log entry. Wanted %+v, got %+v", test.want, e)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	defer func(f func() float64) { sample = f }(sample)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range []struct {
+		rate    float64
+		sampled float64
+		logged  bool
+	}{
+		{1, 0.99, true},
+		{0.5, 0.2, true},
+		{0.5, 0.7, false},
+		{0, 0, false},
+	} {
+		sample = func() float64 { return test.sampled }
+		out := new(bytes.Buffer)
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		NewHandler(next, out, test.rate).ServeHTTP(httptest.NewRecorder(), req)
+
+		if logged := out.Len() > 0; logged != test.logged {
+			t.Errorf("Wrong sampling for rate %v and sample %v. Wanted logged %t, got %t", test.rate, test.sampled, test.logged, logged)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokeninfo.ErrInvalidToken.Write(w, r)
+	})
+	out := new(bytes.Buffer)
+	h := requestid.NewHandler(NewHandler(next, out, 1))
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	var e Entry
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatal("Failed to parse the access log entry: ", err)
+	}
+	if e.RequestID != "abc-123" {
+		t.Errorf("Wrong request ID in the access log. Wanted abc-123, got %q", e.RequestID)
+	}
+	if !strings.Contains(rw.Body.String(), `"request_id":"abc-123"`) {
+		t.Errorf("Error response should contain the request ID, got %s", rw.Body.String())
+	}
+}
@@ -0,0 +1,298 @@
+// Package admin implements the administration API, served on its own listen address. Every call
+// requires HTTP Basic authentication with one of the configured admin users
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/handlers/userinfo"
+	"github.com/zalando/planb-tokeninfo/logging"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+const (
+	cachePath        = "/admin/cache"
+	globalCutOffPath = "/admin/revocations/global"
+	configPath       = "/admin/config"
+	settingsPath     = "/admin/settings"
+	logLevelPath     = "/admin/loglevel"
+)
+
+type adminHandler struct {
+	cache tokencache.Cache
+	crp   *revoke.CachingRevokeProvider
+	users map[string]string
+	mux   *http.ServeMux
+}
+
+// logLevel is the body of the log level calls
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// globalCutOff is the body of the global revocation calls, with a Unix timestamp
+type globalCutOff struct {
+	IssuedBefore int `json:"issued_before"`
+}
+
+// NewHandler returns an http.Handler for the admin API. Callers must authenticate with one of the
+// user/password pairs in users; without users every call is rejected. The following operations are
+// available on the upstream token info cache, which may be nil when there is no upstream:
+//
+//	GET    /admin/cache/stats    cache size, hit ratio and age of the oldest entry
+//	DELETE /admin/cache          purge all entries
+//	DELETE /admin/cache/{hash}   evict the entries of a single token by its SHA-256 hash
+//
+// and on the revocations of crp:
+//
+//	GET    /admin/revocations/global   the time before which all tokens were issued are revoked
+//	PUT    /admin/revocations/global   revoke all tokens issued before a time, now by default
+//
+// The effective settings, after a reload the current ones, with the secrets redacted:
+//
+//	GET    /admin/config
+//
+// and the options that can be changed at runtime, until the next reload:
+//
+//	GET    /admin/settings   their current values
+//	PATCH  /admin/settings   change some of them, for ex. {"UPSTREAM_CACHE_TTL": "10m"}
+//	GET    /admin/loglevel   the level of the logger
+//	PUT    /admin/loglevel   change it to debug, info, warn or error, for ex. {"level": "debug"}
+func NewHandler(cache tokencache.Cache, crp *revoke.CachingRevokeProvider, users map[string]string) http.Handler {
+	h := &adminHandler{cache: cache, crp: crp, users: users, mux: http.NewServeMux()}
+	h.mux.HandleFunc(cachePath+"/stats", h.cacheStats)
+	h.mux.HandleFunc(cachePath, h.purgeCache)
+	h.mux.HandleFunc(cachePath+"/", h.evictToken)
+	h.mux.HandleFunc(globalCutOffPath, h.globalCutOff)
+	h.mux.HandleFunc(configPath, h.config)
+	h.mux.HandleFunc(settingsPath, h.settings)
+	h.mux.HandleFunc(logLevelPath, h.logLevel)
+	return h
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		incCounter("planb.admin.unauthorized")
+		return
+	}
+	slog.Info("Admin call", "method", req.Method, "path", req.URL.Path, "user", user)
+	h.mux.ServeHTTP(w, req)
+}
+
+func (h *adminHandler) authenticate(req *http.Request) (string, bool) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	return user, tokeninfo.CheckSecret(h.users, user, password)
+}
+
+func (h *adminHandler) cacheStats(w http.ResponseWriter, req *http.Request) {
+	if !h.allow(w, req, http.MethodGet) {
+		return
+	}
+	s, err := h.cache.Stats()
+	if err != nil {
+		slog.Warn("Failed to get the cache stats", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		slog.Warn("Failed to finish cache stats response", "error", err)
+	}
+}
+
+func (h *adminHandler) purgeCache(w http.ResponseWriter, req *http.Request) {
+	if !h.allow(w, req, http.MethodDelete) {
+		return
+	}
+	if err := h.cache.Purge(); err != nil {
+		slog.Warn("Failed to purge the cache", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Purged the upstream token info cache")
+	incCounter("planb.admin.cache.purges")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *adminHandler) evictToken(w http.ResponseWriter, req *http.Request) {
+	if !h.allow(w, req, http.MethodDelete) {
+		return
+	}
+	hash := strings.TrimPrefix(req.URL.Path, cachePath+"/")
+	if !validHash(hash) {
+		http.Error(w, "Invalid token hash", http.StatusBadRequest)
+		return
+	}
+	// with the variants of the responses for the callers with their own scope policy and the UserInfo response
+	keys := []string{userinfo.CacheKey(hash)}
+	for _, variant := range append([]string{""}, options.Current().ScopePolicy.Variants()...) {
+		keys = append(keys, tokeninfo.VariantKey(hash, variant))
+	}
+	for _, key := range keys {
+		if err := h.cache.Delete(key); err != nil {
+			slog.Warn("Failed to evict token from the cache", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	slog.Info("Evicted token from the upstream token info cache", "hash", hash)
+	incCounter("planb.admin.cache.evictions")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *adminHandler) globalCutOff(w http.ResponseWriter, req *http.Request) {
+	if h.crp == nil {
+		http.Error(w, "No revocations", http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(globalCutOff{IssuedBefore: h.crp.IssuedBefore()}); err != nil {
+			slog.Warn("Failed to finish global revocation response", "error", err)
+		}
+	case http.MethodPut:
+		c := globalCutOff{IssuedBefore: int(time.Now().Unix())}
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1024)).Decode(&c); err != nil && err != io.EOF {
+			http.Error(w, "Invalid global revocation", http.StatusBadRequest)
+			return
+		}
+		if err := h.crp.RevokeIssuedBefore(c.IssuedBefore); err != nil {
+			http.Error(w, "Invalid global revocation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// the cached upstream responses don't tell when their tokens were issued
+		if h.cache != nil {
+			if err := h.cache.Purge(); err != nil {
+				slog.Warn("Failed to purge the cache", "error", err)
+			}
+		}
+		slog.Info("Revoked all tokens issued before a time", "issued_before", c.IssuedBefore)
+		incCounter("planb.admin.revocations.global")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *adminHandler) config(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(options.Current().Redacted()); err != nil {
+		slog.Warn("Failed to finish config response", "error", err)
+	}
+}
+
+func (h *adminHandler) settings(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		values, err := options.DecodeValues(http.MaxBytesReader(w, req.Body, 4096))
+		if err != nil {
+			http.Error(w, "Invalid settings", http.StatusBadRequest)
+			return
+		}
+		previous, err := options.Change(values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		user, _, _ := req.BasicAuth()
+		current := options.RuntimeValues()
+		for name, value := range previous {
+			slog.Info("Admin changed a setting", "user", user, "setting", name, "from", value, "to", current[name])
+		}
+		incCounter("planb.admin.settings.changes")
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(options.RuntimeValues()); err != nil {
+		slog.Warn("Failed to finish settings response", "error", err)
+	}
+}
+
+func (h *adminHandler) logLevel(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var l logLevel
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1024)).Decode(&l); err != nil {
+			http.Error(w, "Invalid log level", http.StatusBadRequest)
+			return
+		}
+		// as a setting, so that the config shows it
+		if _, err := options.Change(map[string]string{"LOG_LEVEL": l.Level}); err != nil {
+			http.Error(w, "Invalid log level: "+l.Level, http.StatusBadRequest)
+			return
+		}
+		user, _, _ := req.BasicAuth()
+		slog.Info("Admin changed the log level", "user", user, "level", l.Level)
+		incCounter("planb.admin.settings.changes")
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(logLevel{Level: logging.LevelName(options.Current().LogLevel)}); err != nil {
+		slog.Warn("Failed to finish log level response", "error", err)
+	}
+}
+
+// allow checks the request method and that there is a cache to work with
+func (h *adminHandler) allow(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	if h.cache == nil {
+		http.Error(w, "No upstream token info cache", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// validHash reports whether s is a hex encoded SHA-256 hash, as returned by tokeninfo.HashToken
+func validHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
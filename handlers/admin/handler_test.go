@@ -0,0 +1,282 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/handlers/userinfo"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/processor"
+	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+const fooHash = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestAuthentication(t *testing.T) {
+	users := map[string]string{"admin": "secret"}
+	for _, test := range []struct {
+		users    map[string]string
+		user     string
+		password string
+		wantCode int
+	}{
+		{users, "admin", "secret", http.StatusOK},
+		{users, "admin", "wrong", http.StatusUnauthorized},
+		{users, "other", "secret", http.StatusUnauthorized},
+		{users, "", "", http.StatusUnauthorized},
+		{nil, "admin", "secret", http.StatusUnauthorized},
+	} {
+		h := NewHandler(tokencache.NewMemoryCache(10), nil, test.users)
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/admin/cache/stats", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		h.ServeHTTP(rw, r)
+		if rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %q/%q. Wanted %d, got %d", test.user, test.password, test.wantCode, rw.Code)
+		}
+	}
+}
+
+func TestCacheOperations(t *testing.T) {
+	c := tokencache.NewMemoryCache(10)
+	c.Set(fooHash, []byte(`{"uid":"foo"}`), time.Minute)
+	c.Set("bar", []byte(`{"uid":"bar"}`), time.Minute)
+	c.Get(fooHash)
+	h := NewHandler(c, nil, map[string]string{"admin": "secret"})
+
+	call := func(method string, path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://example.com"+path, nil)
+		r.SetBasicAuth("admin", "secret")
+		h.ServeHTTP(rw, r)
+		return rw
+	}
+
+	rw := call("GET", "/admin/cache/stats")
+	var s tokencache.Stats
+	if err := json.NewDecoder(rw.Body).Decode(&s); err != nil {
+		t.Fatal("Failed to decode the stats: ", err)
+	}
+	if rw.Code != http.StatusOK || s.Size != 2 || s.Hits != 1 || s.HitRatio != 1 {
+		t.Errorf("Wrong stats response: %d %+v", rw.Code, s)
+	}
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		wantCode int
+	}{
+		{"POST", "/admin/cache/stats", http.StatusMethodNotAllowed},
+		{"GET", "/admin/cache/" + fooHash, http.StatusMethodNotAllowed},
+		{"DELETE", "/admin/cache/foo", http.StatusBadRequest},
+		{"DELETE", "/admin/cache/" + strings.ToUpper(fooHash), http.StatusBadRequest},
+		{"DELETE", "/admin/cache/" + fooHash, http.StatusNoContent},
+		{"GET", "/admin/other", http.StatusNotFound},
+	} {
+		if rw := call(test.method, test.path); rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s %s. Wanted %d, got %d", test.method, test.path, test.wantCode, rw.Code)
+		}
+	}
+	if _, err := c.Get(fooHash); err != tokencache.ErrNotFound {
+		t.Error("Token should have been evicted")
+	}
+	if _, err := c.Get("bar"); err != nil {
+		t.Error("Other tokens should still be cached: ", err)
+	}
+
+	if rw := call("DELETE", "/admin/cache"); rw.Code != http.StatusNoContent {
+		t.Errorf("Wrong status code for the purge: %d", rw.Code)
+	}
+	if _, err := c.Get("bar"); err != tokencache.ErrNotFound {
+		t.Error("Cache should have been purged")
+	}
+}
+
+func TestEvictVariants(t *testing.T) {
+	p, err := processor.LoadScopePolicy(strings.NewReader(`{"callers": {"billing": {"allow": ["uid"]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p *processor.ScopePolicy) { options.Current().ScopePolicy = p }(options.Current().ScopePolicy)
+	options.Current().ScopePolicy = p
+
+	c := tokencache.NewMemoryCache(10)
+	c.Set(fooHash, []byte(`{"uid":"foo"}`), time.Minute)
+	c.Set(fooHash+"/billing", []byte(`{"uid":"foo"}`), time.Minute)
+	c.Set(userinfo.CacheKey(fooHash), []byte(`{"sub":"foo"}`), time.Minute)
+	h := NewHandler(c, nil, map[string]string{"admin": "secret"})
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("DELETE", "http://example.com/admin/cache/"+fooHash, nil)
+	r.SetBasicAuth("admin", "secret")
+	h.ServeHTTP(rw, r)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("Wrong status code for the eviction: %d", rw.Code)
+	}
+	for _, key := range []string{fooHash, fooHash + "/billing", userinfo.CacheKey(fooHash)} {
+		if _, err := c.Get(key); err != tokencache.ErrNotFound {
+			t.Errorf("Entry %s should have been evicted", key)
+		}
+	}
+}
+
+func TestWithoutCache(t *testing.T) {
+	h := NewHandler(nil, nil, map[string]string{"admin": "secret"})
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("DELETE", "http://example.com/admin/cache", nil)
+	r.SetBasicAuth("admin", "secret")
+	h.ServeHTTP(rw, r)
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code without a cache. Wanted %d, got %d", http.StatusNotFound, rw.Code)
+	}
+}
+
+func TestGlobalRevocation(t *testing.T) {
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+	c := tokencache.NewMemoryCache(10)
+	c.Set(fooHash, []byte(`{"uid":"foo"}`), time.Minute)
+	h := NewHandler(c, crp, map[string]string{"admin": "secret"})
+
+	call := func(method string, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://example.com/admin/revocations/global", strings.NewReader(body))
+		r.SetBasicAuth("admin", "secret")
+		h.ServeHTTP(rw, r)
+		return rw
+	}
+
+	if rw := call("GET", ""); rw.Body.String() != `{"issued_before":0}`+"\n" {
+		t.Errorf("Wrong global revocation without one: %s", rw.Body.String())
+	}
+	if rw := call("PUT", `{"issued_before":100000}`); rw.Code != http.StatusNoContent {
+		t.Errorf("Wrong status code for the global revocation: %d", rw.Code)
+	}
+	if rw := call("GET", ""); rw.Body.String() != `{"issued_before":100000}`+"\n" {
+		t.Errorf("Wrong global revocation: %s", rw.Body.String())
+	}
+	if _, err := c.Get(fooHash); err != tokencache.ErrNotFound {
+		t.Error("Cache should have been purged")
+	}
+	for _, body := range []string{`{"issued_before":`, fmt.Sprintf(`{"issued_before":%d}`, time.Now().Add(time.Hour).Unix())} {
+		if rw := call("PUT", body); rw.Code != http.StatusBadRequest {
+			t.Errorf("Wrong status code for an invalid global revocation %s: %d", body, rw.Code)
+		}
+	}
+
+	before := time.Now().Unix()
+	call("PUT", "")
+	if ts := int64(crp.IssuedBefore()); ts < before {
+		t.Errorf("Global revocation should default to now. Wanted at least %d, got %d", before, ts)
+	}
+	if rw := call("DELETE", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status code for DELETE: %d", rw.Code)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	defer options.Replace(options.Replace(&options.Settings{ListenAddress: ":9021", AdminUsers: map[string]string{"admin": "secret"}, UpstreamTimeout: time.Second}))
+	h := NewHandler(nil, nil, map[string]string{"admin": "secret"})
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/admin/config", nil)
+	r.SetBasicAuth("admin", "secret")
+	h.ServeHTTP(rw, r)
+	var config map[string]interface{}
+	if err := json.NewDecoder(rw.Body).Decode(&config); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Failed to get the config: %d %v", rw.Code, err)
+	}
+	if config["ListenAddress"] != ":9021" || config["UpstreamTimeout"] != "1s" {
+		t.Errorf("Wrong config: %v", config)
+	}
+	if users, _ := config["AdminUsers"].(map[string]interface{}); users["admin"] == "secret" {
+		t.Error("The passwords should be redacted")
+	}
+
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("PUT", "http://example.com/admin/config", nil)
+	r.SetBasicAuth("admin", "secret")
+	h.ServeHTTP(rw, r)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status code for PUT. Wanted %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	defer options.Replace(options.Replace(&options.Settings{UpstreamCacheTTL: time.Minute, UpstreamTimeout: time.Second, UpstreamRetries: 1}))
+	h := NewHandler(nil, nil, map[string]string{"admin": "secret"})
+
+	call := func(method string, path string, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "secret")
+		h.ServeHTTP(rw, r)
+		return rw
+	}
+
+	for _, test := range []struct {
+		body     string
+		wantCode int
+	}{
+		{`{"UPSTREAM_CACHE_TTL": "10m", "UPSTREAM_RETRIES": 3}`, http.StatusOK},
+		{`{"UPSTREAM_TIMEOUT": "-1s"}`, http.StatusBadRequest},
+		{`{"LISTEN_ADDRESS": ":8080"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	} {
+		if rw := call("PATCH", "/admin/settings", test.body); rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d", test.body, test.wantCode, rw.Code)
+		}
+	}
+	if s := options.Current(); s.UpstreamCacheTTL != 10*time.Minute || s.UpstreamRetries != 3 || s.UpstreamTimeout != time.Second {
+		t.Errorf("Wrong settings after the changes: %v %d %v", s.UpstreamCacheTTL, s.UpstreamRetries, s.UpstreamTimeout)
+	}
+
+	rw := call("GET", "/admin/config", "")
+	var config map[string]interface{}
+	if err := json.NewDecoder(rw.Body).Decode(&config); err != nil || config["UpstreamCacheTTL"] != "10m0s" {
+		t.Errorf("The config should have the changed settings: %v %v", err, config["UpstreamCacheTTL"])
+	}
+	if rw := call("DELETE", "/admin/settings", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status code for DELETE. Wanted %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	defer options.Replace(options.Replace(&options.Settings{}))
+	h := NewHandler(nil, nil, map[string]string{"admin": "secret"})
+
+	call := func(method string, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://example.com/admin/loglevel", strings.NewReader(body))
+		r.SetBasicAuth("admin", "secret")
+		h.ServeHTTP(rw, r)
+		return rw
+	}
+
+	if rw := call("GET", ""); rw.Code != http.StatusOK || rw.Body.String() != "{\"level\":\"info\"}\n" {
+		t.Errorf("Wrong log level response: %d %s", rw.Code, rw.Body)
+	}
+	if rw := call("PUT", `{"level": "debug"}`); rw.Code != http.StatusOK || rw.Body.String() != "{\"level\":\"debug\"}\n" {
+		t.Errorf("Wrong response for the change: %d %s", rw.Code, rw.Body)
+	}
+	if options.Current().LogLevel != slog.LevelDebug {
+		t.Errorf("The log level setting should be changed, got %v", options.Current().LogLevel)
+	}
+	for _, body := range []string{`{"level": "trace"}`, `{}`, `debug`} {
+		if rw := call("PUT", body); rw.Code != http.StatusBadRequest {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d", body, http.StatusBadRequest, rw.Code)
+		}
+	}
+	if rw := call("POST", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status code for POST. Wanted %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+}
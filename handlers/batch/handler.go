@@ -0,0 +1,106 @@
+// Package batch implements an endpoint to validate many tokens with a single request
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+const (
+	// tokens of a batch are validated concurrently by this many workers
+	workers = 16
+	// requests with a bigger body are rejected before decoding
+	maxBodySize = 10 << 20
+)
+
+type batchHandler struct {
+	tokenInfo http.Handler
+	maxTokens int
+}
+
+// Result is the outcome of the validation of one token of the batch. TokenInfo is the Token Info
+// response for valid tokens, Error the error response otherwise
+type Result struct {
+	Status    int             `json:"status"`
+	TokenInfo json.RawMessage `json:"token_info,omitempty"`
+	Error     json.RawMessage `json:"error,omitempty"`
+}
+
+// NewHandler returns an http.Handler that accepts a JSON array of tokens in a POST request and responds
+// with a JSON array of Results in the same order. Tokens are validated by the tokenInfo http.Handler, so
+// the JWT validation and the upstream cache apply. Batches with more than maxTokens tokens are rejected
+func NewHandler(tokenInfo http.Handler, maxTokens int) http.Handler {
+	return &batchHandler{tokenInfo: tokenInfo, maxTokens: maxTokens}
+}
+
+// ServeHTTP validates all the tokens of the batch and sends back the results
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tokens []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodySize)).Decode(&tokens); err != nil {
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+		return
+	}
+	if len(tokens) > h.maxTokens {
+		tokeninfo.ErrTooManyTokens.Write(w, req)
+		return
+	}
+	incCounter("planb.tokeninfo.batch.requests", 1)
+	incCounter("planb.tokeninfo.batch.tokens", int64(len(tokens)))
+
+	results := make([]Result, len(tokens))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(tokens); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.validate(req, tokens[i])
+			}
+		}()
+	}
+	for i := range tokens {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		slog.Warn("Failed to finish batch response", "error", err)
+	}
+}
+
+// validate sends a single token to the Token Info handlers
+func (h *batchHandler) validate(req *http.Request, token string) Result {
+	rec := tokeninfo.NewResponseRecorder()
+	h.tokenInfo.ServeHTTP(rec, tokeninfo.NewTokenInfoRequest(req, token))
+
+	body := bytes.TrimSpace(rec.Body.Bytes())
+	if !json.Valid(body) {
+		body, _ = json.Marshal(map[string]string{"error": http.StatusText(rec.Status)})
+	}
+	if rec.Status == http.StatusOK {
+		return Result{Status: rec.Status, TokenInfo: body}
+	}
+	return Result{Status: rec.Status, Error: body}
+}
+
+func incCounter(key string, n int64) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(n)
+	}
+}
@@ -0,0 +1,108 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/handlers/ratelimit"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+// tokenInfoHandler accepts tokens starting with "valid" and rejects the rest
+var tokenInfoHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	token := tokeninfo.AccessTokenFromRequest(req)
+	switch {
+	case token == "":
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+	case strings.HasPrefix(token, "valid"):
+		fmt.Fprintf(w, `{"uid":%q}`, token)
+	case token == "broken":
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Internal Server Error"))
+	default:
+		tokeninfo.ErrInvalidToken.Write(w, req)
+	}
+})
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(tokenInfoHandler, 100)
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo/batch", strings.NewReader(`["valid1", "foo", "", "valid2", "broken"]`))
+	h.ServeHTTP(rw, r)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Wrong status code. Wanted %d, got %d", http.StatusOK, rw.Code)
+	}
+	var results []Result
+	if err := json.NewDecoder(rw.Body).Decode(&results); err != nil {
+		t.Fatal("Failed to decode the batch response: ", err)
+	}
+
+	want := []struct {
+		status    int
+		tokenInfo string
+		err       string
+	}{
+		{200, `{"uid":"valid1"}`, ""},
+		{401, "", `{"error":"invalid_token","error_description":"Access Token not valid"}`},
+		{400, "", `{"error":"invalid_request","error_description":"Access Token not valid"}`},
+		{200, `{"uid":"valid2"}`, ""},
+		{500, "", `{"error":"Internal Server Error"}`},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Wrong number of results. Wanted %d, got %d", len(want), len(results))
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || string(results[i].TokenInfo) != w.tokenInfo || string(results[i].Error) != w.err {
+			t.Errorf("Wrong result %d. Wanted %d %s %s, got %d %s %s", i, w.status, w.tokenInfo, w.err,
+				results[i].Status, results[i].TokenInfo, results[i].Error)
+		}
+	}
+}
+
+func TestInvalidRequests(t *testing.T) {
+	h := NewHandler(tokenInfoHandler, 2)
+	for _, test := range []struct {
+		method   string
+		body     string
+		wantCode int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "", http.StatusBadRequest},
+		{"POST", `{"tokens": ["valid"]}`, http.StatusBadRequest},
+		{"POST", `["valid", "valid", "valid"]`, http.StatusRequestEntityTooLarge},
+		{"POST", `[]`, http.StatusOK},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://example.com/oauth2/tokeninfo/batch", strings.NewReader(test.body))
+		h.ServeHTTP(rw, r)
+		if rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s %q. Wanted %d, got %d", test.method, test.body, test.wantCode, rw.Code)
+		}
+	}
+}
+
+func TestRateLimitPerToken(t *testing.T) {
+	h := NewHandler(ratelimit.NewHandler(tokenInfoHandler, ratelimit.Limits{PerIP: 2}), 100)
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo/batch", strings.NewReader(`["valid1", "valid2", "valid3", "valid4"]`))
+	h.ServeHTTP(rw, r)
+
+	var results []Result
+	if err := json.NewDecoder(rw.Body).Decode(&results); err != nil {
+		t.Fatal("Failed to decode the batch response: ", err)
+	}
+	var limited int
+	for _, r := range results {
+		if r.Status == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	if len(results) != 4 || limited != 2 {
+		t.Errorf("Every token of the batch should be charged to the rate limit. Wanted 2 rejected tokens, got %d: %v", limited, results)
+	}
+}
@@ -0,0 +1,95 @@
+// Package callerauth restricts the token validation endpoints to known callers, identified either by a
+// static API key or by the subject of a verified TLS client certificate. Stolen tokens can't be checked
+// by anyone who can reach the service
+package callerauth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+// APIKeyHeader is the request header with the API key of the caller
+const APIKeyHeader = "X-Api-Key"
+
+// Callers are the allowed callers. APIKeys maps caller names to their API keys. CertSubjects are the subject
+// common names of the allowed client certificates, which are also the caller names. Client certificates
+// must be verified by the TLS server
+type Callers struct {
+	APIKeys      map[string]string
+	CertSubjects []string
+}
+
+type contextKey struct{}
+
+type handler struct {
+	next     http.Handler
+	apiKeys  map[string]string
+	subjects map[string]bool
+}
+
+// NewHandler returns an http.Handler that calls next for the requests of the callers and rejects all other
+// requests with status 401. The requests of every caller are counted and the caller is in the Request context,
+// see FromContext
+func NewHandler(next http.Handler, callers Callers) http.Handler {
+	h := &handler{next: next, apiKeys: callers.APIKeys, subjects: make(map[string]bool)}
+	for _, s := range callers.CertSubjects {
+		h.subjects[s] = true
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	caller, ok := h.certCaller(req)
+	if !ok {
+		caller, ok = h.keyCaller(req)
+	}
+	if !ok {
+		incCounter("planb.tokeninfo.callers.unauthorized")
+		tokeninfo.ErrInvalidClient.Write(w, req)
+		return
+	}
+	incCounter("planb.tokeninfo.callers." + caller)
+	// the key of the caller is not forwarded to the upstream token info
+	req.Header.Del(APIKeyHeader)
+	h.next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, caller)))
+}
+
+// FromContext returns the name of the caller in ctx, or an empty string without caller authentication
+func FromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(contextKey{}).(string)
+	return caller
+}
+
+// certCaller returns the subject common name of the verified client certificate, if it is allowed
+func (h *handler) certCaller(req *http.Request) (string, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+	return cn, cn != "" && h.subjects[cn]
+}
+
+// keyCaller returns the name of the caller with the API key of the request. All keys are compared in constant
+// time, so that the time taken doesn't tell which one almost matched or how long the keys are
+func (h *handler) keyCaller(req *http.Request) (string, bool) {
+	key := req.Header.Get(APIKeyHeader)
+	if key == "" {
+		return "", false
+	}
+	var caller string
+	for name, k := range h.apiKeys {
+		if tokeninfo.SecretEqual(k, key) {
+			caller = name
+		}
+	}
+	return caller, caller != ""
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
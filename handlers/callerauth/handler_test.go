@@ -0,0 +1,71 @@
+package callerauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHandler(t *testing.T) {
+	var forwardedKey string
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwardedKey = req.Header.Get(APIKeyHeader)
+	})
+	h := NewHandler(next, Callers{
+		APIKeys:      map[string]string{"frontend": "secret1", "batch-job": "secret2"},
+		CertSubjects: []string{"app", "other"},
+	})
+	cert := func(subject pkix.Name) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+	}
+
+	for _, test := range []struct {
+		key        string
+		tls        *tls.ConnectionState
+		wantCode   int
+		wantCaller string
+	}{
+		{"", nil, http.StatusUnauthorized, ""},
+		{"wrong", nil, http.StatusUnauthorized, ""},
+		{"secret", nil, http.StatusUnauthorized, ""},
+		{"secret12", nil, http.StatusUnauthorized, ""},
+		{"secret1", nil, http.StatusOK, "frontend"},
+		{"secret2", nil, http.StatusOK, "batch-job"},
+		{"", cert(pkix.Name{CommonName: "app"}), http.StatusOK, "app"},
+		{"", cert(pkix.Name{CommonName: "other", Organization: []string{"Example"}}), http.StatusOK, "other"},
+		{"", cert(pkix.Name{Organization: []string{"Example"}}), http.StatusUnauthorized, ""},
+		{"", cert(pkix.Name{CommonName: "unknown"}), http.StatusUnauthorized, ""},
+		{"secret1", cert(pkix.Name{CommonName: "unknown"}), http.StatusOK, "frontend"},
+		{"", &tls.ConnectionState{}, http.StatusUnauthorized, ""},
+	} {
+		var before int64
+		if test.wantCaller != "" {
+			before = metrics.GetOrRegisterCounter("planb.tokeninfo.callers."+test.wantCaller, metrics.DefaultRegistry).Count()
+		}
+		forwardedKey = ""
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+		if test.key != "" {
+			r.Header.Set(APIKeyHeader, test.key)
+		}
+		r.TLS = test.tls
+		h.ServeHTTP(rw, r)
+
+		if rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for key %q. Wanted %d, got %d", test.key, test.wantCode, rw.Code)
+		}
+		if forwardedKey != "" {
+			t.Errorf("API key should not be forwarded, got %q", forwardedKey)
+		}
+		if test.wantCaller != "" {
+			if n := metrics.GetOrRegisterCounter("planb.tokeninfo.callers."+test.wantCaller, metrics.DefaultRegistry).Count(); n != before+1 {
+				t.Errorf("Requests of %s should be counted", test.wantCaller)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Package chaos injects faults into the token validation responses, so that the consumers can test how they
+// handle a slow or failing token info without mocks of their own. It's for test environments only
+package chaos
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Header tells the clients which fault was injected into a response
+const Header = "X-Chaos-Fault"
+
+// Faults are the percentages of the requests that get each fault. Latency delays the response, errors are
+// answered with ErrorStatus instead of the token info and malformed responses are cut in the middle
+type Faults struct {
+	Latency             time.Duration
+	LatencyPercentage   int
+	ErrorPercentage     int
+	ErrorStatus         int
+	MalformedPercentage int
+}
+
+// Injector holds the Faults to inject. They can be replaced while requests are served
+type Injector struct {
+	faults atomic.Pointer[Faults]
+}
+
+// NewInjector returns an Injector for the faults f
+func NewInjector(f Faults) *Injector {
+	i := new(Injector)
+	i.Set(f)
+	return i
+}
+
+// Set replaces the faults of the Injector
+func (i *Injector) Set(f Faults) {
+	i.faults.Store(&f)
+}
+
+type handler struct {
+	next     http.Handler
+	injector *Injector
+}
+
+var roll = func() int { return rand.Intn(100) }
+
+// NewHandler returns an http.Handler that injects the faults of the injector into the responses of next.
+// The latency is added to the other faults, a request gets either an error or a malformed response
+func NewHandler(next http.Handler, injector *Injector) http.Handler {
+	return &handler{next: next, injector: injector}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f := h.injector.faults.Load()
+	if f.Latency > 0 && roll() < f.LatencyPercentage {
+		incCounter("planb.chaos.latency")
+		w.Header().Add(Header, "latency")
+		t := time.NewTimer(f.Latency)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+			return
+		}
+	}
+	if roll() < f.ErrorPercentage {
+		incCounter("planb.chaos.errors")
+		w.Header().Add(Header, "error")
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.WriteHeader(f.ErrorStatus)
+		w.Write([]byte(http.StatusText(f.ErrorStatus)))
+		return
+	}
+	if roll() < f.MalformedPercentage {
+		incCounter("planb.chaos.malformed")
+		w.Header().Add(Header, "malformed")
+		h.truncated(w, req)
+		return
+	}
+	h.next.ServeHTTP(w, req)
+}
+
+// truncated sends the response of next with the status and headers, but only the first half of the body
+func (h *handler) truncated(w http.ResponseWriter, req *http.Request) {
+	buf := &responseBuffer{header: w.Header(), status: http.StatusOK}
+	h.next.ServeHTTP(buf, req)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(buf.status)
+	w.Write(buf.body.Bytes()[:buf.body.Len()/2])
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+// responseBuffer keeps the status and body of a response, its headers are the ones of the real response
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (rw *responseBuffer) Header() http.Header {
+	return rw.header
+}
+
+func (rw *responseBuffer) WriteHeader(status int) {
+	rw.status = status
+}
+
+func (rw *responseBuffer) Write(b []byte) (int, error) {
+	return rw.body.Write(b)
+}
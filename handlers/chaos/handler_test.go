@@ -0,0 +1,79 @@
+package chaos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const tokenInfo = `{"uid":"foo","realm":"/services","scope":["uid"]}`
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(tokenInfo))
+})
+
+func TestHandler(t *testing.T) {
+	defer func(f func() int) { roll = f }(roll)
+	for _, test := range []struct {
+		name       string
+		faults     Faults
+		rolled     int
+		wantStatus int
+		wantBody   string
+		wantFault  string
+		wantDelay  bool
+	}{
+		{"no faults", Faults{ErrorStatus: 503}, 0, http.StatusOK, tokenInfo, "", false},
+		{"error", Faults{ErrorPercentage: 10, ErrorStatus: 503}, 9, http.StatusServiceUnavailable, "Service Unavailable", "error", false},
+		{"error not rolled", Faults{ErrorPercentage: 10, ErrorStatus: 503}, 10, http.StatusOK, tokenInfo, "", false},
+		{"malformed", Faults{MalformedPercentage: 100, ErrorStatus: 503}, 50, http.StatusOK, tokenInfo[:len(tokenInfo)/2], "malformed", false},
+		{"latency", Faults{Latency: 50 * time.Millisecond, LatencyPercentage: 100, ErrorStatus: 503}, 0, http.StatusOK, tokenInfo, "latency", true},
+		{"all", Faults{Latency: 50 * time.Millisecond, LatencyPercentage: 100, ErrorPercentage: 100, MalformedPercentage: 100, ErrorStatus: 500}, 0,
+			http.StatusInternalServerError, "Internal Server Error", "latency", true},
+	} {
+		roll = func() int { return test.rolled }
+		h := NewHandler(okHandler, NewInjector(test.faults))
+		rw := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/tokeninfo", nil))
+
+		if rw.Code != test.wantStatus || rw.Body.String() != test.wantBody {
+			t.Errorf("Wrong response for %s. Wanted %d %q, got %d %q", test.name, test.wantStatus, test.wantBody, rw.Code, rw.Body.String())
+		}
+		if f := rw.Header().Get(Header); f != test.wantFault {
+			t.Errorf("Wrong %s header for %s. Wanted %q, got %q", Header, test.name, test.wantFault, f)
+		}
+		if delayed := time.Since(start) >= 50*time.Millisecond; delayed != test.wantDelay {
+			t.Errorf("Wrong latency for %s. Wanted delayed %t, got %v", test.name, test.wantDelay, time.Since(start))
+		}
+	}
+}
+
+func TestSetFaults(t *testing.T) {
+	defer func(f func() int) { roll = f }(roll)
+	roll = func() int { return 0 }
+	i := NewInjector(Faults{})
+	h := NewHandler(okHandler, i)
+	i.Set(Faults{ErrorPercentage: 100, ErrorStatus: http.StatusBadGateway})
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/tokeninfo", nil))
+	if rw.Code != http.StatusBadGateway {
+		t.Errorf("The new faults should be injected. Wanted 502, got %d", rw.Code)
+	}
+}
+
+func TestLatencyCanceled(t *testing.T) {
+	h := NewHandler(okHandler, NewInjector(Faults{Latency: time.Minute, LatencyPercentage: 100}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rw := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/tokeninfo", nil).WithContext(ctx))
+	if time.Since(start) > time.Second || rw.Body.Len() != 0 {
+		t.Errorf("The latency should end with the request, after %v with body %q", time.Since(start), rw.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+// Package cors implements Cross-Origin Resource Sharing for browser clients that call the token info
+// endpoints directly, see https://fetch.spec.whatwg.org/#http-cors-protocol
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+var (
+	defaultMethods = []string{http.MethodGet, http.MethodPost}
+	defaultHeaders = []string{"Authorization", "Content-Type", "Accept", requestid.Header}
+	// response headers readable by the browser besides the CORS-safelisted ones
+	exposedHeaders = strings.Join([]string{requestid.Header, "X-Cache"}, ", ")
+)
+
+// Config is the CORS policy. Origins are either "*" for any origin, exact origins like
+// "https://app.example.org" or origins with a wildcard subdomain like "https://*.example.org". Without
+// Methods, GET and POST are allowed. Without Headers, the Authorization, Content-Type, Accept and
+// X-Request-Id request headers are allowed. MaxAge is how long browsers may cache the preflight response
+type Config struct {
+	Origins []string
+	Methods []string
+	Headers []string
+	MaxAge  time.Duration
+}
+
+type handler struct {
+	next     http.Handler
+	any      bool
+	origins  map[string]bool
+	suffixes []string
+	methods  map[string]bool
+	headers  map[string]bool
+	// the Access-Control-Allow-* values of the preflight responses
+	allowMethods string
+	allowHeaders string
+	maxAge       string
+}
+
+// NewHandler returns an http.Handler that adds the CORS headers to the responses of next for the allowed
+// origins and answers the preflight requests itself. Requests without an Origin header or from other
+// origins are passed to next unchanged, the browser then blocks their responses
+func NewHandler(next http.Handler, c Config) http.Handler {
+	h := &handler{next: next, origins: make(map[string]bool), methods: make(map[string]bool), headers: make(map[string]bool)}
+	for _, o := range c.Origins {
+		switch {
+		case o == "*":
+			h.any = true
+		case strings.Contains(o, "://*."):
+			// "https://*.example.org" matches "https://app.example.org" but not "https://example.org"
+			h.suffixes = append(h.suffixes, strings.ToLower(o))
+		default:
+			h.origins[strings.ToLower(o)] = true
+		}
+	}
+	methods, headers := c.Methods, c.Headers
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	if len(headers) == 0 {
+		headers = defaultHeaders
+	}
+	for _, m := range methods {
+		h.methods[strings.ToUpper(m)] = true
+	}
+	for _, name := range headers {
+		h.headers[strings.ToLower(name)] = true
+	}
+	h.allowMethods = strings.Join(methods, ", ")
+	h.allowHeaders = strings.Join(headers, ", ")
+	if c.MaxAge > 0 {
+		h.maxAge = strconv.Itoa(int(c.MaxAge / time.Second))
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if !h.any {
+		// the response depends on the origin, shared caches must not mix them up
+		w.Header().Add("Vary", "Origin")
+	}
+	if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+		h.preflight(w, req, origin)
+		return
+	}
+	if origin != "" && h.allowOrigin(origin) {
+		h.setOrigin(w, origin)
+		w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+	}
+	h.next.ServeHTTP(w, req)
+}
+
+// preflight answers whether the actual request would be allowed. Rejected preflight requests get status
+// 403 without CORS headers
+func (h *handler) preflight(w http.ResponseWriter, req *http.Request, origin string) {
+	incCounter("planb.tokeninfo.cors.preflight")
+	if origin == "" || !h.allowOrigin(origin) || !h.methods[req.Header.Get("Access-Control-Request-Method")] || !h.allowHeadersOf(req) {
+		incCounter("planb.tokeninfo.cors.rejected")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	h.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", h.allowMethods)
+	w.Header().Set("Access-Control-Allow-Headers", h.allowHeaders)
+	if h.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", h.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) setOrigin(w http.ResponseWriter, origin string) {
+	if h.any {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+func (h *handler) allowOrigin(origin string) bool {
+	if h.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if h.origins[origin] {
+		return true
+	}
+	for _, s := range h.suffixes {
+		scheme, domain, _ := strings.Cut(s, "*")
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(scheme)+len(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeadersOf checks the comma separated Access-Control-Request-Headers
+func (h *handler) allowHeadersOf(req *http.Request) bool {
+	for _, v := range req.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" && !h.headers[strings.ToLower(name)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
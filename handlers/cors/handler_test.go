@@ -0,0 +1,78 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("next"))
+	})
+	exact := NewHandler(next, Config{Origins: []string{"https://app.example.org", "https://*.example.com"}, MaxAge: 10 * time.Minute})
+	wildcard := NewHandler(next, Config{Origins: []string{"*"}, Methods: []string{"GET"}, Headers: []string{"Authorization"}})
+
+	for _, test := range []struct {
+		handler     http.Handler
+		method      string
+		origin      string
+		reqMethod   string
+		reqHeaders  string
+		wantCode    int
+		wantOrigin  string
+		wantMethods string
+		wantMaxAge  string
+	}{
+		{exact, "GET", "", "", "", http.StatusOK, "", "", ""},
+		{exact, "GET", "https://app.example.org", "", "", http.StatusOK, "https://app.example.org", "", ""},
+		{exact, "POST", "https://APP.example.org", "", "", http.StatusOK, "https://APP.example.org", "", ""},
+		{exact, "GET", "https://evil.example.org", "", "", http.StatusOK, "", "", ""},
+		{exact, "GET", "https://spa.example.com", "", "", http.StatusOK, "https://spa.example.com", "", ""},
+		{exact, "GET", "https://example.com", "", "", http.StatusOK, "", "", ""},
+		{exact, "GET", "https://evilexample.com", "", "", http.StatusOK, "", "", ""},
+		{exact, "GET", "http://spa.example.com", "", "", http.StatusOK, "", "", ""},
+		{exact, "OPTIONS", "https://app.example.org", "POST", "authorization, content-type", http.StatusNoContent, "https://app.example.org", "GET, POST", "600"},
+		{exact, "OPTIONS", "https://app.example.org", "DELETE", "", http.StatusForbidden, "", "", ""},
+		{exact, "OPTIONS", "https://app.example.org", "GET", "X-Foo", http.StatusForbidden, "", "", ""},
+		{exact, "OPTIONS", "https://evil.example.org", "GET", "", http.StatusForbidden, "", "", ""},
+		{exact, "OPTIONS", "", "GET", "", http.StatusForbidden, "", "", ""},
+		{exact, "OPTIONS", "https://app.example.org", "", "", http.StatusOK, "https://app.example.org", "", ""},
+		{wildcard, "GET", "https://foo.example.net", "", "", http.StatusOK, "*", "", ""},
+		{wildcard, "OPTIONS", "https://foo.example.net", "GET", "Authorization", http.StatusNoContent, "*", "GET", ""},
+		{wildcard, "OPTIONS", "https://foo.example.net", "POST", "", http.StatusForbidden, "", "", ""},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://example.com/oauth2/tokeninfo", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.reqMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", test.reqMethod)
+		}
+		if test.reqHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", test.reqHeaders)
+		}
+		test.handler.ServeHTTP(rw, r)
+
+		if rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s from %q. Wanted %d, got %d", test.method, test.origin, test.wantCode, rw.Code)
+		}
+		if o := rw.Header().Get("Access-Control-Allow-Origin"); o != test.wantOrigin {
+			t.Errorf("Wrong allowed origin for %s from %q. Wanted %q, got %q", test.method, test.origin, test.wantOrigin, o)
+		}
+		if m := rw.Header().Get("Access-Control-Allow-Methods"); m != test.wantMethods {
+			t.Errorf("Wrong allowed methods for %s from %q. Wanted %q, got %q", test.method, test.origin, test.wantMethods, m)
+		}
+		if a := rw.Header().Get("Access-Control-Max-Age"); a != test.wantMaxAge {
+			t.Errorf("Wrong max age for %s from %q. Wanted %q, got %q", test.method, test.origin, test.wantMaxAge, a)
+		}
+		if preflight := test.reqMethod != ""; preflight == (rw.Body.String() == "next") {
+			t.Errorf("Only preflight requests should be answered without calling next, got %q", rw.Body.String())
+		}
+		if vary := rw.Header().Get("Vary"); (test.handler == exact) != (vary == "Origin") {
+			t.Errorf("Wrong Vary header: %q", vary)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package debug implements the runtime diagnostics endpoints, served on their own internal listen address.
+// They expose the process internals and must not be reachable from outside
+package debug
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const snapshotPath = "/debug/snapshot"
+
+// snapshotProfiles are written by every snapshot, in the gzipped protobuf format read by go tool pprof
+var snapshotProfiles = []string{"heap", "goroutine"}
+
+type debugHandler struct {
+	dir string
+	mux *http.ServeMux
+}
+
+// NewHandler returns an http.Handler with the following endpoints:
+//
+//	GET  /debug/pprof/     the profiles of net/http/pprof, for ex. /debug/pprof/heap or /debug/pprof/profile?seconds=30
+//	GET  /debug/vars       the expvar variables, with the command line and the runtime memory statistics
+//	POST /debug/snapshot   write the heap and goroutine profiles to files in dir, the temporary directory if empty
+//
+// net/http/pprof and expvar also register their handlers on http.DefaultServeMux when imported, so the
+// default ServeMux must not be served on a public address
+func NewHandler(dir string) http.Handler {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	h := &debugHandler{dir: dir, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	h.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	h.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	h.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	h.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	h.mux.Handle("/debug/vars", expvar.Handler())
+	h.mux.HandleFunc(snapshotPath, h.snapshot)
+	return h
+}
+
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+// snapshot writes the profiles to files named after the profile and the current time and sends back their paths
+func (h *debugHandler) snapshot(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// up to date heap statistics, like /debug/pprof/heap?gc=1
+	runtime.GC()
+	ts := time.Now().UTC().Format("20060102T150405.000Z")
+	files := make(map[string]string, len(snapshotProfiles))
+	for _, name := range snapshotProfiles {
+		path := filepath.Join(h.dir, fmt.Sprintf("%s-%s.pb.gz", name, ts))
+		if err := writeProfile(name, path); err != nil {
+			slog.Warn("Failed to write a profile", "profile", name, "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		files[name] = path
+	}
+	slog.Info("Wrote the debug snapshot", "files", files)
+	incCounter("planb.debug.snapshots")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		slog.Warn("Failed to finish debug snapshot response", "error", err)
+	}
+}
+
+func writeProfile(name string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := rpprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,66 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(t.TempDir())
+	for _, test := range []struct {
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"GET", "/debug/pprof/", http.StatusOK, "goroutine"},
+		{"GET", "/debug/pprof/cmdline", http.StatusOK, ""},
+		{"GET", "/debug/pprof/heap?debug=1", http.StatusOK, "heap profile"},
+		{"GET", "/debug/vars", http.StatusOK, `"memstats"`},
+		{"GET", "/debug/snapshot", http.StatusMethodNotAllowed, ""},
+		{"GET", "/debug/foo", http.StatusNotFound, ""},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		h.ServeHTTP(rw, r)
+		if rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s %s. Wanted %d, got %d", test.method, test.path, test.wantCode, rw.Code)
+		}
+		if !strings.Contains(rw.Body.String(), test.wantBody) {
+			t.Errorf("Response of %s %s should contain %q", test.method, test.path, test.wantBody)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	h := NewHandler(t.TempDir())
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://example.com/debug/snapshot", nil)
+	h.ServeHTTP(rw, r)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Wrong status code. Wanted %d, got %d", http.StatusOK, rw.Code)
+	}
+	var files map[string]string
+	if err := json.Unmarshal(rw.Body.Bytes(), &files); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"heap", "goroutine"} {
+		fi, err := os.Stat(files[name])
+		if err != nil {
+			t.Errorf("Missing %s profile: %v", name, err)
+		} else if fi.Size() == 0 {
+			t.Errorf("Empty %s profile", name)
+		}
+	}
+
+	h = NewHandler("/nonexistent/dir")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+	if rw.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status code without a writable directory. Wanted %d, got %d", http.StatusInternalServerError, rw.Code)
+	}
+}
@@ -0,0 +1,177 @@
+// Package devtoken issues test tokens signed with a key pair generated at startup, so that developers can run
+// integration tests against a standalone token info, without an OpenID provider or a Revocation service. The
+// public key is one of the validation keys, the issued tokens are validated like any other JWT token. It's for
+// development environments only
+package devtoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	jwthandler "github.com/zalando/planb-tokeninfo/handlers/tokeninfo/jwt"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+const (
+	// IssuerName is the iss claim of the test tokens, unless set in the claims of the request
+	IssuerName = "planb-tokeninfo-dev"
+	// DefaultSubject is the sub claim of the test tokens, unless set in the request
+	DefaultSubject = "test-user"
+	// DefaultRealm is the realm of the test tokens, unless set in the request
+	DefaultRealm = "/services"
+	// DefaultExpiresIn is the lifetime of the test tokens in seconds, unless set in the request
+	DefaultExpiresIn = 3600
+
+	// maxBodySize limits the size of the token requests
+	maxBodySize = 64 << 10
+)
+
+// An Issuer signs test tokens with an ECDSA P-256 key generated for the process. It's also the KeyLoader
+// of its public key, with a random key ID that changes on every start
+type Issuer struct {
+	key *ecdsa.PrivateKey
+	kid string
+}
+
+// NewIssuer returns an Issuer with a new key pair
+func NewIssuer() (*Issuer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Issuer{key: key, kid: "planb-dev-" + hex.EncodeToString(id)}, nil
+}
+
+// KeyID returns the kid of the key pair, in the header of every test token
+func (i *Issuer) KeyID() string {
+	return i.kid
+}
+
+// LoadKey returns the public key of the Issuer if id is its key ID
+func (i *Issuer) LoadKey(id string) (interface{}, error) {
+	if id != i.kid {
+		return nil, fmt.Errorf("%w: %s", keyloader.ErrKeyNotFound, id)
+	}
+	return &i.key.PublicKey, nil
+}
+
+// Keys returns the public key of the Issuer
+func (i *Issuer) Keys() map[string]interface{} {
+	return map[string]interface{}{i.kid: &i.key.PublicKey}
+}
+
+// A Request describes the test token to issue. All fields are optional. ExpiresIn may be negative for tokens
+// that are already expired. The Claims are added last and replace any of the other claims
+type Request struct {
+	Subject   string                 `json:"sub"`
+	Realm     string                 `json:"realm"`
+	Scope     []string               `json:"scope"`
+	ClientID  string                 `json:"client_id"`
+	ExpiresIn *int64                 `json:"expires_in"`
+	Claims    map[string]interface{} `json:"claims"`
+}
+
+// Response is the body of a successful token request, like the one of an OAuth 2.0 token endpoint
+type Response struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Issue returns a signed token for r, issued at now, and its lifetime in seconds
+func (i *Issuer) Issue(r Request, now time.Time) (string, int64, error) {
+	expiresIn := int64(DefaultExpiresIn)
+	if r.ExpiresIn != nil {
+		expiresIn = *r.ExpiresIn
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", 0, err
+	}
+	c := jwt.MapClaims{
+		"iss":                    IssuerName,
+		jwthandler.JwtClaimSub:   DefaultSubject,
+		jwthandler.JwtClaimRealm: DefaultRealm,
+		jwthandler.JwtClaimScope: []string{},
+		"iat":                    now.Unix(),
+		jwthandler.JwtClaimExp:   now.Unix() + expiresIn,
+		"jti":                    hex.EncodeToString(jti),
+	}
+	if r.Subject != "" {
+		c[jwthandler.JwtClaimSub] = r.Subject
+	}
+	if r.Realm != "" {
+		c[jwthandler.JwtClaimRealm] = r.Realm
+	}
+	if r.Scope != nil {
+		c[jwthandler.JwtClaimScope] = r.Scope
+	}
+	if r.ClientID != "" {
+		c["azp"] = r.ClientID
+	}
+	for k, v := range r.Claims {
+		c[k] = v
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+	t.Header["kid"] = i.kid
+	s, err := t.SignedString(i.key)
+	return s, expiresIn, err
+}
+
+type handler struct {
+	issuer *Issuer
+}
+
+// NewHandler returns an http.Handler that issues test tokens with the issuer. The optional JSON body of the
+// POST requests is a Request
+func NewHandler(issuer *Issuer) http.Handler {
+	return &handler{issuer: issuer}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var r Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodySize)).Decode(&r); err != nil && err != io.EOF {
+		incCounter("planb.devtoken.invalid")
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+		return
+	}
+	token, expiresIn, err := h.issuer.Issue(r, time.Now())
+	if err != nil {
+		slog.Error("Failed to sign a test token", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	incCounter("planb.devtoken.issued")
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(&Response{AccessToken: token, TokenType: "Bearer", ExpiresIn: expiresIn}); err != nil {
+		slog.Error("Failed to write the test token", "error", err)
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,107 @@
+package devtoken
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwthandler "github.com/zalando/planb-tokeninfo/handlers/tokeninfo/jwt"
+	"github.com/zalando/planb-tokeninfo/revoke"
+)
+
+// issue requests a test token with the body and returns the response
+func issue(t *testing.T, h http.Handler, body string) (*httptest.ResponseRecorder, Response) {
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("POST", "/oauth2/issue-test-token", strings.NewReader(body)))
+	var resp Response
+	if rw.Code == http.StatusOK {
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rw, resp
+}
+
+func TestIssuedTokensAreValid(t *testing.T) {
+	issuer, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(issuer)
+	th := jwthandler.New(issuer, revoke.NewCachingRevokeProvider(nil))
+	for _, test := range []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantInfo   map[string]interface{}
+	}{
+		{"defaults", "", http.StatusOK, map[string]interface{}{"uid": DefaultSubject, "realm": DefaultRealm, "scope": []interface{}{}}},
+		{"request", `{"sub":"jdoe","realm":"/employees","scope":["uid","cn"],"client_id":"orders","expires_in":60}`, http.StatusOK,
+			map[string]interface{}{"uid": "jdoe", "realm": "/employees", "scope": []interface{}{"uid", "cn"}, "client_id": "orders"}},
+		{"claims", `{"claims":{"realm":"/customers","email":"jdoe@example.org"}}`, http.StatusOK, map[string]interface{}{"realm": "/customers"}},
+		{"expired", `{"expires_in":-60}`, http.StatusUnauthorized, nil},
+	} {
+		rw, resp := issue(t, h, test.body)
+		if rw.Code != http.StatusOK || resp.TokenType != "Bearer" || rw.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("Failed to issue a token for %s: %d %s", test.name, rw.Code, rw.Body.String())
+		}
+		if test.name == "request" && resp.ExpiresIn != 60 {
+			t.Errorf("Wrong expires_in of the response. Wanted 60, got %d", resp.ExpiresIn)
+		}
+		req := httptest.NewRequest("GET", "/oauth2/tokeninfo", nil)
+		req.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+		if !th.Match(req) {
+			t.Fatalf("The token for %s should be a JWT token", test.name)
+		}
+		info := httptest.NewRecorder()
+		th.ServeHTTP(info, req)
+		if info.Code != test.wantStatus {
+			t.Fatalf("Wrong status of the token for %s. Wanted %d, got %d %s", test.name, test.wantStatus, info.Code, info.Body.String())
+		}
+		var got map[string]interface{}
+		json.Unmarshal(info.Body.Bytes(), &got)
+		for k, want := range test.wantInfo {
+			if g, _ := json.Marshal(got[k]); string(g) != mustMarshal(want) {
+				t.Errorf("Wrong %s of the token for %s. Wanted %v, got %v", k, test.name, want, got[k])
+			}
+		}
+	}
+}
+
+func mustMarshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func TestInvalidRequests(t *testing.T) {
+	issuer, _ := NewIssuer()
+	h := NewHandler(issuer)
+
+	if rw, _ := issue(t, h, `{"scope":"uid"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for an invalid request. Wanted 400, got %d", rw.Code)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/issue-test-token", nil))
+	if rw.Code != http.StatusMethodNotAllowed || rw.Header().Get("Allow") != "POST" {
+		t.Errorf("Only POST requests should be allowed, got %d", rw.Code)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	issuer, _ := NewIssuer()
+	other, _ := NewIssuer()
+	if issuer.KeyID() == other.KeyID() || !strings.HasPrefix(issuer.KeyID(), "planb-dev-") {
+		t.Errorf("Every issuer should have its own key ID, got %s and %s", issuer.KeyID(), other.KeyID())
+	}
+	if _, err := issuer.LoadKey(issuer.KeyID()); err != nil {
+		t.Error("The key of the issuer should be found: ", err)
+	}
+	if _, err := issuer.LoadKey(other.KeyID()); err == nil {
+		t.Error("The key of another issuer should not be found")
+	}
+	if keys := issuer.Keys(); len(keys) != 1 || keys[issuer.KeyID()] == nil {
+		t.Errorf("Wrong keys of the issuer: %v", keys)
+	}
+}
@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/jwt"
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+// responseContentTypes are the media types of the Accept header supported by the Token Info endpoints
+var responseContentTypes = []string{"application/json", "application/msgpack", "application/x-protobuf"}
+
+// openIDConfiguration is the subset of the OpenID Connect Discovery metadata that applies to the endpoints
+// of this server. See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type openIDConfiguration struct {
+	Issuer                                    string   `json:"issuer"`
+	JwksURI                                   string   `json:"jwks_uri"`
+	UserInfoEndpoint                          string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	IDTokenSigningAlgValuesSupported          []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// tokenInfoConfiguration describes the Token Info specific endpoints and features
+type tokenInfoConfiguration struct {
+	Issuer                        string   `json:"issuer"`
+	TokenInfoEndpoint             string   `json:"tokeninfo_endpoint"`
+	BatchEndpoint                 string   `json:"batch_endpoint"`
+	IntrospectionEndpoint         string   `json:"introspection_endpoint"`
+	UserInfoEndpoint              string   `json:"userinfo_endpoint"`
+	TokenReviewEndpoint           string   `json:"tokenreview_endpoint"`
+	TokenExchangeEndpoint         string   `json:"token_exchange_endpoint,omitempty"`
+	JwksURI                       string   `json:"jwks_uri"`
+	SigningAlgValuesSupported     []string `json:"signing_alg_values_supported"`
+	ResponseContentTypesSupported []string `json:"response_content_types_supported"`
+	UpstreamTokenInfo             bool     `json:"upstream_tokeninfo"`
+	UpstreamUserInfo              bool     `json:"upstream_userinfo"`
+	BatchMaxTokens                int      `json:"batch_max_tokens"`
+}
+
+type discoveryHandler struct {
+	issuer   *url.URL
+	hosts    []string
+	trusted  []netip.Prefix
+	document func(issuer string) interface{}
+}
+
+// NewOpenIDConfigurationHandler returns an http.Handler for /.well-known/openid-configuration. The issuer is
+// the DiscoveryIssuer of the settings or, if not set, the scheme and host of the request when the host is one
+// of the DiscoveryHosts
+func NewOpenIDConfigurationHandler(settings *options.Settings) http.Handler {
+	algs := jwthandler.AllowedAlgorithms(settings.JwtAllowedAlgorithms)
+	authMethods := []string{"none"}
+	if len(settings.IntrospectionClients) > 0 {
+		authMethods = []string{"client_secret_basic"}
+	}
+	return &discoveryHandler{issuer: settings.DiscoveryIssuer, hosts: settings.DiscoveryHosts, trusted: settings.TrustedProxies, document: func(issuer string) interface{} {
+		return &openIDConfiguration{
+			Issuer:                issuer,
+			JwksURI:               issuer + "/oauth2/connect/keys",
+			UserInfoEndpoint:      issuer + "/oauth2/userinfo",
+			IntrospectionEndpoint: issuer + "/oauth2/introspect",
+			IntrospectionEndpointAuthMethodsSupported: authMethods,
+			IDTokenSigningAlgValuesSupported:          algs,
+		}
+	}}
+}
+
+// NewTokenInfoConfigurationHandler returns an http.Handler for /.well-known/tokeninfo-configuration, which
+// describes all endpoints and the features enabled by the settings. The issuer is chosen like for
+// NewOpenIDConfigurationHandler
+func NewTokenInfoConfigurationHandler(settings *options.Settings) http.Handler {
+	algs := jwthandler.AllowedAlgorithms(settings.JwtAllowedAlgorithms)
+	return &discoveryHandler{issuer: settings.DiscoveryIssuer, hosts: settings.DiscoveryHosts, trusted: settings.TrustedProxies, document: func(issuer string) interface{} {
+		var tokenExchange string
+		if settings.UpstreamTokenExchangeURL != nil {
+			tokenExchange = issuer + "/oauth2/token-exchange"
+		}
+		return &tokenInfoConfiguration{
+			Issuer:                        issuer,
+			TokenInfoEndpoint:             issuer + "/oauth2/tokeninfo",
+			BatchEndpoint:                 issuer + "/oauth2/tokeninfo/batch",
+			IntrospectionEndpoint:         issuer + "/oauth2/introspect",
+			UserInfoEndpoint:              issuer + "/oauth2/userinfo",
+			TokenReviewEndpoint:           issuer + "/apis/authentication.k8s.io/v1/tokenreviews",
+			TokenExchangeEndpoint:         tokenExchange,
+			JwksURI:                       issuer + "/oauth2/connect/keys",
+			SigningAlgValuesSupported:     algs,
+			ResponseContentTypesSupported: responseContentTypes,
+			UpstreamTokenInfo:             settings.UpstreamTokenInfoURL != nil,
+			UpstreamUserInfo:              settings.UpstreamUserInfoURL != nil,
+			BatchMaxTokens:                settings.BatchMaxTokens,
+		}
+	}}
+}
+
+// ServeHTTP sends back the discovery document as JSON
+func (h *discoveryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	issuer, ok := h.issuerFor(req)
+	if !ok {
+		// the endpoints would point to any host the client asked for
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.document(issuer)); err != nil {
+		slog.Warn("Failed to write the discovery document", "error", err)
+	}
+}
+
+// issuerFor returns the configured issuer without a trailing slash or the base URL of the request, if its host
+// is one of the allowed hosts. X-Forwarded-Proto is only read from the trusted proxies
+func (h *discoveryHandler) issuerFor(req *http.Request) (string, bool) {
+	if h.issuer != nil {
+		return strings.TrimSuffix(h.issuer.String(), "/"), true
+	}
+	if !slices.ContainsFunc(h.hosts, func(host string) bool { return strings.EqualFold(host, req.Host) }) {
+		return "", false
+	}
+	scheme := "http"
+	if req.TLS != nil || (req.Header.Get("X-Forwarded-Proto") == "https" && clientip.FromTrustedProxy(req, h.trusted)) {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host, true
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+func TestOpenIDConfiguration(t *testing.T) {
+	issuer, _ := url.Parse("https://tokeninfo.example.com/")
+	for _, test := range []struct {
+		settings *options.Settings
+		tls      bool
+		wantBody string
+	}{
+		{&options.Settings{DiscoveryHosts: []string{"example.com"}}, false,
+			`{"issuer":"http://example.com","jwks_uri":"http://example.com/oauth2/connect/keys","userinfo_endpoint":"http://example.com/oauth2/userinfo","introspection_endpoint":"http://example.com/oauth2/introspect","introspection_endpoint_auth_methods_supported":["none"],"id_token_signing_alg_values_supported":["RS256","RS384","RS512","PS256","PS384","PS512","ES256","ES384","ES512","EdDSA"]}` + "\n"},
+		{&options.Settings{DiscoveryHosts: []string{"example.com"}, IntrospectionClients: map[string]string{"foo": "bar"}}, true,
+			`{"issuer":"https://example.com","jwks_uri":"https://example.com/oauth2/connect/keys","userinfo_endpoint":"https://example.com/oauth2/userinfo","introspection_endpoint":"https://example.com/oauth2/introspect","introspection_endpoint_auth_methods_supported":["client_secret_basic"],"id_token_signing_alg_values_supported":["RS256","RS384","RS512","PS256","PS384","PS512","ES256","ES384","ES512","EdDSA"]}` + "\n"},
+		{&options.Settings{DiscoveryIssuer: issuer}, false,
+			`{"issuer":"https://tokeninfo.example.com","jwks_uri":"https://tokeninfo.example.com/oauth2/connect/keys","userinfo_endpoint":"https://tokeninfo.example.com/oauth2/userinfo","introspection_endpoint":"https://tokeninfo.example.com/oauth2/introspect","introspection_endpoint_auth_methods_supported":["none"],"id_token_signing_alg_values_supported":["RS256","RS384","RS512","PS256","PS384","PS512","ES256","ES384","ES512","EdDSA"]}` + "\n"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/.well-known/openid-configuration", nil)
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		NewOpenIDConfigurationHandler(test.settings).ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusOK, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Wrong content type: %q", ct)
+		}
+		if w.Body.String() != test.wantBody {
+			t.Errorf("Wrong response body. Wanted %s, got %s", test.wantBody, w.Body.String())
+		}
+	}
+}
+
+func TestTokenInfoConfiguration(t *testing.T) {
+	upstream, _ := url.Parse("http://upstream.example.com")
+	settings := &options.Settings{UpstreamTokenInfoURL: upstream, BatchMaxTokens: 100, JwtAllowedAlgorithms: []string{"PS256", "RS256", "HS256"},
+		DiscoveryHosts: []string{"example.com"}, TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/.well-known/tokeninfo-configuration", nil)
+	r.RemoteAddr = "10.0.0.1:4711"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	NewTokenInfoConfigurationHandler(settings).ServeHTTP(w, r)
+	want := `{"issuer":"https://example.com","tokeninfo_endpoint":"https://example.com/oauth2/tokeninfo",` +
+		`"batch_endpoint":"https://example.com/oauth2/tokeninfo/batch","introspection_endpoint":"https://example.com/oauth2/introspect",` +
+		`"userinfo_endpoint":"https://example.com/oauth2/userinfo","tokenreview_endpoint":"https://example.com/apis/authentication.k8s.io/v1/tokenreviews",` +
+		`"jwks_uri":"https://example.com/oauth2/connect/keys","signing_alg_values_supported":["RS256","PS256"],` +
+		`"response_content_types_supported":["application/json","application/msgpack","application/x-protobuf"],` +
+		`"upstream_tokeninfo":true,"upstream_userinfo":false,"batch_max_tokens":100}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("Wrong response body. Wanted %s, got %s", want, w.Body.String())
+	}
+}
+
+func TestUntrustedRequests(t *testing.T) {
+	settings := &options.Settings{DiscoveryHosts: []string{"Example.com"}, TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	h := NewOpenIDConfigurationHandler(settings)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://attacker.example.org/.well-known/openid-configuration", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Hosts that are not allowed should get 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://example.com/.well-known/openid-configuration", nil)
+	r.RemoteAddr = "192.0.2.1:4711"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), `{"issuer":"http://example.com"`) {
+		t.Errorf("X-Forwarded-Proto should only be read from the trusted proxies, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://example.com/.well-known/openid-configuration", nil)
+	NewOpenIDConfigurationHandler(&options.Settings{}).ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
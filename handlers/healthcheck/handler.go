@@ -1,30 +1,167 @@
 package healthcheck
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/zalando/planb-tokeninfo/breaker"
 	"github.com/zalando/planb-tokeninfo/keyloader"
 )
 
+// KeysDependency is the name of the public keys in the detailed health response
+const KeysDependency = "keys"
+
+// the overall status of the detailed health response
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusDown     = "down"
+	statusFailed   = "failed"
+)
+
+// A Dependency returns the details of a dependency of the service for the detailed health response and a
+// non nil error when the dependency is not healthy
+type Dependency func() (map[string]interface{}, error)
+
 type handler struct {
-	ver    string
-	loader keyloader.KeyLoader
+	ver      string
+	loader   keyloader.KeyLoader
+	circuits []string
+	deps     map[string]Dependency
+	critical map[string]bool
+}
+
+type healthReport struct {
+	Status       string                       `json:"status"`
+	Version      string                       `json:"version"`
+	Dependencies map[string]*dependencyReport `json:"dependencies"`
+	Circuits     map[string]string            `json:"circuits,omitempty"`
+}
+
+type dependencyReport struct {
+	Status   string                 `json:"status"`
+	Critical bool                   `json:"critical"`
+	Error    string                 `json:"error,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
 }
 
 // NewHandler creates an Health check http.Handler that returns 200 when there is at least 1 key
-// Response also reports version
-func NewHandler(kl keyloader.KeyLoader, version string) http.Handler {
-	return &handler{loader: kl, ver: version}
+// Response also reports version, the time of the last key refresh and the state of the circuit breakers
+// named circuits
+func NewHandler(kl keyloader.KeyLoader, version string, circuits ...string) http.Handler {
+	return NewHandlerWithDependencies(kl, version, nil, []string{KeysDependency}, circuits...)
 }
 
-// ServeHTTP returns a 200 status code if there is at least 1 key available or 503 otherwise
-func (h handler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
-	if len(h.loader.Keys()) < 1 {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		fmt.Fprintf(w, "No keys available\n%s", h.ver)
-	} else {
-		w.WriteHeader(http.StatusOK)
+// NewHandlerWithDependencies creates an Health check http.Handler that also checks the named dependencies,
+// next to the keys of kl. It returns 503 when one of the critical dependencies fails, the failures of the
+// others are only reported. With "?format=json" or "Accept: application/json" the response describes
+// every dependency
+func NewHandlerWithDependencies(kl keyloader.KeyLoader, version string, deps map[string]Dependency, critical []string, circuits ...string) http.Handler {
+	h := &handler{loader: kl, ver: version, circuits: circuits, deps: deps, critical: make(map[string]bool)}
+	for _, name := range critical {
+		h.critical[name] = true
+	}
+	return h
+}
+
+// ServeHTTP returns a 200 status code if none of the critical dependencies failed or 503 otherwise
+// Open circuit breakers are reported but don't change the status code
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := h.report()
+	code := http.StatusOK
+	if report.Status == statusDown {
+		code = http.StatusServiceUnavailable
+	}
+
+	if wantsJSON(req) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	w.WriteHeader(code)
+	switch {
+	case code == http.StatusOK:
 		fmt.Fprintf(w, "OK\n%s", h.ver)
+	case report.Dependencies[KeysDependency].Status == statusFailed:
+		fmt.Fprintf(w, "No keys available\n%s", h.ver)
+	default:
+		fmt.Fprintf(w, "Unhealthy: %s\n%s", strings.Join(report.failed(), ", "), h.ver)
+	}
+	if r, ok := h.loader.(keyloader.Refresher); ok {
+		if t := r.LastRefresh(); !t.IsZero() {
+			fmt.Fprintf(w, "\nkeys refreshed at: %s", t.UTC().Format(time.RFC3339))
+		}
 	}
+	for _, c := range h.circuits {
+		fmt.Fprintf(w, "\n%s circuit: %s", c, report.Circuits[c])
+	}
+}
+
+// report checks all the dependencies. The service is down when a critical dependency failed and degraded
+// when another one failed
+func (h *handler) report() *healthReport {
+	report := &healthReport{Status: statusOK, Version: h.ver, Dependencies: make(map[string]*dependencyReport)}
+	report.add(KeysDependency, h.critical[KeysDependency], h.keys)
+	for name, dep := range h.deps {
+		report.add(name, h.critical[name], dep)
+	}
+	if len(h.circuits) > 0 {
+		report.Circuits = make(map[string]string, len(h.circuits))
+		for _, c := range h.circuits {
+			report.Circuits[c] = breaker.State(c)
+		}
+	}
+	return report
+}
+
+// keys reports the number of keys and the time of the last refresh
+func (h *handler) keys() (map[string]interface{}, error) {
+	n := len(h.loader.Keys())
+	details := map[string]interface{}{"count": n}
+	if r, ok := h.loader.(keyloader.Refresher); ok {
+		if t := r.LastRefresh(); !t.IsZero() {
+			details["last_refresh"] = t.UTC().Format(time.RFC3339)
+		}
+	}
+	if n < 1 {
+		return details, errNoKeys
+	}
+	return details, nil
+}
+
+func (r *healthReport) add(name string, critical bool, dep Dependency) {
+	details, err := dep()
+	d := &dependencyReport{Status: statusOK, Critical: critical, Details: details}
+	if err != nil {
+		d.Status, d.Error = statusFailed, err.Error()
+		switch {
+		case critical:
+			r.Status = statusDown
+		case r.Status == statusOK:
+			r.Status = statusDegraded
+		}
+	}
+	r.Dependencies[name] = d
+}
+
+// failed returns the sorted names of the failed critical dependencies
+func (r *healthReport) failed() []string {
+	var names []string
+	for name, d := range r.Dependencies {
+		if d.Critical && d.Status == statusFailed {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func wantsJSON(req *http.Request) bool {
+	return req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json")
 }
@@ -1,9 +1,12 @@
 package healthcheck
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type mockLoaderWithKeys int
@@ -18,6 +21,13 @@ type mockLoaderWithoutKeys int
 func (m *mockLoaderWithoutKeys) LoadKey(_ string) (interface{}, error) { return "dummy", nil }
 func (m *mockLoaderWithoutKeys) Keys() map[string]interface{}          { return map[string]interface{}{} }
 
+type mockRefresher struct {
+	mockLoaderWithKeys
+	last time.Time
+}
+
+func (m *mockRefresher) LastRefresh() time.Time { return m.last }
+
 func TestHandler(t *testing.T) {
 	for _, test := range []struct {
 		h        http.Handler
@@ -27,6 +37,9 @@ func TestHandler(t *testing.T) {
 		{NewHandler(new(mockLoaderWithKeys), "v1"), http.StatusOK, "OK\nv1"},
 		{NewHandler(new(mockLoaderWithKeys), "v2"), http.StatusOK, "OK\nv2"},
 		{NewHandler(new(mockLoaderWithoutKeys), "x"), http.StatusServiceUnavailable, "No keys available\nx"},
+		{NewHandler(new(mockLoaderWithKeys), "v3", "foo"), http.StatusOK, "OK\nv3\nfoo circuit: closed"},
+		{NewHandler(new(mockRefresher), "v4"), http.StatusOK, "OK\nv4"},
+		{NewHandler(&mockRefresher{last: time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)}, "v5", "foo"), http.StatusOK, "OK\nv5\nkeys refreshed at: 2016-05-01T10:00:00Z\nfoo circuit: closed"},
 	} {
 		rw := httptest.NewRecorder()
 		r, _ := http.NewRequest("GET", "http://example.com", nil)
@@ -43,3 +56,100 @@ func TestHandler(t *testing.T) {
 	}
 
 }
+
+func TestDependencies(t *testing.T) {
+	var cacheErr, upstreamErr error
+	deps := map[string]Dependency{
+		"cache":    func() (map[string]interface{}, error) { return map[string]interface{}{"backend": "redis"}, cacheErr },
+		"upstream": func() (map[string]interface{}, error) { return nil, upstreamErr },
+	}
+	h := NewHandlerWithDependencies(new(mockLoaderWithKeys), "v1", deps, []string{"keys", "upstream"})
+	for _, test := range []struct {
+		cacheErr    error
+		upstreamErr error
+		wantCode    int
+		wantStatus  string
+		wantText    string
+	}{
+		{nil, nil, http.StatusOK, "ok", "OK\nv1"},
+		{errors.New("connection refused"), nil, http.StatusOK, "degraded", "OK\nv1"},
+		{nil, errors.New("timeout"), http.StatusServiceUnavailable, "down", "Unhealthy: upstream\nv1"},
+	} {
+		cacheErr, upstreamErr = test.cacheErr, test.upstreamErr
+
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/health", nil)
+		h.ServeHTTP(rw, r)
+		if rw.Code != test.wantCode || rw.Body.String() != test.wantText {
+			t.Errorf("Wrong health response. Wanted %d %q, got %d %q", test.wantCode, test.wantText, rw.Code, rw.Body.String())
+		}
+
+		rw = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "http://example.com/health?format=json", nil)
+		h.ServeHTTP(rw, r)
+		var report healthReport
+		if err := json.Unmarshal(rw.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Invalid JSON health response %q: %v", rw.Body.String(), err)
+		}
+		if rw.Code != test.wantCode || report.Status != test.wantStatus || report.Version != "v1" {
+			t.Errorf("Wrong JSON health response. Wanted %d %s, got %d %s", test.wantCode, test.wantStatus, rw.Code, report.Status)
+		}
+		keys := report.Dependencies["keys"]
+		if keys == nil || keys.Status != "ok" || !keys.Critical || keys.Details["count"] != 1.0 {
+			t.Errorf("Wrong keys dependency: %+v", keys)
+		}
+		cache := report.Dependencies["cache"]
+		if cache == nil || cache.Critical || cache.Details["backend"] != "redis" || (test.cacheErr != nil) != (cache.Status == "failed") {
+			t.Errorf("Wrong cache dependency: %+v", cache)
+		}
+		if test.upstreamErr != nil && report.Dependencies["upstream"].Error != test.upstreamErr.Error() {
+			t.Errorf("Wrong upstream error: %+v", report.Dependencies["upstream"])
+		}
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/health/alive", nil)
+	NewLivenessHandler("v1").ServeHTTP(rw, r)
+	if rw.Code != http.StatusOK || rw.Body.String() != "OK\nv1" {
+		t.Errorf("Wrong liveness response: %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	var upstreamErr error
+	checks := map[string]Check{
+		"cache":    func() error { return nil },
+		"upstream": func() error { return upstreamErr },
+	}
+	ready := NewReadinessHandler(new(mockLoaderWithKeys), "v1", 2, checks)
+
+	for _, test := range []struct {
+		upstreamErr error
+		wantCode    int
+		wantResp    string
+	}{
+		{nil, http.StatusOK, "OK\nv1"},
+		{errors.New("connection refused"), http.StatusOK, "OK\nv1"},
+		{errors.New("connection refused"), http.StatusServiceUnavailable, "Not ready\nv1\nupstream: connection refused"},
+		{errors.New("timeout"), http.StatusServiceUnavailable, "Not ready\nv1\nupstream: timeout"},
+		{nil, http.StatusOK, "OK\nv1"},
+		{errors.New("connection refused"), http.StatusOK, "OK\nv1"},
+	} {
+		upstreamErr = test.upstreamErr
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/health/ready", nil)
+		ready.ServeHTTP(rw, r)
+		if rw.Code != test.wantCode || rw.Body.String() != test.wantResp {
+			t.Errorf("Wrong readiness response. Wanted %d %q, got %d %q", test.wantCode, test.wantResp, rw.Code, rw.Body.String())
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/health/ready", nil)
+	NewReadinessHandler(new(mockLoaderWithoutKeys), "v2", 3, nil).ServeHTTP(rw, r)
+	if rw.Code != http.StatusServiceUnavailable || rw.Body.String() != "Not ready\nv2\nkeys: no keys available" {
+		t.Errorf("Wrong readiness response without keys: %d %q", rw.Code, rw.Body.String())
+	}
+}
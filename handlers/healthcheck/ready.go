@@ -0,0 +1,95 @@
+package healthcheck
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+// A Check returns a non nil error when a dependency of the service is not available
+type Check func() error
+
+var errNoKeys = errors.New("no keys available")
+
+type livenessHandler struct {
+	ver string
+}
+
+type readinessHandler struct {
+	ver       string
+	loader    keyloader.KeyLoader
+	checks    map[string]Check
+	threshold int
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+// NewLivenessHandler creates an http.Handler that always returns 200 and the version, as long as the
+// process is able to serve requests
+func NewLivenessHandler(version string) http.Handler {
+	return &livenessHandler{ver: version}
+}
+
+func (h *livenessHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\n%s", h.ver)
+}
+
+// NewReadinessHandler creates an http.Handler that returns 200 when the service is ready to receive
+// traffic. The service is ready when there is at least 1 key and none of the named checks failed in the
+// last threshold calls, so that a single failed check doesn't take the service out of rotation
+func NewReadinessHandler(kl keyloader.KeyLoader, version string, threshold int, checks map[string]Check) http.Handler {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &readinessHandler{ver: version, loader: kl, checks: checks, threshold: threshold, failures: make(map[string]int)}
+}
+
+// ServeHTTP runs all checks and returns a 200 status code when ready or 503 otherwise. The failing
+// checks are reported in the response body
+func (h *readinessHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	errs := h.check()
+	if len(errs) == 0 {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "OK\n%s", h.ver)
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintf(w, "Not ready\n%s", h.ver)
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\n%s: %v", name, errs[name])
+	}
+}
+
+// check returns the errors of the checks that failed at least threshold times in a row
+func (h *readinessHandler) check() map[string]error {
+	errs := make(map[string]error)
+	if len(h.loader.Keys()) < 1 {
+		errs["keys"] = errNoKeys
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, c := range h.checks {
+		if err := c(); err != nil {
+			h.failures[name]++
+			if h.failures[name] >= h.threshold {
+				errs[name] = err
+			}
+		} else {
+			h.failures[name] = 0
+		}
+	}
+	return errs
+}
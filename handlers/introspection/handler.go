@@ -0,0 +1,147 @@
+package introspection
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+const tokenParameter = "token"
+
+type introspectionHandler struct {
+	tokenInfo http.Handler
+	clients   map[string]string
+}
+
+// Response is the RFC 7662 introspection response. Only Active is mandatory, every other field
+// is omitted for inactive tokens. See https://tools.ietf.org/html/rfc7662#section-2.2
+type Response struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Realm     string `json:"realm,omitempty"`
+}
+
+// the subset of the Token Info response that can be translated into an introspection response
+type tokenInfoResponse struct {
+	UID       string   `json:"uid"`
+	Scope     []string `json:"scope"`
+	Realm     string   `json:"realm"`
+	ClientID  string   `json:"client_id"`
+	TokenType string   `json:"token_type"`
+	ExpiresIn int64    `json:"expires_in"`
+}
+
+// NewHandler returns an http.Handler that implements the RFC 7662 token introspection endpoint.
+// Tokens are validated by the tokenInfo http.Handler, which means both JWT and upstream validation
+// apply. If clients is not empty, callers have to authenticate with HTTP Basic authentication using
+// one of the client ID/secret pairs
+func NewHandler(tokenInfo http.Handler, clients map[string]string) http.Handler {
+	return &introspectionHandler{tokenInfo: tokenInfo, clients: clients}
+}
+
+// ServeHTTP validates the token form parameter and sends back an introspection response
+func (h *introspectionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.authenticate(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspection"`)
+		registerError(tokeninfo.ErrInvalidClient)
+		tokeninfo.ErrInvalidClient.Write(w, req)
+		return
+	}
+
+	token := req.PostFormValue(tokenParameter)
+	if token == "" {
+		registerError(tokeninfo.ErrInvalidRequest)
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+		return
+	}
+
+	rec := tokeninfo.NewResponseRecorder()
+	h.tokenInfo.ServeHTTP(rec, tokeninfo.NewTokenInfoRequest(req, token))
+
+	if rec.Status >= http.StatusInternalServerError {
+		slog.Warn("Failed to introspect token", "status", rec.Status)
+		http.Error(w, http.StatusText(rec.Status), rec.Status)
+		return
+	}
+
+	resp := &Response{Active: false}
+	if rec.Status == http.StatusOK {
+		if ti, err := decodeTokenInfo(rec.Body.Bytes()); err == nil {
+			resp = newResponse(ti, time.Now())
+		} else {
+			slog.Warn("Failed to decode the token info response", "error", err)
+		}
+	}
+
+	incCounter(resp.Active)
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Warn("Failed to finish introspection response", "error", err)
+	}
+}
+
+func (h *introspectionHandler) authenticate(req *http.Request) bool {
+	if len(h.clients) == 0 {
+		return true
+	}
+	id, secret, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	return tokeninfo.CheckSecret(h.clients, id, secret)
+}
+
+func decodeTokenInfo(data []byte) (*tokenInfoResponse, error) {
+	ti := new(tokenInfoResponse)
+	if err := json.Unmarshal(data, ti); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+func newResponse(ti *tokenInfoResponse, timeBase time.Time) *Response {
+	return &Response{
+		Active:    true,
+		Scope:     strings.Join(ti.Scope, " "),
+		ClientID:  ti.ClientID,
+		Username:  ti.UID,
+		TokenType: ti.TokenType,
+		Exp:       timeBase.Unix() + ti.ExpiresIn,
+		Sub:       ti.UID,
+		Realm:     ti.Realm,
+	}
+}
+
+func incCounter(active bool) {
+	key := "planb.tokeninfo.introspection.inactive"
+	if active {
+		key = "planb.tokeninfo.introspection.active"
+	}
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+func registerError(err tokeninfo.Error) {
+	key := "planb.tokeninfo.introspection.errors." + err.Error
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
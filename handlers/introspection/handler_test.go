@@ -0,0 +1,88 @@
+package introspection
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testTokenInfoHandler struct{}
+
+func (h *testTokenInfoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Header.Get("Authorization") {
+	case "Bearer valid":
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"uid":"foo","scope":["uid","cn"],"realm":"/services","client_id":"bar","token_type":"Bearer","expires_in":3600}`)
+	case "Bearer broken":
+		w.WriteHeader(http.StatusGatewayTimeout)
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_token","error_description":"Access Token not valid"}`)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(new(testTokenInfoHandler), nil)
+	for _, test := range []struct {
+		method   string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{"GET", "valid", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{"POST", "", http.StatusBadRequest, `{"error":"invalid_request","error_description":"Access Token not valid"}` + "\n"},
+		{"POST", "invalid", http.StatusOK, `{"active":false}` + "\n"},
+		{"POST", "broken", http.StatusGatewayTimeout, "Gateway Timeout\n"},
+		{"POST", "valid", http.StatusOK, `{"active":true,"scope":"uid cn","client_id":"bar","username":"foo","token_type":"Bearer","exp":`},
+	} {
+		w := httptest.NewRecorder()
+		body := url.Values{"token": {test.token}}.Encode()
+		r, _ := http.NewRequest(test.method, "http://example.com/oauth2/introspect", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for token %q. Wanted %d, got %d", test.token, test.wantCode, w.Code)
+		}
+
+		if !strings.HasPrefix(w.Body.String(), test.wantBody) {
+			t.Errorf("Wrong response body for token %q. Wanted %q, got %q", test.token, test.wantBody, w.Body.String())
+		}
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	h := NewHandler(new(testTokenInfoHandler), map[string]string{"client": "secret"})
+	for _, test := range []struct {
+		user     string
+		password string
+		wantCode int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"client", "wrong", http.StatusUnauthorized},
+		{"client", "secre", http.StatusUnauthorized},
+		{"client", "secrets", http.StatusUnauthorized},
+		{"client", "", http.StatusUnauthorized},
+		{"unknown", "secret", http.StatusUnauthorized},
+		{"client", "secret", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://example.com/oauth2/introspect", strings.NewReader("token=valid"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for client %q. Wanted %d, got %d", test.user, test.wantCode, w.Code)
+		}
+
+		if test.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Error("Missing WWW-Authenticate header for failed client authentication")
+		}
+	}
+}
@@ -2,7 +2,7 @@ package jwks
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/zalando/planb-tokeninfo/keyloader"
@@ -22,6 +22,6 @@ func (h *jwksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wrapper := &jwksWrapper{keys: h.loader.Keys()}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(wrapper); err != nil {
-		log.Println("Failed to finish JWKS response: ", err)
+		slog.Warn("Failed to finish JWKS response", "error", err)
 	}
 }
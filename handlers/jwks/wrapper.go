@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"math/big"
 	"reflect"
 
@@ -22,12 +23,12 @@ func (j *jwksWrapper) MarshalJSON() ([]byte, error) {
 	for k, v := range j.keys {
 		key, ok := v.(jwk.JSONWebKey)
 		if !ok {
-			fmt.Printf("Key %q is not a JWK\n", k)
+			slog.Warn("Key is not a JWK", "kid", k, "type", reflect.TypeOf(v))
 			return nil, fmt.Errorf("Invalid JWK: %v\n", v)
 		}
 		m, err := fromJwk(key)
 		if err != nil {
-			fmt.Printf("Failed to convert the JWK %v to a map: %v\n", key, err)
+			slog.Warn("Failed to convert the JWK to a map", "kid", key.KeyID, "error", err)
 			return nil, err
 		}
 		keys[i] = m
@@ -0,0 +1,65 @@
+// Package limits rejects requests with an oversized URL, body or access token before they are parsed any
+// further. Without it a multi-megabyte "token" is hashed, decoded and possibly sent to the upstream
+package limits
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+// Limits are the maximum sizes in bytes of the parts of a request. A zero limit is disabled
+type Limits struct {
+	TokenLength int
+	URLLength   int
+	BodySize    int64
+}
+
+type handler struct {
+	next   http.Handler
+	limits Limits
+}
+
+// NewHandler returns an http.Handler that calls next for requests within the limits. Requests with a longer
+// URL are rejected with status 414, requests with a bigger body with status 413 and requests with a longer
+// access token with status 400. Form bodies are read here, so that their size is checked before the access
+// token is looked for
+func NewHandler(next http.Handler, limits Limits) http.Handler {
+	return &handler{next: next, limits: limits}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.limits.URLLength > 0 && len(req.URL.RequestURI()) > h.limits.URLLength {
+		reject(w, req, "url", tokeninfo.ErrURITooLong)
+		return
+	}
+	if h.limits.BodySize > 0 && req.Body != nil {
+		if req.ContentLength > h.limits.BodySize {
+			reject(w, req, "body", tokeninfo.ErrRequestTooLarge)
+			return
+		}
+		// bodies without a Content-Length are cut off while they are read
+		req.Body = http.MaxBytesReader(w, req.Body, h.limits.BodySize)
+		if err := req.ParseForm(); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				reject(w, req, "body", tokeninfo.ErrRequestTooLarge)
+				return
+			}
+		}
+	}
+	if h.limits.TokenLength > 0 && len(tokeninfo.AccessTokenFromRequest(req)) > h.limits.TokenLength {
+		reject(w, req, "token", tokeninfo.ErrInvalidRequest)
+		return
+	}
+	h.next.ServeHTTP(w, req)
+}
+
+func reject(w http.ResponseWriter, req *http.Request, limit string, e tokeninfo.Error) {
+	e.Write(w, req)
+	if c, ok := metrics.DefaultRegistry.GetOrRegister("planb.limits.rejected."+limit, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
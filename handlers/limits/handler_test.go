@@ -0,0 +1,83 @@
+package limits
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHandler(t *testing.T) {
+	var token string
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token = req.FormValue("access_token")
+	})
+	h := NewHandler(next, Limits{TokenLength: 16, URLLength: 64, BodySize: 32})
+	long := strings.Repeat("x", 17)
+	for _, test := range []struct {
+		name      string
+		method    string
+		url       string
+		body      string
+		auth      string
+		chunked   bool
+		wantCode  int
+		wantLimit string
+		wantToken string
+	}{
+		{"query", "GET", "/oauth2/tokeninfo?access_token=foo", "", "", false, http.StatusOK, "", "foo"},
+		{"long query", "GET", "/oauth2/tokeninfo?access_token=" + long, "", "", false, http.StatusBadRequest, "token", ""},
+		{"long header", "GET", "/oauth2/tokeninfo", "", "Bearer " + long, false, http.StatusBadRequest, "token", ""},
+		{"long URL", "GET", "/oauth2/tokeninfo?access_token=foo&x=" + strings.Repeat("y", 64), "", "", false, http.StatusRequestURITooLong, "url", ""},
+		{"form", "POST", "/oauth2/tokeninfo", "access_token=foo", "", false, http.StatusOK, "", "foo"},
+		{"long form", "POST", "/oauth2/tokeninfo", "access_token=" + long, "", false, http.StatusBadRequest, "token", ""},
+		{"big form", "POST", "/oauth2/tokeninfo", "access_token=foo&x=" + strings.Repeat("y", 32), "", false, http.StatusRequestEntityTooLarge, "body", ""},
+		{"big chunked form", "POST", "/oauth2/tokeninfo", "access_token=foo&x=" + strings.Repeat("y", 32), "", true, http.StatusRequestEntityTooLarge, "body", ""},
+	} {
+		var before int64
+		if test.wantLimit != "" {
+			before = metrics.GetOrRegisterCounter("planb.limits.rejected."+test.wantLimit, metrics.DefaultRegistry).Count()
+		}
+		token = ""
+		var body io.Reader = strings.NewReader(test.body)
+		if test.chunked {
+			// hides the length of the body
+			body = io.MultiReader(body)
+		}
+		r, _ := http.NewRequest(test.method, "http://example.com"+test.url, body)
+		if test.method == "POST" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d", test.name, test.wantCode, w.Code)
+		}
+		if token != test.wantToken {
+			t.Errorf("Wrong token forwarded for %s. Wanted %q, got %q", test.name, test.wantToken, token)
+		}
+		if test.wantLimit != "" {
+			if c := metrics.GetOrRegisterCounter("planb.limits.rejected."+test.wantLimit, metrics.DefaultRegistry).Count(); c != before+1 {
+				t.Errorf("The rejection of %s should be counted once, got %d", test.name, c-before)
+			}
+		}
+	}
+}
+
+func TestDisabledLimits(t *testing.T) {
+	called := false
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { called = true }), Limits{})
+	r, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo", strings.NewReader("access_token="+strings.Repeat("x", 1<<16)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if !called {
+		t.Error("Requests should not be limited with zero limits")
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+type endpointHandler struct {
+	handler  http.Handler
+	prefix   string
+	registry metrics.Registry
+}
+
+// NewEndpointHandler returns an http.Handler that counts the responses of h by status class, for ex.
+// planb.endpoint./oauth2/tokeninfo.2xx for the endpoint /oauth2/tokeninfo
+func NewEndpointHandler(h http.Handler, endpoint string) http.Handler {
+	return &endpointHandler{handler: h, prefix: "planb.endpoint." + endpoint + ".", registry: metrics.DefaultRegistry}
+}
+
+func (h *endpointHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	h.handler.ServeHTTP(sw, req)
+	key := fmt.Sprintf("%s%dxx", h.prefix, sw.status/100)
+	if c, ok := h.registry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+// statusWriter keeps the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+func TestEndpointHandler(t *testing.T) {
+	defer func(nilMetrics bool) { gometrics.UseNilMetrics = nilMetrics }(gometrics.UseNilMetrics)
+	gometrics.UseNilMetrics = false
+	r := gometrics.NewRegistry()
+	for _, test := range []struct {
+		status  int
+		wantKey string
+	}{
+		{0, "planb.endpoint./test.2xx"},
+		{http.StatusNoContent, "planb.endpoint./test.2xx"},
+		{http.StatusUnauthorized, "planb.endpoint./test.4xx"},
+		{http.StatusServiceUnavailable, "planb.endpoint./test.5xx"},
+	} {
+		c := gometrics.GetOrRegisterCounter(test.wantKey, r)
+		before := c.Count()
+		h := &endpointHandler{prefix: "planb.endpoint./test.", registry: r, handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if test.status != 0 {
+				w.WriteHeader(test.status)
+			}
+			w.Write([]byte("ok"))
+		})}
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+		h.ServeHTTP(rw, req)
+		if c.Count() != before+1 {
+			t.Errorf("Response with status %d should be counted in %s", test.status, test.wantKey)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/latency"
+)
+
+type prometheusHandler struct {
+	registry metrics.Registry
+}
+
+var (
+	// DefaultPrometheus is a global instance of the Prometheus metrics handler using the metrics default registry
+	DefaultPrometheus = PrometheusHandler(metrics.DefaultRegistry)
+
+	prometheusQuantiles = []float64{0.5, 0.75, 0.95, 0.99}
+)
+
+// PrometheusHandler creates an http.Handler that returns metrics registry r in the Prometheus text
+// exposition format. Counters and meters are exported as counters, gauges as gauges, latency histograms
+// as histograms and both timers and other histograms as summaries. Timers are converted to seconds
+func PrometheusHandler(r metrics.Registry) http.Handler {
+	return &prometheusHandler{registry: r}
+}
+
+// ServeHTTP returns status 200 and writes metrics from the registry in the Prometheus text format
+func (h *prometheusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := make(map[string]interface{})
+	h.registry.Each(func(name string, i interface{}) {
+		m[name] = i
+	})
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		writePrometheusMetric(buf, prometheusName(name), m[name])
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func writePrometheusMetric(buf *bytes.Buffer, name string, i interface{}) {
+	switch metric := i.(type) {
+	case metrics.Counter:
+		writeSample(buf, name+"_total", "counter", float64(metric.Count()))
+	case metrics.Meter:
+		writeSample(buf, name+"_total", "counter", float64(metric.Snapshot().Count()))
+	case metrics.Gauge:
+		writeSample(buf, name, "gauge", float64(metric.Value()))
+	case metrics.GaugeFloat64:
+		writeSample(buf, name, "gauge", metric.Value())
+	case *latency.Histogram:
+		writeHistogram(buf, name+"_seconds", metric)
+	case metrics.Histogram:
+		h := metric.Snapshot()
+		writeSummary(buf, name, h.Percentiles(prometheusQuantiles), float64(h.Sum()), h.Count(), 1)
+	case metrics.Timer:
+		t := metric.Snapshot()
+		writeSummary(buf, name+"_seconds", t.Percentiles(prometheusQuantiles), float64(t.Sum()), t.Count(), 1e9)
+	}
+}
+
+func writeSample(buf *bytes.Buffer, name string, kind string, value float64) {
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(buf, "%s %g\n", name, value)
+}
+
+func writeSummary(buf *bytes.Buffer, name string, percentiles []float64, sum float64, count int64, scale float64) {
+	fmt.Fprintf(buf, "# TYPE %s summary\n", name)
+	for i, q := range prometheusQuantiles {
+		fmt.Fprintf(buf, "%s{quantile=\"%g\"} %g\n", name, q, percentiles[i]/scale)
+	}
+	fmt.Fprintf(buf, "%s_sum %g\n", name, sum/scale)
+	fmt.Fprintf(buf, "%s_count %d\n", name, count)
+}
+
+func writeHistogram(buf *bytes.Buffer, name string, h *latency.Histogram) {
+	cumulative, count, sum := h.Buckets()
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for i, le := range latency.Buckets {
+		fmt.Fprintf(buf, "%s_bucket{le=\"%g\"} %d\n", name, le, cumulative[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(buf, "%s_sum %g\n", name, sum)
+	fmt.Fprintf(buf, "%s_count %d\n", name, count)
+}
+
+// prometheusName converts a metric name like planb.tokeninfo.jwt./services.requests to a valid
+// Prometheus metric name like planb_tokeninfo_jwt__services_requests
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
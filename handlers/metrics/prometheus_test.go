@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/latency"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	gometrics.UseNilMetrics = false
+	r := gometrics.NewRegistry()
+	c := gometrics.NewRegisteredCounter("planb.tokeninfo.proxy.cache.hits", r)
+	c.Inc(3)
+	g := gometrics.NewRegisteredGauge("planb.openidprovider.numkeys", r)
+	g.Update(2)
+	tm := gometrics.NewRegisteredTimer("planb.tokeninfo.jwt./services.requests", r)
+	tm.Update(2 * time.Second)
+	h := latency.NewHistogram()
+	r.Register("planb.tokeninfo.proxy.upstream.latency", h)
+	h.Update(int64(20 * time.Millisecond))
+	h.Update(int64(time.Minute))
+
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/metrics/prometheus", nil)
+	PrometheusHandler(r).ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("Metrics endpoint responded with wrong status code = %d", rw.Code)
+	}
+
+	for _, want := range []string{
+		"# TYPE planb_openidprovider_numkeys gauge\nplanb_openidprovider_numkeys 2\n",
+		"# TYPE planb_tokeninfo_jwt__services_requests_seconds summary\n",
+		"planb_tokeninfo_jwt__services_requests_seconds{quantile=\"0.5\"} 2\n",
+		"planb_tokeninfo_jwt__services_requests_seconds_sum 2\n",
+		"planb_tokeninfo_jwt__services_requests_seconds_count 1\n",
+		"# TYPE planb_tokeninfo_proxy_cache_hits_total counter\nplanb_tokeninfo_proxy_cache_hits_total 3\n",
+		"# TYPE planb_tokeninfo_proxy_upstream_latency_seconds histogram\n",
+		"planb_tokeninfo_proxy_upstream_latency_seconds_bucket{le=\"0.01\"} 0\n",
+		"planb_tokeninfo_proxy_upstream_latency_seconds_bucket{le=\"0.025\"} 1\n",
+		"planb_tokeninfo_proxy_upstream_latency_seconds_bucket{le=\"10\"} 1\n",
+		"planb_tokeninfo_proxy_upstream_latency_seconds_bucket{le=\"+Inf\"} 2\n",
+		"planb_tokeninfo_proxy_upstream_latency_seconds_sum 60.02\n",
+		"planb_tokeninfo_proxy_upstream_latency_seconds_count 2\n",
+	} {
+		if !strings.Contains(rw.Body.String(), want) {
+			t.Errorf("Metrics response is missing %q. Got %q", want, rw.Body.String())
+		}
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"planb.tokeninfo.proxy", "planb_tokeninfo_proxy"},
+		{"planb.tokeninfo.jwt./services.requests", "planb_tokeninfo_jwt__services_requests"},
+		{"planb.breaker.load-keys", "planb_breaker_load_keys"},
+	} {
+		if n := prometheusName(test.name); n != test.want {
+			t.Errorf("Wrong Prometheus name for %q. Wanted %q, got %q", test.name, test.want, n)
+		}
+	}
+}
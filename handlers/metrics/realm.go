@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// maxRealms limits the number of realm counters, the tokens of more realms are counted as otherRealm
+	maxRealms  = 100
+	otherRealm = "other"
+	// responses bigger than this are not inspected for the realm and scopes
+	maxInspectedBody = 64 * 1024
+)
+
+type realmHandler struct {
+	next     http.Handler
+	realms   bool
+	scopes   map[string]bool
+	registry metrics.Registry
+
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+// NewRealmHandler returns an http.Handler that counts the valid tokens of the token info responses of next
+// by realm, if realms is set, and by each of the scopes, for ex. planb.tokeninfo.realms./services and
+// planb.tokeninfo.scopes.uid. Other scopes are not counted, they are too many
+func NewRealmHandler(next http.Handler, realms bool, scopes []string) http.Handler {
+	h := &realmHandler{next: next, realms: realms, scopes: make(map[string]bool, len(scopes)), registry: metrics.DefaultRegistry,
+		known: make(map[string]bool)}
+	for _, s := range scopes {
+		h.scopes[s] = true
+	}
+	return h
+}
+
+func (h *realmHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	bw := &bodyWriter{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(bw, req)
+	if bw.status != http.StatusOK || bw.truncated || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return
+	}
+	var ti struct {
+		Realm string   `json:"realm"`
+		Scope []string `json:"scope"`
+	}
+	if json.Unmarshal(bw.body.Bytes(), &ti) != nil {
+		return
+	}
+	if h.realms && ti.Realm != "" {
+		h.inc("planb.tokeninfo.realms." + h.realm(ti.Realm))
+	}
+	for _, s := range ti.Scope {
+		if h.scopes[s] {
+			h.inc("planb.tokeninfo.scopes." + s)
+		}
+	}
+}
+
+// realm returns the realm or otherRealm once there are maxRealms other realms
+func (h *realmHandler) realm(realm string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.known[realm] {
+		if len(h.known) >= maxRealms {
+			return otherRealm
+		}
+		h.known[realm] = true
+	}
+	return realm
+}
+
+func (h *realmHandler) inc(key string) {
+	if c, ok := h.registry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+// bodyWriter keeps the status and the body of the response, up to maxInspectedBody
+type bodyWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *bodyWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	if w.body.Len()+len(b) <= maxInspectedBody {
+		w.body.Write(b)
+	} else {
+		w.truncated = true
+	}
+	return w.ResponseWriter.Write(b)
+}
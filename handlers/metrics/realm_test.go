@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+func TestRealmHandler(t *testing.T) {
+	defer func(nilMetrics bool) { gometrics.UseNilMetrics = nilMetrics }(gometrics.UseNilMetrics)
+	gometrics.UseNilMetrics = false
+	r := gometrics.NewRegistry()
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		if req.URL.Query().Get("access_token") == "invalid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_token"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"uid":"foo","realm":%q,"scope":["uid","cn","team"]}`, req.URL.Query().Get("realm"))
+	})
+	h := NewRealmHandler(next, true, []string{"uid", "team"}).(*realmHandler)
+	h.registry = r
+
+	for _, query := range []string{"realm=/services", "realm=/services", "realm=/employees", "access_token=invalid&realm=/services"} {
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?"+query, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for name, want := range map[string]int64{
+		"planb.tokeninfo.realms./services":  2,
+		"planb.tokeninfo.realms./employees": 1,
+		"planb.tokeninfo.scopes.uid":        3,
+		"planb.tokeninfo.scopes.team":       3,
+		"planb.tokeninfo.scopes.cn":         0,
+	} {
+		var got int64
+		if c, ok := r.Get(name).(gometrics.Counter); ok {
+			got = c.Count()
+		}
+		if got != want {
+			t.Errorf("Wrong count of %s. Wanted %d, got %d", name, want, got)
+		}
+	}
+
+	for i := 0; i < maxRealms; i++ {
+		h.realm(fmt.Sprintf("/realm%d", i))
+	}
+	if got := h.realm("/new"); got != otherRealm {
+		t.Errorf("Realms beyond the limit should be counted as %s, got %s", otherRealm, got)
+	}
+	if got := h.realm("/services"); got != "/services" {
+		t.Errorf("Known realms should still be counted, got %s", got)
+	}
+}
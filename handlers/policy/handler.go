@@ -0,0 +1,201 @@
+// Package policy delegates authorization decisions about validated tokens to an Open Policy Agent, so that
+// rules like "service tokens may not call employee endpoints" are enforced in one place
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+// maxBodySize limits the size of the decisions of the policy agent
+const maxBodySize = 1 << 20
+
+// ErrUndefinedDecision is returned when the policy has no decision for the input
+var ErrUndefinedDecision = errors.New("Undefined policy decision")
+
+// hiddenHeaders are never sent to the policy agent
+var hiddenHeaders = map[string]bool{"Authorization": true, "Cookie": true, "X-Api-Key": true}
+
+// Config is the policy agent and how to handle its failures
+type Config struct {
+	// URL is the OPA Data API document with the decision, for ex. http://localhost:8181/v1/data/tokeninfo/decision
+	URL     *url.URL
+	Timeout time.Duration
+	// FailOpen allows the tokens when the policy agent can't be reached. By default they are rejected
+	FailOpen bool
+}
+
+// Input is the document the policy decides on
+type Input struct {
+	TokenInfo map[string]interface{} `json:"tokeninfo"`
+	Request   Request                `json:"request"`
+}
+
+// Request is the context of the token info request. Credentials are left out of the headers
+type Request struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Host       string            `json:"host"`
+	RemoteAddr string            `json:"remote_addr"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// Decision is the result of the policy. It can be a boolean or an object with these fields. Annotations are
+// added to the token info response of allowed tokens
+type Decision struct {
+	Allow       bool                   `json:"allow"`
+	Reason      string                 `json:"reason,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// UnmarshalJSON reads boolean decisions as well as objects
+func (d *Decision) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &d.Allow); err == nil {
+		return nil
+	}
+	type decision Decision
+	return json.Unmarshal(b, (*decision)(d))
+}
+
+type policyHandler struct {
+	handler  http.Handler
+	url      string
+	client   *http.Client
+	failOpen bool
+}
+
+// NewHandler returns an http.Handler that asks the policy agent about every token validated by h, with the
+// token info and the request. Tokens rejected by h don't reach the policy
+func NewHandler(h http.Handler, c Config) http.Handler {
+	return &policyHandler{
+		handler:  h,
+		url:      c.URL.String(),
+		client:   &http.Client{Timeout: c.Timeout, Transport: ht.NewTransport()},
+		failOpen: c.FailOpen,
+	}
+}
+
+// ServeHTTP sends the token info response of the wrapped handler if the policy allows it
+func (h *policyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rec := tokeninfo.NewResponseRecorder()
+	h.handler.ServeHTTP(rec, req)
+	if rec.Status != http.StatusOK {
+		rec.WriteResponse(w)
+		return
+	}
+
+	input := Input{Request: newRequest(req)}
+	if err := json.Unmarshal(rec.Body.Bytes(), &input.TokenInfo); err != nil {
+		// only JSON token info responses can be checked
+		slog.Warn("Failed to read the token info for the policy", "error", err)
+		incCounter("planb.policy.errors")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	start := time.Now()
+	d, err := h.decide(req, &input)
+	if t, ok := metrics.DefaultRegistry.GetOrRegister("planb.policy.requests", metrics.NewTimer).(metrics.Timer); ok {
+		t.UpdateSince(start)
+	}
+	switch {
+	case err != nil && h.failOpen:
+		slog.Warn("Policy decision failed, allowing the token", "error", err)
+		incCounter("planb.policy.errors")
+		rec.WriteResponse(w)
+	case err != nil:
+		slog.Warn("Policy decision failed", "error", err)
+		incCounter("planb.policy.errors")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	case !d.Allow:
+		incCounter("planb.policy.denied")
+		e := tokeninfo.ErrInsufficientScope
+		if d.Reason != "" {
+			e.ErrorDescription = d.Reason
+		}
+		e.Write(w, req)
+	case len(d.Annotations) > 0:
+		incCounter("planb.policy.allowed")
+		for k, v := range d.Annotations {
+			input.TokenInfo[k] = v
+		}
+		rec.Body.Reset()
+		if err := json.NewEncoder(rec.Body).Encode(input.TokenInfo); err != nil {
+			slog.Warn("Failed to annotate the token info", "error", err)
+		}
+		rec.Header().Del("Content-Length")
+		rec.WriteResponse(w)
+	default:
+		incCounter("planb.policy.allowed")
+		rec.WriteResponse(w)
+	}
+}
+
+// decide queries the OPA Data API with the input. An undefined decision is an error
+func (h *policyHandler) decide(req *http.Request, input *Input) (*Decision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", ht.UserAgent)
+	requestid.Inject(req.Context(), r.Header)
+	resp, err := h.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Policy agent returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Result *Decision `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Result == nil {
+		return nil, ErrUndefinedDecision
+	}
+	return result.Result, nil
+}
+
+// newRequest returns the context of req for the policy input
+func newRequest(req *http.Request) Request {
+	r := Request{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Headers:    make(map[string]string),
+	}
+	for k, v := range req.Header {
+		if !hiddenHeaders[k] {
+			r.Headers[strings.ToLower(k)] = strings.Join(v, ",")
+		}
+	}
+	return r
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var tokenInfoHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	switch req.Header.Get("Authorization") {
+	case "Bearer employee":
+		fmt.Fprint(w, `{"uid":"jdoe","realm":"/employees","scope":["uid"]}`)
+	case "Bearer service":
+		fmt.Fprint(w, `{"uid":"stups_svc","realm":"/services","scope":["uid"]}`)
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_token","error_description":"Access Token not valid"}`)
+	}
+})
+
+func TestHandler(t *testing.T) {
+	var inputs []Input
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Input Input `json:"input"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		inputs = append(inputs, body.Input)
+		switch {
+		case body.Input.Request.Headers["x-original-uri"] == "/undefined":
+			fmt.Fprint(w, `{}`)
+		case body.Input.Request.Headers["x-original-uri"] == "/bool":
+			fmt.Fprint(w, `{"result": true}`)
+		case body.Input.TokenInfo["realm"] == "/services" && body.Input.Request.Headers["x-original-uri"] == "/employees":
+			fmt.Fprint(w, `{"result": {"allow": false, "reason": "Service tokens may not call employee endpoints"}}`)
+		default:
+			fmt.Fprint(w, `{"result": {"allow": true, "annotations": {"tenant": "acme"}}}`)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	h := NewHandler(tokenInfoHandler, Config{URL: u, Timeout: time.Second})
+
+	for _, test := range []struct {
+		token      string
+		uri        string
+		wantCode   int
+		wantBody   string
+		wantInputs int
+	}{
+		{"invalid", "/employees", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"Access Token not valid"}`, 0},
+		{"employee", "/employees", http.StatusOK, `{"realm":"/employees","scope":["uid"],"tenant":"acme","uid":"jdoe"}` + "\n", 1},
+		{"service", "/employees", http.StatusForbidden, `{"error":"insufficient_scope","error_description":"Service tokens may not call employee endpoints"}` + "\n", 2},
+		{"service", "/bool", http.StatusOK, `{"uid":"stups_svc","realm":"/services","scope":["uid"]}`, 3},
+		{"service", "/undefined", http.StatusServiceUnavailable, "Service Unavailable\n", 4},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com/oauth2/tokeninfo", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		r.Header.Set("X-Original-URI", test.uri)
+		h.ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s on %s. Wanted %d, got %d", test.token, test.uri, test.wantCode, w.Code)
+		}
+		if w.Body.String() != test.wantBody {
+			t.Errorf("Wrong body for %s on %s. Wanted %s, got %s", test.token, test.uri, test.wantBody, w.Body)
+		}
+		if len(inputs) != test.wantInputs {
+			t.Errorf("Wrong number of policy queries for %s on %s. Wanted %d, got %d", test.token, test.uri, test.wantInputs, len(inputs))
+		}
+	}
+
+	in := inputs[0]
+	if in.Request.Method != http.MethodGet || in.Request.Path != "/oauth2/tokeninfo" || in.TokenInfo["uid"] != "jdoe" {
+		t.Errorf("Wrong policy input: %+v", in)
+	}
+	if _, has := in.Request.Headers["authorization"]; has {
+		t.Error("The Authorization header should not be sent to the policy agent")
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	for _, test := range []struct {
+		failOpen bool
+		wantCode int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com/oauth2/tokeninfo", nil)
+		r.Header.Set("Authorization", "Bearer employee")
+		NewHandler(tokenInfoHandler, Config{URL: u, Timeout: time.Second, FailOpen: test.failOpen}).ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code with fail open %v. Wanted %d, got %d", test.failOpen, test.wantCode, w.Code)
+		}
+	}
+}
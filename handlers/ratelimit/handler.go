@@ -0,0 +1,150 @@
+// Package ratelimit implements a token bucket rate limiting middleware with a global limit and limits
+// per client IP and per access token
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+// idle buckets are removed after this long, by then they are full again anyway
+const sweepInterval = time.Minute
+
+// Limits are the allowed requests per second. A zero limit is disabled. Every limit allows bursts of
+// one second worth of requests
+type Limits struct {
+	Global   float64
+	PerIP    float64
+	PerToken float64
+}
+
+type handler struct {
+	next     http.Handler
+	global   *limiter
+	perIP    *limiter
+	perToken *limiter
+}
+
+var now = time.Now
+
+// contextKey holds the handler of a request in its context, for Charge
+type contextKey struct{}
+
+// NewHandler returns an http.Handler that calls next unless one of the limits was exceeded, in which
+// case it responds with status 429 and a Retry-After header. Tokens are identified by their hash
+func NewHandler(next http.Handler, limits Limits) http.Handler {
+	return &handler{
+		next:     next,
+		global:   newLimiter(limits.Global),
+		perIP:    newLimiter(limits.PerIP),
+		perToken: newLimiter(limits.PerToken),
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.charge(w, req) {
+		h.next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, h)))
+	}
+}
+
+// Charge takes one more request from the limits of req, for requests that cost more than others, for ex.
+// because they skip the cache. It responds with status 429 and returns false when a limit was exceeded.
+// Requests without rate limits are always allowed
+func Charge(w http.ResponseWriter, req *http.Request) bool {
+	h, ok := req.Context().Value(contextKey{}).(*handler)
+	return !ok || h.charge(w, req)
+}
+
+// charge takes a request from every limit of req, or responds with status 429 and returns false
+func (h *handler) charge(w http.ResponseWriter, req *http.Request) bool {
+	t := now()
+	if wait := h.perIP.take(clientip.FromRequest(req), t); wait > 0 {
+		reject(w, req, "ip", wait)
+		return false
+	}
+	if token := tokeninfo.AccessTokenFromRequest(req); token != "" {
+		if wait := h.perToken.take(tokeninfo.HashToken(token), t); wait > 0 {
+			reject(w, req, "token", wait)
+			return false
+		}
+	}
+	if wait := h.global.take("", t); wait > 0 {
+		reject(w, req, "global", wait)
+		return false
+	}
+	return true
+}
+
+func reject(w http.ResponseWriter, req *http.Request, limit string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	tokeninfo.ErrRateLimited.Write(w, req)
+	if c, ok := metrics.DefaultRegistry.GetOrRegister("planb.ratelimit.rejected."+limit, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps one token bucket per key
+type limiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{rate: rate, burst: math.Max(1, math.Ceil(rate)), buckets: make(map[string]*bucket)}
+}
+
+// take removes a token from the bucket of key and returns 0, or returns how long it takes until a token
+// is available when the bucket is empty. A nil limiter always allows the request
+func (l *limiter) take(key string, t time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(t)
+	b, has := l.buckets[key]
+	if !has {
+		b = &bucket{tokens: l.burst, last: t}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+t.Sub(b.last).Seconds()*l.rate)
+	b.last = t
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// sweep removes the buckets that were not used for a while
+func (l *limiter) sweep(t time.Time) {
+	if t.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	for key, b := range l.buckets {
+		if t.Sub(b.last) >= sweepInterval {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = t
+}
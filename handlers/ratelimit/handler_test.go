@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/clientip"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func request(h http.Handler, ip string, token string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+	r.RemoteAddr = ip + ":12345"
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	h.ServeHTTP(rw, r)
+	return rw
+}
+
+func TestHandler(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	for _, test := range []struct {
+		name   string
+		limits Limits
+		calls  []string
+		want   []int
+	}{
+		{"disabled", Limits{}, []string{"a/t1", "a/t1", "a/t1"}, []int{200, 200, 200}},
+		{"global", Limits{Global: 2}, []string{"a/t1", "b/t2", "c/t3"}, []int{200, 200, 429}},
+		{"per ip", Limits{PerIP: 1}, []string{"a/t1", "a/t2", "b/t1"}, []int{200, 429, 200}},
+		{"per token", Limits{PerToken: 1}, []string{"a/t1", "b/t1", "b/t2", "b/"}, []int{200, 429, 200, 200}},
+	} {
+		h := NewHandler(okHandler, test.limits)
+		for i, call := range test.calls {
+			ipToken := strings.SplitN(call, "/", 2)
+			rw := request(h, ipToken[0], ipToken[1])
+			if rw.Code != test.want[i] {
+				t.Errorf("Wrong status code for %s call %d. Wanted %d, got %d", test.name, i, test.want[i], rw.Code)
+			}
+			if rw.Code == http.StatusTooManyRequests && rw.Header().Get("Retry-After") != "1" {
+				t.Errorf("Wrong Retry-After for %s call %d: %q", test.name, i, rw.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
+
+func TestRefill(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	h := NewHandler(okHandler, Limits{PerIP: 0.1})
+	if rw := request(h, "a", ""); rw.Code != http.StatusOK {
+		t.Fatalf("First request should be allowed, got %d", rw.Code)
+	}
+	rw := request(h, "a", "")
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") != "10" {
+		t.Errorf("Wrong response for the limited request: %d, Retry-After %q", rw.Code, rw.Header().Get("Retry-After"))
+	}
+
+	now = func() time.Time { return start.Add(4 * time.Second) }
+	if rw := request(h, "a", ""); rw.Header().Get("Retry-After") != "6" {
+		t.Errorf("Wrong Retry-After after a partial refill: %q", rw.Header().Get("Retry-After"))
+	}
+
+	now = func() time.Time { return start.Add(10 * time.Second) }
+	if rw := request(h, "a", ""); rw.Code != http.StatusOK {
+		t.Errorf("Request should be allowed after the bucket refilled, got %d", rw.Code)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	charged := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if Charge(w, req) {
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	h := NewHandler(charged, Limits{PerToken: 3})
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		if rw := request(h, "a", "t1"); rw.Code != want {
+			t.Errorf("Wrong status code for call %d. Wanted %d, got %d", i, want, rw.Code)
+		}
+	}
+	if rw := request(charged, "a", "t1"); rw.Code != http.StatusOK {
+		t.Errorf("Requests without rate limits should be allowed, got %d", rw.Code)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := newLimiter(1)
+	start := time.Now()
+	l.take("a", start)
+	l.take("b", start.Add(sweepInterval/2))
+	l.take("c", start.Add(sweepInterval))
+	if _, has := l.buckets["a"]; has || len(l.buckets) != 2 {
+		t.Errorf("Idle buckets should be removed: %v", l.buckets)
+	}
+}
+
+func TestPerIPBehindProxy(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Now()
+	now = func() time.Time { return start }
+
+	h := clientip.NewHandler(NewHandler(okHandler, Limits{PerIP: 1}), []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	for i, test := range []struct {
+		forwardedFor string
+		want         int
+	}{
+		{"192.0.2.1", http.StatusOK},
+		{"192.0.2.2", http.StatusOK},
+		{"192.0.2.1", http.StatusTooManyRequests},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		r.RemoteAddr = "10.0.0.1:12345"
+		r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		h.ServeHTTP(rw, r)
+		if rw.Code != test.want {
+			t.Errorf("Wrong status code for call %d from %s. Wanted %d, got %d", i, test.forwardedFor, test.want, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package revocations implements the endpoint where the identity provider pushes revocations, so that
+// they apply immediately instead of with the next poll of the Revocation Provider
+package revocations
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// maxBodySize limits the size of pushed revocation documents
+const maxBodySize = 1 << 20
+
+type revocationsHandler struct {
+	crp     *revoke.CachingRevokeProvider
+	cache   tokencache.Cache
+	clients map[string]string
+}
+
+// NewHandler returns an http.Handler that adds the revocations POSTed to it to the revocations of crp. The
+// body has the same format as the responses of the Revocation Provider:
+//
+//	{"revocations": [{"type": "TOKEN", "data": {"token_hash": "..."}}, {"type": "GLOBAL", "data": {}}]}
+//
+// GLOBAL revocations also purge the upstream token info cache, which may be nil, with the UserInfo responses
+// stored in it. Callers must authenticate with HTTP Basic authentication using one of the client ID/secret
+// pairs in clients; without clients every call is rejected
+func NewHandler(crp *revoke.CachingRevokeProvider, cache tokencache.Cache, clients map[string]string) http.Handler {
+	return &revocationsHandler{crp: crp, cache: cache, clients: clients}
+}
+
+// ServeHTTP adds the revocations in the body of the request. Nothing is added if any of them is invalid
+func (h *revocationsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	client, ok := h.authenticate(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="revocations"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		incCounter("planb.tokeninfo.revocation.push.unauthorized")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read the revocations", http.StatusBadRequest)
+		return
+	}
+	revs, err := h.crp.AddRevocations(body)
+	if err != nil {
+		slog.Warn("Rejected pushed revocations", "client", client, "error", err)
+		incCounter("planb.tokeninfo.revocation.push.invalid")
+		http.Error(w, "Invalid revocations", http.StatusBadRequest)
+		return
+	}
+
+	slog.Info("Revocations pushed", "client", client, "revocations", len(revs))
+	for _, r := range revs {
+		incCounter("planb.tokeninfo.revocation.push." + r.Type)
+		if r.Type == revoke.REVOCATION_TYPE_GLOBAL && h.cache != nil {
+			if err := h.cache.Purge(); err != nil {
+				slog.Warn("Failed to purge the upstream token info cache", "error", err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *revocationsHandler) authenticate(req *http.Request) (string, bool) {
+	client, secret, ok := req.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	return client, tokeninfo.CheckSecret(h.clients, client, secret)
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
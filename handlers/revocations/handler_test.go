@@ -0,0 +1,72 @@
+package revocations
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// hash returns the value hash of a claim revocation
+func hash(v string) string {
+	h := sha256.Sum256([]byte(options.Current().HashingSalt + v))
+	return base64.URLEncoding.EncodeToString(h[:])
+}
+
+func TestHandler(t *testing.T) {
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+	cache := tokencache.NewMemoryCache(10)
+	h := NewHandler(crp, cache, map[string]string{"idp": "secret"})
+
+	token := &jwt.Token{Raw: "foo.bar.baz", Claims: jwt.MapClaims{"iat": float64(time.Now().Add(-time.Minute).Unix()), "sub": "jdoe"}}
+	cache.Set("foo", []byte("bar"), time.Minute)
+
+	for _, test := range []struct {
+		method      string
+		user        string
+		password    string
+		body        string
+		wantCode    int
+		wantRevoked bool
+		wantCached  bool
+	}{
+		{"GET", "idp", "secret", "", http.StatusMethodNotAllowed, false, true},
+		{"POST", "", "", `{"revocations":[{"type":"GLOBAL","data":{}}]}`, http.StatusUnauthorized, false, true},
+		{"POST", "idp", "wrong", `{"revocations":[{"type":"GLOBAL","data":{}}]}`, http.StatusUnauthorized, false, true},
+		{"POST", "idp", "secret", `{"revocations":[`, http.StatusBadRequest, false, true},
+		{"POST", "idp", "secret", `{"revocations":[{"type":"FOO","data":{}}]}`, http.StatusBadRequest, false, true},
+		// all or nothing
+		{"POST", "idp", "secret", `{"revocations":[{"type":"CLAIM","data":{"names":["sub"],"value_hash":"` + hash("jdoe") + `"}},{"type":"TOKEN","data":{}}]}`,
+			http.StatusBadRequest, false, true},
+		{"POST", "idp", "secret", `{"revocations":[]}`, http.StatusNoContent, false, true},
+		{"POST", "idp", "secret", `{"revocations":[{"type":"CLAIM","data":{"names":["sub"],"value_hash":"` + hash("jdoe") + `"}}]}`,
+			http.StatusNoContent, true, true},
+		{"POST", "idp", "secret", `{"revocations":[{"type":"GLOBAL","data":{}}]}`, http.StatusNoContent, true, false},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://example.com/revocations", strings.NewReader(test.body))
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		h.ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s %s. Wanted %d, got %d", test.method, test.body, test.wantCode, w.Code)
+		}
+		if revoked := crp.IsJWTRevoked(token); revoked != test.wantRevoked {
+			t.Errorf("Wrong revocation status after %s. Wanted %t, got %t", test.body, test.wantRevoked, revoked)
+		}
+		if _, err := cache.Get("foo"); (err == nil) != test.wantCached {
+			t.Errorf("Wrong cache status after %s. Wanted %t, got %v", test.body, test.wantCached, err)
+		}
+	}
+}
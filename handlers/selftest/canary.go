@@ -0,0 +1,112 @@
+package selftest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	jwthandler "github.com/zalando/planb-tokeninfo/handlers/tokeninfo/jwt"
+)
+
+// canaryTTL is the lifetime of the signed canary tokens, long enough for clock skews between the instances
+const canaryTTL = time.Minute
+
+// DefaultSubject is the sub claim of the signed canary tokens, unless set in the claims
+const DefaultSubject = "planb-selftest"
+
+var errNoPrivateKey = errors.New("no private key found")
+
+// A Canary returns the token that is validated by the self test
+type Canary func() (string, error)
+
+// StaticCanary returns a Canary that always returns token, for ex. a long lived token signed by the issuer
+func StaticCanary(token string) Canary {
+	return func() (string, error) {
+		return token, nil
+	}
+}
+
+// SignedCanary returns a Canary that signs a new token with the PEM encoded private key and the key id kid
+// on every call. The tokens have the realm /services, no scopes, the sub DefaultSubject and the claims, the
+// "scope" claim being a space separated list. They expire after a minute. RSA keys sign with RS256, EC keys
+// with the ES algorithm of their curve and Ed25519 keys with EdDSA
+func SignedCanary(keyPEM []byte, kid string, claims map[string]string) (Canary, error) {
+	key, method, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return func() (string, error) {
+		now := time.Now()
+		c := jwt.MapClaims{
+			jwthandler.JwtClaimSub:   DefaultSubject,
+			jwthandler.JwtClaimRealm: "/services",
+			jwthandler.JwtClaimScope: []string{},
+			"iat":                    now.Unix(),
+			jwthandler.JwtClaimExp:   now.Add(canaryTTL).Unix(),
+		}
+		for k, v := range claims {
+			if k == jwthandler.JwtClaimScope {
+				c[k] = strings.Fields(v)
+			} else {
+				c[k] = v
+			}
+		}
+		t := jwt.NewWithClaims(method, c)
+		t.Header["kid"] = kid
+		return t.SignedString(key)
+	}, nil
+}
+
+// parsePrivateKey returns the first private key of the PEM data and its signing method
+func parsePrivateKey(data []byte) (interface{}, jwt.SigningMethod, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, nil, errNoPrivateKey
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		method, err := signingMethod(key)
+		return key, method, err
+	}
+}
+
+func signingMethod(key interface{}) (jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256, nil
+		case 384:
+			return jwt.SigningMethodES384, nil
+		case 521:
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("Unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jwthandler.SigningMethodEd25519, nil
+	}
+	return nil, fmt.Errorf("Unsupported private key type %T", key)
+}
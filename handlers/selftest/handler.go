@@ -0,0 +1,102 @@
+// Package selftest validates a canary token with the token info handlers, so that synthetic monitoring
+// doesn't need a real token
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+// resultTTL is how long the result of a self test is reused. The endpoint is not authenticated, so that every
+// request signing and validating a token would be cheap to abuse
+var resultTTL = 5 * time.Second
+
+type handler struct {
+	tokenInfo http.Handler
+	canary    Canary
+	ver       string
+	mu        sync.Mutex
+	checked   time.Time
+	err       error
+}
+
+// NewHandler creates an http.Handler that validates the token of canary with the tokenInfo handler, the way
+// a client of /oauth2/tokeninfo would. It returns 200 and the version when the token info response is valid
+// or 503 and the reason otherwise. The result is reused for a few seconds
+func NewHandler(tokenInfo http.Handler, canary Canary, version string) http.Handler {
+	return &handler{tokenInfo: tokenInfo, canary: canary, ver: version}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := h.result(req.Context()); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Self test failed: %v\n%s", err, h.ver)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\n%s", h.ver)
+}
+
+// result returns the result of the last self test while it is younger than resultTTL and runs a new one
+// otherwise. Concurrent requests wait for the same self test
+func (h *handler) result(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.checked) < resultTTL {
+		return h.err
+	}
+	// the result is shared with the next requests, it must not fail because this client is gone
+	err := h.check(context.WithoutCancel(ctx))
+	if err != nil {
+		slog.Warn("Self test failed", "error", err)
+		incCounter("planb.health.selftest.failures")
+	}
+	h.checked, h.err = time.Now(), err
+	return err
+}
+
+// check validates the canary token and the response
+func (h *handler) check(ctx context.Context) error {
+	token, err := h.canary()
+	if err != nil {
+		return fmt.Errorf("failed to get the canary token: %v", err)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/oauth2/tokeninfo", nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Accept", "application/json")
+
+	rec := tokeninfo.NewResponseRecorder()
+	h.tokenInfo.ServeHTTP(rec, r)
+	if rec.Status != http.StatusOK {
+		return fmt.Errorf("token info returned %d: %s", rec.Status, bytes.TrimSpace(rec.Body.Bytes()))
+	}
+	var ti struct {
+		UID       string `json:"uid"`
+		ExpiresIn *int   `json:"expires_in"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ti); err != nil {
+		return fmt.Errorf("invalid token info response: %v", err)
+	}
+	if ti.UID == "" || ti.ExpiresIn == nil {
+		return fmt.Errorf("token info response without uid or expires_in: %s", bytes.TrimSpace(rec.Body.Bytes()))
+	}
+	return nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,142 @@
+package selftest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dgrijalva/jwt-go/request"
+)
+
+func pemKey(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// tokenInfo validates the tokens with the public key, like the JWT token info handler
+func tokenInfo(public interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, err := request.ParseFromRequest(req, request.OAuth2Extractor, func(t *jwt.Token) (interface{}, error) {
+			if t.Header["kid"] != "selftest" {
+				return nil, fmt.Errorf("unknown kid %v", t.Header["kid"])
+			}
+			return public, nil
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"error":"invalid_token","error_description":%q}`, err.Error())
+			return
+		}
+		claims := token.Claims.(jwt.MapClaims)
+		json.NewEncoder(w).Encode(map[string]interface{}{"uid": claims["sub"], "realm": claims["realm"], "scope": claims["scope"], "expires_in": 60})
+	})
+}
+
+func TestSignedCanary(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	for _, test := range []struct {
+		key     interface{}
+		public  interface{}
+		wantAlg string
+	}{
+		{rsaKey, &rsaKey.PublicKey, "RS256"},
+		{ecKey, &ecKey.PublicKey, "ES384"},
+		{edKey, edPublic, "EdDSA"},
+	} {
+		canary, err := SignedCanary(pemKey(t, test.key), "selftest", map[string]string{"iss": "https://example.com", "scope": "uid cn"})
+		if err != nil {
+			t.Fatal("Failed to create the canary: ", err)
+		}
+		s, err := canary()
+		if err != nil {
+			t.Fatal("Failed to sign the canary: ", err)
+		}
+		token, err := jwt.Parse(s, func(*jwt.Token) (interface{}, error) { return test.public, nil })
+		if err != nil {
+			t.Fatalf("Invalid %s canary: %v", test.wantAlg, err)
+		}
+		claims := token.Claims.(jwt.MapClaims)
+		if token.Method.Alg() != test.wantAlg || token.Header["kid"] != "selftest" || claims["sub"] != DefaultSubject ||
+			claims["iss"] != "https://example.com" || fmt.Sprint(claims["scope"]) != "[uid cn]" {
+			t.Errorf("Wrong canary: %v %v", token.Header, claims)
+		}
+	}
+
+	if _, err := SignedCanary([]byte("not a key"), "selftest", nil); err != errNoPrivateKey {
+		t.Errorf("Wanted %v without a private key, got %v", errNoPrivateKey, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	public, key, _ := ed25519.GenerateKey(rand.Reader)
+	canary, _ := SignedCanary(pemKey(t, key), "selftest", nil)
+	other, _ := SignedCanary(pemKey(t, key), "other", nil)
+	for _, test := range []struct {
+		tokenInfo http.Handler
+		canary    Canary
+		wantCode  int
+		wantBody  string
+	}{
+		{tokenInfo(public), canary, http.StatusOK, "OK\nv1"},
+		{tokenInfo(public), other, http.StatusServiceUnavailable, "Self test failed: token info returned 401"},
+		{tokenInfo(public), StaticCanary("foo"), http.StatusServiceUnavailable, "Self test failed: token info returned 401"},
+		{http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("{}")) }), canary,
+			http.StatusServiceUnavailable, "Self test failed: token info response without uid or expires_in"},
+		{http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("OK")) }), canary,
+			http.StatusServiceUnavailable, "Self test failed: invalid token info response"},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/health/selftest", nil)
+		NewHandler(test.tokenInfo, test.canary, "v1").ServeHTTP(rw, r)
+		if rw.Code != test.wantCode || !strings.HasPrefix(rw.Body.String(), test.wantBody) {
+			t.Errorf("Wrong self test response. Wanted %d %q, got %d %q", test.wantCode, test.wantBody, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestResultIsReused(t *testing.T) {
+	defer func(d time.Duration) { resultTTL = d }(resultTTL)
+	public, key, _ := ed25519.GenerateKey(rand.Reader)
+	canary, _ := SignedCanary(pemKey(t, key), "selftest", nil)
+	calls := 0
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		tokenInfo(public).ServeHTTP(w, r)
+	}), canary, "v1")
+	get := func() int {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/health/selftest", nil)
+		h.ServeHTTP(rw, r)
+		return rw.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := get(); code != http.StatusOK {
+			t.Fatalf("Wrong status of the self test. Wanted 200, got %d", code)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("The result of the self test should be reused, got %d token info calls", calls)
+	}
+	resultTTL = 0
+	get()
+	if calls != 2 {
+		t.Errorf("The self test should run again when its result is too old, got %d token info calls", calls)
+	}
+}
@@ -0,0 +1,145 @@
+package serializer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type canonicalSerializer struct {
+	// rank of the fields that come first, in this order
+	rank map[string]int
+}
+
+// Canonical returns a JSON Serializer whose output only depends on the values, so that the same token info is
+// encoded to the same bytes by every replica and release. Object fields in order come first, in that order, and
+// the others follow sorted by name. There is no whitespace and HTML characters are not escaped. Integers are kept,
+// other numbers are written like in RFC 8785 (so 1.50 becomes 1.5). The document ends with a new line
+func Canonical(order []string) Serializer {
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+	return canonicalSerializer{rank: rank}
+}
+
+func (canonicalSerializer) Name() string {
+	return "canonical"
+}
+
+func (canonicalSerializer) ContentType() string {
+	return JSON.ContentType()
+}
+
+func (s canonicalSerializer) Serialize(w io.Writer, v interface{}) error {
+	bw := bufio.NewWriter(w)
+	if err := s.write(bw, v); err != nil {
+		return err
+	}
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+func (s canonicalSerializer) write(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		if v {
+			w.WriteString("true")
+		} else {
+			w.WriteString("false")
+		}
+	case json.Number:
+		// integers keep all their digits, also beyond the precision of a float64
+		if i, err := v.Int64(); err == nil {
+			w.WriteString(strconv.FormatInt(i, 10))
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return writeCanonicalNumber(w, f)
+	case float64:
+		return writeCanonicalNumber(w, v)
+	case int:
+		w.WriteString(strconv.Itoa(v))
+	case string:
+		writeCanonicalString(w, v)
+	case []interface{}:
+		w.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := s.write(w, e); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return s.less(keys[i], keys[j]) })
+		w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeCanonicalString(w, k)
+			w.WriteByte(':')
+			if err := s.write(w, v[k]); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+// less orders the ranked fields first, by rank, and the others by name
+func (s canonicalSerializer) less(a string, b string) bool {
+	ra, aRanked := s.rank[a]
+	rb, bRanked := s.rank[b]
+	switch {
+	case aRanked && bRanked:
+		return ra < rb
+	case aRanked != bRanked:
+		return aRanked
+	}
+	return a < b
+}
+
+// writeCanonicalNumber writes f in the shortest form that reads back as f. encoding/json already follows the
+// ECMAScript rules that RFC 8785 refers to
+func writeCanonicalNumber(w *bufio.Writer, f float64) error {
+	if f == 0 {
+		// -0 too
+		f = 0
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	w.Write(b)
+	return nil
+}
+
+func writeCanonicalString(w *bufio.Writer, s string) {
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	e.Encode(s)
+	w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,99 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+type handler struct {
+	next http.Handler
+	// re-encodes the JSON responses, if not nil
+	json Serializer
+}
+
+// NewHandler returns an http.Handler that re-encodes the JSON responses of next with the Serializer
+// for the Accept header of the request. Responses in other formats are sent unchanged
+func NewHandler(next http.Handler) http.Handler {
+	return &handler{next: next}
+}
+
+// NewCanonicalHandler returns an http.Handler like NewHandler that also re-encodes the JSON responses of next with
+// the Canonical serializer for the field order, so that they are byte-identical across replicas and releases
+func NewCanonicalHandler(next http.Handler, order []string) http.Handler {
+	return &handler{next: next, json: Canonical(order)}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	s := ForAccept(r.Header.Get("Accept"))
+	if s == JSON {
+		if h.json == nil {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		s = h.json
+	}
+
+	buf := &responseBuffer{header: make(http.Header), status: http.StatusOK}
+	h.next.ServeHTTP(buf, r)
+	for k, v := range buf.header {
+		w.Header()[k] = v
+	}
+	body := buf.body.Bytes()
+	if strings.HasPrefix(buf.header.Get("Content-Type"), "application/json") {
+		if encoded, err := serialize(s, body); err != nil {
+			slog.Warn("Failed to serialize the response", "error", err)
+		} else {
+			body = encoded
+			w.Header().Set("Content-Type", s.ContentType())
+			w.Header().Del("Content-Length")
+			incCounter("planb.tokeninfo.serializer." + s.Name())
+		}
+	}
+	w.WriteHeader(buf.status)
+	w.Write(body)
+}
+
+func serialize(s Serializer, body []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	if err := s.Serialize(out, v); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+// responseBuffer holds the complete response of the next handler so that it can be re-encoded
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (rw *responseBuffer) Header() http.Header {
+	return rw.header
+}
+
+func (rw *responseBuffer) WriteHeader(status int) {
+	rw.status = status
+}
+
+func (rw *responseBuffer) Write(b []byte) (int, error) {
+	return rw.body.Write(b)
+}
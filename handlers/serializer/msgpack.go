@@ -0,0 +1,114 @@
+package serializer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Name() string {
+	return "msgpack"
+}
+
+func (msgpackSerializer) ContentType() string {
+	return "application/msgpack"
+}
+
+// Serialize writes v in the MessagePack format. Only the types of decoded JSON documents are supported.
+// Map keys are sorted, so the output is stable
+func (msgpackSerializer) Serialize(w io.Writer, v interface{}) error {
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpack(bw, v); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+	case bool:
+		if v {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(w, i)
+		} else if f, err := v.Float64(); err == nil {
+			writeMsgpackFloat(w, f)
+		} else {
+			return err
+		}
+	case float64:
+		writeMsgpackFloat(w, v)
+	case int:
+		writeMsgpackInt(w, int64(v))
+	case string:
+		writeMsgpackHeader(w, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		w.WriteString(v)
+	case []interface{}:
+		writeMsgpackHeader(w, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := writeMsgpack(w, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(w, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpack(w, k)
+			if err := writeMsgpack(w, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported type for MessagePack: %T", v)
+	}
+	return nil
+}
+
+func writeMsgpackInt(w *bufio.Writer, i int64) {
+	if i >= -32 && i < 128 {
+		w.WriteByte(byte(i))
+		return
+	}
+	w.WriteByte(0xd3)
+	binary.Write(w, binary.BigEndian, i)
+}
+
+func writeMsgpackFloat(w *bufio.Writer, f float64) {
+	w.WriteByte(0xcb)
+	binary.Write(w, binary.BigEndian, math.Float64bits(f))
+}
+
+// writeMsgpackHeader writes the type and length of a string, array or map. Lengths below fixMax are
+// stored in the fix byte, the others use the 8 (if supported), 16 or 32 bit variant
+func writeMsgpackHeader(w *bufio.Writer, n int, fix byte, fixMax int, b8 byte, b16 byte, b32 byte) {
+	switch {
+	case n < fixMax:
+		w.WriteByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		w.WriteByte(b8)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(b16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(b32)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
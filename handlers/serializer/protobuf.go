@@ -0,0 +1,58 @@
+package serializer
+
+import (
+	"encoding/json"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type protobufSerializer struct{}
+
+func (protobufSerializer) Name() string {
+	return "protobuf"
+}
+
+func (protobufSerializer) ContentType() string {
+	return "application/x-protobuf; messageType=google.protobuf.Struct"
+}
+
+// Serialize writes v as a google.protobuf.Struct message, or as a google.protobuf.Value if v is not a
+// JSON object. Numbers become doubles, as in the JSON mapping of protobuf
+func (protobufSerializer) Serialize(w io.Writer, v interface{}) error {
+	var m proto.Message
+	var err error
+	if obj, ok := toFloats(v).(map[string]interface{}); ok {
+		m, err = structpb.NewStruct(obj)
+	} else {
+		m, err = structpb.NewValue(toFloats(v))
+	}
+	if err != nil {
+		return err
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// toFloats replaces the json.Number values in v, which structpb doesn't accept
+func toFloats(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, e := range v {
+			v[i] = toFloats(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = toFloats(e)
+		}
+	}
+	return v
+}
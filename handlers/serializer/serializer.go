@@ -0,0 +1,62 @@
+package serializer
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"strings"
+)
+
+// A Serializer encodes token info responses. The values are decoded JSON documents: maps, slices,
+// strings, booleans, json.Number and nil. The Name is used for the metrics
+type Serializer interface {
+	Name() string
+	ContentType() string
+	Serialize(w io.Writer, v interface{}) error
+}
+
+var (
+	// JSON is the default serializer of all responses
+	JSON Serializer = jsonSerializer{}
+	// MessagePack encodes responses in the MessagePack format (https://msgpack.org)
+	MessagePack Serializer = msgpackSerializer{}
+	// Protobuf encodes responses as a google.protobuf.Struct message
+	Protobuf Serializer = protobufSerializer{}
+
+	serializers = map[string]Serializer{
+		"application/json":       JSON,
+		"application/msgpack":    MessagePack,
+		"application/x-msgpack":  MessagePack,
+		"application/protobuf":   Protobuf,
+		"application/x-protobuf": Protobuf,
+	}
+)
+
+// ForAccept returns the Serializer for the first media type in the Accept header that has one. Media
+// types with a quality of 0 are skipped. It returns JSON when there is no match
+func ForAccept(accept string) Serializer {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if s, ok := serializers[mediaType]; ok {
+			return s
+		}
+	}
+	return JSON
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Name() string {
+	return "json"
+}
+
+func (jsonSerializer) ContentType() string {
+	return "application/json;charset=UTF-8"
+}
+
+func (jsonSerializer) Serialize(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,151 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestForAccept(t *testing.T) {
+	for _, test := range []struct {
+		accept string
+		want   Serializer
+	}{
+		{"", JSON},
+		{"*/*", JSON},
+		{"application/json", JSON},
+		{"application/msgpack", MessagePack},
+		{"application/x-msgpack", MessagePack},
+		{"text/html, application/x-protobuf;q=0.9", Protobuf},
+		{"application/protobuf;q=0, application/msgpack", MessagePack},
+		{"application/json, application/msgpack", JSON},
+	} {
+		if s := ForAccept(test.accept); s != test.want {
+			t.Errorf("Wrong serializer for %q. Wanted %s, got %s", test.accept, test.want.Name(), s.Name())
+		}
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	for _, test := range []struct {
+		json string
+		want []byte
+	}{
+		{`null`, []byte{0xc0}},
+		{`true`, []byte{0xc3}},
+		{`42`, []byte{0x2a}},
+		{`-1`, []byte{0xff}},
+		{`3600`, []byte{0xd3, 0, 0, 0, 0, 0, 0, 0x0e, 0x10}},
+		{`1.5`, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{`"uid"`, []byte{0xa3, 'u', 'i', 'd'}},
+		{`["a"]`, []byte{0x91, 0xa1, 'a'}},
+		{`{"b":false,"a":1}`, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0xc2}},
+		{`"` + string(bytes.Repeat([]byte("x"), 40)) + `"`, append([]byte{0xd9, 40}, bytes.Repeat([]byte("x"), 40)...)},
+	} {
+		b, err := serialize(MessagePack, []byte(test.json))
+		if err != nil || !bytes.Equal(b, test.want) {
+			t.Errorf("Wrong MessagePack for %s. Wanted %x, got %x (%v)", test.json, test.want, b, err)
+		}
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	b, err := serialize(Protobuf, []byte(`{"uid":"foo","expires_in":42,"scope":["uid"],"uid_scope":true}`))
+	if err != nil {
+		t.Fatal("Failed to serialize: ", err)
+	}
+	s := new(structpb.Struct)
+	if err := proto.Unmarshal(b, s); err != nil {
+		t.Fatal("Failed to decode the message: ", err)
+	}
+	m := s.AsMap()
+	if m["uid"] != "foo" || m["expires_in"] != float64(42) || m["uid_scope"] != true || len(m["scope"].([]interface{})) != 1 {
+		t.Errorf("Wrong decoded message: %v", m)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("OK"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("Content-Length", "16")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "x"})
+	})
+	h := NewHandler(next)
+
+	for _, test := range []struct {
+		path            string
+		accept          string
+		wantContentType string
+		wantBody        []byte
+	}{
+		{"/", "", "application/json;charset=UTF-8", []byte("{\"error\":\"x\"}\n")},
+		{"/", "application/msgpack", "application/msgpack", []byte{0x81, 0xa5, 'e', 'r', 'r', 'o', 'r', 0xa1, 'x'}},
+		{"/text", "application/msgpack", "text/plain", []byte("OK")},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept", test.accept)
+		h.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != test.wantContentType {
+			t.Errorf("Wrong content type for %q. Wanted %q, got %q", test.accept, test.wantContentType, ct)
+		}
+		if !bytes.Equal(w.Body.Bytes(), test.wantBody) {
+			t.Errorf("Wrong body for %q. Wanted %q, got %q", test.accept, test.wantBody, w.Body.Bytes())
+		}
+		if test.path == "/" && test.accept != "" && (w.Code != http.StatusUnauthorized || w.Header().Get("Content-Length") != "") {
+			t.Errorf("Wrong status %d or content length %q", w.Code, w.Header().Get("Content-Length"))
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Error("Responses should vary by the Accept header")
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	s := Canonical([]string{"uid", "scope", "uid"})
+	for _, test := range []struct {
+		json string
+		want string
+	}{
+		{`{"realm": "/services", "scope": ["uid"], "uid": "foo", "cn": "<Foo>"}`, `{"uid":"foo","scope":["uid"],"cn":"<Foo>","realm":"/services"}`},
+		{`{"b": {"uid": 1, "a": 2}, "a": null}`, `{"a":null,"b":{"uid":1,"a":2}}`},
+		{`[1.50, 1e2, 1.0, 9007199254740993, -0.0, 1e-7]`, `[1.5,100,1,9007199254740993,0,1e-7]`},
+		{`"café & <b>"`, `"café & <b>"`},
+	} {
+		b, err := serialize(s, []byte(test.json))
+		if err != nil || string(b) != test.want+"\n" {
+			t.Errorf("Wrong canonical JSON for %s. Wanted %s, got %s (%v)", test.json, test.want, b, err)
+		}
+	}
+}
+
+func TestCanonicalHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{ "scope": ["uid"], "expires_in": 42.0, "uid": "foo" }`))
+	})
+	h := NewCanonicalHandler(next, []string{"uid"})
+	for _, accept := range []string{"", "application/json"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		h.ServeHTTP(w, r)
+		if want := "{\"uid\":\"foo\",\"expires_in\":42,\"scope\":[\"uid\"]}\n"; w.Body.String() != want {
+			t.Errorf("Wrong body for %q. Wanted %q, got %q", accept, want, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json;charset=UTF-8" {
+			t.Errorf("Wrong content type for %q: %q", accept, ct)
+		}
+	}
+}
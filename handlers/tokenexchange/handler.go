@@ -0,0 +1,341 @@
+// Package tokenexchange forwards OAuth 2.0 Token Exchange requests (RFC 8693) to the upstream token endpoint, so
+// that services next to the token info have a single egress point for all their token operations
+package tokenexchange
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/proxy"
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// GrantType is the grant_type of token exchange requests
+const GrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// Command is the name of the circuit breaker around the upstream calls. It's configured like the one of the
+// upstream token info calls
+const Command = "tokenexchange"
+
+// errUpstreamFailure is reported to the circuit breaker whenever the upstream answers with a server error. The
+// upstream response is still sent back to the client
+var errUpstreamFailure = errors.New("Upstream token exchange failure")
+
+const (
+	// maxBodySize limits the size of token exchange requests and of the upstream responses
+	maxBodySize = 1 << 20
+	// cached token exchange responses are prefixed, the cache can be shared with the upstream Token Info responses
+	cacheKeyPrefix = "tokenexchange:"
+)
+
+type tokenExchangeHandler struct {
+	upstream *url.URL
+	client   *http.Client
+	cache    tokencache.Cache
+	cacheTTL time.Duration
+	retries  int
+	backoff  time.Duration
+}
+
+// cachedResponse is a successful upstream response with the time it was stored, used to lower its expires_in.
+// It contains the issued token, so it's only stored encrypted with the key of the request, see sealKey
+type cachedResponse struct {
+	StoredAt    int64           `json:"stored_at"`
+	ContentType string          `json:"content_type"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// NewHandler returns an http.Handler that sends token exchange requests to the upstream token endpoint, guarded
+// by the Command circuit breaker. Every call may issue a token, so only the calls that failed to connect before
+// the request was sent are retried, up to retries times, waiting backoff (doubled on every attempt) in between.
+// Successful responses are stored encrypted in the cache for at most cacheTTL, and never longer than the issued
+// token is valid. A nil cache or a zero cacheTTL disables caching
+func NewHandler(upstream *url.URL, cache tokencache.Cache, cacheTTL, timeout time.Duration, retries int, backoff time.Duration) http.Handler {
+	tokeninfoproxy.ConfigureCommand(Command, timeout)
+	return &tokenExchangeHandler{
+		upstream: upstream,
+		client:   &http.Client{Timeout: timeout, Transport: ht.NewTransport()},
+		cache:    cache,
+		cacheTTL: cacheTTL,
+		retries:  retries,
+		backoff:  backoff,
+	}
+}
+
+// ServeHTTP forwards the token exchange request, with the client credentials, to the upstream token endpoint
+func (h *tokenExchangeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, maxBodySize)
+	if err := req.ParseForm(); err != nil || req.PostForm.Get("grant_type") != GrantType || req.PostForm.Get("subject_token") == "" {
+		incCounter("planb.tokeninfo.tokenexchange.invalid")
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+		return
+	}
+
+	// the token endpoint authenticates the client, so its credentials are part of the key. url.Values.Encode
+	// sorts the parameters
+	request := req.Header.Get("Authorization") + "\n" + req.PostForm.Encode()
+	key := cacheKeyPrefix + tokeninfo.HashToken(request)
+	secret := sealKey(request)
+	if h.cache != nil && h.cacheTTL > 0 {
+		if h.serveCached(w, key, secret, time.Now()) {
+			incCounter("planb.tokeninfo.tokenexchange.cache.hits")
+			return
+		}
+		incCounter("planb.tokeninfo.tokenexchange.cache.misses")
+	}
+
+	start := time.Now()
+	resp, err := h.exchange(req)
+	if err != nil {
+		slog.Warn("Upstream token exchange call failed", "error", err)
+		incCounter("planb.tokeninfo.tokenexchange.upstream.errors")
+		status := http.StatusBadGateway
+		switch err {
+		case hystrix.ErrTimeout:
+			status = http.StatusGatewayTimeout
+		case hystrix.ErrMaxConcurrency:
+			status = http.StatusTooManyRequests
+		case hystrix.ErrCircuitOpen:
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if t, ok := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.tokenexchange.upstream", metrics.NewTimer).(metrics.Timer); ok {
+		t.UpdateSince(start)
+	}
+	if resp.status == http.StatusOK && h.cache != nil && h.cacheTTL > 0 {
+		h.store(key, secret, resp, time.Now())
+	}
+	resp.header.Set("X-Cache", "MISS")
+	resp.writeTo(w)
+}
+
+// serveCached sends the cached response, decrypted with secret, with the remaining lifetime of the token. It
+// returns false if there is none or the token is about to expire
+func (h *tokenExchangeHandler) serveCached(w http.ResponseWriter, key string, secret []byte, now time.Time) bool {
+	sealed, err := h.cache.Get(key)
+	if err != nil {
+		return false
+	}
+	b, err := open(secret, sealed)
+	if err != nil {
+		return false
+	}
+	var cr cachedResponse
+	var body map[string]interface{}
+	if json.Unmarshal(b, &cr) != nil || json.Unmarshal(cr.Body, &body) != nil {
+		return false
+	}
+	expiresIn, _ := body["expires_in"].(float64)
+	remaining := int64(expiresIn) - (now.Unix() - cr.StoredAt)
+	if remaining <= 0 {
+		return false
+	}
+	body["expires_in"] = remaining
+
+	w.Header().Set("Content-Type", cr.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("X-Cache", "HIT")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write the token exchange response", "error", err)
+	}
+	return true
+}
+
+// store caches a successful response encrypted with secret until the issued token expires, but never for longer
+// than the cache TTL. Responses without expires_in are not cached, their lifetime is unknown
+func (h *tokenExchangeHandler) store(key string, secret []byte, resp *upstreamResponse, now time.Time) {
+	var body struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if json.Unmarshal(resp.body.Bytes(), &body) != nil || body.ExpiresIn <= 0 {
+		return
+	}
+	ttl := h.cacheTTL
+	if d := time.Duration(body.ExpiresIn) * time.Second; d < ttl {
+		ttl = d
+	}
+	b, err := json.Marshal(cachedResponse{StoredAt: now.Unix(), ContentType: resp.header.Get("Content-Type"), Body: resp.body.Bytes()})
+	if err != nil {
+		return
+	}
+	if b, err = seal(secret, b); err != nil {
+		return
+	}
+	if err := h.cache.Set(key, b, ttl); err != nil {
+		slog.Warn("Failed to store the token exchange response in the cache", "error", err)
+	}
+}
+
+// exchange calls the upstream token endpoint, again only after connection errors that happened before the
+// request was sent. The upstream may have issued a token for a request that timed out or failed later
+func (h *tokenExchangeHandler) exchange(req *http.Request) (*upstreamResponse, error) {
+	for attempt := 0; ; attempt++ {
+		resp, sent, err := h.upstreamAttempt(req)
+		if err == nil || sent || attempt >= h.retries || req.Context().Err() != nil {
+			return resp, err
+		}
+		incCounter("planb.tokeninfo.tokenexchange.upstream.retries")
+		time.Sleep(backoff(h.backoff, attempt))
+	}
+}
+
+// upstreamAttempt makes a single call to the upstream guarded by the circuit breaker. It returns whether the
+// request was sent, even partly. Server errors are returned as responses
+func (h *tokenExchangeHandler) upstreamAttempt(req *http.Request) (*upstreamResponse, bool, error) {
+	type result struct {
+		resp *upstreamResponse
+		err  error
+	}
+	var sent atomic.Bool
+	// the command keeps running after a timeout, so its result is only read once it finished
+	done := make(chan result, 1)
+	err := hystrix.Do(Command, func() error {
+		resp, err := h.upstreamRequest(req, &sent)
+		done <- result{resp, err}
+		if err == nil && resp.status >= http.StatusInternalServerError {
+			return errUpstreamFailure
+		}
+		return err
+	}, nil)
+	if _, ok := err.(hystrix.CircuitError); ok {
+		// after a timeout, the request may still be sent
+		return nil, err == hystrix.ErrTimeout || sent.Load(), err
+	}
+	res := <-done
+	return res.resp, sent.Load(), res.err
+}
+
+// upstreamRequest sends the form of the token exchange request to the upstream token endpoint. sent is set once
+// the transport starts writing the request
+func (h *tokenExchangeHandler) upstreamRequest(req *http.Request, sent *atomic.Bool) (*upstreamResponse, error) {
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{WroteHeaderField: func(string, []string) { sent.Store(true) }})
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, h.upstream.String(), strings.NewReader(req.PostForm.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("User-Agent", ht.UserAgent)
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	requestid.Inject(req.Context(), r.Header)
+	resp, err := h.client.Do(r)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ur := &upstreamResponse{header: make(http.Header), body: new(bytes.Buffer), status: resp.StatusCode}
+	for _, k := range []string{"Content-Type", "Cache-Control", "Pragma", "WWW-Authenticate"} {
+		if v := resp.Header.Get(k); v != "" {
+			ur.header.Set(k, v)
+		}
+	}
+	if _, err := io.Copy(ur.body, io.LimitReader(resp.Body, maxBodySize)); err != nil {
+		return nil, err
+	}
+	return ur, nil
+}
+
+type upstreamResponse struct {
+	header http.Header
+	body   *bytes.Buffer
+	status int
+}
+
+func (ur *upstreamResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range ur.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(ur.status)
+	w.Write(ur.body.Bytes())
+}
+
+// backoff returns the delay before the retry after attempt (starting at 0). The delay doubles with every
+// attempt and is randomized between half and the full value
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(mrand.Int63n(int64(d-half)+1))
+}
+
+// sealKey returns the key that encrypts the cached response of the request, the client credentials and the
+// parameters. It's another hash of the request than the cache key, so that the issued tokens can only be read
+// by the clients that send the same request again, never by the readers of the cache
+func sealKey(request string) []byte {
+	sum := sha256.Sum256([]byte(cacheKeyPrefix + request))
+	return sum[:]
+}
+
+// seal encrypts b with AES-GCM, the random nonce comes first
+func seal(key, b []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, b, nil), nil
+}
+
+// open decrypts b encrypted by seal
+func open(key, b []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("sealed response is too short")
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
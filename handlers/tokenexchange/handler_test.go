@@ -0,0 +1,181 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+func exchangeRequest(form url.Values, auth string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "http://example.com/oauth2/token-exchange", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	return r
+}
+
+func TestHandler(t *testing.T) {
+	var upstreamCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamCalls++
+		user, pass, _ := req.BasicAuth()
+		if user != "svc" || pass != "secret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		if req.PostFormValue("grant_type") != GrantType || req.PostFormValue("audience") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_target"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintf(w, `{"access_token":"exchanged-%s","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`,
+			req.PostFormValue("subject_token"))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	h := NewHandler(u, tokencache.NewMemoryCache(10), time.Minute, time.Second, 0, 0)
+
+	form := url.Values{"grant_type": {GrantType}, "subject_token": {"foo"}, "subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"}, "audience": {"bar"}}
+	auth := "Basic c3ZjOnNlY3JldA=="
+	for _, test := range []struct {
+		name      string
+		form      url.Values
+		auth      string
+		wantCode  int
+		wantCache string
+		wantCalls int
+	}{
+		{"wrong grant type", url.Values{"grant_type": {"client_credentials"}, "subject_token": {"foo"}}, auth, http.StatusBadRequest, "", 0},
+		{"missing subject token", url.Values{"grant_type": {GrantType}}, auth, http.StatusBadRequest, "", 0},
+		{"exchanged", form, auth, http.StatusOK, "MISS", 1},
+		{"cached", form, auth, http.StatusOK, "HIT", 1},
+		{"other client", form, "Basic b3RoZXI6c2VjcmV0", http.StatusUnauthorized, "MISS", 2},
+		{"rejected by the upstream", url.Values{"grant_type": {GrantType}, "subject_token": {"foo"}}, auth, http.StatusBadRequest, "MISS", 3},
+		{"errors are not cached", url.Values{"grant_type": {GrantType}, "subject_token": {"foo"}}, auth, http.StatusBadRequest, "MISS", 4},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, exchangeRequest(test.form, test.auth))
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d", test.name, test.wantCode, w.Code)
+		}
+		if c := w.Header().Get("X-Cache"); c != test.wantCache {
+			t.Errorf("Wrong X-Cache header for %s. Wanted %q, got %q", test.name, test.wantCache, c)
+		}
+		if upstreamCalls != test.wantCalls {
+			t.Errorf("Wrong number of upstream calls for %s. Wanted %d, got %d", test.name, test.wantCalls, upstreamCalls)
+		}
+		if w.Code == http.StatusOK {
+			var body map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if body["access_token"] != "exchanged-foo" || w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Wrong response for %s: %s", test.name, w.Body)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com/oauth2/token-exchange", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("Only POST requests should be accepted, got %d", w.Code)
+	}
+}
+
+func TestCachedExpiresIn(t *testing.T) {
+	cache := tokencache.NewMemoryCache(10)
+	h := &tokenExchangeHandler{cache: cache, cacheTTL: time.Hour}
+	now := time.Now()
+	secret := sealKey("request")
+	resp := &upstreamResponse{header: http.Header{"Content-Type": {"application/json"}}, status: http.StatusOK}
+	resp.body = bytes.NewBufferString(`{"access_token":"foo","expires_in":600}`)
+	h.store("key", secret, resp, now.Add(-100*time.Second))
+
+	if b, _ := cache.Get("key"); len(b) == 0 || bytes.Contains(b, []byte(`"foo"`)) {
+		t.Errorf("The issued token should be stored encrypted, got %q", b)
+	}
+	w := httptest.NewRecorder()
+	if !h.serveCached(w, "key", secret, now) {
+		t.Fatal("The response should be cached")
+	}
+	if !strings.Contains(w.Body.String(), `"expires_in":500`) {
+		t.Errorf("expires_in should be lowered by the age of the entry, got %s", w.Body)
+	}
+	if h.serveCached(httptest.NewRecorder(), "key", secret, now.Add(500*time.Second)) {
+		t.Error("Expired tokens should not be served from the cache")
+	}
+	if h.serveCached(httptest.NewRecorder(), "key", sealKey("other request"), now) {
+		t.Error("The cached response should only be decrypted with the key of the request")
+	}
+}
+
+// failingTransport fails the first calls, after writing the headers of the request if sent is true
+type failingTransport struct {
+	failures int
+	sent     bool
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.failures == 0 {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	t.failures--
+	if trace := httptrace.ContextClientTrace(req.Context()); t.sent && trace != nil && trace.WroteHeaderField != nil {
+		trace.WroteHeaderField("Authorization", []string{"Basic c3ZjOnNlY3JldA=="})
+	}
+	return nil, errors.New("connection reset")
+}
+
+func TestRetries(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"foo","token_type":"Bearer"}`)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	form := url.Values{"grant_type": {GrantType}, "subject_token": {"foo"}}
+
+	w := httptest.NewRecorder()
+	NewHandler(u, nil, 0, time.Second, 1, time.Millisecond).ServeHTTP(w, exchangeRequest(form, ""))
+	if w.Code != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("Server errors should not be retried, got %d after %d calls", w.Code, calls)
+	}
+
+	for _, test := range []struct {
+		name      string
+		transport *failingTransport
+		wantCode  int
+		wantCalls int
+	}{
+		{"connection error", &failingTransport{failures: 1}, http.StatusOK, 2},
+		{"sent request", &failingTransport{failures: 1, sent: true}, http.StatusBadGateway, 2},
+		{"too many connection errors", &failingTransport{failures: 2}, http.StatusBadGateway, 2},
+	} {
+		h := NewHandler(u, nil, 0, time.Second, 1, time.Millisecond).(*tokenExchangeHandler)
+		h.client.Transport = test.transport
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, exchangeRequest(form, ""))
+		if w.Code != test.wantCode || calls != test.wantCalls {
+			t.Errorf("Wrong response for %s. Wanted %d after %d calls, got %d after %d calls", test.name, test.wantCode, test.wantCalls, w.Code, calls)
+		}
+	}
+}
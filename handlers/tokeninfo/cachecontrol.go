@@ -0,0 +1,58 @@
+package tokeninfo
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy sets the Cache-Control and Expires headers of successful Token Info responses. MaxAge caps
+// the lifetime derived from the token, a MaxAge of 0 disables the headers. Directives, for ex. "private",
+// are sent before the max-age. The responses always vary by Authorization, so that a shared cache never
+// answers with the token info of another token
+type CachePolicy struct {
+	MaxAge     time.Duration
+	Directives string
+}
+
+// Enabled returns true if the policy sets any header
+func (p CachePolicy) Enabled() bool {
+	return p.MaxAge > 0
+}
+
+// Set makes the response cacheable until the token expires in expiresIn, but never for longer than MaxAge
+func (p CachePolicy) Set(h http.Header, expiresIn time.Duration, now time.Time) {
+	if !p.Enabled() {
+		return
+	}
+	age := expiresIn
+	if age > p.MaxAge {
+		age = p.MaxAge
+	}
+	if age < 0 {
+		age = 0
+	}
+	p.set(h, "max-age="+strconv.Itoa(int(age/time.Second)))
+	h.Set("Expires", now.Add(age).UTC().Format(http.TimeFormat))
+}
+
+// SetNoCache asks caches to revalidate the response, for responses whose remaining lifetime is unknown
+func (p CachePolicy) SetNoCache(h http.Header) {
+	if p.Enabled() {
+		p.set(h, "no-cache")
+	}
+}
+
+func (p CachePolicy) set(h http.Header, directive string) {
+	if p.Directives != "" {
+		directive = p.Directives + ", " + directive
+	}
+	h.Set("Cache-Control", directive)
+	for _, v := range h.Values("Vary") {
+		if strings.EqualFold(v, "Authorization") {
+			return
+		}
+	}
+	h.Add("Vary", "Authorization")
+}
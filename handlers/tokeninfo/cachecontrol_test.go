@@ -0,0 +1,46 @@
+package tokeninfo
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCachePolicy(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		policy      CachePolicy
+		expiresIn   time.Duration
+		wantControl string
+		wantExpires string
+	}{
+		{CachePolicy{}, time.Hour, "", ""},
+		{CachePolicy{MaxAge: time.Minute}, 30 * time.Second, "max-age=30", "Thu, 02 Jan 2020 03:04:35 GMT"},
+		{CachePolicy{MaxAge: time.Minute, Directives: "private"}, time.Hour, "private, max-age=60", "Thu, 02 Jan 2020 03:05:05 GMT"},
+		{CachePolicy{MaxAge: time.Minute, Directives: "private"}, -time.Second, "private, max-age=0", "Thu, 02 Jan 2020 03:04:05 GMT"},
+	} {
+		h := make(http.Header)
+		test.policy.Set(h, test.expiresIn, now)
+		if cc := h.Get("Cache-Control"); cc != test.wantControl {
+			t.Errorf("Wrong Cache-Control for %v. Wanted %q, got %q", test.policy, test.wantControl, cc)
+		}
+		if e := h.Get("Expires"); e != test.wantExpires {
+			t.Errorf("Wrong Expires for %v. Wanted %q, got %q", test.policy, test.wantExpires, e)
+		}
+		if v := h.Get("Vary"); test.policy.Enabled() != (v == "Authorization") {
+			t.Errorf("Wrong Vary for %v: %q", test.policy, v)
+		}
+	}
+
+	h := make(http.Header)
+	h.Add("Vary", "Accept")
+	p := CachePolicy{MaxAge: time.Minute, Directives: "public"}
+	p.SetNoCache(h)
+	p.SetNoCache(h)
+	if cc := h.Get("Cache-Control"); cc != "public, no-cache" {
+		t.Errorf("Wrong Cache-Control without a lifetime: %q", cc)
+	}
+	if v := h.Values("Vary"); len(v) != 2 || v[1] != "Authorization" {
+		t.Errorf("The responses should vary by Authorization once, got %q", v)
+	}
+}
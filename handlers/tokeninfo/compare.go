@@ -0,0 +1,97 @@
+package tokeninfo
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// maxComparisons is the number of background calls for comparisons that can run at the same time. Further
+// requests are not compared, so that a slow backend can't pile up goroutines
+const maxComparisons = 100
+
+// comparedFields are the fields of the token info responses that are counted by name when they differ. The
+// others, like the attributes of the scopes, are counted as other, so that the number of metrics doesn't
+// depend on the responses
+var comparedFields = map[string]bool{
+	"access_token": true, "refresh_token": true, "uid": true, "grant_type": true, "scope": true, "realm": true,
+	"token_type": true, "client_id": true,
+}
+
+// A Comparer calls another backend in the background with copies of requests and compares its responses
+// with the ones sent to the clients. The differences are counted with metrics below its prefix
+type Comparer struct {
+	prefix  string
+	timeout time.Duration
+	running chan struct{}
+}
+
+// NewComparer returns a Comparer for backend calls that take at most timeout. Its metrics are prefix.requests,
+// .matches, .mismatches.status, .mismatches.body, .mismatches.field.FIELD, .timeouts, .dropped and .skipped
+func NewComparer(prefix string, timeout time.Duration) *Comparer {
+	return &Comparer{prefix: prefix, timeout: timeout, running: make(chan struct{}, maxComparisons)}
+}
+
+// Compare sends a copy of req to call in the background and compares the status and the body it returns with
+// the response sent to the client. Only requests without a body can be sent again
+func (c *Comparer) Compare(req *http.Request, status int, body []byte, call func(r *http.Request) (int, []byte)) {
+	if req.Body != nil && req.Body != http.NoBody {
+		incCounter(c.prefix + ".skipped")
+		return
+	}
+	select {
+	case c.running <- struct{}{}:
+	default:
+		incCounter(c.prefix + ".dropped")
+		return
+	}
+	// the client may be gone before the backend answers. The caller and the request id stay in the context
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), c.timeout)
+	r := req.Clone(ctx)
+	tokenHash := HashPrefix(AccessTokenFromRequest(req))
+	body = append([]byte(nil), body...)
+	go func() {
+		defer func() { <-c.running }()
+		defer cancel()
+		incCounter(c.prefix + ".requests")
+		otherStatus, otherBody := call(r)
+		if ctx.Err() != nil {
+			incCounter(c.prefix + ".timeouts")
+			return
+		}
+		c.compare(tokenHash, status, body, otherStatus, otherBody)
+	}()
+}
+
+// compare counts and logs the differences between the two responses. Only the names of the fields are
+// logged, their values are personal data
+func (c *Comparer) compare(tokenHash string, status int, body []byte, otherStatus int, otherBody []byte) {
+	if status != otherStatus {
+		slog.Info("Compared token info responses have another status", "metrics", c.prefix, "token_hash", tokenHash, "status", status, "other_status", otherStatus)
+		incCounter(c.prefix + ".mismatches.status")
+		return
+	}
+	fields, ok := DiffFields(body, otherBody)
+	switch {
+	case !ok:
+		slog.Info("Compared token info responses have another body", "metrics", c.prefix, "token_hash", tokenHash)
+		incCounter(c.prefix + ".mismatches.body")
+	case len(fields) == 0:
+		incCounter(c.prefix + ".matches")
+	default:
+		slog.Info("Compared token info responses have other fields", "metrics", c.prefix, "token_hash", tokenHash, "fields", fields)
+		incCounter(c.prefix + ".mismatches.body")
+		other := false
+		for _, f := range fields {
+			if comparedFields[f] {
+				incCounter(c.prefix + ".mismatches.field." + f)
+			} else {
+				other = true
+			}
+		}
+		if other {
+			incCounter(c.prefix + ".mismatches.field.other")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package tokeninfo
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestComparer(t *testing.T) {
+	c := NewComparer("test.compare", time.Second)
+	counter := func(name string) metrics.Counter {
+		return metrics.GetOrRegisterCounter("test.compare."+name, metrics.DefaultRegistry)
+	}
+	done := make(chan struct{})
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+	c.Compare(req, http.StatusOK, []byte(`{"uid":"jdoe","scope":["uid"],"uid.read":true}`), func(r *http.Request) (int, []byte) {
+		defer close(done)
+		return http.StatusOK, []byte(`{"uid":"jane","scope":["uid","cn"],"cn":true,"custom":"x"}`)
+	})
+	<-done
+	for deadline := time.Now().Add(time.Second); counter("mismatches.body").Count() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The mismatch was not counted")
+		}
+	}
+	for name, want := range map[string]int64{"requests": 1, "mismatches.field.uid": 1, "mismatches.field.scope": 1, "mismatches.field.other": 1} {
+		if got := counter(name).Count(); got != want {
+			t.Errorf("Wrong count of %s. Wanted %d, got %d", name, want, got)
+		}
+	}
+	metrics.DefaultRegistry.Each(func(name string, _ interface{}) {
+		for _, f := range []string{"cn", "custom", "uid.read"} {
+			if strings.HasSuffix(name, ".field."+f) {
+				t.Errorf("The field %s should be counted as other", f)
+			}
+		}
+	})
+
+	post, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo", strings.NewReader("access_token=foo"))
+	c.Compare(post, http.StatusOK, nil, func(r *http.Request) (int, []byte) {
+		t.Error("Requests with a body should not be sent again")
+		return http.StatusOK, nil
+	})
+	if counter("skipped").Count() != 1 {
+		t.Error("The request with a body should be counted as skipped")
+	}
+}
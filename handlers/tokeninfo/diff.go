@@ -0,0 +1,32 @@
+package tokeninfo
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// volatileFields change between two responses for the same token and are not compared by DiffFields
+var volatileFields = map[string]bool{"expires_in": true}
+
+// DiffFields returns the sorted names of the top level fields that differ between the token info responses
+// a and b, without expires_in. It's false when one of them is not a JSON object and they are not equal
+func DiffFields(a []byte, b []byte) ([]string, bool) {
+	var ma, mb map[string]interface{}
+	if json.Unmarshal(a, &ma) != nil || json.Unmarshal(b, &mb) != nil {
+		return nil, string(a) == string(b)
+	}
+	var fields []string
+	for k, v := range ma {
+		if w, ok := mb[k]; (!ok || !reflect.DeepEqual(v, w)) && !volatileFields[k] {
+			fields = append(fields, k)
+		}
+	}
+	for k := range mb {
+		if _, ok := ma[k]; !ok && !volatileFields[k] {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields, true
+}
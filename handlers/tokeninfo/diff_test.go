@@ -0,0 +1,25 @@
+package tokeninfo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	for _, test := range []struct {
+		a, b       string
+		wantFields []string
+		wantOK     bool
+	}{
+		{`{"uid":"jdoe","expires_in":42}`, `{"uid":"jdoe","expires_in":41}`, nil, true},
+		{`{"uid":"jdoe","scope":["uid"]}`, `{"uid":"jdoe","scope":["uid","cn"]}`, []string{"scope"}, true},
+		{`{"uid":"jdoe","realm":"/services"}`, `{"uid":"jane","cn":"Jane"}`, []string{"cn", "realm", "uid"}, true},
+		{`not json`, `not json`, nil, true},
+		{`not json`, `{}`, nil, false},
+	} {
+		fields, ok := DiffFields([]byte(test.a), []byte(test.b))
+		if ok != test.wantOK || !reflect.DeepEqual(fields, test.wantFields) {
+			t.Errorf("Wrong diff of %s and %s. Wanted %v %v, got %v %v", test.a, test.b, test.wantFields, test.wantOK, fields, ok)
+		}
+	}
+}
@@ -24,7 +24,7 @@ func (h *errorAllHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		tie = tokeninfo.ErrInvalidToken
 	}
 	registerError(tie)
-	tie.Write(w)
+	tie.Write(w, req)
 	return
 }
 
@@ -2,29 +2,120 @@ package tokeninfo
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zalando/planb-tokeninfo/requestid"
 )
 
-// Error type is used to wrap standard error messages that can be easily marshaled to JSON
+// Error type is used to wrap standard error messages that can be easily marshaled to JSON. ErrorReason is an
+// optional machine readable cause of the error, for ex. "expired". RequestID is set by Write
 type Error struct {
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"`
+	ErrorReason      string `json:"error_reason,omitempty"`
+	RequestID        string `json:"request_id,omitempty"`
 	statusCode       int
 }
 
 var (
 	// ErrInvalidRequest should be used whenever the receiver failed to parse the request
-	ErrInvalidRequest = Error{"invalid_request", "Access Token not valid", http.StatusBadRequest}
+	ErrInvalidRequest = Error{Error: "invalid_request", ErrorDescription: "Access Token not valid", statusCode: http.StatusBadRequest}
 	// ErrInvalidToken should be used whenever the receiver failed to validate a JWT Token
-	ErrInvalidToken = Error{"invalid_token", "Access Token not valid", http.StatusUnauthorized}
+	ErrInvalidToken = Error{Error: "invalid_token", ErrorDescription: "Access Token not valid", statusCode: http.StatusUnauthorized}
+	// ErrInvalidClient should be used whenever the caller failed to authenticate itself
+	ErrInvalidClient = Error{Error: "invalid_client", ErrorDescription: "Client authentication failed", statusCode: http.StatusUnauthorized}
+	// ErrInsufficientScope should be used whenever a valid token is not allowed to access the resource
+	ErrInsufficientScope = Error{Error: "insufficient_scope", ErrorDescription: "Access Token not allowed", statusCode: http.StatusForbidden}
+	// ErrRateLimited should be used whenever the caller sent more requests than allowed
+	ErrRateLimited = Error{Error: "rate_limited", ErrorDescription: "Too many requests", statusCode: http.StatusTooManyRequests}
+	// ErrTooManyTokens should be used whenever a batch request has more tokens than allowed
+	ErrTooManyTokens = Error{Error: "invalid_request", ErrorDescription: "Too many tokens", statusCode: http.StatusRequestEntityTooLarge}
+	// ErrRequestTooLarge should be used whenever the request body is bigger than allowed
+	ErrRequestTooLarge = Error{Error: "invalid_request", ErrorDescription: "Request too large", statusCode: http.StatusRequestEntityTooLarge}
+	// ErrURITooLong should be used whenever the request URL is longer than allowed
+	ErrURITooLong = Error{Error: "invalid_request", ErrorDescription: "Request URI too long", statusCode: http.StatusRequestURITooLong}
+	// ErrTemporarilyUnavailable should be used whenever a token can't be validated for now, for ex. because a
+	// list it must be checked against is unavailable
+	ErrTemporarilyUnavailable = Error{Error: "temporarily_unavailable", ErrorDescription: "Access Token can't be validated", statusCode: http.StatusServiceUnavailable}
 )
 
-// Write will write the Error e to the response writer, marshaled as JSON, and with the respective Status Code
-func (e *Error) Write(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+// problemTypes are the type URIs of the problem documents, by error code. Other errors use about:blank
+var problemTypes = map[string]string{
+	"invalid_request":    "https://tools.ietf.org/html/rfc6750#section-3.1",
+	"invalid_token":      "https://tools.ietf.org/html/rfc6750#section-3.1",
+	"invalid_client":     "https://tools.ietf.org/html/rfc6749#section-5.2",
+	"insufficient_scope": "https://tools.ietf.org/html/rfc6750#section-3.1",
+}
+
+// problem is an RFC 7807 problem document. The fields of the legacy error are kept as extension members
+type problem struct {
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Status      int    `json:"status"`
+	Detail      string `json:"detail"`
+	Instance    string `json:"instance,omitempty"`
+	Error       string `json:"error"`
+	ErrorReason string `json:"error_reason,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+// Write will write the Error e to the response writer, marshaled as JSON, and with the respective Status Code.
+// The request ID of the response headers, if any, is added to the body. If the request, which may be nil,
+// accepts application/problem+json the error is sent as an RFC 7807 problem document instead
+func (e *Error) Write(w http.ResponseWriter, req *http.Request) {
+	requestID := w.Header().Get(requestid.Header)
+	var body interface{}
+	if req != nil && AcceptsProblem(req) {
+		p := &problem{
+			Type:        "about:blank",
+			Title:       http.StatusText(e.statusCode),
+			Status:      e.statusCode,
+			Detail:      e.ErrorDescription,
+			Instance:    req.URL.Path,
+			Error:       e.Error,
+			ErrorReason: e.ErrorReason,
+			RequestID:   requestID,
+		}
+		if t, has := problemTypes[e.Error]; has {
+			p.Type = t
+		}
+		body = p
+		w.Header().Set("Content-Type", "application/problem+json")
+	} else {
+		legacy := *e
+		legacy.RequestID = requestID
+		body = &legacy
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	}
 	w.WriteHeader(e.statusCode)
-	if err := json.NewEncoder(w).Encode(e); err != nil {
-		log.Println("Failed to finish error response: ", err)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to finish error response", "error", err)
+	}
+}
+
+// AcceptsProblem returns true if the Accept header of the request lists application/problem+json with a
+// quality above 0
+func AcceptsProblem(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, r := range strings.Split(accept, ",") {
+			params := strings.Split(r, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "application/problem+json") {
+				continue
+			}
+			accepted := true
+			for _, p := range params[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && kv[0] == "q" {
+					q, err := strconv.ParseFloat(kv[1], 64)
+					accepted = err == nil && q > 0
+				}
+			}
+			if accepted {
+				return true
+			}
+		}
 	}
+	return false
 }
@@ -22,6 +22,11 @@ func TestErrorEncoding(t *testing.T) {
 			`{"error":"invalid_token","error_description":"Access Token not valid"}` + "\n",
 			http.StatusUnauthorized,
 		},
+		{
+			ErrInvalidClient,
+			`{"error":"invalid_client","error_description":"Client authentication failed"}` + "\n",
+			http.StatusUnauthorized,
+		},
 		{
 			Error{Error: "foo", ErrorDescription: "bar", statusCode: http.StatusExpectationFailed},
 			`{"error":"foo","error_description":"bar"}` + "\n",
@@ -29,7 +34,7 @@ func TestErrorEncoding(t *testing.T) {
 		},
 	} {
 		w := httptest.NewRecorder()
-		test.given.Write(w)
+		test.given.Write(w, nil)
 
 		if w.Code != test.wantCode {
 			t.Errorf("Wrong status code. Wanted %q, got %q", http.StatusText(test.wantCode), http.StatusText(w.Code))
@@ -41,3 +46,56 @@ func TestErrorEncoding(t *testing.T) {
 
 	}
 }
+
+func TestErrorRequestID(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Request-Id", "abc-123")
+	ErrInvalidToken.Write(w, nil)
+	want := `{"error":"invalid_token","error_description":"Access Token not valid","request_id":"abc-123"}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("Wrong body. Wanted %q, got %q", want, w.Body.String())
+	}
+	if ErrInvalidToken.RequestID != "" {
+		t.Error("Writing an error should not change it")
+	}
+}
+
+func TestProblemDocument(t *testing.T) {
+	for _, test := range []struct {
+		accept   string
+		given    Error
+		want     string
+		wantType string
+	}{
+		{"", ErrInvalidToken, `{"error":"invalid_token","error_description":"Access Token not valid","request_id":"abc-123"}` + "\n",
+			"application/json;charset=UTF-8"},
+		{"application/json", ErrInvalidToken, `{"error":"invalid_token","error_description":"Access Token not valid","request_id":"abc-123"}` + "\n",
+			"application/json;charset=UTF-8"},
+		{"application/problem+json;q=0, application/json", ErrInvalidToken,
+			`{"error":"invalid_token","error_description":"Access Token not valid","request_id":"abc-123"}` + "\n", "application/json;charset=UTF-8"},
+		{"application/json;q=0.5, Application/Problem+JSON", ErrInvalidToken,
+			`{"type":"https://tools.ietf.org/html/rfc6750#section-3.1","title":"Unauthorized","status":401,"detail":"Access Token not valid",` +
+				`"instance":"/oauth2/tokeninfo","error":"invalid_token","request_id":"abc-123"}` + "\n", "application/problem+json"},
+		{"application/problem+json", ErrRateLimited,
+			`{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"Too many requests",` +
+				`"instance":"/oauth2/tokeninfo","error":"rate_limited","request_id":"abc-123"}` + "\n", "application/problem+json"},
+		{"application/problem+json", Error{Error: "invalid_token", ErrorDescription: "Access Token not valid", ErrorReason: "expired", statusCode: http.StatusUnauthorized},
+			`{"type":"https://tools.ietf.org/html/rfc6750#section-3.1","title":"Unauthorized","status":401,"detail":"Access Token not valid",` +
+				`"instance":"/oauth2/tokeninfo","error":"invalid_token","error_reason":"expired","request_id":"abc-123"}` + "\n", "application/problem+json"},
+	} {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Request-Id", "abc-123")
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		test.given.Write(w, r)
+
+		if w.Body.String() != test.want {
+			t.Errorf("Wrong body for %q. Wanted %q, got %q", test.accept, test.want, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.wantType {
+			t.Errorf("Wrong content type for %q. Wanted %q, got %q", test.accept, test.wantType, ct)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tokeninfo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag returns a weak entity tag for the Token Info response with the cache key key, see VariantKey, of
+// a token that expires at expiry. The expires_in of the responses goes down every second, so the tag only
+// says that the responses are equivalent: same token, same variant and same expiry
+func ETag(key string, expiry int64) string {
+	sum := sha256.Sum256([]byte(key + "@" + strconv.FormatInt(expiry, 10)))
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+type conditionalHandler struct {
+	next http.Handler
+}
+
+// NewConditionalHandler returns an http.Handler that answers the GET and HEAD requests whose If-None-Match
+// header matches the ETag of a successful response of next with 304 Not Modified. next always runs to the
+// end, so that the policy, the audit log and the metrics see the real response, only its body is dropped.
+// It must wrap all the handlers that can still reject a token
+func NewConditionalHandler(next http.Handler) http.Handler {
+	return &conditionalHandler{next: next}
+}
+
+func (h *conditionalHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ifNoneMatch := req.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	h.next.ServeHTTP(&conditionalWriter{ResponseWriter: w, ifNoneMatch: ifNoneMatch}, req)
+}
+
+// conditionalWriter replaces a successful response whose ETag matches ifNoneMatch with 304 Not Modified
+type conditionalWriter struct {
+	http.ResponseWriter
+	ifNoneMatch string
+	wroteHeader bool
+	notModified bool
+}
+
+func (w *conditionalWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status != http.StatusOK || !matchesETag(w.ifNoneMatch, w.Header().Get("ETag")) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	incCounter("planb.tokeninfo.notmodified")
+	w.notModified = true
+	// a 304 has no body to describe
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(http.StatusNotModified)
+}
+
+func (w *conditionalWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// matchesETag compares the If-None-Match header with etag. The comparison is weak, W/ prefixes are ignored
+func matchesETag(header string, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package tokeninfo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETag(t *testing.T) {
+	etag := ETag("hash", 1600000000)
+	if etag != ETag("hash", 1600000000) {
+		t.Error("The same token and expiry should have the same tag")
+	}
+	for _, other := range []string{ETag("other", 1600000000), ETag("hash", 1600000001), ETag(VariantKey("hash", "caller"), 1600000000)} {
+		if other == etag {
+			t.Errorf("Another token, expiry or variant should have another tag than %s", etag)
+		}
+	}
+}
+
+func TestConditionalHandler(t *testing.T) {
+	etag := ETag("hash", 1600000000)
+	for _, test := range []struct {
+		method      string
+		ifNoneMatch string
+		status      int
+		want        int
+	}{
+		{http.MethodGet, "", http.StatusOK, http.StatusOK},
+		{http.MethodGet, etag, http.StatusOK, http.StatusNotModified},
+		{http.MethodHead, etag, http.StatusOK, http.StatusNotModified},
+		{http.MethodGet, etag[2:], http.StatusOK, http.StatusNotModified},
+		{http.MethodGet, `"other", ` + etag, http.StatusOK, http.StatusNotModified},
+		{http.MethodGet, "*", http.StatusOK, http.StatusNotModified},
+		{http.MethodGet, `W/"other"`, http.StatusOK, http.StatusOK},
+		{http.MethodPost, etag, http.StatusOK, http.StatusOK},
+		// for ex. a token denied by the policy after it was validated
+		{http.MethodGet, etag, http.StatusForbidden, http.StatusForbidden},
+		{http.MethodGet, "*", http.StatusUnauthorized, http.StatusUnauthorized},
+	} {
+		var served bool
+		h := NewConditionalHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			served = true
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.status)
+			w.Write([]byte(`{"uid":"foo"}`))
+		}))
+		req := httptest.NewRequest(test.method, "/oauth2/tokeninfo", nil)
+		if test.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if !served || rw.Code != test.want {
+			t.Errorf("Wrong status for %s with If-None-Match %q. Wanted %d, got %d", test.method, test.ifNoneMatch, test.want, rw.Code)
+		}
+		if rw.Code == http.StatusNotModified && (rw.Body.Len() != 0 || rw.Header().Get("Content-Type") != "" || rw.Header().Get("ETag") != etag) {
+			t.Errorf("A 304 should have the ETag, without a body or Content-Type, got %v %q", rw.Header(), rw.Body.String())
+		}
+	}
+
+	// responses without a tag never match
+	h := NewConditionalHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("{}")) }))
+	req := httptest.NewRequest(http.MethodGet, "/oauth2/tokeninfo", nil)
+	req.Header.Set("If-None-Match", "*")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Errorf("A response without an ETag should be sent, got %d", rw.Code)
+	}
+}
@@ -59,3 +59,12 @@ func AccessTokenFromRequest(req *http.Request) string {
 
 	return req.FormValue(accessTokenParameter)
 }
+
+// NewTokenInfoRequest builds the Request used to validate token with the Token Info handlers on behalf of req,
+// for ex. for the introspection or the batch endpoints. It keeps the remote address and the context of req
+func NewTokenInfoRequest(req *http.Request, token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/oauth2/tokeninfo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.RemoteAddr = req.RemoteAddr
+	return r.WithContext(req.Context())
+}
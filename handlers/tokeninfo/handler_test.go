@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -72,3 +73,38 @@ func TestAccessTokenFromRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestAccessTokenFromPostRequest(t *testing.T) {
+	for _, test := range []struct {
+		body          string
+		authorization string
+		want          string
+	}{
+		{"", "", ""},
+		{"access_token=foo", "", "foo"},
+		{"access_token=foo", "Bearer bar", "bar"},
+		{"", "Bearer bar", "bar"},
+	} {
+		req, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		at := AccessTokenFromRequest(req)
+		if test.want != at {
+			t.Errorf("Unexpected access token from request. Wanted %q, got %q", test.want, at)
+		}
+	}
+}
+
+func TestNewTokenInfoRequest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/oauth2/introspect", strings.NewReader("token=foo"))
+	req.RemoteAddr = "10.0.0.1:4711"
+	r := NewTokenInfoRequest(req, "foo")
+	if r.Method != http.MethodGet || r.URL.Path != "/oauth2/tokeninfo" || AccessTokenFromRequest(r) != "foo" {
+		t.Errorf("Wrong token info request: %s %s %q", r.Method, r.URL, AccessTokenFromRequest(r))
+	}
+	if r.RemoteAddr != req.RemoteAddr || r.Context() != req.Context() {
+		t.Errorf("The token info request should keep the remote address and the context, got %q", r.RemoteAddr)
+	}
+}
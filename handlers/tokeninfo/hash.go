@@ -0,0 +1,41 @@
+package tokeninfo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+// hashPrefixLength is the length of the hash prefix used to correlate requests for the same token in
+// logs and traces. It's enough to tell tokens apart without identifying them outside this deployment
+const hashPrefixLength = 12
+
+// HashToken returns the hex encoded SHA-256 hash of the Access Token. It can be used as an identifier
+// for a token whenever keeping the token itself in memory is not needed. With options.TokenHashSalt
+// the hash is an HMAC keyed with the salt, so hashes can't be matched against other deployments
+func HashToken(token string) string {
+	if salt := options.Current().TokenHashSalt; salt != "" {
+		mac := hmac.New(sha256.New, []byte(salt))
+		mac.Write([]byte(token))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashPrefix returns the start of the HashToken hash of the Access Token. Use it instead of the token
+// in logs and traces
+func HashPrefix(token string) string {
+	return HashToken(token)[:hashPrefixLength]
+}
+
+// VariantKey returns the cache key of a variant of the Token Info response for the token with the HashToken
+// hash, for ex. the response with the scopes of a caller. The default variant, "", is keyed by the hash alone
+func VariantKey(hash string, variant string) string {
+	if variant == "" {
+		return hash
+	}
+	return hash + "/" + variant
+}
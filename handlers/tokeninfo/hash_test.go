@@ -0,0 +1,29 @@
+package tokeninfo
+
+import (
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+func TestHashToken(t *testing.T) {
+	defer func(s string) { options.Current().TokenHashSalt = s }(options.Current().TokenHashSalt)
+	for _, test := range []struct {
+		token      string
+		salt       string
+		want       string
+		wantPrefix string
+	}{
+		{"", "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "e3b0c44298fc"},
+		{"foo", "", "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", "2c26b46b68ff"},
+		{"foo", "salt", "6a9534d88e984dfcea835f190147b72b3f647fdcc2409e5b8be8b331ec7fe8a5", "6a9534d88e98"},
+	} {
+		options.Current().TokenHashSalt = test.salt
+		if h := HashToken(test.token); h != test.want {
+			t.Errorf("Wrong hash for token %q with salt %q. Wanted %q, got %q", test.token, test.salt, test.want, h)
+		}
+		if p := HashPrefix(test.token); p != test.wantPrefix {
+			t.Errorf("Wrong hash prefix for token %q with salt %q. Wanted %q, got %q", test.token, test.salt, test.wantPrefix, p)
+		}
+	}
+}
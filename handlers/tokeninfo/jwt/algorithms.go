@@ -0,0 +1,58 @@
+package jwthandler
+
+import (
+	"slices"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SupportedAlgorithms are the signing algorithms of the JWT tokens that can be validated. Tokens signed
+// with "none" or an HMAC algorithm are never accepted, there is no shared secret to verify them
+var SupportedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// AllowedAlgorithms returns the SupportedAlgorithms that are also in allowed, all of them if allowed is empty
+func AllowedAlgorithms(allowed []string) []string {
+	if len(allowed) == 0 {
+		return SupportedAlgorithms
+	}
+	var algs []string
+	for _, alg := range SupportedAlgorithms {
+		if slices.Contains(allowed, alg) {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// algorithmPolicy tells which signing algorithms are accepted. The algorithms of an issuer replace the
+// default ones for the tokens of that issuer
+type algorithmPolicy struct {
+	algs    []string
+	issuers map[string][]string
+}
+
+func newAlgorithmPolicy(allowed []string, issuers map[string][]string) *algorithmPolicy {
+	p := &algorithmPolicy{algs: AllowedAlgorithms(allowed), issuers: make(map[string][]string, len(issuers))}
+	for iss, algs := range issuers {
+		p.issuers[iss] = AllowedAlgorithms(algs)
+	}
+	return p
+}
+
+// allows checks the signing method of t against the algorithms of its issuer
+func (p *algorithmPolicy) allows(t *jwt.Token) bool {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *SigningMethodEdDSA:
+	default:
+		return false
+	}
+	algs := p.algs
+	if len(p.issuers) > 0 {
+		if iss, ok := tokenIssuer(t); ok {
+			if ia, has := p.issuers[iss]; has {
+				algs = ia
+			}
+		}
+	}
+	return slices.Contains(algs, t.Method.Alg())
+}
@@ -0,0 +1,62 @@
+package jwthandler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestAllowedAlgorithms(t *testing.T) {
+	for _, test := range []struct {
+		allowed []string
+		want    []string
+	}{
+		{nil, SupportedAlgorithms},
+		{[]string{"PS256", "RS256"}, []string{"RS256", "PS256"}},
+		{[]string{"HS256", "ES512"}, []string{"ES512"}},
+		{[]string{"HS256", "none"}, nil},
+	} {
+		if algs := AllowedAlgorithms(test.allowed); !reflect.DeepEqual(algs, test.want) {
+			t.Errorf("Wrong algorithms for %v. Wanted %v, got %v", test.allowed, test.want, algs)
+		}
+	}
+}
+
+func TestAlgorithmPolicy(t *testing.T) {
+	policy := newAlgorithmPolicy([]string{"RS256"}, map[string][]string{
+		"https://idp.example.org":   {"PS256", "ES256"},
+		"https://other.example.org": {"HS256"},
+	})
+	for _, test := range []struct {
+		method jwt.SigningMethod
+		claims jwt.MapClaims
+		want   bool
+	}{
+		{jwt.SigningMethodRS256, jwt.MapClaims{}, true},
+		{jwt.SigningMethodPS256, jwt.MapClaims{}, false},
+		{jwt.SigningMethodHS256, jwt.MapClaims{}, false},
+		{jwt.SigningMethodNone, jwt.MapClaims{}, false},
+		{jwt.SigningMethodRS256, jwt.MapClaims{"iss": "https://unknown.example.org"}, true},
+		{jwt.SigningMethodRS256, jwt.MapClaims{"iss": "https://idp.example.org"}, false},
+		{jwt.SigningMethodPS256, jwt.MapClaims{"iss": "https://idp.example.org"}, true},
+		{jwt.SigningMethodES256, jwt.MapClaims{"iss": "https://idp.example.org"}, true},
+		{jwt.SigningMethodHS256, jwt.MapClaims{"iss": "https://other.example.org"}, false},
+		{jwt.SigningMethodRS256, jwt.MapClaims{"iss": "https://other.example.org"}, false},
+	} {
+		token := &jwt.Token{Method: test.method, Claims: test.claims}
+		if ok := policy.allows(token); ok != test.want {
+			t.Errorf("Wrong result for %s with %v. Wanted %t, got %t", test.method.Alg(), test.claims, test.want, ok)
+		}
+	}
+}
+
+func TestRejectedAlgorithmMetrics(t *testing.T) {
+	kf := jwtValidator(new(mockKeyLoader), newAlgorithmPolicy([]string{"ES256"}, nil))
+	kf(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"alg": "RS256"}})
+	c, ok := metrics.DefaultRegistry.Get("planb.tokeninfo.jwt.rejections.unsupported_alg.RS256").(metrics.Counter)
+	if !ok || c.Count() < 1 {
+		t.Error("Rejected algorithms should be counted")
+	}
+}
@@ -0,0 +1,52 @@
+package jwthandler
+
+import (
+	"crypto/ed25519"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method (RFC 8037) for Ed25519 keys.
+// Verification expects an ed25519.PublicKey and signing an ed25519.PrivateKey
+type SigningMethodEdDSA struct{}
+
+// SigningMethodEd25519 is the instance registered for the "EdDSA" alg header
+var SigningMethodEd25519 = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+// Alg returns the name of the algorithm as used in the JWT header
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the base64 URL encoded signature of the signing string with the Ed25519 public key
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pk, ok := key.(ed25519.PublicKey)
+	if !ok || len(pk) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pk, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign returns the base64 URL encoded signature of the signing string using the Ed25519 private key
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	sk, ok := key.(ed25519.PrivateKey)
+	if !ok || len(sk) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(sk, []byte(signingString))), nil
+}
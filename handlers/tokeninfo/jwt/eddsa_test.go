@@ -0,0 +1,47 @@
+package jwthandler
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestSigningMethodEdDSA(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+
+	token := jwt.NewWithClaims(SigningMethodEd25519, jwt.MapClaims{"sub": "foo"})
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatal("Failed to sign token: ", err)
+	}
+
+	if _, err := token.SignedString(pub); err != jwt.ErrInvalidKeyType {
+		t.Errorf("Signing with a public key should fail. Got %v", err)
+	}
+
+	parts := strings.Split(signed, ".")
+	signingString := strings.Join(parts[0:2], ".")
+	for _, test := range []struct {
+		key       interface{}
+		signature string
+		wantError error
+	}{
+		{pub, parts[2], nil},
+		{otherPub, parts[2], jwt.ErrSignatureInvalid},
+		{[]byte(pub), parts[2], jwt.ErrInvalidKeyType},
+		{testRSAPKey, parts[2], jwt.ErrInvalidKeyType},
+		{pub, jwt.EncodeSegment([]byte("foo")), jwt.ErrSignatureInvalid},
+	} {
+		if err := SigningMethodEd25519.Verify(signingString, test.signature, test.key); err != test.wantError {
+			t.Errorf("Unexpected verification result. Wanted %v, got %v", test.wantError, err)
+		}
+	}
+
+	parsed, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return pub, nil })
+	if err != nil || !parsed.Valid || parsed.Method.Alg() != "EdDSA" {
+		t.Errorf("Failed to parse EdDSA token: %v", err)
+	}
+}
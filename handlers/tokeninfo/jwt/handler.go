@@ -1,48 +1,106 @@
 package jwthandler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/denylist"
+	"github.com/zalando/planb-tokeninfo/handlers/callerauth"
 	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/jwe"
 	"github.com/zalando/planb-tokeninfo/keyloader"
+	"github.com/zalando/planb-tokeninfo/latency"
+	"github.com/zalando/planb-tokeninfo/options"
 	"github.com/zalando/planb-tokeninfo/processor"
 	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type jwtHandler struct {
 	keyLoader keyloader.KeyLoader
 	crp       *revoke.CachingRevokeProvider
+	leeway    time.Duration
+	mapping   *processor.ClaimMapping
+	scopes    *processor.ScopePolicy
+	reasons   bool
+	algs      *algorithmPolicy
+	denylist  denylist.List
+	cache     tokeninfo.CachePolicy
+	etags     bool
+	decrypter *jwe.Decrypter
+	// failClosed rejects the tokens when the deny list can't be checked
+	failClosed bool
 }
 
 var (
-	ErrInvalidJWT   = errors.New("Invalid JWT token.")
-	ErrRevokedToken = errors.New("Token is revoked.")
+	ErrInvalidJWT          = errors.New("Invalid JWT token.")
+	ErrRevokedToken        = errors.New("Token is revoked.")
+	ErrDeniedToken         = errors.New("Token ID is denied.")
+	ErrDenyListUnavailable = errors.New("Token ID can't be checked against the deny list.")
 )
 
-// New returns an http.Handler that is able to validate JWT tokens
+// New returns an http.Handler that is able to validate JWT tokens. The exp, nbf and iat claims are
+// validated with the clock skew from options.ClockSkew and the responses are changed by options.ScopePolicy
+// and options.ClaimMapping. With options.ErrorReasons the error responses tell why a token was rejected.
+// Only tokens signed with the AllowedAlgorithms of options.JwtAllowedAlgorithms, or of options.IssuerAlgorithms
+// for their issuer, are accepted. With options.JWEDecrypter, JWE tokens are decrypted and the JWT they contain
+// is validated like the others. With options.ResponseETags the responses have an ETag, see
+// tokeninfo.NewConditionalHandler
 func New(kl keyloader.KeyLoader, crp *revoke.CachingRevokeProvider) tokeninfo.Handler {
-	return &jwtHandler{keyLoader: kl, crp: crp}
+	return NewWithDenyList(kl, crp, nil)
+}
+
+// NewWithDenyList returns an http.Handler like New that also rejects the tokens whose jti claim is in the
+// deny list dl. Tokens are not rejected when the deny list can't be checked, unless options.JtiDenyListFailClosed
+// is set, then they are answered with status 503
+func NewWithDenyList(kl keyloader.KeyLoader, crp *revoke.CachingRevokeProvider, dl denylist.List) tokeninfo.Handler {
+	settings := options.Current()
+	return &jwtHandler{
+		keyLoader:  kl,
+		crp:        crp,
+		leeway:     settings.ClockSkew,
+		mapping:    settings.ClaimMapping,
+		scopes:     settings.ScopePolicy,
+		reasons:    settings.ErrorReasons,
+		algs:       newAlgorithmPolicy(settings.JwtAllowedAlgorithms, settings.IssuerAlgorithms),
+		denylist:   dl,
+		cache:      tokeninfo.CachePolicy{MaxAge: settings.ResponseCacheMaxAge, Directives: settings.ResponseCacheDirectives},
+		etags:      settings.ResponseETags,
+		decrypter:  settings.JWEDecrypter,
+		failClosed: settings.JtiDenyListFailClosed,
+	}
 }
 
 // ServeHTTP will validate the JWT token in the Request and send back the TokenInfo in case
 // of success or the appropriate error messages otherwise. Both are sent in JSON.
 func (h *jwtHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	ti, err := h.validateToken(r)
+	token, ti, err := h.validateToken(r)
 	if err == nil && ti != nil {
+		h.cache.Set(w.Header(), time.Duration(ti.ExpiresIn)*time.Second, start)
+		policy, variant := h.scopes.ForCaller(callerauth.FromContext(r.Context()))
+		if h.etags {
+			// the token was validated again, a revoked token never gets the tag. The tag is the one of the token the
+			// client presented, a JWE rather than the JWT inside of it
+			exp, _ := ClaimAsInt64(token, JwtClaimExp)
+			w.Header().Set("ETag", tokeninfo.ETag(tokeninfo.VariantKey(tokeninfo.HashToken(tokeninfo.AccessTokenFromRequest(r)), variant), exp))
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if err := Marshal(ti, w); err != nil {
-			fmt.Println("Error serializing the token info: ", err)
+		ti.Scope = policy.Apply(ti.Scope, ti.Realm)
+		if err := h.writeResponse(w, token, ti); err != nil {
+			slog.Warn("Failed to serialize the token info response", "error", err)
 		} else {
-			measureRequest(start, fmt.Sprintf("planb.tokeninfo.jwt.%s.requests", ti.Realm))
+			measureRequest(start, "planb.tokeninfo.jwt."+ti.Realm+".requests")
 		}
 		return
 	}
@@ -51,31 +109,111 @@ func (h *jwtHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch err {
 	case request.ErrNoTokenInRequest:
 		tie = tokeninfo.ErrInvalidRequest
+	case ErrDenyListUnavailable:
+		tie = tokeninfo.ErrTemporarilyUnavailable
 	default:
 		tie = tokeninfo.ErrInvalidToken
 	}
+	reason := errorReason(err)
 	registerError(tie)
-	tie.Write(w)
+	registerRejection(reason)
+	if h.reasons {
+		tie.ErrorReason = reason
+	}
+	tie.Write(w, r)
 }
 
-func (h *jwtHandler) validateToken(req *http.Request) (*processor.TokenInfo, error) {
+// writeResponse sends the Token Info response for ti. Without a claim mapping the response is written by a
+// pooled responseEncoder, which avoids building a map for every request
+func (h *jwtHandler) writeResponse(w http.ResponseWriter, token *jwt.Token, ti *processor.TokenInfo) error {
+	if h.mapping == nil {
+		return Marshal(ti, w)
+	}
+	m := response(ti)
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		h.mapping.Apply(claims, m)
+	}
+	return json.NewEncoder(w).Encode(m)
+}
+
+func (h *jwtHandler) validateToken(req *http.Request) (*jwt.Token, *processor.TokenInfo, error) {
+	_, span := tracing.Start(req.Context(), "jwt.validate")
+	defer span.End()
+
 	start := time.Now()
-	token, err := request.ParseFromRequest(req, request.OAuth2Extractor, jwtValidator(h.keyLoader))
+	var extractor request.Extractor = request.OAuth2Extractor
+	if h.decrypter != nil {
+		extractor = &jweExtractor{Extractor: extractor, decrypter: h.decrypter}
+	}
+	token, err := request.ParseFromRequest(req, extractor, jwtValidator(h.keyLoader, h.algs), request.WithParser(parser))
 	if err != nil {
-		log.Println("Failed to validate token: ", err)
-		return nil, err
+		slog.Debug("Failed to validate token", "error", err)
+		tracing.Fail(span, err)
+		return nil, nil, err
 	}
 
-	measureRequest(start, fmt.Sprintf("planb.tokeninfo.jwt.validation.%s", token.Method.Alg()))
+	measureRequest(start, "planb.tokeninfo.jwt.validation."+token.Method.Alg())
+	latency.Observe("planb.tokeninfo.jwt.validation.latency", start)
+	span.SetAttributes(attribute.String("jwt.alg", token.Method.Alg()))
 	if !token.Valid {
-		log.Println("Failed to validate token: ", ErrInvalidJWT)
-		return nil, ErrInvalidJWT
+		slog.Debug("Failed to validate token", "error", ErrInvalidJWT)
+		tracing.Fail(span, ErrInvalidJWT)
+		return nil, nil, ErrInvalidJWT
+	}
+	if err := validateTimeClaims(token, time.Now(), h.leeway); err != nil {
+		slog.Debug("Failed to validate token", "error", err)
+		tracing.Fail(span, err)
+		return nil, nil, err
+	}
+	if h.isRevoked(req, token) {
+		slog.Debug("Failed to validate token", "error", ErrRevokedToken)
+		tracing.Fail(span, ErrRevokedToken)
+		return nil, nil, ErrRevokedToken
+	}
+	if denied, err := h.isDenied(token); err != nil || denied {
+		if err == nil {
+			err = ErrDeniedToken
+		}
+		slog.Debug("Failed to validate token", "error", err)
+		tracing.Fail(span, err)
+		return nil, nil, err
+	}
+	ti, err := NewTokenInfo(token, time.Now())
+	if err != nil {
+		tracing.Fail(span, err)
+	}
+	return token, ti, err
+}
+
+// isRevoked checks the revocations of the token. TOKEN revocations can be for the JWE that the client presented
+// as well as for the JWT inside of it
+func (h *jwtHandler) isRevoked(req *http.Request, token *jwt.Token) bool {
+	if presented := tokeninfo.AccessTokenFromRequest(req); presented != token.Raw {
+		return h.crp.IsJWERevoked(token, presented)
+	}
+	return h.crp.IsJWTRevoked(token)
+}
+
+// isDenied checks the jti claim of t against the deny list. When the list can't be checked, the token is not
+// denied, or ErrDenyListUnavailable is returned with failClosed
+func (h *jwtHandler) isDenied(t *jwt.Token) (bool, error) {
+	if h.denylist == nil {
+		return false, nil
+	}
+	jti, ok := ClaimAsString(t, JwtClaimID)
+	if !ok {
+		return false, nil
 	}
-	if h.crp.IsJWTRevoked(token) {
-		log.Println("Failed to validate token: ", ErrRevokedToken)
-		return nil, ErrRevokedToken
+	denied, err := h.denylist.Contains(jti)
+	if err != nil {
+		slog.Warn("Failed to check the deny list", "error", err, "fail_closed", h.failClosed)
+		incCounter("planb.tokeninfo.denylist.errors")
+		if h.failClosed {
+			return false, ErrDenyListUnavailable
+		}
+		return false, nil
 	}
-	return NewTokenInfo(token, time.Now())
+	return denied, nil
 }
 
 // Checks if the Request contains a JWT that can be handled by this Handler
@@ -84,10 +222,37 @@ func (h *jwtHandler) Match(r *http.Request) bool {
 	if token == "" {
 		return false
 	}
+	return IsJWT(token, h.decrypter != nil)
+}
 
-	parts := strings.Split(token, ".")
+// IsJWT returns true if the token has the parts of a JWT, or of a JWE when the tokens can be decrypted
+func IsJWT(token string, decrypt bool) bool {
+	parts := strings.Count(token, ".")
+	return parts == 2 || (parts == 4 && decrypt)
+}
 
-	return len(parts) == 3
+// jweExtractor is a request.Extractor that replaces the JWE tokens of the request with the JWT they contain
+type jweExtractor struct {
+	request.Extractor
+	decrypter *jwe.Decrypter
+}
+
+func (e *jweExtractor) ExtractToken(req *http.Request) (string, error) {
+	token, err := e.Extractor.ExtractToken(req)
+	if err != nil || !jwe.IsJWE(token) {
+		return token, err
+	}
+	plaintext, err := e.decrypter.Decrypt(token)
+	if err != nil {
+		return "", err
+	}
+	// only signed JWTs are accepted inside, a nested JWE or an unsecured JWT is not
+	nested := string(plaintext)
+	if strings.Count(nested, ".") != 2 {
+		return "", fmt.Errorf("%w: the content is not a JWT", jwe.ErrMalformed)
+	}
+	incCounter("planb.tokeninfo.jwt.decrypted")
+	return nested, nil
 }
 
 func measureRequest(start time.Time, key string) {
@@ -96,6 +261,19 @@ func measureRequest(start time.Time, key string) {
 	}
 }
 
+func registerRejection(reason string) {
+	key := fmt.Sprintf("planb.tokeninfo.jwt.rejections.%s", reason)
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
 func registerError(err tokeninfo.Error) {
 	key := fmt.Sprintf("planb.tokeninfo.jwt.errors.%s", err.Error)
 	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
@@ -1,19 +1,30 @@
 package jwthandler
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/jwe"
+	"github.com/zalando/planb-tokeninfo/options"
 	"github.com/zalando/planb-tokeninfo/processor"
 	"github.com/zalando/planb-tokeninfo/revoke"
 )
@@ -106,6 +117,36 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestHandlerPost(t *testing.T) {
+	kl := new(mockKeyLoader)
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+	h := New(kl, crp)
+
+	for _, test := range []struct {
+		body          string
+		authorization string
+		wantCode      int
+	}{
+		{"", "", http.StatusBadRequest},
+		{"access_token=" + testRSAToken, "", http.StatusOK},
+		{"", "Bearer " + testECDSAToken, http.StatusOK},
+		{"", "Bearer foo", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		h.ServeHTTP(w, req)
+
+		if test.wantCode != w.Code {
+			t.Errorf("Wrong status code. Wanted %d, got %d", test.wantCode, w.Code)
+		}
+	}
+}
+
 func TestRoutingMatch(t *testing.T) {
 	kl := new(mockKeyLoader)
 	u, _ := url.Parse("localhost")
@@ -130,6 +171,113 @@ func TestRoutingMatch(t *testing.T) {
 	}
 }
 
+// encryptJWE returns a JWE token with the content encrypted for key with RSA-OAEP and A128GCM
+func encryptJWE(t *testing.T, content string, key *rsa.PrivateKey) string {
+	b64 := base64.RawURLEncoding
+	cek := make([]byte, 16)
+	rand.Read(cek)
+	encryptedKey, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, &key.PublicKey, cek, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := b64.EncodeToString([]byte(`{"alg":"RSA-OAEP","enc":"A128GCM","kid":"enc1","cty":"JWT"}`))
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	iv := make([]byte, gcm.NonceSize())
+	rand.Read(iv)
+	sealed := gcm.Seal(nil, iv, []byte(content), []byte(protected))
+	n := len(sealed) - gcm.Overhead()
+	return strings.Join([]string{protected, b64.EncodeToString(encryptedKey), b64.EncodeToString(iv), b64.EncodeToString(sealed[:n]), b64.EncodeToString(sealed[n:])}, ".")
+}
+
+func TestHandlerJWE(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	d, err := jwe.NewDecrypter(map[string]interface{}{"enc1": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+	h := New(new(mockKeyLoader), crp).(*jwtHandler)
+	h.reasons = true
+	encrypted := encryptJWE(t, testRSAToken, key)
+	// the revocation is for the JWE the client presents, not for the JWT inside of it
+	revoked := encryptJWE(t, testRSAToken, key)
+	sum := sha256.Sum256([]byte(options.Current().HashingSalt + revoked))
+	if _, err := crp.AddRevocations([]byte(`{"revocations":[{"type":"TOKEN","data":{"token_hash":"` + base64.URLEncoding.EncodeToString(sum[:]) + `"}}]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+encrypted, nil)
+	if h.Match(req) {
+		t.Error("JWE tokens should not match without a decrypter")
+	}
+	h.decrypter = d
+	if !h.Match(req) {
+		t.Error("JWE tokens should match with a decrypter")
+	}
+
+	for _, test := range []struct {
+		name       string
+		token      string
+		wantCode   int
+		wantReason string
+	}{
+		{"encrypted JWT", encrypted, http.StatusOK, ""},
+		{"plain JWT", testRSAToken, http.StatusOK, ""},
+		{"other key", encryptJWE(t, testRSAToken, otherKey), http.StatusUnauthorized, ReasonUndecryptable},
+		{"opaque content", encryptJWE(t, "opaque", key), http.StatusUnauthorized, ReasonMalformed},
+		{"unsigned JWT", encryptJWE(t, "eyJhbGciOiJub25lIn0.e30", key), http.StatusUnauthorized, ReasonMalformed},
+		{"revoked JWE", revoked, http.StatusUnauthorized, ReasonRevoked},
+		{"bad signature", encryptJWE(t, testRSAToken[:len(testRSAToken)-4]+"AAAA", key), http.StatusUnauthorized, ReasonBadSignature},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+test.token, nil)
+		h.ServeHTTP(w, req)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d: %s", test.name, test.wantCode, w.Code, w.Body.String())
+		}
+		if test.wantReason != "" && !strings.Contains(w.Body.String(), `"error_reason":"`+test.wantReason+`"`) {
+			t.Errorf("Wrong error reason for %s. Wanted %q, got %s", test.name, test.wantReason, w.Body.String())
+		}
+	}
+}
+
+func TestHandlerETag(t *testing.T) {
+	u, _ := url.Parse("localhost")
+	h := New(new(mockKeyLoader), revoke.NewCachingRevokeProvider(u)).(*jwtHandler)
+	ch := tokeninfo.NewConditionalHandler(h)
+	get := func(token string, ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		ch.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get(testRSAToken, ""); w.Header().Get("ETag") != "" {
+		t.Error("Responses should not have an ETag by default")
+	}
+	h.etags = true
+	etag := get(testRSAToken, "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Missing ETag")
+	}
+	if w := get(testRSAToken, etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Wrong response for an unchanged token. Wanted 304 without a body, got %d: %q", w.Code, w.Body.String())
+	}
+	if w := get(testECDSAToken, etag); w.Code != http.StatusOK {
+		t.Errorf("Wrong status code for another token. Wanted 200, got %d", w.Code)
+	}
+	if w := get("foo", "*"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status code for an invalid token. Wanted 401, got %d", w.Code)
+	}
+}
+
 func TestHandlerCreation(t *testing.T) {
 	kl := new(mockKeyLoader)
 	u, _ := url.Parse("localhost")
@@ -144,3 +292,39 @@ func TestHandlerCreation(t *testing.T) {
 		t.Error("Handler doesn't have the right key loader")
 	}
 }
+
+func benchmarkHandler(token string, b *testing.B) {
+	u, _ := url.Parse("localhost")
+	h := New(new(mockKeyLoader), revoke.NewCachingRevokeProvider(u))
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("Wrong status code %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func BenchmarkHandlerRSA(b *testing.B) {
+	benchmarkHandler(testRSAToken, b)
+}
+
+func BenchmarkHandlerECDSA(b *testing.B) {
+	benchmarkHandler(testECDSAToken, b)
+}
+
+func BenchmarkHandlerInvalid(b *testing.B) {
+	u, _ := url.Parse("localhost")
+	h := New(new(mockKeyLoader), revoke.NewCachingRevokeProvider(u))
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=a.b.c", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
@@ -3,8 +3,11 @@ package jwthandler
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/dgrijalva/jwt-go/request"
+	"github.com/zalando/planb-tokeninfo/jwe"
 	"github.com/zalando/planb-tokeninfo/keyloader"
 )
 
@@ -13,16 +16,49 @@ var (
 	ErrMissingKeyID = errors.New("Missing key Id in the JWT header")
 	// ErrInvalidKeyID should be used when the content of the kid attribute is invalid
 	ErrInvalidKeyID = errors.New("Invalid key Id in the JWT header")
+	// ErrTokenExpired should be used when the exp claim is in the past
+	ErrTokenExpired = errors.New("Token is expired")
+	// ErrTokenNotValidYet should be used when the nbf claim is in the future
+	ErrTokenNotValidYet = errors.New("Token is not valid yet")
+	// ErrTokenIssuedInFuture should be used when the iat claim is in the future
+	ErrTokenIssuedInFuture = errors.New("Token was issued in the future")
+	// ErrUnsupportedAlgorithm should be used when the token is signed with an algorithm that isn't accepted
+	ErrUnsupportedAlgorithm = errors.New("Unsupported signing method")
 )
 
-func jwtValidator(kl keyloader.KeyLoader) jwt.Keyfunc {
+// Reasons for rejected tokens, reported in the metrics and optionally in the error responses
+const (
+	ReasonMissingToken        = "missing_token"
+	ReasonMalformed           = "malformed"
+	ReasonUnsupportedAlg      = "unsupported_alg"
+	ReasonMissingKeyID        = "missing_kid"
+	ReasonUnknownKeyID        = "unknown_kid"
+	ReasonUnknownIssuer       = "unknown_issuer"
+	ReasonBadSignature        = "bad_signature"
+	ReasonExpired             = "expired"
+	ReasonNotValidYet         = "not_valid_yet"
+	ReasonIssuedInFuture      = "issued_in_future"
+	ReasonRevoked             = "revoked"
+	ReasonDenied              = "denied"
+	ReasonInvalidClaims       = "invalid_claims"
+	ReasonInvalid             = "invalid"
+	ReasonUndecryptable       = "undecryptable"
+	ReasonDenyListUnavailable = "denylist_unavailable"
+)
+
+// the time based claims are not checked by the parser but by validateTimeClaims, with a leeway. The claims
+// are decoded into jwt.MapClaims, the processors and the claim mappings read any claim of the tokens. Only
+// the responses are encoded without maps, see responseEncoder
+var parser = &jwt.Parser{SkipClaimsValidation: true}
+
+// jwtValidator returns the Keyfunc that loads the key of tokens signed with an algorithm of the policy
+func jwtValidator(kl keyloader.KeyLoader, policy *algorithmPolicy) jwt.Keyfunc {
 	return func(token *jwt.Token) (interface{}, error) {
-		switch token.Method.(type) {
-		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
-			return loadKey(kl, token)
-		default:
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		if !policy.allows(token) {
+			registerRejection(ReasonUnsupportedAlg + "." + token.Method.Alg())
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedAlgorithm, token.Header["alg"])
 		}
+		return loadKey(kl, token)
 	}
 }
 
@@ -38,5 +74,83 @@ func loadKey(kl keyloader.KeyLoader, t *jwt.Token) (interface{}, error) {
 		return nil, ErrInvalidKeyID
 	}
 
-	return kl.LoadKey(id)
+	if il, ok := kl.(keyloader.IssuerKeyLoader); ok {
+		iss, _ := tokenIssuer(t)
+		return il.LoadIssuerKey(iss, id)
+	}
+
+	return keyloader.FetchKey(kl, id)
+}
+
+// errorReason tells why a token was rejected with err. Errors from the parser are classified by their
+// flags, except for the errors of the key lookup which are wrapped by the parser
+func errorReason(err error) string {
+	var ve *jwt.ValidationError
+	if errors.As(err, &ve) {
+		switch {
+		case ve.Errors&jwt.ValidationErrorMalformed != 0:
+			return ReasonMalformed
+		case ve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return ReasonBadSignature
+		case ve.Inner == nil:
+			// the parser doesn't know the alg of the token
+			return ReasonUnsupportedAlg
+		}
+		err = ve.Inner
+	}
+
+	switch {
+	case errors.Is(err, request.ErrNoTokenInRequest):
+		return ReasonMissingToken
+	case errors.Is(err, jwe.ErrMalformed):
+		return ReasonMalformed
+	case errors.Is(err, jwe.ErrUnsupported):
+		return ReasonUnsupportedAlg
+	case errors.Is(err, jwe.ErrUnknownKey), errors.Is(err, jwe.ErrDecryption):
+		return ReasonUndecryptable
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		return ReasonUnsupportedAlg
+	case errors.Is(err, ErrMissingKeyID), errors.Is(err, ErrInvalidKeyID):
+		return ReasonMissingKeyID
+	case errors.Is(err, keyloader.ErrKeyNotFound):
+		return ReasonUnknownKeyID
+	case errors.Is(err, keyloader.ErrUnknownIssuer):
+		return ReasonUnknownIssuer
+	case errors.Is(err, ErrTokenExpired):
+		return ReasonExpired
+	case errors.Is(err, ErrTokenNotValidYet):
+		return ReasonNotValidYet
+	case errors.Is(err, ErrTokenIssuedInFuture):
+		return ReasonIssuedInFuture
+	case errors.Is(err, ErrRevokedToken):
+		return ReasonRevoked
+	case errors.Is(err, ErrDeniedToken):
+		return ReasonDenied
+	case errors.Is(err, ErrDenyListUnavailable):
+		return ReasonDenyListUnavailable
+	case errors.Is(err, ErrInvalidClaimScope), errors.Is(err, ErrInvalidClaimRealm), errors.Is(err, ErrInvalidClaimSub),
+		errors.Is(err, ErrInvalidClaimAzp), errors.Is(err, ErrInvalidClaimExp), errors.Is(err, ErrInvalidClaimAud),
+		errors.Is(err, ErrInvalidClaimEmail):
+		return ReasonInvalidClaims
+	}
+	return ReasonInvalid
+}
+
+// validateTimeClaims checks the exp, nbf and iat claims of t, if present, against now. Up to leeway of
+// clock skew between the token issuer and this service is tolerated in both directions
+func validateTimeClaims(t *jwt.Token, now time.Time, leeway time.Duration) error {
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return ErrInvalidJWT
+	}
+	l := int64(leeway / time.Second)
+	switch {
+	case !claims.VerifyExpiresAt(now.Unix()-l, false):
+		return ErrTokenExpired
+	case !claims.VerifyNotBefore(now.Unix()+l, false):
+		return ErrTokenNotValidYet
+	case !claims.VerifyIssuedAt(now.Unix()+l, false):
+		return ErrTokenIssuedInFuture
+	}
+	return nil
 }
@@ -1,9 +1,25 @@
 package jwthandler
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/dgrijalva/jwt-go/request"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/denylist"
+	"github.com/zalando/planb-tokeninfo/jwe"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/revoke"
 )
 
 func TestLoadKey(t *testing.T) {
@@ -31,29 +47,63 @@ func TestLoadKey(t *testing.T) {
 	}
 }
 
+func TestLoadIssuerKey(t *testing.T) {
+	kl := keyloader.NewIssuerKeyLoader(nil, map[string]keyloader.KeyLoader{"https://idp.example.org": new(mockKeyLoader)})
+	for _, test := range []struct {
+		claims    jwt.MapClaims
+		want      interface{}
+		wantError bool
+	}{
+		{jwt.MapClaims{"iss": "https://idp.example.org"}, testRSAPKey, false},
+		{jwt.MapClaims{"iss": "https://other.example.org"}, nil, true},
+		{jwt.MapClaims{}, nil, true},
+	} {
+		token := &jwt.Token{Header: map[string]interface{}{"kid": "RS256"}, Claims: test.claims}
+		k, err := loadKey(kl, token)
+
+		if test.wantError != (err != nil) {
+			t.Errorf("Unexpected error status for %v: %v", test.claims, err)
+		}
+
+		if k != test.want {
+			t.Errorf("Unexpected key loaded. Wanted %v, got %v", test.want, k)
+		}
+	}
+}
+
 func TestJwtValidator(t *testing.T) {
 	kl := new(mockKeyLoader)
-	kf := jwtValidator(kl)
 	for _, test := range []struct {
 		method    jwt.SigningMethod
+		algs      []string
 		want      interface{}
 		wantError bool
 	}{
-		{jwt.SigningMethodHS256, nil, true},
-		{jwt.SigningMethodHS384, nil, true},
-		{jwt.SigningMethodHS512, nil, true},
-		{jwt.SigningMethodRS256, nil, false},
-		{jwt.SigningMethodRS384, nil, false},
-		{jwt.SigningMethodRS512, nil, false},
-		{jwt.SigningMethodES256, nil, false},
-		{jwt.SigningMethodES384, nil, false},
-		{jwt.SigningMethodES512, nil, false},
+		{jwt.SigningMethodHS256, SupportedAlgorithms, nil, true},
+		{jwt.SigningMethodHS384, SupportedAlgorithms, nil, true},
+		{jwt.SigningMethodHS512, SupportedAlgorithms, nil, true},
+		{jwt.SigningMethodHS256, []string{"HS256"}, nil, true},
+		{jwt.SigningMethodRS256, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodRS384, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodRS512, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodPS256, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodPS384, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodPS512, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodES256, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodES384, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodES512, SupportedAlgorithms, nil, false},
+		{SigningMethodEd25519, SupportedAlgorithms, nil, false},
+		{jwt.SigningMethodPS256, []string{"PS256"}, nil, false},
+		{jwt.SigningMethodRS256, []string{"PS256"}, nil, true},
 	} {
-		token := &jwt.Token{Method: test.method}
-		k, err := kf(token)
+		token := &jwt.Token{Method: test.method, Header: map[string]interface{}{"alg": test.method.Alg()}}
+		k, err := jwtValidator(kl, newAlgorithmPolicy(test.algs, nil))(token)
 
-		if test.wantError && err == nil {
-			t.Error("Unexpected error status. Wanted error but didn't")
+		if test.wantError && !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Errorf("Wanted an unsupported algorithm error for %s with %v, got %v", test.method.Alg(), test.algs, err)
+		}
+		if !test.wantError && errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Errorf("Unexpected error for %s with %v: %v", test.method.Alg(), test.algs, err)
 		}
 
 		if k != test.want {
@@ -61,3 +111,218 @@ func TestJwtValidator(t *testing.T) {
 		}
 	}
 }
+
+func TestHandlerRSAPSS(t *testing.T) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyMap["pss"] = &priv.PublicKey
+	defer delete(keyMap, "pss")
+	defer func(algs []string) { options.Current().JwtAllowedAlgorithms = algs }(options.Current().JwtAllowedAlgorithms)
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+
+	for _, test := range []struct {
+		method   jwt.SigningMethod
+		allowed  []string
+		wantCode int
+	}{
+		{jwt.SigningMethodPS256, nil, http.StatusOK},
+		{jwt.SigningMethodPS384, nil, http.StatusOK},
+		{jwt.SigningMethodPS512, nil, http.StatusOK},
+		{jwt.SigningMethodPS256, []string{"PS256"}, http.StatusOK},
+		{jwt.SigningMethodPS256, []string{"RS256"}, http.StatusUnauthorized},
+		{jwt.SigningMethodRS256, []string{"PS256"}, http.StatusUnauthorized},
+	} {
+		token := jwt.NewWithClaims(test.method, jwt.MapClaims{
+			"sub":   "foo",
+			"realm": "/services",
+			"scope": []string{"uid"},
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "pss"
+		signed, _ := token.SignedString(priv)
+
+		options.Current().JwtAllowedAlgorithms = test.allowed
+		h := New(new(mockKeyLoader), crp)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		h.ServeHTTP(w, req)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s with %v. Wanted %d, got %d: %s", test.method.Alg(), test.allowed, test.wantCode, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestValidateTimeClaims(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	for _, test := range []struct {
+		claims    jwt.MapClaims
+		leeway    time.Duration
+		wantError error
+	}{
+		{jwt.MapClaims{}, 0, nil},
+		{jwt.MapClaims{"exp": float64(1000001), "nbf": float64(1000000), "iat": float64(1000000)}, 0, nil},
+		{jwt.MapClaims{"exp": float64(999999)}, 0, ErrTokenExpired},
+		{jwt.MapClaims{"exp": float64(999990)}, 30 * time.Second, nil},
+		{jwt.MapClaims{"exp": float64(999960)}, 30 * time.Second, ErrTokenExpired},
+		{jwt.MapClaims{"nbf": float64(1000010)}, 0, ErrTokenNotValidYet},
+		{jwt.MapClaims{"nbf": float64(1000010)}, 30 * time.Second, nil},
+		{jwt.MapClaims{"iat": float64(1000010)}, 0, ErrTokenIssuedInFuture},
+		{jwt.MapClaims{"iat": float64(1000010)}, 30 * time.Second, nil},
+		{jwt.MapClaims{"iat": float64(1000040)}, 30 * time.Second, ErrTokenIssuedInFuture},
+	} {
+		token := &jwt.Token{Claims: test.claims}
+		if err := validateTimeClaims(token, now, test.leeway); err != test.wantError {
+			t.Errorf("Unexpected result for %v with leeway %v. Wanted %v, got %v", test.claims, test.leeway, test.wantError, err)
+		}
+	}
+}
+
+func TestHandlerClockSkew(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	keyMap["skew"] = pub
+	defer delete(keyMap, "skew")
+	defer func(d time.Duration) { options.Current().ClockSkew = d }(options.Current().ClockSkew)
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+
+	token := jwt.NewWithClaims(SigningMethodEd25519, jwt.MapClaims{
+		"sub":   "foo",
+		"realm": "/services",
+		"scope": []string{"uid"},
+		"exp":   time.Now().Add(-5 * time.Second).Unix(),
+	})
+	token.Header["kid"] = "skew"
+	signed, _ := token.SignedString(priv)
+
+	for _, test := range []struct {
+		leeway   time.Duration
+		wantCode int
+	}{
+		{0, http.StatusUnauthorized},
+		{30 * time.Second, http.StatusOK},
+	} {
+		options.Current().ClockSkew = test.leeway
+		h := New(new(mockKeyLoader), crp)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		h.ServeHTTP(w, req)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code with leeway %v. Wanted %d, got %d: %s", test.leeway, test.wantCode, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{request.ErrNoTokenInRequest, ReasonMissingToken},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorMalformed}, ReasonMalformed},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorSignatureInvalid}, ReasonBadSignature},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorUnverifiable}, ReasonUnsupportedAlg},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorUnverifiable, Inner: fmt.Errorf("%w: HS256", ErrUnsupportedAlgorithm)}, ReasonUnsupportedAlg},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorUnverifiable, Inner: ErrMissingKeyID}, ReasonMissingKeyID},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorUnverifiable, Inner: fmt.Errorf("%w: foo", keyloader.ErrKeyNotFound)}, ReasonUnknownKeyID},
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorUnverifiable, Inner: fmt.Errorf("%w: \"bar\"", keyloader.ErrUnknownIssuer)}, ReasonUnknownIssuer},
+		{ErrTokenExpired, ReasonExpired},
+		{ErrTokenNotValidYet, ReasonNotValidYet},
+		{ErrTokenIssuedInFuture, ReasonIssuedInFuture},
+		{ErrRevokedToken, ReasonRevoked},
+		{ErrDeniedToken, ReasonDenied},
+		{ErrInvalidClaimScope, ReasonInvalidClaims},
+		{fmt.Errorf("%w: the content is not a JWT", jwe.ErrMalformed), ReasonMalformed},
+		{fmt.Errorf("%w: RSA1_5", jwe.ErrUnsupported), ReasonUnsupportedAlg},
+		{fmt.Errorf("%w: foo", jwe.ErrUnknownKey), ReasonUndecryptable},
+		{jwe.ErrDecryption, ReasonUndecryptable},
+		{errors.New("foo"), ReasonInvalid},
+	} {
+		if r := errorReason(test.err); r != test.want {
+			t.Errorf("Wrong reason for %v. Wanted %q, got %q", test.err, test.want, r)
+		}
+	}
+}
+
+func TestHandlerErrorReason(t *testing.T) {
+	defer func(b bool) { options.Current().ErrorReasons = b }(options.Current().ErrorReasons)
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+
+	for _, test := range []struct {
+		reasons  bool
+		token    string
+		wantBody string
+	}{
+		{false, "foo", `{"error":"invalid_token","error_description":"Access Token not valid"}` + "\n"},
+		{true, "foo", `{"error":"invalid_token","error_description":"Access Token not valid","error_reason":"malformed"}` + "\n"},
+		{true, "", `{"error":"invalid_request","error_description":"Access Token not valid","error_reason":"missing_token"}` + "\n"},
+	} {
+		options.Current().ErrorReasons = test.reasons
+		h := New(new(mockKeyLoader), crp)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+test.token, nil)
+		h.ServeHTTP(w, req)
+		if w.Body.String() != test.wantBody {
+			t.Errorf("Wrong response body with reasons %t. Wanted %q, got %q", test.reasons, test.wantBody, w.Body.String())
+		}
+	}
+
+	c, ok := metrics.DefaultRegistry.Get("planb.tokeninfo.jwt.rejections.malformed").(metrics.Counter)
+	if !ok || c.Count() < 2 {
+		t.Error("Rejections should be counted by reason")
+	}
+}
+
+type failingDenyList struct{}
+
+func (failingDenyList) Contains(jti string) (bool, error) { return false, errors.New("unavailable") }
+
+func TestHandlerDenyList(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	keyMap["deny"] = pub
+	defer delete(keyMap, "deny")
+	u, _ := url.Parse("localhost")
+	crp := revoke.NewCachingRevokeProvider(u)
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["sub"], claims["realm"], claims["scope"] = "foo", "/services", []string{"uid"}
+		claims["iat"], claims["exp"] = time.Now().Unix(), time.Now().Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(SigningMethodEd25519, claims)
+		token.Header["kid"] = "deny"
+		signed, _ := token.SignedString(priv)
+		return signed
+	}
+	denied, allowed, noID := sign(jwt.MapClaims{"jti": "denied"}), sign(jwt.MapClaims{"jti": "allowed"}), sign(jwt.MapClaims{})
+
+	for _, test := range []struct {
+		denyList   denylist.List
+		failClosed bool
+		token      string
+		wantCode   int
+	}{
+		{nil, false, denied, http.StatusOK},
+		{denylist.NewList("denied"), false, denied, http.StatusUnauthorized},
+		{denylist.NewList("denied"), false, allowed, http.StatusOK},
+		{denylist.NewList("denied"), false, noID, http.StatusOK},
+		{failingDenyList{}, false, denied, http.StatusOK},
+		{failingDenyList{}, true, denied, http.StatusServiceUnavailable},
+		{failingDenyList{}, true, noID, http.StatusOK},
+	} {
+		h := NewWithDenyList(new(mockKeyLoader), crp, test.denyList)
+		h.(*jwtHandler).failClosed = test.failClosed
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		h.ServeHTTP(w, req)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code with %v. Wanted %d, got %d: %s", test.denyList, test.wantCode, w.Code, w.Body.String())
+		}
+	}
+
+	if c, ok := metrics.DefaultRegistry.Get("planb.tokeninfo.jwt.rejections.denied").(metrics.Counter); !ok || c.Count() < 1 {
+		t.Error("Denied tokens should be counted")
+	}
+}
@@ -0,0 +1,138 @@
+package jwthandler
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zalando/planb-tokeninfo/processor"
+)
+
+type fieldKind int
+
+const (
+	fieldString fieldKind = iota
+	fieldTrue
+	fieldScope
+	fieldExpiresIn
+)
+
+// responseField is an attribute of the Token Info response. Only string attributes keep their value here,
+// the scope list and expires_in are taken from the TokenInfo when the response is encoded
+type responseField struct {
+	key  string
+	kind fieldKind
+	str  string
+}
+
+// responseEncoder writes the same JSON as encoding/json does for the map returned by response, without
+// building the map. Encoders are pooled so that the fields, their index by key and the buffer are reused
+// across requests
+type responseEncoder struct {
+	fields []responseField
+	index  map[string]int
+	buf    []byte
+}
+
+var encoderPool = sync.Pool{New: func() interface{} { return &responseEncoder{index: make(map[string]int)} }}
+
+func getEncoder() *responseEncoder {
+	return encoderPool.Get().(*responseEncoder)
+}
+
+func putEncoder(e *responseEncoder) {
+	// big responses are not kept around
+	if cap(e.buf) <= 64*1024 {
+		encoderPool.Put(e)
+	}
+}
+
+// encode returns the JSON Token Info response for ti, followed by a newline like json.Encoder does. The
+// result is only valid until the encoder is used again
+func (e *responseEncoder) encode(ti *processor.TokenInfo) []byte {
+	e.fields = e.fields[:0]
+	clear(e.index)
+	e.set(responseField{key: "access_token", str: ti.AccessToken}, true)
+	if ti.RefreshToken != "" {
+		e.set(responseField{key: "refresh_token", str: ti.RefreshToken}, true)
+	}
+	e.set(responseField{key: "uid", str: ti.UID}, true)
+	e.set(responseField{key: "grant_type", str: ti.GrantType}, true)
+	e.set(responseField{key: "scope", kind: fieldScope}, true)
+	e.set(responseField{key: "realm", str: ti.Realm}, true)
+	e.set(responseField{key: "token_type", str: ti.TokenType}, true)
+	e.set(responseField{key: "expires_in", kind: fieldExpiresIn}, true)
+	// compatibility: "truthy" attributes for all scopes, see response
+	for _, scope := range ti.Scope {
+		e.set(responseField{key: scope, kind: fieldTrue}, false)
+	}
+	if ti.ClientId != "" {
+		e.set(responseField{key: "client_id", str: ti.ClientId}, true)
+	}
+	for k, v := range ti.PrivateClaims {
+		e.set(responseField{key: k, str: v}, true)
+	}
+	// encoding/json sorts the keys of maps. The index is not used anymore
+	slices.SortFunc(e.fields, func(a, b responseField) int { return strings.Compare(a.key, b.key) })
+
+	b := append(e.buf[:0], '{')
+	for i, f := range e.fields {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendString(b, f.key)
+		b = append(b, ':')
+		switch f.kind {
+		case fieldString:
+			b = appendString(b, f.str)
+		case fieldTrue:
+			b = append(b, "true"...)
+		case fieldExpiresIn:
+			b = strconv.AppendInt(b, int64(ti.ExpiresIn), 10)
+		case fieldScope:
+			if ti.Scope == nil {
+				b = append(b, "null"...)
+				break
+			}
+			b = append(b, '[')
+			for j, s := range ti.Scope {
+				if j > 0 {
+					b = append(b, ',')
+				}
+				b = appendString(b, s)
+			}
+			b = append(b, ']')
+		}
+	}
+	b = append(b, '}', '\n')
+	e.buf = b
+	return b
+}
+
+// set adds the field f. An existing field with the same key is replaced only if replace is true
+func (e *responseEncoder) set(f responseField, replace bool) {
+	if i, has := e.index[f.key]; has {
+		if replace {
+			e.fields[i] = f
+		}
+		return
+	}
+	e.index[f.key] = len(e.fields)
+	e.fields = append(e.fields, f)
+}
+
+// appendString appends s as a JSON string. Strings with characters that encoding/json escapes are encoded
+// by it, so that the output doesn't depend on how the escaping is done
+func appendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x80 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			q, _ := json.Marshal(s)
+			return append(b, q...)
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"')
+}
@@ -1,10 +1,12 @@
 package jwthandler
 
 import (
-	"encoding/json"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -19,8 +21,21 @@ const (
 	JwtClaimAzp    = "azp"
 	JwtClaimExp    = "exp"
 	JwtClaimIssuer = "iss"
+	JwtClaimID     = "jti"
+	JwtClaimTenant = "tid"
+	JwtClaimAud    = "aud"
+	JwtClaimEmail  = "email"
 )
 
+// spiffeScheme is the scheme of SPIFFE IDs, spiffe://trust-domain/path
+const spiffeScheme = "spiffe://"
+
+// GoogleIssuer is the issuer of Google ID tokens, which is also sent without the scheme
+const GoogleIssuer = "https://accounts.google.com"
+
+// tenantTemplate is the placeholder for the tenant in the issuer of multi-tenant Azure AD applications
+const tenantTemplate = "{tenantid}"
+
 var (
 	// ErrInvalidClaimScope should be used whenever the scope claim is invalid or missing in the JWT
 	ErrInvalidClaimScope = errors.New("Invalid claim: scope")
@@ -32,9 +47,22 @@ var (
 	ErrInvalidClaimAzp = errors.New("Invalid claim: azp")
 	// ErrInvalidClaimExp should be used whenever the claim exp is invalid or missing in the JWT
 	ErrInvalidClaimExp = errors.New("Invalid claim: exp")
+	// ErrInvalidClaimAud should be used whenever the claim aud doesn't have an accepted audience
+	ErrInvalidClaimAud = errors.New("Invalid claim: aud")
+	// ErrInvalidClaimEmail should be used whenever the claim email is missing or not verified in the JWT
+	ErrInvalidClaimEmail = errors.New("Invalid claim: email")
 )
 
 func Marshal(ti *processor.TokenInfo, w io.Writer) error {
+	e := getEncoder()
+	defer putEncoder(e)
+	_, err := w.Write(e.encode(ti))
+	return err
+}
+
+// response returns the fields of the Token Info response for ti. It's only used when the response is
+// changed by a claim mapping, responseEncoder writes the same response without the map otherwise
+func response(ti *processor.TokenInfo) map[string]interface{} {
 	m := make(map[string]interface{})
 	m["access_token"] = ti.AccessToken
 	if ti.RefreshToken != "" {
@@ -66,23 +94,292 @@ func Marshal(ti *processor.TokenInfo, w io.Writer) error {
 		m[k] = v
 	}
 
-	return json.NewEncoder(w).Encode(m)
+	return m
+}
+
+type issuerProcessor struct {
+	realm      string
+	scopeClaim string
+}
+
+// NewIssuerProcessor returns a processor.JwtProcessor for tokens of an issuer that doesn't follow the
+// Plan B claims. A non empty realm is used for all tokens instead of the realm claim and a non empty
+// scopeClaim is the name of the claim that holds the scopes (for ex., "scp")
+func NewIssuerProcessor(realm string, scopeClaim string) processor.JwtProcessor {
+	return &issuerProcessor{realm: realm, scopeClaim: scopeClaim}
+}
+
+func (p *issuerProcessor) Process(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
+	scopeClaim := p.scopeClaim
+	if scopeClaim == "" {
+		scopeClaim = JwtClaimScope
+	}
+	return newTokenInfo(t, timeBase, scopeClaim, p.realm)
 }
 
 func defaultNewTokenInfo(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
-	scopes, ok := ClaimAsStrings(t, JwtClaimScope)
+	return newTokenInfo(t, timeBase, JwtClaimScope, "")
+}
+
+type keycloakProcessor struct {
+	realm string
+}
+
+// NewKeycloakProcessor returns a processor.JwtProcessor for Keycloak tokens. Their scopes are the space
+// separated scopes of the scope claim, the realm roles of realm_access.roles and, for every client of
+// resource_access, its roles as "client:role". A non empty realm is used for all tokens instead of the
+// realm claim
+func NewKeycloakProcessor(realm string) processor.JwtProcessor {
+	return &keycloakProcessor{realm: realm}
+}
+
+func (p *keycloakProcessor) Process(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
+	claims, ok := t.Claims.(jwt.MapClaims)
 	if !ok {
 		return nil, ErrInvalidClaimScope
 	}
+	scopes, ok := keycloakScopes(claims)
+	if !ok {
+		return nil, ErrInvalidClaimScope
+	}
+	return tokenInfo(t, timeBase, scopes, p.realm)
+}
 
-	sub, ok := ClaimAsString(t, JwtClaimSub)
+// keycloakScopes returns the scopes and roles of Keycloak claims. It fails for claims of an unexpected type
+func keycloakScopes(claims jwt.MapClaims) ([]string, bool) {
+	scopes := []string{}
+	if c, has := claims[JwtClaimScope]; has {
+		s, ok := c.(string)
+		if !ok {
+			return nil, false
+		}
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	roles := func(c interface{}, prefix string) bool {
+		access, ok := c.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		list, ok := access["roles"].([]interface{})
+		if !ok {
+			return access["roles"] == nil
+		}
+		for _, r := range list {
+			role, ok := r.(string)
+			if !ok {
+				return false
+			}
+			scopes = append(scopes, prefix+role)
+		}
+		return true
+	}
+	if c, has := claims["realm_access"]; has && !roles(c, "") {
+		return nil, false
+	}
+	if c, has := claims["resource_access"]; has {
+		clients, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		// in a stable order, the clients are a map
+		names := make([]string, 0, len(clients))
+		for client := range clients {
+			names = append(names, client)
+		}
+		sort.Strings(names)
+		for _, client := range names {
+			if !roles(clients[client], client+":") {
+				return nil, false
+			}
+		}
+	}
+	return scopes, true
+}
+
+type azureADProcessor struct {
+	realm     string
+	audiences []string
+}
+
+// NewAzureADProcessor returns a processor.JwtProcessor for Azure AD (Entra ID) v2 tokens. Their scopes are
+// the space separated delegated scopes of the scp claim and the application roles of the roles claim, at
+// least one of them is required. A non empty realm is used for all tokens instead of the realm claim. With
+// audiences, only tokens for one of them are accepted
+func NewAzureADProcessor(realm string, audiences []string) processor.JwtProcessor {
+	return &azureADProcessor{realm: realm, audiences: audiences}
+}
+
+func (p *azureADProcessor) Process(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
+	claims, ok := t.Claims.(jwt.MapClaims)
 	if !ok {
+		return nil, ErrInvalidClaimScope
+	}
+	if len(p.audiences) > 0 && !hasAudience(claims[JwtClaimAud], p.audiences) {
+		return nil, ErrInvalidClaimAud
+	}
+	_, hasScp := claims["scp"]
+	_, hasRoles := claims["roles"]
+	if !hasScp && !hasRoles {
+		return nil, ErrInvalidClaimScope
+	}
+	scopes := []string{}
+	if hasScp {
+		scp, ok := ClaimAsString(t, "scp")
+		if !ok {
+			return nil, ErrInvalidClaimScope
+		}
+		scopes = append(scopes, strings.Fields(scp)...)
+	}
+	if hasRoles {
+		roles, ok := ClaimAsStrings(t, "roles")
+		if !ok {
+			return nil, ErrInvalidClaimScope
+		}
+		scopes = append(scopes, roles...)
+	}
+	return tokenInfo(t, timeBase, scopes, p.realm)
+}
+
+type googleProcessor struct {
+	audiences []string
+	realm     string
+}
+
+// NewGoogleProcessor returns a processor.JwtProcessor for Google ID tokens, for ex. of service accounts or
+// workload identities. Only tokens for one of the audiences with a verified email are accepted. The email is
+// the uid of the tokens, they have no scopes and they are all in the given realm
+func NewGoogleProcessor(audiences []string, realm string) processor.JwtProcessor {
+	return &googleProcessor{audiences: audiences, realm: realm}
+}
+
+func (p *googleProcessor) Process(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok || !hasAudience(claims[JwtClaimAud], p.audiences) {
+		return nil, ErrInvalidClaimAud
+	}
+	email, ok := ClaimAsString(t, JwtClaimEmail)
+	if verified, _ := claims["email_verified"].(bool); !ok || email == "" || !verified {
+		return nil, ErrInvalidClaimEmail
+	}
+	ti, err := tokenInfo(t, timeBase, []string{}, p.realm)
+	if err != nil {
+		return nil, err
+	}
+	ti.UID = email
+	return ti, nil
+}
+
+// hasAudience checks if the aud claim, a string or an array of strings, has one of the audiences
+func hasAudience(aud interface{}, audiences []string) bool {
+	switch a := aud.(type) {
+	case string:
+		return slices.Contains(audiences, a)
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && slices.Contains(audiences, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type spiffeProcessor struct {
+	trustDomain string
+	audiences   []string
+	realm       string
+}
+
+// NewSPIFFEProcessor returns a processor.JwtProcessor for the JWT-SVIDs of a SPIFFE trust domain. Only tokens
+// for one of the audiences are accepted. The SPIFFE ID of the sub claim is the uid of the tokens, they have
+// no scopes and they are all in the given realm
+func NewSPIFFEProcessor(trustDomain string, audiences []string, realm string) processor.JwtProcessor {
+	return &spiffeProcessor{trustDomain: trustDomain, audiences: audiences, realm: realm}
+}
+
+func (p *spiffeProcessor) Process(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok || !hasAudience(claims[JwtClaimAud], p.audiences) {
+		return nil, ErrInvalidClaimAud
+	}
+	sub, _ := ClaimAsString(t, JwtClaimSub)
+	if td, path := spiffeID(sub); td != p.trustDomain || path == "" {
 		return nil, ErrInvalidClaimSub
 	}
+	return tokenInfo(t, timeBase, []string{}, p.realm)
+}
+
+// spiffeID returns the trust domain and the path of a SPIFFE ID, or empty strings for other values
+func spiffeID(id string) (string, string) {
+	rest, ok := strings.CutPrefix(id, spiffeScheme)
+	if !ok {
+		return "", ""
+	}
+	td, path, _ := strings.Cut(rest, "/")
+	return td, path
+}
+
+// tokenIssuer returns the issuer of t as it is configured: the issuer of options.AzureADIssuers with the
+// {tenantid} placeholder that the iss claim matches with the tenant of the tid claim, if it's one of
+// options.AzureADTenants, or the iss claim. With options.GoogleIDTokenAudiences, the Google issuer
+// without the scheme is the Google issuer. The JWT-SVIDs of the trust domains of options.SPIFFEBundles, whose
+// sub claim is a SPIFFE ID of the trust domain, have the trust domain as issuer, like spiffe://example.org,
+// whatever their iss claim is, so that they are always validated with the keys of the trust bundle
+func tokenIssuer(t *jwt.Token) (string, bool) {
+	settings := options.Current()
+	if len(settings.SPIFFEBundles) > 0 {
+		sub, _ := ClaimAsString(t, JwtClaimSub)
+		if td, _ := spiffeID(sub); td != "" {
+			if _, has := settings.SPIFFEBundles[td]; has {
+				return spiffeScheme + td, true
+			}
+		}
+	}
+	iss, ok := ClaimAsString(t, JwtClaimIssuer)
+	if !ok {
+		return "", false
+	}
+	if iss == strings.TrimPrefix(GoogleIssuer, "https://") && len(settings.GoogleIDTokenAudiences) > 0 {
+		return GoogleIssuer, true
+	}
+	for _, template := range settings.AzureADIssuers {
+		if !strings.Contains(template, tenantTemplate) {
+			continue
+		}
+		tid, ok := ClaimAsString(t, JwtClaimTenant)
+		if !ok || tid == "" || strings.Contains(tid, "/") {
+			continue
+		}
+		if !slices.Contains(settings.AzureADTenants, tid) {
+			continue
+		}
+		if strings.ReplaceAll(template, tenantTemplate, tid) == iss {
+			return template, true
+		}
+	}
+	return iss, true
+}
 
-	realm, ok := ClaimAsString(t, JwtClaimRealm)
+func newTokenInfo(t *jwt.Token, timeBase time.Time, scopeClaim string, realm string) (*processor.TokenInfo, error) {
+	scopes, ok := ClaimAsStrings(t, scopeClaim)
 	if !ok {
-		return nil, ErrInvalidClaimRealm
+		return nil, ErrInvalidClaimScope
+	}
+	return tokenInfo(t, timeBase, scopes, realm)
+}
+
+// tokenInfo returns the TokenInfo of t with the given scopes. The realm claim is only read when realm is empty
+func tokenInfo(t *jwt.Token, timeBase time.Time, scopes []string, realm string) (*processor.TokenInfo, error) {
+	sub, ok := ClaimAsString(t, JwtClaimSub)
+	if !ok {
+		return nil, ErrInvalidClaimSub
+	}
+
+	if realm == "" {
+		realm, ok = tokenRealm(t)
+		if !ok {
+			return nil, ErrInvalidClaimRealm
+		}
 	}
 
 	clientId := ""
@@ -101,7 +398,11 @@ func defaultNewTokenInfo(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo
 		return nil, ErrInvalidClaimExp
 	}
 
+	// tokens accepted thanks to the clock skew leeway may have expired already
 	expiresIn := int(time.Unix(exp, 0).Sub(timeBase).Seconds())
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
 
 	return &processor.TokenInfo{
 		AccessToken: t.Raw,
@@ -115,10 +416,23 @@ func defaultNewTokenInfo(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo
 	}, nil
 }
 
+// tokenRealm returns the realm of t from options.RealmRules or, when there are no rules, from the realm claim
+func tokenRealm(t *jwt.Token) (string, bool) {
+	rules := options.Current().RealmRules
+	if rules == nil {
+		return ClaimAsString(t, JwtClaimRealm)
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", false
+	}
+	return rules.Realm(claims)
+}
+
 func NewTokenInfo(t *jwt.Token, timeBase time.Time) (*processor.TokenInfo, error) {
-	issuer, ok := ClaimAsString(t, JwtClaimIssuer)
+	issuer, ok := tokenIssuer(t)
 	if ok {
-		jwtprocessor, found := options.AppSettings.JwtProcessors[issuer]
+		jwtprocessor, found := options.Current().JwtProcessors[issuer]
 		if found {
 			return jwtprocessor.Process(t, timeBase)
 		}
@@ -130,7 +444,7 @@ func ClaimAsStrings(t *jwt.Token, claim string) ([]string, bool) {
 	if c, ok := getClaim(t, claim); ok {
 		value, ok := c.([]interface{})
 		if !ok {
-			log.Printf("Invalid string array value for claim %q = %v", claim, c)
+			slog.Debug("Invalid string array value for claim", "claim", claim, "value", c)
 			return nil, false
 		}
 		result := make([]string, len(value))
@@ -146,7 +460,7 @@ func ClaimAsString(t *jwt.Token, claim string) (string, bool) {
 	if c, ok := getClaim(t, claim); ok {
 		value, ok := c.(string)
 		if !ok {
-			log.Printf("Invalid string value for claim %q = %v", claim, c)
+			slog.Debug("Invalid string value for claim", "claim", claim, "value", c)
 			return "", false
 		}
 		return value, true
@@ -163,7 +477,7 @@ func ClaimAsInt64(t *jwt.Token, claim string) (int64, bool) {
 	case float64:
 		return int64(c.(float64)), true
 	default:
-		log.Printf("Invalid number format for claim %q = %v", claim, c)
+		slog.Debug("Invalid number format for claim", "claim", claim, "value", c)
 	}
 	return 0, false
 }
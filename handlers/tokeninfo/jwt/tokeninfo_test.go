@@ -2,7 +2,12 @@ package jwthandler
 
 import (
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -100,7 +105,9 @@ func (jwtProcessor TestJWTProcessor) Process(t *jwt.Token, timeBase time.Time) (
 }
 
 func TestJwtProcessor(t *testing.T) {
-	options.AppSettings.JwtProcessors["test-processor"] = TestJWTProcessor{}
+	s := *options.Current()
+	s.JwtProcessors = map[string]processor.JwtProcessor{"test-processor": TestJWTProcessor{}}
+	defer options.Replace(options.Replace(&s))
 	for _, test := range []struct {
 		token     jwt.Token
 		want      *processor.TokenInfo
@@ -130,6 +137,253 @@ func TestJwtProcessor(t *testing.T) {
 	}
 }
 
+func TestIssuerProcessor(t *testing.T) {
+	for _, test := range []struct {
+		processor processor.JwtProcessor
+		claims    jwt.MapClaims
+		want      *processor.TokenInfo
+		wantError bool
+	}{
+		{
+			NewIssuerProcessor("/partners", "scp"),
+			jwt.MapClaims{"scp": []interface{}{"read"}, "sub": "foo", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"read"}, UID: "foo", Realm: "/partners", ExpiresIn: 1},
+			false,
+		},
+		{
+			NewIssuerProcessor("/partners", ""),
+			jwt.MapClaims{"scope": []interface{}{"read"}, "sub": "foo", "realm": "/ignored", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"read"}, UID: "foo", Realm: "/partners", ExpiresIn: 1},
+			false,
+		},
+		{
+			NewIssuerProcessor("", "scp"),
+			jwt.MapClaims{"scp": []interface{}{"read"}, "sub": "foo", "realm": "/test", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"read"}, UID: "foo", Realm: "/test", ExpiresIn: 1},
+			false,
+		},
+		{
+			NewIssuerProcessor("", "scp"),
+			jwt.MapClaims{"scp": []interface{}{"read"}, "sub": "foo", "exp": float64(43)},
+			nil,
+			true,
+		},
+		{
+			NewIssuerProcessor("/partners", "scp"),
+			jwt.MapClaims{"scope": []interface{}{"read"}, "sub": "foo", "exp": float64(43)},
+			nil,
+			true,
+		},
+	} {
+		ti, err := test.processor.Process(&jwt.Token{Claims: test.claims}, time.Unix(42, 0))
+
+		if test.wantError != (err != nil) {
+			t.Errorf("Unexpected error status: %v", err)
+		}
+
+		if !reflect.DeepEqual(ti, test.want) {
+			t.Errorf("Unexpected token info. Wanted %v, got %v", test.want, ti)
+		}
+	}
+}
+
+func TestKeycloakProcessor(t *testing.T) {
+	access := func(roles ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"roles": roles}
+	}
+	for _, test := range []struct {
+		realm  string
+		claims jwt.MapClaims
+		want   *processor.TokenInfo
+	}{
+		{
+			"/employees",
+			jwt.MapClaims{
+				"scope":           "openid email",
+				"realm_access":    access("admin", "user"),
+				"resource_access": map[string]interface{}{"shop": access("buyer"), "account": access("manage-account")},
+				"sub":             "foo",
+				"azp":             "shop",
+				"exp":             float64(43),
+			},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"openid", "email", "admin", "user", "account:manage-account", "shop:buyer"}, UID: "foo", Realm: "/employees", ClientId: "shop", ExpiresIn: 1},
+		},
+		{
+			"",
+			jwt.MapClaims{"realm_access": map[string]interface{}{}, "resource_access": map[string]interface{}{}, "sub": "foo", "realm": "/test", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{}, UID: "foo", Realm: "/test", ExpiresIn: 1},
+		},
+		{"/employees", jwt.MapClaims{"scope": []interface{}{"openid"}, "sub": "foo", "exp": float64(43)}, nil},
+		{"/employees", jwt.MapClaims{"realm_access": access("admin", 42), "sub": "foo", "exp": float64(43)}, nil},
+		{"/employees", jwt.MapClaims{"resource_access": access("admin"), "sub": "foo", "exp": float64(43)}, nil},
+		{"", jwt.MapClaims{"scope": "openid", "sub": "foo", "exp": float64(43)}, nil},
+	} {
+		ti, err := NewKeycloakProcessor(test.realm).Process(&jwt.Token{Claims: test.claims}, time.Unix(42, 0))
+		if (test.want == nil) != (err != nil) {
+			t.Errorf("Unexpected error status for %v: %v", test.claims, err)
+		}
+		if !reflect.DeepEqual(ti, test.want) {
+			t.Errorf("Unexpected token info for %v. Wanted %v, got %v", test.claims, test.want, ti)
+		}
+	}
+}
+
+func TestAzureADProcessor(t *testing.T) {
+	for _, test := range []struct {
+		claims jwt.MapClaims
+		want   *processor.TokenInfo
+	}{
+		{
+			jwt.MapClaims{"scp": "User.Read Files.Read", "sub": "foo", "azp": "app", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"User.Read", "Files.Read"}, UID: "foo", Realm: "/azure", ClientId: "app", ExpiresIn: 1},
+		},
+		{
+			jwt.MapClaims{"roles": []interface{}{"Orders.Write"}, "sub": "foo", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"Orders.Write"}, UID: "foo", Realm: "/azure", ExpiresIn: 1},
+		},
+		{
+			jwt.MapClaims{"scp": "User.Read", "roles": []interface{}{"Admin"}, "sub": "foo", "exp": float64(43)},
+			&processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{"User.Read", "Admin"}, UID: "foo", Realm: "/azure", ExpiresIn: 1},
+		},
+		{jwt.MapClaims{"scope": []interface{}{"uid"}, "sub": "foo", "exp": float64(43)}, nil},
+		{jwt.MapClaims{"scp": []interface{}{"User.Read"}, "sub": "foo", "exp": float64(43)}, nil},
+		{jwt.MapClaims{"roles": "Admin", "sub": "foo", "exp": float64(43)}, nil},
+	} {
+		ti, err := NewAzureADProcessor("/azure", nil).Process(&jwt.Token{Claims: test.claims}, time.Unix(42, 0))
+		if (test.want == nil) != (err != nil) {
+			t.Errorf("Unexpected error status for %v: %v", test.claims, err)
+		}
+		if !reflect.DeepEqual(ti, test.want) {
+			t.Errorf("Unexpected token info for %v. Wanted %v, got %v", test.claims, test.want, ti)
+		}
+	}
+}
+
+func TestAzureADAudiences(t *testing.T) {
+	p := NewAzureADProcessor("/azure", []string{"api://tokeninfo"})
+	for aud, wantErr := range map[interface{}]error{"api://tokeninfo": nil, "api://other": ErrInvalidClaimAud, nil: ErrInvalidClaimAud} {
+		claims := jwt.MapClaims{"aud": aud, "scp": "User.Read", "sub": "foo", "exp": float64(43)}
+		if _, err := p.Process(&jwt.Token{Claims: claims}, time.Unix(42, 0)); err != wantErr {
+			t.Errorf("Wrong error for the audience %v. Wanted %v, got %v", aud, wantErr, err)
+		}
+	}
+}
+
+func TestGoogleProcessor(t *testing.T) {
+	p := NewGoogleProcessor([]string{"https://tokeninfo.example.org", "other"}, "/services")
+	valid := func(aud interface{}, email interface{}, verified interface{}) jwt.MapClaims {
+		return jwt.MapClaims{"aud": aud, "email": email, "email_verified": verified, "sub": "1234567890", "azp": "1234567890", "exp": float64(43)}
+	}
+	want := &processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{}, UID: "sa@project.iam.gserviceaccount.com", Realm: "/services", ClientId: "1234567890", ExpiresIn: 1}
+	for _, test := range []struct {
+		claims  jwt.MapClaims
+		wantErr error
+	}{
+		{valid("https://tokeninfo.example.org", "sa@project.iam.gserviceaccount.com", true), nil},
+		{valid([]interface{}{"foo", "other"}, "sa@project.iam.gserviceaccount.com", true), nil},
+		{valid("foo", "sa@project.iam.gserviceaccount.com", true), ErrInvalidClaimAud},
+		{valid([]interface{}{"foo", 42}, "sa@project.iam.gserviceaccount.com", true), ErrInvalidClaimAud},
+		{valid(nil, "sa@project.iam.gserviceaccount.com", true), ErrInvalidClaimAud},
+		{valid("other", "sa@project.iam.gserviceaccount.com", false), ErrInvalidClaimEmail},
+		{valid("other", "sa@project.iam.gserviceaccount.com", "true"), ErrInvalidClaimEmail},
+		{valid("other", nil, true), ErrInvalidClaimEmail},
+	} {
+		ti, err := p.Process(&jwt.Token{Claims: test.claims}, time.Unix(42, 0))
+		if err != test.wantErr {
+			t.Errorf("Wrong error for %v. Wanted %v, got %v", test.claims, test.wantErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(ti, want) {
+			t.Errorf("Unexpected token info for %v. Wanted %v, got %v", test.claims, want, ti)
+		}
+	}
+}
+
+func TestSPIFFEProcessor(t *testing.T) {
+	p := NewSPIFFEProcessor("example.org", []string{"tokeninfo"}, "/mesh")
+	for _, test := range []struct {
+		claims  jwt.MapClaims
+		wantErr error
+	}{
+		{jwt.MapClaims{"sub": "spiffe://example.org/ns/shop/sa/orders", "aud": []interface{}{"tokeninfo"}, "exp": float64(43)}, nil},
+		{jwt.MapClaims{"sub": "spiffe://example.org/ns/shop/sa/orders", "aud": "other", "exp": float64(43)}, ErrInvalidClaimAud},
+		{jwt.MapClaims{"sub": "spiffe://other.org/ns/shop/sa/orders", "aud": "tokeninfo", "exp": float64(43)}, ErrInvalidClaimSub},
+		{jwt.MapClaims{"sub": "spiffe://example.org", "aud": "tokeninfo", "exp": float64(43)}, ErrInvalidClaimSub},
+		{jwt.MapClaims{"sub": "orders", "aud": "tokeninfo", "exp": float64(43)}, ErrInvalidClaimSub},
+		{jwt.MapClaims{"sub": "spiffe://example.org/ns/shop/sa/orders", "aud": "tokeninfo"}, ErrInvalidClaimExp},
+	} {
+		ti, err := p.Process(&jwt.Token{Claims: test.claims}, time.Unix(42, 0))
+		if err != test.wantErr {
+			t.Errorf("Wrong error for %v. Wanted %v, got %v", test.claims, test.wantErr, err)
+		}
+		want := &processor.TokenInfo{GrantType: "password", TokenType: "Bearer", Scope: []string{}, UID: "spiffe://example.org/ns/shop/sa/orders", Realm: "/mesh", ExpiresIn: 1}
+		if err == nil && !reflect.DeepEqual(ti, want) {
+			t.Errorf("Unexpected token info for %v. Wanted %v, got %v", test.claims, want, ti)
+		}
+	}
+}
+
+func TestTokenIssuer(t *testing.T) {
+	defer func(issuers, tenants []string) {
+		options.Current().AzureADIssuers, options.Current().AzureADTenants = issuers, tenants
+	}(options.Current().AzureADIssuers, options.Current().AzureADTenants)
+	const template = "https://login.example.org/{tenantid}/v2.0"
+	options.Current().AzureADIssuers = []string{"https://login.example.org/fixed/v2.0", template}
+
+	for _, test := range []struct {
+		tenants []string
+		claims  jwt.MapClaims
+		want    string
+	}{
+		{[]string{"t1", "fixed"}, jwt.MapClaims{"iss": "https://login.example.org/t1/v2.0", "tid": "t1"}, template},
+		{[]string{"t1", "t2"}, jwt.MapClaims{"iss": "https://login.example.org/t1/v2.0", "tid": "t2"}, "https://login.example.org/t1/v2.0"},
+		{[]string{"t1"}, jwt.MapClaims{"iss": "https://login.example.org/t1/v2.0"}, "https://login.example.org/t1/v2.0"},
+		{[]string{"fixed"}, jwt.MapClaims{"iss": "https://login.example.org/fixed/v2.0", "tid": "fixed"}, template},
+		{[]string{"t1"}, jwt.MapClaims{"iss": "https://idp.example.org", "tid": "t1"}, "https://idp.example.org"},
+		{[]string{"a/b"}, jwt.MapClaims{"iss": "https://login.example.org/a/b/v2.0", "tid": "a/b"}, "https://login.example.org/a/b/v2.0"},
+		{[]string{"t1"}, jwt.MapClaims{"iss": "https://login.example.org/t2/v2.0", "tid": "t2"}, "https://login.example.org/t2/v2.0"},
+		{nil, jwt.MapClaims{"iss": "https://login.example.org/t1/v2.0", "tid": "t1"}, "https://login.example.org/t1/v2.0"},
+	} {
+		options.Current().AzureADTenants = test.tenants
+		if iss, _ := tokenIssuer(&jwt.Token{Claims: test.claims}); iss != test.want {
+			t.Errorf("Wrong issuer for %v with tenants %v. Wanted %q, got %q", test.claims, test.tenants, test.want, iss)
+		}
+	}
+
+	defer func(bundles map[string]*url.URL) { options.Current().SPIFFEBundles = bundles }(options.Current().SPIFFEBundles)
+	svid := &jwt.Token{Claims: jwt.MapClaims{"sub": "spiffe://example.org/orders", "iss": "https://idp.example.org"}}
+	if iss, _ := tokenIssuer(svid); iss != "https://idp.example.org" {
+		t.Errorf("Tokens should keep their issuer without SPIFFE bundles, got %q", iss)
+	}
+	options.Current().SPIFFEBundles = map[string]*url.URL{"example.org": {Scheme: "https", Host: "spire.example.org"}}
+	for _, test := range []struct {
+		claims jwt.MapClaims
+		want   string
+	}{
+		{jwt.MapClaims{"sub": "spiffe://example.org/orders", "iss": "https://idp.example.org"}, "spiffe://example.org"},
+		{jwt.MapClaims{"sub": "spiffe://example.org/orders"}, "spiffe://example.org"},
+		{jwt.MapClaims{"sub": "spiffe://other.org/orders", "iss": "https://idp.example.org"}, "https://idp.example.org"},
+		{jwt.MapClaims{"sub": "https://example.org/orders", "iss": "https://idp.example.org"}, "https://idp.example.org"},
+	} {
+		if iss, _ := tokenIssuer(&jwt.Token{Claims: test.claims}); iss != test.want {
+			t.Errorf("Wrong issuer for %v. Wanted %q, got %q", test.claims, test.want, iss)
+		}
+	}
+
+	defer func(audiences []string) { options.Current().GoogleIDTokenAudiences = audiences }(options.Current().GoogleIDTokenAudiences)
+	google := &jwt.Token{Claims: jwt.MapClaims{"iss": "accounts.google.com"}}
+	if iss, _ := tokenIssuer(google); iss != "accounts.google.com" {
+		t.Errorf("The Google issuer without scheme should be unchanged without Google audiences, got %q", iss)
+	}
+	options.Current().GoogleIDTokenAudiences = []string{"https://tokeninfo.example.org"}
+	if iss, _ := tokenIssuer(google); iss != GoogleIssuer {
+		t.Errorf("Wrong issuer for the Google issuer without scheme. Wanted %q, got %q", GoogleIssuer, iss)
+	}
+
+	if _, ok := tokenIssuer(&jwt.Token{Claims: jwt.MapClaims{"tid": "t1"}}); ok {
+		t.Error("Tokens without an iss claim should have no issuer")
+	}
+}
+
 func TestMarshal(t *testing.T) {
 
 	for _, test := range []struct {
@@ -162,3 +416,184 @@ func TestMarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestClaimMapping(t *testing.T) {
+	cm, err := processor.LoadClaimMapping(strings.NewReader(`{
+		"claims": {"tenant": "tenant_id", "missing": "nope"},
+		"rename": {"uid": "user_id"},
+		"drop": ["grant_type", "access_token"],
+		"constants": {"source": "planb"}
+	}`))
+	if err != nil {
+		t.Fatal("Failed to load claim mapping: ", err)
+	}
+
+	claims := jwt.MapClaims{"sub": "foo", "tenant_id": "acme"}
+	for _, test := range []struct {
+		mapping *processor.ClaimMapping
+		want    string
+	}{
+		{nil, "{\"access_token\":\"\",\"expires_in\":0,\"grant_type\":\"\",\"realm\":\"/test\",\"scope\":null,\"token_type\":\"\",\"uid\":\"foo\"}\n"},
+		{cm, "{\"expires_in\":0,\"realm\":\"/test\",\"scope\":null,\"source\":\"planb\",\"tenant\":\"acme\",\"token_type\":\"\",\"user_id\":\"foo\"}\n"},
+	} {
+		m := response(&processor.TokenInfo{UID: "foo", Realm: "/test"})
+		test.mapping.Apply(claims, m)
+		buf := new(bytes.Buffer)
+		json.NewEncoder(buf).Encode(m)
+		if s := buf.String(); s != test.want {
+			t.Errorf("Unexpected mapped response. Wanted %v, got %v", test.want, s)
+		}
+	}
+
+	cm, err = processor.LoadClaimMapping(strings.NewReader(`{
+		"claims": {
+			"roles": "https://example.com/roles",
+			"groups": "realm_access.roles",
+			"tenant": "https://example.com/app_metadata.tenant",
+			"client": "resource_access.my.app.id",
+			"missing": "realm_access.nope",
+			"not_object": "https://example.com/roles.admin"
+		},
+		"flatten": {"https://example.com/app_metadata": "app_", "https://example.com/roles": "role_"},
+		"drop": ["access_token", "expires_in", "grant_type", "realm", "scope", "token_type", "uid"]
+	}`))
+	if err != nil {
+		t.Fatal("Failed to load claim mapping: ", err)
+	}
+	claims = jwt.MapClaims{
+		"https://example.com/roles":        []interface{}{"admin"},
+		"https://example.com/app_metadata": map[string]interface{}{"tenant": "acme", "plan": "free"},
+		"realm_access":                     map[string]interface{}{"roles": []interface{}{"users"}},
+		"resource_access":                  map[string]interface{}{"my.app": map[string]interface{}{"id": "app"}},
+	}
+	m := response(&processor.TokenInfo{UID: "foo", Realm: "/test"})
+	cm.Apply(claims, m)
+	buf := new(bytes.Buffer)
+	json.NewEncoder(buf).Encode(m)
+	want := "{\"app_plan\":\"free\",\"app_tenant\":\"acme\",\"client\":\"app\",\"groups\":[\"users\"],\"roles\":[\"admin\"],\"tenant\":\"acme\"}\n"
+	if s := buf.String(); s != want {
+		t.Errorf("Unexpected mapped response for nested claims. Wanted %v, got %v", want, s)
+	}
+
+	if _, err := processor.LoadClaimMapping(strings.NewReader(`{"claim": {}}`)); err == nil {
+		t.Error("Unknown attributes in the claim mapping should fail")
+	}
+}
+
+func TestRealmRules(t *testing.T) {
+	rr, err := processor.LoadRealmRules(strings.NewReader(`{
+		"rules": [
+			{"claim": "sub", "match": "^stups_", "realm": "/services"},
+			{"claim": "managed-id", "realm": "/employees"},
+			{"claim": "realm"}
+		],
+		"default": "/customers"
+	}`))
+	if err != nil {
+		t.Fatal("Failed to load realm rules: ", err)
+	}
+	defer func(rr *processor.RealmRules) { options.Current().RealmRules = rr }(options.Current().RealmRules)
+	options.Current().RealmRules = rr
+
+	for _, test := range []struct {
+		claims jwt.MapClaims
+		want   string
+	}{
+		{jwt.MapClaims{"sub": "stups_foo", "realm": "/test"}, "/services"},
+		{jwt.MapClaims{"sub": "foo", "managed-id": "bar", "realm": "/test"}, "/employees"},
+		{jwt.MapClaims{"sub": "foo", "realm": "/test"}, "/test"},
+		{jwt.MapClaims{"sub": "foo"}, "/customers"},
+	} {
+		test.claims["scope"] = []interface{}{"uid"}
+		test.claims["exp"] = float64(43)
+		ti, err := NewTokenInfo(&jwt.Token{Claims: test.claims}, time.Unix(42, 0))
+		if err != nil {
+			t.Errorf("Failed to create token info for %v: %v", test.claims, err)
+		} else if ti.Realm != test.want {
+			t.Errorf("Wrong realm for %v. Wanted %q, got %q", test.claims, test.want, ti.Realm)
+		}
+	}
+
+	rr.Default = ""
+	if _, err := NewTokenInfo(&jwt.Token{Claims: jwt.MapClaims{"scope": []interface{}{"uid"}, "sub": "foo", "exp": float64(43)}}, time.Unix(42, 0)); err != ErrInvalidClaimRealm {
+		t.Errorf("Expected %v without a matching rule, got %v", ErrInvalidClaimRealm, err)
+	}
+
+	for _, invalid := range []string{`{"rules": [{"match": "foo"}]}`, `{"rules": [{"claim": "sub", "match": "("}]}`} {
+		if _, err := processor.LoadRealmRules(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Invalid realm rules %s should fail", invalid)
+		}
+	}
+}
+
+func TestScopePolicy(t *testing.T) {
+	p, err := processor.LoadScopePolicy(strings.NewReader(`{
+		"aliases": {"admin": ["read", "write"]},
+		"realms": {"/services": ["service"]},
+		"allow": ["uid", "read", "write", "service"]
+	}`))
+	if err != nil {
+		t.Fatal("Failed to load scope policy: ", err)
+	}
+
+	for _, test := range []struct {
+		policy *processor.ScopePolicy
+		scopes []string
+		realm  string
+		want   []string
+	}{
+		{nil, []string{"uid", "internal"}, "/services", []string{"uid", "internal"}},
+		{p, []string{"uid", "internal"}, "/employees", []string{"uid"}},
+		{p, []string{"uid", "admin", "read"}, "/employees", []string{"uid", "read", "write"}},
+		{p, []string{"uid"}, "/services", []string{"uid", "service"}},
+		{p, []string{}, "/services", []string{"service"}},
+	} {
+		if got := test.policy.Apply(test.scopes, test.realm); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Wrong scopes for %v in %q. Wanted %v, got %v", test.scopes, test.realm, test.want, got)
+		}
+	}
+}
+
+func TestResponseEncoder(t *testing.T) {
+	many := make([]string, 2000)
+	for i := range many {
+		many[i] = "scope-" + strconv.Itoa(i%1500)
+	}
+	for _, ti := range []*processor.TokenInfo{
+		{},
+		{AccessToken: "a.b.c", RefreshToken: "r", UID: "foo", GrantType: "password", Scope: []string{"uid", "cn"}, Realm: "/services",
+			ClientId: "client-123", TokenType: "Bearer", ExpiresIn: 3600},
+		{UID: "foo", Scope: []string{"realm", "client_id", "z", "a", "z"}, Realm: "/employees", ClientId: "bar"},
+		{UID: "Jörg <jdoe@example.org>", Scope: []string{"\"quoted\"", "tab\there"}, PrivateClaims: map[string]string{"uid": "other", "x": "y&z"}},
+		{Scope: []string{}, ExpiresIn: -1},
+		{UID: "foo", Scope: many},
+	} {
+		buf := new(bytes.Buffer)
+		if err := Marshal(ti, buf); err != nil {
+			t.Fatal("Failed to encode the response: ", err)
+		}
+		want := new(bytes.Buffer)
+		json.NewEncoder(want).Encode(response(ti))
+		if buf.String() != want.String() {
+			t.Errorf("Encoded response differs from encoding/json for %+v. Wanted %s, got %s", ti, want.String(), buf.String())
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	ti := &processor.TokenInfo{AccessToken: testRSAToken, UID: "foo", GrantType: "password", Scope: []string{"uid", "cn", "email"},
+		Realm: "/services", ClientId: "client-123", TokenType: "Bearer", ExpiresIn: 3600}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Marshal(ti, ioutil.Discard)
+	}
+}
+
+func BenchmarkMarshalMap(b *testing.B) {
+	ti := &processor.TokenInfo{AccessToken: testRSAToken, UID: "foo", GrantType: "password", Scope: []string{"uid", "cn", "email"},
+		Realm: "/services", ClientId: "client-123", TokenType: "Bearer", ExpiresIn: 3600}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		json.NewEncoder(ioutil.Discard).Encode(response(ti))
+	}
+}
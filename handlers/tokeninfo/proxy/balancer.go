@@ -0,0 +1,143 @@
+package tokeninfoproxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/ht"
+)
+
+// Load balancing strategies for multiple upstreams
+const (
+	// BalancingRoundRobin spreads the requests evenly over all healthy upstreams
+	BalancingRoundRobin = "round-robin"
+	// BalancingFailover sends all requests to the first healthy upstream in the configured order
+	BalancingFailover = "failover"
+)
+
+// an upstream is ejected after this many consecutive failures
+const maxConsecutiveFailures = 3
+
+type upstream struct {
+	url          *url.URL
+	proxy        *httputil.ReverseProxy
+	failures     int
+	ejectedUntil time.Time
+}
+
+// balancer picks the upstream for every call and keeps track of their health from the call results.
+// Upstreams that fail repeatedly are ejected for some time (passive health checking)
+type balancer struct {
+	mu        sync.Mutex
+	upstreams []*upstream
+	failover  bool
+	ejectFor  time.Duration
+	next      int
+	// gauge is the metric with the number of healthy upstreams
+	gauge string
+}
+
+// newBalancer returns a balancer for the upstreamURLs. With h2c the upstreams are called with HTTP/2 only,
+// also for http:// URLs. The headers of the upstream requests are changed by headers, if not nil
+func newBalancer(upstreamURLs []*url.URL, strategy string, ejectFor time.Duration, h2c bool, headers *headerRewriter) *balancer {
+	b := &balancer{failover: strategy == BalancingFailover, ejectFor: ejectFor, gauge: "planb.tokeninfo.proxy.upstream.healthy"}
+	for _, u := range upstreamURLs {
+		p := httputil.NewSingleHostReverseProxy(u)
+		p.Director = hostModifier(u, p.Director, headers)
+		p.ErrorHandler = proxyError
+		if h2c {
+			p.Transport = ht.NewH2CTransport()
+		} else {
+			p.Transport = ht.NewTransport()
+		}
+		b.upstreams = append(b.upstreams, &upstream{url: u, proxy: p})
+	}
+	return b
+}
+
+// pick returns the next healthy upstream, avoiding the previous one if there is any other healthy
+// upstream. If all upstreams are ejected, the one that will come back first is used
+func (b *balancer) pick(previous *upstream, now time.Time) *upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.upstreams)
+	start := 0
+	if !b.failover {
+		start = b.next
+	}
+
+	for i := 0; i < n; i++ {
+		u := b.upstreams[(start+i)%n]
+		if u != previous && !now.Before(u.ejectedUntil) {
+			if !b.failover {
+				b.next = (start + i + 1) % n
+			}
+			return u
+		}
+	}
+	if previous != nil && !now.Before(previous.ejectedUntil) {
+		return previous
+	}
+
+	first := b.upstreams[0]
+	for _, u := range b.upstreams[1:] {
+		if u.ejectedUntil.Before(first.ejectedUntil) {
+			first = u
+		}
+	}
+	return first
+}
+
+// report records the result of a call to the upstream
+func (b *balancer) report(u *upstream, ok bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		u.failures = 0
+		u.ejectedUntil = time.Time{}
+	} else {
+		u.failures++
+		if u.failures >= maxConsecutiveFailures && !now.Before(u.ejectedUntil) {
+			slog.Warn("Ejecting upstream tokeninfo after consecutive failures", "upstream", u.url, "duration", b.ejectFor, "failures", u.failures)
+			u.ejectedUntil = now.Add(b.ejectFor)
+			incCounter("planb.tokeninfo.proxy.upstream.ejections")
+		}
+	}
+
+	var healthy int64
+	for _, u := range b.upstreams {
+		if !now.Before(u.ejectedUntil) {
+			healthy++
+		}
+	}
+	if g, ok := metrics.DefaultRegistry.GetOrRegister(b.gauge, metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(healthy)
+	}
+}
+
+// Ping checks that at least one of the upstream token infos is reachable. The request has no token, so
+// any response other than a server error means the upstream is up
+func Ping(upstreamURLs []*url.URL) error {
+	err := errors.New("No upstream tokeninfo")
+	for _, u := range upstreamURLs {
+		var resp *http.Response
+		if resp, err = ht.Get(u.String()); err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusInternalServerError {
+			return nil
+		}
+		err = fmt.Errorf("%s responded with status %d", u, resp.StatusCode)
+	}
+	return err
+}
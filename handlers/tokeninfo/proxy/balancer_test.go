@@ -0,0 +1,156 @@
+package tokeninfoproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+func testBalancer(strategy string) *balancer {
+	var urls []*url.URL
+	for _, host := range []string{"a", "b", "c"} {
+		u, _ := url.Parse("http://" + host)
+		urls = append(urls, u)
+	}
+	return newBalancer(urls, strategy, time.Minute, false, nil)
+}
+
+func picks(b *balancer, n int, now time.Time) string {
+	var s string
+	for i := 0; i < n; i++ {
+		s += b.pick(nil, now).url.Host
+	}
+	return s
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	now := time.Now()
+	b := testBalancer(BalancingRoundRobin)
+	if p := picks(b, 6, now); p != "abcabc" {
+		t.Errorf("Wrong round robin order: %s", p)
+	}
+
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		b.report(b.upstreams[1], false, now)
+	}
+	if p := picks(b, 6, now); p != "acacac" {
+		t.Errorf("Ejected upstream should be skipped: %s", p)
+	}
+
+	if p := picks(b, 3, now.Add(2*time.Minute)); p != "abc" {
+		t.Errorf("Upstream should be back after the eject duration: %s", p)
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	now := time.Now()
+	b := testBalancer(BalancingFailover)
+	if p := picks(b, 3, now); p != "aaa" {
+		t.Errorf("Failover should always pick the first upstream: %s", p)
+	}
+
+	b.report(b.upstreams[0], false, now)
+	b.report(b.upstreams[0], false, now)
+	if p := picks(b, 1, now); p != "a" {
+		t.Errorf("Upstream should not be ejected before %d failures: %s", maxConsecutiveFailures, p)
+	}
+	b.report(b.upstreams[0], true, now)
+	b.report(b.upstreams[0], false, now)
+	b.report(b.upstreams[0], false, now)
+	if p := picks(b, 1, now); p != "a" {
+		t.Errorf("A success should reset the failures: %s", p)
+	}
+
+	b.report(b.upstreams[0], false, now)
+	if p := picks(b, 3, now); p != "bbb" {
+		t.Errorf("Failover should pick the standby upstream: %s", p)
+	}
+
+	if u := b.pick(b.upstreams[1], now); u.url.Host != "c" {
+		t.Errorf("A retry should avoid the previous upstream, got %s", u.url.Host)
+	}
+
+	for _, u := range b.upstreams[1:] {
+		for i := 0; i < maxConsecutiveFailures; i++ {
+			b.report(u, false, now.Add(time.Second))
+		}
+	}
+	if p := picks(b, 1, now.Add(time.Second)); p != "a" {
+		t.Errorf("With all upstreams ejected the first one to come back should be picked: %s", p)
+	}
+}
+
+func TestMultipleUpstreams(t *testing.T) {
+	defer hystrix.Flush()
+	defer func(f func(time.Duration)) { sleep = f }(sleep)
+	sleep = func(time.Duration) {}
+	defer func(s string) { options.Current().UpstreamBalancing = s }(options.Current().UpstreamBalancing)
+	options.Current().UpstreamBalancing = BalancingFailover
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	var standbyCalls int
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		standbyCalls++
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer standby.Close()
+
+	pu, _ := url.Parse(fmt.Sprintf("http://%s", primary.Listener.Addr()))
+	su, _ := url.Parse(fmt.Sprintf("http://%s", standby.Listener.Addr()))
+	h := NewTokenInfoProxyHandlerWithUpstreams([]*url.URL{pu, su}, tokencache.NewMemoryCache(10), 0, time.Second).(*tokenInfoProxyHandler)
+	h.retries = 1
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/oauth2/tokeninfo?access_token=foo%d", i), nil)
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("Request %d should be answered by the standby, got %d", i, w.Code)
+		}
+	}
+	if standbyCalls != 5 {
+		t.Errorf("Wrong number of calls to the standby. Wanted 5, got %d", standbyCalls)
+	}
+	if h.upstreams.upstreams[0].failures != maxConsecutiveFailures {
+		t.Errorf("Primary should be ejected after %d failures, got %d", maxConsecutiveFailures, h.upstreams.upstreams[0].failures)
+	}
+}
+
+func TestPing(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	upURL, _ := url.Parse(up.URL)
+	downURL, _ := url.Parse(down.URL)
+	closedURL, _ := url.Parse("http://127.0.0.1:1")
+	for _, test := range []struct {
+		urls    []*url.URL
+		wantErr bool
+	}{
+		{nil, true},
+		{[]*url.URL{upURL}, false},
+		{[]*url.URL{downURL}, true},
+		{[]*url.URL{closedURL}, true},
+		{[]*url.URL{closedURL, downURL, upURL}, false},
+	} {
+		if err := Ping(test.urls); (err != nil) != test.wantErr {
+			t.Errorf("Unexpected result for %v. Wanted error %t, got %v", test.urls, test.wantErr, err)
+		}
+	}
+}
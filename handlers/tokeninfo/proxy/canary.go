@@ -0,0 +1,81 @@
+package tokeninfoproxy
+
+import (
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/handlers/callerauth"
+)
+
+// CanaryHeader sends a request to the canary upstream, whatever the percentage, when it's "true". The
+// cached responses are skipped for such requests, so that they really reach the canary. It's only honoured
+// for authenticated callers and trusted proxies
+const CanaryHeader = "X-Tokeninfo-Canary"
+
+// Names of the upstream backends in the metrics
+const (
+	backendPrimary = "primary"
+	backendCanary  = "canary"
+)
+
+// canary routes a percentage of the upstream calls to another upstream, for ex. a new token info backend
+// that takes over the traffic gradually. Unlike the shadow upstream, its responses are sent to the clients
+type canary struct {
+	upstreams  *balancer
+	percentage int
+	trusted    []netip.Prefix
+}
+
+// newCanary returns a canary for the upstream at canaryURL, or nil without canaryURL. The canary is called
+// like the other upstreams, with their headers and protocol, and it's ejected after failures too. The
+// CanaryHeader is honoured for the requests of the trusted proxies
+func newCanary(canaryURL *url.URL, percentage int, ejectFor time.Duration, h2c bool, headers *headerRewriter, trusted []netip.Prefix) *canary {
+	if canaryURL == nil {
+		return nil
+	}
+	upstreams := newBalancer([]*url.URL{canaryURL}, BalancingRoundRobin, ejectFor, h2c, headers)
+	upstreams.gauge = "planb.tokeninfo.proxy.backends.canary.healthy"
+	return &canary{upstreams: upstreams, percentage: percentage, trusted: trusted}
+}
+
+// forced tells whether req asks for the canary with the CanaryHeader, and is allowed to: an anonymous client
+// could otherwise skip the cache and load the upstreams at will
+func (c *canary) forced(req *http.Request) bool {
+	if c == nil || req.Header.Get(CanaryHeader) != "true" {
+		return false
+	}
+	return callerauth.FromContext(req.Context()) != "" || clientip.FromTrustedProxy(req, c.trusted)
+}
+
+// route returns the upstreams for an upstream call of req and the name of the backend in the metrics. The
+// name is empty without a canary, as there is only one backend then
+func (h *tokenInfoProxyHandler) route(req *http.Request) (*balancer, string) {
+	switch c := h.canary; {
+	case c == nil:
+		return h.upstreams, ""
+	case c.forced(req):
+		incCounter("planb.tokeninfo.proxy.backends.canary.forced")
+		return c.upstreams, backendCanary
+	case rand.Intn(100) < c.percentage:
+		return c.upstreams, backendCanary
+	}
+	return h.upstreams, backendPrimary
+}
+
+// observeBackend records the duration and the result of an upstream call to backend
+func observeBackend(backend string, start time.Time, failed bool) {
+	if backend == "" {
+		return
+	}
+	incCounter("planb.tokeninfo.proxy.backends." + backend + ".requests")
+	if failed {
+		incCounter("planb.tokeninfo.proxy.backends." + backend + ".errors")
+	}
+	t := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy.backends."+backend, metrics.NewTimer).(metrics.Timer)
+	t.UpdateSince(start)
+}
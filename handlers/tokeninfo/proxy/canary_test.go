@@ -0,0 +1,89 @@
+package tokeninfoproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/zalando/planb-tokeninfo/handlers/callerauth"
+	"github.com/zalando/planb-tokeninfo/handlers/ratelimit"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+func TestCanary(t *testing.T) {
+	defer hystrix.Flush()
+	var primaryCalls, canaryCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer primary.Close()
+	canaryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&canaryCalls, 1)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer canaryServer.Close()
+	primaryURL, _ := url.Parse(primary.URL)
+	canaryURL, _ := url.Parse(canaryServer.URL)
+
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, test := range []struct {
+		name             string
+		percentage       int
+		header           string
+		apiKey           string
+		remoteAddr       string
+		limit            float64
+		wantPrimaryCalls int32
+		wantCanaryCalls  int32
+		wantCodes        []int
+	}{
+		{"0%", 0, "", "", "", 0, 1, 0, []int{200, 200}},
+		{"100%", 100, "", "", "", 0, 0, 1, []int{200, 200}},
+		{"forced by a caller", 0, "true", "k", "", 0, 0, 2, []int{200, 200}},
+		{"forced by a trusted proxy", 0, "true", "", "10.1.2.3:1234", 0, 0, 2, []int{200, 200}},
+		{"forced by an anonymous client", 0, "true", "", "192.0.2.1:1234", 0, 1, 0, []int{200, 200}},
+		{"not forced", 0, "false", "k", "", 0, 1, 0, []int{200, 200}},
+		{"forced beyond the rate limit", 0, "true", "k", "", 3, 0, 1, []int{200, 429}},
+	} {
+		primaryCalls, canaryCalls = 0, 0
+		h := NewTokenInfoProxyHandlerWithUpstreams([]*url.URL{primaryURL}, tokencache.NewMemoryCache(10), time.Minute, time.Second).(*tokenInfoProxyHandler)
+		h.canary = newCanary(canaryURL, test.percentage, 0, false, nil, trusted)
+		var handler http.Handler = h
+		if test.limit > 0 {
+			handler = ratelimit.NewHandler(handler, ratelimit.Limits{PerToken: test.limit})
+		}
+		if test.apiKey != "" {
+			handler = callerauth.NewHandler(handler, callerauth.Callers{APIKeys: map[string]string{"ci": test.apiKey}})
+		}
+
+		// the second request finds the response in the cache, unless it's forced to the canary
+		for i := 0; i < 2; i++ {
+			r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=foo", nil)
+			if test.header != "" {
+				r.Header.Set(CanaryHeader, test.header)
+			}
+			if test.apiKey != "" {
+				r.Header.Set(callerauth.APIKeyHeader, test.apiKey)
+			}
+			if test.remoteAddr != "" {
+				r.RemoteAddr = test.remoteAddr
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.wantCodes[i] {
+				t.Errorf("Wrong status of request %d for %s. Wanted %d, got %d", i, test.name, test.wantCodes[i], w.Code)
+			}
+		}
+		p, c := atomic.LoadInt32(&primaryCalls), atomic.LoadInt32(&canaryCalls)
+		if p != test.wantPrimaryCalls || c != test.wantCanaryCalls {
+			t.Errorf("Wrong calls for %s. Wanted %d primary and %d canary calls, got %d and %d",
+				test.name, test.wantPrimaryCalls, test.wantCanaryCalls, p, c)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package tokeninfoproxy
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader has the time the client waits for the response, as a duration ("200ms") or in milliseconds.
+// The upstream gets the upstream timeout in the same header
+const TimeoutHeader = "X-Timeout"
+
+// errDeadlineExceeded is returned when the deadline of the client passed before the upstream answered. It
+// says nothing about the health of the upstream, so it's neither retried nor reported to the circuit breaker
+var errDeadlineExceeded = errors.New("Client deadline exceeded")
+
+// timeoutKey is the context key of the hard upstream timeout, used to tell the upstream how long we wait
+type timeoutKey struct{}
+
+// clientDeadline returns the earlier of the deadline of the request context, for ex. from a gRPC client, and
+// the one from the timeout header
+func clientDeadline(req *http.Request, now time.Time) (time.Time, bool) {
+	deadline, ok := req.Context().Deadline()
+	if d, valid := parseTimeout(req.Header.Get(TimeoutHeader)); valid {
+		if at := now.Add(d); !ok || at.Before(deadline) {
+			deadline, ok = at, true
+		}
+	}
+	return deadline, ok
+}
+
+// parseTimeout reads a positive duration or number of milliseconds
+func parseTimeout(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, ms > 0
+	}
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d > 0
+}
+
+// setTimeout sets the timeout header of an upstream request to the time left until the hard timeout or, if
+// earlier, the deadline of the client
+func setTimeout(req *http.Request, now time.Time) {
+	budget, ok := req.Context().Value(timeoutKey{}).(time.Duration)
+	ok = ok && budget > 0
+	if deadline, has := req.Context().Deadline(); has && (!ok || deadline.Sub(now) < budget) {
+		budget, ok = deadline.Sub(now), true
+	}
+	if !ok || budget <= 0 {
+		req.Header.Del(TimeoutHeader)
+		return
+	}
+	req.Header.Set(TimeoutHeader, strconv.FormatInt(budget.Milliseconds(), 10)+"ms")
+}
+
+// watchSlow counts and logs an upstream call that takes longer than the soft timeout, as soon as it does. The
+// returned function must be called when the call finished
+func (h *tokenInfoProxyHandler) watchSlow(u *upstream) func() {
+	if h.softTimeout <= 0 {
+		return func() {}
+	}
+	t := time.AfterFunc(h.softTimeout, func() {
+		incCounter("planb.tokeninfo.proxy.upstream.slow")
+		slog.Info("Upstream tokeninfo is slow", "upstream", u.url.Host, "soft_timeout", h.softTimeout)
+	})
+	return func() { t.Stop() }
+}
@@ -0,0 +1,174 @@
+package tokeninfoproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+func TestParseTimeout(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  time.Duration
+		valid bool
+	}{
+		{"", 0, false},
+		{"200", 200 * time.Millisecond, true},
+		{"1.5s", 1500 * time.Millisecond, true},
+		{"0", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	} {
+		d, valid := parseTimeout(test.value)
+		if valid != test.valid || (valid && d != test.want) {
+			t.Errorf("Wrong timeout for %q. Wanted %v (%v), got %v (%v)", test.value, test.want, test.valid, d, valid)
+		}
+	}
+}
+
+func TestClientDeadline(t *testing.T) {
+	now := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Second))
+	defer cancel()
+	for _, test := range []struct {
+		ctx    context.Context
+		header string
+		want   time.Time
+		ok     bool
+	}{
+		{context.Background(), "", time.Time{}, false},
+		{context.Background(), "200ms", now.Add(200 * time.Millisecond), true},
+		{ctx, "", now.Add(time.Second), true},
+		{ctx, "200", now.Add(200 * time.Millisecond), true},
+		{ctx, "2s", now.Add(time.Second), true},
+	} {
+		r, _ := http.NewRequestWithContext(test.ctx, "GET", "/oauth2/tokeninfo", nil)
+		r.Header.Set(TimeoutHeader, test.header)
+		d, ok := clientDeadline(r, now)
+		if ok != test.ok || !d.Equal(test.want) {
+			t.Errorf("Wrong deadline for %q. Wanted %v (%v), got %v (%v)", test.header, test.want, test.ok, d, ok)
+		}
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	defer hystrix.Flush()
+	var upstreamTimeout int64
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		ms, _ := strconv.ParseInt(strings.TrimSuffix(req.Header.Get(TimeoutHeader), "ms"), 10, 64)
+		atomic.StoreInt64(&upstreamTimeout, ms)
+		if req.URL.Query().Get("access_token") == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandlerWithCache(u, tokencache.NewMemoryCache(10), 0, time.Second)
+
+	for _, test := range []struct {
+		token       string
+		timeout     string
+		wantCode    int
+		wantMin     int64
+		wantMax     int64
+		wantUpCalls int32
+	}{
+		{"fast", "", http.StatusOK, 900, 1000, 1},
+		{"fast", "100ms", http.StatusOK, 900, 1000, 2},
+		{"fast", "5s", http.StatusOK, 900, 1000, 3},
+		{"slow", "50", http.StatusGatewayTimeout, 900, 1000, 4},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token="+test.token, nil)
+		r.Header.Set(TimeoutHeader, test.timeout)
+		h.ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code with timeout %q. Wanted %d, got %d", test.timeout, test.wantCode, w.Code)
+		}
+		if got := atomic.LoadInt64(&upstreamTimeout); got < test.wantMin || got > test.wantMax {
+			t.Errorf("Wrong upstream timeout for %q. Wanted %d-%dms, got %dms", test.timeout, test.wantMin, test.wantMax, got)
+		}
+		if got := atomic.LoadInt32(&calls); got != test.wantUpCalls {
+			t.Errorf("Wrong number of upstream calls for %q. Wanted %d, got %d", test.timeout, test.wantUpCalls, got)
+		}
+	}
+
+	if c := metrics.DefaultRegistry.Get("planb.tokeninfo.proxy.upstream.deadlines"); c == nil || c.(metrics.Counter).Count() == 0 {
+		t.Error("Exceeded client deadlines should be counted")
+	}
+}
+
+func TestCoalescedDeadlines(t *testing.T) {
+	defer hystrix.Flush()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandlerWithCache(u, tokencache.NewMemoryCache(10), 0, time.Second)
+
+	// the client with the short deadline starts the call, the other one still gets the response
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i, timeout := range []string{"50ms", ""} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=shared", nil)
+			r.Header.Set(TimeoutHeader, timeout)
+			h.ServeHTTP(w, r)
+			codes[i] = w.Code
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+	if codes[0] != http.StatusGatewayTimeout || codes[1] != http.StatusOK {
+		t.Errorf("Wrong status codes. Wanted 504 and 200, got %v", codes)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("The clients should share one upstream call, got %d", got)
+	}
+}
+
+func TestSoftTimeout(t *testing.T) {
+	defer hystrix.Flush()
+	defer func(d time.Duration) { options.Current().UpstreamSoftTimeout = d }(options.Current().UpstreamSoftTimeout)
+	options.Current().UpstreamSoftTimeout = 10 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	slow := metrics.GetOrRegisterCounter("planb.tokeninfo.proxy.upstream.slow", metrics.DefaultRegistry)
+	before := slow.Count()
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=foo", nil)
+	NewTokenInfoProxyHandlerWithCache(u, tokencache.NewMemoryCache(10), 0, time.Second).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("A slow upstream should still be answered, got %d", w.Code)
+	}
+	if slow.Count() != before+1 {
+		t.Errorf("The slow upstream call should be counted once, got %d", slow.Count()-before)
+	}
+}
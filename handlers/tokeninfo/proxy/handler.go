@@ -2,61 +2,232 @@ package tokeninfoproxy
 
 import (
 	"bytes"
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/afex/hystrix-go/hystrix"
-	"github.com/karlseguin/ccache"
 	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/breaker"
+	"github.com/zalando/planb-tokeninfo/handlers/callerauth"
+	"github.com/zalando/planb-tokeninfo/handlers/ratelimit"
 	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/latency"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/processor"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+	"github.com/zalando/planb-tokeninfo/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/singleflight"
 )
 
 type tokenInfoProxyHandler struct {
-	upstream *httputil.ReverseProxy
-	cache    *ccache.Cache
-	cacheTTL time.Duration
-	timeout  time.Duration
+	command     string
+	upstreams   *balancer
+	cache       tokencache.Cache
+	cacheTTL    time.Duration
+	cacheMinTTL time.Duration
+	cacheMaxTTL time.Duration
+	timeout     time.Duration
+	softTimeout time.Duration
+	retries     int
+	backoff     time.Duration
+	budget      *retryBudget
+	hedgeDelay  time.Duration
+	inflight    singleflight.Group
+	scopes      *processor.ScopePolicy
+	validator   *responseValidator
+	maxBody     int64
+	policy      tokeninfo.CachePolicy
+	etags       bool
+	streaming   bool
+	shadow      *shadow
+	canary      *canary
 }
 
-const proxyCommand = "proxy"
+// ProxyCommand is the name of the circuit breaker around the upstream calls
+const ProxyCommand = "proxy"
+
+// RouteCommand returns the name of the circuit breaker around the calls to the upstream of a route of
+// TOKEN_ROUTES_FILE with the target, so that a failing route doesn't open the circuit of the others
+func RouteCommand(target string) string {
+	return ProxyCommand + ":" + target
+}
+
+// errUpstreamFailure is reported to the circuit breaker whenever the upstream answers with a server
+// error. The upstream response is still sent back to the client
+var errUpstreamFailure = errors.New("Upstream tokeninfo failure")
 
 // NewTokenInfoProxyHandler returns an http.Handler that proxies every Request to the server
-// at the upstreamURL
+// at the upstreamURL. Responses are cached in memory
 func NewTokenInfoProxyHandler(upstreamURL *url.URL, cacheMaxSize int64, cacheTTL time.Duration, timeout time.Duration) http.Handler {
-	log.Printf("Upstream tokeninfo is %s with %v cache (%d max size)", upstreamURL, cacheTTL, cacheMaxSize)
-	p := httputil.NewSingleHostReverseProxy(upstreamURL)
-	p.Director = hostModifier(upstreamURL, p.Director)
-	cache := ccache.New(ccache.Configure().MaxSize(cacheMaxSize))
-	hystrix.ConfigureCommand(proxyCommand, hystrix.CommandConfig{
-		Timeout: int(timeout.Seconds() * 1000),
+	slog.Info("Tokens are proxied to the upstream tokeninfo", "url", upstreamURL, "cache_ttl", cacheTTL, "cache_max_size", cacheMaxSize)
+	return NewTokenInfoProxyHandlerWithCache(upstreamURL, tokencache.NewMemoryCache(cacheMaxSize), cacheTTL, timeout)
+}
+
+// NewTokenInfoProxyHandlerWithCache returns an http.Handler that proxies every Request to the server
+// at the upstreamURL. Responses are stored in the cache
+func NewTokenInfoProxyHandlerWithCache(upstreamURL *url.URL, cache tokencache.Cache, cacheTTL time.Duration, timeout time.Duration) http.Handler {
+	return NewTokenInfoProxyHandlerWithUpstreams([]*url.URL{upstreamURL}, cache, cacheTTL, timeout)
+}
+
+// NewTokenInfoProxyHandlerWithUpstreams returns an http.Handler that proxies every Request to one of the
+// servers at the upstreamURLs, chosen with the configured load balancing strategy. Responses are stored
+// in the cache. A percentage of the calls goes to the canary upstream, if one is configured
+func NewTokenInfoProxyHandlerWithUpstreams(upstreamURLs []*url.URL, cache tokencache.Cache, cacheTTL time.Duration, timeout time.Duration) http.Handler {
+	return newTokenInfoProxyHandler(ProxyCommand, upstreamURLs, cache, cacheTTL, timeout)
+}
+
+// NewTokenInfoProxyHandlerForRoute returns the handler of NewTokenInfoProxyHandlerWithUpstreams for the upstream
+// at upstreamURL of the routes with the target. Its calls have their own circuit breaker, RouteCommand(target)
+func NewTokenInfoProxyHandlerForRoute(target string, upstreamURL *url.URL, cache tokencache.Cache, cacheTTL time.Duration, timeout time.Duration) http.Handler {
+	return newTokenInfoProxyHandler(RouteCommand(target), []*url.URL{upstreamURL}, cache, cacheTTL, timeout)
+}
+
+// ConfigureCommand configures the circuit breaker command like the ones of the upstream token info calls,
+// for calls that take at most timeout
+func ConfigureCommand(command string, timeout time.Duration) {
+	settings := options.Current()
+	hystrix.ConfigureCommand(command, hystrix.CommandConfig{
+		Timeout:                int(timeout.Seconds() * 1000),
+		ErrorPercentThreshold:  settings.UpstreamCircuitErrorThreshold,
+		RequestVolumeThreshold: settings.UpstreamCircuitRequestVolume,
+		SleepWindow:            int(settings.UpstreamCircuitSleepWindow.Seconds() * 1000),
 	})
-	return &tokenInfoProxyHandler{upstream: p, cache: cache, cacheTTL: cacheTTL, timeout: timeout}
 }
 
-func newResponseBuffer(w http.ResponseWriter) *responseBuffer {
+func newTokenInfoProxyHandler(command string, upstreamURLs []*url.URL, cache tokencache.Cache, cacheTTL time.Duration, timeout time.Duration) *tokenInfoProxyHandler {
+	settings := options.Current()
+	ConfigureCommand(command, timeout)
+	maxTTL := settings.UpstreamCacheMaxTTL
+	if maxTTL == 0 {
+		maxTTL = cacheTTL
+	}
+	headers := newHeaderRewriter(settings.UpstreamForwardHeaders, settings.UpstreamHeaders)
+	var validator *responseValidator
+	if settings.UpstreamValidateResponses {
+		validator = newResponseValidator(settings.UpstreamAllowedFields)
+	}
+	return &tokenInfoProxyHandler{
+		command:     command,
+		upstreams:   newBalancer(upstreamURLs, settings.UpstreamBalancing, settings.UpstreamEjectDuration, settings.UpstreamH2C, headers),
+		cache:       cache,
+		cacheTTL:    cacheTTL,
+		cacheMinTTL: settings.UpstreamCacheMinTTL,
+		cacheMaxTTL: maxTTL,
+		timeout:     timeout,
+		softTimeout: settings.UpstreamSoftTimeout,
+		retries:     settings.UpstreamRetries,
+		backoff:     settings.UpstreamRetryBackoff,
+		budget:      newRetryBudget(settings.UpstreamRetryBudget),
+		hedgeDelay:  settings.UpstreamHedgeDelay,
+		scopes:      settings.ScopePolicy,
+		validator:   validator,
+		maxBody:     settings.UpstreamMaxBodySize,
+		policy:      tokeninfo.CachePolicy{MaxAge: settings.ResponseCacheMaxAge, Directives: settings.ResponseCacheDirectives},
+		etags:       settings.ResponseETags,
+		streaming:   settings.UpstreamStreaming,
+		canary:      newCanary(settings.UpstreamCanaryURL, settings.UpstreamCanaryPercentage, settings.UpstreamEjectDuration, settings.UpstreamH2C, headers, settings.TrustedProxies)}
+}
+
+// NewTokenInfoProxyHandlerWithShadow returns the handler of NewTokenInfoProxyHandlerWithUpstreams that also
+// duplicates a percentage of the upstream calls to the candidate upstream at candidateURL, to compare their
+// responses. The responses of the candidate are never sent to the clients
+func NewTokenInfoProxyHandlerWithShadow(upstreamURLs []*url.URL, candidateURL *url.URL, cache tokencache.Cache, cacheTTL time.Duration, timeout time.Duration) http.Handler {
+	settings := options.Current()
+	h := newTokenInfoProxyHandler(ProxyCommand, upstreamURLs, cache, cacheTTL, timeout)
+	headers := newHeaderRewriter(settings.UpstreamForwardHeaders, settings.UpstreamHeaders)
+	h.shadow = newShadow(candidateURL, settings.UpstreamShadowPercentage, timeout, settings.UpstreamH2C, headers)
+	return h
+}
+
+// buffers bigger than this are not returned to the pool, so that a few big responses don't keep memory
+const maxPooledBufferSize = 64 * 1024
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// newResponseBuffer returns a responseBuffer with a pooled buffer. Bodies bigger than maxSize bytes are
+// truncated, unless maxSize is 0
+func newResponseBuffer(maxSize int64) *responseBuffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
 	return &responseBuffer{
-		ResponseWriter: w,
-		Buffer:         &bytes.Buffer{},
+		header:     make(http.Header),
+		Buffer:     b,
+		StatusCode: http.StatusOK,
+		maxSize:    maxSize,
 	}
 }
 
+// badGatewayResponse replaces an upstream response that can't be sent to the client
+func badGatewayResponse() *responseBuffer {
+	rw := newResponseBuffer(0)
+	rw.StatusCode = http.StatusBadGateway
+	return rw
+}
+
+// responseBuffer holds a complete upstream response so that it can be cached and sent to every
+// Request waiting for it
 type responseBuffer struct {
-	http.ResponseWriter
+	header     http.Header
 	Buffer     *bytes.Buffer
 	StatusCode int
+	maxSize    int64
+	truncated  bool
+}
+
+func (rw *responseBuffer) Header() http.Header {
+	return rw.header
 }
 
 func (rw *responseBuffer) WriteHeader(status int) {
 	rw.StatusCode = status
-	rw.ResponseWriter.WriteHeader(status)
 }
 
+// Write discards the rest of the body once it's bigger than the maximum size. Returning an error instead
+// would make the reverse proxy abort the whole Request
 func (rw *responseBuffer) Write(b []byte) (int, error) {
-	rw.Buffer.Write(b)
-	return rw.ResponseWriter.Write(b)
+	if rw.maxSize > 0 && int64(rw.Buffer.Len()+len(b)) > rw.maxSize {
+		rw.truncated = true
+		return len(b), nil
+	}
+	return rw.Buffer.Write(b)
+}
+
+// release returns the buffer to the pool. The response can't be used anymore afterwards
+func (rw *responseBuffer) release() {
+	if rw.Buffer.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(rw.Buffer)
+	}
+	rw.Buffer = nil
+}
+
+// writeTo sends the buffered response to w, with the caching headers of the policy for successful
+// responses and the etag, if not empty. The buffer itself is not changed, so it can be written to several
+// response writers
+func (rw *responseBuffer) writeTo(w http.ResponseWriter, policy tokeninfo.CachePolicy, etag string) {
+	for k, v := range rw.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "MISS")
+	if now := time.Now(); rw.StatusCode == http.StatusOK && policy.Enabled() {
+		if expiresIn, ok := expiresIn(rw.Buffer.Bytes(), now); ok {
+			policy.Set(w.Header(), expiresIn, now)
+		} else {
+			policy.SetNoCache(w.Header())
+		}
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(rw.StatusCode)
+	w.Write(rw.Buffer.Bytes())
 }
 
 func incCounter(key string) {
@@ -65,74 +236,408 @@ func incCounter(key string) {
 	}
 }
 
+// incVariantCounter counts the cache hits or misses of a variant other than the default one
+func incVariantCounter(variant string, result string) {
+	if variant != "" {
+		incCounter("planb.tokeninfo.proxy.cache.variants." + variant + "." + result)
+	}
+}
+
 // ServeHTTP proxies the Request with an Access Token to the upstream and sends back the response
 // from the upstream
 func (h *tokenInfoProxyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	token := tokeninfo.AccessTokenFromRequest(req)
 	if token == "" {
-		tokeninfo.ErrInvalidRequest.Write(w)
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+		return
+	}
+	if h.canary.forced(req) && !ratelimit.Charge(w, req) {
+		// the calls forced to the canary skip the cache, so they are charged to the rate limits once more
 		return
 	}
 	start := time.Now()
-	item := h.cache.Get(token)
-	if item != nil {
-		if !item.Expired() {
-			incCounter("planb.tokeninfo.proxy.cache.hits")
-			w.Header().Set("Content-Type", "application/json;charset=UTF-8")
-			w.Header().Set("X-Cache", "HIT")
-			w.Write(item.Value().([]byte))
+	if h.streaming {
+		err := h.streamUpstream(w, req, token)
+		h.updateCircuitState()
+		if err != nil {
+			writeUpstreamError(w, err)
 			return
-		} else {
-			incCounter("planb.tokeninfo.proxy.cache.expirations")
 		}
+		t := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy", metrics.NewTimer).(metrics.Timer)
+		t.UpdateSince(start)
+		return
+	}
+	// callers with their own scope policy have their own variant of the responses, in the cache too
+	_, variant := h.scopes.ForCaller(callerauth.FromContext(req.Context()))
+	key := tokeninfo.VariantKey(tokeninfo.HashToken(token), variant)
+	inflightKey := key
+	cached, err := h.cache.Get(key)
+	if h.canary.forced(req) {
+		// neither a cached response nor the call of another request would come from the canary
+		inflightKey = backendCanary + ":" + key
+		cached, err = nil, tokencache.ErrNotFound
+	}
+	switch err {
+	case nil:
+		incCounter("planb.tokeninfo.proxy.cache.hits")
+		incVariantCounter(variant, "hits")
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("X-Cache", "HIT")
+		// the cached expires_in is from the time the response was stored
+		h.policy.SetNoCache(w.Header())
+		if etag := h.etag(key, http.StatusOK, cached); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Write(cached)
+		return
+	case tokencache.ErrNotFound:
+	case tokencache.ErrExpired:
+		incCounter("planb.tokeninfo.proxy.cache.expirations")
+	default:
+		slog.Warn("Failed to get token info from the cache", "error", err)
+		incCounter("planb.tokeninfo.proxy.cache.errors")
 	}
 	incCounter("planb.tokeninfo.proxy.cache.misses")
-	err := hystrix.Do(proxyCommand, func() error {
+	incVariantCounter(variant, "misses")
+	rw, shared, err := h.coalescedUpstream(req, token, inflightKey)
+	h.updateCircuitState()
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	rw.writeTo(w, h.policy, h.etag(key, rw.StatusCode, rw.Buffer.Bytes()))
+	if !shared {
+		rw.release()
+	}
+
+	t := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy", metrics.NewTimer).(metrics.Timer)
+	t.UpdateSince(start)
+}
+
+// writeUpstreamError answers with the status code for a failed upstream call: 504 for timeouts and exceeded
+// client deadlines, 429 when too many calls are running and 503 while the circuit breaker is open
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	switch err {
+	case hystrix.ErrTimeout:
+		{
+			status = http.StatusGatewayTimeout
+			incCounter("planb.tokeninfo.proxy.upstream.timeouts")
+		}
+	case errDeadlineExceeded:
+		{
+			status = http.StatusGatewayTimeout
+			incCounter("planb.tokeninfo.proxy.upstream.deadlines")
+		}
+	case hystrix.ErrMaxConcurrency:
+		{
+			status = http.StatusTooManyRequests
+			incCounter("planb.tokeninfo.proxy.upstream.overruns")
+		}
+	case hystrix.ErrCircuitOpen:
+		{
+			status = http.StatusServiceUnavailable
+			incCounter("planb.tokeninfo.proxy.upstream.openrequests")
+		}
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(http.StatusText(status)))
+}
+
+// coalescedUpstream makes sure that concurrent Requests for the same token and variant, with the cache key
+// key, share a single upstream round trip. Only the first Request is sent to the upstream, the remaining ones
+// wait for its response. The shared call only ends with the upstream timeout, every Request waits for it until
+// its own client deadline. A response that was shared with other Requests must not be released
+func (h *tokenInfoProxyHandler) coalescedUpstream(req *http.Request, token string, key string) (*responseBuffer, bool, error) {
+	var wait <-chan time.Time
+	if deadline, ok := clientDeadline(req, time.Now()); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, false, errDeadlineExceeded
+		}
+		t := time.NewTimer(left)
+		defer t.Stop()
+		wait = t.C
+	}
+	leader := false
+	ch := h.inflight.DoChan(key, func() (interface{}, error) {
+		leader = true
+		// the call outlives the Request that started it when its client gives up first
+		return h.upstreamRequest(req.WithContext(context.WithoutCancel(req.Context())), token)
+	})
+	select {
+	case res := <-ch:
+		if !leader {
+			incCounter("planb.tokeninfo.proxy.upstream.coalesced")
+		}
+		if res.Err != nil {
+			return nil, res.Shared, res.Err
+		}
+		return res.Val.(*responseBuffer), res.Shared, nil
+	case <-wait:
+	case <-req.Context().Done():
+	}
+	// the response may still be shared with other Requests, it's never released
+	return nil, true, errDeadlineExceeded
+}
+
+func (h *tokenInfoProxyHandler) upstreamRequest(req *http.Request, token string) (*responseBuffer, error) {
+	ctx, span := tracing.Start(req.Context(), "proxy.upstream", attribute.String("token.hash", tokeninfo.HashPrefix(token)))
+	defer span.End()
+	req = req.WithContext(context.WithValue(ctx, timeoutKey{}, h.timeout))
+
+	h.budget.deposit()
+	upstreams, backend := h.route(req)
+	start := time.Now()
+	var rw *responseBuffer
+	var err error
+	var u *upstream
+	for attempt := 0; ; attempt++ {
+		u = upstreams.pick(u, time.Now())
+		rw, err = h.hedgedAttempt(upstreams, u, req, token)
+		if !retryable(err) || attempt >= h.retries {
+			break
+		}
+		if !h.budget.withdraw() {
+			incCounter("planb.tokeninfo.proxy.upstream.retries.exhausted")
+			break
+		}
+		incCounter("planb.tokeninfo.proxy.upstream.retries")
+		if rw != nil {
+			rw.release()
+		}
+		sleep(backoff(h.backoff, attempt))
+	}
+
+	if rw != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", rw.StatusCode))
+	}
+	observeBackend(backend, start, err != nil)
+	if err != nil {
+		tracing.Fail(span, err)
+		if err != errUpstreamFailure {
+			return nil, err
+		}
+	}
+	if h.shadow.sample() {
+		policy, _ := h.scopes.ForCaller(callerauth.FromContext(req.Context()))
+		h.shadow.mirror(req, rw.StatusCode, rw.Buffer.Bytes(), h.validator, policy)
+	}
+	return rw, nil
+}
+
+// upstreamAttempt makes a single call to the upstream u guarded by the circuit breaker. The response is
+// returned also for upstream server errors, together with errUpstreamFailure
+func (h *tokenInfoProxyHandler) upstreamAttempt(u *upstream, req *http.Request, token string) (*responseBuffer, error) {
+	// the command keeps running after a timeout, so its response is only read once it finished
+	result := make(chan *responseBuffer, 1)
+	err := hystrix.Do(h.command, func() error {
 		upstreamStart := time.Now()
-		rw := newResponseBuffer(w)
-		rw.Header().Set("X-Cache", "MISS")
-		h.upstream.ServeHTTP(rw, req)
-		if rw.StatusCode == http.StatusOK && h.cacheTTL > 0 {
-			h.cache.Set(token, rw.Buffer.Bytes(), h.cacheTTL)
+		stop := h.watchSlow(u)
+		buf := newResponseBuffer(h.maxBody)
+		u.proxy.ServeHTTP(buf, req)
+		stop()
+		if errors.Is(req.Context().Err(), context.DeadlineExceeded) {
+			buf.release()
+			result <- nil
+			return nil
+		}
+		if buf.truncated {
+			slog.Warn("Upstream tokeninfo response is too big", "max_bytes", h.maxBody)
+			incCounter("planb.tokeninfo.proxy.upstream.toolarge")
+			buf.release()
+			buf = badGatewayResponse()
+		}
+		if buf.StatusCode == http.StatusOK && h.validator != nil {
+			if err := h.validator.sanitize(buf); err != nil {
+				// the upstream is treated as failed, so the call is retried and counts against the upstream
+				slog.Warn("Rejected upstream tokeninfo response", "error", err)
+				incCounter("planb.tokeninfo.proxy.upstream.invalid")
+				buf.release()
+				buf = badGatewayResponse()
+			}
+		}
+		policy, variant := h.scopes.ForCaller(callerauth.FromContext(req.Context()))
+		if buf.StatusCode == http.StatusOK && policy != nil {
+			applyScopePolicy(buf, policy)
+		}
+		if buf.StatusCode == http.StatusOK && h.cacheTTL > 0 {
+			h.store(tokeninfo.VariantKey(tokeninfo.HashToken(token), variant), buf.Buffer.Bytes(), time.Now())
 		}
 		upstreamTimer := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy.upstream", metrics.NewTimer).(metrics.Timer)
 		upstreamTimer.UpdateSince(upstreamStart)
+		latency.Observe("planb.tokeninfo.proxy.upstream.latency", upstreamStart)
+		result <- buf
+		if buf.StatusCode >= http.StatusInternalServerError {
+			return errUpstreamFailure
+		}
 		return nil
 	}, nil)
+	if err != nil && err != errUpstreamFailure {
+		return nil, err
+	}
+	rw := <-result
+	if rw == nil {
+		return nil, errDeadlineExceeded
+	}
+	return rw, err
+}
 
-	if err != nil {
-		status := http.StatusInternalServerError
-		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		switch err {
-		case hystrix.ErrTimeout:
-			{
-				status = http.StatusGatewayTimeout
-				incCounter("planb.tokeninfo.proxy.upstream.timeouts")
-			}
-		case hystrix.ErrMaxConcurrency:
-			{
-				status = http.StatusTooManyRequests
-				incCounter("planb.tokeninfo.proxy.upstream.overruns")
-			}
-		case hystrix.ErrCircuitOpen:
-			{
-				status = http.StatusBadGateway
-				incCounter("planb.tokeninfo.proxy.upstream.openrequests")
-			}
+// applyScopePolicy rewrites the scopes of an upstream token info response with the policy before it's cached.
+// The scope attribute is replaced and the matching boolean attributes are updated. Responses without a list
+// of scopes are left unchanged
+func applyScopePolicy(buf *responseBuffer, policy *processor.ScopePolicy) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Buffer.Bytes(), &m); err != nil {
+		return
+	}
+	list, ok := m["scope"].([]interface{})
+	if !ok {
+		return
+	}
+	scopes := make([]string, 0, len(list))
+	for _, s := range list {
+		if s, ok := s.(string); ok {
+			scopes = append(scopes, s)
+		}
+	}
+	realm, _ := m["realm"].(string)
+	for _, s := range scopes {
+		if v, ok := m[s].(bool); ok && v {
+			delete(m, s)
+		}
+	}
+	scopes = policy.Apply(scopes, realm)
+	for _, s := range scopes {
+		if _, exists := m[s]; !exists {
+			m[s] = true
 		}
-		w.WriteHeader(status)
-		w.Write([]byte(http.StatusText(status)))
+	}
+	m["scope"] = scopes
+	body, err := json.Marshal(m)
+	if err != nil {
 		return
 	}
+	buf.Buffer = bytes.NewBuffer(body)
+	buf.header.Del("Content-Length")
+}
 
-	t := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy", metrics.NewTimer).(metrics.Timer)
-	t.UpdateSince(start)
+// retryable returns true for failures that a new attempt might not run into: timeouts, connection
+// errors and server errors. Requests rejected by the circuit breaker are not retried
+func retryable(err error) bool {
+	return err == hystrix.ErrTimeout || err == errUpstreamFailure
+}
+
+// store caches the token info until the token expires, but never for longer than the maximum TTL.
+// Tokens that expire before the minimum TTL are not cached at all. Entries are keyed by the token hash,
+// with its variant, so that tokens are never stored in plain text and can be evicted by hash. The body is
+// copied because it belongs to a pooled buffer
+func (h *tokenInfoProxyHandler) store(key string, body []byte, now time.Time) {
+	ttl := h.cacheMaxTTL
+	if expiresIn, ok := expiresIn(body, now); ok && expiresIn < ttl {
+		ttl = expiresIn
+	}
+	if ttl <= 0 || ttl < h.cacheMinTTL {
+		incCounter("planb.tokeninfo.proxy.cache.skipped")
+		return
+	}
+	if err := h.cache.Set(key, append([]byte(nil), body...), ttl); err != nil {
+		slog.Warn("Failed to store token info in the cache", "error", err)
+		incCounter("planb.tokeninfo.proxy.cache.errors")
+	}
+}
+
+// etag returns the ETag of a response of the upstream for the cache key key, or "" if ETags are disabled or
+// the response is not a successful token info. The exp attribute is the expiry. Without one, the expires_in
+// stands for it: the body of an entry doesn't change while it is in the cache and a new entry has another one
+func (h *tokenInfoProxyHandler) etag(key string, status int, body []byte) string {
+	if !h.etags || status != http.StatusOK {
+		return ""
+	}
+	var ti struct {
+		ExpiresIn *int64 `json:"expires_in"`
+		Exp       *int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(body, &ti); err != nil {
+		return ""
+	}
+	switch {
+	case ti.Exp != nil:
+		return tokeninfo.ETag(key, *ti.Exp)
+	case ti.ExpiresIn != nil:
+		return tokeninfo.ETag(key, *ti.ExpiresIn)
+	}
+	return ""
+}
+
+// expiresIn reads the remaining lifetime of the token from the expires_in or exp attributes of the
+// token info response. It returns false if the response has none of them
+func expiresIn(body []byte, now time.Time) (time.Duration, bool) {
+	var ti struct {
+		ExpiresIn *int64 `json:"expires_in"`
+		Exp       *int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(body, &ti); err != nil {
+		return 0, false
+	}
+	switch {
+	case ti.ExpiresIn != nil:
+		return time.Duration(*ti.ExpiresIn) * time.Second, true
+	case ti.Exp != nil:
+		return time.Unix(*ti.Exp, 0).Sub(now), true
+	}
+	return 0, false
 }
 
-func hostModifier(upstreamURL *url.URL, original func(req *http.Request)) func(req *http.Request) {
+// updateCircuitState updates the gauge of the circuit breaker around the upstream token info. The ones of the
+// routes are only reported by the health endpoint
+func (h *tokenInfoProxyHandler) updateCircuitState() {
+	if h.command != ProxyCommand {
+		return
+	}
+	var open int64
+	if breaker.IsOpen(ProxyCommand) {
+		open = 1
+	}
+	if g, ok := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy.upstream.circuit.open", metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(open)
+	}
+}
+
+func hostModifier(upstreamURL *url.URL, original func(req *http.Request), headers *headerRewriter) func(req *http.Request) {
 	return func(req *http.Request) {
 		original(req)
 		req.Host = upstreamURL.Host
 		req.URL.Path = upstreamURL.Path
+		if req.Method == http.MethodPost {
+			postToGet(req)
+		}
+		headers.rewrite(req.Header)
+		setTimeout(req, time.Now())
+		tracing.Inject(req.Context(), req.Header)
+		requestid.Inject(req.Context(), req.Header)
+	}
+}
+
+// proxyError replaces the default error handler of the reverse proxy, which logs the upstream URL. It
+// can contain the Access Token in the query
+func proxyError(w http.ResponseWriter, req *http.Request, err error) {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
 	}
+	slog.Warn("Upstream tokeninfo call failed", "error", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// postToGet turns a POST Request into a GET Request with the Access Token in the Authorization header.
+// The form body was already consumed while looking for the Access Token, so it can't be forwarded
+func postToGet(req *http.Request) {
+	token := tokeninfo.AccessTokenFromRequest(req)
+	req.Method = http.MethodGet
+	req.Body = nil
+	req.ContentLength = 0
+	req.Header.Del("Content-Type")
+	req.Header.Del("Content-Length")
+	req.Header.Set("Authorization", "Bearer "+token)
 }
@@ -1,12 +1,29 @@
 package tokeninfoproxy
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/callerauth"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/processor"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+	"github.com/zalando/planb-tokeninfo/tracing"
 )
 
 const testTokenInfo = `{"access_token": "xxx","cn": "John Doe","expires_in": 42,"grant_type": "password","realm":"/services","scope":["uid","cn"],"token_type":"Bearer","uid":"jdoe"}` + "\n"
@@ -77,6 +94,182 @@ func TestHostHeader(t *testing.T) {
 	h.ServeHTTP(w, r)
 }
 
+func TestPostForm(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			t.Errorf("Received the wrong method: %s", req.Method)
+		}
+		if req.Header.Get("Authorization") != "Bearer foo" {
+			t.Errorf("Received the wrong Authorization header: %q", req.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	url, _ := url.Parse(fmt.Sprintf("http://%s/upstream-tokeninfo", server.Listener.Addr()))
+	h := NewTokenInfoProxyHandler(url, 0, 0, time.Second*1)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://example.com/oauth2/tokeninfo", strings.NewReader("access_token=foo"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != testTokenInfo {
+		t.Errorf("Wrong response for a POST request. Got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("traceparent") != traceParent {
+			t.Errorf("Trace context was not propagated to the upstream. Got %q", req.Header.Get("traceparent"))
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	url, _ := url.Parse(fmt.Sprintf("http://%s/upstream-tokeninfo", server.Listener.Addr()))
+	h := tracing.NewHandler(NewTokenInfoProxyHandler(url, 0, 0, time.Second*1), "tokeninfo")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+	r.Header.Set("traceparent", traceParent)
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header.Get("X-Request-Id")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	defer func(f []string) { options.Current().UpstreamForwardHeaders = f }(options.Current().UpstreamForwardHeaders)
+	// the request ID is forwarded even if the inbound header isn't
+	options.Current().UpstreamForwardHeaders = []string{"X-Foo"}
+
+	u, _ := url.Parse(server.URL)
+	h := requestid.NewHandler(NewTokenInfoProxyHandler(u, 0, 0, time.Second))
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+	r.Header.Set("X-Request-Id", "abc-123")
+	h.ServeHTTP(w, r)
+
+	if received != "abc-123" {
+		t.Errorf("Request ID was not propagated to the upstream. Got %q", received)
+	}
+}
+
+func TestScopePolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("Content-Length", fmt.Sprint(len(testTokenInfo)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	p, err := processor.LoadScopePolicy(strings.NewReader(`{
+		"aliases": {"cn": ["name", "email"]},
+		"realms": {"/services": ["service"]},
+		"allow": ["uid", "email", "service"]
+	}`))
+	if err != nil {
+		t.Fatal("Failed to load scope policy: ", err)
+	}
+	defer func(p *processor.ScopePolicy) { options.Current().ScopePolicy = p }(options.Current().ScopePolicy)
+	options.Current().ScopePolicy = p
+
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second)
+	want := `{"access_token":"xxx","cn":"John Doe","email":true,"expires_in":42,"grant_type":"password","realm":"/services","scope":["uid","email","service"],"service":true,"token_type":"Bearer","uid":"jdoe"}`
+	for _, wantCache := range []string{"MISS", "HIT"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+		h.ServeHTTP(w, r)
+		if w.Body.String() != want {
+			t.Errorf("Wrong response body. Wanted %s, got %s", want, w.Body.String())
+		}
+		if w.Header().Get("X-Cache") != wantCache {
+			t.Errorf("Wrong cache header. Wanted %q, got %q", wantCache, w.Header().Get("X-Cache"))
+		}
+	}
+}
+
+func TestCallerScopePolicy(t *testing.T) {
+	var upstreamCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	p, err := processor.LoadScopePolicy(strings.NewReader(`{
+		"realms": {"/services": ["service"]},
+		"callers": {"billing": {"allow": ["uid"]}}
+	}`))
+	if err != nil {
+		t.Fatal("Failed to load scope policy: ", err)
+	}
+	defer func(p *processor.ScopePolicy) { options.Current().ScopePolicy = p }(options.Current().ScopePolicy)
+	options.Current().ScopePolicy = p
+
+	u, _ := url.Parse(server.URL)
+	cache := tokencache.NewMemoryCache(10)
+	h := callerauth.NewHandler(NewTokenInfoProxyHandlerWithCache(u, cache, time.Minute, time.Second),
+		callerauth.Callers{APIKeys: map[string]string{"billing": "b", "shop": "s"}})
+	wantDefault := `"scope":["uid","cn","service"]`
+	wantBilling := `"scope":["uid"]`
+	for _, test := range []struct {
+		key       string
+		wantCache string
+		want      string
+	}{
+		{"s", "MISS", wantDefault},
+		{"b", "MISS", wantBilling},
+		{"s", "HIT", wantDefault},
+		{"b", "HIT", wantBilling},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+		r.Header.Set(callerauth.APIKeyHeader, test.key)
+		h.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("Wrong response body for %s. Wanted %s, got %s", test.key, test.want, w.Body.String())
+		}
+		if w.Header().Get("X-Cache") != test.wantCache {
+			t.Errorf("Wrong cache header for %s. Wanted %q, got %q", test.key, test.wantCache, w.Header().Get("X-Cache"))
+		}
+	}
+	if upstreamCalls != 2 {
+		t.Errorf("Every variant should be requested once from the upstream, got %d calls", upstreamCalls)
+	}
+	hash := tokeninfo.HashToken("foo")
+	for _, key := range []string{hash, hash + "/billing"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Errorf("Missing cache entry %s: %v", key, err)
+		}
+	}
+	if c, ok := metrics.DefaultRegistry.Get("planb.tokeninfo.proxy.cache.variants.billing.hits").(metrics.Counter); !ok || c.Count() == 0 {
+		t.Error("The cache hits of the billing variant should be counted")
+	}
+}
+
 func TestCache(t *testing.T) {
 	var upstream string
 	var upstreamCalls int
@@ -129,6 +322,131 @@ func TestCache(t *testing.T) {
 	}
 }
 
+type ttlRecordingCache struct {
+	tokencache.Cache
+	ttl time.Duration
+	set bool
+}
+
+func (c *ttlRecordingCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.ttl, c.set = ttl, true
+	return nil
+}
+
+func TestResponseCacheHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	defer func(d time.Duration, s string) {
+		options.Current().ResponseCacheMaxAge, options.Current().ResponseCacheDirectives = d, s
+	}(options.Current().ResponseCacheMaxAge, options.Current().ResponseCacheDirectives)
+	options.Current().ResponseCacheMaxAge, options.Current().ResponseCacheDirectives = 30*time.Second, "private"
+
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second)
+	for _, want := range []string{"private, max-age=30", "private, no-cache"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+		h.ServeHTTP(w, r)
+		if cc := w.Header().Get("Cache-Control"); cc != want {
+			t.Errorf("Wrong Cache-Control for a %s. Wanted %q, got %q", w.Header().Get("X-Cache"), want, cc)
+		}
+	}
+}
+
+func TestResponseETag(t *testing.T) {
+	var upstreamCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("ETag", `"upstream"`)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	defer func(b bool) { options.Current().ResponseETags = b }(options.Current().ResponseETags)
+	options.Current().ResponseETags = true
+
+	u, _ := url.Parse(server.URL)
+	h := tokeninfo.NewConditionalHandler(NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second))
+	get := func(token string, ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+token, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// the tag of the first response, from the upstream, is the one of the cached entry
+	etag := get("foo", "").Header().Get("ETag")
+	if etag == "" || etag == `"upstream"` {
+		t.Fatalf("Wrong ETag: %q", etag)
+	}
+	w := get("foo", etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Wrong response for a cached token. Wanted 304 without a body, got %d: %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "" {
+		t.Errorf("304 responses should not have a Content-Type, got %q", w.Header().Get("Content-Type"))
+	}
+	if w := get("bar", etag); w.Code != http.StatusOK || w.Body.String() != testTokenInfo {
+		t.Errorf("Wrong response for another token. Wanted 200, got %d", w.Code)
+	}
+	if w := get("baz", "*"); w.Code != http.StatusNotModified || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Wrong response for a matching tag on a cache miss. Wanted 304, got %d", w.Code)
+	}
+	if upstreamCalls != 3 {
+		t.Errorf("Wrong number of upstream calls. Wanted 3, got %d", upstreamCalls)
+	}
+}
+
+func TestCacheEntryTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for _, test := range []struct {
+		body    string
+		minTTL  time.Duration
+		maxTTL  time.Duration
+		want    time.Duration
+		wantSet bool
+	}{
+		{`{"expires_in":10}`, 0, time.Minute, 10 * time.Second, true},
+		{`{"expires_in":3600}`, 0, time.Minute, time.Minute, true},
+		{`{"exp":1030}`, 0, time.Minute, 30 * time.Second, true},
+		{`{"uid":"foo"}`, 0, time.Minute, time.Minute, true},
+		{`{"expires_in":3}`, 5 * time.Second, time.Minute, 0, false},
+		{`{"expires_in":0}`, 0, time.Minute, 0, false},
+		{`{"exp":900}`, 0, time.Minute, 0, false},
+	} {
+		c := &ttlRecordingCache{}
+		h := &tokenInfoProxyHandler{cache: c, cacheMinTTL: test.minTTL, cacheMaxTTL: test.maxTTL}
+		h.store(tokeninfo.HashToken("foo"), []byte(test.body), now)
+
+		if c.set != test.wantSet || c.ttl != test.want {
+			t.Errorf("Wrong cache TTL for %s. Wanted %v (%t), got %v (%t)", test.body, test.want, test.wantSet, c.ttl, c.set)
+		}
+	}
+}
+
+func TestCacheMaxTTLDefault(t *testing.T) {
+	u, _ := url.Parse("http://example.com")
+	h := NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second).(*tokenInfoProxyHandler)
+	if h.cacheMaxTTL != time.Minute {
+		t.Errorf("Maximum TTL should default to the cache TTL, got %v", h.cacheMaxTTL)
+	}
+
+	defer func(d time.Duration) { options.Current().UpstreamCacheMaxTTL = d }(options.Current().UpstreamCacheMaxTTL)
+	options.Current().UpstreamCacheMaxTTL = time.Hour
+	h = NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second).(*tokenInfoProxyHandler)
+	if h.cacheMaxTTL != time.Hour {
+		t.Errorf("Wrong maximum TTL. Wanted %v, got %v", time.Hour, h.cacheMaxTTL)
+	}
+}
+
 func TestCacheDisabled(t *testing.T) {
 	var upstream string
 	var counter int
@@ -177,6 +495,56 @@ func TestCacheDisabled(t *testing.T) {
 	}
 }
 
+func TestMaxBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if req.URL.Query().Get("access_token") == "big" {
+			w.Write(bytes.Repeat([]byte(" "), 2048))
+		}
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	defer func(i int64) { options.Current().UpstreamMaxBodySize = i }(options.Current().UpstreamMaxBodySize)
+	options.Current().UpstreamMaxBodySize = 1024
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second)
+	for _, test := range []struct {
+		token    string
+		wantCode int
+	}{
+		{"big", http.StatusBadGateway},
+		{"small", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+test.token, nil)
+		h.ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s response. Wanted %d, got %d", test.token, test.wantCode, w.Code)
+		}
+	}
+}
+
+func TestPooledBuffers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"expires_in":42,"uid":%q}`, req.URL.Query().Get("access_token"))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandler(u, 10, time.Minute, time.Second)
+	// cached responses must not be overwritten by later responses that reuse the same buffer
+	for _, token := range []string{"foo", "bar", "baz", "foo", "bar"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+token, nil)
+		h.ServeHTTP(w, r)
+		if want := fmt.Sprintf(`{"expires_in":42,"uid":%q}`, token); w.Body.String() != want {
+			t.Errorf("Wrong response body for %s (%s). Wanted %s, got %s", token, w.Header().Get("X-Cache"), want, w.Body.String())
+		}
+	}
+}
+
 func TestUpstreamTimeout(t *testing.T) {
 	handler := func(w http.ResponseWriter, req *http.Request) {
 		time.Sleep(10 * time.Millisecond)
@@ -198,3 +566,135 @@ func TestUpstreamTimeout(t *testing.T) {
 		t.Errorf("Response code should be 504 Gateway Timeout but was %d %s instead", w.Code, http.StatusText(w.Code))
 	}
 }
+
+func TestCoalescing(t *testing.T) {
+	var upstreamCalls int32
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	url, _ := url.Parse(fmt.Sprintf("http://%s", server.Listener.Addr()))
+	h := NewTokenInfoProxyHandler(url, 0, 0, time.Second*1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusOK, w.Code)
+			}
+
+			if w.Body.String() != testTokenInfo {
+				t.Errorf("Wrong response body. Wanted %q, got %s", testTokenInfo, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&upstreamCalls); calls != 1 {
+		t.Errorf("Concurrent requests for the same token should share one upstream call, but we got %d calls", calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer hystrix.Flush()
+	defer func(volume int) { options.Current().UpstreamCircuitRequestVolume = volume }(options.Current().UpstreamCircuitRequestVolume)
+	options.Current().UpstreamCircuitRequestVolume = 3
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	url, _ := url.Parse(fmt.Sprintf("http://%s", server.Listener.Addr()))
+	h := NewTokenInfoProxyHandler(url, 0, 0, time.Second*1)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/oauth2/tokeninfo?access_token=foo%d", i), nil)
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Upstream response code should be passed through but was %d %s instead", w.Code, http.StatusText(w.Code))
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=bar", nil)
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Response code should be 503 Service Unavailable with an open circuit but was %d %s instead", w.Code, http.StatusText(w.Code))
+	}
+}
+
+func TestRouteCircuitBreaker(t *testing.T) {
+	defer hystrix.Flush()
+	defer func(volume int) { options.Current().UpstreamCircuitRequestVolume = volume }(options.Current().UpstreamCircuitRequestVolume)
+	options.Current().UpstreamCircuitRequestVolume = 3
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer healthy.Close()
+
+	failingURL, _ := url.Parse(failing.URL)
+	healthyURL, _ := url.Parse(healthy.URL)
+	route := NewTokenInfoProxyHandlerForRoute(failing.URL, failingURL, tokencache.NewMemoryCache(10), 0, time.Second)
+	h := NewTokenInfoProxyHandlerWithCache(healthyURL, tokencache.NewMemoryCache(10), 0, time.Second)
+
+	for i := 0; i < 3; i++ {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/oauth2/tokeninfo?access_token=foo%d", i), nil)
+		route.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=bar", nil)
+	route.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("The circuit of the failing route should be open, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("The circuit of the upstream should stay closed when a route fails, got %d", w.Code)
+	}
+}
+
+func TestProxyErrorLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=secret", nil)
+	proxyError(w, r, &url.Error{Op: "Get", URL: r.URL.String(), Err: errors.New("connection refused")})
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if strings.Contains(buf.String(), "secret") || !strings.Contains(buf.String(), "connection refused") {
+		t.Errorf("The error log should contain the error without the token: %q", buf.String())
+	}
+}
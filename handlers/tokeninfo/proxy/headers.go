@@ -0,0 +1,52 @@
+package tokeninfoproxy
+
+import "net/http"
+
+// headerRewriter changes the headers of the requests to the upstream: only the allowed inbound headers
+// are forwarded and static headers, for ex. an API key of the upstream, are added
+type headerRewriter struct {
+	forward map[string]bool
+	static  http.Header
+}
+
+// newHeaderRewriter returns a rewriter that forwards only the inbound headers in forward, or all of them if
+// forward is empty, and sets the static headers. It returns nil if there is nothing to change
+func newHeaderRewriter(forward []string, static map[string]string) *headerRewriter {
+	if len(forward) == 0 && len(static) == 0 {
+		return nil
+	}
+	hr := &headerRewriter{static: make(http.Header)}
+	if len(forward) > 0 {
+		// the Access Token is sent in the Authorization header of POST requests
+		hr.forward = map[string]bool{"Authorization": true}
+		for _, name := range forward {
+			hr.forward[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	for name, value := range static {
+		hr.static.Set(name, value)
+	}
+	return hr
+}
+
+// rewrite removes the headers that aren't forwarded and sets the static headers. A nil rewriter leaves the
+// headers unchanged
+func (hr *headerRewriter) rewrite(h http.Header) {
+	if hr == nil {
+		return
+	}
+	if hr.forward != nil {
+		for name := range h {
+			if !hr.forward[name] {
+				delete(h, name)
+			}
+		}
+		// the reverse proxy adds the client address unless the header is present with a nil value
+		if !hr.forward["X-Forwarded-For"] {
+			h["X-Forwarded-For"] = nil
+		}
+	}
+	for name, values := range hr.static {
+		h[name] = values
+	}
+}
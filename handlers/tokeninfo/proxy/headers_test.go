@@ -0,0 +1,61 @@
+package tokeninfoproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+func TestHeaderRewriter(t *testing.T) {
+	for _, test := range []struct {
+		forward []string
+		static  map[string]string
+		want    http.Header
+	}{
+		{nil, nil, http.Header{"Authorization": {"Bearer foo"}, "X-Request-Id": {"42"}, "Cookie": {"a=b"}}},
+		{[]string{"x-request-id"}, nil, http.Header{"Authorization": {"Bearer foo"}, "X-Request-Id": {"42"}, "X-Forwarded-For": nil}},
+		{[]string{"X-Forwarded-For"}, nil, http.Header{"Authorization": {"Bearer foo"}}},
+		{nil, map[string]string{"x-api-key": "secret"}, http.Header{"Authorization": {"Bearer foo"}, "X-Request-Id": {"42"}, "Cookie": {"a=b"}, "X-Api-Key": {"secret"}}},
+		{[]string{"Cookie"}, map[string]string{"Cookie": "c=d"}, http.Header{"Authorization": {"Bearer foo"}, "Cookie": {"c=d"}, "X-Forwarded-For": nil}},
+	} {
+		h := http.Header{"Authorization": {"Bearer foo"}, "X-Request-Id": {"42"}, "Cookie": {"a=b"}}
+		newHeaderRewriter(test.forward, test.static).rewrite(h)
+		if !reflect.DeepEqual(h, test.want) {
+			t.Errorf("Wrong headers with forward %v and static %v. Wanted %v, got %v", test.forward, test.static, test.want, h)
+		}
+	}
+}
+
+func TestUpstreamHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	defer func(f []string, s map[string]string) {
+		options.Current().UpstreamForwardHeaders, options.Current().UpstreamHeaders = f, s
+	}(options.Current().UpstreamForwardHeaders, options.Current().UpstreamHeaders)
+	options.Current().UpstreamForwardHeaders = []string{"X-Request-Id"}
+	options.Current().UpstreamHeaders = map[string]string{"X-Api-Key": "secret"}
+
+	u, _ := url.Parse(server.URL)
+	h := NewTokenInfoProxyHandler(u, 0, 0, time.Second)
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+	r.Header.Set("X-Request-Id", "42")
+	r.Header.Set("Cookie", "a=b")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	for name, want := range map[string]string{"X-Request-Id": "42", "X-Api-Key": "secret", "Cookie": "", "X-Forwarded-For": ""} {
+		if v := received.Get(name); v != want {
+			t.Errorf("Wrong upstream header %s. Wanted %q, got %q", name, want, v)
+		}
+	}
+}
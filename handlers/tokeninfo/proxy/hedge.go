@@ -0,0 +1,71 @@
+package tokeninfoproxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+type attemptResult struct {
+	rw     *responseBuffer
+	err    error
+	hedged bool
+}
+
+// hedgedAttempt calls the upstream u and, when hedging is enabled and u didn't answer within the hedge
+// delay, a second upstream of upstreams as well. The first successful response is used; if both calls fail the error
+// of the last one is returned. Hedged calls are paid from the retry budget, so they can't multiply the
+// load on a slow upstream
+func (h *tokenInfoProxyHandler) hedgedAttempt(upstreams *balancer, u *upstream, req *http.Request, token string) (*responseBuffer, error) {
+	if h.hedgeDelay <= 0 {
+		return h.reportedAttempt(upstreams, u, req, token)
+	}
+
+	results := make(chan attemptResult, 2)
+	call := func(u *upstream, hedged bool) {
+		rw, err := h.reportedAttempt(upstreams, u, req, token)
+		results <- attemptResult{rw: rw, err: err, hedged: hedged}
+	}
+	go call(u, false)
+
+	timer := time.NewTimer(h.hedgeDelay)
+	defer timer.Stop()
+	hedge := timer.C
+	pending := 1
+	var last attemptResult
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.hedged {
+					incCounter("planb.tokeninfo.proxy.upstream.hedges.won")
+				}
+				return r.rw, nil
+			}
+			last = r
+		case <-hedge:
+			hedge = nil
+			if !h.budget.withdraw() {
+				incCounter("planb.tokeninfo.proxy.upstream.hedges.exhausted")
+				continue
+			}
+			incCounter("planb.tokeninfo.proxy.upstream.hedges")
+			pending++
+			go call(upstreams.pick(u, time.Now()), true)
+		}
+	}
+	return last.rw, last.err
+}
+
+// reportedAttempt makes a single call to the upstream u and reports the result to upstreams, its balancer. Calls
+// rejected by the circuit breaker or cut short by the deadline of the client don't say anything about the
+// health of u
+func (h *tokenInfoProxyHandler) reportedAttempt(upstreams *balancer, u *upstream, req *http.Request, token string) (*responseBuffer, error) {
+	rw, err := h.upstreamAttempt(u, req, token)
+	if err != hystrix.ErrCircuitOpen && err != hystrix.ErrMaxConcurrency && err != errDeadlineExceeded {
+		upstreams.report(u, err == nil, time.Now())
+	}
+	return rw, err
+}
@@ -0,0 +1,63 @@
+package tokeninfoproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+func TestHedging(t *testing.T) {
+	defer hystrix.Flush()
+	var slowCalls, fastCalls int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&slowCalls, 1)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fastCalls, 1)
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer fast.Close()
+	slowURL, _ := url.Parse(slow.URL)
+	fastURL, _ := url.Parse(fast.URL)
+
+	for _, test := range []struct {
+		delay         time.Duration
+		budget        float64
+		wantFastCalls int32
+		wantMax       time.Duration
+	}{
+		{0, retryBudgetMax, 0, time.Second},
+		{20 * time.Millisecond, retryBudgetMax, 1, 200 * time.Millisecond},
+		{20 * time.Millisecond, 0, 0, time.Second},
+	} {
+		slowCalls, fastCalls = 0, 0
+		h := NewTokenInfoProxyHandlerWithUpstreams([]*url.URL{slowURL, fastURL}, nil, 0, time.Second).(*tokenInfoProxyHandler)
+		h.hedgeDelay = test.delay
+		h.budget.balance = test.budget
+
+		start := time.Now()
+		r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=foo", nil)
+		rw, err := h.upstreamRequest(r, "foo")
+		d := time.Since(start)
+
+		if err != nil || rw.StatusCode != http.StatusOK {
+			t.Errorf("Hedged call with delay %v failed: %v", test.delay, err)
+		}
+		if s, f := atomic.LoadInt32(&slowCalls), atomic.LoadInt32(&fastCalls); s != 1 || f != test.wantFastCalls {
+			t.Errorf("Wrong calls with hedge delay %v. Wanted 1 slow and %d fast calls, got %d and %d",
+				test.delay, test.wantFastCalls, s, f)
+		}
+		if d > test.wantMax {
+			t.Errorf("Hedged call with delay %v took too long: %v", test.delay, d)
+		}
+		hystrix.Flush()
+	}
+}
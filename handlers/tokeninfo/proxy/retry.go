@@ -0,0 +1,55 @@
+package tokeninfoproxy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// retryBudgetMax is the maximum number of retries that can be saved up while the upstream is healthy
+const retryBudgetMax = 10
+
+var sleep = time.Sleep
+
+// retryBudget limits the retries to a ratio of the upstream requests, so that retries can't multiply
+// the load on an upstream that is already failing. Every request deposits ratio and every retry
+// withdraws 1 from the balance
+type retryBudget struct {
+	mu      sync.Mutex
+	ratio   float64
+	balance float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, balance: retryBudgetMax}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balance += b.ratio
+	if b.balance > retryBudgetMax {
+		b.balance = retryBudgetMax
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
+
+// backoff returns the delay before the retry after attempt (starting at 0). The delay doubles with
+// every attempt and is randomized between half and the full value to spread retries of many requests
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,126 @@
+package tokeninfoproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+func TestRetries(t *testing.T) {
+	defer hystrix.Flush()
+	defer func(f func(time.Duration)) { sleep = f }(sleep)
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	var calls, failures int
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(testTokenInfo))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	u, _ := url.Parse(fmt.Sprintf("http://%s", server.Listener.Addr()))
+
+	for _, test := range []struct {
+		retries   int
+		budget    float64
+		failures  int
+		wantCode  int
+		wantCalls int
+	}{
+		{0, 0.1, 1, http.StatusBadGateway, 1},
+		{2, 0.1, 1, http.StatusOK, 2},
+		{2, 0.1, 2, http.StatusOK, 3},
+		{2, 0.1, 3, http.StatusBadGateway, 3},
+	} {
+		calls, failures, slept = 0, test.failures, nil
+		h := NewTokenInfoProxyHandler(u, 0, 0, time.Second).(*tokenInfoProxyHandler)
+		h.retries = test.retries
+		h.backoff = 10 * time.Millisecond
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=foo", nil)
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.wantCode || calls != test.wantCalls {
+			t.Errorf("Wrong result with %d retries and %d failures. Wanted %d after %d calls, got %d after %d calls",
+				test.retries, test.failures, test.wantCode, test.wantCalls, w.Code, calls)
+		}
+		if len(slept) != test.wantCalls-1 {
+			t.Errorf("Wrong number of backoffs. Wanted %d, got %v", test.wantCalls-1, slept)
+		}
+		hystrix.Flush()
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.5)
+	for i := 0; i < retryBudgetMax; i++ {
+		if !b.withdraw() {
+			t.Fatalf("Retry %d should be within the initial budget", i)
+		}
+	}
+	if b.withdraw() {
+		t.Error("Budget should be exhausted")
+	}
+	b.deposit()
+	if b.withdraw() {
+		t.Error("Half a retry should not be enough")
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Error("Two requests should allow one retry")
+	}
+	for i := 0; i < 100; i++ {
+		b.deposit()
+	}
+	if b.balance != retryBudgetMax {
+		t.Errorf("Budget should be capped at %d, got %v", retryBudgetMax, b.balance)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, test := range []struct {
+		base    time.Duration
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{0, 3, 0, 0},
+		{10 * time.Millisecond, 0, 5 * time.Millisecond, 10 * time.Millisecond},
+		{10 * time.Millisecond, 1, 10 * time.Millisecond, 20 * time.Millisecond},
+		{10 * time.Millisecond, 3, 40 * time.Millisecond, 80 * time.Millisecond},
+	} {
+		for i := 0; i < 10; i++ {
+			if d := backoff(test.base, test.attempt); d < test.min || d > test.max {
+				t.Errorf("Backoff for attempt %d with base %v out of range: %v", test.attempt, test.base, d)
+			}
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{hystrix.ErrTimeout, true},
+		{errUpstreamFailure, true},
+		{hystrix.ErrCircuitOpen, false},
+		{hystrix.ErrMaxConcurrency, false},
+	} {
+		if retryable(test.err) != test.want {
+			t.Errorf("Wrong retryable result for %v. Wanted %t", test.err, test.want)
+		}
+	}
+}
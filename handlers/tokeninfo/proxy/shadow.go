@@ -0,0 +1,59 @@
+package tokeninfoproxy
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/processor"
+)
+
+// shadow duplicates a percentage of the upstream calls to a candidate upstream, for ex. a new token info
+// backend before the cutover. The responses of the candidate are compared with the ones of the upstream
+// and the differences are counted, they are never sent to the clients
+type shadow struct {
+	candidate  *upstream
+	percentage int
+	comparer   *tokeninfo.Comparer
+}
+
+// newShadow returns a shadow for the candidate URL. The candidate is called like the upstreams, with
+// their headers and protocol
+func newShadow(candidateURL *url.URL, percentage int, timeout time.Duration, h2c bool, headers *headerRewriter) *shadow {
+	candidate := newBalancer([]*url.URL{candidateURL}, BalancingRoundRobin, 0, h2c, headers).upstreams[0]
+	candidate.proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		// timeouts are counted by mirror
+		if req.Context().Err() == nil {
+			incCounter("planb.tokeninfo.proxy.shadow.errors")
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return &shadow{candidate: candidate, percentage: percentage, comparer: tokeninfo.NewComparer("planb.tokeninfo.proxy.shadow", timeout)}
+}
+
+// sample tells whether the current call is duplicated to the candidate
+func (s *shadow) sample() bool {
+	return s != nil && rand.Intn(100) < s.percentage
+}
+
+// mirror calls the candidate in the background with a copy of req and compares its response with the
+// response of the upstream, status and body. The candidate response goes through the same validation and
+// scope policy as the upstream one
+func (s *shadow) mirror(req *http.Request, status int, body []byte, validator *responseValidator, policy *processor.ScopePolicy) {
+	s.comparer.Compare(req, status, body, func(r *http.Request) (int, []byte) {
+		buf := newResponseBuffer(0)
+		defer buf.release()
+		s.candidate.proxy.ServeHTTP(buf, r)
+		if buf.StatusCode == http.StatusOK && validator != nil {
+			if err := validator.sanitize(buf); err != nil {
+				buf.StatusCode = http.StatusBadGateway
+			}
+		}
+		if buf.StatusCode == http.StatusOK && policy != nil {
+			applyScopePolicy(buf, policy)
+		}
+		return buf.StatusCode, append([]byte(nil), buf.Buffer.Bytes()...)
+	})
+}
@@ -0,0 +1,46 @@
+package tokeninfoproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestShadow(t *testing.T) {
+	defer hystrix.Flush()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer primary.Close()
+	candidate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(strings.Replace(testTokenInfo, `"uid":"jdoe"`, `"uid":"john.doe"`, 1)))
+	}))
+	defer candidate.Close()
+	primaryURL, _ := url.Parse(primary.URL)
+	candidateURL, _ := url.Parse(candidate.URL)
+
+	h := NewTokenInfoProxyHandlerWithUpstreams([]*url.URL{primaryURL}, nil, 0, time.Second).(*tokenInfoProxyHandler)
+	h.shadow = newShadow(candidateURL, 100, time.Second, false, nil)
+	mismatches := metrics.GetOrRegisterCounter("planb.tokeninfo.proxy.shadow.mismatches.field.uid", metrics.DefaultRegistry)
+	before := mismatches.Count()
+
+	r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=foo", nil)
+	rw, err := h.upstreamRequest(r, "foo")
+	if err != nil || rw.StatusCode != http.StatusOK {
+		t.Fatalf("Shadowed call failed: %v", err)
+	}
+	if rw.Buffer.String() != testTokenInfo {
+		t.Errorf("The client got the response of the candidate: %s", rw.Buffer.String())
+	}
+	for deadline := time.Now().Add(time.Second); mismatches.Count() == before; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The mismatch of the uid was not counted")
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package tokeninfoproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/latency"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// hopHeaders only apply to a single connection and are not forwarded by proxies, see RFC 9110, section 7.6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// streamUpstream sends the Request to the upstream and copies the response body to w while it's read, instead
+// of buffering it. Only the round trip until the response headers is guarded by the circuit breaker and
+// retried; once the body is being sent nothing can be changed. The whole call, body included, must finish
+// within the upstream timeout. Streamed calls are neither hedged nor coalesced
+func (h *tokenInfoProxyHandler) streamUpstream(w http.ResponseWriter, req *http.Request, token string) error {
+	ctx, span := tracing.Start(req.Context(), "proxy.upstream", attribute.String("token.hash", tokeninfo.HashPrefix(token)))
+	defer span.End()
+	ctx = context.WithValue(ctx, timeoutKey{}, h.timeout)
+	now := time.Now()
+	deadline, clientBound := clientDeadline(req, now)
+	if clientBound && !now.Before(deadline) {
+		return errDeadlineExceeded
+	}
+	if !clientBound || now.Add(h.timeout).Before(deadline) {
+		deadline, clientBound = now.Add(h.timeout), false
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	h.budget.deposit()
+	upstreams, backend := h.route(req)
+	start := time.Now()
+	var res *http.Response
+	var err error
+	var u *upstream
+	for attempt := 0; ; attempt++ {
+		u = upstreams.pick(u, time.Now())
+		res, err = h.streamAttempt(u, req, clientBound)
+		if err != hystrix.ErrCircuitOpen && err != hystrix.ErrMaxConcurrency && err != errDeadlineExceeded {
+			upstreams.report(u, err == nil, time.Now())
+		}
+		if !retryable(err) || attempt >= h.retries {
+			break
+		}
+		if !h.budget.withdraw() {
+			incCounter("planb.tokeninfo.proxy.upstream.retries.exhausted")
+			break
+		}
+		incCounter("planb.tokeninfo.proxy.upstream.retries")
+		if res != nil {
+			res.Body.Close()
+		}
+		sleep(backoff(h.backoff, attempt))
+	}
+
+	observeBackend(backend, start, err != nil)
+	if err != nil {
+		tracing.Fail(span, err)
+		if err != errUpstreamFailure {
+			return err
+		}
+	}
+	if res == nil {
+		// the upstream couldn't be reached, like the error handler of the reverse proxy
+		w.WriteHeader(http.StatusBadGateway)
+		return nil
+	}
+	defer res.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if h.maxBody > 0 && res.ContentLength > h.maxBody {
+		slog.Warn("Upstream tokeninfo response is too big", "max_bytes", h.maxBody)
+		incCounter("planb.tokeninfo.proxy.upstream.toolarge")
+		w.WriteHeader(http.StatusBadGateway)
+		return nil
+	}
+
+	removeHopHeaders(res.Header)
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "MISS")
+	if id := requestid.FromContext(req.Context()); id != "" {
+		w.Header().Set(requestid.Header, id)
+	}
+	// the expiry of the token is in the body, which is not parsed
+	if res.StatusCode == http.StatusOK && h.policy.Enabled() {
+		h.policy.SetNoCache(w.Header())
+	}
+	w.WriteHeader(res.StatusCode)
+
+	var body io.Reader = res.Body
+	if h.maxBody > 0 {
+		body = io.LimitReader(res.Body, h.maxBody)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		slog.Warn("Failed to stream the upstream tokeninfo response", "error", err)
+		// the client must not take the partial response for a complete one
+		panic(http.ErrAbortHandler)
+	}
+	if h.maxBody > 0 {
+		if n, _ := io.ReadFull(res.Body, make([]byte, 1)); n > 0 {
+			slog.Warn("Upstream tokeninfo response is too big", "max_bytes", h.maxBody)
+			incCounter("planb.tokeninfo.proxy.upstream.toolarge")
+			panic(http.ErrAbortHandler)
+		}
+	}
+	return nil
+}
+
+// streamAttempt makes a single round trip to the upstream u guarded by the circuit breaker. The response is
+// returned with an unread body, also for upstream server errors together with errUpstreamFailure. A nil
+// response with errUpstreamFailure means that the upstream couldn't be reached
+func (h *tokenInfoProxyHandler) streamAttempt(u *upstream, req *http.Request, clientBound bool) (*http.Response, error) {
+	// the command keeps running after a timeout, so its response is only read once it finished
+	result := make(chan *http.Response, 1)
+	err := hystrix.Do(h.command, func() error {
+		upstreamStart := time.Now()
+		stop := h.watchSlow(u)
+		res, err := u.roundTrip(req)
+		stop()
+		if err != nil {
+			result <- nil
+			if errors.Is(req.Context().Err(), context.DeadlineExceeded) {
+				return nil
+			}
+			if ue, ok := err.(*url.Error); ok {
+				err = ue.Err
+			}
+			slog.Warn("Upstream tokeninfo call failed", "error", err)
+			return errUpstreamFailure
+		}
+		upstreamTimer := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.proxy.upstream", metrics.NewTimer).(metrics.Timer)
+		upstreamTimer.UpdateSince(upstreamStart)
+		latency.Observe("planb.tokeninfo.proxy.upstream.latency", upstreamStart)
+		result <- res
+		if res.StatusCode >= http.StatusInternalServerError {
+			return errUpstreamFailure
+		}
+		return nil
+	}, nil)
+	if err != nil && err != errUpstreamFailure {
+		go func() {
+			if res := <-result; res != nil {
+				res.Body.Close()
+			}
+		}()
+		return nil, err
+	}
+	res := <-result
+	if res == nil && err == nil {
+		// the deadline passed while waiting for the upstream
+		if clientBound {
+			return nil, errDeadlineExceeded
+		}
+		return nil, hystrix.ErrTimeout
+	}
+	return res, err
+}
+
+// roundTrip sends the Request to the upstream the same way as the reverse proxy, but returns the response
+// instead of copying it
+func (u *upstream) roundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.RequestURI = ""
+	out.Close = false
+	u.proxy.Director(out)
+	removeHopHeaders(out.Header)
+	// like the reverse proxy, the client address is added unless the header is present with a nil value
+	if prior, ok := out.Header["X-Forwarded-For"]; !ok || prior != nil {
+		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			out.Header.Set("X-Forwarded-For", strings.Join(append(prior, ip), ", "))
+		}
+	}
+	return u.proxy.Transport.RoundTrip(out)
+}
+
+// removeHopHeaders removes the hop-by-hop headers, including the ones named in the Connection header
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,151 @@
+package tokeninfoproxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+func streamingHandler(u *url.URL, timeout time.Duration) http.Handler {
+	defer func(b bool) { options.Current().UpstreamStreaming = b }(options.Current().UpstreamStreaming)
+	options.Current().UpstreamStreaming = true
+	return NewTokenInfoProxyHandlerWithCache(u, tokencache.NewMemoryCache(10), 0, timeout)
+}
+
+func TestStreaming(t *testing.T) {
+	defer hystrix.Flush()
+	var calls int32
+	var forwardedFor string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		forwardedFor = req.Header.Get("X-Forwarded-For")
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		w.Header().Set("X-Request-Id", "upstream")
+		w.Write([]byte(testTokenInfo))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	h := requestid.NewHandler(streamingHandler(u, time.Second))
+	for i := 1; i <= 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+		r.Header.Set("X-Request-Id", "abc-123")
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK || w.Body.String() != testTokenInfo {
+			t.Errorf("Wrong streamed response. Got %d %q", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json;charset=UTF-8" {
+			t.Errorf("The upstream headers should be sent, got Content-Type %q", got)
+		}
+		if got := w.Header().Get("X-Cache"); got != "MISS" {
+			t.Errorf("Wrong cache header. Wanted MISS, got %q", got)
+		}
+		if got := w.Header().Values("X-Request-Id"); len(got) != 1 || got[0] != "abc-123" {
+			t.Errorf("The request ID of the client should be sent back, got %v", got)
+		}
+		if w.Header().Get("X-Hop") != "" {
+			t.Error("Hop-by-hop headers of the upstream should not be sent")
+		}
+		if got := atomic.LoadInt32(&calls); got != int32(i) {
+			t.Errorf("Streamed responses should not be cached. Wanted %d upstream calls, got %d", i, got)
+		}
+	}
+	if forwardedFor != "192.0.2.1" {
+		t.Errorf("The client address should be forwarded, got %q", forwardedFor)
+	}
+}
+
+func TestStreamingMaxBodySize(t *testing.T) {
+	defer hystrix.Flush()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body := append(bytes.Repeat([]byte(" "), 2048), testTokenInfo...)
+		switch req.URL.Query().Get("access_token") {
+		case "big":
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		case "small":
+			body = []byte(testTokenInfo)
+		case "chunked":
+			// flushing before the end of the body sends it without a Content-Length
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	defer func(i int64) { options.Current().UpstreamMaxBodySize = i }(options.Current().UpstreamMaxBodySize)
+	options.Current().UpstreamMaxBodySize = 1024
+	u, _ := url.Parse(server.URL)
+	h := streamingHandler(u, time.Second)
+	for _, test := range []struct {
+		token     string
+		wantCode  int
+		wantAbort bool
+	}{
+		{"big", http.StatusBadGateway, false},
+		{"small", http.StatusOK, false},
+		{"chunked", http.StatusOK, true},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+test.token, nil)
+		aborted := func() (aborted bool) {
+			defer func() { aborted = recover() == http.ErrAbortHandler }()
+			h.ServeHTTP(w, r)
+			return false
+		}()
+		if w.Code != test.wantCode || aborted != test.wantAbort {
+			t.Errorf("Wrong response for %s. Wanted %d (aborted %v), got %d (aborted %v)", test.token, test.wantCode, test.wantAbort, w.Code, aborted)
+		}
+		if aborted && int64(w.Body.Len()) > options.Current().UpstreamMaxBodySize {
+			t.Errorf("No more than the maximum body size should be sent, got %d bytes", w.Body.Len())
+		}
+	}
+}
+
+func TestStreamingUpstreamErrors(t *testing.T) {
+	defer hystrix.Flush()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("access_token") == "slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("maintenance"))
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	u, _ := url.Parse(server.URL)
+	d, _ := url.Parse(down.URL)
+	for _, test := range []struct {
+		upstream *url.URL
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{u, "foo", http.StatusServiceUnavailable, "maintenance"},
+		{u, "slow", http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout)},
+		{d, "foo", http.StatusBadGateway, ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token="+test.token, nil)
+		streamingHandler(test.upstream, 50*time.Millisecond).ServeHTTP(w, r)
+		if w.Code != test.wantCode || w.Body.String() != test.wantBody {
+			t.Errorf("Wrong response for %s. Wanted %d %q, got %d %q", test.token, test.wantCode, test.wantBody, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package tokeninfoproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// errInvalidResponse is returned when a successful upstream response is not a valid token info
+var errInvalidResponse = errors.New("Invalid upstream token info response")
+
+// requiredFields are the attributes every upstream token info response must have. They are always allowed
+var requiredFields = []string{"expires_in", "scope", "uid"}
+
+// responseValidator checks successful upstream responses before they are cached, so that error pages
+// returned with status 200 are never served to the clients
+type responseValidator struct {
+	allowed map[string]bool
+}
+
+// newResponseValidator returns a validator that only keeps the given attributes, besides the required
+// ones and the boolean attributes of the scopes. All attributes are kept if fields is empty
+func newResponseValidator(fields []string) *responseValidator {
+	v := &responseValidator{}
+	if len(fields) > 0 {
+		v.allowed = make(map[string]bool)
+		for _, f := range append(fields, requiredFields...) {
+			v.allowed[f] = true
+		}
+	}
+	return v
+}
+
+// sanitize parses the body of the response and checks the required attributes. Attributes that aren't
+// allowed are removed and the body is encoded again with a normalized Content-Type
+func (v *responseValidator) sanitize(buf *responseBuffer) error {
+	var m map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(buf.Buffer.Bytes()))
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidResponse, err)
+	}
+	if _, ok := m["expires_in"].(json.Number); !ok {
+		return fmt.Errorf("%w: expires_in is not a number", errInvalidResponse)
+	}
+	if uid, ok := m["uid"].(string); !ok || uid == "" {
+		return fmt.Errorf("%w: uid is not a string", errInvalidResponse)
+	}
+	list, ok := m["scope"].([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: scope is not a list", errInvalidResponse)
+	}
+	scopes := make(map[string]bool, len(list))
+	for _, s := range list {
+		s, ok := s.(string)
+		if !ok {
+			return fmt.Errorf("%w: scope is not a list of strings", errInvalidResponse)
+		}
+		scopes[s] = true
+	}
+
+	if v.allowed != nil {
+		for k, val := range m {
+			if _, isBool := val.(bool); v.allowed[k] || (scopes[k] && isBool) {
+				continue
+			}
+			delete(m, k)
+		}
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidResponse, err)
+	}
+	buf.Buffer = bytes.NewBuffer(body)
+	buf.header.Set("Content-Type", "application/json;charset=UTF-8")
+	buf.header.Del("Content-Length")
+	return nil
+}
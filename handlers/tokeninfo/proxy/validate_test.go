@@ -0,0 +1,89 @@
+package tokeninfoproxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+func TestResponseValidator(t *testing.T) {
+	for _, test := range []struct {
+		fields   []string
+		body     string
+		wantErr  bool
+		wantBody string
+	}{
+		{nil, testTokenInfo, false,
+			`{"access_token":"xxx","cn":"John Doe","expires_in":42,"grant_type":"password","realm":"/services","scope":["uid","cn"],"token_type":"Bearer","uid":"jdoe"}`},
+		{[]string{"realm"}, testTokenInfo, false, `{"expires_in":42,"realm":"/services","scope":["uid","cn"],"uid":"jdoe"}`},
+		{[]string{"realm"}, `{"expires_in":42,"scope":["uid","cn"],"uid":"jdoe","cn":true,"foo":true}`, false,
+			`{"cn":true,"expires_in":42,"scope":["uid","cn"],"uid":"jdoe"}`},
+		{nil, `{"expires_in":12345678901234567890,"scope":[],"uid":"jdoe"}`, false, `{"expires_in":12345678901234567890,"scope":[],"uid":"jdoe"}`},
+		{nil, "<html><body>Internal Server Error</body></html>", true, ""},
+		{nil, `{"scope":["uid"],"uid":"jdoe"}`, true, ""},
+		{nil, `{"expires_in":"42","scope":["uid"],"uid":"jdoe"}`, true, ""},
+		{nil, `{"expires_in":42,"scope":["uid"]}`, true, ""},
+		{nil, `{"expires_in":42,"scope":"uid","uid":"jdoe"}`, true, ""},
+		{nil, `{"expires_in":42,"scope":["uid",1],"uid":"jdoe"}`, true, ""},
+	} {
+		buf := newResponseBuffer(0)
+		buf.header.Set("Content-Type", "text/html")
+		buf.header.Set("Content-Length", "42")
+		buf.Write([]byte(test.body))
+		err := newResponseValidator(test.fields).sanitize(buf)
+		if test.wantErr {
+			if !errors.Is(err, errInvalidResponse) {
+				t.Errorf("Wanted an invalid response error for %s, got %v", test.body, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.body, err)
+			continue
+		}
+		if buf.Buffer.String() != test.wantBody {
+			t.Errorf("Wrong body with fields %v. Wanted %s, got %s", test.fields, test.wantBody, buf.Buffer.String())
+		}
+		if ct := buf.header.Get("Content-Type"); ct != "application/json;charset=UTF-8" {
+			t.Errorf("Wrong content type: %q", ct)
+		}
+		if buf.header.Get("Content-Length") != "" {
+			t.Error("Content-Length of the original body should be removed")
+		}
+	}
+}
+
+func TestInvalidUpstreamResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("<html><body>Oops</body></html>"))
+	}))
+	defer server.Close()
+
+	defer func(b bool) { options.Current().UpstreamValidateResponses = b }(options.Current().UpstreamValidateResponses)
+	options.Current().UpstreamValidateResponses = true
+
+	u, _ := url.Parse(server.URL)
+	cache := tokencache.NewMemoryCache(10)
+	h := NewTokenInfoProxyHandlerWithCache(u, cache, time.Minute, time.Second)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo?access_token=foo", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("html")) {
+		t.Errorf("Invalid upstream response should not be sent to the client, got %s", w.Body.String())
+	}
+	if s, _ := cache.Stats(); s.Size != 0 {
+		t.Errorf("Invalid upstream response should not be cached, got %d entries", s.Size)
+	}
+}
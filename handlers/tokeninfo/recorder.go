@@ -0,0 +1,40 @@
+package tokeninfo
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// A ResponseRecorder keeps the response of a token info handler called by another handler, for ex. to read
+// the token info before answering the client
+type ResponseRecorder struct {
+	header http.Header
+	Body   *bytes.Buffer
+	Status int
+}
+
+// NewResponseRecorder returns a ResponseRecorder with the status 200 until WriteHeader is called
+func NewResponseRecorder() *ResponseRecorder {
+	return &ResponseRecorder{header: make(http.Header), Body: new(bytes.Buffer), Status: http.StatusOK}
+}
+
+func (rr *ResponseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *ResponseRecorder) Write(b []byte) (int, error) {
+	return rr.Body.Write(b)
+}
+
+func (rr *ResponseRecorder) WriteHeader(status int) {
+	rr.Status = status
+}
+
+// WriteResponse sends the recorded headers, status and body to w
+func (rr *ResponseRecorder) WriteResponse(w http.ResponseWriter) {
+	for k, v := range rr.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rr.Status)
+	w.Write(rr.Body.Bytes())
+}
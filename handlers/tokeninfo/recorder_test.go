@@ -0,0 +1,24 @@
+package tokeninfo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	rec := NewResponseRecorder()
+	if rec.Status != http.StatusOK {
+		t.Errorf("The default status should be 200, got %d", rec.Status)
+	}
+	ErrInvalidToken.Write(rec, nil)
+	if rec.Status != http.StatusUnauthorized || rec.Header().Get("Content-Type") == "" || rec.Body.Len() == 0 {
+		t.Fatalf("Wrong recorded response: %d %v %q", rec.Status, rec.Header(), rec.Body.String())
+	}
+
+	rw := httptest.NewRecorder()
+	rec.WriteResponse(rw)
+	if rw.Code != rec.Status || rw.Header().Get("Content-Type") != rec.Header().Get("Content-Type") || rw.Body.String() != rec.Body.String() {
+		t.Errorf("Wrong written response: %d %v %q", rw.Code, rw.Header(), rw.Body.String())
+	}
+}
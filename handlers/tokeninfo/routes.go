@@ -0,0 +1,70 @@
+package tokeninfo
+
+import (
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/processor"
+)
+
+type tokenRouter struct {
+	routes   *processor.TokenRoutes
+	jwt      http.Handler
+	upstream http.Handler
+	others   map[string]http.Handler
+	next     http.Handler
+}
+
+// NewTokenRouter returns an http.Handler that sends the requests with a token matching one of the routes to
+// the jwt, the upstream or the others http.Handler, by the target of the route. others has an http.Handler
+// for every target returned by Upstreams. Requests without a token or a matching route are passed to next
+func NewTokenRouter(routes *processor.TokenRoutes, jwt http.Handler, upstream http.Handler, others map[string]http.Handler, next http.Handler) http.Handler {
+	return &tokenRouter{routes: routes, jwt: jwt, upstream: upstream, others: others, next: next}
+}
+
+// Target returns the target that the handlers of NewTokenRouter and NewHandler choose for the token: the one of
+// the first of the routes that matches it, processor.RouteJWT for a JWT or else processor.RouteUpstream. The
+// routes may be nil
+func Target(token string, routes *processor.TokenRoutes, jwt bool) string {
+	if routes != nil {
+		if route := routes.Route(token); route != nil {
+			return route.Target
+		}
+	}
+	if jwt {
+		return processor.RouteJWT
+	}
+	return processor.RouteUpstream
+}
+
+func (tr *tokenRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token := AccessTokenFromRequest(req)
+	var route *processor.TokenRoute
+	if token != "" {
+		route = tr.routes.Route(token)
+	}
+	if route == nil {
+		tr.next.ServeHTTP(w, req)
+		return
+	}
+	switch route.Target {
+	case processor.RouteJWT:
+		incCounter("planb.tokeninfo.routes.jwt")
+		tr.jwt.ServeHTTP(w, req)
+	case processor.RouteUpstream:
+		incCounter("planb.tokeninfo.routes.upstream")
+		tr.upstream.ServeHTTP(w, req)
+	case processor.RouteReject:
+		incCounter("planb.tokeninfo.routes.reject")
+		ErrInvalidToken.Write(w, req)
+	default:
+		incCounter("planb.tokeninfo.routes.other")
+		tr.others[route.Target].ServeHTTP(w, req)
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,85 @@
+package tokeninfo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/processor"
+)
+
+const testRoutes = `{
+	"routes": [
+		{"prefix": "legacy.", "target": "https://legacy.example.org/oauth2/tokeninfo"},
+		{"match": "^[0-9a-f]{8}-", "target": "upstream"},
+		{"dots": 2, "max_length": 20, "target": "upstream"},
+		{"dots": 2, "target": "jwt"},
+		{"min_length": 3, "max_length": 8, "target": "reject"}
+	]
+}`
+
+func TestTokenRouter(t *testing.T) {
+	routes, err := processor.LoadTokenRoutes(strings.NewReader(testRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { w.Write([]byte(name)) })
+	}
+	others := map[string]http.Handler{"https://legacy.example.org/oauth2/tokeninfo": named("legacy")}
+	h := NewTokenRouter(routes, named("jwt"), named("upstream"), others, named("default"))
+
+	for _, test := range []struct {
+		token    string
+		wantBody string
+		wantCode int
+	}{
+		{"", "default", http.StatusOK},
+		{"legacy.a.b", "legacy", http.StatusOK},
+		{"0123abcd-foo", "upstream", http.StatusOK},
+		{"a.b.c", "upstream", http.StatusOK},
+		{"header.payload.signature", "jwt", http.StatusOK},
+		{"abcd", "", http.StatusUnauthorized},
+		{"ab", "default", http.StatusOK},
+		{"opaque-token-without-route", "default", http.StatusOK},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		h.ServeHTTP(rw, r)
+		if rw.Code != test.wantCode {
+			t.Errorf("Wrong status code for %q. Wanted %d, got %d", test.token, test.wantCode, rw.Code)
+		}
+		if test.wantCode == http.StatusOK && rw.Body.String() != test.wantBody {
+			t.Errorf("Wrong route for %q. Wanted %s, got %s", test.token, test.wantBody, rw.Body.String())
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	routes, err := processor.LoadTokenRoutes(strings.NewReader(testRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		token  string
+		routes *processor.TokenRoutes
+		jwt    bool
+		want   string
+	}{
+		{"legacy.a.b", routes, true, "https://legacy.example.org/oauth2/tokeninfo"},
+		{"a.b.c", routes, true, processor.RouteUpstream},
+		{"abcd", routes, false, processor.RouteReject},
+		{"opaque-token-without-route", routes, false, processor.RouteUpstream},
+		{"header.payload.signature", nil, true, processor.RouteJWT},
+		{"opaque-token-without-route", nil, false, processor.RouteUpstream},
+	} {
+		if got := Target(test.token, test.routes, test.jwt); got != test.want {
+			t.Errorf("Wrong target for %q. Wanted %s, got %s", test.token, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package tokeninfo
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// compare is replaced by the tests to see what is compared
+var compare = subtle.ConstantTimeCompare
+
+// SecretEqual compares a secret, like an API key or a password, with the one sent by a client in constant time.
+// The SHA-256 hashes of both are compared instead of the secrets themselves, so that neither a difference in
+// length nor the length of the common prefix changes the time taken. Tokens don't need it, they are only
+// looked up by their HashToken hash
+func SecretEqual(secret, sent string) bool {
+	a := sha256.Sum256([]byte(secret))
+	b := sha256.Sum256([]byte(sent))
+	return compare(a[:], b[:]) == 1
+}
+
+// CheckSecret returns true if sent is the secret of name in secrets. The secret is compared even if name is
+// unknown, so that the time taken doesn't tell which names exist
+func CheckSecret(secrets map[string]string, name, sent string) bool {
+	secret, has := secrets[name]
+	equal := SecretEqual(secret, sent)
+	return equal && has
+}
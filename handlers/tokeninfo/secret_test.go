@@ -0,0 +1,71 @@
+package tokeninfo
+
+import (
+	"crypto/subtle"
+	"strings"
+	"testing"
+)
+
+// recordCompares replaces the constant time comparison with one that records the lengths of the compared values
+func recordCompares(t *testing.T) *[]int {
+	var lengths []int
+	compare = func(x, y []byte) int {
+		lengths = append(lengths, len(x), len(y))
+		return subtle.ConstantTimeCompare(x, y)
+	}
+	t.Cleanup(func() { compare = subtle.ConstantTimeCompare })
+	return &lengths
+}
+
+func TestSecretEqual(t *testing.T) {
+	lengths := recordCompares(t)
+	for _, test := range []struct {
+		secret string
+		sent   string
+		want   bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "secreT", false},
+		{"secret", "secre", false},
+		{"secret", "secret" + strings.Repeat("x", 1000), false},
+		{"secret", "", false},
+		{"", "", true},
+	} {
+		if got := SecretEqual(test.secret, test.sent); got != test.want {
+			t.Errorf("Wrong comparison of %q with %q. Wanted %v, got %v", test.secret, test.sent, test.want, got)
+		}
+	}
+	// values of any length are compared in full, as hashes of the same length
+	for _, l := range *lengths {
+		if l != 32 {
+			t.Fatalf("Only SHA-256 hashes should be compared, got values of lengths %v", *lengths)
+		}
+	}
+	if len(*lengths) != 12 {
+		t.Errorf("Every comparison should be made, got %d compared values", len(*lengths))
+	}
+}
+
+func TestCheckSecret(t *testing.T) {
+	lengths := recordCompares(t)
+	secrets := map[string]string{"admin": "secret", "other": ""}
+	for _, test := range []struct {
+		name string
+		sent string
+		want bool
+	}{
+		{"admin", "secret", true},
+		{"admin", "wrong", false},
+		{"other", "", true},
+		{"unknown", "secret", false},
+		{"unknown", "", false},
+	} {
+		before := len(*lengths)
+		if got := CheckSecret(secrets, test.name, test.sent); got != test.want {
+			t.Errorf("Wrong check of %q for %s. Wanted %v, got %v", test.sent, test.name, test.want, got)
+		}
+		if len(*lengths) != before+2 {
+			t.Errorf("The secret of %s should be compared, known or not", test.name)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package verify compares the token info responses of the local JWT validation with the ones of the upstream
+// token info for the same tokens, so that the differences are known before the consumers rely on the local
+// validation
+package verify
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+type verifyHandler struct {
+	tokeninfo.Handler
+	upstream   http.Handler
+	percentage int
+	comparer   *tokeninfo.Comparer
+}
+
+// NewHandler returns a tokeninfo.Handler that answers with the JWT handler jwt and sends a percentage of its
+// requests to the upstream token info handler as well, in the background. The two responses are compared,
+// status and fields, and the differences are counted and logged. The upstream responses are never sent to
+// the clients
+func NewHandler(jwt tokeninfo.Handler, upstream http.Handler, percentage int, timeout time.Duration) tokeninfo.Handler {
+	return &verifyHandler{Handler: jwt, upstream: upstream, percentage: percentage, comparer: tokeninfo.NewComparer("planb.tokeninfo.jwt.verify", timeout)}
+}
+
+func (h *verifyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if rand.Intn(100) >= h.percentage {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+	bw := &bodyWriter{ResponseWriter: w, status: http.StatusOK}
+	h.Handler.ServeHTTP(bw, req)
+	h.verify(req, bw.status, bw.body.Bytes())
+}
+
+// verify calls the upstream in the background with a copy of req and compares its response with the local
+// one
+func (h *verifyHandler) verify(req *http.Request, status int, body []byte) {
+	h.comparer.Compare(req, status, body, func(r *http.Request) (int, []byte) {
+		rr := tokeninfo.NewResponseRecorder()
+		h.upstream.ServeHTTP(rr, r)
+		return rr.Status, rr.Body.Bytes()
+	})
+}
+
+// bodyWriter keeps the status and the body of the response sent to the client
+type bodyWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bodyWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,83 @@
+package verify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+type jwtHandler string
+
+func (h jwtHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte(h))
+}
+
+func (h jwtHandler) Match(req *http.Request) bool {
+	return true
+}
+
+func TestVerify(t *testing.T) {
+	const local = `{"uid":"jdoe","scope":["uid"],"realm":"/employees","expires_in":3600}`
+	for _, test := range []struct {
+		name           string
+		upstreamStatus int
+		upstreamBody   string
+		wantCounters   []string
+	}{
+		{"same fields", http.StatusOK, `{"uid":"jdoe","scope":["uid"],"realm":"/employees","expires_in":3599}`,
+			[]string{"planb.tokeninfo.jwt.verify.matches"}},
+		{"other fields", http.StatusOK, `{"uid":"jdoe","scope":["uid","cn"],"expires_in":3599}`,
+			[]string{"planb.tokeninfo.jwt.verify.mismatches.field.scope", "planb.tokeninfo.jwt.verify.mismatches.field.realm"}},
+		{"other status", http.StatusUnauthorized, `{"error":"invalid_token"}`,
+			[]string{"planb.tokeninfo.jwt.verify.mismatches.status"}},
+	} {
+		before := make(map[string]int64)
+		for _, name := range test.wantCounters {
+			before[name] = metrics.GetOrRegisterCounter(name, metrics.DefaultRegistry).Count()
+		}
+		upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Bearer foo" {
+				t.Errorf("The token was not sent to the upstream for %s", test.name)
+			}
+			w.WriteHeader(test.upstreamStatus)
+			w.Write([]byte(test.upstreamBody))
+		})
+		h := NewHandler(jwtHandler(local), upstream, 100, time.Second)
+
+		r, _ := http.NewRequest("GET", "/oauth2/tokeninfo", nil)
+		r.Header.Set("Authorization", "Bearer foo")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != local {
+			t.Errorf("The client didn't get the local response for %s: %d %s", test.name, w.Code, w.Body.String())
+		}
+
+		for _, name := range test.wantCounters {
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+				if metrics.GetOrRegisterCounter(name, metrics.DefaultRegistry).Count() > before[name] {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Errorf("%s was not counted for %s", name, test.name)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyPercentage(t *testing.T) {
+	called := make(chan struct{}, 1)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { called <- struct{}{} })
+	h := NewHandler(jwtHandler("{}"), upstream, 0, time.Second)
+	r, _ := http.NewRequest("GET", "/oauth2/tokeninfo?access_token=foo", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	select {
+	case <-called:
+		t.Error("The upstream was called with a percentage of 0")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,139 @@
+package tokenreview
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+)
+
+// maxBodySize limits the size of TokenReview requests
+const maxBodySize = 1 << 20
+
+const kindTokenReview = "TokenReview"
+
+var apiVersions = map[string]bool{
+	"authentication.k8s.io/v1":      true,
+	"authentication.k8s.io/v1beta1": true,
+}
+
+type tokenReviewHandler struct {
+	tokenInfo http.Handler
+}
+
+// TokenReview is the subset of the Kubernetes TokenReview object used by authentication webhooks. See
+// https://kubernetes.io/docs/reference/access-authn-authz/authentication/#webhook-token-authentication
+type TokenReview struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       Spec   `json:"spec"`
+	Status     Status `json:"status"`
+}
+
+// Spec holds the token to review
+type Spec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// Status is the result of the review. User is only set for authenticated tokens
+type Status struct {
+	Authenticated bool      `json:"authenticated"`
+	User          *UserInfo `json:"user,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// UserInfo describes the owner of an authenticated token. The realm is the only group and the scopes
+// and client ID are passed as extra attributes
+type UserInfo struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// the subset of the Token Info response that can be translated into a TokenReview status
+type tokenInfoResponse struct {
+	UID      string   `json:"uid"`
+	Scope    []string `json:"scope"`
+	Realm    string   `json:"realm"`
+	ClientID string   `json:"client_id"`
+}
+
+// NewHandler returns an http.Handler that implements the Kubernetes authentication webhook. Tokens are
+// validated by the tokenInfo http.Handler, which means both JWT and upstream validation apply
+func NewHandler(tokenInfo http.Handler) http.Handler {
+	return &tokenReviewHandler{tokenInfo: tokenInfo}
+}
+
+// ServeHTTP reviews the token of a TokenReview request and sends it back with its status. Invalid tokens
+// get status 200 with an unauthenticated status, as the API server expects
+func (h *tokenReviewHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	review := new(TokenReview)
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxBodySize)).Decode(review); err != nil ||
+		review.Kind != kindTokenReview || !apiVersions[review.APIVersion] {
+		http.Error(w, "Invalid TokenReview", http.StatusBadRequest)
+		return
+	}
+
+	review.Status = Status{Error: "Access Token not valid"}
+	if review.Spec.Token != "" {
+		rec := tokeninfo.NewResponseRecorder()
+		h.tokenInfo.ServeHTTP(rec, tokeninfo.NewTokenInfoRequest(req, review.Spec.Token))
+
+		if rec.Status >= http.StatusInternalServerError {
+			slog.Warn("Failed to review token", "status", rec.Status)
+			http.Error(w, http.StatusText(rec.Status), rec.Status)
+			return
+		}
+
+		if rec.Status == http.StatusOK {
+			ti := new(tokenInfoResponse)
+			if err := json.Unmarshal(rec.Body.Bytes(), ti); err == nil {
+				review.Status = newStatus(ti)
+			} else {
+				slog.Warn("Failed to decode the token info response", "error", err)
+			}
+		}
+	}
+	review.Spec = Spec{}
+
+	incCounter(review.Status.Authenticated)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		slog.Warn("Failed to finish TokenReview response", "error", err)
+	}
+}
+
+func newStatus(ti *tokenInfoResponse) Status {
+	u := &UserInfo{Username: ti.UID, UID: ti.UID, Extra: make(map[string][]string)}
+	if ti.Realm != "" {
+		u.Groups = []string{ti.Realm}
+	}
+	if len(ti.Scope) > 0 {
+		u.Extra["scope"] = ti.Scope
+	}
+	if ti.ClientID != "" {
+		u.Extra["client_id"] = []string{ti.ClientID}
+	}
+	return Status{Authenticated: true, User: u}
+}
+
+func incCounter(authenticated bool) {
+	key := "planb.tokeninfo.tokenreview.unauthenticated"
+	if authenticated {
+		key = "planb.tokeninfo.tokenreview.authenticated"
+	}
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,63 @@
+package tokenreview
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testTokenInfoHandler struct{}
+
+func (h *testTokenInfoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Header.Get("Authorization") {
+	case "Bearer valid":
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"uid":"foo","scope":["uid","cn"],"realm":"/services","client_id":"bar","token_type":"Bearer","expires_in":3600}`)
+	case "Bearer broken":
+		w.WriteHeader(http.StatusGatewayTimeout)
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_token","error_description":"Access Token not valid"}`)
+	}
+}
+
+func review(apiVersion string, token string) string {
+	return fmt.Sprintf(`{"apiVersion":%q,"kind":"TokenReview","spec":{"token":%q}}`, apiVersion, token)
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(new(testTokenInfoHandler))
+	unauthenticated := `"status":{"authenticated":false,"error":"Access Token not valid"}}` + "\n"
+	for _, test := range []struct {
+		method   string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"GET", review("authentication.k8s.io/v1", "valid"), http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{"POST", "invalid json", http.StatusBadRequest, "Invalid TokenReview\n"},
+		{"POST", `{"apiVersion":"v1","kind":"Pod"}`, http.StatusBadRequest, "Invalid TokenReview\n"},
+		{"POST", review("authentication.k8s.io/v2", "valid"), http.StatusBadRequest, "Invalid TokenReview\n"},
+		{"POST", review("authentication.k8s.io/v1", ""), http.StatusOK, `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":""},` + unauthenticated},
+		{"POST", review("authentication.k8s.io/v1", "invalid"), http.StatusOK, `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":""},` + unauthenticated},
+		{"POST", review("authentication.k8s.io/v1", "broken"), http.StatusGatewayTimeout, "Gateway Timeout\n"},
+		{"POST", review("authentication.k8s.io/v1", "valid"), http.StatusOK,
+			`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":""},"status":{"authenticated":true,"user":{"username":"foo","uid":"foo","groups":["/services"],"extra":{"client_id":["bar"],"scope":["uid","cn"]}}}}` + "\n"},
+		{"POST", review("authentication.k8s.io/v1beta1", "valid"), http.StatusOK, `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":""},"status":{"authenticated":true,`},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://example.com/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d", test.body, test.wantCode, w.Code)
+		}
+
+		if !strings.HasPrefix(w.Body.String(), test.wantBody) {
+			t.Errorf("Wrong response body for %s. Wanted %q, got %q", test.body, test.wantBody, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package userinfo
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/jwe"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/processor"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// maxBodySize limits the size of upstream UserInfo responses
+const maxBodySize = 1 << 20
+
+// cached UserInfo responses are prefixed, the cache can be shared with the upstream Token Info responses
+const cacheKeyPrefix = "userinfo:"
+
+// standardClaims are the claims of the OpenID Connect UserInfo response besides sub. See
+// https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+var standardClaims = []string{
+	"name", "given_name", "family_name", "middle_name", "nickname", "preferred_username", "profile",
+	"picture", "website", "email", "email_verified", "gender", "birthdate", "zoneinfo", "locale",
+	"phone_number", "phone_number_verified", "address", "updated_at",
+}
+
+// CacheKey returns the key of the cached UserInfo response of the token with the hash, see tokeninfo.HashToken
+func CacheKey(hash string) string {
+	return cacheKeyPrefix + hash
+}
+
+type userInfoHandler struct {
+	tokenInfo http.Handler
+	route     func(token string) string
+	upstream  *url.URL
+	client    *http.Client
+	cache     tokencache.Cache
+	cacheTTL  time.Duration
+}
+
+// NewHandler returns an http.Handler for the OpenID Connect UserInfo endpoint. route returns the target of a
+// token like the Token Info handlers choose it, see tokeninfo.Target. Tokens routed to processor.RouteJWT are
+// validated by the tokenInfo http.Handler and the response is built from their claims. Other tokens are sent to
+// the upstream UserInfo endpoint, if not nil. With a cache, they are validated by the tokenInfo http.Handler
+// first and the successful upstream responses are stored for cacheTTL, at most until the token expires. A nil
+// cache disables caching
+func NewHandler(tokenInfo http.Handler, route func(token string) string, upstream *url.URL, cache tokencache.Cache, cacheTTL time.Duration, timeout time.Duration) http.Handler {
+	return &userInfoHandler{
+		tokenInfo: tokenInfo,
+		route:     route,
+		upstream:  upstream,
+		client:    &http.Client{Timeout: timeout, Transport: ht.NewTransport()},
+		cache:     cache,
+		cacheTTL:  cacheTTL,
+	}
+}
+
+// ServeHTTP sends back the UserInfo of the Access Token in the Request
+func (h *userInfoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token := tokeninfo.AccessTokenFromRequest(req)
+	if token == "" {
+		tokeninfo.ErrInvalidRequest.Write(w, req)
+		return
+	}
+	switch target := h.route(token); {
+	case target == processor.RouteJWT:
+		h.serveJWT(w, req, token)
+	case target != processor.RouteReject && h.upstream != nil:
+		h.serveUpstream(w, req, token)
+	default:
+		tokeninfo.ErrInvalidToken.Write(w, req)
+	}
+}
+
+// serveJWT validates the token like the Token Info endpoint and builds the UserInfo from its claims. The
+// signature was checked by the Token Info handler, so the claims don't need to be verified again
+func (h *userInfoHandler) serveJWT(w http.ResponseWriter, req *http.Request, token string) {
+	rec := tokeninfo.NewResponseRecorder()
+	h.tokenInfo.ServeHTTP(rec, tokenInfoRequest(req, token))
+	if rec.Status != http.StatusOK {
+		rec.WriteResponse(w)
+		return
+	}
+
+	var ti struct {
+		UID string `json:"uid"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &ti)
+	if jwe.IsJWE(token) {
+		token = h.decrypt(token)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		slog.Warn("Failed to read the claims of a validated token", "error", err)
+		tokeninfo.ErrInvalidToken.Write(w, req)
+		return
+	}
+
+	ui := make(map[string]interface{})
+	ui["sub"] = ti.UID
+	if sub, ok := claims["sub"].(string); ok {
+		ui["sub"] = sub
+	}
+	for _, c := range standardClaims {
+		if v, has := claims[c]; has {
+			ui[c] = v
+		}
+	}
+	incCounter("planb.tokeninfo.userinfo.jwt")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ui); err != nil {
+		slog.Warn("Failed to write the userinfo response", "error", err)
+	}
+}
+
+// decrypt returns the JWT inside of the JWE token, which the Token Info handler already decrypted once
+func (h *userInfoHandler) decrypt(token string) string {
+	d := options.Current().JWEDecrypter
+	if d == nil {
+		return ""
+	}
+	plaintext, err := d.Decrypt(token)
+	if err != nil {
+		return ""
+	}
+	return string(plaintext)
+}
+
+// serveUpstream sends the UserInfo of the upstream, from the cache if possible
+func (h *userInfoHandler) serveUpstream(w http.ResponseWriter, req *http.Request, token string) {
+	key := CacheKey(tokeninfo.HashToken(token))
+	var ttl time.Duration
+	if h.cache != nil {
+		// cached responses are only sent for tokens that are still valid, and kept until they expire at most
+		rec := tokeninfo.NewResponseRecorder()
+		h.tokenInfo.ServeHTTP(rec, tokenInfoRequest(req, token))
+		if rec.Status != http.StatusOK {
+			rec.WriteResponse(w)
+			return
+		}
+		ttl = cacheTTL(rec, h.cacheTTL)
+		if body, err := h.cache.Get(key); err == nil {
+			incCounter("planb.tokeninfo.userinfo.cache.hits")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Cache", "HIT")
+			w.Write(body)
+			return
+		}
+		incCounter("planb.tokeninfo.userinfo.cache.misses")
+	}
+
+	start := time.Now()
+	rec, err := h.upstreamRequest(req, token)
+	if err != nil {
+		slog.Warn("Upstream userinfo call failed", "error", err)
+		incCounter("planb.tokeninfo.userinfo.upstream.errors")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if t, ok := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.userinfo.upstream", metrics.NewTimer).(metrics.Timer); ok {
+		t.UpdateSince(start)
+	}
+	if rec.Status == http.StatusOK && ttl > 0 {
+		if err := h.cache.Set(key, rec.Body.Bytes(), ttl); err != nil {
+			slog.Warn("Failed to store userinfo in the cache", "error", err)
+		}
+	}
+	rec.Header().Set("X-Cache", "MISS")
+	rec.WriteResponse(w)
+}
+
+// cacheTTL returns the maxTTL, or the expires_in of the Token Info response if the token expires earlier
+func cacheTTL(rec *tokeninfo.ResponseRecorder, maxTTL time.Duration) time.Duration {
+	var ti struct {
+		ExpiresIn int `json:"expires_in"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ti); err != nil {
+		return 0
+	}
+	return min(maxTTL, time.Duration(ti.ExpiresIn)*time.Second)
+}
+
+// upstreamRequest calls the upstream UserInfo endpoint with the token in the Authorization header
+func (h *userInfoHandler) upstreamRequest(req *http.Request, token string) (*tokeninfo.ResponseRecorder, error) {
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodGet, h.upstream.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("User-Agent", ht.UserAgent)
+	requestid.Inject(req.Context(), r.Header)
+	resp, err := h.client.Do(r)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rec := tokeninfo.NewResponseRecorder()
+	rec.Status = resp.StatusCode
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		rec.Header().Set("Content-Type", ct)
+	}
+	if _, err := io.Copy(rec.Body, io.LimitReader(resp.Body, maxBodySize)); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// tokenInfoRequest builds the Request used to validate token with the Token Info handlers. Its errors are
+// negotiated like the ones of this handler
+func tokenInfoRequest(req *http.Request, token string) *http.Request {
+	r := tokeninfo.NewTokenInfoRequest(req, token)
+	r.Header["Accept"] = req.Header["Accept"]
+	return r
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
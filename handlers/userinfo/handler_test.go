@@ -0,0 +1,158 @@
+package userinfo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	"github.com/zalando/planb-tokeninfo/processor"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// testTokenInfoHandler accepts the tokens with their expires_in
+type testTokenInfoHandler map[string]int
+
+func (h testTokenInfoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if expiresIn, ok := h[tokeninfo.AccessTokenFromRequest(req)]; ok {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"uid":"foo","scope":["uid"],"realm":"/employees","token_type":"Bearer","expires_in":%d}`, expiresIn)
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprint(w, `{"error":"invalid_token","error_description":"Access Token not valid"}`)
+}
+
+func testToken(claims jwt.MapClaims) string {
+	s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	return s
+}
+
+func TestHandler(t *testing.T) {
+	var upstreamCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamCalls++
+		switch req.Header.Get("Authorization") {
+		case "Bearer opaque":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"sub":"bar","email":"bar@example.org"}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	valid := testToken(jwt.MapClaims{"sub": "foo", "name": "Foo Bar", "email": "foo@example.org", "email_verified": true, "scope": []string{"uid"}})
+	noSub := testToken(jwt.MapClaims{"name": "Foo Bar"})
+	invalid := testToken(jwt.MapClaims{"sub": "baz"})
+	u, _ := url.Parse(server.URL)
+	h := NewHandler(testTokenInfoHandler{valid: 3600, "opaque": 3600}, route, u, tokencache.NewMemoryCache(10), time.Minute, time.Second)
+	noUpstream := NewHandler(testTokenInfoHandler{noSub: 3600}, route, nil, nil, 0, time.Second)
+
+	for _, test := range []struct {
+		handler   http.Handler
+		token     string
+		wantCode  int
+		wantBody  string
+		wantCache string
+	}{
+		{h, "", http.StatusBadRequest, `{"error":"invalid_request","error_description":"Access Token not valid"}` + "\n", ""},
+		{h, valid, http.StatusOK, `{"email":"foo@example.org","email_verified":true,"name":"Foo Bar","sub":"foo"}` + "\n", ""},
+		{noUpstream, noSub, http.StatusOK, `{"name":"Foo Bar","sub":"foo"}` + "\n", ""},
+		{h, invalid, http.StatusUnauthorized, `{"error":"invalid_token","error_description":"Access Token not valid"}`, ""},
+		{h, "opaque", http.StatusOK, `{"sub":"bar","email":"bar@example.org"}`, "MISS"},
+		{h, "opaque", http.StatusOK, `{"sub":"bar","email":"bar@example.org"}`, "HIT"},
+		{h, "unknown", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"Access Token not valid"}`, ""},
+		{h, "rejected.", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"Access Token not valid"}` + "\n", ""},
+		{noUpstream, "opaque", http.StatusUnauthorized, `{"error":"invalid_token","error_description":"Access Token not valid"}` + "\n", ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/userinfo", nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		test.handler.ServeHTTP(w, r)
+
+		if w.Code != test.wantCode {
+			t.Errorf("Wrong status code for %s. Wanted %d, got %d", test.token, test.wantCode, w.Code)
+		}
+		if w.Body.String() != test.wantBody {
+			t.Errorf("Wrong response body for %s. Wanted %q, got %q", test.token, test.wantBody, w.Body.String())
+		}
+		if c := w.Header().Get("X-Cache"); c != test.wantCache {
+			t.Errorf("Wrong cache header for %s. Wanted %q, got %q", test.token, test.wantCache, c)
+		}
+	}
+	if upstreamCalls != 1 {
+		t.Errorf("Wrong number of upstream calls. Wanted 1, got %d", upstreamCalls)
+	}
+}
+
+// route sends the tokens ending with a dot to processor.RouteReject, like a route of TOKEN_ROUTES_FILE
+func route(token string) string {
+	if strings.HasSuffix(token, ".") {
+		return processor.RouteReject
+	}
+	return tokeninfo.Target(token, nil, strings.Count(token, ".") == 2)
+}
+
+type ttlCache struct {
+	tokencache.Cache
+	ttl time.Duration
+}
+
+func (c *ttlCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.ttl = ttl
+	return c.Cache.Set(key, value, ttl)
+}
+
+func TestCacheTTL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"sub":"bar"}`)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	for _, test := range []struct {
+		expiresIn int
+		wantTTL   time.Duration
+	}{
+		{3600, time.Minute},
+		{10, 10 * time.Second},
+		{0, 0},
+	} {
+		c := &ttlCache{Cache: tokencache.NewMemoryCache(10)}
+		h := NewHandler(testTokenInfoHandler{"opaque": test.expiresIn}, route, u, c, time.Minute, time.Second)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/userinfo?access_token=opaque", nil)
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status code for expires_in %d: %d", test.expiresIn, w.Code)
+		}
+		if c.ttl != test.wantTTL {
+			t.Errorf("Wrong cache TTL for expires_in %d. Wanted %s, got %s", test.expiresIn, test.wantTTL, c.ttl)
+		}
+	}
+}
+
+func TestUpstreamFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	u, _ := url.Parse(server.URL)
+	server.Close()
+
+	h := NewHandler(testTokenInfoHandler{}, route, u, nil, 0, time.Second)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/oauth2/userinfo?access_token=opaque", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "opaque") {
+		t.Errorf("Error response should not contain the token: %s", w.Body.String())
+	}
+}
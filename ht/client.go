@@ -20,19 +20,22 @@ var (
 // Use it only for one time requests where performance is not a concern
 // It use some settings from the options package: options.HttpClientTimeout and options.HttpClientTlsTimeout
 func DefaultHTTPClient() *http.Client {
-	return NewHTTPClient(options.AppSettings.HTTPClientTimeout, options.AppSettings.HTTPClientTLSTimeout)
+	return NewHTTPClient(options.Current().HTTPClientTimeout, options.Current().HTTPClientTLSTimeout)
 }
 
 // NewHTTPClient returns a new http.Client with specific timeouts from its arguments. KeepAlive is disabled.
 // That means no connection pooling. Use it only for one time requests where performance is not a concern
+// The ClientTLSConfig is used for HTTPS requests, if set
 func NewHTTPClient(timeout time.Duration, tlsTimeout time.Duration) *http.Client {
-	return &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			Proxy:               http.ProxyFromEnvironment,
-			DisableKeepAlives:   true,
-			Dial:                (&net.Dialer{Timeout: options.AppSettings.HTTPClientTimeout}).Dial,
-			TLSHandshakeTimeout: tlsTimeout}}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DisableKeepAlives:   true,
+		Dial:                (&net.Dialer{Timeout: options.Current().HTTPClientTimeout}).Dial,
+		TLSHandshakeTimeout: tlsTimeout}
+	if ClientTLSConfig != nil {
+		t.TLSClientConfig = ClientTLSConfig.Clone()
+	}
+	return &http.Client{Timeout: timeout, Transport: t}
 }
 
 // Get issues a GET to the specified URL. It follows redirects, up to a maximum of 10
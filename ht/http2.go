@@ -0,0 +1,24 @@
+package ht
+
+import "net/http"
+
+// ServerProtocols returns the protocols of the listener. HTTP/1.1 and HTTP/2 over TLS are always
+// enabled, h2c adds HTTP/2 over plain TCP with prior knowledge, as used by gRPC style load balancers
+func ServerProtocols(h2c bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(h2c)
+	return p
+}
+
+// NewH2CTransport returns an http.Transport like NewTransport that only speaks HTTP/2. Connections to
+// http:// URLs use h2c with prior knowledge and https:// URLs negotiate HTTP/2 with ALPN. All requests to
+// a host are multiplexed over a single connection
+func NewH2CTransport() *http.Transport {
+	t := NewTransport()
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP2(true)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return t
+}
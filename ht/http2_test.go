@@ -0,0 +1,36 @@
+package ht
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestH2C(t *testing.T) {
+	for _, test := range []struct {
+		h2c       bool
+		wantProto int
+		wantErr   bool
+	}{
+		{false, 0, true},
+		{true, 2, false},
+	} {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		server.Config.Protocols = ServerProtocols(test.h2c)
+		server.Start()
+
+		resp, err := (&http.Client{Transport: NewH2CTransport()}).Get(server.URL)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected result with h2c %t. Wanted error %t, got %v", test.h2c, test.wantErr, err)
+		}
+		if err == nil {
+			resp.Body.Close()
+			if resp.ProtoMajor != test.wantProto {
+				t.Errorf("Wrong protocol with h2c %t. Wanted HTTP/%d, got %s", test.h2c, test.wantProto, resp.Proto)
+			}
+		}
+		server.Close()
+	}
+}
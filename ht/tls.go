@@ -0,0 +1,73 @@
+package ht
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrNoCACertificates is returned when the CA bundle does not contain any PEM certificate
+var ErrNoCACertificates = errors.New("no PEM certificates in the CA bundle")
+
+// ClientTLSConfig is the TLS configuration used by the http.Client instances and transports from this
+// package. When nil, the Go defaults are used. It is set with SetupClientTLS
+var ClientTLSConfig *tls.Config
+
+// LoadClientTLSConfig returns a TLS configuration with the client certificate from certFile and keyFile
+// and the trusted certificate authorities from the PEM bundle caFile. All arguments are optional; without
+// a caFile the system certificate pool is used
+func LoadClientTLSConfig(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// LoadCertPool returns a certificate pool with the certificates of the PEM bundle caFile
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrNoCACertificates
+	}
+	return pool, nil
+}
+
+// SetupClientTLS loads the client TLS configuration with LoadClientTLSConfig and uses it for the Default
+// client and for every client or transport created afterwards by this package
+func SetupClientTLS(certFile string, keyFile string, caFile string) error {
+	cfg, err := LoadClientTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		return err
+	}
+	ClientTLSConfig = cfg
+	Default = DefaultHTTPClient()
+	return nil
+}
+
+// NewTransport returns an http.Transport like http.DefaultTransport, with connection pooling, using the
+// ClientTLSConfig. Use it for long lived clients like reverse proxies
+func NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if ClientTLSConfig != nil {
+		t.TLSClientConfig = ClientTLSConfig.Clone()
+	}
+	return t
+}
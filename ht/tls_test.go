@@ -0,0 +1,118 @@
+package ht
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "planb-tokeninfo"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate: ", err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+
+	cf, kf := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(cf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(kf, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	return cf, kf
+}
+
+func TestSetupClientTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ht")
+	defer os.RemoveAll(dir)
+	defer func(c *tls.Config, d *http.Client) { ClientTLSConfig, Default = c, d }(ClientTLSConfig, Default)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.PeerCertificates) == 0 || req.TLS.PeerCertificates[0].Subject.CommonName != "planb-tokeninfo" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	ca := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	cf, kf := writeClientCertificate(t, dir)
+
+	if _, err := Get(server.URL); err == nil {
+		t.Error("Expected an error for an unknown certificate authority")
+	}
+
+	if err := SetupClientTLS("", "", ca); err != nil {
+		t.Fatal("Failed to set up the client TLS: ", err)
+	}
+	if _, err := Get(server.URL); err == nil {
+		t.Error("Expected an error without a client certificate")
+	}
+
+	if err := SetupClientTLS(cf, kf, ca); err != nil {
+		t.Fatal("Failed to set up the client TLS: ", err)
+	}
+	resp, err := Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code. Wanted %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = (&http.Client{Transport: NewTransport()}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code for the pooled transport. Wanted %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestLoadClientTLSConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ht")
+	defer os.RemoveAll(dir)
+	cf, kf := writeClientCertificate(t, dir)
+
+	for _, test := range []struct {
+		certFile string
+		keyFile  string
+		caFile   string
+		wantErr  bool
+	}{
+		{"", "", "", false},
+		{cf, kf, "", false},
+		{cf, kf, cf, false},
+		{cf, filepath.Join(dir, "missing.key"), "", true},
+		{"", "", filepath.Join(dir, "missing.crt"), true},
+		{"", "", kf, true},
+	} {
+		_, err := LoadClientTLSConfig(test.certFile, test.keyFile, test.caFile)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected result for %q, %q, %q. Wanted error %t, got %v", test.certFile, test.keyFile, test.caFile, test.wantErr, err)
+		}
+	}
+}
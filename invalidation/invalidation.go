@@ -0,0 +1,171 @@
+// Package invalidation sends the evictions and purges of the upstream token info cache and the pushed
+// revocations to all instances through a Redis pub/sub channel, so that a token revoked on one instance
+// is not accepted by the others until its cache entry expires
+package invalidation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/url"
+
+	"github.com/go-redis/redis"
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// Types of the events sent through the channel
+const (
+	EventEvict       = "evict"
+	EventPurge       = "purge"
+	EventRevocations = "revocations"
+)
+
+// An Event is sent to the other instances. Origin identifies the instance that sent it
+type Event struct {
+	Origin      string          `json:"origin"`
+	Type        string          `json:"type"`
+	Key         string          `json:"key,omitempty"`
+	Revocations json.RawMessage `json:"revocations,omitempty"`
+}
+
+// A Bus publishes the events of this instance and receives the events of the other instances
+type Bus struct {
+	client  *redis.Client
+	pubsub  *redis.PubSub
+	channel string
+	origin  string
+}
+
+// New returns a Bus on the channel of the Redis server at u. It fails if the channel can't be subscribed
+func New(u *url.URL, channel string) (*Bus, error) {
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	pubsub := client.Subscribe(channel)
+	// the first reply confirms the subscription
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		client.Close()
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Bus{client: client, pubsub: pubsub, channel: channel, origin: hex.EncodeToString(id)}, nil
+}
+
+// Publish sends ev to the other instances. Failures are logged, the event is lost
+func (b *Bus) Publish(ev Event) {
+	ev.Origin = b.origin
+	data, err := json.Marshal(ev)
+	if err == nil {
+		err = b.client.Publish(b.channel, data).Err()
+	}
+	if err != nil {
+		slog.Warn("Failed to publish an invalidation event", "type", ev.Type, "error", err)
+		incCounter("planb.tokeninfo.invalidation.errors")
+		return
+	}
+	incCounter("planb.tokeninfo.invalidation.published." + ev.Type)
+}
+
+// Listen calls handle with every event of the other instances until the Bus is closed. The Redis client
+// subscribes again after connection failures, events sent in the meantime are lost
+func (b *Bus) Listen(handle func(Event)) {
+	go func() {
+		for msg := range b.pubsub.Channel() {
+			var ev Event
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				slog.Warn("Failed to read an invalidation event", "error", err)
+				incCounter("planb.tokeninfo.invalidation.errors")
+				continue
+			}
+			if ev.Origin == b.origin {
+				continue
+			}
+			incCounter("planb.tokeninfo.invalidation.received." + ev.Type)
+			handle(ev)
+		}
+	}()
+}
+
+// Close unsubscribes from the channel
+func (b *Bus) Close() error {
+	b.pubsub.Close()
+	return b.client.Close()
+}
+
+// Sync keeps cache and crp in sync with the other instances: the evictions and purges of the returned
+// Cache and the revocations pushed to crp are published, and the events of the other instances are applied
+// to cache and crp
+func (b *Bus) Sync(cache tokencache.Cache, crp *revoke.CachingRevokeProvider) tokencache.Cache {
+	crp.OnPush(func(data []byte) {
+		b.Publish(Event{Type: EventRevocations, Revocations: data})
+	})
+	b.Listen(func(ev Event) {
+		var err error
+		switch ev.Type {
+		case EventEvict:
+			if cache != nil {
+				err = cache.Delete(ev.Key)
+			}
+		case EventPurge:
+			if cache != nil {
+				err = cache.Purge()
+			}
+		case EventRevocations:
+			_, err = crp.ApplyRevocations(ev.Revocations)
+		default:
+			slog.Warn("Unknown invalidation event", "type", ev.Type)
+		}
+		if err != nil {
+			slog.Warn("Failed to apply an invalidation event", "type", ev.Type, "error", err)
+			incCounter("planb.tokeninfo.invalidation.errors")
+		}
+	})
+	if cache == nil {
+		return nil
+	}
+	return &publishingCache{Cache: cache, bus: b}
+}
+
+// publishingCache publishes its evictions and purges
+type publishingCache struct {
+	tokencache.Cache
+	bus *Bus
+}
+
+func (c *publishingCache) Delete(key string) error {
+	if err := c.Cache.Delete(key); err != nil {
+		return err
+	}
+	c.bus.Publish(Event{Type: EventEvict, Key: key})
+	return nil
+}
+
+func (c *publishingCache) Purge() error {
+	if err := c.Cache.Purge(); err != nil {
+		return err
+	}
+	c.bus.Publish(Event{Type: EventPurge})
+	return nil
+}
+
+// Close closes the wrapped cache when it can be closed
+func (c *publishingCache) Close() error {
+	if closer, ok := c.Cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,108 @@
+package invalidation
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// waitFor polls cond, events are applied asynchronously
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+type instance struct {
+	bus   *Bus
+	cache tokencache.Cache
+	local tokencache.Cache
+	crp   *revoke.CachingRevokeProvider
+}
+
+func newInstance(t *testing.T, u *url.URL) *instance {
+	bus, err := New(u, "invalidation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, _ := url.Parse("localhost")
+	i := &instance{bus: bus, local: tokencache.NewMemoryCache(10), crp: revoke.NewCachingRevokeProvider(rp)}
+	i.cache = bus.Sync(i.local, i.crp)
+	return i
+}
+
+func TestSync(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	u, _ := url.Parse("redis://" + s.Addr())
+
+	a, b := newInstance(t, u), newInstance(t, u)
+	defer a.bus.Close()
+	defer b.bus.Close()
+	for _, i := range []*instance{a, b} {
+		i.cache.Set("foo", []byte("foo"), time.Minute)
+		i.cache.Set("bar", []byte("bar"), time.Minute)
+	}
+
+	if err := a.cache.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, err := b.local.Get("foo"); return err != nil }) {
+		t.Error("Evicted entry should be removed from the other instance")
+	}
+	if _, err := b.local.Get("bar"); err != nil {
+		t.Errorf("Other entries should be kept: %v", err)
+	}
+
+	if err := b.cache.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, err := a.local.Get("bar"); return err != nil }) {
+		t.Error("Purge should remove the entries of the other instance")
+	}
+
+	if err := a.crp.RevokeIssuedBefore(100000); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return b.crp.IssuedBefore() == 100000 }) {
+		t.Errorf("Pushed revocation should be applied by the other instance, got cut-off %d", b.crp.IssuedBefore())
+	}
+}
+
+func TestOwnEventsIgnored(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	u, _ := url.Parse("redis://" + s.Addr())
+
+	a := newInstance(t, u)
+	defer a.bus.Close()
+	received := make(chan Event, 1)
+	a.bus.Listen(func(ev Event) { received <- ev })
+
+	a.bus.Publish(Event{Type: EventPurge})
+	select {
+	case ev := <-received:
+		t.Errorf("Events of the same instance should be ignored, got %v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNewFailure(t *testing.T) {
+	u, _ := url.Parse("redis://127.0.0.1:1")
+	if _, err := New(u, "invalidation"); err == nil {
+		t.Error("Wanted an error without a Redis server")
+	}
+}
@@ -0,0 +1,117 @@
+// Package jwe decrypts JSON Web Encryption tokens in the compact serialization (RFC 7516), for ex. JWTs that an
+// identity provider encrypted for this service. The content encryption key is managed with RSA-OAEP, RSA-OAEP-256
+// or ECDH-ES and the content is encrypted with AES GCM, see RFC 7518. The tokens are decrypted with go-jose
+package jwe
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+var (
+	// ErrMalformed is returned for tokens that are not in the JWE compact serialization
+	ErrMalformed = errors.New("Malformed JWE token")
+	// ErrUnsupported is returned for tokens with an unsupported algorithm, encryption or compression
+	ErrUnsupported = errors.New("Unsupported JWE algorithm")
+	// ErrUnknownKey is returned when there is no key with the kid of the token, or when a token without a kid
+	// could be for more than one key
+	ErrUnknownKey = errors.New("Unknown JWE key")
+	// ErrDecryption is returned when the key can't decrypt the token. It doesn't tell which step failed
+	ErrDecryption = errors.New("Failed to decrypt the JWE token")
+)
+
+var (
+	keyAlgorithms      = []jose.KeyAlgorithm{jose.RSA_OAEP, jose.RSA_OAEP_256, jose.ECDH_ES}
+	contentEncryptions = []jose.ContentEncryption{jose.A128GCM, jose.A192GCM, jose.A256GCM}
+)
+
+// header has the parameters of the protected header that are checked before the token is decrypted
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Kid string `json:"kid"`
+	Zip string `json:"zip"`
+}
+
+// A Decrypter decrypts tokens with its private keys
+type Decrypter struct {
+	keys map[string]interface{}
+}
+
+// NewDecrypter returns a Decrypter for the *rsa.PrivateKey and *ecdsa.PrivateKey keys, by key id
+func NewDecrypter(keys map[string]interface{}) (*Decrypter, error) {
+	d := &Decrypter{keys: make(map[string]interface{}, len(keys))}
+	for kid, key := range keys {
+		switch key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			d.keys[kid] = key
+		default:
+			return nil, fmt.Errorf("key %q: unsupported key type %T", kid, key)
+		}
+	}
+	return d, nil
+}
+
+// IsJWE returns true if the token has the five parts of the JWE compact serialization
+func IsJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// Decrypt returns the plaintext of the token, usually a signed JWT. Tokens are decrypted with the key of their
+// kid. Only a Decrypter with a single key accepts tokens without a kid, so that every token costs at most one
+// decryption
+func (d *Decrypter) Decrypt(token string) ([]byte, error) {
+	if !IsJWE(token) {
+		return nil, ErrMalformed
+	}
+	protected, _, _ := strings.Cut(token, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(protected)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if !slices.Contains(keyAlgorithms, jose.KeyAlgorithm(h.Alg)) || !slices.Contains(contentEncryptions, jose.ContentEncryption(h.Enc)) || h.Zip != "" {
+		return nil, fmt.Errorf("%w: %s %s %s", ErrUnsupported, h.Alg, h.Enc, h.Zip)
+	}
+	obj, err := jose.ParseEncryptedCompact(token, keyAlgorithms, contentEncryptions)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, err := d.key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := obj.Decrypt(key)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+// key returns the key of the kid, or the only key for tokens without a kid
+func (d *Decrypter) key(kid string) (interface{}, error) {
+	if kid == "" {
+		if len(d.keys) != 1 {
+			return nil, fmt.Errorf("%w: the token has no kid", ErrUnknownKey)
+		}
+		for _, key := range d.keys {
+			return key, nil
+		}
+	}
+	key, has := d.keys[kid]
+	if !has {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,125 @@
+package jwe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+var b64 = base64.RawURLEncoding
+
+// encrypt builds a JWE token for the public key of key, like an identity provider would
+func encrypt(t *testing.T, plaintext string, alg string, enc string, kid string, key interface{}) string {
+	var pub interface{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		pub = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		pub = &k.PublicKey
+	}
+	opts := (&jose.EncrypterOptions{}).WithContentType("JWT")
+	e, err := jose.NewEncrypter(jose.ContentEncryption(enc), jose.Recipient{Algorithm: jose.KeyAlgorithm(alg), Key: pub, KeyID: kid}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := e.Encrypt([]byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestDecrypt(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	d, err := NewDecrypter(map[string]interface{}{"rsa": rsaKey, "ec": ecKey, "ec384": ec384Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const jws = "header.claims.signature"
+	for _, test := range []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"RSA-OAEP", encrypt(t, jws, "RSA-OAEP", "A128GCM", "rsa", rsaKey), nil},
+		{"RSA-OAEP-256", encrypt(t, jws, "RSA-OAEP-256", "A256GCM", "rsa", rsaKey), nil},
+		{"ECDH-ES", encrypt(t, jws, "ECDH-ES", "A128GCM", "ec", ecKey), nil},
+		{"ECDH-ES P-384", encrypt(t, jws, "ECDH-ES", "A256GCM", "ec384", ec384Key), nil},
+		{"without kid", encrypt(t, jws, "ECDH-ES", "A192GCM", "", ec384Key), ErrUnknownKey},
+		{"other key", encrypt(t, jws, "RSA-OAEP", "A128GCM", "rsa", otherKey), ErrDecryption},
+		{"wrong kid", encrypt(t, jws, "ECDH-ES", "A128GCM", "ec384", ecKey), ErrDecryption},
+		{"unknown kid", encrypt(t, jws, "RSA-OAEP", "A128GCM", "unknown", rsaKey), ErrUnknownKey},
+		{"unsupported alg", encrypt(t, jws, "RSA1_5", "A128GCM", "rsa", rsaKey), ErrUnsupported},
+		{"unsupported enc", "eyJhbGciOiJSU0EtT0FFUCIsImVuYyI6IkExMjhDQkMtSFMyNTYifQ.AA.AA.AA.AA", ErrUnsupported},
+		{"JWS", "eyJhbGciOiJSUzI1NiJ9.e30.c2ln", ErrMalformed},
+		{"invalid base64", b64.EncodeToString([]byte(`{"alg":"RSA-OAEP","enc":"A128GCM","kid":"rsa"}`)) + ".!.b.c.d", ErrMalformed},
+	} {
+		plaintext, err := d.Decrypt(test.token)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("Wrong error for %s. Wanted %v, got %v", test.name, test.wantErr, err)
+		}
+		if err == nil && string(plaintext) != jws {
+			t.Errorf("Wrong plaintext for %s: %q", test.name, plaintext)
+		}
+	}
+}
+
+func TestTamperedToken(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	d, _ := NewDecrypter(map[string]interface{}{"rsa": key})
+	token := encrypt(t, "header.claims.signature", "RSA-OAEP", "A128GCM", "rsa", key)
+	parts := strings.Split(token, ".")
+	for i := range parts {
+		tampered := append([]string(nil), parts...)
+		b, _ := b64.DecodeString(tampered[i])
+		if i == 0 {
+			// the same header in another encoding changes the authenticated data
+			tampered[i] = b64.EncodeToString(append(b, ' '))
+		} else {
+			b[len(b)/2] ^= 1
+			tampered[i] = b64.EncodeToString(b)
+		}
+		if _, err := d.Decrypt(strings.Join(tampered, ".")); err != ErrDecryption {
+			t.Errorf("Tampering with part %d should fail the decryption, got %v", i, err)
+		}
+	}
+}
+
+func TestWithoutKid(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	d, _ := NewDecrypter(map[string]interface{}{"rsa": key})
+	if plaintext, err := d.Decrypt(encrypt(t, "header.claims.signature", "RSA-OAEP", "A128GCM", "", key)); err != nil || string(plaintext) != "header.claims.signature" {
+		t.Errorf("A token without kid should be decrypted with the only key, got %q %v", plaintext, err)
+	}
+	if _, err := d.Decrypt(encrypt(t, "header.claims.signature", "RSA-OAEP", "A128GCM", "", otherKey)); err != ErrDecryption {
+		t.Errorf("Wrong error for another key without kid. Wanted %v, got %v", ErrDecryption, err)
+	}
+}
+
+func TestIsJWE(t *testing.T) {
+	for token, want := range map[string]bool{
+		"a.b.c":     false,
+		"a.b.c.d.e": true,
+		"a..c.d.e":  true,
+		"opaque":    false,
+	} {
+		if got := IsJWE(token); got != want {
+			t.Errorf("Wrong result for %q. Wanted %v, got %v", token, want, got)
+		}
+	}
+}
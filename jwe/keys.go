@@ -0,0 +1,41 @@
+package jwe
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadDecrypter returns a Decrypter for the private keys in the PEM files, by key id
+func LoadDecrypter(files map[string]string) (*Decrypter, error) {
+	keys := make(map[string]interface{}, len(files))
+	for kid, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if keys[kid], err = parsePrivateKey(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return NewDecrypter(keys)
+}
+
+// parsePrivateKey returns the first private key of the PEM data
+func parsePrivateKey(data []byte) (interface{}, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, fmt.Errorf("no private key found")
+		}
+		switch block.Type {
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package jwe
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDecrypter(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, blocks ...*pem.Block) string {
+		var data []byte
+		for _, b := range blocks {
+			data = append(data, pem.EncodeToMemory(b)...)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	cert := &pem.Block{Type: "CERTIFICATE", Bytes: []byte("ignored")}
+	rsaFile := write("rsa.pem", cert, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	ecFile := write("ec.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})
+	edFile := write("ed.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: edDER})
+	certFile := write("cert.pem", cert)
+
+	d, err := LoadDecrypter(map[string]string{"rsa": rsaFile, "ec": ecFile})
+	if err != nil {
+		t.Fatal("Failed to load the keys: ", err)
+	}
+	token := encrypt(t, "header.claims.signature", "ECDH-ES", "A128GCM", "ec", ecKey)
+	if _, err := d.Decrypt(token); err != nil {
+		t.Error("Failed to decrypt with the loaded key: ", err)
+	}
+
+	for name, files := range map[string]map[string]string{
+		"Ed25519 key":    {"ed": edFile},
+		"no private key": {"cert": certFile},
+		"missing file":   {"missing": filepath.Join(dir, "missing.pem")},
+	} {
+		if _, err := LoadDecrypter(files); err == nil {
+			t.Errorf("Loading a file with %s should fail", name)
+		}
+	}
+}
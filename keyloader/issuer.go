@@ -0,0 +1,72 @@
+package keyloader
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownIssuer should be used when a token issuer has no key set and there is no default key set
+var ErrUnknownIssuer = errors.New("Unknown token issuer")
+
+// An IssuerKeyLoader is a KeyLoader that keeps a separate set of keys per token issuer
+type IssuerKeyLoader interface {
+	KeyLoader
+	LoadIssuerKey(issuer string, id string) (interface{}, error)
+}
+
+type issuerKeyLoader struct {
+	defaultLoader KeyLoader
+	issuers       map[string]KeyLoader
+}
+
+// NewIssuerKeyLoader returns an IssuerKeyLoader that looks up the keys of the issuers in the map with
+// their own KeyLoader. Keys from any other issuer are looked up with the default KeyLoader, which may be
+// nil to only accept the issuers in the map
+func NewIssuerKeyLoader(defaultLoader KeyLoader, issuers map[string]KeyLoader) IssuerKeyLoader {
+	return &issuerKeyLoader{defaultLoader: defaultLoader, issuers: issuers}
+}
+
+// LoadIssuerKey returns the key with the given id from the key set of the issuer, which is refreshed if it
+// doesn't have the key yet. See FetchKey
+func (kl *issuerKeyLoader) LoadIssuerKey(issuer string, id string) (interface{}, error) {
+	if l, has := kl.issuers[issuer]; has {
+		return FetchKey(l, id)
+	}
+	if kl.defaultLoader == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIssuer, issuer)
+	}
+	return FetchKey(kl.defaultLoader, id)
+}
+
+// LoadKey returns the key with the given id from the default key set
+func (kl *issuerKeyLoader) LoadKey(id string) (interface{}, error) {
+	return kl.LoadIssuerKey("", id)
+}
+
+// Keys returns the keys of all issuers. Keys from the default KeyLoader take precedence when the same
+// id is used by more than one issuer
+func (kl *issuerKeyLoader) Keys() map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, l := range kl.issuers {
+		for id, k := range l.Keys() {
+			m[id] = k
+		}
+	}
+	if kl.defaultLoader != nil {
+		for id, k := range kl.defaultLoader.Keys() {
+			m[id] = k
+		}
+	}
+	return m
+}
+
+// LastRefresh returns the oldest last refresh time of all the key loaders that are a Refresher, so that a
+// single stale key set is noticed
+func (kl *issuerKeyLoader) LastRefresh() time.Time {
+	loaders := []KeyLoader{kl.defaultLoader}
+	for _, l := range kl.issuers {
+		loaders = append(loaders, l)
+	}
+	return oldestRefresh(loaders)
+}
@@ -0,0 +1,85 @@
+package keyloader
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type mapKeyLoader map[string]interface{}
+
+func (m mapKeyLoader) LoadKey(id string) (interface{}, error) {
+	if k, has := m[id]; has {
+		return k, nil
+	}
+	return nil, fmt.Errorf("Key '%s' not found", id)
+}
+
+func (m mapKeyLoader) Keys() map[string]interface{} {
+	return m
+}
+
+func TestIssuerKeyLoader(t *testing.T) {
+	def := mapKeyLoader{"k1": "default-k1", "shared": "default-shared"}
+	idp := mapKeyLoader{"k2": "idp-k2", "shared": "idp-shared"}
+	issuers := map[string]KeyLoader{"https://idp.example.org": idp}
+
+	for _, test := range []struct {
+		kl        IssuerKeyLoader
+		issuer    string
+		id        string
+		want      interface{}
+		wantError bool
+	}{
+		{NewIssuerKeyLoader(def, issuers), "https://idp.example.org", "k2", "idp-k2", false},
+		{NewIssuerKeyLoader(def, issuers), "https://idp.example.org", "shared", "idp-shared", false},
+		{NewIssuerKeyLoader(def, issuers), "https://idp.example.org", "k1", nil, true},
+		{NewIssuerKeyLoader(def, issuers), "https://other.example.org", "k1", "default-k1", false},
+		{NewIssuerKeyLoader(def, issuers), "", "shared", "default-shared", false},
+		{NewIssuerKeyLoader(nil, issuers), "https://other.example.org", "k1", nil, true},
+		{NewIssuerKeyLoader(nil, issuers), "https://idp.example.org", "k2", "idp-k2", false},
+	} {
+		k, err := test.kl.LoadIssuerKey(test.issuer, test.id)
+		if test.wantError != (err != nil) {
+			t.Errorf("Unexpected error loading %q from %q: %v", test.id, test.issuer, err)
+		}
+		if k != test.want {
+			t.Errorf("Wrong key loaded for %q from %q. Wanted %v, got %v", test.id, test.issuer, test.want, k)
+		}
+	}
+
+	keys := NewIssuerKeyLoader(def, issuers).Keys()
+	if len(keys) != 3 || keys["shared"] != "default-shared" || keys["k2"] != "idp-k2" {
+		t.Errorf("Wrong set of keys: %v", keys)
+	}
+
+	if len(NewIssuerKeyLoader(nil, issuers).Keys()) != 2 {
+		t.Error("Keys without a default loader should only contain the issuer keys")
+	}
+}
+
+type refreshingKeyLoader struct {
+	mapKeyLoader
+	last time.Time
+}
+
+func (r refreshingKeyLoader) LastRefresh() time.Time {
+	return r.last
+}
+
+func TestIssuerLastRefresh(t *testing.T) {
+	now := time.Now()
+	def := refreshingKeyLoader{last: now}
+	stale := refreshingKeyLoader{last: now.Add(-time.Hour)}
+
+	if r := NewIssuerKeyLoader(mapKeyLoader{}, nil).(Refresher).LastRefresh(); !r.IsZero() {
+		t.Errorf("Wrong last refresh without refreshers: %v", r)
+	}
+	if r := NewIssuerKeyLoader(def, nil).(Refresher).LastRefresh(); !r.Equal(now) {
+		t.Errorf("Wrong last refresh. Wanted %v, got %v", now, r)
+	}
+	issuers := map[string]KeyLoader{"https://idp.example.org": stale, "https://other.example.org": mapKeyLoader{}}
+	if r := NewIssuerKeyLoader(def, issuers).(Refresher).LastRefresh(); !r.Equal(stale.last) {
+		t.Errorf("The oldest refresh should be reported. Wanted %v, got %v", stale.last, r)
+	}
+}
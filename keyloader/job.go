@@ -1,10 +1,17 @@
 package keyloader
 
-import "time"
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
 
 // JobFunc is a type that defines a zero argument function
 type JobFunc func()
 
+// RefreshFunc is a job that returns whether it succeeded
+type RefreshFunc func() bool
+
 // Schedule executes the job in regular intervals. The task is left running in the background
 func Schedule(interval time.Duration, job JobFunc) {
 	go func() {
@@ -14,3 +21,105 @@ func Schedule(interval time.Duration, job JobFunc) {
 		}
 	}()
 }
+
+// SchedulerConfig has the intervals of a Scheduler
+type SchedulerConfig struct {
+	// Interval between successful runs
+	Interval time.Duration
+	// Jitter is the fraction by which every wait randomly varies, so that many instances don't run together
+	Jitter float64
+	// Backoff is the wait after the first failure, it doubles with every consecutive failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MinTriggerInterval is the minimum time between the last run and a triggered one. Triggers are
+	// disabled if 0
+	MinTriggerInterval time.Duration
+}
+
+// A Scheduler executes a job in jittered intervals, backs off exponentially after failures and can be
+// triggered to run early
+type Scheduler struct {
+	config  SchedulerConfig
+	job     RefreshFunc
+	trigger chan struct{}
+
+	mu      sync.Mutex
+	lastRun time.Time
+	// closed when the triggered run finished, nil if no run was triggered
+	triggered chan struct{}
+}
+
+// ScheduleWithBackoff executes the job right away and then as configured. The task is left running in the background
+func ScheduleWithBackoff(config SchedulerConfig, job RefreshFunc) *Scheduler {
+	s := &Scheduler{config: config, job: job, trigger: make(chan struct{}, 1)}
+	go s.run()
+	return s
+}
+
+func (s *Scheduler) run() {
+	failures := 0
+	for {
+		s.mu.Lock()
+		s.lastRun = time.Now()
+		done := s.triggered
+		s.mu.Unlock()
+		if s.job() {
+			failures = 0
+		} else {
+			failures++
+		}
+		if done != nil {
+			s.mu.Lock()
+			close(done)
+			s.triggered = nil
+			// a trigger that raced with the timer was served by this run
+			select {
+			case <-s.trigger:
+			default:
+			}
+			s.mu.Unlock()
+		}
+		t := time.NewTimer(s.wait(failures, rand.Float64()))
+		select {
+		case <-t.C:
+		case <-s.trigger:
+			t.Stop()
+		}
+	}
+}
+
+// wait returns the time until the next run after the number of consecutive failures, varied by the jitter
+// with the random number r in [0, 1)
+func (s *Scheduler) wait(failures int, r float64) time.Duration {
+	d := s.config.Interval
+	if failures > 0 {
+		d = s.config.Backoff
+		for i := 1; i < failures && d < s.config.MaxBackoff; i++ {
+			d *= 2
+		}
+		if d > s.config.MaxBackoff {
+			d = s.config.MaxBackoff
+		}
+	}
+	return time.Duration(float64(d) * (1 + s.config.Jitter*(2*r-1)))
+}
+
+// Trigger runs the job early and returns a channel that is closed when the run finished. Callers share a
+// run that was already triggered, triggered is only true for the caller that triggered it. The channel is
+// nil if triggers are disabled or if the last run was less than MinTriggerInterval ago
+func (s *Scheduler) Trigger() (done <-chan struct{}, triggered bool) {
+	if s.config.MinTriggerInterval <= 0 {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.triggered != nil {
+		return s.triggered, false
+	}
+	if time.Since(s.lastRun) < s.config.MinTriggerInterval {
+		return nil, false
+	}
+	s.triggered = make(chan struct{})
+	s.trigger <- struct{}{}
+	return s.triggered, true
+}
@@ -1,15 +1,101 @@
 package keyloader
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestScheduling(t *testing.T) {
-	c := 0
-	Schedule(time.Millisecond, func() { c++ })
+	var c atomic.Int32
+	Schedule(time.Millisecond, func() { c.Add(1) })
 	time.Sleep(time.Millisecond * 2)
-	if c == 0 {
+	if c.Load() == 0 {
 		t.Error("Job is not being executed")
 	}
 }
+
+func TestSchedulerWait(t *testing.T) {
+	s := &Scheduler{config: SchedulerConfig{Interval: time.Minute, Jitter: 0.1, Backoff: time.Second, MaxBackoff: 10 * time.Second}}
+	for _, test := range []struct {
+		failures int
+		r        float64
+		want     time.Duration
+	}{
+		{0, 0.5, time.Minute},
+		{0, 0, 54 * time.Second},
+		{0, 1, 66 * time.Second},
+		{1, 0.5, time.Second},
+		{2, 0.5, 2 * time.Second},
+		{4, 0.5, 8 * time.Second},
+		{5, 0.5, 10 * time.Second},
+		{100, 0.5, 10 * time.Second},
+		{100, 0, 9 * time.Second},
+	} {
+		if got := s.wait(test.failures, test.r); got != test.want {
+			t.Errorf("Wrong wait after %d failures. Wanted %v, got %v", test.failures, test.want, got)
+		}
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	runs := make(chan bool, 10)
+	failures := 2
+	ScheduleWithBackoff(SchedulerConfig{Interval: time.Hour, Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}, func() bool {
+		failures--
+		runs <- failures < 0
+		return failures < 0
+	})
+	for i, want := range []bool{false, false, true} {
+		select {
+		case ok := <-runs:
+			if ok != want {
+				t.Errorf("Wrong result of run %d. Wanted %v, got %v", i, want, ok)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Failed run %d wasn't retried", i)
+		}
+	}
+	select {
+	case <-runs:
+		t.Error("A successful run should wait for the interval")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSchedulerTrigger(t *testing.T) {
+	runs := make(chan struct{}, 10)
+	job := func() bool {
+		runs <- struct{}{}
+		return true
+	}
+	if done, _ := ScheduleWithBackoff(SchedulerConfig{Interval: time.Hour}, job).Trigger(); done != nil {
+		t.Error("Triggers should be disabled without a minimum interval")
+	}
+	<-runs
+
+	s := ScheduleWithBackoff(SchedulerConfig{Interval: time.Hour, MinTriggerInterval: 50 * time.Millisecond}, job)
+	<-runs
+	if done, _ := s.Trigger(); done != nil {
+		t.Error("A trigger right after a run should be rejected")
+	}
+	time.Sleep(60 * time.Millisecond)
+	done, triggered := s.Trigger()
+	if done == nil || !triggered {
+		t.Fatal("A trigger after the minimum interval should run the job")
+	}
+	if shared, triggered := s.Trigger(); shared != done || triggered {
+		t.Error("A second trigger should share the triggered run")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The triggered job didn't finish")
+	}
+	if len(runs) != 1 {
+		t.Errorf("The job should run once for both triggers, got %d runs", len(runs))
+	}
+	if done, _ := s.Trigger(); done != nil {
+		t.Error("A trigger right after the triggered run should be rejected")
+	}
+}
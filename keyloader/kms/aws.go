@@ -0,0 +1,143 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+// ErrMissingRegion is returned for AWS KMS key ids that are not ARNs when no region is configured
+var ErrMissingRegion = errors.New("Missing AWS region")
+
+// AWSConfig are the AWS KMS keys by key id and the credentials used to get their public keys
+type AWSConfig struct {
+	// Keys are key ARNs, or key ids and aliases in Region, by the kid of the tokens they sign
+	Keys   map[string]string
+	Region string
+	// Endpoint replaces the regional KMS endpoint, for ex. with a VPC endpoint
+	Endpoint        *url.URL
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// NewAWSLoader returns a KeyLoader with the public keys of the AWS KMS keys, fetched with GetPublicKey
+func NewAWSLoader(c AWSConfig) (keyloader.KeyLoader, error) {
+	client := ht.DefaultHTTPClient()
+	return load(c.Keys, func(key string) (interface{}, error) {
+		return c.getPublicKey(client, key)
+	})
+}
+
+func (c AWSConfig) getPublicKey(client *http.Client, key string) (interface{}, error) {
+	region := c.Region
+	// arn:aws:kms:REGION:ACCOUNT:key/ID
+	if parts := strings.Split(key, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return nil, ErrMissingRegion
+	}
+	endpoint := fmt.Sprintf("https://kms.%s.amazonaws.com/", region)
+	if c.Endpoint != nil {
+		endpoint = c.Endpoint.String()
+	}
+
+	body, _ := json.Marshal(map[string]string{"KeyId": key})
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.GetPublicKey")
+	req.Header.Set("User-Agent", ht.UserAgent)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	signV4(req, body, c.AccessKeyID, c.SecretAccessKey, region, "kms", time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	var pk struct {
+		PublicKey []byte
+	}
+	if err := json.Unmarshal(b, &pk); err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(pk.PublicKey)
+}
+
+// signV4 adds the AWS Signature Version 4 Authorization header to req. All the headers of the request and
+// the host are signed
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k == "User-Agent" {
+			continue
+		}
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.Query().Encode(), canonicalHeaders.String(), signedHeaders, hexSHA256(body),
+	}, "\n")
+	scope := strings.Join([]string{day, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), day)
+	for _, s := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
@@ -0,0 +1,77 @@
+package kms
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the get-vanilla case of the AWS Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Wrong signature.\nWanted %s\ngot    %s", want, got)
+	}
+}
+
+func TestAWSLoader(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	arn := "arn:aws:kms:eu-central-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct{ KeyId string }
+		json.NewDecoder(req.Body).Decode(&body)
+		auth := req.Header.Get("Authorization")
+		switch {
+		case req.Header.Get("X-Amz-Target") != "TrentService.GetPublicKey" || req.Header.Get("X-Amz-Security-Token") != "session":
+			w.WriteHeader(http.StatusBadRequest)
+		case !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-central-1/kms/aws4_request"):
+			w.WriteHeader(http.StatusForbidden)
+		case body.KeyId != arn:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"NotFoundException","message":"Key not found"}`))
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"KeyId": arn, "PublicKey": der, "KeySpec": "ECC_NIST_P256"})
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	c := AWSConfig{Keys: map[string]string{"signer": arn}, Endpoint: u, AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"}
+	kl, err := NewAWSLoader(c)
+	if err != nil {
+		t.Fatal("Failed to load the public key: ", err)
+	}
+	if k, err := kl.LoadKey("signer"); err != nil || !key.PublicKey.Equal(k) {
+		t.Errorf("Wrong public key: %v", err)
+	}
+	if _, err := kl.LoadKey(arn); err == nil {
+		t.Error("Keys should only be found by their kid")
+	}
+	if len(kl.Keys()) != 1 {
+		t.Errorf("Wrong number of keys: %v", kl.Keys())
+	}
+
+	c.Keys = map[string]string{"signer": "alias/missing"}
+	if _, err := NewAWSLoader(c); !errors.Is(err, ErrMissingRegion) {
+		t.Errorf("Wanted a missing region error, got %v", err)
+	}
+	c.Region = "eu-central-1"
+	if _, err := NewAWSLoader(c); err == nil || !strings.Contains(err.Error(), "NotFoundException") {
+		t.Errorf("Wanted the KMS error, got %v", err)
+	}
+}
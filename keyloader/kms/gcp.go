@@ -0,0 +1,137 @@
+package kms
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+const gcpScope = "https://www.googleapis.com/auth/cloudkms"
+
+var (
+	// ErrInvalidPublicKey is returned when Cloud KMS doesn't return a PEM encoded public key
+	ErrInvalidPublicKey = errors.New("Invalid public key")
+
+	gcpEndpoint = "https://cloudkms.googleapis.com/"
+	// metadataTokenURL returns an access token of the default service account on Google Cloud
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCPConfig are the Cloud KMS key versions by key id and the credentials used to get their public keys
+type GCPConfig struct {
+	// Keys are the resource names of the key versions, for ex.
+	// projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/1, by the kid of the tokens they sign
+	Keys map[string]string
+	// CredentialsFile is a service account key file. Without it, the default service account of the
+	// metadata server is used
+	CredentialsFile string
+}
+
+// NewGCPLoader returns a KeyLoader with the public keys of the Cloud KMS key versions
+func NewGCPLoader(c GCPConfig) (keyloader.KeyLoader, error) {
+	client := ht.DefaultHTTPClient()
+	token, err := gcpAccessToken(client, c.CredentialsFile)
+	if err != nil {
+		incCounter("planb.kms.errors")
+		return nil, err
+	}
+	return load(c.Keys, func(name string) (interface{}, error) {
+		req, err := http.NewRequest(http.MethodGet, gcpEndpoint+"v1/"+strings.Trim(name, "/")+"/publicKey", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", ht.UserAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := readResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		var pk struct {
+			Pem string `json:"pem"`
+		}
+		if err := json.Unmarshal(b, &pk); err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode([]byte(pk.Pem))
+		if block == nil {
+			return nil, ErrInvalidPublicKey
+		}
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	})
+}
+
+// gcpAccessToken returns an access token for Cloud KMS, from the metadata server or by exchanging a JWT
+// signed with the key of the service account in credentialsFile
+func gcpAccessToken(client *http.Client, credentialsFile string) (string, error) {
+	var req *http.Request
+	var err error
+	if credentialsFile == "" {
+		if req, err = http.NewRequest(http.MethodGet, metadataTokenURL, nil); err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		data, err := ioutil.ReadFile(credentialsFile)
+		if err != nil {
+			return "", err
+		}
+		var sa struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+			TokenURI    string `json:"token_uri"`
+		}
+		if err := json.Unmarshal(data, &sa); err != nil {
+			return "", err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sa.PrivateKey))
+		if err != nil {
+			return "", err
+		}
+		now := time.Now()
+		assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": sa.ClientEmail, "scope": gcpScope, "aud": sa.TokenURI, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+		}).SignedString(key)
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		if req, err = http.NewRequest(http.MethodPost, sa.TokenURI, strings.NewReader(form.Encode())); err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", ht.UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	b, err := readResponse(resp)
+	if err != nil {
+		return "", err
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return "", err
+	}
+	if t.AccessToken == "" {
+		return "", errors.New("No access token in the response")
+	}
+	return t.AccessToken, nil
+}
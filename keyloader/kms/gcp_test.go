@@ -0,0 +1,92 @@
+package kms
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestGCPLoader(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	saKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	name := "projects/p/locations/europe-west3/keyRings/tokens/cryptoKeys/signer/cryptoKeyVersions/1"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/metadata/token":
+			if req.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"metadata-token","token_type":"Bearer"}`)
+		case "/oauth2/token":
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(req.FormValue("assertion"), claims, func(*jwt.Token) (interface{}, error) { return &saKey.PublicKey, nil })
+			if err != nil || claims["iss"] != "tokeninfo@p.iam.gserviceaccount.com" || claims["scope"] != gcpScope {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"sa-token","token_type":"Bearer"}`)
+		case "/v1/" + name + "/publicKey":
+			if a := req.Header.Get("Authorization"); a != "Bearer metadata-token" && a != "Bearer sa-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"pem": publicKey, "algorithm": "EC_SIGN_P256_SHA256", "name": name})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(e, m string) { gcpEndpoint, metadataTokenURL = e, m }(gcpEndpoint, metadataTokenURL)
+	gcpEndpoint, metadataTokenURL = server.URL+"/", server.URL+"/metadata/token"
+
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "tokeninfo@p.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(saKey)})),
+		"token_uri":    server.URL + "/oauth2/token",
+	})
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	ioutil.WriteFile(credentialsFile, credentials, 0600)
+
+	for _, test := range []struct {
+		name    string
+		config  GCPConfig
+		wantErr bool
+	}{
+		{"metadata server", GCPConfig{Keys: map[string]string{"signer": name}}, false},
+		{"service account", GCPConfig{Keys: map[string]string{"signer": name}, CredentialsFile: credentialsFile}, false},
+		{"missing key", GCPConfig{Keys: map[string]string{"signer": name + "0"}}, true},
+		{"missing credentials", GCPConfig{Keys: map[string]string{"signer": name}, CredentialsFile: credentialsFile + ".missing"}, true},
+	} {
+		kl, err := NewGCPLoader(test.config)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Wanted an error for %s", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.name, err)
+			continue
+		}
+		if k, err := kl.LoadKey("signer"); err != nil || !key.PublicKey.Equal(k) {
+			t.Errorf("Wrong public key for %s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package kms implements key loaders with the public keys of asymmetric signing keys held in AWS KMS or
+// Google Cloud KMS, for token signers whose keys are not published as a JSON Web Key Set
+package kms
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+// maxBodySize limits the size of KMS responses
+const maxBodySize = 1 << 20
+
+// kmsKeyLoader has the public keys by key id. The public key of a KMS key version never changes, so the
+// keys are only loaded once
+type kmsKeyLoader struct {
+	keys map[string]interface{}
+}
+
+// load returns a KeyLoader with the public key of every KMS key in keys, by key id
+func load(keys map[string]string, fetch func(key string) (interface{}, error)) (keyloader.KeyLoader, error) {
+	kl := &kmsKeyLoader{keys: make(map[string]interface{})}
+	for kid, key := range keys {
+		pub, err := fetch(key)
+		if err != nil {
+			incCounter("planb.kms.errors")
+			return nil, fmt.Errorf("Failed to get the public key of %s: %w", key, err)
+		}
+		kl.keys[kid] = pub
+	}
+	return kl, nil
+}
+
+func (kl *kmsKeyLoader) LoadKey(id string) (interface{}, error) {
+	if k, has := kl.keys[id]; has {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %s", keyloader.ErrKeyNotFound, id)
+}
+
+func (kl *kmsKeyLoader) Keys() map[string]interface{} {
+	m := make(map[string]interface{}, len(kl.keys))
+	for id, k := range kl.keys {
+		m[id] = k
+	}
+	return m
+}
+
+// readResponse returns the body of a successful response or an error with the start of the body
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+	}
+	return body, nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
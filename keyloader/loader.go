@@ -1,8 +1,56 @@
 package keyloader
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrKeyNotFound is returned by LoadKey when there is no key with the requested ID
+var ErrKeyNotFound = errors.New("Key not found")
+
+// ErrNoKeys is returned by WaitForKeys when no key was loaded before the timeout
+var ErrNoKeys = errors.New("No keys loaded")
+
+// how often WaitForKeys checks the keys
+const waitInterval = 100 * time.Millisecond
+
 // A KeyLoader fetches cryptographic keys and is able to lookup them up by ID or return the entire
 // map of known keys
 type KeyLoader interface {
 	LoadKey(id string) (interface{}, error)
 	Keys() map[string]interface{}
 }
+
+// A Refresher is a KeyLoader that refreshes its keys in the background. LastRefresh returns the time of
+// the last successful refresh or the zero time if the keys were never loaded
+type Refresher interface {
+	LastRefresh() time.Time
+}
+
+// A KeyFetcher is a KeyLoader that can refresh its keys to find a key it doesn't know yet, for ex. right
+// after a key rotation. LoadKey only looks up the known keys
+type KeyFetcher interface {
+	FetchKey(id string) (interface{}, error)
+}
+
+// FetchKey returns the key with the given id from the loader, refreshing the keys of a KeyFetcher that
+// doesn't know the key yet
+func FetchKey(kl KeyLoader, id string) (interface{}, error) {
+	k, err := kl.LoadKey(id)
+	if f, ok := kl.(KeyFetcher); ok && errors.Is(err, ErrKeyNotFound) {
+		return f.FetchKey(id)
+	}
+	return k, err
+}
+
+// WaitForKeys blocks until the key loader has at least one key or returns ErrNoKeys after the timeout
+func WaitForKeys(kl KeyLoader, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for len(kl.Keys()) == 0 {
+		if !time.Now().Before(deadline) {
+			return ErrNoKeys
+		}
+		time.Sleep(waitInterval)
+	}
+	return nil
+}
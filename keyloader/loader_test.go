@@ -0,0 +1,40 @@
+package keyloader
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// delayedKeyLoader has its keys once loaded is set
+type delayedKeyLoader struct {
+	mapKeyLoader
+	loaded atomic.Bool
+}
+
+func (d *delayedKeyLoader) Keys() map[string]interface{} {
+	if !d.loaded.Load() {
+		return nil
+	}
+	return d.mapKeyLoader.Keys()
+}
+
+func TestWaitForKeys(t *testing.T) {
+	if err := WaitForKeys(mapKeyLoader{"kid": "key"}, 0); err != nil {
+		t.Errorf("Loaded keys shouldn't be waited for, got %v", err)
+	}
+
+	d := &delayedKeyLoader{mapKeyLoader: mapKeyLoader{"kid": "key"}}
+	start := time.Now()
+	if err := WaitForKeys(d, 150*time.Millisecond); err != ErrNoKeys {
+		t.Errorf("Missing keys should fail after the timeout, got %v", err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("Gave up too early, after %v", waited)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { d.loaded.Store(true) })
+	if err := WaitForKeys(d, time.Second); err != nil {
+		t.Errorf("Keys loaded before the timeout should be found, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package keyloader
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// ErrKeyConflict is returned by LoadKey when merged key sets have different keys with the same ID
+var ErrKeyConflict = errors.New("Conflicting keys with the same ID")
+
+type mergedKeyLoader struct {
+	loaders []KeyLoader
+	// IDs of conflicting keys that were already logged
+	logged sync.Map
+}
+
+// NewMergedKeyLoader returns a KeyLoader that merges the keys of all the loaders into a single key set. A key
+// ID with different keys in more than one loader is rejected, because there is no way to tell which one
+// signed the token
+func NewMergedKeyLoader(loaders ...KeyLoader) KeyLoader {
+	return &mergedKeyLoader{loaders: loaders}
+}
+
+// LoadKey returns the key with the given id from any of the loaders
+func (kl *mergedKeyLoader) LoadKey(id string) (interface{}, error) {
+	var key interface{}
+	for _, l := range kl.loaders {
+		k, err := l.LoadKey(id)
+		if err != nil {
+			continue
+		}
+		if key != nil && !sameKey(key, k) {
+			kl.conflict(id)
+			return nil, fmt.Errorf("%w: %q", ErrKeyConflict, id)
+		}
+		key = k
+	}
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// FetchKey refreshes the keys of all the loaders that are a KeyFetcher at the same time, then returns the key
+// with the given id from any of the loaders
+func (kl *mergedKeyLoader) FetchKey(id string) (interface{}, error) {
+	var wg sync.WaitGroup
+	for _, l := range kl.loaders {
+		if f, ok := l.(KeyFetcher); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f.FetchKey(id)
+			}()
+		}
+	}
+	wg.Wait()
+	return kl.LoadKey(id)
+}
+
+// Keys returns the keys of all the loaders, without the IDs that have conflicting keys
+func (kl *mergedKeyLoader) Keys() map[string]interface{} {
+	m := make(map[string]interface{})
+	conflicts := make(map[string]bool)
+	for _, l := range kl.loaders {
+		for id, k := range l.Keys() {
+			if prev, has := m[id]; has && !sameKey(prev, k) {
+				conflicts[id] = true
+			}
+			m[id] = k
+		}
+	}
+	for id := range conflicts {
+		kl.conflict(id)
+		delete(m, id)
+	}
+	return m
+}
+
+// LastRefresh returns the oldest last refresh time of all the loaders that are a Refresher
+func (kl *mergedKeyLoader) LastRefresh() time.Time {
+	return oldestRefresh(kl.loaders)
+}
+
+// conflict counts a key ID collision and logs it the first time it happens
+func (kl *mergedKeyLoader) conflict(id string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister("planb.openidprovider.keyconflicts", metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+	if _, logged := kl.logged.LoadOrStore(id, true); !logged {
+		slog.Warn("Key has different keys in the merged key sets and is rejected", "kid", id)
+	}
+}
+
+// sameKey compares two keys, public keys with their Equal method
+func sameKey(a, b interface{}) bool {
+	if k, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return k.Equal(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// oldestRefresh returns the oldest last refresh time of the loaders that are a Refresher, so that a single
+// stale key set is noticed
+func oldestRefresh(loaders []KeyLoader) time.Time {
+	var oldest time.Time
+	first := true
+	for _, l := range loaders {
+		if r, ok := l.(Refresher); ok {
+			if t := r.LastRefresh(); first || t.Before(oldest) {
+				oldest, first = t, false
+			}
+		}
+	}
+	return oldest
+}
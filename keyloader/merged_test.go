@@ -0,0 +1,82 @@
+package keyloader
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMergedKeyLoader(t *testing.T) {
+	legacy := mapKeyLoader{"old": "legacy-old", "shared": "same", "kid": "legacy-kid"}
+	current := mapKeyLoader{"new": "current-new", "shared": "same", "kid": "current-kid"}
+	kl := NewMergedKeyLoader(legacy, current)
+
+	for _, test := range []struct {
+		id      string
+		want    interface{}
+		wantErr error
+	}{
+		{"old", "legacy-old", nil},
+		{"new", "current-new", nil},
+		{"shared", "same", nil},
+		{"kid", nil, ErrKeyConflict},
+		{"missing", nil, ErrKeyNotFound},
+	} {
+		k, err := kl.LoadKey(test.id)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("Wrong error loading %q. Wanted %v, got %v", test.id, test.wantErr, err)
+		}
+		if k != test.want {
+			t.Errorf("Wrong key loaded for %q. Wanted %v, got %v", test.id, test.want, k)
+		}
+	}
+
+	keys := kl.Keys()
+	if len(keys) != 3 || keys["old"] != "legacy-old" || keys["new"] != "current-new" || keys["shared"] != "same" {
+		t.Errorf("Wrong set of keys: %v", keys)
+	}
+}
+
+func TestMergedLastRefresh(t *testing.T) {
+	now := time.Now()
+	stale := refreshingKeyLoader{last: now.Add(-time.Hour)}
+	kl := NewMergedKeyLoader(refreshingKeyLoader{last: now}, stale, mapKeyLoader{})
+	if r := kl.(Refresher).LastRefresh(); !r.Equal(stale.last) {
+		t.Errorf("The oldest refresh should be reported. Wanted %v, got %v", stale.last, r)
+	}
+}
+
+// fetchingKeyLoader knows the keys of fetched once FetchKey was called
+type fetchingKeyLoader struct {
+	mapKeyLoader
+	fetched mapKeyLoader
+	fetches int32
+}
+
+func (f *fetchingKeyLoader) FetchKey(id string) (interface{}, error) {
+	atomic.AddInt32(&f.fetches, 1)
+	for kid, k := range f.fetched {
+		f.mapKeyLoader[kid] = k
+	}
+	return f.LoadKey(id)
+}
+
+func TestFetchKey(t *testing.T) {
+	rotated := &fetchingKeyLoader{mapKeyLoader: mapKeyLoader{"old": "old"}, fetched: mapKeyLoader{"new": "new"}}
+	other := &fetchingKeyLoader{mapKeyLoader: mapKeyLoader{"other": "other"}}
+	kl := NewMergedKeyLoader(rotated, other, mapKeyLoader{"static": "static"})
+
+	if k, err := FetchKey(kl, "old"); k != "old" || err != nil || rotated.fetches+other.fetches != 0 {
+		t.Errorf("Known keys shouldn't be fetched: %v %v", k, err)
+	}
+	if k, err := FetchKey(kl, "new"); k != "new" || err != nil {
+		t.Errorf("Failed to fetch the new key: %v %v", k, err)
+	}
+	if rotated.fetches != 1 || other.fetches != 1 {
+		t.Errorf("Every key set should be refreshed once, got %d and %d", rotated.fetches, other.fetches)
+	}
+	if _, err := FetchKey(kl, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Wrong error for a missing key: %v", err)
+	}
+}
@@ -2,12 +2,13 @@ package jwk
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 )
 
 // The JSONWebKeySet is an helper type to unmarshal te JSON response from an OpenID JWKS endpoint
@@ -41,6 +42,8 @@ var (
 	ErrInvalidRSAPublicKey = errors.New("Invalid RSA Public key")
 	// ErrInvalidECDSAPublicKey should be used whenever the key thumbprint is an invalid ECDSA key
 	ErrInvalidECDSAPublicKey = errors.New("Invalid ECDSA Public key")
+	// ErrInvalidEd25519PublicKey should be used whenever the key thumbprint is an invalid Ed25519 key
+	ErrInvalidEd25519PublicKey = errors.New("Invalid Ed25519 Public key")
 )
 
 // ToMap returns the JSON Web Keys Set as a simple map with the Key IDs as keys of the map and the
@@ -50,7 +53,7 @@ func (jwks *JSONWebKeySet) ToMap() map[string]interface{} {
 	m := make(map[string]interface{})
 	for _, k := range jwks.Keys {
 		if _, has := m[k.KeyID]; has {
-			log.Printf("Duplicate key %q. Rejecting\n", k.KeyID)
+			slog.Warn("Duplicate key, rejecting", "kid", k.KeyID)
 			continue
 		}
 		m[k.KeyID] = k
@@ -93,8 +96,20 @@ func (key *jsonWebKeyHelper) toECDSA() (*ecdsa.PublicKey, error) {
 	}, nil
 }
 
+func (key *jsonWebKeyHelper) toEd25519() (ed25519.PublicKey, error) {
+	if key.Crv != "Ed25519" {
+		return nil, fmt.Errorf("Unsupported OKP curve '%s'", key.Crv)
+	}
+
+	if key.X == nil || len(*key.X) != ed25519.PublicKeySize {
+		return nil, ErrInvalidEd25519PublicKey
+	}
+
+	return ed25519.PublicKey(*key.X), nil
+}
+
 // UnmarshalJSON is used to unmarshal a JWK entry from the JSON Web Keys Set
-// It assumes all keys from that endpoint are public keys. Only RSA and ECDSA keys are supported
+// It assumes all keys from that endpoint are public keys. Only RSA, ECDSA and Ed25519 (OKP) keys are supported
 func (jwk *JSONWebKey) UnmarshalJSON(data []byte) (err error) {
 	var buf jsonWebKeyHelper
 	if err = json.Unmarshal(data, &buf); err != nil {
@@ -106,6 +121,8 @@ func (jwk *JSONWebKey) UnmarshalJSON(data []byte) (err error) {
 		key, err = buf.toECDSA()
 	case "RSA":
 		key, err = buf.toRSA()
+	case "OKP":
+		key, err = buf.toEd25519()
 	default:
 		err = fmt.Errorf("Unsupported key type %q", buf.Kty)
 	}
@@ -2,14 +2,18 @@ package jwk
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"math/big"
 	"reflect"
 	"testing"
 )
 
+var testEd25519PKey, _ = base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+
 func TestJwk(t *testing.T) {
 	for _, test := range []struct {
 		input      string
@@ -30,6 +34,20 @@ func TestJwk(t *testing.T) {
 		{`{"keys":[{"alg":"RS256","kid":"2011-04-29","kty":"RSA","use":"sign","e":"AQAB"}]}`, nil, true},
 		{`{"keys":[{"alg":"RS256","kid":"2011-04-29","kty":"RSA","use":"sign","n":"AQAB"}]}`, nil, true},
 		{`{"keys":[{"alg":"RS256","kid":"2011-04-29","kty":"RSA","use":"sign","n":"-"}]}`, nil, true},
+		{`{"keys":[{"alg":"EdDSA","crv":"X448","kid":"testkey","kty":"OKP","use":"sign","x":"EA"}]}`, nil, true},
+		{`{"keys":[{"alg":"EdDSA","crv":"Ed25519","kid":"testkey","kty":"OKP","use":"sign"}]}`, nil, true},
+		{`{"keys":[{"alg":"EdDSA","crv":"Ed25519","kid":"testkey","kty":"OKP","use":"sign","x":"EA"}]}`, nil, true},
+		{
+			`{"keys":[{"alg":"EdDSA","crv":"Ed25519","kid":"testkey","kty":"OKP","use":"sign","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`,
+			&JSONWebKeySet{Keys: []JSONWebKey{
+				{
+					Key:       ed25519.PublicKey(testEd25519PKey),
+					KeyID:     "testkey",
+					Algorithm: "EdDSA",
+					Use:       "sign",
+				},
+			}}, false,
+		},
 		{
 			`{"keys":[{"alg":"ES256","crv":"P-256","kid":"testkey","kty":"EC","use":"sign","x":"EA","y":"EA"}]}`,
 			&JSONWebKeySet{Keys: []JSONWebKey{
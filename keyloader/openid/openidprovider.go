@@ -1,14 +1,17 @@
 package openid
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/zalando/planb-tokeninfo/breaker"
@@ -16,6 +19,9 @@ import (
 	"github.com/zalando/planb-tokeninfo/keyloader"
 	"github.com/zalando/planb-tokeninfo/keyloader/openid/jwk"
 	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
@@ -24,68 +30,282 @@ import (
 type cachingOpenIDProviderLoader struct {
 	url      string
 	keyCache *caching.Cache
+	// url is the JWKS itself instead of the OpenID configuration
+	direct bool
+	// only the keys with this use are loaded, when not empty
+	use string
+
+	// validators of the last JWKS response, sent on the next refresh to skip unchanged key sets
+	jwksURI      string
+	etag         string
+	lastModified string
+
+	// keys removed from the JWKS stay usable until the end of the grace period
+	gracePeriod time.Duration
+
+	// refreshes the keys early when a token has an unknown key id, nil in tests
+	scheduler *keyloader.Scheduler
+
+	mu          sync.Mutex
+	lastRefresh time.Time
+	retired     map[string]retiredKey
+}
+
+type retiredKey struct {
+	key   interface{}
+	until time.Time
 }
 
 const (
-	metricsNoKeysError = "planb.openidprovider.errors.nokeys"
-	metricsNumKeys     = "planb.openidprovider.numkeys"
+	metricsNoKeysError     = "planb.openidprovider.errors.nokeys"
+	metricsNumKeys         = "planb.openidprovider.numkeys"
+	metricsRefreshSuccess  = "planb.openidprovider.refresh.success"
+	metricsRefreshFailures = "planb.openidprovider.refresh.failures"
+	metricsRefreshForced   = "planb.openidprovider.refresh.forced"
+	metricsNotModified     = "planb.openidprovider.refresh.notmodified"
+	metricsLastRefresh     = "planb.openidprovider.refresh.last"
+	metricsRetiredKeys     = "planb.openidprovider.retiredkeys"
+	metricsRetiredKeysUsed = "planb.openidprovider.retiredkeys.used"
 )
 
 var (
 	errInvalidResponseStatusCode = errors.New("Invalid response status code")
-	scheduleFunc                 = keyloader.Schedule
+	scheduleFunc                 = keyloader.ScheduleWithBackoff
 )
 
+// fetchTimeout is the longest a token with an unknown key id waits for the keys to be refreshed
+const fetchTimeout = 2 * time.Second
+
+// schedulerConfig returns the refresh intervals of the settings
+func schedulerConfig() keyloader.SchedulerConfig {
+	settings := options.Current()
+	return keyloader.SchedulerConfig{
+		Interval:           settings.OpenIDProviderRefreshInterval,
+		Jitter:             settings.OpenIDProviderRefreshJitter,
+		Backoff:            settings.OpenIDProviderRefreshBackoff,
+		MaxBackoff:         settings.OpenIDProviderRefreshMaxBackoff,
+		MinTriggerInterval: settings.OpenIDProviderKidRefreshInterval,
+	}
+}
+
 // NewCachingOpenIDProviderLoader returns a KeyLoader that uses the configured URL to an OpenID
 // endpoint where the URI for the JSON Web Keys Set is available
 func NewCachingOpenIDProviderLoader(u *url.URL) keyloader.KeyLoader {
-	kl := &cachingOpenIDProviderLoader{url: u.String(), keyCache: caching.NewCache()}
-	scheduleFunc(options.AppSettings.OpenIDProviderRefreshInterval, kl.refreshKeys)
+	kl := &cachingOpenIDProviderLoader{
+		url:         u.String(),
+		keyCache:    caching.NewCache(),
+		gracePeriod: options.Current().OpenIDProviderKeyGracePeriod}
+	kl.scheduler = scheduleFunc(schedulerConfig(), kl.refreshKeys)
+	return kl
+}
+
+// NewCachingJWKSLoader returns a KeyLoader for the JSON Web Key Set at the URL, without OpenID discovery
+func NewCachingJWKSLoader(u *url.URL) keyloader.KeyLoader {
+	kl := &cachingOpenIDProviderLoader{
+		url:         u.String(),
+		direct:      true,
+		keyCache:    caching.NewCache(),
+		gracePeriod: options.Current().OpenIDProviderKeyGracePeriod}
+	kl.scheduler = scheduleFunc(schedulerConfig(), kl.refreshKeys)
+	return kl
+}
+
+// NewCachingSPIFFEBundleLoader returns a KeyLoader for the JWT-SVID keys of the SPIFFE trust bundle at the URL,
+// for ex. the bundle endpoint of a SPIRE server. The bundle is a JSON Web Key Set and its X.509-SVID keys are
+// ignored
+func NewCachingSPIFFEBundleLoader(u *url.URL) keyloader.KeyLoader {
+	kl := &cachingOpenIDProviderLoader{
+		url:         u.String(),
+		direct:      true,
+		use:         "jwt-svid",
+		keyCache:    caching.NewCache(),
+		gracePeriod: options.Current().OpenIDProviderKeyGracePeriod}
+	kl.scheduler = scheduleFunc(schedulerConfig(), kl.refreshKeys)
 	return kl
 }
 
 func (kl *cachingOpenIDProviderLoader) LoadKey(id string) (interface{}, error) {
 	v := kl.keyCache.Get(id)
 	if v == nil {
-		return nil, fmt.Errorf("Key '%s' not found", id)
+		if v = kl.retiredKey(id, time.Now()); v == nil {
+			return nil, fmt.Errorf("%w: %s", keyloader.ErrKeyNotFound, id)
+		}
+		incCounter(metricsRetiredKeysUsed)
 	}
 	return v.(jwk.JSONWebKey).Key, nil
 }
 
+// FetchKey refreshes the keys if there is no key with the given id, for ex. right after a key rotation, and
+// waits for the refresh. Concurrent calls share the refresh and there is at most one refresh per
+// OPENID_PROVIDER_KID_REFRESH_INTERVAL, so tokens with made up key ids can't overload the OpenID provider
+func (kl *cachingOpenIDProviderLoader) FetchKey(id string) (interface{}, error) {
+	if k, err := kl.LoadKey(id); err == nil || kl.scheduler == nil {
+		return k, err
+	}
+	done, triggered := kl.scheduler.Trigger()
+	if done == nil {
+		return kl.LoadKey(id)
+	}
+	if triggered {
+		slog.Info("Refreshing keys for unknown key", "kid", id)
+		incCounter(metricsRefreshForced)
+	}
+	t := time.NewTimer(fetchTimeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+	}
+	return kl.LoadKey(id)
+}
+
+// retiredKey returns the key with the given id if it was removed from the JWKS less than the grace period
+// before now
+func (kl *cachingOpenIDProviderLoader) retiredKey(id string, now time.Time) interface{} {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if r, has := kl.retired[id]; has && now.Before(r.until) {
+		return r.key
+	}
+	return nil
+}
+
+// retireKeys keeps the keys from old that are missing in the new key set for the grace period. Retired
+// keys that expired or that are back in the new key set are dropped
+func (kl *cachingOpenIDProviderLoader) retireKeys(old map[string]interface{}, newKeys map[string]interface{}, now time.Time) {
+	if kl.gracePeriod <= 0 {
+		return
+	}
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if kl.retired == nil {
+		kl.retired = make(map[string]retiredKey)
+	}
+	for kid, r := range kl.retired {
+		if _, has := newKeys[kid]; has || !now.Before(r.until) {
+			delete(kl.retired, kid)
+		}
+	}
+	for kid, k := range old {
+		if _, has := newKeys[kid]; !has {
+			slog.Info("Public key was removed, it can still be used for the grace period", "kid", kid, "grace_period", kl.gracePeriod)
+			kl.retired[kid] = retiredKey{key: k, until: now.Add(kl.gracePeriod)}
+		}
+	}
+	if g, ok := metrics.DefaultRegistry.GetOrRegister(metricsRetiredKeys, metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(int64(len(kl.retired)))
+	}
+}
+
 func (kl *cachingOpenIDProviderLoader) Keys() map[string]interface{} {
 	return kl.keyCache.Snapshot()
 }
 
+// LastRefresh returns the time of the last successful refresh, including the ones where the JWKS was
+// not modified
+func (kl *cachingOpenIDProviderLoader) LastRefresh() time.Time {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return kl.lastRefresh
+}
+
+func (kl *cachingOpenIDProviderLoader) refreshed(now time.Time) {
+	kl.mu.Lock()
+	kl.lastRefresh = now
+	kl.mu.Unlock()
+	if g, ok := metrics.DefaultRegistry.GetOrRegister(metricsLastRefresh, metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(now.Unix())
+	}
+	incCounter(metricsRefreshSuccess)
+}
+
+// refreshKeys loads the keys in a trace span and returns true on success
+func (kl *cachingOpenIDProviderLoader) refreshKeys() bool {
+	_, span := tracing.Start(context.Background(), "openid.refresh_keys", attribute.String("openid.configuration_url", kl.url))
+	defer span.End()
+	if !kl.loadKeys() {
+		span.SetStatus(codes.Error, "Failed to refresh keys")
+		return false
+	}
+	return true
+}
+
+// loadKeys replaces the cached keys and returns true on success
 // Example: https://www.googleapis.com/oauth2/v3/certs
-func (kl *cachingOpenIDProviderLoader) refreshKeys() {
-	log.Println("Refreshing keys..")
+func (kl *cachingOpenIDProviderLoader) loadKeys() bool {
+	slog.Debug("Refreshing keys")
 
-	log.Println("Loading configuration..")
-	c, err := kl.loadConfiguration()
-	if err != nil {
-		log.Printf("Failed to get configuration from %q. %s\n", kl.url, err)
-		return
+	jwksURI := kl.url
+	if !kl.direct {
+		slog.Debug("Loading configuration")
+		c, err := kl.loadConfiguration()
+		if err != nil {
+			slog.Warn("Failed to get configuration", "url", kl.url, "error", err)
+			incCounter(metricsRefreshFailures)
+			return false
+		}
+		slog.Debug("Configuration loaded successfully, loading JWKS")
+		jwksURI = withAppID(c.JwksURI, kl.url)
 	}
 
-	log.Println("Configuration loaded successfully, loading JWKS..")
-	resp, err := breaker.Get("loadKeys", c.JwksURI)
+	req, err := http.NewRequest("GET", jwksURI, nil)
 	if err != nil {
-		log.Println("Failed to get JWKS from ", c.JwksURI)
-		return
+		slog.Warn("Invalid JWKS URI", "url", jwksURI, "error", err)
+		incCounter(metricsRefreshFailures)
+		return false
+	}
+	if jwksURI == kl.jwksURI {
+		if kl.etag != "" {
+			req.Header.Set("If-None-Match", kl.etag)
+		}
+		if kl.lastModified != "" {
+			req.Header.Set("If-Modified-Since", kl.lastModified)
+		}
+	}
+	resp, err := breaker.Do("loadKeys", req)
+	if err != nil {
+		slog.Warn("Failed to get JWKS", "url", jwksURI, "error", err)
+		incCounter(metricsRefreshFailures)
+		return false
 	}
 	defer resp.Body.Close()
 
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		slog.Debug("JWKS not modified, keeping the current keys")
+		incCounter(metricsNotModified)
+		kl.refreshed(time.Now())
+		return true
+	default:
+		slog.Warn("Failed to get JWKS", "url", jwksURI, "status", resp.StatusCode)
+		incCounter(metricsRefreshFailures)
+		return false
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Failed to read JWKS response body from %q: %v\n", c.JwksURI, err)
-		return
+		slog.Warn("Failed to read JWKS response body", "url", jwksURI, "error", err)
+		incCounter(metricsRefreshFailures)
+		return false
 	}
 
-	log.Println("JWKS loaded successfully, parsing JWKS..")
+	slog.Debug("JWKS loaded successfully, parsing JWKS")
 	jwks := new(jwk.JSONWebKeySet)
 	if err = json.Unmarshal(body, jwks); err != nil {
-		log.Println("Failed to parse JWKS: ", err)
-		return
+		slog.Warn("Failed to parse JWKS", "error", err)
+		incCounter(metricsRefreshFailures)
+		return false
+	}
+
+	if kl.use != "" {
+		keys := jwks.Keys[:0]
+		for _, k := range jwks.Keys {
+			if k.Use == kl.use {
+				keys = append(keys, k)
+			}
+		}
+		jwks.Keys = keys
 	}
 
 	// safety first: only remove public keys if our newly
@@ -94,11 +314,10 @@ func (kl *cachingOpenIDProviderLoader) refreshKeys() {
 	// just because somebody cleared the provider database)
 	numKeys := len(jwks.Keys)
 	if numKeys < 1 {
-		log.Println("No JWKS currently in the OpenID provider")
-		if c, ok := metrics.DefaultRegistry.GetOrRegister(metricsNoKeysError, metrics.NewCounter).(metrics.Counter); ok {
-			c.Inc(1)
-		}
-		return
+		slog.Warn("No JWKS currently in the OpenID provider")
+		incCounter(metricsNoKeysError)
+		incCounter(metricsRefreshFailures)
+		return false
 	}
 
 	if g, ok := metrics.DefaultRegistry.GetOrRegister(metricsNumKeys, metrics.NewGauge).(metrics.Gauge); ok {
@@ -110,17 +329,40 @@ func (kl *cachingOpenIDProviderLoader) refreshKeys() {
 		key := k.(jwk.JSONWebKey)
 		existing := kl.keyCache.Get(kid)
 		if existing == nil {
-			log.Printf("Received new public key %q (%s)\n", kid, key.Algorithm)
+			slog.Info("Received new public key", "kid", kid, "alg", key.Algorithm)
 		} else if !reflect.DeepEqual(existing, key) {
 			// this is potentially dangerous: the key contents changed..
 			// (but maybe the key wasn't used for signing yet, so it might be ok)
-			log.Printf("Received a replacement public key for existing key %q (%s)", kid, key.Algorithm)
+			slog.Info("Received a replacement public key for existing key", "kid", kid, "alg", key.Algorithm)
 		}
 	}
 
-	log.Printf("Resetting key cache with %d key(s)..", numKeys)
-	kl.keyCache.Reset(newKeys)
-	log.Println("Refresh done..")
+	slog.Debug("Resetting key cache", "keys", numKeys)
+	old := kl.keyCache.Reset(newKeys)
+	kl.retireKeys(old, newKeys, time.Now())
+	kl.jwksURI, kl.etag, kl.lastModified = jwksURI, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	kl.refreshed(time.Now())
+	slog.Debug("Refresh done")
+	return true
+}
+
+// withAppID adds the appid query parameter of the configuration URL to the JWKS URI, if it doesn't have one.
+// Azure AD only returns the signing keys of applications with their own keys, for ex. for SAML, when they are
+// requested with the application id, which is set in the metadata URL
+func withAppID(jwksURI string, configURL string) string {
+	c, err := url.Parse(configURL)
+	if err != nil {
+		return jwksURI
+	}
+	appID := c.Query().Get("appid")
+	u, err := url.Parse(jwksURI)
+	if appID == "" || err != nil || u.Query().Has("appid") {
+		return jwksURI
+	}
+	q := u.Query()
+	q.Set("appid", appID)
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
 // https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationResponse
@@ -145,3 +387,9 @@ func (kl *cachingOpenIDProviderLoader) loadConfiguration() (*configuration, erro
 	err = json.Unmarshal(body, config)
 	return config, err
 }
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
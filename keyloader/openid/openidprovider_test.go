@@ -6,6 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,7 +20,9 @@ func init() {
 	scheduleFunc = noOpScheduler
 }
 
-func noOpScheduler(_ time.Duration, _ keyloader.JobFunc) {}
+func noOpScheduler(_ keyloader.SchedulerConfig, _ keyloader.RefreshFunc) *keyloader.Scheduler {
+	return nil
+}
 
 func TestLoadConfigurationFailure(t *testing.T) {
 	kc := caching.NewCache()
@@ -129,6 +134,73 @@ func TestLoadKeys(t *testing.T) {
 	}
 }
 
+func TestLoadJWKSDirectly(t *testing.T) {
+	var configRequests int
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/.well-known/openid-configuration" {
+			configRequests++
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"keys": [{"alg": "ES256", "crv": "P-256", "kid": "testkey", "kty": "EC", "use": "sign",
+			"x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}]}`)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/oauth2/v3/certs")
+	kl := NewCachingJWKSLoader(u)
+	if !kl.(*cachingOpenIDProviderLoader).loadKeys() {
+		t.Fatal("Failed to refresh the keys from the JWKS URL")
+	}
+	if _, err := kl.LoadKey("testkey"); err != nil {
+		t.Error("Failed to load key `testkey`: ", err)
+	}
+	if configRequests != 0 {
+		t.Errorf("The OpenID configuration should not be requested, got %d requests", configRequests)
+	}
+}
+
+func TestLoadSPIFFEBundle(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"spiffe_sequence": 1, "keys": [
+			{"use": "x509-svid", "kty": "EC", "crv": "P-256", "x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"},
+			{"use": "jwt-svid", "kid": "svidkey", "kty": "EC", "crv": "P-256", "x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}]}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/bundle")
+	kl := NewCachingSPIFFEBundleLoader(u)
+	if !kl.(*cachingOpenIDProviderLoader).loadKeys() {
+		t.Fatal("Failed to refresh the keys from the bundle")
+	}
+	if _, err := kl.LoadKey("svidkey"); err != nil {
+		t.Error("Failed to load key `svidkey`: ", err)
+	}
+	if keys := kl.Keys(); len(keys) != 1 {
+		t.Errorf("Only the JWT-SVID keys should be loaded, got %v", keys)
+	}
+}
+
+func TestWithAppID(t *testing.T) {
+	for _, test := range []struct {
+		jwksURI   string
+		configURL string
+		want      string
+	}{
+		{"https://login.example.org/common/discovery/v2.0/keys", "https://login.example.org/common/v2.0/.well-known/openid-configuration", "https://login.example.org/common/discovery/v2.0/keys"},
+		{"https://login.example.org/common/discovery/v2.0/keys", "https://login.example.org/common/v2.0/.well-known/openid-configuration?appid=app1", "https://login.example.org/common/discovery/v2.0/keys?appid=app1"},
+		{"https://login.example.org/keys?appid=app1", "https://login.example.org/.well-known/openid-configuration?appid=app2", "https://login.example.org/keys?appid=app1"},
+		{"https://login.example.org/keys?v=2", "https://login.example.org/.well-known/openid-configuration?appid=app1", "https://login.example.org/keys?appid=app1&v=2"},
+	} {
+		if got := withAppID(test.jwksURI, test.configURL); got != test.want {
+			t.Errorf("Wrong JWKS URI for %q. Wanted %q, got %q", test.configURL, test.want, got)
+		}
+	}
+}
+
 func TestRevokeKeys(t *testing.T) {
 	var listener string
 
@@ -219,3 +291,162 @@ func TestInvalidJwksUri(t *testing.T) {
 		t.Error("Key amount should be 0")
 	}
 }
+
+func TestConditionalRefresh(t *testing.T) {
+	var listener string
+	var jwksCalls, notModified int
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/.well-known/openid-configuration" {
+			fmt.Fprintf(w, `{"issuer": "PlanB", "jwks_uri": "%s/oauth2/v3/certs"}`, listener)
+			return
+		}
+		jwksCalls++
+		if req.Header.Get("If-None-Match") == `"v1"` && req.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		fmt.Fprintf(w, `{"keys": [{"kid": "testkey", "kty": "EC", "crv": "P-256",
+			"x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}]}`)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	listener = fmt.Sprintf("http://%s", server.Listener.Addr())
+	kl := &cachingOpenIDProviderLoader{url: listener + "/.well-known/openid-configuration", keyCache: caching.NewCache()}
+	if !kl.LastRefresh().IsZero() {
+		t.Error("Keys should never have been refreshed")
+	}
+
+	if !kl.loadKeys() {
+		t.Fatal("Failed to load the keys")
+	}
+	first := kl.LastRefresh()
+	if first.IsZero() {
+		t.Error("Last refresh time was not set")
+	}
+
+	if !kl.loadKeys() {
+		t.Error("A not modified JWKS should be a successful refresh")
+	}
+	if jwksCalls != 2 || notModified != 1 {
+		t.Errorf("Wrong JWKS calls. Wanted 2 calls and 1 not modified, got %d and %d", jwksCalls, notModified)
+	}
+	if kl.keyCache.Get("testkey") == nil {
+		t.Error("`testkey` should still be in cache")
+	}
+	if kl.LastRefresh().Before(first) {
+		t.Error("Last refresh time was not updated")
+	}
+
+	// a new JWKS URI has no validators
+	kl.jwksURI = listener + "/old/certs"
+	kl.loadKeys()
+	if jwksCalls != 3 || notModified != 1 {
+		t.Errorf("Validators should only be sent to the same JWKS URI, got %d calls and %d not modified", jwksCalls, notModified)
+	}
+}
+
+func TestRetiredKeys(t *testing.T) {
+	var listener string
+	kids := []string{"oldkey", "testkey"}
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/.well-known/openid-configuration" {
+			fmt.Fprintf(w, `{"issuer": "PlanB", "jwks_uri": "%s/oauth2/v3/certs"}`, listener)
+			return
+		}
+		var keys []string
+		for _, kid := range kids {
+			keys = append(keys, fmt.Sprintf(`{"kid": "%s", "kty": "EC", "crv": "P-256",
+				"x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}`, kid))
+		}
+		fmt.Fprintf(w, `{"keys": [%s]}`, strings.Join(keys, ","))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	listener = fmt.Sprintf("http://%s", server.Listener.Addr())
+	kl := &cachingOpenIDProviderLoader{url: listener + "/.well-known/openid-configuration", keyCache: caching.NewCache(), gracePeriod: time.Minute}
+	kl.refreshKeys()
+
+	kids = []string{"testkey"}
+	kl.refreshKeys()
+	if _, has := kl.Keys()["oldkey"]; has {
+		t.Error("`oldkey` should not be a current key anymore")
+	}
+	if k, err := kl.LoadKey("oldkey"); k == nil || err != nil {
+		t.Error("`oldkey` should still be usable during the grace period: ", err)
+	}
+	if k := kl.retiredKey("oldkey", time.Now().Add(2*time.Minute)); k != nil {
+		t.Error("`oldkey` should not be usable after the grace period")
+	}
+
+	kids = []string{"oldkey", "testkey"}
+	kl.refreshKeys()
+	if len(kl.retired) != 0 {
+		t.Errorf("Keys back in the JWKS should not be retired anymore: %v", kl.retired)
+	}
+
+	kl.gracePeriod = 0
+	kids = []string{"testkey"}
+	kl.refreshKeys()
+	if _, err := kl.LoadKey("oldkey"); err == nil {
+		t.Error("`oldkey` should be dropped immediately without a grace period")
+	}
+}
+
+func TestFetchKey(t *testing.T) {
+	var rotated atomic.Bool
+	var requests atomic.Int32
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		kid := "oldkey"
+		if rotated.Load() {
+			kid = "newkey"
+		}
+		fmt.Fprintf(w, `{"keys": [{"kid": "%s", "kty": "EC", "crv": "P-256",
+			"x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}]}`, kid)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	kl := &cachingOpenIDProviderLoader{url: server.URL, direct: true, keyCache: caching.NewCache()}
+	kl.scheduler = keyloader.ScheduleWithBackoff(keyloader.SchedulerConfig{Interval: time.Hour, MinTriggerInterval: 20 * time.Millisecond}, kl.refreshKeys)
+	for len(kl.Keys()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rotated.Store(true)
+	if _, err := kl.FetchKey("newkey"); err == nil {
+		t.Error("`newkey` should not be fetched right after the last refresh")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("A refresh right after the last one should be rate limited, got %d requests", requests.Load())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := kl.FetchKey("newkey"); err != nil {
+				t.Error("An unknown key should be fetched: ", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests.Load() != 2 {
+		t.Errorf("Concurrent fetches should share a single refresh, got %d requests", requests.Load())
+	}
+	if _, err := kl.LoadKey("newkey"); err != nil {
+		t.Error("`newkey` should be known after the refresh: ", err)
+	}
+}
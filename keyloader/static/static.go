@@ -0,0 +1,196 @@
+// Package static implements key loaders with a fixed set of public keys from a local file or an
+// environment variable, for environments where the OpenID provider can't be reached at runtime
+package static
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/caching"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+	"github.com/zalando/planb-tokeninfo/keyloader/openid/jwk"
+)
+
+const (
+	metricsReloadSuccess  = "planb.statickeys.reload.success"
+	metricsReloadFailures = "planb.statickeys.reload.failures"
+)
+
+var (
+	// ErrNoKeys is returned when a key source doesn't contain any public key
+	ErrNoKeys = errors.New("No public keys found")
+	// ErrMissingKeyID is returned for PEM blocks without a kid header
+	ErrMissingKeyID = errors.New("Missing kid header in PEM block")
+
+	scheduleFunc = keyloader.Schedule
+)
+
+type staticKeyLoader struct {
+	keyCache *caching.Cache
+}
+
+type fileKeyLoader struct {
+	staticKeyLoader
+	path    string
+	modTime time.Time
+}
+
+// NewLoader returns a KeyLoader with the keys in data, a JSON Web Key Set or PEM encoded public keys.
+// For ex., the content of an environment variable
+func NewLoader(data []byte) (keyloader.KeyLoader, error) {
+	keys, err := ParseKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	kl := &staticKeyLoader{keyCache: caching.NewCache()}
+	kl.keyCache.Reset(keys)
+	return kl, nil
+}
+
+// NewFileLoader returns a KeyLoader with the keys from the file at path, a JSON Web Key Set or PEM
+// encoded public keys. The file is checked for changes every interval and reloaded when it was modified.
+// An error is returned only if the initial keys can't be loaded; later failures are logged and the
+// previous keys are kept
+func NewFileLoader(path string, interval time.Duration) (keyloader.KeyLoader, error) {
+	kl := &fileKeyLoader{staticKeyLoader: staticKeyLoader{keyCache: caching.NewCache()}, path: path}
+	if err := kl.load(); err != nil {
+		return nil, err
+	}
+	scheduleFunc(interval, kl.reload)
+	return kl, nil
+}
+
+func (kl *staticKeyLoader) LoadKey(id string) (interface{}, error) {
+	v := kl.keyCache.Get(id)
+	if v == nil {
+		return nil, fmt.Errorf("%w: %s", keyloader.ErrKeyNotFound, id)
+	}
+	return v.(jwk.JSONWebKey).Key, nil
+}
+
+func (kl *staticKeyLoader) Keys() map[string]interface{} {
+	return kl.keyCache.Snapshot()
+}
+
+func (kl *fileKeyLoader) reload() {
+	fi, err := os.Stat(kl.path)
+	if err != nil {
+		slog.Warn("Failed to check the keys file", "error", err)
+		incCounter(metricsReloadFailures)
+		return
+	}
+	if !fi.ModTime().After(kl.modTime) {
+		return
+	}
+
+	if err := kl.load(); err != nil {
+		slog.Warn("Failed to reload the keys, keeping the previous ones", "error", err)
+		incCounter(metricsReloadFailures)
+		return
+	}
+	slog.Info("Reloaded the keys", "file", kl.path)
+	incCounter(metricsReloadSuccess)
+}
+
+func (kl *fileKeyLoader) load() error {
+	fi, err := os.Stat(kl.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(kl.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseKeys(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", kl.path, err)
+	}
+	kl.keyCache.Reset(keys)
+	kl.modTime = fi.ModTime()
+	return nil
+}
+
+// ParseKeys returns the public keys in data by key id. Data is either a JSON Web Key Set or a sequence of
+// PEM blocks of type PUBLIC KEY, RSA PUBLIC KEY or CERTIFICATE with the key id in a kid header and,
+// optionally, the algorithm in an alg header:
+//
+//	-----BEGIN PUBLIC KEY-----
+//	kid: testkey
+//	alg: ES256
+//
+//	MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE...
+//	-----END PUBLIC KEY-----
+func ParseKeys(data []byte) (map[string]interface{}, error) {
+	data = bytes.TrimSpace(data)
+	var keys map[string]interface{}
+	if bytes.HasPrefix(data, []byte("{")) {
+		jwks := new(jwk.JSONWebKeySet)
+		if err := json.Unmarshal(data, jwks); err != nil {
+			return nil, err
+		}
+		keys = jwks.ToMap()
+	} else {
+		var err error
+		if keys, err = parsePEM(data); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+func parsePEM(data []byte) (map[string]interface{}, error) {
+	keys := make(map[string]interface{})
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return keys, nil
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported PEM block type %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		kid := block.Headers["kid"]
+		if kid == "" {
+			return nil, ErrMissingKeyID
+		}
+		if _, has := keys[kid]; has {
+			slog.Warn("Duplicate key, rejecting", "kid", kid)
+			continue
+		}
+		keys[kid] = jwk.JSONWebKey{Key: key, KeyID: kid, Algorithm: block.Headers["alg"], Use: "sig"}
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
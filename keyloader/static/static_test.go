@@ -0,0 +1,123 @@
+package static
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+const testJWKS = `{"keys": [{"kid": "testkey", "kty": "EC", "crv": "P-256", "alg": "ES256",
+	"x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}]}`
+
+func init() {
+	scheduleFunc = func(_ time.Duration, _ keyloader.JobFunc) {}
+}
+
+func pemKey(t *testing.T, headers map[string]string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal("Failed to marshal public key: ", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: headers, Bytes: der})
+}
+
+func TestParseKeys(t *testing.T) {
+	twoKeys := append(pemKey(t, map[string]string{"kid": "k1", "alg": "ES256"}), pemKey(t, map[string]string{"kid": "k2"})...)
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		wantKeys int
+		wantErr  bool
+	}{
+		{"jwks", []byte(testJWKS), 1, false},
+		{"pem", twoKeys, 2, false},
+		{"duplicate kid", append(twoKeys, pemKey(t, map[string]string{"kid": "k1"})...), 2, false},
+		{"missing kid", pemKey(t, nil), 0, true},
+		{"private key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Headers: map[string]string{"kid": "k1"}}), 0, true},
+		{"invalid key", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: map[string]string{"kid": "k1"}}), 0, true},
+		{"empty jwks", []byte(`{"keys": []}`), 0, true},
+		{"invalid jwks", []byte(`{"keys": `), 0, true},
+		{"empty", nil, 0, true},
+	} {
+		keys, err := ParseKeys(test.data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected result for %s. Wanted error %t, got %v", test.name, test.wantErr, err)
+		}
+		if len(keys) != test.wantKeys {
+			t.Errorf("Wrong number of keys for %s. Wanted %d, got %d", test.name, test.wantKeys, len(keys))
+		}
+	}
+}
+
+func TestNewLoader(t *testing.T) {
+	kl, err := NewLoader(pemKey(t, map[string]string{"kid": "testkey"}))
+	if err != nil {
+		t.Fatal("Failed to load the keys: ", err)
+	}
+	if k, err := kl.LoadKey("testkey"); err != nil {
+		t.Error("Failed to load key `testkey`: ", err)
+	} else if _, ok := k.(*ecdsa.PublicKey); !ok {
+		t.Errorf("Key is not a valid ECDSA PublicKey: %T", k)
+	}
+	if _, err := kl.LoadKey("missing-key"); err == nil {
+		t.Error("Key 'missing-key' should not be found")
+	}
+
+	if _, err := NewLoader([]byte("not a key")); err == nil {
+		t.Error("Expected an error without keys")
+	}
+}
+
+func TestFileLoader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "static")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.pem")
+
+	if _, err := NewFileLoader(path, time.Second); err == nil {
+		t.Error("Expected an error for a missing keys file")
+	}
+
+	start := time.Now().Add(-time.Hour)
+	ioutil.WriteFile(path, []byte(testJWKS), 0600)
+	os.Chtimes(path, start, start)
+	kl, err := NewFileLoader(path, time.Second)
+	if err != nil {
+		t.Fatal("Failed to load the keys file: ", err)
+	}
+	if _, has := kl.Keys()["testkey"]; !has {
+		t.Error("Key map doesn't contain 'testkey'")
+	}
+
+	// unchanged files are not reloaded
+	ioutil.WriteFile(path, pemKey(t, map[string]string{"kid": "same-time"}), 0600)
+	os.Chtimes(path, start, start)
+	kl.(*fileKeyLoader).reload()
+	if _, has := kl.Keys()["testkey"]; !has {
+		t.Error("Keys should not be reloaded")
+	}
+
+	ioutil.WriteFile(path, pemKey(t, map[string]string{"kid": "newkey"}), 0600)
+	os.Chtimes(path, start.Add(time.Minute), start.Add(time.Minute))
+	kl.(*fileKeyLoader).reload()
+	if keys := kl.Keys(); len(keys) != 1 || keys["newkey"] == nil {
+		t.Errorf("Keys were not reloaded: %v", keys)
+	}
+
+	// invalid files keep the previous keys
+	ioutil.WriteFile(path, []byte("garbage"), 0600)
+	os.Chtimes(path, start.Add(2*time.Minute), start.Add(2*time.Minute))
+	kl.(*fileKeyLoader).reload()
+	if _, err := kl.LoadKey("newkey"); err != nil {
+		t.Error("Previous keys should be kept after a failed reload: ", err)
+	}
+}
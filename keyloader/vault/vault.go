@@ -0,0 +1,386 @@
+// Package vault implements a key loader with the public keys stored in HashiCorp Vault, either as a JSON
+// Web Key Set or PEM encoded keys in a KV secret or as the public keys of a transit key
+package vault
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/caching"
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+	"github.com/zalando/planb-tokeninfo/keyloader/openid/jwk"
+	"github.com/zalando/planb-tokeninfo/keyloader/static"
+)
+
+// Secret engines the keys can be read from
+const (
+	EngineKV      = "kv"
+	EngineTransit = "transit"
+)
+
+// Auth methods used to get a Vault token
+const (
+	AuthToken      = "token"
+	AuthKubernetes = "kubernetes"
+	AuthAppRole    = "approle"
+)
+
+const (
+	metricsRefreshSuccess  = "planb.vault.refresh.success"
+	metricsRefreshFailures = "planb.vault.refresh.failures"
+	metricsLogins          = "planb.vault.logins"
+	metricsNumKeys         = "planb.vault.numkeys"
+
+	// maxBodySize limits the size of Vault responses
+	maxBodySize = 1 << 20
+)
+
+var (
+	// ErrMissingField is returned when the KV secret doesn't have the configured field
+	ErrMissingField = errors.New("Missing field in the Vault secret")
+	// ErrPermissionDenied is returned when Vault rejects the token
+	ErrPermissionDenied = errors.New("Permission denied by Vault")
+
+	startRefresh = func(kl *vaultKeyLoader) { go kl.refreshLoop() }
+)
+
+// Config is the location of the keys in Vault and how to authenticate to it
+type Config struct {
+	Address   *url.URL
+	Namespace string
+	// Path of the KV secret, for ex. secret/data/tokeninfo for a KV version 2 engine mounted at secret/,
+	// or of the transit key, for ex. transit/keys/tokeninfo
+	Path   string
+	Engine string
+	// Field of the KV secret with the JSON Web Key Set or the PEM encoded keys
+	Field string
+
+	AuthMethod string
+	// AuthMount is the path the auth method is mounted at, the name of the method by default
+	AuthMount string
+	Token     string
+	// Role is the role of the Kubernetes auth method or the role_id of the AppRole auth method
+	Role     string
+	SecretID string
+	// ServiceAccountTokenFile is the JWT sent to the Kubernetes auth method
+	ServiceAccountTokenFile string
+
+	RefreshInterval time.Duration
+}
+
+type vaultKeyLoader struct {
+	config   Config
+	client   *http.Client
+	keyCache *caching.Cache
+
+	mu          sync.Mutex
+	token       string
+	tokenTTL    time.Duration
+	tokenExpiry time.Time
+	// lease of the last secret read, 0 if it has none
+	leaseDuration time.Duration
+	lastRefresh   time.Time
+}
+
+type vaultResponse struct {
+	Data          json.RawMessage `json:"data"`
+	LeaseDuration int             `json:"lease_duration"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// NewLoader returns a KeyLoader with the keys from Vault. The keys are read again every refresh interval or,
+// if it is shorter, after two thirds of the secret lease. The Vault token of the Kubernetes and AppRole auth
+// methods is renewed with a new login after two thirds of its lease. An error is returned only if the
+// initial keys can't be loaded; later failures are logged and the previous keys are kept
+func NewLoader(c Config) (keyloader.KeyLoader, error) {
+	if c.AuthMount == "" {
+		c.AuthMount = c.AuthMethod
+	}
+	kl := &vaultKeyLoader{config: c, client: ht.DefaultHTTPClient(), keyCache: caching.NewCache()}
+	if err := kl.load(); err != nil {
+		return nil, err
+	}
+	startRefresh(kl)
+	return kl, nil
+}
+
+func (kl *vaultKeyLoader) LoadKey(id string) (interface{}, error) {
+	v := kl.keyCache.Get(id)
+	if v == nil {
+		return nil, fmt.Errorf("%w: %s", keyloader.ErrKeyNotFound, id)
+	}
+	return v.(jwk.JSONWebKey).Key, nil
+}
+
+func (kl *vaultKeyLoader) Keys() map[string]interface{} {
+	return kl.keyCache.Snapshot()
+}
+
+// LastRefresh returns the time of the last successful refresh
+func (kl *vaultKeyLoader) LastRefresh() time.Time {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return kl.lastRefresh
+}
+
+func (kl *vaultKeyLoader) refreshLoop() {
+	for {
+		time.Sleep(kl.nextRefresh())
+		kl.refresh()
+	}
+}
+
+// nextRefresh returns the time until the keys or the token have to be refreshed
+func (kl *vaultKeyLoader) nextRefresh() time.Duration {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	d := kl.config.RefreshInterval
+	if l := kl.leaseDuration * 2 / 3; l > 0 && (d <= 0 || l < d) {
+		d = l
+	}
+	if !kl.tokenExpiry.IsZero() {
+		if t := time.Until(kl.tokenExpiry) - kl.tokenTTL/3; t < d {
+			d = t
+		}
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+func (kl *vaultKeyLoader) refresh() {
+	if err := kl.load(); err != nil {
+		slog.Warn("Failed to refresh the keys from Vault, keeping the previous ones", "error", err)
+		incCounter(metricsRefreshFailures)
+		return
+	}
+	incCounter(metricsRefreshSuccess)
+}
+
+// load reads the keys and replaces the cached ones. A rejected token is replaced with a new login once
+func (kl *vaultKeyLoader) load() error {
+	if err := kl.ensureToken(false); err != nil {
+		return err
+	}
+	keys, err := kl.readKeys()
+	if errors.Is(err, ErrPermissionDenied) && kl.config.AuthMethod != AuthToken {
+		if err = kl.ensureToken(true); err != nil {
+			return err
+		}
+		keys, err = kl.readKeys()
+	}
+	if err != nil {
+		return err
+	}
+	kl.keyCache.Reset(keys)
+	kl.mu.Lock()
+	kl.lastRefresh = time.Now()
+	kl.mu.Unlock()
+	if g, ok := metrics.DefaultRegistry.GetOrRegister(metricsNumKeys, metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(int64(len(keys)))
+	}
+	return nil
+}
+
+// ensureToken logs in when there is no token yet, when two thirds of its lease have passed or when force is
+// true. Static tokens are used as they are
+func (kl *vaultKeyLoader) ensureToken(force bool) error {
+	if kl.config.AuthMethod == AuthToken || kl.config.AuthMethod == "" {
+		kl.mu.Lock()
+		kl.token = kl.config.Token
+		kl.mu.Unlock()
+		return nil
+	}
+	kl.mu.Lock()
+	valid := kl.token != "" && (kl.tokenExpiry.IsZero() || time.Until(kl.tokenExpiry) > kl.tokenTTL/3)
+	kl.mu.Unlock()
+	if valid && !force {
+		return nil
+	}
+
+	body := make(map[string]string)
+	switch kl.config.AuthMethod {
+	case AuthKubernetes:
+		jwt, err := ioutil.ReadFile(kl.config.ServiceAccountTokenFile)
+		if err != nil {
+			return err
+		}
+		body["role"], body["jwt"] = kl.config.Role, strings.TrimSpace(string(jwt))
+	case AuthAppRole:
+		body["role_id"], body["secret_id"] = kl.config.Role, kl.config.SecretID
+	default:
+		return fmt.Errorf("Unsupported Vault auth method %q", kl.config.AuthMethod)
+	}
+	b, _ := json.Marshal(body)
+	resp, err := kl.do(http.MethodPost, "auth/"+kl.config.AuthMount+"/login", bytes.NewReader(b), "")
+	if err != nil {
+		return fmt.Errorf("Vault login failed: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("Vault login failed: no client token in the response")
+	}
+	ttl := time.Duration(resp.Auth.LeaseDuration) * time.Second
+	kl.mu.Lock()
+	kl.token, kl.tokenTTL, kl.tokenExpiry = resp.Auth.ClientToken, ttl, time.Time{}
+	if ttl > 0 {
+		kl.tokenExpiry = time.Now().Add(ttl)
+	}
+	kl.mu.Unlock()
+	incCounter(metricsLogins)
+	return nil
+}
+
+// readKeys reads the secret or the transit key and returns its public keys by key id
+func (kl *vaultKeyLoader) readKeys() (map[string]interface{}, error) {
+	kl.mu.Lock()
+	token := kl.token
+	kl.mu.Unlock()
+	resp, err := kl.do(http.MethodGet, kl.config.Path, nil, token)
+	if err != nil {
+		return nil, err
+	}
+	kl.mu.Lock()
+	kl.leaseDuration = time.Duration(resp.LeaseDuration) * time.Second
+	kl.mu.Unlock()
+	if kl.config.Engine == EngineTransit {
+		return parseTransitKeys(resp.Data)
+	}
+	return parseSecretKeys(resp.Data, kl.config.Field)
+}
+
+// do sends a request to the Vault HTTP API at path
+func (kl *vaultKeyLoader) do(method string, path string, body io.Reader, token string) (*vaultResponse, error) {
+	u := kl.config.Address.ResolveReference(&url.URL{Path: "v1/" + strings.Trim(path, "/")})
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", ht.UserAgent)
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if kl.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", kl.config.Namespace)
+	}
+	resp, err := kl.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	vr := new(vaultResponse)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(vr); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, ErrPermissionDenied
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("Vault returned status %d for %s: %s", resp.StatusCode, path, strings.Join(vr.Errors, ", "))
+	}
+	return vr, nil
+}
+
+// parseSecretKeys returns the keys in the field of a KV secret, version 1 or 2. The field holds either a
+// JSON Web Key Set, as an object or a string, or PEM encoded keys with kid headers
+func parseSecretKeys(data json.RawMessage, field string) (map[string]interface{}, error) {
+	var secret map[string]json.RawMessage
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, err
+	}
+	// KV version 2 wraps the secret data with its metadata
+	if inner, has := secret["data"]; has {
+		if _, v2 := secret["metadata"]; v2 {
+			secret = nil
+			if err := json.Unmarshal(inner, &secret); err != nil {
+				return nil, err
+			}
+		}
+	}
+	v, has := secret[field]
+	if !has {
+		return nil, fmt.Errorf("%w: %q", ErrMissingField, field)
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		v = []byte(s)
+	}
+	return static.ParseKeys(v)
+}
+
+// parseTransitKeys returns the public keys of all the versions of a transit key. Their key id is the name
+// of the key and the version, for ex. tokeninfo:v2
+func parseTransitKeys(data json.RawMessage) (map[string]interface{}, error) {
+	var key struct {
+		Name string `json:"name"`
+		Keys map[string]struct {
+			PublicKey string `json:"public_key"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(key.Keys))
+	for v := range key.Keys {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+
+	keys := make(map[string]interface{})
+	for _, v := range versions {
+		pub, err := parsePublicKey(key.Keys[v].PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid public key of %s version %s: %v", key.Name, v, err)
+		}
+		kid := fmt.Sprintf("%s:v%s", key.Name, v)
+		keys[kid] = jwk.JSONWebKey{Key: pub, KeyID: kid, Use: "sig"}
+	}
+	if len(keys) == 0 {
+		return nil, static.ErrNoKeys
+	}
+	return keys, nil
+}
+
+// parsePublicKey parses a PEM encoded public key or a base64 encoded Ed25519 key, the formats used by the
+// transit engine
+func parsePublicKey(s string) (interface{}, error) {
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("Not a public key")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testJWKS = `{"keys": [{"kid": "testkey", "kty": "EC", "crv": "P-256", "alg": "ES256",
+	"x": "_5Z_cB5zhjVCt_GMfiC6sSBos0podt-YJicV6_GzDD0", "y": "02LHDzZYup0SlbuqjNPBhr2X_LGamSgRidzKXsA0TFs"}]}`
+
+func init() {
+	startRefresh = func(_ *vaultKeyLoader) {}
+}
+
+func TestKVSecret(t *testing.T) {
+	jwks, _ := json.Marshal(testJWKS)
+	for _, test := range []struct {
+		name    string
+		path    string
+		field   string
+		wantErr bool
+	}{
+		{"kv v2", "secret/data/tokeninfo", "keys", false},
+		{"kv v1 with object", "kv/tokeninfo", "jwks", false},
+		{"missing field", "secret/data/tokeninfo", "pem", true},
+		{"missing secret", "secret/data/missing", "keys", true},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-Vault-Token") != "s.root" || req.Header.Get("X-Vault-Namespace") != "team" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			switch req.URL.Path {
+			case "/v1/secret/data/tokeninfo":
+				fmt.Fprintf(w, `{"data": {"data": {"keys": %s}, "metadata": {"version": 1}}}`, jwks)
+			case "/v1/kv/tokeninfo":
+				fmt.Fprintf(w, `{"lease_duration": 600, "data": {"jwks": %s}}`, testJWKS)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors": []}`)
+			}
+		}))
+		u, _ := url.Parse(server.URL)
+
+		kl, err := NewLoader(Config{Address: u, Namespace: "team", Path: test.path, Engine: EngineKV, Field: test.field,
+			AuthMethod: AuthToken, Token: "s.root", RefreshInterval: time.Hour})
+		server.Close()
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Wanted an error for %s", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.name, err)
+			continue
+		}
+		if _, err := kl.LoadKey("testkey"); err != nil {
+			t.Errorf("Failed to load the key for %s: %v", test.name, err)
+		}
+		if _, err := kl.LoadKey("missing"); err == nil {
+			t.Errorf("Unknown key should not be loaded for %s", test.name)
+		}
+	}
+}
+
+func TestTransitKeys(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ec.PublicKey)
+	ed, _, _ := ed25519.GenerateKey(rand.Reader)
+	keys, _ := json.Marshal(map[string]interface{}{
+		"name": "tokeninfo",
+		"keys": map[string]interface{}{
+			"1": map[string]string{"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+			"2": map[string]string{"public_key": base64.StdEncoding.EncodeToString(ed)},
+		},
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/transit/keys/tokeninfo" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"data": %s}`, keys)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	kl, err := NewLoader(Config{Address: u, Path: "transit/keys/tokeninfo", Engine: EngineTransit, AuthMethod: AuthToken, Token: "s.root"})
+	if err != nil {
+		t.Fatal("Failed to load the transit keys: ", err)
+	}
+	if k, err := kl.LoadKey("tokeninfo:v1"); err != nil || !ec.PublicKey.Equal(k) {
+		t.Errorf("Wrong ECDSA key version 1: %v", err)
+	}
+	if k, err := kl.LoadKey("tokeninfo:v2"); err != nil || !ed.Equal(k) {
+		t.Errorf("Wrong Ed25519 key version 2: %v", err)
+	}
+}
+
+func TestKubernetesLogin(t *testing.T) {
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(jwtFile, []byte("sa-jwt\n"), 0600)
+
+	var logins int
+	token := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/auth/k8s/login":
+			var body map[string]string
+			json.NewDecoder(req.Body).Decode(&body)
+			if body["role"] != "tokeninfo" || body["jwt"] != "sa-jwt" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			logins++
+			token = fmt.Sprintf("s.%d", logins)
+			fmt.Fprintf(w, `{"auth": {"client_token": %q, "lease_duration": 3600}}`, token)
+		case "/v1/secret/data/tokeninfo":
+			if req.Header.Get("X-Vault-Token") != token {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, `{"data": {"data": {"keys": %s}, "metadata": {}}}`, testJWKS)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	c := Config{Address: u, Path: "secret/data/tokeninfo", Engine: EngineKV, Field: "keys", AuthMethod: AuthKubernetes,
+		AuthMount: "k8s", Role: "tokeninfo", ServiceAccountTokenFile: jwtFile, RefreshInterval: 2 * time.Hour}
+	kl, err := NewLoader(c)
+	if err != nil {
+		t.Fatal("Failed to load the keys: ", err)
+	}
+	vkl := kl.(*vaultKeyLoader)
+	if next := vkl.nextRefresh(); next > 40*time.Minute || next < 39*time.Minute {
+		t.Errorf("The token should be renewed after two thirds of its lease, got %v", next)
+	}
+
+	vkl.refresh()
+	if logins != 1 {
+		t.Errorf("A valid token should be reused, got %d logins", logins)
+	}
+
+	// a revoked token is replaced
+	token = "revoked"
+	vkl.refresh()
+	if logins != 2 {
+		t.Errorf("A rejected token should be replaced, got %d logins", logins)
+	}
+	if vkl.LastRefresh().IsZero() {
+		t.Error("Last refresh should be set")
+	}
+}
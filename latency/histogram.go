@@ -0,0 +1,58 @@
+// Package latency records durations in histograms with fixed buckets. Unlike the quantiles of the timers,
+// the buckets of all instances can be added up, for ex. by Prometheus
+package latency
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Buckets are the upper bounds of the buckets in seconds
+var Buckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// A Histogram is a metrics.Histogram of nanoseconds, so that it can be registered and reported like one,
+// that also counts the durations in Buckets
+type Histogram struct {
+	metrics.Histogram
+	// per bucket, the last one has the durations longer than all Buckets
+	counts []int64
+	sum    int64
+}
+
+// NewHistogram returns an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{
+		Histogram: metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015)),
+		counts:    make([]int64, len(Buckets)+1),
+	}
+}
+
+// Update records a duration in nanoseconds
+func (h *Histogram) Update(ns int64) {
+	h.Histogram.Update(ns)
+	atomic.AddInt64(&h.counts[sort.SearchFloat64s(Buckets, float64(ns)/1e9)], 1)
+	atomic.AddInt64(&h.sum, ns)
+}
+
+// Buckets returns the cumulative counts of the durations up to every bound of Buckets, the count of all
+// the durations and their sum in seconds
+func (h *Histogram) Buckets() (cumulative []int64, count int64, sum float64) {
+	cumulative = make([]int64, len(Buckets))
+	for i := range h.counts {
+		count += atomic.LoadInt64(&h.counts[i])
+		if i < len(Buckets) {
+			cumulative[i] = count
+		}
+	}
+	return cumulative, count, float64(atomic.LoadInt64(&h.sum)) / 1e9
+}
+
+// Observe records the time since start in the histogram with the name in the default registry
+func Observe(name string, start time.Time) {
+	if h, ok := metrics.DefaultRegistry.GetOrRegister(name, NewHistogram).(*Histogram); ok {
+		h.Update(int64(time.Since(start)))
+	}
+}
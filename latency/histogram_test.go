@@ -0,0 +1,42 @@
+package latency
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	for _, d := range []time.Duration{500 * time.Microsecond, time.Millisecond, 3 * time.Millisecond, 200 * time.Millisecond, time.Minute} {
+		h.Update(int64(d))
+	}
+	cumulative, count, sum := h.Buckets()
+	want := []int64{2, 2, 3, 3, 3, 3, 3, 4, 4, 4, 4, 4, 4}
+	if !reflect.DeepEqual(cumulative, want) {
+		t.Errorf("Wrong buckets. Wanted %v, got %v", want, cumulative)
+	}
+	if count != 5 || sum != 60.2045 {
+		t.Errorf("Wrong count or sum: %d %v", count, sum)
+	}
+	if h.Snapshot().Count() != 5 {
+		t.Error("The durations should also be sampled")
+	}
+}
+
+func TestObserve(t *testing.T) {
+	r := metrics.NewRegistry()
+	defer func(d metrics.Registry) { metrics.DefaultRegistry = d }(metrics.DefaultRegistry)
+	metrics.DefaultRegistry = r
+
+	Observe("test.latency", time.Now().Add(-time.Second))
+	h, ok := r.Get("test.latency").(*Histogram)
+	if !ok {
+		t.Fatalf("The histogram should be registered, got %T", r.Get("test.latency"))
+	}
+	if _, count, sum := h.Buckets(); count != 1 || sum < 1 {
+		t.Errorf("Wrong count or sum: %d %v", count, sum)
+	}
+}
@@ -0,0 +1,58 @@
+// Package logging sets up the structured logger of the service. The level of the logger can be changed at
+// runtime, for ex. to debug a single client without restarting every instance with verbose logs
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// level is the minimum level of the records written by the logger of Setup
+var level = new(slog.LevelVar)
+
+// levels are the levels that can be set, by name
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// Setup makes a logger that writes to w, as JSON objects with the json format and as key=value pairs
+// otherwise, the default slog logger. The records of the log package go through it at the info level
+func Setup(w io.Writer, format string) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// Fatal logs the message at the error level and exits
+func Fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// ParseLevel returns the level with the name debug, info, warn or error, in any case
+func ParseLevel(name string) (slog.Level, error) {
+	l, ok := levels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return l, nil
+}
+
+// SetLevel changes the minimum level of the logged records
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// LevelName returns the name of l accepted by ParseLevel
+func LevelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	defer func(l *slog.Logger) { slog.SetDefault(l); log.SetOutput(os.Stderr) }(slog.Default())
+	defer SetLevel(slog.LevelInfo)
+
+	var buf bytes.Buffer
+	Setup(&buf, "json")
+	slog.Debug("hidden")
+	log.Print("from the log package")
+	SetLevel(slog.LevelDebug)
+	slog.Debug("shown", "key", "value")
+
+	var records []map[string]interface{}
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal("Failed to decode the log records: ", err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Wrong number of log records. Wanted 2, got %v", records)
+	}
+	if records[0]["msg"] != "from the log package" || records[0]["level"] != "INFO" {
+		t.Errorf("Wrong record of the log package: %v", records[0])
+	}
+	if records[1]["msg"] != "shown" || records[1]["level"] != "DEBUG" || records[1]["key"] != "value" {
+		t.Errorf("Wrong debug record: %v", records[1])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "Warn": slog.LevelWarn, "error": slog.LevelError} {
+		if l, err := ParseLevel(name); err != nil || l != want {
+			t.Errorf("Wrong level for %q. Wanted %v, got %v %v", name, want, l, err)
+		}
+		if l, _ := ParseLevel(name); LevelName(l) != LevelName(want) {
+			t.Errorf("Wrong name of %v: %s", l, LevelName(l))
+		}
+	}
+	for _, name := range []string{"", "trace", "info+2"} {
+		if _, err := ParseLevel(name); err == nil {
+			t.Errorf("Parsing %q should fail", name)
+		}
+	}
+}
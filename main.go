@@ -1,15 +1,30 @@
 package main
 
 import (
-	"log"
+	"flag"
+	"fmt"
+	"os"
 
+	"github.com/zalando/planb-tokeninfo/logging"
 	"github.com/zalando/planb-tokeninfo/options"
 	"github.com/zalando/planb-tokeninfo/runner"
 )
 
 func main() {
-	if err := options.LoadFromEnvironment(); err != nil {
-		log.Fatal(err)
+	validate := flag.Bool("validate-config", false, "check the options and exit")
+	flag.Parse()
+
+	err := options.LoadFromEnvironment()
+	if *validate {
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("The configuration is valid")
+		return
+	}
+	if err != nil {
+		logging.Fatal("Invalid configuration", "error", err)
 	}
-	runner.Run(options.AppSettings)
+	runner.Run(options.Current())
 }
@@ -0,0 +1,31 @@
+// Package metricsink sends the metrics of a registry to monitoring systems that don't scrape the metrics
+// endpoints, for ex. StatsD or the Datadog agent
+package metricsink
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// A Sink sends the current values of the metrics of a registry
+type Sink interface {
+	Send(r metrics.Registry) error
+}
+
+// Run sends the metrics of r to the sink every interval. It never returns
+func Run(s Sink, r metrics.Registry, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.Send(r); err != nil {
+			incCounter("planb.metricsink.failures")
+			slog.Warn("Failed to send the metrics", "error", err)
+		}
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,192 @@
+package metricsink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/latency"
+)
+
+// maxPacketSize keeps the packets within the MTU of most networks, like the StatsD clients do
+const maxPacketSize = 1432
+
+var (
+	// the percentiles of the timers and histograms sent as gauges, by suffix
+	percentiles      = []float64{0.5, 0.95, 0.99}
+	percentileSuffix = []string{"p50", "p95", "p99"}
+	// cacheResults are the values of the cache tag for the cache lookup counters
+	cacheResults = map[string]string{"hits": "hit", "misses": "miss", "expirations": "stale"}
+	// tokenInfoTags are the tags of the counters of the valid tokens by realm and scope
+	tokenInfoTags = map[string]string{"planb.tokeninfo.realms": "realm", "planb.tokeninfo.scopes": "scope"}
+)
+
+// StatsD sends the metrics over UDP in the StatsD line protocol. Counters and meters are sent as the
+// increments since the previous Send, gauges as gauges. Timers and histograms are sent as a counter with
+// the suffix ".count" and gauges for the mean, max and percentiles, timers in milliseconds.
+// With the DogStatsD extensions, the status class of the endpoint counters, the realm and scope of the
+// token counters and the result of the cache lookup counters are sent as tags, for ex.
+// planb.endpoint.responses with endpoint:/oauth2/tokeninfo and status:2xx instead of
+// planb.endpoint./oauth2/tokeninfo.2xx
+type StatsD struct {
+	conn      io.Writer
+	prefix    string
+	tags      []string
+	dogStatsD bool
+	// the counts of the counters, meters, timers and histograms at the previous Send
+	last map[string]int64
+}
+
+// NewStatsD returns a StatsD sink for the agent at address. The prefix, if any, is prepended to the metric
+// names. The tags are added to all the metrics and require the DogStatsD extensions
+func NewStatsD(address string, prefix string, tags []string, dogStatsD bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsD{conn: conn, prefix: prefix, tags: tags, dogStatsD: dogStatsD, last: make(map[string]int64)}, nil
+}
+
+// Send sends the metrics of r in as few packets as possible
+func (s *StatsD) Send(r metrics.Registry) error {
+	p := &packetWriter{w: s.conn}
+	r.Each(func(name string, i interface{}) {
+		s.write(p, name, i)
+	})
+	p.flush()
+	return p.err
+}
+
+func (s *StatsD) write(p *packetWriter, name string, i interface{}) {
+	switch metric := i.(type) {
+	case metrics.Counter:
+		s.count(p, name, name, metric.Count())
+	case metrics.Meter:
+		s.count(p, name, name, metric.Count())
+	case metrics.Gauge:
+		p.line(s.line(name, "", fmt.Sprint(metric.Value()), "g"))
+	case metrics.GaugeFloat64:
+		p.line(s.line(name, "", fmt.Sprintf("%g", metric.Value()), "g"))
+	case *latency.Histogram:
+		h := metric.Snapshot()
+		s.distribution(p, name, h.Count(), float64(h.Max()), h.Mean(), h.Percentiles(percentiles), 1e6)
+	case metrics.Histogram:
+		h := metric.Snapshot()
+		s.distribution(p, name, h.Count(), float64(h.Max()), h.Mean(), h.Percentiles(percentiles), 1)
+	case metrics.Timer:
+		t := metric.Snapshot()
+		s.distribution(p, name, t.Count(), float64(t.Max()), t.Mean(), t.Percentiles(percentiles), 1e6)
+	}
+}
+
+// count sends the increment of a count since the previous Send, if any. A count that went down was reset
+func (s *StatsD) count(p *packetWriter, key string, name string, count int64) {
+	delta := count - s.last[key]
+	if delta < 0 {
+		delta = count
+	}
+	s.last[key] = count
+	if delta != 0 {
+		p.line(s.line(name, "", fmt.Sprint(delta), "c"))
+	}
+}
+
+// distribution sends the count and, if there were values, the mean, max and percentiles divided by scale
+func (s *StatsD) distribution(p *packetWriter, name string, count int64, max float64, mean float64, ps []float64, scale float64) {
+	s.count(p, name, name+".count", count)
+	if count == 0 {
+		return
+	}
+	p.line(s.line(name, ".mean", fmt.Sprintf("%g", mean/scale), "g"))
+	p.line(s.line(name, ".max", fmt.Sprintf("%g", max/scale), "g"))
+	for i, v := range ps {
+		p.line(s.line(name, "."+percentileSuffix[i], fmt.Sprintf("%g", v/scale), "g"))
+	}
+}
+
+// line formats a metric, the suffix is added to the name after the tags were taken from it
+func (s *StatsD) line(name string, suffix string, value string, kind string) string {
+	tags := s.tags
+	if s.dogStatsD {
+		var own []string
+		name, own = tagged(name)
+		if len(own) > 0 {
+			tags = append(append([]string(nil), s.tags...), own...)
+		}
+	}
+	l := sanitizeName(s.prefix+name+suffix) + ":" + value + "|" + kind
+	if s.dogStatsD && len(tags) > 0 {
+		l += "|#" + strings.Join(tags, ",")
+	}
+	return l
+}
+
+// tagged returns the name and tags of a metric with the DogStatsD extensions: the counters of the endpoints
+// by status class, of the tokens by realm and scope and of the cache lookups by result become one metric
+// each, with the differences as tags
+func tagged(name string) (string, []string) {
+	if rest := strings.TrimPrefix(name, "planb.endpoint."); rest != name {
+		if i := strings.LastIndexByte(rest, '.'); i > 0 {
+			return "planb.endpoint.responses", []string{"endpoint:" + sanitizeTag(rest[:i]), "status:" + sanitizeTag(rest[i+1:])}
+		}
+	}
+	for prefix, tag := range tokenInfoTags {
+		if value := strings.TrimPrefix(name, prefix+"."); value != name {
+			return prefix, []string{tag + ":" + sanitizeTag(value)}
+		}
+	}
+	if i := strings.LastIndex(name, ".cache."); i > 0 {
+		if result, ok := cacheResults[name[i+len(".cache."):]]; ok {
+			return name[:i] + ".cache.lookups", []string{"cache:" + result}
+		}
+	}
+	return name, nil
+}
+
+// sanitizeName replaces the characters that are not allowed in StatsD and Graphite metric names
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// sanitizeTag replaces the separators of the DogStatsD line protocol in a tag value
+func sanitizeTag(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(value)
+}
+
+// packetWriter joins the lines into packets of at most maxPacketSize bytes. It keeps the first error
+type packetWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+	err error
+}
+
+func (p *packetWriter) line(l string) {
+	if p.buf.Len() > 0 && p.buf.Len()+1+len(l) > maxPacketSize {
+		p.flush()
+	}
+	if p.buf.Len() > 0 {
+		p.buf.WriteByte('\n')
+	}
+	p.buf.WriteString(l)
+}
+
+func (p *packetWriter) flush() {
+	if p.buf.Len() == 0 {
+		return
+	}
+	if _, err := p.w.Write(p.buf.Bytes()); err != nil && p.err == nil {
+		p.err = err
+	}
+	p.buf.Reset()
+}
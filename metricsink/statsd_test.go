@@ -0,0 +1,140 @@
+package metricsink
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/latency"
+)
+
+func TestTagged(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		wantName string
+		wantTags []string
+	}{
+		{"planb.tokeninfo.proxy", "planb.tokeninfo.proxy", nil},
+		{"planb.endpoint./oauth2/tokeninfo.2xx", "planb.endpoint.responses", []string{"endpoint:/oauth2/tokeninfo", "status:2xx"}},
+		{"planb.tokeninfo.proxy.cache.hits", "planb.tokeninfo.proxy.cache.lookups", []string{"cache:hit"}},
+		{"planb.tokeninfo.userinfo.cache.misses", "planb.tokeninfo.userinfo.cache.lookups", []string{"cache:miss"}},
+		{"planb.tokeninfo.proxy.cache.expirations", "planb.tokeninfo.proxy.cache.lookups", []string{"cache:stale"}},
+		{"planb.tokeninfo.proxy.cache.errors", "planb.tokeninfo.proxy.cache.errors", nil},
+		{"planb.tokeninfo.realms./services", "planb.tokeninfo.realms", []string{"realm:/services"}},
+		{"planb.tokeninfo.scopes.uid", "planb.tokeninfo.scopes", []string{"scope:uid"}},
+	} {
+		name, tags := tagged(test.name)
+		if name != test.wantName || !reflect.DeepEqual(tags, test.wantTags) {
+			t.Errorf("Wrong tags for %s. Wanted %s %v, got %s %v", test.name, test.wantName, test.wantTags, name, tags)
+		}
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	metrics.UseNilMetrics = false
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	r := metrics.NewRegistry()
+	hits := metrics.NewCounter()
+	r.Register("planb.tokeninfo.proxy.cache.hits", hits)
+	r.Register("planb.endpoint./oauth2/tokeninfo.4xx", metrics.NewCounter())
+	r.Register("planb.openidprovider.numkeys", metrics.NewGauge())
+	h := latency.NewHistogram()
+	r.Register("planb.tokeninfo.proxy.upstream.latency", h)
+	hits.Inc(3)
+	r.Get("planb.endpoint./oauth2/tokeninfo.4xx").(metrics.Counter).Inc(1)
+	r.Get("planb.openidprovider.numkeys").(metrics.Gauge).Update(2)
+	h.Update(int64(20 * time.Millisecond))
+
+	for _, test := range []struct {
+		dogStatsD bool
+		want      []string
+		wantNot   []string
+	}{
+		{true, []string{
+			"test.planb.tokeninfo.proxy.cache.lookups:3|c|#env:test,cache:hit",
+			"test.planb.endpoint.responses:1|c|#env:test,endpoint:/oauth2/tokeninfo,status:4xx",
+			"test.planb.openidprovider.numkeys:2|g|#env:test",
+			"test.planb.tokeninfo.proxy.upstream.latency.count:1|c|#env:test",
+			"test.planb.tokeninfo.proxy.upstream.latency.p99:20|g|#env:test",
+		}, nil},
+		{false, []string{
+			"test.planb.tokeninfo.proxy.cache.hits:3|c",
+			"test.planb.endpoint._oauth2_tokeninfo.4xx:1|c",
+			"test.planb.openidprovider.numkeys:2|g",
+		}, []string{"#env:test"}},
+	} {
+		s, err := NewStatsD(pc.LocalAddr().String(), "test", []string{"env:test"}, test.dogStatsD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Send(r); err != nil {
+			t.Fatal("Failed to send the metrics: ", err)
+		}
+		got := readPacket(t, pc)
+		for _, line := range test.want {
+			if !strings.Contains(got, line+"\n") {
+				t.Errorf("Missing line %q in %q", line, got)
+			}
+		}
+		for _, s := range test.wantNot {
+			if strings.Contains(got, s) {
+				t.Errorf("Unexpected %q in %q", s, got)
+			}
+		}
+	}
+
+	// only the increments are sent again
+	s, _ := NewStatsD(pc.LocalAddr().String(), "", nil, true)
+	s.Send(r)
+	readPacket(t, pc)
+	hits.Inc(2)
+	s.Send(r)
+	got := readPacket(t, pc)
+	if !strings.Contains(got, "planb.tokeninfo.proxy.cache.lookups:2|c|#cache:hit\n") || strings.Contains(got, "responses") {
+		t.Errorf("Only the increments of the counters should be sent, got %q", got)
+	}
+}
+
+func readPacket(t *testing.T, pc net.PacketConn) string {
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 2*maxPacketSize)
+	n, _, err := pc.ReadFrom(b)
+	if err != nil {
+		t.Fatal("Failed to read the metrics: ", err)
+	}
+	return string(b[:n]) + "\n"
+}
+
+type packets [][]byte
+
+func (p *packets) Write(b []byte) (int, error) {
+	*p = append(*p, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestPacketWriter(t *testing.T) {
+	var sent packets
+	p := &packetWriter{w: &sent}
+	line := strings.Repeat("x", 100)
+	for i := 0; i < 30; i++ {
+		p.line(line)
+	}
+	p.flush()
+	if len(sent) != 3 {
+		t.Fatalf("Wrong number of packets. Wanted 3, got %d", len(sent))
+	}
+	for _, b := range sent {
+		if len(b) > maxPacketSize || bytes.HasSuffix(b, []byte("\n")) {
+			t.Errorf("Wrong packet of %d bytes", len(b))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// configValues holds the options from the CONFIG_FILE. Environment variables take precedence over them
+var configValues map[string]string
+
+// reloadHooks are called by Reload with the new settings
+var reloadHooks []func(*Settings)
+
+// loadConfigFile reads the options from a JSON object that uses the environment variable names as keys,
+// for ex. {"UPSTREAM_CACHE_TTL": "5m", "UPSTREAM_RETRIES": 2}. Values can be strings, numbers or booleans.
+// Files with the .yaml or .yml extension are read as a YAML mapping with the same keys and values
+func loadConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var raw map[string]interface{}
+		if err := yaml.NewDecoder(f).Decode(&raw); err != nil {
+			return nil, err
+		}
+		return flatValues(raw)
+	default:
+		return DecodeValues(f)
+	}
+}
+
+// DecodeValues reads options from a JSON object that uses the environment variable names as keys, like the
+// CONFIG_FILE
+func DecodeValues(r io.Reader) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	return flatValues(raw)
+}
+
+// flatValues converts the decoded string, number and boolean values to the strings of environment variables
+func flatValues(raw map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[k] = v
+		case float64:
+			values[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int:
+			values[k] = strconv.Itoa(v)
+		case bool:
+			values[k] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("Invalid value for %s: %v", k, v)
+		}
+	}
+	return values, nil
+}
+
+// lookupEnv returns the value of the environment variable v or, if it's not set, the value of v in the
+// config file
+func lookupEnv(v string) (string, bool) {
+	if s, ok := os.LookupEnv(v); ok {
+		return s, true
+	}
+	s, ok := configValues[v]
+	return s, ok
+}
+
+// OnReload registers f to be called with the new settings after every successful Reload
+func OnReload(f func(*Settings)) {
+	reloadHooks = append(reloadHooks, f)
+}
+
+// Reload loads the options again from the environment and the CONFIG_FILE and replaces the current settings.
+// The JWT processors set up at startup are kept. If the options are invalid, the current settings stay
+// in place and the error is returned
+func Reload() error {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+
+	settings, err := load()
+	if err != nil {
+		return err
+	}
+	settings.JwtProcessors = Current().JwtProcessors
+	published.Store(settings)
+	for _, f := range reloadHooks {
+		f(settings)
+	}
+	return nil
+}
@@ -1,12 +1,20 @@
 package options
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/netip"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/jwe"
+	"github.com/zalando/planb-tokeninfo/logging"
 	"github.com/zalando/planb-tokeninfo/processor"
 )
 
@@ -14,29 +22,258 @@ import (
 type Settings struct {
 	ListenAddress                     string
 	MetricsListenAddress              string
+	ReadinessFailureThreshold         int
+	HealthCriticalDependencies        []string
+	HealthRevocationsMaxAge           time.Duration
+	SelftestKeyFile                   string
+	SelftestKeyID                     string
+	SelftestClaims                    map[string]string
+	SelftestToken                     string
+	ExtAuthzListenAddress             string
+	AdminListenAddress                string
+	AdminUsers                        map[string]string
+	DebugListenAddress                string
+	Listeners                         map[string][]string
+	UnixSocketMode                    os.FileMode
+	DebugSnapshotDir                  string
+	TLSCertFile                       string
+	TLSKeyFile                        string
+	TLSReloadInterval                 time.Duration
+	TLSClientCAFile                   string
+	ListenH2C                         bool
+	AccessLogDestination              string
+	AccessLogSampleRate               float64
+	LogLevel                          slog.Level
+	LogFormat                         string
+	TracingEnabled                    bool
+	MetricsSinks                      []string
+	StatsDAddress                     string
+	StatsDPrefix                      string
+	StatsDTags                        []string
+	StatsDFormat                      string
+	StatsDFlushInterval               time.Duration
+	MetricsRealms                     bool
+	MetricsScopes                     []string
+	AuditSink                         string
+	AuditFile                         string
+	AuditURL                          string
+	AuditHMACKey                      string
+	AuditQueueSize                    int
+	AuditFlushInterval                time.Duration
+	KafkaBrokers                      []string
+	KafkaTLS                          bool
+	KafkaTLSCAFile                    string
+	KafkaTLSCertFile                  string
+	KafkaTLSKeyFile                   string
+	KafkaSASLMechanism                string
+	KafkaSASLUsername                 string
+	KafkaSASLPassword                 string
+	KafkaAuditTopic                   string
+	KafkaRevocationsTopic             string
+	KafkaBatchSize                    int
+	KafkaLinger                       time.Duration
+	KafkaTimeout                      time.Duration
+	WebhookURL                        *url.URL
+	WebhookConditions                 []string
+	WebhookExpectedRealms             []string
+	WebhookSecret                     string
+	WebhookFlushInterval              time.Duration
+	RateLimitGlobal                   float64
+	RateLimitPerIP                    float64
+	RateLimitPerToken                 float64
+	TrustedProxies                    []netip.Prefix
+	MaxTokenLength                    int
+	MaxURLLength                      int
+	MaxRequestBodySize                int64
+	CallerAPIKeys                     map[string]string
+	CallerCertSubjects                []string
+	CORSAllowedOrigins                []string
+	CORSAllowedMethods                []string
+	CORSAllowedHeaders                []string
+	CORSMaxAge                        time.Duration
 	UpstreamTokenInfoURL              *url.URL
+	UpstreamTokenInfoURLs             []*url.URL
+	UpstreamBalancing                 string
+	UpstreamEjectDuration             time.Duration
+	UpstreamH2C                       bool
+	UpstreamForwardHeaders            []string
+	UpstreamHeaders                   map[string]string
 	UpstreamTimeout                   time.Duration
+	UpstreamSoftTimeout               time.Duration
+	UpstreamCircuitErrorThreshold     int
+	UpstreamCircuitRequestVolume      int
+	UpstreamCircuitSleepWindow        time.Duration
+	UpstreamRetries                   int
+	UpstreamRetryBackoff              time.Duration
+	UpstreamRetryBudget               float64
+	UpstreamHedgeDelay                time.Duration
+	UpstreamShadowURL                 *url.URL
+	UpstreamShadowPercentage          int
+	UpstreamCanaryURL                 *url.URL
+	UpstreamCanaryPercentage          int
+	UpstreamValidateResponses         bool
+	UpstreamMaxBodySize               int64
+	UpstreamAllowedFields             []string
+	UpstreamStreaming                 bool
 	UpstreamCacheMaxSize              int64
+	UpstreamCacheMaxBytes             int64
+	UpstreamCacheShards               int
 	UpstreamCacheTTL                  time.Duration
+	UpstreamCacheMinTTL               time.Duration
+	UpstreamCacheMaxTTL               time.Duration
+	UpstreamCacheBackend              string
+	UpstreamCacheRedisURL             *url.URL
+	UpstreamCacheSnapshotFile         string
+	UpstreamUserInfoURL               *url.URL
+	UserInfoCacheTTL                  time.Duration
+	UpstreamTokenExchangeURL          *url.URL
+	TokenExchangeCacheTTL             time.Duration
 	OpenIDProviderConfigurationURL    *url.URL
+	OpenIDProviders                   map[string]*url.URL
+	JwksURLs                          []*url.URL
+	VaultAddress                      *url.URL
+	VaultNamespace                    string
+	VaultToken                        string
+	VaultKeysPath                     string
+	VaultKeysEngine                   string
+	VaultKeysField                    string
+	VaultAuthMethod                   string
+	VaultAuthMount                    string
+	VaultAuthRole                     string
+	VaultAuthSecretID                 string
+	VaultKubernetesTokenFile          string
+	VaultRefreshInterval              time.Duration
+	AWSKMSKeys                        map[string]string
+	AWSKMSEndpoint                    *url.URL
+	AWSRegion                         string
+	AWSAccessKeyID                    string
+	AWSSecretAccessKey                string
+	AWSSessionToken                   string
+	GCPKMSKeys                        map[string]string
+	GCPCredentialsFile                string
+	IssuerRealms                      map[string]string
+	IssuerScopeClaims                 map[string]string
+	KeycloakIssuers                   []string
+	AzureADIssuers                    []string
+	AzureADTenants                    []string
+	AzureADAudiences                  []string
+	GoogleIDTokenAudiences            []string
+	GoogleIDTokenRealm                string
+	SPIFFEBundles                     map[string]*url.URL
+	SPIFFEAudiences                   []string
+	SPIFFERealm                       string
 	OpenIDProviderRefreshInterval     time.Duration
+	OpenIDProviderRefreshJitter       float64
+	OpenIDProviderRefreshBackoff      time.Duration
+	OpenIDProviderRefreshMaxBackoff   time.Duration
+	OpenIDProviderKidRefreshInterval  time.Duration
+	OpenIDProviderKeyGracePeriod      time.Duration
+	KeysPreloadTimeout                time.Duration
+	StaticKeys                        string
+	StaticKeysFile                    string
+	StaticKeysReloadInterval          time.Duration
 	HTTPClientTimeout                 time.Duration
 	HTTPClientTLSTimeout              time.Duration
+	HTTPClientCertFile                string
+	HTTPClientKeyFile                 string
+	HTTPClientCAFile                  string
 	RevocationCacheTTL                time.Duration
 	RevocationProviderRefreshInterval time.Duration
 	RevocationRefreshTolerance        time.Duration
 	RevocationProviderUrl             *url.URL
+	RevocationPushClients             map[string]string
+	RevocationSnapshotFile            string
 	HashingSalt                       string
+	TokenHashSalt                     string
+	ClockSkew                         time.Duration
+	ErrorReasons                      bool
+	JwtAllowedAlgorithms              []string
+	IssuerAlgorithms                  map[string][]string
+	JwtVerifyPercentage               int
+	ChaosMode                         bool
+	ChaosLatency                      time.Duration
+	ChaosLatencyPercentage            int
+	ChaosErrorPercentage              int
+	ChaosErrorStatus                  int
+	ChaosMalformedPercentage          int
+	DevMode                           bool
+	JtiDenyListURL                    *url.URL
+	JtiDenyListRefreshInterval        time.Duration
+	JtiDenyListRedisKey               string
+	JtiDenyListFailClosed             bool
+	InvalidationRedisURL              *url.URL
+	InvalidationChannel               string
+	ClaimMapping                      *processor.ClaimMapping
+	RealmRules                        *processor.RealmRules
+	ScopePolicy                       *processor.ScopePolicy
+	JWEDecrypter                      *jwe.Decrypter
+	OPAURL                            *url.URL
+	OPATimeout                        time.Duration
+	OPAFailOpen                       bool
+	TokenRoutes                       *processor.TokenRoutes
 	JwtProcessors                     map[string]processor.JwtProcessor
+	IntrospectionClients              map[string]string
+	BatchMaxTokens                    int
+	ResponseCacheMaxAge               time.Duration
+	ResponseCacheDirectives           string
+	ResponseCanonicalJSON             bool
+	ResponseFieldOrder                []string
+	ResponseETags                     bool
+	DiscoveryIssuer                   *url.URL
+	DiscoveryHosts                    []string
 }
 
 const (
 	defaultListenAddress                 = ":9021"
 	defaultMetricsListenAddress          = ":9020"
+	defaultReadinessFailureThreshold     = 3
+	defaultHealthRevocationsMaxAge       = 5 * time.Minute
+	defaultBatchMaxTokens                = 1000
+	defaultGoogleIDTokenRealm            = "/services"
+	defaultSPIFFERealm                   = "/services"
+	googleIssuer                         = "https://accounts.google.com"
+	googleConfigurationURL               = "https://accounts.google.com/.well-known/openid-configuration"
+	defaultMaxTokenLength                = 16 * 1024
+	defaultMaxURLLength                  = 32 * 1024
+	defaultMaxRequestBodySize            = 64 * 1024
+	defaultTLSReloadInterval             = 1 * time.Minute
+	defaultStaticKeysReloadInterval      = 1 * time.Minute
+	defaultVaultRefreshInterval          = 5 * time.Minute
+	defaultVaultKubernetesTokenFile      = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultOPATimeout                    = 500 * time.Millisecond
+	defaultAccessLogSampleRate           = 1.0
+	defaultStatsDAddress                 = "127.0.0.1:8125"
+	defaultStatsDFormat                  = "dogstatsd"
+	defaultStatsDFlushInterval           = 10 * time.Second
+	defaultAuditQueueSize                = 10000
+	defaultAuditFlushInterval            = 1 * time.Second
+	defaultKafkaBatchSize                = 100
+	defaultKafkaLinger                   = 1 * time.Second
+	defaultKafkaTimeout                  = 10 * time.Second
+	defaultWebhookFlushInterval          = 10 * time.Second
 	defaultUpstreamCacheMaxSize          = 10000
+	defaultUpstreamCacheShards           = 16
+	defaultUserInfoCacheTTL              = 60 * time.Second
+	defaultJtiDenyListRefreshInterval    = 1 * time.Minute
+	defaultJtiDenyListRedisKey           = "tokeninfo:denied-jti"
+	defaultInvalidationChannel           = "tokeninfo:invalidation"
+	defaultUpstreamMaxBodySize           = 1024 * 1024
 	defaultUpstreamCacheTTL              = 60 * time.Second
+	defaultUpstreamCacheBackend          = "memory"
 	defaultUpstreamTimeout               = 1 * time.Second
+	defaultUpstreamBalancing             = "round-robin"
+	defaultUpstreamEjectDuration         = 10 * time.Second
+	defaultUpstreamCircuitErrorThreshold = 50
+	defaultUpstreamCircuitRequestVolume  = 20
+	defaultUpstreamCircuitSleepWindow    = 5 * time.Second
+	defaultUpstreamRetryBackoff          = 50 * time.Millisecond
+	defaultUpstreamRetryBudget           = 0.1
 	defaultOpenIDRefreshInterval         = 30 * time.Second
+	defaultOpenIDRefreshJitter           = 0.1
+	defaultOpenIDRefreshBackoff          = time.Second
+	defaultOpenIDRefreshMaxBackoff       = 30 * time.Second
+	defaultChaosErrorStatus              = 503
+	defaultOpenIDKidRefreshInterval      = 10 * time.Second
 	defaultHTTPClientTimeout             = 10 * time.Second
 	defaultHTTPClientTLSTimeout          = 10 * time.Second
 	defaultRevocationCacheTTL            = 30 * 24 * time.Hour
@@ -45,19 +282,91 @@ const (
 	defaultHashingSalt                   = "seasaltisthebest"
 )
 
-var (
-	// AppSettings is a global variable that holds the application settings
-	AppSettings = defaultSettings()
-)
+// published holds the application settings. They are built completely before they are stored and are never
+// modified afterwards, a change stores new settings
+var published atomic.Pointer[Settings]
+
+func init() {
+	published.Store(defaultSettings())
+}
+
+// Current returns the application settings
+func Current() *Settings {
+	return published.Load()
+}
+
+// Replace stores s as the application settings and returns the previous ones
+func Replace(s *Settings) *Settings {
+	return published.Swap(s)
+}
+
+// healthDependencies are the dependencies reported by the health endpoint
+var healthDependencies = map[string]bool{"keys": true, "upstream": true, "cache": true, "revocations": true}
+
+// metricsSinks are the destinations of METRICS_SINKS: the metrics endpoints and StatsD
+var metricsSinks = map[string]bool{"http": true, "statsd": true}
+
+// statsDFormats are the line protocols of STATSD_FORMAT
+var statsDFormats = map[string]bool{"statsd": true, "dogstatsd": true}
+
+// auditSinks are the destinations of AUDIT_SINK
+var auditSinks = map[string]bool{"file": true, "http": true, "kafka": true, "kafka-native": true}
+
+// kafkaSASLMechanisms are the mechanisms of KAFKA_SASL_MECHANISM
+var kafkaSASLMechanisms = map[string]bool{"PLAIN": true, "SCRAM-SHA-256": true, "SCRAM-SHA-512": true}
+
+// webhookConditions are the conditions of WEBHOOK_CONDITIONS
+var webhookConditions = map[string]bool{"new_token": true, "revoked_token": true, "unexpected_realm": true}
+
+// listenerHandlers are the groups of endpoints a listener of LISTENERS can serve
+var listenerHandlers = map[string]bool{"tokeninfo": true, "health": true, "metrics": true, "admin": true, "debug": true}
 
 func defaultSettings() *Settings {
 	return &Settings{
 		ListenAddress:                     defaultListenAddress,
 		MetricsListenAddress:              defaultMetricsListenAddress,
+		ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+		HealthCriticalDependencies:        []string{"keys"},
+		HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+		BatchMaxTokens:                    defaultBatchMaxTokens,
+		TLSReloadInterval:                 defaultTLSReloadInterval,
+		StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+		AccessLogSampleRate:               defaultAccessLogSampleRate,
+		MetricsSinks:                      []string{"http"},
+		StatsDAddress:                     defaultStatsDAddress,
+		StatsDFormat:                      defaultStatsDFormat,
+		StatsDFlushInterval:               defaultStatsDFlushInterval,
+		AuditQueueSize:                    defaultAuditQueueSize,
+		AuditFlushInterval:                defaultAuditFlushInterval,
+		KafkaBatchSize:                    defaultKafkaBatchSize,
+		KafkaLinger:                       defaultKafkaLinger,
+		KafkaTimeout:                      defaultKafkaTimeout,
+		WebhookFlushInterval:              defaultWebhookFlushInterval,
+		MaxTokenLength:                    defaultMaxTokenLength,
+		MaxURLLength:                      defaultMaxURLLength,
+		MaxRequestBodySize:                defaultMaxRequestBodySize,
 		UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+		UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+		UpstreamCacheShards:               defaultUpstreamCacheShards,
+		UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+		JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+		JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+		InvalidationChannel:               defaultInvalidationChannel,
 		UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+		UpstreamCacheBackend:              defaultUpstreamCacheBackend,
 		UpstreamTimeout:                   defaultUpstreamTimeout,
+		UpstreamBalancing:                 defaultUpstreamBalancing,
+		UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+		UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+		UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+		UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+		UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+		UpstreamRetryBudget:               defaultUpstreamRetryBudget,
 		OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+		OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+		OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+		OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+		OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 		HTTPClientTimeout:                 defaultHTTPClientTimeout,
 		HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 		RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -73,37 +382,396 @@ func defaultSettings() *Settings {
 // variables are:
 //
 //      UPSTREAM_TOKENINFO_URL
-//      OPENID_PROVIDER_CONFIGURATION_URL
-//	REVOCATION_PROVIDER_URL
+//      OPENID_PROVIDER_CONFIGURATION_URL (optional when OPENID_PROVIDERS, SPIFFE_BUNDLES, JWKS_URLS, VAULT_KEYS_PATH,
+//      AWS_KMS_KEYS, GCP_KMS_KEYS, STATIC_KEYS, STATIC_KEYS_FILE or DEV_MODE is set)
+//	REVOCATION_PROVIDER_URL (optional with DEV_MODE)
 //
-// The remaining options have sane defaults and are not mandatory
+// The remaining options have sane defaults and are not mandatory. Options can also be set in the JSON file
+// at CONFIG_FILE, environment variables take precedence. Invalid options are returned in a ConfigError and
+// leave the current settings unchanged
 func LoadFromEnvironment() error {
+	settings, err := load()
+	if err != nil {
+		return err
+	}
+	published.Store(settings)
+	return nil
+}
+
+// load reads the options. The invalid values and combinations of options are returned together in a
+// ConfigError
+func load() (*Settings, error) {
 	settings := defaultSettings()
+	val := new(validator)
+
+	configValues = nil
+	if s := os.Getenv("CONFIG_FILE"); s != "" {
+		values, err := loadConfigFile(s)
+		if err != nil {
+			val.fail("Invalid CONFIG_FILE: %v", err)
+		}
+		configValues = values
+	}
 
 	if s := getString("UPSTREAM_TOKENINFO_URL", ""); s != "" {
-		tokeninfoURL, err := getURL("UPSTREAM_TOKENINFO_URL")
+		tokeninfoURLs, err := getURLs("UPSTREAM_TOKENINFO_URL")
 		if err != nil {
-			return fmt.Errorf("Error with UPSTREAM_TOKENINFO_URL: %v\n", err)
+			val.fail("Error with UPSTREAM_TOKENINFO_URL: %v", err)
+		} else {
+			settings.UpstreamTokenInfoURL = tokeninfoURLs[0]
+			settings.UpstreamTokenInfoURLs = tokeninfoURLs
 		}
-		settings.UpstreamTokenInfoURL = tokeninfoURL
 	}
 
-	openIDConfiguration, err := getURL("OPENID_PROVIDER_CONFIGURATION_URL")
-	if err != nil || openIDConfiguration == nil {
-		return fmt.Errorf("Invalid OPENID_PROVIDER_CONFIGURATION_URL: %v\n", err)
+	switch s := getString("UPSTREAM_BALANCING", ""); s {
+	case "":
+	case "round-robin", "failover":
+		settings.UpstreamBalancing = s
+	default:
+		val.fail("Invalid UPSTREAM_BALANCING: %q", s)
+	}
+
+	if d := val.getDuration("UPSTREAM_EJECT_DURATION", 0); d > 0 {
+		settings.UpstreamEjectDuration = d
 	}
-	settings.OpenIDProviderConfigurationURL = openIDConfiguration
 
-	revocationURL, err := getURL("REVOCATION_PROVIDER_URL")
-	if err != nil || revocationURL == nil {
-		return fmt.Errorf("Invalid REVOCATION_PROVIDER_URL: %v\n", err)
+	settings.UpstreamH2C = val.getBool("UPSTREAM_H2C", false)
+	settings.UpstreamForwardHeaders = getStrings("UPSTREAM_FORWARD_HEADERS")
+	settings.UpstreamHeaders = getStringMap("UPSTREAM_HEADERS")
+	if i := val.getInt("UPSTREAM_MAX_BODY_SIZE", 0); i > 0 {
+		settings.UpstreamMaxBodySize = int64(i)
+	}
+	settings.UpstreamValidateResponses = val.getBool("UPSTREAM_VALIDATE_RESPONSES", false)
+	settings.UpstreamAllowedFields = getStrings("UPSTREAM_ALLOWED_FIELDS")
+
+	providers, err := getURLMap("OPENID_PROVIDERS")
+	if err != nil {
+		val.fail("Invalid OPENID_PROVIDERS: %v", err)
+	}
+	settings.OpenIDProviders = providers
+
+	bundles, err := getURLMap("SPIFFE_BUNDLES")
+	if err != nil {
+		val.fail("Invalid SPIFFE_BUNDLES: %v", err)
+	}
+	if len(bundles) > 0 {
+		for td := range bundles {
+			if td == "" || strings.ContainsAny(td, "/:") {
+				val.fail("Invalid SPIFFE_BUNDLES: %q is not a trust domain", td)
+			}
+		}
+		settings.SPIFFEBundles = bundles
+		settings.SPIFFEAudiences = getStrings("SPIFFE_AUDIENCES")
+		if len(settings.SPIFFEAudiences) == 0 {
+			val.fail("SPIFFE_BUNDLES requires SPIFFE_AUDIENCES")
+		}
+		settings.SPIFFERealm = getString("SPIFFE_REALM", defaultSPIFFERealm)
+	}
+
+	settings.StaticKeys = getString("STATIC_KEYS", "")
+	settings.StaticKeysFile = getString("STATIC_KEYS_FILE", "")
+	staticKeys := settings.StaticKeys != "" || settings.StaticKeysFile != ""
+	if settings.StaticKeys != "" && settings.StaticKeysFile != "" {
+		val.fail("STATIC_KEYS and STATIC_KEYS_FILE can't be used together")
+	}
+
+	if d := val.getDuration("STATIC_KEYS_RELOAD_INTERVAL", 0); d > 0 {
+		settings.StaticKeysReloadInterval = d
+	}
+
+	if getString("JWKS_URLS", "") != "" {
+		if staticKeys {
+			val.fail("JWKS_URLS can't be used together with static keys")
+		}
+		jwksURLs, err := getURLs("JWKS_URLS")
+		if err != nil {
+			val.fail("Invalid JWKS_URLS: %v", err)
+		}
+		settings.JwksURLs = jwksURLs
+	}
+
+	if s := getString("VAULT_KEYS_PATH", ""); s != "" {
+		if staticKeys {
+			val.fail("VAULT_KEYS_PATH can't be used together with static keys")
+		}
+		vaultAddress, err := getURL("VAULT_ADDR")
+		if err != nil || vaultAddress == nil {
+			val.fail("Invalid VAULT_ADDR: %v", err)
+		}
+		settings.VaultAddress = vaultAddress
+		settings.VaultKeysPath = s
+		settings.VaultNamespace = getString("VAULT_NAMESPACE", "")
+		settings.VaultKeysField = getString("VAULT_KEYS_FIELD", "keys")
+		settings.VaultAuthMount = getString("VAULT_AUTH_MOUNT", "")
+		settings.VaultAuthRole = getString("VAULT_AUTH_ROLE", "")
+		settings.VaultRefreshInterval = val.getDuration("VAULT_REFRESH_INTERVAL", defaultVaultRefreshInterval)
+
+		switch e := getString("VAULT_KEYS_ENGINE", "kv"); e {
+		case "kv", "transit":
+			settings.VaultKeysEngine = e
+		default:
+			val.fail("Invalid VAULT_KEYS_ENGINE: %q", e)
+		}
+		settings.VaultAuthMethod = getString("VAULT_AUTH_METHOD", "token")
+		switch settings.VaultAuthMethod {
+		case "token":
+			if settings.VaultToken = getString("VAULT_TOKEN", ""); settings.VaultToken == "" {
+				val.fail("VAULT_TOKEN is required with the token auth method")
+			}
+		case "kubernetes":
+			settings.VaultKubernetesTokenFile = getString("VAULT_KUBERNETES_TOKEN_FILE", defaultVaultKubernetesTokenFile)
+		case "approle":
+			if settings.VaultAuthSecretID = getString("VAULT_AUTH_SECRET_ID", ""); settings.VaultAuthSecretID == "" {
+				val.fail("VAULT_AUTH_SECRET_ID is required with the approle auth method")
+			}
+		default:
+			val.fail("Invalid VAULT_AUTH_METHOD: %q", settings.VaultAuthMethod)
+		}
+		if settings.VaultAuthMethod != "token" && settings.VaultAuthRole == "" {
+			val.fail("VAULT_AUTH_ROLE is required with the %s auth method", settings.VaultAuthMethod)
+		}
+	}
+
+	if m := getStringMapSep("AWS_KMS_KEYS", "="); len(m) > 0 {
+		if staticKeys {
+			val.fail("AWS_KMS_KEYS can't be used together with static keys")
+		}
+		if err := checkKMSKeys(m); err != nil {
+			val.fail("Invalid AWS_KMS_KEYS: %v", err)
+		}
+		settings.AWSKMSKeys = m
+		settings.AWSRegion = getString("AWS_REGION", "")
+		settings.AWSAccessKeyID = getString("AWS_ACCESS_KEY_ID", "")
+		settings.AWSSecretAccessKey = getString("AWS_SECRET_ACCESS_KEY", "")
+		settings.AWSSessionToken = getString("AWS_SESSION_TOKEN", "")
+		if settings.AWSAccessKeyID == "" || settings.AWSSecretAccessKey == "" {
+			val.fail("AWS_KMS_KEYS requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		if getString("AWS_KMS_ENDPOINT", "") != "" {
+			endpoint, err := getURL("AWS_KMS_ENDPOINT")
+			if err != nil {
+				val.fail("Error with AWS_KMS_ENDPOINT: %v", err)
+			}
+			settings.AWSKMSEndpoint = endpoint
+		}
 	}
-	settings.RevocationProviderUrl = revocationURL
+
+	if m := getStringMapSep("GCP_KMS_KEYS", "="); len(m) > 0 {
+		if staticKeys {
+			val.fail("GCP_KMS_KEYS can't be used together with static keys")
+		}
+		if err := checkKMSKeys(m); err != nil {
+			val.fail("Invalid GCP_KMS_KEYS: %v", err)
+		}
+		settings.GCPKMSKeys = m
+		settings.GCPCredentialsFile = getString("GOOGLE_APPLICATION_CREDENTIALS", "")
+	}
+
+	// the test tokens of DEV_MODE are validated with its own key. Anyone can get them with any claims, so they must never be
+	// accepted next to real tokens
+	settings.DevMode = val.getBool("DEV_MODE", false)
+	if settings.DevMode {
+		for _, v := range []string{"UPSTREAM_TOKENINFO_URL", "OPENID_PROVIDER_CONFIGURATION_URL", "OPENID_PROVIDERS", "SPIFFE_BUNDLES", "JWKS_URLS",
+			"VAULT_KEYS_PATH", "AWS_KMS_KEYS", "GCP_KMS_KEYS", "STATIC_KEYS", "STATIC_KEYS_FILE", "GOOGLE_ID_TOKEN_AUDIENCES"} {
+			if getString(v, "") != "" {
+				val.fail("DEV_MODE can't be used together with %s", v)
+			}
+		}
+	}
+
+	// the default provider is only optional when there is at least one provider per issuer or SPIFFE bundle, or when JWKS URLs,
+	// Vault, KMS, static keys or DEV_MODE replace it
+	keySources := settings.DevMode || len(providers) > 0 || len(bundles) > 0 || len(settings.JwksURLs) > 0 || settings.VaultKeysPath != "" ||
+		len(settings.AWSKMSKeys) > 0 || len(settings.GCPKMSKeys) > 0
+	if s := getString("OPENID_PROVIDER_CONFIGURATION_URL", ""); staticKeys && s != "" {
+		val.fail("OPENID_PROVIDER_CONFIGURATION_URL can't be used together with static keys")
+	} else if !staticKeys && (s != "" || !keySources) {
+		openIDConfiguration, err := getURL("OPENID_PROVIDER_CONFIGURATION_URL")
+		if err != nil || openIDConfiguration == nil {
+			val.fail("Invalid OPENID_PROVIDER_CONFIGURATION_URL: %v", err)
+		}
+		settings.OpenIDProviderConfigurationURL = openIDConfiguration
+	}
+
+	if m := getStringMapSep("ISSUER_REALMS", "="); len(m) > 0 {
+		settings.IssuerRealms = m
+	}
+
+	if m := getStringMapSep("ISSUER_SCOPE_CLAIMS", "="); len(m) > 0 {
+		settings.IssuerScopeClaims = m
+	}
+
+	settings.KeycloakIssuers = getStrings("KEYCLOAK_ISSUERS")
+	for _, iss := range settings.KeycloakIssuers {
+		if _, has := settings.IssuerScopeClaims[iss]; has {
+			val.fail("ISSUER_SCOPE_CLAIMS can't be set for the Keycloak issuer %s", iss)
+		}
+	}
+
+	settings.AzureADIssuers = getStrings("AZURE_AD_ISSUERS")
+	for _, iss := range settings.AzureADIssuers {
+		if _, has := settings.IssuerScopeClaims[iss]; has {
+			val.fail("ISSUER_SCOPE_CLAIMS can't be set for the Azure AD issuer %s", iss)
+		}
+		for _, kc := range settings.KeycloakIssuers {
+			if kc == iss {
+				val.fail("%s can't be both a Keycloak and an Azure AD issuer", iss)
+			}
+		}
+	}
+	settings.AzureADTenants = getStrings("AZURE_AD_TENANTS")
+	settings.AzureADAudiences = getStrings("AZURE_AD_AUDIENCES")
+	// a template issuer matches the tokens of any tenant, they are only accepted for the known tenants and audiences
+	for _, iss := range settings.AzureADIssuers {
+		if !strings.Contains(iss, "{tenantid}") {
+			continue
+		}
+		if len(settings.AzureADTenants) == 0 {
+			val.fail("AZURE_AD_TENANTS is required for the Azure AD issuer %s", iss)
+		}
+		if len(settings.AzureADAudiences) == 0 {
+			val.fail("AZURE_AD_AUDIENCES is required for the Azure AD issuer %s", iss)
+		}
+	}
+
+	if l := getStrings("GOOGLE_ID_TOKEN_AUDIENCES"); len(l) > 0 {
+		if _, has := settings.IssuerScopeClaims[googleIssuer]; has {
+			val.fail("ISSUER_SCOPE_CLAIMS can't be set for %s with GOOGLE_ID_TOKEN_AUDIENCES", googleIssuer)
+		}
+		settings.GoogleIDTokenAudiences = l
+		settings.GoogleIDTokenRealm = getString("GOOGLE_ID_TOKEN_REALM", defaultGoogleIDTokenRealm)
+		// the keys of Google are only used for its own tokens
+		if _, has := settings.OpenIDProviders[googleIssuer]; !has {
+			u, _ := url.Parse(googleConfigurationURL)
+			if settings.OpenIDProviders == nil {
+				settings.OpenIDProviders = make(map[string]*url.URL)
+			}
+			settings.OpenIDProviders[googleIssuer] = u
+		}
+	}
+
+	// without a Revocation service in DEV_MODE, only the pushed revocations apply
+	if getString("REVOCATION_PROVIDER_URL", "") != "" || !settings.DevMode {
+		revocationURL, err := getURL("REVOCATION_PROVIDER_URL")
+		if err != nil || revocationURL == nil {
+			val.fail("Invalid REVOCATION_PROVIDER_URL: %v", err)
+		}
+		settings.RevocationProviderUrl = revocationURL
+	}
+
+	settings.TokenHashSalt = getString("TOKEN_HASH_SALT", "")
 
 	if s := getString("REVOCATION_HASHING_SALT", ""); s != "" {
 		settings.HashingSalt = s
 	}
 
+	if d := val.getDuration("TOKENINFO_CLOCK_SKEW", 0); d > 0 {
+		settings.ClockSkew = d
+	}
+
+	settings.ErrorReasons = val.getBool("TOKENINFO_ERROR_REASONS", false)
+	settings.JwtAllowedAlgorithms = getStrings("JWT_ALLOWED_ALGORITHMS")
+	if err := checkAlgorithms(settings.JwtAllowedAlgorithms); err != nil {
+		val.fail("Error with JWT_ALLOWED_ALGORITHMS: %v", err)
+	}
+
+	if s := getString("JTI_DENYLIST_URL", ""); s != "" {
+		u, err := getURL("JTI_DENYLIST_URL")
+		if err != nil {
+			val.fail("Error with JTI_DENYLIST_URL: %v", err)
+		}
+		settings.JtiDenyListURL = u
+	}
+
+	if d := val.getDuration("JTI_DENYLIST_REFRESH_INTERVAL", 0); d > 0 {
+		settings.JtiDenyListRefreshInterval = d
+	}
+
+	if s := getString("JTI_DENYLIST_REDIS_KEY", ""); s != "" {
+		settings.JtiDenyListRedisKey = s
+	}
+
+	settings.JtiDenyListFailClosed = val.getBool("JTI_DENYLIST_FAIL_CLOSED", false)
+
+	if s := getString("INVALIDATION_REDIS_URL", ""); s != "" {
+		u, err := getURL("INVALIDATION_REDIS_URL")
+		if err != nil {
+			val.fail("Error with INVALIDATION_REDIS_URL: %v", err)
+		}
+		settings.InvalidationRedisURL = u
+	}
+
+	if s := getString("INVALIDATION_CHANNEL", ""); s != "" {
+		settings.InvalidationChannel = s
+	}
+
+	if m := getStringMapSep("ISSUER_ALGORITHMS", "="); len(m) > 0 {
+		settings.IssuerAlgorithms = make(map[string][]string, len(m))
+		for iss, s := range m {
+			algs := strings.Fields(s)
+			if len(algs) == 0 {
+				val.fail("Error with ISSUER_ALGORITHMS: no algorithms for %q", iss)
+			}
+			if err := checkAlgorithms(algs); err != nil {
+				val.fail("Error with ISSUER_ALGORITHMS: %v", err)
+			}
+			settings.IssuerAlgorithms[iss] = algs
+		}
+	}
+
+	if s := getString("CLAIM_MAPPING_FILE", ""); s != "" {
+		cm, err := loadClaimMapping(s)
+		if err != nil {
+			val.fail("Invalid CLAIM_MAPPING_FILE: %v", err)
+		}
+		settings.ClaimMapping = cm
+	}
+
+	if s := getString("REALM_RULES_FILE", ""); s != "" {
+		rr, err := loadRealmRules(s)
+		if err != nil {
+			val.fail("Invalid REALM_RULES_FILE: %v", err)
+		}
+		settings.RealmRules = rr
+	}
+
+	if s := getString("SCOPE_POLICY_FILE", ""); s != "" {
+		p, err := loadScopePolicy(s)
+		if err != nil {
+			val.fail("Invalid SCOPE_POLICY_FILE: %v", err)
+		}
+		settings.ScopePolicy = p
+	}
+
+	if m := getStringMapSep("JWE_KEYS", "="); len(m) > 0 {
+		d, err := jwe.LoadDecrypter(m)
+		if err != nil {
+			val.fail("Invalid JWE_KEYS: %v", err)
+		}
+		settings.JWEDecrypter = d
+	}
+
+	if s := getString("OPA_URL", ""); s != "" {
+		u, err := getURL("OPA_URL")
+		if err != nil {
+			val.fail("Error with OPA_URL: %v", err)
+		}
+		settings.OPAURL = u
+		settings.OPATimeout = val.getDuration("OPA_TIMEOUT", defaultOPATimeout)
+		settings.OPAFailOpen = val.getBool("OPA_FAIL_OPEN", false)
+	}
+
+	if s := getString("TOKEN_ROUTES_FILE", ""); s != "" {
+		tr, err := loadTokenRoutes(s)
+		if err != nil {
+			val.fail("Invalid TOKEN_ROUTES_FILE: %v", err)
+		} else if len(tr.Upstreams()) > 0 && settings.UpstreamTokenInfoURL == nil {
+			// the routes to other upstreams use the cache of the upstream token info
+			val.fail("UPSTREAM_TOKENINFO_URL is required for the upstream URLs of TOKEN_ROUTES_FILE")
+		}
+		settings.TokenRoutes = tr
+	}
+
 	if s := getString("LISTEN_ADDRESS", ""); s != "" {
 		settings.ListenAddress = s
 	}
@@ -112,87 +780,862 @@ func LoadFromEnvironment() error {
 		settings.MetricsListenAddress = s
 	}
 
-	if i := getInt("UPSTREAM_CACHE_MAX_SIZE", -1); i > -1 {
+	if i := val.getInt("READINESS_FAILURE_THRESHOLD", 0); i > 0 {
+		settings.ReadinessFailureThreshold = i
+	}
+
+	if deps := getStrings("HEALTH_CRITICAL_DEPENDENCIES"); len(deps) > 0 {
+		for _, dep := range deps {
+			if !healthDependencies[dep] {
+				val.invalid("HEALTH_CRITICAL_DEPENDENCIES", dep, "is not keys, upstream, cache or revocations")
+			}
+		}
+		settings.HealthCriticalDependencies = deps
+	}
+
+	if d := val.getDuration("HEALTH_REVOCATIONS_MAX_AGE", 0); d > 0 {
+		settings.HealthRevocationsMaxAge = d
+	}
+
+	settings.SelftestKeyFile = getString("SELFTEST_KEY_FILE", "")
+	settings.SelftestKeyID = getString("SELFTEST_KEY_ID", "")
+	if (settings.SelftestKeyFile == "") != (settings.SelftestKeyID == "") {
+		val.fail("SELFTEST_KEY_FILE and SELFTEST_KEY_ID must be set together")
+	}
+	settings.SelftestClaims = getStringMap("SELFTEST_CLAIMS")
+	settings.SelftestToken = getString("SELFTEST_TOKEN", "")
+	if settings.SelftestToken != "" && settings.SelftestKeyFile != "" {
+		val.fail("SELFTEST_TOKEN and SELFTEST_KEY_FILE can't be used together")
+	}
+
+	settings.ExtAuthzListenAddress = getString("EXT_AUTHZ_LISTEN_ADDRESS", "")
+
+	settings.AdminListenAddress = getString("ADMIN_LISTEN_ADDRESS", "")
+	settings.AdminUsers = getStringMap("ADMIN_USERS")
+	if settings.AdminListenAddress != "" && len(settings.AdminUsers) == 0 {
+		val.fail("ADMIN_USERS is required with ADMIN_LISTEN_ADDRESS")
+	}
+
+	settings.DebugListenAddress = getString("DEBUG_LISTEN_ADDRESS", "")
+	settings.DebugSnapshotDir = getString("DEBUG_SNAPSHOT_DIR", "")
+
+	if m := getStringMapSep("LISTENERS", "="); len(m) > 0 {
+		settings.Listeners = make(map[string][]string, len(m))
+		for address, s := range m {
+			handlers := strings.Fields(s)
+			if len(handlers) == 0 {
+				val.fail("Error with LISTENERS: no handlers for %q", address)
+			}
+			for _, h := range handlers {
+				if !listenerHandlers[h] {
+					val.fail("Error with LISTENERS: unknown handler %q", h)
+				}
+				if h == "admin" && len(settings.AdminUsers) == 0 {
+					val.fail("ADMIN_USERS is required for the admin handler of LISTENERS")
+				}
+			}
+			settings.Listeners[address] = handlers
+		}
+	}
+
+	settings.TLSCertFile = getString("TOKENINFO_TLS_CERT_FILE", "")
+	settings.TLSKeyFile = getString("TOKENINFO_TLS_KEY_FILE", "")
+	if (settings.TLSCertFile == "") != (settings.TLSKeyFile == "") {
+		val.fail("TOKENINFO_TLS_CERT_FILE and TOKENINFO_TLS_KEY_FILE must be set together")
+	}
+
+	if d := val.getDuration("TOKENINFO_TLS_RELOAD_INTERVAL", 0); d > 0 {
+		settings.TLSReloadInterval = d
+	}
+
+	settings.TLSClientCAFile = getString("TOKENINFO_TLS_CLIENT_CA_FILE", "")
+	if settings.TLSClientCAFile != "" && settings.TLSCertFile == "" {
+		val.fail("TOKENINFO_TLS_CLIENT_CA_FILE requires TOKENINFO_TLS_CERT_FILE")
+	}
+
+	settings.ListenH2C = val.getBool("LISTEN_H2C", false)
+
+	if s := getString("UNIX_SOCKET_MODE", ""); s != "" {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || mode > 0777 {
+			val.fail("Invalid UNIX_SOCKET_MODE: %q is not an octal file mode", s)
+		}
+		settings.UnixSocketMode = os.FileMode(mode)
+	}
+
+	settings.AccessLogDestination = getString("ACCESS_LOG_DESTINATION", "")
+
+	if f := val.getFloat("ACCESS_LOG_SAMPLE_RATE", -1); f > 1 {
+		val.invalid("ACCESS_LOG_SAMPLE_RATE", getString("ACCESS_LOG_SAMPLE_RATE", ""), "must not be more than 1")
+	} else if f >= 0 {
+		settings.AccessLogSampleRate = f
+	}
+
+	if s := getString("LOG_LEVEL", ""); s != "" {
+		l, err := logging.ParseLevel(s)
+		if err != nil {
+			val.invalid("LOG_LEVEL", s, "is not debug, info, warn or error")
+		}
+		settings.LogLevel = l
+	}
+
+	if s := getString("LOG_FORMAT", ""); s != "" && s != "text" && s != "json" {
+		val.invalid("LOG_FORMAT", s, "is not text or json")
+	} else {
+		settings.LogFormat = s
+	}
+
+	settings.TracingEnabled = val.getBool("TRACING_ENABLED", false)
+
+	if sinks := getStrings("METRICS_SINKS"); len(sinks) > 0 {
+		for _, sink := range sinks {
+			if !metricsSinks[sink] {
+				val.invalid("METRICS_SINKS", sink, "is not http or statsd")
+			}
+		}
+		settings.MetricsSinks = sinks
+	}
+
+	if s := getString("STATSD_ADDRESS", ""); s != "" {
+		settings.StatsDAddress = s
+	}
+	settings.StatsDPrefix = getString("STATSD_PREFIX", "")
+	settings.StatsDTags = getStrings("STATSD_TAGS")
+
+	if s := getString("STATSD_FORMAT", ""); s != "" {
+		if !statsDFormats[s] {
+			val.invalid("STATSD_FORMAT", s, "is not statsd or dogstatsd")
+		}
+		settings.StatsDFormat = s
+	}
+
+	if len(settings.StatsDTags) > 0 && settings.StatsDFormat != "dogstatsd" {
+		val.fail("STATSD_TAGS requires STATSD_FORMAT dogstatsd")
+	}
+
+	if d := val.getDuration("STATSD_FLUSH_INTERVAL", 0); d > 0 {
+		settings.StatsDFlushInterval = d
+	}
+
+	settings.MetricsRealms = val.getBool("METRICS_REALMS", false)
+	settings.MetricsScopes = getStrings("METRICS_SCOPES")
+
+	if s := getString("AUDIT_SINK", ""); s != "" {
+		if !auditSinks[s] {
+			val.invalid("AUDIT_SINK", s, "is not file, http, kafka or kafka-native")
+		}
+		settings.AuditSink = s
+	}
+	settings.AuditFile = getString("AUDIT_FILE", "")
+	settings.AuditURL = getString("AUDIT_URL", "")
+	settings.AuditHMACKey = getString("AUDIT_HMAC_KEY", "")
+
+	if settings.AuditSink != "" && settings.AuditHMACKey == "" {
+		val.fail("AUDIT_SINK requires AUDIT_HMAC_KEY")
+	}
+
+	if settings.AuditSink == "file" && settings.AuditFile == "" {
+		val.fail("AUDIT_SINK file requires AUDIT_FILE")
+	}
+
+	if (settings.AuditSink == "http" || settings.AuditSink == "kafka") && settings.AuditURL == "" {
+		val.fail("AUDIT_SINK %s requires AUDIT_URL", settings.AuditSink)
+	}
+
+	if i := val.getInt("AUDIT_QUEUE_SIZE", 0); i > 0 {
+		settings.AuditQueueSize = i
+	}
+
+	if d := val.getDuration("AUDIT_FLUSH_INTERVAL", 0); d > 0 {
+		settings.AuditFlushInterval = d
+	}
+
+	settings.KafkaBrokers = getStrings("KAFKA_BROKERS")
+	settings.KafkaTLSCAFile = getString("KAFKA_TLS_CA_FILE", "")
+	settings.KafkaTLSCertFile = getString("KAFKA_TLS_CERT_FILE", "")
+	settings.KafkaTLSKeyFile = getString("KAFKA_TLS_KEY_FILE", "")
+	if (settings.KafkaTLSCertFile == "") != (settings.KafkaTLSKeyFile == "") {
+		val.fail("KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE must be set together")
+	}
+	// the certificates are only used with TLS
+	settings.KafkaTLS = val.getBool("KAFKA_TLS", false) || settings.KafkaTLSCAFile != "" || settings.KafkaTLSCertFile != ""
+
+	if s := getString("KAFKA_SASL_MECHANISM", ""); s != "" {
+		if !kafkaSASLMechanisms[s] {
+			val.invalid("KAFKA_SASL_MECHANISM", s, "is not PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
+		}
+		settings.KafkaSASLMechanism = s
+	}
+	settings.KafkaSASLUsername = getString("KAFKA_SASL_USERNAME", "")
+	settings.KafkaSASLPassword = getString("KAFKA_SASL_PASSWORD", "")
+	if settings.KafkaSASLMechanism != "" && (settings.KafkaSASLUsername == "" || settings.KafkaSASLPassword == "") {
+		val.fail("KAFKA_SASL_MECHANISM requires KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD")
+	}
+
+	settings.KafkaAuditTopic = getString("KAFKA_AUDIT_TOPIC", "")
+	settings.KafkaRevocationsTopic = getString("KAFKA_REVOCATIONS_TOPIC", "")
+	if settings.AuditSink == "kafka-native" && (len(settings.KafkaBrokers) == 0 || settings.KafkaAuditTopic == "") {
+		val.fail("AUDIT_SINK kafka-native requires KAFKA_BROKERS and KAFKA_AUDIT_TOPIC")
+	}
+
+	if settings.KafkaRevocationsTopic != "" && len(settings.KafkaBrokers) == 0 {
+		val.fail("KAFKA_REVOCATIONS_TOPIC requires KAFKA_BROKERS")
+	}
+
+	if i := val.getInt("KAFKA_BATCH_SIZE", 0); i > 0 {
+		settings.KafkaBatchSize = i
+	}
+
+	if d := val.getDuration("KAFKA_LINGER", 0); d > 0 {
+		settings.KafkaLinger = d
+	}
+
+	if d := val.getDuration("KAFKA_TIMEOUT", 0); d > 0 {
+		settings.KafkaTimeout = d
+	}
+
+	if s := getString("WEBHOOK_URL", ""); s != "" {
+		u, err := getURL("WEBHOOK_URL")
+		if err != nil {
+			val.fail("Error with WEBHOOK_URL: %v", err)
+		}
+		settings.WebhookURL = u
+	}
+	settings.WebhookConditions = getStrings("WEBHOOK_CONDITIONS")
+	for _, c := range settings.WebhookConditions {
+		if !webhookConditions[c] {
+			val.invalid("WEBHOOK_CONDITIONS", c, "is not new_token, revoked_token or unexpected_realm")
+		}
+	}
+	settings.WebhookExpectedRealms = getStrings("WEBHOOK_EXPECTED_REALMS")
+	settings.WebhookSecret = getString("WEBHOOK_SECRET", "")
+	if (getString("WEBHOOK_URL", "") == "") != (len(settings.WebhookConditions) == 0) {
+		val.fail("WEBHOOK_URL and WEBHOOK_CONDITIONS must be set together")
+	}
+
+	for _, c := range settings.WebhookConditions {
+		if c == "unexpected_realm" && len(settings.WebhookExpectedRealms) == 0 {
+			val.fail("WEBHOOK_CONDITIONS unexpected_realm requires WEBHOOK_EXPECTED_REALMS")
+		}
+		// the reason of the rejection is only known from the error responses
+		if c == "revoked_token" && !settings.ErrorReasons {
+			val.fail("WEBHOOK_CONDITIONS revoked_token requires TOKENINFO_ERROR_REASONS")
+		}
+	}
+
+	if d := val.getDuration("WEBHOOK_FLUSH_INTERVAL", 0); d > 0 {
+		settings.WebhookFlushInterval = d
+	}
+
+	if f := val.getFloat("RATE_LIMIT_GLOBAL", 0); f > 0 {
+		settings.RateLimitGlobal = f
+	}
+
+	if f := val.getFloat("RATE_LIMIT_PER_IP", 0); f > 0 {
+		settings.RateLimitPerIP = f
+	}
+
+	if f := val.getFloat("RATE_LIMIT_PER_TOKEN", 0); f > 0 {
+		settings.RateLimitPerToken = f
+	}
+
+	for _, s := range getStrings("TRUSTED_PROXIES") {
+		p, err := clientip.ParsePrefix(s)
+		if err != nil {
+			val.fail("Error with TRUSTED_PROXIES: %v", err)
+			continue
+		}
+		settings.TrustedProxies = append(settings.TrustedProxies, p)
+	}
+
+	if i := val.getInt("MAX_TOKEN_LENGTH", -1); i > -1 {
+		settings.MaxTokenLength = i
+	}
+
+	if i := val.getInt("MAX_URL_LENGTH", -1); i > -1 {
+		settings.MaxURLLength = i
+	}
+
+	if i := val.getInt("MAX_REQUEST_BODY_SIZE", -1); i > -1 {
+		settings.MaxRequestBodySize = int64(i)
+	}
+
+	settings.CallerAPIKeys = getStringMap("CALLER_API_KEYS")
+	settings.CallerCertSubjects = getStrings("CALLER_CERT_SUBJECTS")
+	if len(settings.CallerCertSubjects) > 0 && settings.TLSClientCAFile == "" {
+		val.fail("TOKENINFO_TLS_CLIENT_CA_FILE is required with CALLER_CERT_SUBJECTS")
+	}
+
+	settings.CORSAllowedOrigins = getStrings("CORS_ALLOWED_ORIGINS")
+	for _, o := range settings.CORSAllowedOrigins {
+		if err := checkOrigin(o); err != nil {
+			val.fail("Error with CORS_ALLOWED_ORIGINS: %v", err)
+		}
+	}
+	settings.CORSAllowedMethods = getStrings("CORS_ALLOWED_METHODS")
+	settings.CORSAllowedHeaders = getStrings("CORS_ALLOWED_HEADERS")
+	settings.CORSMaxAge = val.getDuration("CORS_MAX_AGE", 0)
+
+	if i := val.getInt("UPSTREAM_CACHE_MAX_SIZE", -1); i > -1 {
 		settings.UpstreamCacheMaxSize = int64(i)
 	}
 
-	if d := getDuration("UPSTREAM_CACHE_TTL", -1); d > -1 {
+	if i := val.getInt("UPSTREAM_CACHE_MAX_BYTES", 0); i > 0 {
+		settings.UpstreamCacheMaxBytes = int64(i)
+	}
+
+	if i := val.getInt("UPSTREAM_CACHE_SHARDS", 0); i > 0 {
+		settings.UpstreamCacheShards = i
+	}
+
+	if d := val.getDuration("UPSTREAM_CACHE_TTL", -1); d > -1 {
 		settings.UpstreamCacheTTL = d
 	}
 
-	if d := getDuration("UPSTREAM_TIMEOUT", -1); d > -1 {
+	if d := val.getDuration("UPSTREAM_CACHE_MIN_TTL", 0); d > 0 {
+		settings.UpstreamCacheMinTTL = d
+	}
+
+	if d := val.getDuration("UPSTREAM_CACHE_MAX_TTL", 0); d > 0 {
+		settings.UpstreamCacheMaxTTL = d
+	}
+
+	// caching headers are only sent when a maximum age is set
+	if d := val.getDuration("RESPONSE_CACHE_MAX_AGE", 0); d > 0 {
+		settings.ResponseCacheMaxAge = d
+		settings.ResponseCacheDirectives = getString("RESPONSE_CACHE_DIRECTIVES", "private")
+	}
+
+	settings.ResponseCanonicalJSON = val.getBool("RESPONSE_CANONICAL_JSON", false)
+	settings.ResponseFieldOrder = getStrings("RESPONSE_FIELD_ORDER")
+	if len(settings.ResponseFieldOrder) > 0 && !settings.ResponseCanonicalJSON {
+		val.fail("RESPONSE_FIELD_ORDER requires RESPONSE_CANONICAL_JSON")
+	}
+
+	settings.ResponseETags = val.getBool("RESPONSE_ETAGS", false)
+
+	if s := getString("UPSTREAM_CACHE_BACKEND", ""); s != "" {
+		settings.UpstreamCacheBackend = s
+	}
+
+	if s := getString("UPSTREAM_CACHE_REDIS_URL", ""); s != "" {
+		redisURL, err := getURL("UPSTREAM_CACHE_REDIS_URL")
+		if err != nil {
+			val.fail("Error with UPSTREAM_CACHE_REDIS_URL: %v", err)
+		}
+		settings.UpstreamCacheRedisURL = redisURL
+	}
+
+	settings.UpstreamCacheSnapshotFile = getString("UPSTREAM_CACHE_SNAPSHOT_FILE", "")
+
+	if s := getString("UPSTREAM_USERINFO_URL", ""); s != "" {
+		u, err := getURL("UPSTREAM_USERINFO_URL")
+		if err != nil {
+			val.fail("Error with UPSTREAM_USERINFO_URL: %v", err)
+		}
+		settings.UpstreamUserInfoURL = u
+	}
+
+	if d := val.getDuration("USERINFO_CACHE_TTL", -1); d > -1 {
+		settings.UserInfoCacheTTL = d
+	}
+
+	if s := getString("UPSTREAM_TOKEN_EXCHANGE_URL", ""); s != "" {
+		u, err := getURL("UPSTREAM_TOKEN_EXCHANGE_URL")
+		if err != nil {
+			val.fail("Error with UPSTREAM_TOKEN_EXCHANGE_URL: %v", err)
+		}
+		settings.UpstreamTokenExchangeURL = u
+	}
+
+	settings.TokenExchangeCacheTTL = val.getDuration("TOKEN_EXCHANGE_CACHE_TTL", 0)
+
+	if d := val.getDuration("UPSTREAM_TIMEOUT", -1); d > -1 {
 		settings.UpstreamTimeout = d
 	}
 
-	if d := getDuration("OPENID_PROVIDER_REFRESH_INTERVAL", 0); d > 0 {
+	settings.UpstreamSoftTimeout = val.getDuration("UPSTREAM_SOFT_TIMEOUT", 0)
+	if settings.UpstreamSoftTimeout > 0 && settings.UpstreamTimeout > 0 && settings.UpstreamSoftTimeout >= settings.UpstreamTimeout {
+		val.fail("UPSTREAM_SOFT_TIMEOUT must be shorter than UPSTREAM_TIMEOUT")
+	}
+
+	if i := val.getInt("UPSTREAM_CIRCUIT_ERROR_THRESHOLD", 0); i > 100 {
+		val.invalid("UPSTREAM_CIRCUIT_ERROR_THRESHOLD", getString("UPSTREAM_CIRCUIT_ERROR_THRESHOLD", ""), "must not be more than 100")
+	} else if i > 0 {
+		settings.UpstreamCircuitErrorThreshold = i
+	}
+
+	if i := val.getInt("UPSTREAM_CIRCUIT_REQUEST_VOLUME", 0); i > 0 {
+		settings.UpstreamCircuitRequestVolume = i
+	}
+
+	if d := val.getDuration("UPSTREAM_CIRCUIT_SLEEP_WINDOW", 0); d > 0 {
+		settings.UpstreamCircuitSleepWindow = d
+	}
+
+	if i := val.getInt("UPSTREAM_RETRIES", 0); i > 0 {
+		settings.UpstreamRetries = i
+	}
+
+	if d := val.getDuration("UPSTREAM_RETRY_BACKOFF", -1); d > -1 {
+		settings.UpstreamRetryBackoff = d
+	}
+
+	if f := val.getFloat("UPSTREAM_RETRY_BUDGET", -1); f >= 0 {
+		settings.UpstreamRetryBudget = f
+	}
+
+	if d := val.getDuration("UPSTREAM_HEDGE_DELAY", 0); d > 0 {
+		settings.UpstreamHedgeDelay = d
+	}
+
+	if s := getString("UPSTREAM_SHADOW_URL", ""); s != "" {
+		u, err := getURL("UPSTREAM_SHADOW_URL")
+		if err != nil {
+			val.fail("Error with UPSTREAM_SHADOW_URL: %v", err)
+		}
+		if settings.UpstreamTokenInfoURL == nil {
+			val.fail("UPSTREAM_SHADOW_URL requires UPSTREAM_TOKENINFO_URL")
+		}
+		settings.UpstreamShadowURL = u
+		settings.UpstreamShadowPercentage = 100
+		if i := val.getInt("UPSTREAM_SHADOW_PERCENTAGE", -1); i > 100 {
+			val.invalid("UPSTREAM_SHADOW_PERCENTAGE", getString("UPSTREAM_SHADOW_PERCENTAGE", ""), "must not be more than 100")
+		} else if i > -1 {
+			settings.UpstreamShadowPercentage = i
+		}
+	}
+
+	if s := getString("UPSTREAM_CANARY_URL", ""); s != "" {
+		u, err := getURL("UPSTREAM_CANARY_URL")
+		if err != nil {
+			val.fail("Error with UPSTREAM_CANARY_URL: %v", err)
+		}
+		if settings.UpstreamTokenInfoURL == nil {
+			val.fail("UPSTREAM_CANARY_URL requires UPSTREAM_TOKENINFO_URL")
+		}
+		settings.UpstreamCanaryURL = u
+		if i := val.getInt("UPSTREAM_CANARY_PERCENTAGE", 0); i > 100 {
+			val.invalid("UPSTREAM_CANARY_PERCENTAGE", getString("UPSTREAM_CANARY_PERCENTAGE", ""), "must not be more than 100")
+		} else if i > 0 {
+			settings.UpstreamCanaryPercentage = i
+		}
+	}
+
+	// the JWT responses are compared with the ones of the upstream token info
+	if i := val.getInt("JWT_VERIFY_PERCENTAGE", 0); i > 100 {
+		val.invalid("JWT_VERIFY_PERCENTAGE", getString("JWT_VERIFY_PERCENTAGE", ""), "must not be more than 100")
+	} else if i > 0 {
+		if settings.UpstreamTokenInfoURL == nil {
+			val.fail("JWT_VERIFY_PERCENTAGE requires UPSTREAM_TOKENINFO_URL")
+		}
+		settings.JwtVerifyPercentage = i
+	}
+
+	// faults are only injected where they were asked for explicitly, never by a stray CHAOS_ variable
+	settings.ChaosMode = val.getBool("CHAOS_MODE", false)
+	if settings.ChaosMode {
+		settings.ChaosLatency = val.getDuration("CHAOS_LATENCY", 0)
+		settings.ChaosLatencyPercentage = val.getPercentage("CHAOS_LATENCY_PERCENTAGE")
+		settings.ChaosErrorPercentage = val.getPercentage("CHAOS_ERROR_PERCENTAGE")
+		settings.ChaosErrorStatus = defaultChaosErrorStatus
+		if i := val.getInt("CHAOS_ERROR_STATUS", 0); i != 0 && (i < 400 || i > 599) {
+			val.invalid("CHAOS_ERROR_STATUS", getString("CHAOS_ERROR_STATUS", ""), "is not a 4xx or 5xx status")
+		} else if i != 0 {
+			settings.ChaosErrorStatus = i
+		}
+		settings.ChaosMalformedPercentage = val.getPercentage("CHAOS_MALFORMED_PERCENTAGE")
+	} else {
+		for _, v := range []string{"CHAOS_LATENCY", "CHAOS_LATENCY_PERCENTAGE", "CHAOS_ERROR_PERCENTAGE", "CHAOS_ERROR_STATUS", "CHAOS_MALFORMED_PERCENTAGE"} {
+			if getString(v, "") != "" {
+				val.fail("%s requires CHAOS_MODE", v)
+			}
+		}
+	}
+
+	// streamed responses are neither kept nor parsed, so they can't be cached or changed
+	settings.UpstreamStreaming = val.getBool("UPSTREAM_STREAMING", false)
+	if settings.UpstreamStreaming {
+		if settings.UpstreamCacheTTL > 0 {
+			val.fail("UPSTREAM_STREAMING requires UPSTREAM_CACHE_TTL 0")
+		}
+		if settings.UpstreamValidateResponses {
+			val.fail("UPSTREAM_STREAMING can't be used together with UPSTREAM_VALIDATE_RESPONSES")
+		}
+		if settings.ScopePolicy != nil {
+			val.fail("UPSTREAM_STREAMING can't be used together with SCOPE_POLICY_FILE")
+		}
+		if settings.UpstreamHedgeDelay > 0 {
+			val.fail("UPSTREAM_STREAMING can't be used together with UPSTREAM_HEDGE_DELAY")
+		}
+		if getString("UPSTREAM_SHADOW_URL", "") != "" {
+			val.fail("UPSTREAM_STREAMING can't be used together with UPSTREAM_SHADOW_URL")
+		}
+	}
+
+	if d := val.getDuration("OPENID_PROVIDER_REFRESH_INTERVAL", 0); d > 0 {
 		settings.OpenIDProviderRefreshInterval = d
 	}
 
-	if d := getDuration("HTTP_CLIENT_TIMEOUT", 0); d > 0 {
+	if f := val.getFloat("OPENID_PROVIDER_REFRESH_JITTER", -1); f >= 1 {
+		val.invalid("OPENID_PROVIDER_REFRESH_JITTER", getString("OPENID_PROVIDER_REFRESH_JITTER", ""), "must be less than 1")
+	} else if f >= 0 {
+		settings.OpenIDProviderRefreshJitter = f
+	}
+
+	if d := val.getDuration("OPENID_PROVIDER_REFRESH_BACKOFF", 0); d > 0 {
+		settings.OpenIDProviderRefreshBackoff = d
+	}
+
+	if d := val.getDuration("OPENID_PROVIDER_REFRESH_MAX_BACKOFF", 0); d > 0 {
+		settings.OpenIDProviderRefreshMaxBackoff = d
+	}
+
+	if settings.OpenIDProviderRefreshBackoff > settings.OpenIDProviderRefreshMaxBackoff {
+		val.fail("Invalid OPENID_PROVIDER_REFRESH_BACKOFF: %v is more than OPENID_PROVIDER_REFRESH_MAX_BACKOFF %v",
+			settings.OpenIDProviderRefreshBackoff, settings.OpenIDProviderRefreshMaxBackoff)
+	}
+
+	if d := val.getDuration("OPENID_PROVIDER_KID_REFRESH_INTERVAL", -1); d > -1 {
+		settings.OpenIDProviderKidRefreshInterval = d
+	}
+
+	if d := val.getDuration("OPENID_PROVIDER_KEY_GRACE_PERIOD", 0); d > 0 {
+		settings.OpenIDProviderKeyGracePeriod = d
+	}
+
+	settings.KeysPreloadTimeout = val.getDuration("KEYS_PRELOAD_TIMEOUT", 0)
+
+	if d := val.getDuration("HTTP_CLIENT_TIMEOUT", 0); d > 0 {
 		settings.HTTPClientTimeout = d
 	}
 
-	if d := getDuration("HTTP_CLIENT_TLS_TIMEOUT", 0); d > 0 {
+	if d := val.getDuration("HTTP_CLIENT_TLS_TIMEOUT", 0); d > 0 {
 		settings.HTTPClientTLSTimeout = d
 	}
 
-	if d := getDuration("REVOCATION_CACHE_TTL", 0); d > 0 {
+	settings.HTTPClientCertFile = getString("HTTP_CLIENT_TLS_CERT_FILE", "")
+	settings.HTTPClientKeyFile = getString("HTTP_CLIENT_TLS_KEY_FILE", "")
+	if (settings.HTTPClientCertFile == "") != (settings.HTTPClientKeyFile == "") {
+		val.fail("HTTP_CLIENT_TLS_CERT_FILE and HTTP_CLIENT_TLS_KEY_FILE must be set together")
+	}
+	settings.HTTPClientCAFile = getString("HTTP_CLIENT_TLS_CA_FILE", "")
+
+	if d := val.getDuration("REVOCATION_CACHE_TTL", 0); d > 0 {
 		settings.RevocationCacheTTL = d
 	}
 
-	if d := getDuration("REVOCATION_PROVIDER_REFRESH_INTERVAL", 0); d > 0 {
+	if d := val.getDuration("REVOCATION_PROVIDER_REFRESH_INTERVAL", 0); d > 0 {
 		settings.RevocationProviderRefreshInterval = d
 	}
 
-	if d := getDuration("REVOCATION_REFRESH_TOLERANCE", 0); d > 0 {
+	if d := val.getDuration("REVOCATION_REFRESH_TOLERANCE", 0); d > 0 {
 		settings.RevocationRefreshTolerance = d
 	}
 
-	AppSettings = settings
+	if m := getStringMap("REVOCATION_PUSH_CLIENTS"); len(m) > 0 {
+		settings.RevocationPushClients = m
+	}
+	settings.RevocationSnapshotFile = getString("REVOCATION_SNAPSHOT_FILE", "")
+
+	if m := getStringMap("INTROSPECTION_CLIENTS"); len(m) > 0 {
+		settings.IntrospectionClients = m
+	}
+
+	if i := val.getInt("BATCH_MAX_TOKENS", 0); i > 0 {
+		settings.BatchMaxTokens = i
+	}
+
+	if s := getString("DISCOVERY_ISSUER", ""); s != "" {
+		u, err := getURL("DISCOVERY_ISSUER")
+		if err != nil {
+			val.fail("Error with DISCOVERY_ISSUER: %v", err)
+		}
+		settings.DiscoveryIssuer = u
+	}
+
+	settings.DiscoveryHosts = getStrings("DISCOVERY_HOSTS")
+
+	if err := val.err(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// checkAlgorithms rejects the JWT signing algorithms that must never be accepted: "none" and the HMAC
+// algorithms, whose secret would have to be shared with every client of the token info
+func checkAlgorithms(algs []string) error {
+	for _, alg := range algs {
+		if strings.EqualFold(alg, "none") || strings.HasPrefix(strings.ToUpper(alg), "HS") {
+			return fmt.Errorf("the %q algorithm is not allowed", alg)
+		}
+	}
 	return nil
 }
 
+func loadClaimMapping(path string) (*processor.ClaimMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return processor.LoadClaimMapping(f)
+}
+
+func loadRealmRules(path string) (*processor.RealmRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return processor.LoadRealmRules(f)
+}
+
+func loadTokenRoutes(path string) (*processor.TokenRoutes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return processor.LoadTokenRoutes(f)
+}
+
+func loadScopePolicy(path string) (*processor.ScopePolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return processor.LoadScopePolicy(f)
+}
+
 func getString(v string, def string) string {
-	s, ok := os.LookupEnv(v)
+	s, ok := lookupEnv(v)
 	if !ok {
 		return def
 	}
 	return s
 }
 
+// getStringMap parses a comma separated list of key:value pairs. Entries without a key are ignored
+func getStringMap(v string) map[string]string {
+	return getStringMapSep(v, ":")
+}
+
+// getStringMapSep parses a comma separated list of key/value pairs where the key ends at the first
+// occurrence of sep. Used with "=" when keys are URLs. Entries without a key are ignored
+func getStringMapSep(v string, sep string) map[string]string {
+	s, ok := lookupEnv(v)
+	if !ok || s == "" {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), sep, 2)
+		if kv[0] == "" {
+			continue
+		}
+		if len(kv) == 2 {
+			m[kv[0]] = kv[1]
+		} else {
+			m[kv[0]] = ""
+		}
+	}
+	return m
+}
+
+// checkKMSKeys requires a key id and a KMS key for every entry of kid=key pairs
+func checkKMSKeys(m map[string]string) error {
+	for kid, key := range m {
+		if key == "" {
+			return fmt.Errorf("missing KMS key for %q", kid)
+		}
+	}
+	return nil
+}
+
+// checkOrigin accepts "*" and origins like "https://app.example.org" or "https://*.example.org", without path
+func checkOrigin(o string) error {
+	if o == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(o, "://*.", "://", 1))
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("invalid origin %q", o)
+	}
+	return nil
+}
+
+// parseURL parses an absolute URL, so that for ex. a missing scheme isn't taken as a path
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("%q is not an absolute URL", s)
+	}
+	return u, nil
+}
+
+// getStrings parses a comma separated list of strings. Empty entries are ignored
+func getStrings(v string) []string {
+	s, ok := lookupEnv(v)
+	if !ok {
+		return nil
+	}
+	var l []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			l = append(l, part)
+		}
+	}
+	return l
+}
+
 func getURL(v string) (*url.URL, error) {
-	u, ok := os.LookupEnv(v)
+	u, ok := lookupEnv(v)
 	if !ok || u == "" {
 		return nil, fmt.Errorf("Missing URL setting: %q", v)
 	}
-	return url.Parse(u)
+	return parseURL(u)
 }
 
-func getInt(v string, def int) int {
-	s, ok := os.LookupEnv(v)
-	if !ok {
+// getURLs parses a comma separated list of URLs. At least one URL is required
+func getURLs(v string) ([]*url.URL, error) {
+	s, ok := lookupEnv(v)
+	if !ok || s == "" {
+		return nil, fmt.Errorf("Missing URL setting: %q", v)
+	}
+	var urls []*url.URL
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u, err := parseURL(part)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("Missing URL setting: %q", v)
+	}
+	return urls, nil
+}
+
+// getURLMap parses a comma separated list of key=URL pairs. Every entry must have a valid URL
+func getURLMap(v string) (map[string]*url.URL, error) {
+	m := getStringMapSep(v, "=")
+	if len(m) == 0 {
+		return nil, nil
+	}
+	urls := make(map[string]*url.URL, len(m))
+	for k, s := range m {
+		if s == "" {
+			return nil, fmt.Errorf("Missing URL for %q", k)
+		}
+		u, err := parseURL(s)
+		if err != nil {
+			return nil, err
+		}
+		urls[k] = u
+	}
+	return urls, nil
+}
+
+// getPercentage reads a percentage between 0 and 100. Invalid values are recorded as problems and return 0
+func (val *validator) getPercentage(v string) int {
+	i := val.getInt(v, 0)
+	if i > 100 {
+		val.invalid(v, getString(v, ""), "must not be more than 100")
+		return 0
+	}
+	return i
+}
+
+// getInt reads a non-negative integer. Invalid values are recorded as problems and return the default
+func (val *validator) getInt(v string, def int) int {
+	s, ok := lookupEnv(v)
+	if !ok || s == "" {
 		return def
 	}
 	i, err := strconv.Atoi(s)
 	if err != nil {
+		val.invalid(v, s, "is not an integer")
+		return def
+	}
+	if i < 0 {
+		val.invalid(v, s, "must not be negative")
 		return def
 	}
 	return i
 }
 
-func getDuration(v string, def time.Duration) time.Duration {
-	s, ok := os.LookupEnv(v)
+// getBool reads a boolean. Invalid values are recorded as problems and return the default
+func (val *validator) getBool(v string, def bool) bool {
+	s, ok := lookupEnv(v)
+	if !ok || s == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		val.invalid(v, s, "is not a boolean")
+		return def
+	}
+	return b
+}
+
+// getFloat reads a non-negative number. Invalid values are recorded as problems and return the default
+func (val *validator) getFloat(v string, def float64) float64 {
+	s, ok := lookupEnv(v)
 	if !ok || s == "" {
 		return def
 	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		val.invalid(v, s, "is not a number")
+		return def
+	}
+	if f < 0 {
+		val.invalid(v, s, "must not be negative")
+		return def
+	}
+	return f
+}
 
-	if d, err := time.ParseDuration(s); err == nil {
-		return d
+// getDuration reads a non-negative duration like "5m" or a number of seconds. Invalid values are recorded
+// as problems and return the default
+func (val *validator) getDuration(v string, def time.Duration) time.Duration {
+	s, ok := lookupEnv(v)
+	if !ok || s == "" {
+		return def
 	}
 
-	if seconds, err := strconv.Atoi(s); err == nil {
-		return time.Duration(seconds) * time.Second
+	d, err := parseDuration(s)
+	if err != nil {
+		val.invalid(v, s, err.Error())
+		return def
 	}
+	return d
+}
 
-	return def
+// parseDuration parses a Go duration or a number of seconds, that must not be negative
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errors.New("is not a duration")
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return d, nil
 }
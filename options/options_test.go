@@ -1,8 +1,11 @@
 package options
 
 import (
+	"io/ioutil"
+	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -40,7 +43,8 @@ func TestGetUrl(t *testing.T) {
 		wantError bool
 	}{
 		{"", "localhost", "", true},
-		{"DIFFICULT_TO_GUESS", "localhost", "localhost", false},
+		{"DIFFICULT_TO_GUESS", "localhost", "", true},
+		{"DIFFICULT_TO_GUESS", "file:///etc/denylist", "file:///etc/denylist", false},
 		{"DIFFICULT_TO_GUESS", "http://192.168.0.%31/", "", true},
 		{"DIFFICULT_TO_GUESS", "", "", true},
 		{"DIFFICULT_TO_GUESS", "http://example.com", "http://example.com", false},
@@ -79,12 +83,128 @@ func TestGetInt(t *testing.T) {
 		if test.envSet != "" {
 			os.Setenv(test.envSet, test.value)
 		}
-		if s := getInt(test.envGet, test.def); s != test.want {
+		if s := new(validator).getInt(test.envGet, test.def); s != test.want {
 			t.Errorf("Failed to retrieve the correct value from the environment. Wanted %q, got %q", test.want, s)
 		}
 	}
 }
 
+func TestGetBool(t *testing.T) {
+	for _, test := range []struct {
+		envSet string
+		value  string
+		envGet string
+		def    bool
+		want   bool
+	}{
+		{"T1", "", "T1", true, true},
+		{"T1", "invalid-bool", "T1", false, false},
+		{"", "", "DIFFICULT_TO_GUESS", true, true},
+		{"T1", "true", "T1", false, true},
+		{"T1", "0", "T1", true, false},
+	} {
+		os.Clearenv()
+		if test.envSet != "" {
+			os.Setenv(test.envSet, test.value)
+		}
+		if b := new(validator).getBool(test.envGet, test.def); b != test.want {
+			t.Errorf("Failed to retrieve the correct value from the environment. Wanted %t, got %t", test.want, b)
+		}
+	}
+}
+
+func TestGetFloat(t *testing.T) {
+	for _, test := range []struct {
+		envSet string
+		value  string
+		envGet string
+		def    float64
+		want   float64
+	}{
+		{"T1", "", "T1", 0.5, 0.5},
+		{"T1", "invalid-float", "T1", 1, 1},
+		{"", "", "DIFFICULT_TO_GUESS", 0, 0},
+		{"T1", "0.25", "T1", 0, 0.25},
+		{"T1", "1", "T1", 0, 1},
+	} {
+		os.Clearenv()
+		if test.envSet != "" {
+			os.Setenv(test.envSet, test.value)
+		}
+		if f := new(validator).getFloat(test.envGet, test.def); f != test.want {
+			t.Errorf("Failed to retrieve the correct value from the environment. Wanted %v, got %v", test.want, f)
+		}
+	}
+}
+
+func TestGetStringMap(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  map[string]string
+	}{
+		{"", nil},
+		{"foo:bar", map[string]string{"foo": "bar"}},
+		{"foo:bar, baz:q:u:x", map[string]string{"foo": "bar", "baz": "q:u:x"}},
+		{"foo,:bar", map[string]string{"foo": ""}},
+	} {
+		os.Clearenv()
+		os.Setenv("T1", test.value)
+		if m := getStringMap("T1"); !reflect.DeepEqual(m, test.want) {
+			t.Errorf("Failed to retrieve the correct value from the environment. Wanted %v, got %v", test.want, m)
+		}
+	}
+}
+
+func TestGetURLs(t *testing.T) {
+	primary, _ := url.Parse("http://primary.example.org/oauth2/tokeninfo")
+	standby, _ := url.Parse("http://standby.example.org/oauth2/tokeninfo")
+	for _, test := range []struct {
+		value     string
+		want      []*url.URL
+		wantError bool
+	}{
+		{"", nil, true},
+		{",", nil, true},
+		{"http://primary.example.org/oauth2/tokeninfo", []*url.URL{primary}, false},
+		{"http://primary.example.org/oauth2/tokeninfo, http://standby.example.org/oauth2/tokeninfo", []*url.URL{primary, standby}, false},
+		{"http://primary.example.org/oauth2/tokeninfo,http://192.168.0.%31/", nil, true},
+	} {
+		os.Clearenv()
+		os.Setenv("T1", test.value)
+		urls, err := getURLs("T1")
+		if test.wantError != (err != nil) {
+			t.Errorf("Unexpected error for %q: %v", test.value, err)
+		}
+		if !reflect.DeepEqual(urls, test.want) {
+			t.Errorf("Wrong URLs for %q. Wanted %v, got %v", test.value, test.want, urls)
+		}
+	}
+}
+
+func TestGetURLMap(t *testing.T) {
+	idp, _ := url.Parse("https://idp.example.org/.well-known/openid-configuration")
+	for _, test := range []struct {
+		value     string
+		want      map[string]*url.URL
+		wantError bool
+	}{
+		{"", nil, false},
+		{"https://idp.example.org=https://idp.example.org/.well-known/openid-configuration", map[string]*url.URL{"https://idp.example.org": idp}, false},
+		{"https://idp.example.org", nil, true},
+		{"https://idp.example.org=http://192.168.0.%31/", nil, true},
+	} {
+		os.Clearenv()
+		os.Setenv("T1", test.value)
+		m, err := getURLMap("T1")
+		if test.wantError != (err != nil) {
+			t.Errorf("Unexpected error for %q: %v", test.value, err)
+		}
+		if !reflect.DeepEqual(m, test.want) {
+			t.Errorf("Failed to retrieve the correct value from the environment. Wanted %v, got %v", test.want, m)
+		}
+	}
+}
+
 func TestGetDuration(t *testing.T) {
 	for _, test := range []struct {
 		envSet string
@@ -107,7 +227,7 @@ func TestGetDuration(t *testing.T) {
 		if test.envSet != "" {
 			os.Setenv(test.envSet, test.value)
 		}
-		if s := getDuration(test.envGet, test.def); s != test.want {
+		if s := new(validator).getDuration(test.envGet, test.def); s != test.want {
 			t.Errorf("Failed to retrieve the correct value from the environment. Wanted %q, got %q", test.want, s)
 		}
 	}
@@ -115,6 +235,13 @@ func TestGetDuration(t *testing.T) {
 
 func TestLoading(t *testing.T) {
 	exampleCom, _ := url.Parse("http://example.com")
+	exampleRedis, _ := url.Parse("redis://localhost:6379/0")
+	exampleOrg, _ := url.Parse("http://example.org")
+	idpConfiguration, _ := url.Parse("http://idp.example.org/.well-known/openid-configuration")
+	googleConfiguration, _ := url.Parse(googleConfigurationURL)
+	spireBundle, _ := url.Parse("https://spire.example.org/bundle")
+	idpJwks, _ := url.Parse("http://idp.example.org/jwks")
+	webhookURL, _ := url.Parse("https://soc.example.com/hooks/tokeninfo")
 	for _, test := range []struct {
 		name     string
 		env      map[string]string
@@ -126,15 +253,2760 @@ func TestLoading(t *testing.T) {
 			"UPSTREAM_TOKENINFO_URL empty",
 			map[string]string{"UPSTREAM_TOKENINFO_URL": ""},
 			&Settings{
-				UpstreamTokenInfoURL:              nil,
-				OpenIDProviderConfigurationURL:    nil,
-				RevocationProviderUrl:             nil,
+				UpstreamTokenInfoURL:              nil,
+				OpenIDProviderConfigurationURL:    nil,
+				RevocationProviderUrl:             nil,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"UPSTREAM_TOKENINFO_URL set",
+			map[string]string{"UPSTREAM_TOKENINFO_URL": "http://example.com"},
+			&Settings{
+				UpstreamTokenInfoURL:              nil,
+				OpenIDProviderConfigurationURL:    nil,
+				RevocationProviderUrl:             nil,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"OPENID_PROVIDER_CONFIGURATION_URL set",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              nil,
+				OpenIDProviderConfigurationURL:    nil,
+				RevocationProviderUrl:             nil,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"OPENID_PROVIDER_CONFIGURATION_URL empty",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "",
+			},
+			nil,
+			true,
+		},
+		{
+			"TOKENINFO_TLS_KEY_FILE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TOKENINFO_TLS_CERT_FILE":           "/etc/tls/tls.crt",
+			},
+			nil,
+			true,
+		},
+		{
+			"HTTP_CLIENT_TLS_CERT_FILE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"HTTP_CLIENT_TLS_KEY_FILE":          "/etc/tls/client.key",
+			},
+			nil,
+			true,
+		},
+		{
+			"STATIC_KEYS and STATIC_KEYS_FILE",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"STATIC_KEYS":             `{"keys": []}`,
+				"STATIC_KEYS_FILE":        "/etc/keys/jwks.json",
+			},
+			nil,
+			true,
+		},
+		{
+			"STATIC_KEYS and OPENID_PROVIDER_CONFIGURATION_URL",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"STATIC_KEYS":                       `{"keys": []}`,
+			},
+			nil,
+			true,
+		},
+		{
+			"ADMIN_USERS missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ADMIN_LISTEN_ADDRESS":              ":9022",
+			},
+			nil,
+			true,
+		},
+		{
+			"LISTENERS with unknown handler",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"LISTENERS":                         ":9021=tokeninfo,:9022=status",
+			},
+			nil,
+			true,
+		},
+		{
+			"LISTENERS admin without ADMIN_USERS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"LISTENERS":                         ":9021=tokeninfo,:9022=admin",
+			},
+			nil,
+			true,
+		},
+		{
+			"UNIX_SOCKET_MODE invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UNIX_SOCKET_MODE":                  "rw-rw----",
+			},
+			nil,
+			true,
+		},
+		{
+			"CLAIM_MAPPING_FILE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"CLAIM_MAPPING_FILE":                "/does/not/exist.json",
+			},
+			nil,
+			true,
+		},
+		{
+			"REALM_RULES_FILE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"REALM_RULES_FILE":                  "/does/not/exist.json",
+			},
+			nil,
+			true,
+		},
+		{
+			"SCOPE_POLICY_FILE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"SCOPE_POLICY_FILE":                 "/does/not/exist.json",
+			},
+			nil,
+			true,
+		},
+		{
+			"KEYCLOAK_ISSUERS with ISSUER_SCOPE_CLAIMS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ISSUER_SCOPE_CLAIMS":               "https://keycloak.example.org/realms/shop=scp",
+				"KEYCLOAK_ISSUERS":                  "https://keycloak.example.org/realms/shop",
+			},
+			nil,
+			true,
+		},
+		{
+			"AZURE_AD_ISSUERS with ISSUER_SCOPE_CLAIMS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ISSUER_SCOPE_CLAIMS":               "https://login.microsoftonline.com/{tenantid}/v2.0=roles",
+				"AZURE_AD_ISSUERS":                  "https://login.microsoftonline.com/{tenantid}/v2.0",
+			},
+			nil,
+			true,
+		},
+		{
+			"AZURE_AD_ISSUERS template without AZURE_AD_TENANTS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AZURE_AD_ISSUERS":                  "https://login.microsoftonline.com/{tenantid}/v2.0",
+				"AZURE_AD_AUDIENCES":                "api://tokeninfo",
+			},
+			nil,
+			true,
+		},
+		{
+			"AZURE_AD_ISSUERS template without AZURE_AD_AUDIENCES",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AZURE_AD_ISSUERS":                  "https://login.microsoftonline.com/{tenantid}/v2.0",
+				"AZURE_AD_TENANTS":                  "72f988bf-86f1-41af-91ab-2d7cd011db47",
+			},
+			nil,
+			true,
+		},
+		{
+			"AZURE_AD_ISSUERS with KEYCLOAK_ISSUERS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"KEYCLOAK_ISSUERS":                  "https://idp.example.org",
+				"AZURE_AD_ISSUERS":                  "https://idp.example.org",
+			},
+			nil,
+			true,
+		},
+		{
+			"GOOGLE_ID_TOKEN_AUDIENCES with ISSUER_SCOPE_CLAIMS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ISSUER_SCOPE_CLAIMS":               "https://accounts.google.com=scope",
+				"GOOGLE_ID_TOKEN_AUDIENCES":         "https://tokeninfo.example.org",
+			},
+			nil,
+			true,
+		},
+		{
+			"SPIFFE_BUNDLES without SPIFFE_AUDIENCES",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"SPIFFE_BUNDLES":          "example.org=https://spire.example.org/bundle",
+			},
+			nil,
+			true,
+		},
+		{
+			"SPIFFE_BUNDLES with a SPIFFE ID",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"SPIFFE_BUNDLES":          "spiffe://example.org=https://spire.example.org/bundle",
+				"SPIFFE_AUDIENCES":        "tokeninfo",
+			},
+			nil,
+			true,
+		},
+		{
+			"JWE_KEYS missing file",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"JWE_KEYS":                          "enc1=/does/not/exist.pem",
+			},
+			nil,
+			true,
+		},
+		{
+			"TOKEN_ROUTES_FILE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TOKEN_ROUTES_FILE":                 "/does/not/exist.json",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_USERINFO_URL invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_USERINFO_URL":             "http://[::1",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_TOKEN_EXCHANGE_URL invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_TOKEN_EXCHANGE_URL":       "http://[::1",
+			},
+			nil,
+			true,
+		},
+		{
+			"OPA_URL invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"OPA_URL":                           "http://[::1",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_SOFT_TIMEOUT not shorter than UPSTREAM_TIMEOUT",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_TIMEOUT":                  "500ms",
+				"UPSTREAM_SOFT_TIMEOUT":             "500ms",
+			},
+			nil,
+			true,
+		},
+		{
+			"OPENID_PROVIDER_REFRESH_JITTER too large",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"OPENID_PROVIDER_REFRESH_JITTER":    "1",
+			},
+			nil,
+			true,
+		},
+		{
+			"OPENID_PROVIDER_REFRESH_BACKOFF more than the maximum",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"OPENID_PROVIDER_REFRESH_BACKOFF":   "1m",
+			},
+			nil,
+			true,
+		},
+		{
+			"METRICS_SINKS unknown",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"METRICS_SINKS":                     "http,graphite",
+			},
+			nil,
+			true,
+		},
+		{
+			"STATSD_TAGS without dogstatsd",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"STATSD_FORMAT":                     "statsd",
+				"STATSD_TAGS":                       "env:test",
+			},
+			nil,
+			true,
+		},
+		{
+			"HEALTH_CRITICAL_DEPENDENCIES unknown",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"HEALTH_CRITICAL_DEPENDENCIES":      "keys,vault",
+			},
+			nil,
+			true,
+		},
+		{
+			"SELFTEST_KEY_FILE without SELFTEST_KEY_ID",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"SELFTEST_KEY_FILE":                 "/etc/tokeninfo/selftest.pem",
+			},
+			nil,
+			true,
+		},
+		{
+			"SELFTEST_TOKEN with SELFTEST_KEY_FILE",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"SELFTEST_KEY_FILE":                 "/etc/tokeninfo/selftest.pem",
+				"SELFTEST_KEY_ID":                   "selftest",
+				"SELFTEST_TOKEN":                    "eyJ...",
+			},
+			nil,
+			true,
+		},
+		{
+			"AUDIT_SINK unknown",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AUDIT_SINK":                        "syslog",
+				"AUDIT_HMAC_KEY":                    "secret",
+			},
+			nil,
+			true,
+		},
+		{
+			"AUDIT_SINK without AUDIT_HMAC_KEY",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AUDIT_SINK":                        "file",
+				"AUDIT_FILE":                        "/var/log/audit.log",
+			},
+			nil,
+			true,
+		},
+		{
+			"AUDIT_SINK file without AUDIT_FILE",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AUDIT_SINK":                        "file",
+				"AUDIT_HMAC_KEY":                    "secret",
+			},
+			nil,
+			true,
+		},
+		{
+			"AUDIT_SINK kafka without AUDIT_URL",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AUDIT_SINK":                        "kafka",
+				"AUDIT_HMAC_KEY":                    "secret",
+			},
+			nil,
+			true,
+		},
+		{
+			"AUDIT_SINK kafka-native without KAFKA_AUDIT_TOPIC",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"AUDIT_SINK":                        "kafka-native",
+				"KAFKA_BROKERS":                     "kafka:9092",
+				"AUDIT_HMAC_KEY":                    "secret",
+			},
+			nil,
+			true,
+		},
+		{
+			"KAFKA_REVOCATIONS_TOPIC without KAFKA_BROKERS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"KAFKA_REVOCATIONS_TOPIC":           "revocations",
+			},
+			nil,
+			true,
+		},
+		{
+			"KAFKA_SASL_MECHANISM unknown",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"KAFKA_SASL_MECHANISM":              "GSSAPI",
+			},
+			nil,
+			true,
+		},
+		{
+			"KAFKA_SASL_MECHANISM without password",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"KAFKA_SASL_MECHANISM":              "PLAIN",
+				"KAFKA_SASL_USERNAME":               "tokeninfo",
+			},
+			nil,
+			true,
+		},
+		{
+			"KAFKA_TLS_CERT_FILE without KAFKA_TLS_KEY_FILE",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"KAFKA_TLS_CERT_FILE":               "/etc/kafka/cert.pem",
+			},
+			nil,
+			true,
+		},
+		{
+			"WEBHOOK_URL without WEBHOOK_CONDITIONS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"WEBHOOK_URL":                       "https://soc.example.com/hooks/tokeninfo",
+			},
+			nil,
+			true,
+		},
+		{
+			"WEBHOOK_CONDITIONS unknown",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"WEBHOOK_URL":                       "https://soc.example.com/hooks/tokeninfo",
+				"WEBHOOK_CONDITIONS":                "new_realm",
+			},
+			nil,
+			true,
+		},
+		{
+			"WEBHOOK_CONDITIONS unexpected_realm without WEBHOOK_EXPECTED_REALMS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"WEBHOOK_URL":                       "https://soc.example.com/hooks/tokeninfo",
+				"WEBHOOK_CONDITIONS":                "unexpected_realm",
+			},
+			nil,
+			true,
+		},
+		{
+			"WEBHOOK_CONDITIONS revoked_token without TOKENINFO_ERROR_REASONS",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"WEBHOOK_URL":                       "https://soc.example.com/hooks/tokeninfo",
+				"WEBHOOK_CONDITIONS":                "revoked_token",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_STREAMING with the cache enabled",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_STREAMING":                "true",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_STREAMING with UPSTREAM_VALIDATE_RESPONSES",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CACHE_TTL":                "0",
+				"UPSTREAM_STREAMING":                "true",
+				"UPSTREAM_VALIDATE_RESPONSES":       "true",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_STREAMING with UPSTREAM_HEDGE_DELAY",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CACHE_TTL":                "0",
+				"UPSTREAM_STREAMING":                "true",
+				"UPSTREAM_HEDGE_DELAY":              "50ms",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_STREAMING with UPSTREAM_SHADOW_URL",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CACHE_TTL":                "0",
+				"UPSTREAM_STREAMING":                "true",
+				"UPSTREAM_SHADOW_URL":               "http://example.org",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_SHADOW_PERCENTAGE more than 100",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_SHADOW_URL":               "http://example.org",
+				"UPSTREAM_SHADOW_PERCENTAGE":        "150",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_CANARY_PERCENTAGE more than 100",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CANARY_URL":               "http://example.org",
+				"UPSTREAM_CANARY_PERCENTAGE":        "101",
+			},
+			nil,
+			true,
+		},
+		{
+			"JWT_VERIFY_PERCENTAGE without UPSTREAM_TOKENINFO_URL",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"JWT_VERIFY_PERCENTAGE":             "10",
+			},
+			nil,
+			true,
+		},
+		{
+			"CHAOS_ERROR_PERCENTAGE without CHAOS_MODE",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"CHAOS_ERROR_PERCENTAGE":            "10",
+			},
+			nil,
+			true,
+		},
+		{
+			"CHAOS_ERROR_STATUS invalid",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"CHAOS_MODE":                        "true",
+				"CHAOS_ERROR_STATUS":                "200",
+			},
+			nil,
+			true,
+		},
+		{
+			"TRUSTED_PROXIES invalid",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TRUSTED_PROXIES":                   "10.0.0.0/8,lb.example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"DEV_MODE with UPSTREAM_TOKENINFO_URL",
+			map[string]string{
+				"DEV_MODE":               "true",
+				"UPSTREAM_TOKENINFO_URL": "http://example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"DEV_MODE with OPENID_PROVIDER_CONFIGURATION_URL",
+			map[string]string{
+				"DEV_MODE":                          "true",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"DEV_MODE with STATIC_KEYS",
+			map[string]string{
+				"DEV_MODE":    "true",
+				"STATIC_KEYS": `{"keys": []}`,
+			},
+			nil,
+			true,
+		},
+		{
+			"DEV_MODE with REVOCATION_PROVIDER_URL invalid",
+			map[string]string{
+				"DEV_MODE":                "true",
+				"REVOCATION_PROVIDER_URL": "example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"RESPONSE_FIELD_ORDER without RESPONSE_CANONICAL_JSON",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"RESPONSE_FIELD_ORDER":              "uid,scope",
+			},
+			nil,
+			true,
+		},
+		{
+			"DISCOVERY_ISSUER invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"DISCOVERY_ISSUER":                  "http://[::1",
+			},
+			nil,
+			true,
+		},
+		{
+			"JWT_ALLOWED_ALGORITHMS none",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"JWT_ALLOWED_ALGORITHMS":            "RS256,none",
+			},
+			nil,
+			true,
+		},
+		{
+			"ISSUER_ALGORITHMS HMAC",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ISSUER_ALGORITHMS":                 "https://idp.example.org=RS256 HS256",
+			},
+			nil,
+			true,
+		},
+		{
+			"ISSUER_ALGORITHMS empty",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ISSUER_ALGORITHMS":                 "https://idp.example.org=",
+			},
+			nil,
+			true,
+		},
+		{
+			"JTI_DENYLIST_URL invalid",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"JTI_DENYLIST_URL":                  "http://[::1",
+			},
+			nil,
+			true,
+		},
+		{
+			"INVALIDATION_REDIS_URL invalid",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"INVALIDATION_REDIS_URL":            "redis://[::1",
+			},
+			nil,
+			true,
+		},
+		{
+			"CORS_ALLOWED_ORIGINS with path",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"CORS_ALLOWED_ORIGINS":              "https://app.example.org/login",
+			},
+			nil,
+			true,
+		},
+		{
+			"CORS_ALLOWED_ORIGINS without scheme",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"CORS_ALLOWED_ORIGINS":              "app.example.org",
+			},
+			nil,
+			true,
+		},
+		{
+			"TOKENINFO_TLS_CLIENT_CA_FILE without TLS",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TOKENINFO_TLS_CLIENT_CA_FILE":      "/etc/tls/ca.pem",
+			},
+			nil,
+			true,
+		},
+		{
+			"CALLER_CERT_SUBJECTS without TOKENINFO_TLS_CLIENT_CA_FILE",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TOKENINFO_TLS_CERT_FILE":           "/etc/tls/tls.crt",
+				"TOKENINFO_TLS_KEY_FILE":            "/etc/tls/tls.key",
+				"CALLER_CERT_SUBJECTS":              "app",
+			},
+			nil,
+			true,
+		},
+		{
+			"JWKS_URLS with static keys",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"STATIC_KEYS":             `{"keys": []}`,
+				"JWKS_URLS":               "http://example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"VAULT_KEYS_PATH without VAULT_ADDR",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"VAULT_KEYS_PATH":         "secret/data/tokeninfo",
+				"VAULT_TOKEN":             "s.root",
+			},
+			nil,
+			true,
+		},
+		{
+			"VAULT_TOKEN missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"VAULT_ADDR":              "http://example.com",
+				"VAULT_KEYS_PATH":         "secret/data/tokeninfo",
+			},
+			nil,
+			true,
+		},
+		{
+			"VAULT_KEYS_ENGINE invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"VAULT_ADDR":              "http://example.com",
+				"VAULT_KEYS_PATH":         "secret/data/tokeninfo",
+				"VAULT_TOKEN":             "s.root",
+				"VAULT_KEYS_ENGINE":       "pki",
+			},
+			nil,
+			true,
+		},
+		{
+			"VAULT_AUTH_SECRET_ID missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"VAULT_ADDR":              "http://example.com",
+				"VAULT_KEYS_PATH":         "secret/data/tokeninfo",
+				"VAULT_AUTH_METHOD":       "approle",
+				"VAULT_AUTH_ROLE":         "tokeninfo",
+			},
+			nil,
+			true,
+		},
+		{
+			"VAULT_AUTH_ROLE missing",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"VAULT_ADDR":              "http://example.com",
+				"VAULT_KEYS_PATH":         "secret/data/tokeninfo",
+				"VAULT_AUTH_METHOD":       "kubernetes",
+			},
+			nil,
+			true,
+		},
+		{
+			"AWS_KMS_KEYS without credentials",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"AWS_KMS_KEYS":            "signer=arn:aws:kms:eu-central-1:123456789012:key/1234abcd",
+			},
+			nil,
+			true,
+		},
+		{
+			"GCP_KMS_KEYS without key",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"GCP_KMS_KEYS":            "signer",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_BALANCING invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_BALANCING":                "random",
+			},
+			nil,
+			true,
+		},
+		{
+			"OPENID_PROVIDERS invalid",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"OPENID_PROVIDERS":        "https://idp.example.org",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_TOKENINFO_URL and OPENID_PROVIDER_CONFIGURATION_URL empty",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_TOKENINFO_URL empty, OPENID_PROVIDER_CONFIGURATION_URL set",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_TOKENINFO_URL set, OPENID_PROVIDER_CONFIGURATION_URL empty",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "",
+			},
+			nil,
+			true,
+		},
+		{
+			"REVOCATION_PROVIDER_URL empty",
+			map[string]string{
+				"REVOCATION_PROVIDER_URL": "",
+			},
+			nil,
+			true,
+		},
+		{
+			"REVOCATION_PROVIDER_URL set",
+			map[string]string{
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"UPSTREAM_TOKENINFO_URL, REVOCATION_PROVIDER_URL empty, OPENID_PROVIDER_CONFIGURATION_URL set",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "",
+			},
+			nil,
+			true,
+		},
+		{
+			"1",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "",
+				"REVOCATION_PROVIDER_URL":           "",
+			},
+			nil,
+			true,
+		},
+		// "2" was same as "4"
+		{
+			"3",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+			},
+			nil,
+			true,
+		},
+		{
+			"4",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              nil,
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"5",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"6",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"LISTEN_ADDRESS":                    ":80",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     ":80",
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"7",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"METRICS_LISTEN_ADDRESS":            ":80",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              ":80",
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"8",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":               "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL":    "http://example.com",
+				"REVOCATION_PROVIDER_URL":              "http://example.com",
+				"OPENID_PROVIDER_REFRESH_INTERVAL":     "1m",
+				"OPENID_PROVIDER_REFRESH_JITTER":       "0.2",
+				"OPENID_PROVIDER_REFRESH_BACKOFF":      "2s",
+				"OPENID_PROVIDER_REFRESH_MAX_BACKOFF":  "1m",
+				"OPENID_PROVIDER_KID_REFRESH_INTERVAL": "0",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     time.Minute,
+				OpenIDProviderRefreshJitter:       0.2,
+				OpenIDProviderRefreshBackoff:      2 * time.Second,
+				OpenIDProviderRefreshMaxBackoff:   time.Minute,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"9",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"HTTP_CLIENT_TIMEOUT":               "1ms",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 time.Millisecond,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"10",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"UPSTREAM_CACHE_MAX_SIZE":           "123456789",
+				"UPSTREAM_CACHE_TTL":                "17s",
+				"UPSTREAM_TIMEOUT":                  "18s",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"HTTP_CLIENT_TLS_TIMEOUT":           "10ms",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              123456789,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  17 * time.Second,
+				UpstreamTimeout:                   18 * time.Second,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              10 * time.Millisecond,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"11",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"UPSTREAM_CACHE_MAX_SIZE":           "0",
+				"UPSTREAM_CACHE_TTL":                "0",
+				"UPSTREAM_TIMEOUT":                  "0",
+				"UPSTREAM_STREAMING":                "true",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"HTTP_CLIENT_TLS_TIMEOUT":           "10ms",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              0,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  0,
+				UpstreamTimeout:                   0,
+				UpstreamStreaming:                 true,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              10 * time.Millisecond,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"12",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"REVOCATION_CACHE_TTL":              "10m0s",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                10 * time.Minute,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"13",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":               "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL":    "http://example.com",
+				"REVOCATION_PROVIDER_URL":              "http://example.com",
+				"REVOCATION_PROVIDER_REFRESH_INTERVAL": "30s",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: 30 * time.Second,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"14",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"REVOCATION_HASHING_SALT":           "TestSalt",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       "TestSalt",
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"15",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"REVOCATION_REFRESH_TOLERANCE":      "30s",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        30 * time.Second,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"16",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"INTROSPECTION_CLIENTS":             "foo:bar,baz:qux",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				IntrospectionClients:              map[string]string{"foo": "bar", "baz": "qux"},
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"17",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CACHE_BACKEND":            "redis",
+				"UPSTREAM_CACHE_REDIS_URL":          "http://example.com",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              "redis",
+				UpstreamCacheRedisURL:             exampleCom,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"18",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CIRCUIT_ERROR_THRESHOLD":  "25",
+				"UPSTREAM_CIRCUIT_REQUEST_VOLUME":   "5",
+				"UPSTREAM_CIRCUIT_SLEEP_WINDOW":     "30s",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     25,
+				UpstreamCircuitRequestVolume:      5,
+				UpstreamCircuitSleepWindow:        30 * time.Second,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"19",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":    "http://example.com",
+				"REVOCATION_PROVIDER_URL":   "http://example.com",
+				"OPENID_PROVIDERS":          "https://idp.example.org=http://idp.example.org/.well-known/openid-configuration",
+				"ISSUER_REALMS":             "https://idp.example.org=/partners",
+				"ISSUER_SCOPE_CLAIMS":       "https://idp.example.org=scp",
+				"KEYCLOAK_ISSUERS":          "https://keycloak.example.org/realms/shop",
+				"AZURE_AD_ISSUERS":          "https://login.microsoftonline.com/{tenantid}/v2.0",
+				"AZURE_AD_TENANTS":          "72f988bf-86f1-41af-91ab-2d7cd011db47",
+				"AZURE_AD_AUDIENCES":        "api://tokeninfo",
+				"GOOGLE_ID_TOKEN_AUDIENCES": "https://tokeninfo.example.org",
+				"SPIFFE_BUNDLES":            "example.org=https://spire.example.org/bundle",
+				"SPIFFE_AUDIENCES":          "tokeninfo",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    nil,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				OpenIDProviders:                   map[string]*url.URL{"https://idp.example.org": idpConfiguration, googleIssuer: googleConfiguration},
+				IssuerRealms:                      map[string]string{"https://idp.example.org": "/partners"},
+				IssuerScopeClaims:                 map[string]string{"https://idp.example.org": "scp"},
+				KeycloakIssuers:                   []string{"https://keycloak.example.org/realms/shop"},
+				AzureADIssuers:                    []string{"https://login.microsoftonline.com/{tenantid}/v2.0"},
+				AzureADTenants:                    []string{"72f988bf-86f1-41af-91ab-2d7cd011db47"},
+				AzureADAudiences:                  []string{"api://tokeninfo"},
+				GoogleIDTokenAudiences:            []string{"https://tokeninfo.example.org"},
+				GoogleIDTokenRealm:                defaultGoogleIDTokenRealm,
+				SPIFFEBundles:                     map[string]*url.URL{"example.org": spireBundle},
+				SPIFFEAudiences:                   []string{"tokeninfo"},
+				SPIFFERealm:                       defaultSPIFFERealm,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"20",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TOKENINFO_TLS_CERT_FILE":           "/etc/tls/tls.crt",
+				"TOKENINFO_TLS_KEY_FILE":            "/etc/tls/tls.key",
+				"TOKENINFO_TLS_RELOAD_INTERVAL":     "10s",
+				"TOKENINFO_TLS_CLIENT_CA_FILE":      "/etc/tls/ca.pem",
+				"CALLER_API_KEYS":                   "frontend:secret",
+				"CALLER_CERT_SUBJECTS":              "app, other",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 10 * time.Second,
+				TLSClientCAFile:                   "/etc/tls/ca.pem",
+				CallerAPIKeys:                     map[string]string{"frontend": "secret"},
+				CallerCertSubjects:                []string{"app", "other"},
+				TLSCertFile:                       "/etc/tls/tls.crt",
+				TLSKeyFile:                        "/etc/tls/tls.key",
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"21",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ACCESS_LOG_DESTINATION":            "stdout",
+				"ACCESS_LOG_SAMPLE_RATE":            "0.1",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               0.1,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				AccessLogDestination:              "stdout",
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"22",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"TRACING_ENABLED":                   "true",
+				"METRICS_SINKS":                     "http,statsd",
+				"STATSD_ADDRESS":                    "datadog:8125",
+				"STATSD_PREFIX":                     "tokeninfo",
+				"STATSD_TAGS":                       "env:test,team:iam",
+				"STATSD_FLUSH_INTERVAL":             "1m",
+				"METRICS_REALMS":                    "true",
+				"METRICS_SCOPES":                    "uid,cn",
+				"AUDIT_SINK":                        "kafka-native",
+				"AUDIT_HMAC_KEY":                    "secret",
+				"KAFKA_BROKERS":                     "kafka-1:9093,kafka-2:9093",
+				"KAFKA_TLS_CA_FILE":                 "/etc/kafka/ca.pem",
+				"KAFKA_SASL_MECHANISM":              "SCRAM-SHA-512",
+				"KAFKA_SASL_USERNAME":               "tokeninfo",
+				"KAFKA_SASL_PASSWORD":               "secret",
+				"KAFKA_AUDIT_TOPIC":                 "audit",
+				"KAFKA_REVOCATIONS_TOPIC":           "revocations",
+				"KAFKA_BATCH_SIZE":                  "500",
+				"KAFKA_LINGER":                      "100ms",
+				"AUDIT_QUEUE_SIZE":                  "500",
+				"AUDIT_FLUSH_INTERVAL":              "5s",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http", "statsd"},
+				StatsDAddress:                     "datadog:8125",
+				StatsDPrefix:                      "tokeninfo",
+				StatsDTags:                        []string{"env:test", "team:iam"},
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               time.Minute,
+				AuditSink:                         "kafka-native",
+				AuditHMACKey:                      "secret",
+				AuditQueueSize:                    500,
+				AuditFlushInterval:                5 * time.Second,
+				KafkaBrokers:                      []string{"kafka-1:9093", "kafka-2:9093"},
+				KafkaTLS:                          true,
+				KafkaTLSCAFile:                    "/etc/kafka/ca.pem",
+				KafkaSASLMechanism:                "SCRAM-SHA-512",
+				KafkaSASLUsername:                 "tokeninfo",
+				KafkaSASLPassword:                 "secret",
+				KafkaAuditTopic:                   "audit",
+				KafkaRevocationsTopic:             "revocations",
+				KafkaBatchSize:                    500,
+				KafkaLinger:                       100 * time.Millisecond,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				MetricsRealms:                     true,
+				MetricsScopes:                     []string{"uid", "cn"},
+				TracingEnabled:                    true,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"23",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"EXT_AUTHZ_LISTEN_ADDRESS":          ":9022",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				ExtAuthzListenAddress:             ":9022",
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"24",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_CACHE_MIN_TTL":            "5s",
+				"UPSTREAM_CACHE_MAX_TTL":            "10m",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamCacheMinTTL:               5 * time.Second,
+				UpstreamCacheMaxTTL:               10 * time.Minute,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"25",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_RETRIES":                  "2",
+				"UPSTREAM_RETRY_BACKOFF":            "10ms",
+				"UPSTREAM_RETRY_BUDGET":             "0.2",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              10 * time.Millisecond,
+				UpstreamRetryBudget:               0.2,
+				UpstreamRetries:                   2,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+			},
+			false,
+		},
+		{
+			"26",
+			map[string]string{
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com,http://example.org",
+				"UPSTREAM_BALANCING":                "failover",
+				"UPSTREAM_EJECT_DURATION":           "30s",
+				"UPSTREAM_SHADOW_URL":               "http://example.org",
+				"UPSTREAM_SHADOW_PERCENTAGE":        "10",
+				"UPSTREAM_CANARY_URL":               "http://example.org",
+				"UPSTREAM_CANARY_PERCENTAGE":        "5",
+				"JWT_VERIFY_PERCENTAGE":             "20",
+			},
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom, exampleOrg},
+				UpstreamShadowURL:                 exampleOrg,
+				UpstreamShadowPercentage:          10,
+				UpstreamCanaryURL:                 exampleOrg,
+				UpstreamCanaryPercentage:          5,
+				JwtVerifyPercentage:               20,
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -142,22 +3014,68 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 "failover",
+				UpstreamEjectDuration:             30 * time.Second,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"UPSTREAM_TOKENINFO_URL set",
-			map[string]string{"UPSTREAM_TOKENINFO_URL": "http://example.com"},
+			"27",
+			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
+				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"HTTP_CLIENT_TLS_CERT_FILE":         "/etc/tls/client.crt",
+				"HTTP_CLIENT_TLS_KEY_FILE":          "/etc/tls/client.key",
+				"HTTP_CLIENT_TLS_CA_FILE":           "/etc/tls/ca.crt",
+			},
 			&Settings{
-				UpstreamTokenInfoURL:              nil,
-				OpenIDProviderConfigurationURL:    nil,
-				RevocationProviderUrl:             nil,
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -165,24 +3083,69 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				HTTPClientCertFile:                "/etc/tls/client.crt",
+				HTTPClientKeyFile:                 "/etc/tls/client.key",
+				HTTPClientCAFile:                  "/etc/tls/ca.crt",
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"OPENID_PROVIDER_CONFIGURATION_URL set",
+			"28",
 			map[string]string{
+				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"OPENID_PROVIDER_KEY_GRACE_PERIOD":  "5m",
 			},
 			&Settings{
-				UpstreamTokenInfoURL:              nil,
-				OpenIDProviderConfigurationURL:    nil,
-				RevocationProviderUrl:             nil,
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    exampleCom,
+				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -190,108 +3153,140 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				OpenIDProviderKeyGracePeriod:      5 * time.Minute,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"OPENID_PROVIDER_CONFIGURATION_URL empty",
-			map[string]string{
-				"OPENID_PROVIDER_CONFIGURATION_URL": "",
-			},
-			nil,
-			true,
-		},
-		{
-			"UPSTREAM_TOKENINFO_URL and OPENID_PROVIDER_CONFIGURATION_URL empty",
-			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "",
-			},
-			nil,
-			true,
-		},
-		{
-			"UPSTREAM_TOKENINFO_URL empty, OPENID_PROVIDER_CONFIGURATION_URL set",
-			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-			},
-			nil,
-			true,
-		},
-		{
-			"UPSTREAM_TOKENINFO_URL set, OPENID_PROVIDER_CONFIGURATION_URL empty",
-			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "",
-			},
-			nil,
-			true,
-		},
-		{
-			"REVOCATION_PROVIDER_URL empty",
-			map[string]string{
-				"REVOCATION_PROVIDER_URL": "",
-			},
-			nil,
-			true,
-		},
-		{
-			"REVOCATION_PROVIDER_URL set",
-			map[string]string{
-				"REVOCATION_PROVIDER_URL": "http://example.com",
-			},
-			nil,
-			true,
-		},
-		{
-			"UPSTREAM_TOKENINFO_URL, REVOCATION_PROVIDER_URL empty, OPENID_PROVIDER_CONFIGURATION_URL set",
+			"29",
 			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-				"REVOCATION_PROVIDER_URL":           "",
+				"UPSTREAM_TOKENINFO_URL":      "http://example.com",
+				"REVOCATION_PROVIDER_URL":     "http://example.com",
+				"STATIC_KEYS_FILE":            "/etc/keys/jwks.json",
+				"STATIC_KEYS_RELOAD_INTERVAL": "10s",
 			},
-			nil,
-			true,
-		},
-		{
-			"1",
-			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "",
-				"REVOCATION_PROVIDER_URL":           "",
+			&Settings{
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    nil,
+				RevocationProviderUrl:             exampleCom,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
+				MetricsListenAddress:              defaultMetricsListenAddress,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
+				HashingSalt:                       defaultHashingSalt,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
+				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          10 * time.Second,
+				StaticKeysFile:                    "/etc/keys/jwks.json",
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
-			nil,
-			true,
+			false,
 		},
-		// "2" was same as "4"
 		{
-			"3",
+			"30",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "",
-				"REVOCATION_PROVIDER_URL":           "http://example.com",
-			},
-			nil,
-			true,
-		},
-		{
-			"4",
-			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"READINESS_FAILURE_THRESHOLD":       "5",
+				"HEALTH_CRITICAL_DEPENDENCIES":      "keys,upstream",
+				"HEALTH_REVOCATIONS_MAX_AGE":        "1m",
+				"SELFTEST_KEY_FILE":                 "/etc/tokeninfo/selftest.pem",
+				"SELFTEST_KEY_ID":                   "selftest",
+				"SELFTEST_CLAIMS":                   "iss:https://example.com,scope:uid",
 			},
 			&Settings{
-				UpstreamTokenInfoURL:              nil,
+				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -299,26 +3294,74 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         5,
+				HealthCriticalDependencies:        []string{"keys", "upstream"},
+				HealthRevocationsMaxAge:           time.Minute,
+				SelftestKeyFile:                   "/etc/tokeninfo/selftest.pem",
+				SelftestKeyID:                     "selftest",
+				SelftestClaims:                    map[string]string{"iss": "https://example.com", "scope": "uid"},
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"5",
+			"31",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"ADMIN_LISTEN_ADDRESS":              ":9022",
+				"ADMIN_USERS":                       "admin:secret",
+				"DEBUG_LISTEN_ADDRESS":              "127.0.0.1:6060",
+				"DEBUG_SNAPSHOT_DIR":                "/var/tmp",
+				"LISTENERS":                         "[::]:9021=tokeninfo, 0.0.0.0:9021=tokeninfo,:9023=health metrics admin,unix:/run/tokeninfo.sock=tokeninfo",
+				"UNIX_SOCKET_MODE":                  "0660",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -326,83 +3369,235 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				AdminListenAddress:                ":9022",
+				AdminUsers:                        map[string]string{"admin": "secret"},
+				DebugListenAddress:                "127.0.0.1:6060",
+				DebugSnapshotDir:                  "/var/tmp",
+				UnixSocketMode:                    0660,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+				Listeners: map[string][]string{
+					"[::]:9021":                {"tokeninfo"},
+					"0.0.0.0:9021":             {"tokeninfo"},
+					":9023":                    {"health", "metrics", "admin"},
+					"unix:/run/tokeninfo.sock": {"tokeninfo"},
+				},
 			},
 			false,
 		},
 		{
-			"6",
+			"32",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"LISTEN_ADDRESS":                    ":80",
+				"RATE_LIMIT_GLOBAL":                 "1000",
+				"RATE_LIMIT_PER_IP":                 "50",
+				"RATE_LIMIT_PER_TOKEN":              "2.5",
+				"TRUSTED_PROXIES":                   "10.0.0.0/8, fd00::/8,192.0.2.1",
+				"MAX_TOKEN_LENGTH":                  "4096",
+				"MAX_URL_LENGTH":                    "0",
+				"MAX_REQUEST_BODY_SIZE":             "1024",
+				"CORS_ALLOWED_ORIGINS":              "https://app.example.org, https://*.example.com",
+				"CORS_ALLOWED_METHODS":              "GET",
+				"CORS_ALLOWED_HEADERS":              "Authorization",
+				"CORS_MAX_AGE":                      "10m",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
-				ListenAddress:                     ":80",
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
+				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
 				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    4096,
+				MaxURLLength:                      0,
+				MaxRequestBodySize:                1024,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				RateLimitGlobal:                   1000,
+				RateLimitPerIP:                    50,
+				RateLimitPerToken:                 2.5,
+				TrustedProxies:                    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8"), netip.MustParsePrefix("192.0.2.1/32")},
+				CORSAllowedOrigins:                []string{"https://app.example.org", "https://*.example.com"},
+				CORSAllowedMethods:                []string{"GET"},
+				CORSAllowedHeaders:                []string{"Authorization"},
+				CORSMaxAge:                        10 * time.Minute,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"7",
+			"33",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"METRICS_LISTEN_ADDRESS":            ":80",
+				"BATCH_MAX_TOKENS":                  "50",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
-				MetricsListenAddress:              ":80",
+				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
 				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    50,
 			},
 			false,
 		},
 		{
-			"8",
+			"34",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"OPENID_PROVIDER_REFRESH_INTERVAL":  "1m",
+				"TOKENINFO_CLOCK_SKEW":              "30s",
+				"TOKENINFO_ERROR_REASONS":           "true",
+				"WEBHOOK_URL":                       "https://soc.example.com/hooks/tokeninfo",
+				"WEBHOOK_CONDITIONS":                "new_token,revoked_token,unexpected_realm",
+				"WEBHOOK_EXPECTED_REALMS":           "/services,/employees",
+				"WEBHOOK_SECRET":                    "secret",
+				"WEBHOOK_FLUSH_INTERVAL":            "1s",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
-				OpenIDProviderRefreshInterval:     time.Minute,
+				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -410,27 +3605,73 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookURL:                        webhookURL,
+				WebhookConditions:                 []string{"new_token", "revoked_token", "unexpected_realm"},
+				WebhookExpectedRealms:             []string{"/services", "/employees"},
+				WebhookSecret:                     "secret",
+				WebhookFlushInterval:              time.Second,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+				ClockSkew:                         30 * time.Second,
+				ErrorReasons:                      true,
 			},
 			false,
 		},
 		{
-			"9",
+			"35",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"HTTP_CLIENT_TIMEOUT":               "1ms",
+				"UPSTREAM_VALIDATE_RESPONSES":       "true",
+				"UPSTREAM_ALLOWED_FIELDS":           "realm, token_type,,",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
-				HTTPClientTimeout:                 time.Millisecond,
+				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -438,30 +3679,121 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+				UpstreamValidateResponses:         true,
+				UpstreamAllowedFields:             []string{"realm", "token_type"},
 			},
 			false,
 		},
 		{
-			"10",
+			"36",
 			map[string]string{
 				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"UPSTREAM_CACHE_MAX_SIZE":           "123456789",
-				"UPSTREAM_CACHE_TTL":                "17s",
-				"UPSTREAM_TIMEOUT":                  "18s",
 				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-				"HTTP_CLIENT_TLS_TIMEOUT":           "10ms",
 				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_FORWARD_HEADERS":          "X-Request-Id,traceparent",
+				"UPSTREAM_HEADERS":                  "X-Api-Key:secret",
+				"UPSTREAM_CACHE_SNAPSHOT_FILE":      "/var/cache/tokeninfo.json",
+				"UPSTREAM_MAX_BODY_SIZE":            "4096",
+				"UPSTREAM_CACHE_SHARDS":             "64",
+				"UPSTREAM_USERINFO_URL":             "http://example.com",
+				"USERINFO_CACHE_TTL":                "5m",
+				"UPSTREAM_TOKEN_EXCHANGE_URL":       "http://example.com",
+				"TOKEN_EXCHANGE_CACHE_TTL":          "30s",
+				"KEYS_PRELOAD_TIMEOUT":              "10s",
+				"OPA_URL":                           "http://example.com",
+				"OPA_TIMEOUT":                       "2s",
+				"OPA_FAIL_OPEN":                     "true",
+				"UPSTREAM_SOFT_TIMEOUT":             "200ms",
+				"DISCOVERY_ISSUER":                  "http://example.com",
+				"DISCOVERY_HOSTS":                   "tokeninfo.example.org,tokeninfo.internal:9021",
+				"JWT_ALLOWED_ALGORITHMS":            "RS256,PS256",
+				"ISSUER_ALGORITHMS":                 "https://idp.example.org=PS256 ES256,https://other.example.org=EdDSA",
+				"JTI_DENYLIST_URL":                  "http://example.com",
+				"JTI_DENYLIST_REFRESH_INTERVAL":     "5m",
+				"JTI_DENYLIST_REDIS_KEY":            "denied",
+				"JTI_DENYLIST_FAIL_CLOSED":          "true",
+				"REVOCATION_PUSH_CLIENTS":           "idp:secret",
+				"REVOCATION_SNAPSHOT_FILE":          "/var/lib/planb/revocations",
+				"INVALIDATION_REDIS_URL":            "redis://localhost:6379/0",
+				"INVALIDATION_CHANNEL":              "invalidation",
+				"RESPONSE_CACHE_MAX_AGE":            "2m",
+				"RESPONSE_CACHE_DIRECTIVES":         "private, must-revalidate",
+				"RESPONSE_CANONICAL_JSON":           "true",
+				"RESPONSE_FIELD_ORDER":              "uid,scope",
+				"RESPONSE_ETAGS":                    "true",
+				"CHAOS_MODE":                        "true",
+				"CHAOS_LATENCY":                     "2s",
+				"CHAOS_LATENCY_PERCENTAGE":          "50",
+				"CHAOS_MALFORMED_PERCENTAGE":        "1",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
 				OpenIDProviderConfigurationURL:    exampleCom,
 				RevocationProviderUrl:             exampleCom,
-				UpstreamCacheMaxSize:              123456789,
-				UpstreamCacheTTL:                  17 * time.Second,
-				UpstreamTimeout:                   18 * time.Second,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               4096,
+				UpstreamCacheShards:               64,
+				UpstreamUserInfoURL:               exampleCom,
+				UserInfoCacheTTL:                  5 * time.Minute,
+				UpstreamTokenExchangeURL:          exampleCom,
+				TokenExchangeCacheTTL:             30 * time.Second,
+				KeysPreloadTimeout:                10 * time.Second,
+				OPAURL:                            exampleCom,
+				OPATimeout:                        2 * time.Second,
+				OPAFailOpen:                       true,
+				UpstreamSoftTimeout:               200 * time.Millisecond,
+				JtiDenyListRefreshInterval:        5 * time.Minute,
+				JtiDenyListRedisKey:               "denied",
+				InvalidationRedisURL:              exampleRedis,
+				InvalidationChannel:               "invalidation",
+				ResponseCacheMaxAge:               2 * time.Minute,
+				ResponseCacheDirectives:           "private, must-revalidate",
+				ResponseCanonicalJSON:             true,
+				ResponseFieldOrder:                []string{"uid", "scope"},
+				ResponseETags:                     true,
+				ChaosMode:                         true,
+				ChaosLatency:                      2 * time.Second,
+				ChaosLatencyPercentage:            50,
+				ChaosErrorStatus:                  503,
+				ChaosMalformedPercentage:          1,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
-				HTTPClientTLSTimeout:              10 * time.Millisecond,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -469,30 +3801,76 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+				UpstreamForwardHeaders:            []string{"X-Request-Id", "traceparent"},
+				UpstreamHeaders:                   map[string]string{"X-Api-Key": "secret"},
+				UpstreamCacheSnapshotFile:         "/var/cache/tokeninfo.json",
+				DiscoveryIssuer:                   exampleCom,
+				DiscoveryHosts:                    []string{"tokeninfo.example.org", "tokeninfo.internal:9021"},
+				JwtAllowedAlgorithms:              []string{"RS256", "PS256"},
+				IssuerAlgorithms:                  map[string][]string{"https://idp.example.org": {"PS256", "ES256"}, "https://other.example.org": {"EdDSA"}},
+				JtiDenyListURL:                    exampleCom,
+				JtiDenyListFailClosed:             true,
+				RevocationPushClients:             map[string]string{"idp": "secret"},
+				RevocationSnapshotFile:            "/var/lib/planb/revocations",
 			},
 			false,
 		},
 		{
-			"11",
+			"37",
 			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"UPSTREAM_CACHE_MAX_SIZE":           "0",
-				"UPSTREAM_CACHE_TTL":                "0",
-				"UPSTREAM_TIMEOUT":                  "0",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-				"HTTP_CLIENT_TLS_TIMEOUT":           "10ms",
-				"REVOCATION_PROVIDER_URL":           "http://example.com",
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"JWKS_URLS":               "http://example.com, http://idp.example.org/jwks",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
-				OpenIDProviderConfigurationURL:    exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    nil,
 				RevocationProviderUrl:             exampleCom,
-				UpstreamCacheMaxSize:              0,
-				UpstreamCacheTTL:                  0,
-				UpstreamTimeout:                   0,
+				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
+				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
+				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
-				HTTPClientTLSTimeout:              10 * time.Millisecond,
+				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
@@ -500,118 +3878,321 @@ func TestLoading(t *testing.T) {
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				JwksURLs:                          []*url.URL{exampleCom, idpJwks},
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"12",
+			"38",
 			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"REVOCATION_CACHE_TTL":              "10m0s",
+				"UPSTREAM_TOKENINFO_URL":  "http://example.com",
+				"REVOCATION_PROVIDER_URL": "http://example.com",
+				"VAULT_ADDR":              "http://example.com",
+				"VAULT_NAMESPACE":         "team",
+				"VAULT_KEYS_PATH":         "transit/keys/tokeninfo",
+				"VAULT_KEYS_ENGINE":       "transit",
+				"VAULT_AUTH_METHOD":       "kubernetes",
+				"VAULT_AUTH_ROLE":         "tokeninfo",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
-				OpenIDProviderConfigurationURL:    exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    nil,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
-				RevocationCacheTTL:                10 * time.Minute,
+				RevocationCacheTTL:                defaultRevocationCacheTTL,
 				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				VaultAddress:                      exampleCom,
+				VaultNamespace:                    "team",
+				VaultKeysPath:                     "transit/keys/tokeninfo",
+				VaultKeysEngine:                   "transit",
+				VaultKeysField:                    "keys",
+				VaultAuthMethod:                   "kubernetes",
+				VaultAuthRole:                     "tokeninfo",
+				VaultKubernetesTokenFile:          defaultVaultKubernetesTokenFile,
+				VaultRefreshInterval:              defaultVaultRefreshInterval,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"13",
+			"39",
 			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":               "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL":    "http://example.com",
-				"REVOCATION_PROVIDER_URL":              "http://example.com",
-				"REVOCATION_PROVIDER_REFRESH_INTERVAL": "30s",
+				"UPSTREAM_TOKENINFO_URL":         "http://example.com",
+				"REVOCATION_PROVIDER_URL":        "http://example.com",
+				"AWS_KMS_KEYS":                   "signer=arn:aws:kms:eu-central-1:123456789012:key/1234abcd,legacy=alias/legacy",
+				"AWS_REGION":                     "eu-central-1",
+				"AWS_ACCESS_KEY_ID":              "AKID",
+				"AWS_SECRET_ACCESS_KEY":          "secret",
+				"AWS_KMS_ENDPOINT":               "http://example.com",
+				"GCP_KMS_KEYS":                   "gcp=projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+				"GOOGLE_APPLICATION_CREDENTIALS": "/etc/gcp/credentials.json",
 			},
 			&Settings{
 				UpstreamTokenInfoURL:              exampleCom,
-				OpenIDProviderConfigurationURL:    exampleCom,
+				UpstreamTokenInfoURLs:             []*url.URL{exampleCom},
+				OpenIDProviderConfigurationURL:    nil,
 				RevocationProviderUrl:             exampleCom,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
-				RevocationProviderRefreshInterval: 30 * time.Second,
+				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
 				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				AWSKMSKeys:                        map[string]string{"signer": "arn:aws:kms:eu-central-1:123456789012:key/1234abcd", "legacy": "alias/legacy"},
+				AWSKMSEndpoint:                    exampleCom,
+				AWSRegion:                         "eu-central-1",
+				AWSAccessKeyID:                    "AKID",
+				AWSSecretAccessKey:                "secret",
+				GCPKMSKeys:                        map[string]string{"gcp": "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"},
+				GCPCredentialsFile:                "/etc/gcp/credentials.json",
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
 			},
 			false,
 		},
 		{
-			"14",
+			"40",
 			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"REVOCATION_HASHING_SALT":           "TestSalt",
+				"DEV_MODE": "true",
 			},
 			&Settings{
-				UpstreamTokenInfoURL:              exampleCom,
-				OpenIDProviderConfigurationURL:    exampleCom,
-				RevocationProviderUrl:             exampleCom,
+				OpenIDProviderConfigurationURL:    nil,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
 				UpstreamTimeout:                   defaultUpstreamTimeout,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
 				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
-				HashingSalt:                       "TestSalt",
+				HashingSalt:                       defaultHashingSalt,
 				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+				DevMode:                           true,
 			},
 			false,
 		},
 		{
-			"15",
+			"41",
 			map[string]string{
-				"UPSTREAM_TOKENINFO_URL":            "http://example.com",
-				"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
-				"REVOCATION_PROVIDER_URL":           "http://example.com",
-				"REVOCATION_REFRESH_TOLERANCE":      "30s",
+				"DEV_MODE":              "true",
+				"UPSTREAM_TIMEOUT":      "0",
+				"UPSTREAM_SOFT_TIMEOUT": "200ms",
 			},
 			&Settings{
-				UpstreamTokenInfoURL:              exampleCom,
-				OpenIDProviderConfigurationURL:    exampleCom,
-				RevocationProviderUrl:             exampleCom,
+				OpenIDProviderConfigurationURL:    nil,
 				UpstreamCacheMaxSize:              defaultUpstreamCacheMaxSize,
+				UpstreamMaxBodySize:               defaultUpstreamMaxBodySize,
+				UpstreamCacheShards:               defaultUpstreamCacheShards,
+				UserInfoCacheTTL:                  defaultUserInfoCacheTTL,
+				JtiDenyListRefreshInterval:        defaultJtiDenyListRefreshInterval,
+				JtiDenyListRedisKey:               defaultJtiDenyListRedisKey,
+				InvalidationChannel:               defaultInvalidationChannel,
 				UpstreamCacheTTL:                  defaultUpstreamCacheTTL,
-				UpstreamTimeout:                   defaultUpstreamTimeout,
+				UpstreamTimeout:                   0,
+				UpstreamSoftTimeout:               200 * time.Millisecond,
 				HTTPClientTimeout:                 defaultHTTPClientTimeout,
 				HTTPClientTLSTimeout:              defaultHTTPClientTLSTimeout,
 				OpenIDProviderRefreshInterval:     defaultOpenIDRefreshInterval,
+				OpenIDProviderRefreshJitter:       defaultOpenIDRefreshJitter,
+				OpenIDProviderRefreshBackoff:      defaultOpenIDRefreshBackoff,
+				OpenIDProviderRefreshMaxBackoff:   defaultOpenIDRefreshMaxBackoff,
+				OpenIDProviderKidRefreshInterval:  defaultOpenIDKidRefreshInterval,
 				ListenAddress:                     defaultListenAddress,
 				MetricsListenAddress:              defaultMetricsListenAddress,
 				RevocationCacheTTL:                defaultRevocationCacheTTL,
 				RevocationProviderRefreshInterval: defaultRevokeProviderRefreshInterval,
 				HashingSalt:                       defaultHashingSalt,
-				RevocationRefreshTolerance:        30 * time.Second,
+				RevocationRefreshTolerance:        defaultRevocationRereshTolerance,
 				JwtProcessors:                     make(map[string]processor.JwtProcessor),
+				UpstreamCacheBackend:              defaultUpstreamCacheBackend,
+				UpstreamCircuitErrorThreshold:     defaultUpstreamCircuitErrorThreshold,
+				UpstreamCircuitRequestVolume:      defaultUpstreamCircuitRequestVolume,
+				UpstreamCircuitSleepWindow:        defaultUpstreamCircuitSleepWindow,
+				TLSReloadInterval:                 defaultTLSReloadInterval,
+				AccessLogSampleRate:               defaultAccessLogSampleRate,
+				MetricsSinks:                      []string{"http"},
+				StatsDAddress:                     defaultStatsDAddress,
+				StatsDFormat:                      defaultStatsDFormat,
+				StatsDFlushInterval:               defaultStatsDFlushInterval,
+				AuditQueueSize:                    defaultAuditQueueSize,
+				AuditFlushInterval:                defaultAuditFlushInterval,
+				KafkaBatchSize:                    defaultKafkaBatchSize,
+				KafkaLinger:                       defaultKafkaLinger,
+				KafkaTimeout:                      defaultKafkaTimeout,
+				WebhookFlushInterval:              defaultWebhookFlushInterval,
+				MaxTokenLength:                    defaultMaxTokenLength,
+				MaxURLLength:                      defaultMaxURLLength,
+				MaxRequestBodySize:                defaultMaxRequestBodySize,
+				UpstreamRetryBackoff:              defaultUpstreamRetryBackoff,
+				UpstreamRetryBudget:               defaultUpstreamRetryBudget,
+				UpstreamBalancing:                 defaultUpstreamBalancing,
+				UpstreamEjectDuration:             defaultUpstreamEjectDuration,
+				StaticKeysReloadInterval:          defaultStaticKeysReloadInterval,
+				ReadinessFailureThreshold:         defaultReadinessFailureThreshold,
+				HealthCriticalDependencies:        []string{"keys"},
+				HealthRevocationsMaxAge:           defaultHealthRevocationsMaxAge,
+				BatchMaxTokens:                    defaultBatchMaxTokens,
+				DevMode:                           true,
 			},
 			false,
 		},
@@ -626,9 +4207,127 @@ func TestLoading(t *testing.T) {
 				t.Errorf("TEST %s: Wanted failure to load settings but it seems that it succeeded: %+v", test.name, test)
 			}
 		} else {
-			if !reflect.DeepEqual(AppSettings, test.want) {
-				t.Errorf("TEST %s: Settings mismatch.\nWanted %+v\nGot %+v", test.name, test.want, AppSettings)
+			if !reflect.DeepEqual(Current(), test.want) {
+				t.Errorf("TEST %s: Settings mismatch.\nWanted %+v\nGot %+v", test.name, test.want, Current())
 			}
 		}
 	}
 }
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	ioutil.WriteFile(path, []byte(`{
+		"OPENID_PROVIDER_CONFIGURATION_URL": "http://example.com",
+		"REVOCATION_PROVIDER_URL": "http://example.com",
+		"UPSTREAM_CACHE_TTL": "5m",
+		"UPSTREAM_RETRIES": 2,
+		"TRACING_ENABLED": true
+	}`), 0600)
+
+	os.Clearenv()
+	os.Setenv("CONFIG_FILE", path)
+	os.Setenv("UPSTREAM_RETRIES", "3")
+	if err := LoadFromEnvironment(); err != nil {
+		t.Fatal("Failed to load the config file: ", err)
+	}
+	if Current().UpstreamCacheTTL != 5*time.Minute || Current().UpstreamRetries != 3 || !Current().TracingEnabled {
+		t.Errorf("Wrong settings from the config file: %+v", Current())
+	}
+
+	for _, invalid := range []string{`not json`, `{"UPSTREAM_RETRIES": [1]}`} {
+		ioutil.WriteFile(path, []byte(invalid), 0600)
+		if err := LoadFromEnvironment(); err == nil {
+			t.Errorf("Invalid config file %s should fail", invalid)
+		}
+	}
+	os.Setenv("CONFIG_FILE", "/does/not/exist.json")
+	if err := LoadFromEnvironment(); err == nil {
+		t.Error("Missing config file should fail")
+	}
+}
+
+func TestYAMLConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	ioutil.WriteFile(path, []byte(`
+OPENID_PROVIDER_CONFIGURATION_URL: http://example.com
+REVOCATION_PROVIDER_URL: http://example.com
+UPSTREAM_CACHE_TTL: 5m
+UPSTREAM_RETRIES: 2
+RATE_LIMIT_GLOBAL: 12.5
+TRACING_ENABLED: true
+`), 0600)
+
+	os.Clearenv()
+	os.Setenv("CONFIG_FILE", path)
+	if err := LoadFromEnvironment(); err != nil {
+		t.Fatal("Failed to load the YAML config file: ", err)
+	}
+	if Current().UpstreamCacheTTL != 5*time.Minute || Current().UpstreamRetries != 2 || Current().RateLimitGlobal != 12.5 || !Current().TracingEnabled {
+		t.Errorf("Wrong settings from the YAML config file: %+v", Current())
+	}
+
+	for _, invalid := range []string{"UPSTREAM_RETRIES: [1]", "- not a mapping", "UPSTREAM_RETRIES: {"} {
+		ioutil.WriteFile(path, []byte(invalid), 0600)
+		if err := LoadFromEnvironment(); err == nil {
+			t.Errorf("Invalid YAML config file %s should fail", invalid)
+		}
+	}
+}
+
+func TestTokenRoutesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	ioutil.WriteFile(path, []byte(`{"routes": [{"prefix": "legacy.", "target": "https://legacy.example.org"}, {"dots": 2, "target": "jwt"}]}`), 0600)
+
+	os.Clearenv()
+	os.Setenv("OPENID_PROVIDER_CONFIGURATION_URL", "http://example.com")
+	os.Setenv("REVOCATION_PROVIDER_URL", "http://example.com")
+	os.Setenv("TOKEN_ROUTES_FILE", path)
+	if err := LoadFromEnvironment(); err == nil {
+		t.Error("Routes to other upstreams without UPSTREAM_TOKENINFO_URL should fail")
+	}
+
+	os.Setenv("UPSTREAM_TOKENINFO_URL", "http://example.com")
+	if err := LoadFromEnvironment(); err != nil {
+		t.Fatal("Failed to load the token routes: ", err)
+	}
+	if r := Current().TokenRoutes.Route("legacy.a.b"); r == nil || r.Target != "https://legacy.example.org" {
+		t.Errorf("Wrong route for a legacy token: %+v", r)
+	}
+}
+
+func TestReload(t *testing.T) {
+	defer func(hooks []func(*Settings)) { reloadHooks = hooks }(reloadHooks)
+	var reloaded *Settings
+	OnReload(func(s *Settings) { reloaded = s })
+
+	os.Clearenv()
+	os.Setenv("OPENID_PROVIDER_CONFIGURATION_URL", "http://example.com")
+	os.Setenv("REVOCATION_PROVIDER_URL", "http://example.com")
+	if err := LoadFromEnvironment(); err != nil {
+		t.Fatal("Failed to load the settings: ", err)
+	}
+	s := *Current()
+	s.JwtProcessors = map[string]processor.JwtProcessor{"issuer": nil}
+	Replace(&s)
+
+	os.Setenv("UPSTREAM_TIMEOUT", "3s")
+	if err := Reload(); err != nil {
+		t.Fatal("Failed to reload the settings: ", err)
+	}
+	if reloaded != Current() || Current().UpstreamTimeout != 3*time.Second {
+		t.Errorf("Wrong reloaded settings: %+v", reloaded)
+	}
+	if _, has := Current().JwtProcessors["issuer"]; !has {
+		t.Error("The JWT processors should be kept on reload")
+	}
+
+	current := Current()
+	reloaded = nil
+	os.Unsetenv("REVOCATION_PROVIDER_URL")
+	if err := Reload(); err == nil {
+		t.Error("Reloading invalid settings should fail")
+	}
+	if Current() != current || reloaded != nil {
+		t.Error("The current settings should be kept when the reload fails")
+	}
+}
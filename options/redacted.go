@@ -0,0 +1,98 @@
+package options
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/processor"
+)
+
+// redactedValue replaces the secrets in Redacted
+const redactedValue = "REDACTED"
+
+var (
+	// secretSettings are replaced as a whole
+	secretSettings = map[string]bool{"VaultToken": true, "VaultAuthSecretID": true, "AWSSecretAccessKey": true,
+		"AWSSessionToken": true, "HashingSalt": true, "TokenHashSalt": true,
+		"SelftestToken": true, "KafkaSASLPassword": true, "WebhookSecret": true, "AuditHMACKey": true}
+	// secretMapSettings keep their names, for ex. the users, but their values are replaced
+	secretMapSettings = map[string]bool{"AdminUsers": true, "CallerAPIKeys": true, "IntrospectionClients": true,
+		"RevocationPushClients": true, "UpstreamHeaders": true}
+)
+
+// Redacted returns the settings by field name, with the secrets replaced and without the passwords of the
+// URLs. Durations are formatted like "1m30s", the rules loaded from files are only reported as loaded
+func (s *Settings) Redacted() map[string]interface{} {
+	m := make(map[string]interface{})
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		value := v.Field(i).Interface()
+		switch {
+		case secretSettings[name]:
+			if value != "" {
+				value = redactedValue
+			}
+		case secretMapSettings[name]:
+			if secrets, ok := value.(map[string]string); ok && secrets != nil {
+				names := make(map[string]string, len(secrets))
+				for k := range secrets {
+					names[k] = redactedValue
+				}
+				value = names
+			}
+		default:
+			value = redactedSetting(value)
+		}
+		m[name] = value
+	}
+	return m
+}
+
+// redactedSetting returns the JSON friendly form of the value of a setting
+func redactedSetting(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case os.FileMode:
+		return fmt.Sprintf("%#o", uint32(v))
+	case *url.URL:
+		if v == nil {
+			return nil
+		}
+		return v.Redacted()
+	case []*url.URL:
+		urls := make([]string, len(v))
+		for i, u := range v {
+			urls[i] = u.Redacted()
+		}
+		return urls
+	case map[string]*url.URL:
+		urls := make(map[string]string, len(v))
+		for k, u := range v {
+			urls[k] = u.Redacted()
+		}
+		return urls
+	case *processor.ClaimMapping:
+		return v != nil
+	case *processor.RealmRules:
+		return v != nil
+	case *processor.ScopePolicy:
+		return v != nil
+	case *processor.TokenRoutes:
+		return v != nil
+	case map[string]processor.JwtProcessor:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	default:
+		return v
+	}
+}
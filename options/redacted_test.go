@@ -0,0 +1,64 @@
+package options
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedacted(t *testing.T) {
+	redis, _ := url.Parse("redis://:password@localhost:6379/0")
+	s := defaultSettings()
+	s.AdminUsers = map[string]string{"admin": "secret"}
+	s.UpstreamHeaders = map[string]string{"X-Api-Key": "secret"}
+	s.VaultToken = "secret"
+	s.TokenHashSalt = "secret"
+	s.SelftestToken = "secret"
+	s.KafkaSASLPassword = "secret"
+	s.WebhookSecret = "secret"
+	s.UpstreamCacheRedisURL = redis
+	s.UnixSocketMode = 0660
+
+	m := s.Redacted()
+	for name, want := range map[string]interface{}{
+		"AdminUsers":            map[string]string{"admin": redactedValue},
+		"UpstreamHeaders":       map[string]string{"X-Api-Key": redactedValue},
+		"CallerAPIKeys":         map[string]string(nil),
+		"VaultToken":            redactedValue,
+		"VaultAuthSecretID":     "",
+		"TokenHashSalt":         redactedValue,
+		"HashingSalt":           redactedValue,
+		"SelftestToken":         redactedValue,
+		"KafkaSASLPassword":     redactedValue,
+		"WebhookSecret":         redactedValue,
+		"UpstreamCacheRedisURL": "redis://:xxxxx@localhost:6379/0",
+		"UpstreamTokenInfoURL":  nil,
+		"UpstreamTimeout":       defaultUpstreamTimeout.String(),
+		"UnixSocketMode":        "0660",
+		"ClaimMapping":          false,
+		"JwtProcessors":         []string{},
+		"ListenAddress":         defaultListenAddress,
+		"UpstreamRetryBudget":   defaultUpstreamRetryBudget,
+	} {
+		if !reflect.DeepEqual(m[name], want) {
+			t.Errorf("Wrong value of %s. Wanted %#v, got %#v", name, want, m[name])
+		}
+	}
+	if len(m) != reflect.TypeOf(Settings{}).NumField() {
+		t.Errorf("Every setting should be dumped, got %d", len(m))
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal("Failed to encode the settings: ", err)
+	}
+	if strings.Contains(string(b), "secret") || strings.Contains(string(b), "password") {
+		t.Errorf("Secrets in the dump: %s", b)
+	}
+	if s.VaultToken != "secret" || s.AdminUsers["admin"] != "secret" || s.UpstreamTimeout != time.Second {
+		t.Error("The settings should not be changed")
+	}
+}
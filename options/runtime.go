@@ -0,0 +1,187 @@
+package options
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zalando/planb-tokeninfo/logging"
+)
+
+// changeMutex serializes the reloads and the changes of the settings at runtime
+var changeMutex sync.Mutex
+
+// runtimeSettings are the options that can be changed at runtime, with a function that sets their value
+var runtimeSettings = map[string]func(s *Settings, value string) error{
+	"UPSTREAM_CACHE_TTL": func(s *Settings, value string) error {
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		if d > 0 && s.UpstreamStreaming {
+			return fmt.Errorf("must be 0 with UPSTREAM_STREAMING")
+		}
+		s.UpstreamCacheTTL = d
+		return nil
+	},
+	"UPSTREAM_TIMEOUT": func(s *Settings, value string) error {
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		if s.UpstreamSoftTimeout > 0 && d > 0 && s.UpstreamSoftTimeout >= d {
+			return fmt.Errorf("must be longer than UPSTREAM_SOFT_TIMEOUT")
+		}
+		s.UpstreamTimeout = d
+		return nil
+	},
+	"UPSTREAM_RETRIES": func(s *Settings, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("is not an integer")
+		}
+		if i < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+		s.UpstreamRetries = i
+		return nil
+	},
+	"LOG_LEVEL": func(s *Settings, value string) error {
+		l, err := logging.ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("is not debug, info, warn or error")
+		}
+		s.LogLevel = l
+		return nil
+	},
+	"CHAOS_LATENCY": func(s *Settings, value string) error {
+		if !s.ChaosMode {
+			return errChaosMode
+		}
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		s.ChaosLatency = d
+		return nil
+	},
+	"CHAOS_LATENCY_PERCENTAGE": chaosPercentage(func(s *Settings) *int { return &s.ChaosLatencyPercentage }),
+	"CHAOS_ERROR_PERCENTAGE":   chaosPercentage(func(s *Settings) *int { return &s.ChaosErrorPercentage }),
+	"CHAOS_ERROR_STATUS": func(s *Settings, value string) error {
+		if !s.ChaosMode {
+			return errChaosMode
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 400 || i > 599 {
+			return fmt.Errorf("is not a 4xx or 5xx status")
+		}
+		s.ChaosErrorStatus = i
+		return nil
+	},
+	"CHAOS_MALFORMED_PERCENTAGE": chaosPercentage(func(s *Settings) *int { return &s.ChaosMalformedPercentage }),
+}
+
+// errChaosMode rejects the changes of the fault injection settings while CHAOS_MODE is off. It can't be
+// turned on at runtime
+var errChaosMode = fmt.Errorf("requires CHAOS_MODE")
+
+// chaosPercentage returns the function that sets the percentage of injected faults returned by field
+func chaosPercentage(field func(s *Settings) *int) func(s *Settings, value string) error {
+	return func(s *Settings, value string) error {
+		if !s.ChaosMode {
+			return errChaosMode
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i > 100 {
+			return fmt.Errorf("is not a percentage between 0 and 100")
+		}
+		*field(s) = i
+		return nil
+	}
+}
+
+// Change replaces the current settings with a copy that has the new values of the options by name, for ex.
+// {"UPSTREAM_CACHE_TTL": "10m"}, and calls the reload hooks with it. It returns the previous values of the
+// changed settings. If an option can't be changed at runtime or a value is invalid, the current settings
+// stay in place and a *ConfigError is returned
+func Change(values map[string]string) (map[string]interface{}, error) {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+
+	current := Current()
+	changed := *current
+	var invalidValues []string
+	for _, name := range sortedKeys(values) {
+		set, ok := runtimeSettings[name]
+		if !ok {
+			invalidValues = append(invalidValues, fmt.Sprintf("%s: can't be changed at runtime", name))
+			continue
+		}
+		if err := set(&changed, values[name]); err != nil {
+			invalidValues = append(invalidValues, fmt.Sprintf("%s: %q %v", name, values[name], err))
+		}
+	}
+	if len(invalidValues) > 0 {
+		return nil, &ConfigError{Problems: invalidValues}
+	}
+
+	previous := make(map[string]interface{}, len(values))
+	for name := range values {
+		previous[name] = runtimeValue(current, name)
+	}
+	published.Store(&changed)
+	for _, f := range reloadHooks {
+		f(&changed)
+	}
+	return previous, nil
+}
+
+// runtimeValue returns the JSON friendly value of one of the runtimeSettings
+func runtimeValue(s *Settings, name string) interface{} {
+	switch name {
+	case "UPSTREAM_CACHE_TTL":
+		return s.UpstreamCacheTTL.String()
+	case "UPSTREAM_TIMEOUT":
+		return s.UpstreamTimeout.String()
+	case "UPSTREAM_RETRIES":
+		return s.UpstreamRetries
+	case "LOG_LEVEL":
+		return logging.LevelName(s.LogLevel)
+	case "CHAOS_LATENCY":
+		return s.ChaosLatency.String()
+	case "CHAOS_LATENCY_PERCENTAGE":
+		return s.ChaosLatencyPercentage
+	case "CHAOS_ERROR_PERCENTAGE":
+		return s.ChaosErrorPercentage
+	case "CHAOS_ERROR_STATUS":
+		return s.ChaosErrorStatus
+	case "CHAOS_MALFORMED_PERCENTAGE":
+		return s.ChaosMalformedPercentage
+	}
+	return nil
+}
+
+// RuntimeValues returns the current values of the options that can be changed at runtime, by name. The
+// fault injection settings are only there with CHAOS_MODE
+func RuntimeValues() map[string]interface{} {
+	values := make(map[string]interface{}, len(runtimeSettings))
+	current := Current()
+	for name := range runtimeSettings {
+		if strings.HasPrefix(name, "CHAOS_") && !current.ChaosMode {
+			continue
+		}
+		values[name] = runtimeValue(current, name)
+	}
+	return values
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
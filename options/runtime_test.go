@@ -0,0 +1,88 @@
+package options
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChange(t *testing.T) {
+	defer func(hooks []func(*Settings)) { reloadHooks = hooks }(reloadHooks)
+	defer Replace(Replace(defaultSettings()))
+	Current().UpstreamSoftTimeout = 500 * time.Millisecond
+	var reloaded *Settings
+	reloadHooks = []func(*Settings){func(s *Settings) { reloaded = s }}
+
+	previous, err := Change(map[string]string{"UPSTREAM_CACHE_TTL": "5m", "UPSTREAM_TIMEOUT": "3", "UPSTREAM_RETRIES": "2"})
+	if err != nil {
+		t.Fatal("Failed to change the settings: ", err)
+	}
+	want := map[string]interface{}{"UPSTREAM_CACHE_TTL": defaultUpstreamCacheTTL.String(), "UPSTREAM_TIMEOUT": defaultUpstreamTimeout.String(), "UPSTREAM_RETRIES": 0}
+	if !reflect.DeepEqual(previous, want) {
+		t.Errorf("Wrong previous values. Wanted %v, got %v", want, previous)
+	}
+	if reloaded != Current() || Current().UpstreamCacheTTL != 5*time.Minute || Current().UpstreamTimeout != 3*time.Second || Current().UpstreamRetries != 2 {
+		t.Errorf("The changed settings should be applied: %+v", Current())
+	}
+
+	if _, err := Change(map[string]string{"LOG_LEVEL": "debug"}); err != nil || Current().LogLevel != slog.LevelDebug {
+		t.Errorf("The log level should be changed: %v", err)
+	}
+
+	for _, values := range []map[string]string{
+		{"UPSTREAM_CACHE_TTL": "1h", "UPSTREAM_TIMEOUT": "100ms"},
+		{"UPSTREAM_RETRIES": "many"},
+		{"UPSTREAM_CACHE_TTL": "-1m"},
+		{"LISTEN_ADDRESS": ":8080"},
+		{"LOG_LEVEL": "verbose"},
+	} {
+		current := Current()
+		if _, err := Change(values); err == nil {
+			t.Errorf("Changing %v should fail", values)
+		}
+		if Current() != current {
+			t.Errorf("The settings should not change after %v failed", values)
+		}
+	}
+
+	if _, err := Change(map[string]string{"UPSTREAM_TIMEOUT": "0"}); err != nil || Current().UpstreamTimeout != 0 {
+		t.Errorf("UPSTREAM_TIMEOUT 0 should be allowed with UPSTREAM_SOFT_TIMEOUT: %v", err)
+	}
+}
+
+func TestChangeChaos(t *testing.T) {
+	defer func(hooks []func(*Settings)) { reloadHooks = hooks }(reloadHooks)
+	defer Replace(Replace(defaultSettings()))
+	reloadHooks = nil
+
+	if _, err := Change(map[string]string{"CHAOS_ERROR_PERCENTAGE": "10"}); err == nil {
+		t.Error("The faults should not be changed without CHAOS_MODE")
+	}
+	if _, ok := RuntimeValues()["CHAOS_ERROR_PERCENTAGE"]; ok {
+		t.Error("The fault injection settings should be hidden without CHAOS_MODE")
+	}
+
+	Current().ChaosMode = true
+	if _, err := Change(map[string]string{"CHAOS_LATENCY": "2s", "CHAOS_LATENCY_PERCENTAGE": "50", "CHAOS_ERROR_PERCENTAGE": "10",
+		"CHAOS_ERROR_STATUS": "500", "CHAOS_MALFORMED_PERCENTAGE": "5"}); err != nil {
+		t.Fatal("Failed to change the faults: ", err)
+	}
+	s := Current()
+	if s.ChaosLatency != 2*time.Second || s.ChaosLatencyPercentage != 50 || s.ChaosErrorPercentage != 10 || s.ChaosErrorStatus != 500 || s.ChaosMalformedPercentage != 5 {
+		t.Errorf("The changed faults should be applied: %+v", s)
+	}
+	if v := RuntimeValues()["CHAOS_LATENCY"]; v != "2s" {
+		t.Errorf("Wrong runtime value of CHAOS_LATENCY: %v", v)
+	}
+	for _, values := range []map[string]string{
+		{"CHAOS_ERROR_PERCENTAGE": "101"},
+		{"CHAOS_MALFORMED_PERCENTAGE": "-1"},
+		{"CHAOS_ERROR_STATUS": "200"},
+		{"CHAOS_LATENCY": "soon"},
+	} {
+		if _, err := Change(values); err == nil {
+			t.Errorf("Changing %v should fail", values)
+		}
+	}
+}
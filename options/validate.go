@@ -0,0 +1,44 @@
+package options
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A ConfigError lists all the problems of the options
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Invalid configuration, %d problem(s):\n", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "  - %s\n", p)
+	}
+	return b.String()
+}
+
+// A validator collects the problems found while loading the options. They are reported together instead
+// of falling back to the defaults or stopping at the first one
+type validator struct {
+	problems []string
+}
+
+// invalid records a problem with the value s of the option v
+func (val *validator) invalid(v string, s string, reason string) {
+	val.problems = append(val.problems, fmt.Sprintf("%s: %q %s", v, s, reason))
+}
+
+// fail records an invalid combination of options
+func (val *validator) fail(format string, a ...interface{}) {
+	val.problems = append(val.problems, fmt.Sprintf(format, a...))
+}
+
+// err returns the problems in a ConfigError, or nil if there are none
+func (val *validator) err() error {
+	if len(val.problems) == 0 {
+		return nil
+	}
+	return &ConfigError{Problems: val.problems}
+}
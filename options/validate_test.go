@@ -0,0 +1,90 @@
+package options
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvalidValues(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		get   func(val *validator) interface{}
+		want  interface{}
+		valid bool
+	}{
+		{"7", func(val *validator) interface{} { return val.getInt("T1", 1) }, 7, true},
+		{"seven", func(val *validator) interface{} { return val.getInt("T1", 1) }, 1, false},
+		{"-7", func(val *validator) interface{} { return val.getInt("T1", 1) }, 1, false},
+		{"yes", func(val *validator) interface{} { return val.getBool("T1", true) }, true, false},
+		{"0.5", func(val *validator) interface{} { return val.getFloat("T1", 1) }, 0.5, true},
+		{"half", func(val *validator) interface{} { return val.getFloat("T1", 1) }, 1.0, false},
+		{"-0.5", func(val *validator) interface{} { return val.getFloat("T1", 1) }, 1.0, false},
+		{"5m", func(val *validator) interface{} { return val.getDuration("T1", time.Second) }, 5 * time.Minute, true},
+		{"5 minutes", func(val *validator) interface{} { return val.getDuration("T1", time.Second) }, time.Second, false},
+		{"-5m", func(val *validator) interface{} { return val.getDuration("T1", time.Second) }, time.Second, false},
+		{"-5", func(val *validator) interface{} { return val.getDuration("T1", time.Second) }, time.Second, false},
+	} {
+		os.Clearenv()
+		os.Setenv("T1", test.value)
+		val := new(validator)
+		if got := test.get(val); got != test.want {
+			t.Errorf("Wrong value for %q. Wanted %v, got %v", test.value, test.want, got)
+		}
+		if valid := val.err() == nil; valid != test.valid {
+			t.Errorf("Wrong problems for %q: %v", test.value, val.problems)
+		}
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("UPSTREAM_TOKENINFO_URL", "http://example.com")
+	os.Setenv("OPENID_PROVIDER_CONFIGURATION_URL", "http://example.com")
+	os.Setenv("REVOCATION_PROVIDER_URL", "http://example.com")
+	os.Setenv("UPSTREAM_TIMEOUT", "1 second")
+	os.Setenv("UPSTREAM_RETRIES", "-1")
+	os.Setenv("ACCESS_LOG_SAMPLE_RATE", "2")
+	os.Setenv("LOG_LEVEL", "verbose")
+	defer Replace(Replace(defaultSettings()))
+
+	err := LoadFromEnvironment()
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Wanted a ConfigError, got %v", err)
+	}
+	want := []string{
+		`ACCESS_LOG_SAMPLE_RATE: "2" must not be more than 1`,
+		`LOG_LEVEL: "verbose" is not debug, info, warn or error`,
+		`UPSTREAM_TIMEOUT: "1 second" is not a duration`,
+		`UPSTREAM_RETRIES: "-1" must not be negative`,
+	}
+	if !reflect.DeepEqual(ce.Problems, want) {
+		t.Errorf("Wrong problems. Wanted %q, got %q", want, ce.Problems)
+	}
+	if !strings.HasPrefix(err.Error(), "Invalid configuration, 4 problem(s):\n  - ACCESS_LOG_SAMPLE_RATE") {
+		t.Errorf("Wrong report: %s", err)
+	}
+	if !reflect.DeepEqual(Current(), defaultSettings()) {
+		t.Error("Invalid options shouldn't replace the settings")
+	}
+
+	// the invalid combinations of options are reported with the invalid values
+	os.Setenv("STATIC_KEYS", "{}")
+	os.Setenv("STATIC_KEYS_FILE", "/etc/keys.json")
+	os.Setenv("UPSTREAM_H2C", "maybe")
+	os.Setenv("ADMIN_LISTEN_ADDRESS", ":9022")
+	err = LoadFromEnvironment()
+	want = []string{
+		`UPSTREAM_H2C: "maybe" is not a boolean`,
+		"STATIC_KEYS and STATIC_KEYS_FILE can't be used together",
+		"OPENID_PROVIDER_CONFIGURATION_URL can't be used together with static keys",
+		"ADMIN_USERS is required with ADMIN_LISTEN_ADDRESS",
+	}
+	if !errors.As(err, &ce) || !reflect.DeepEqual(ce.Problems[:4], want) {
+		t.Errorf("Wrong problems. Wanted %q first, got %v", want, err)
+	}
+}
@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// A ClaimMapping changes the Token Info response of JWT tokens. It is loaded from a JSON document like
+//
+//	{
+//	  "claims": {"tenant": "tenant_id", "roles": "https://example.com/roles", "groups": "realm_access.roles"},
+//	  "flatten": {"https://example.com/app_metadata": "app_"},
+//	  "rename": {"uid": "user_id"},
+//	  "drop": ["grant_type"],
+//	  "constants": {"source": "planb"}
+//	}
+//
+// Claims maps response fields to the JWT claims they are copied from, Flatten maps claim objects to the
+// prefix of the response fields their members are copied to, Rename maps existing response fields to their
+// new names, Drop lists the response fields to remove and Constants are added to every response.
+//
+// Claims are named by their path: a claim of the token, like the namespaced "https://example.com/roles", or
+// the dot separated path of a member of nested claim objects, like "realm_access.roles"
+type ClaimMapping struct {
+	Claims    map[string]string      `json:"claims"`
+	Flatten   map[string]string      `json:"flatten"`
+	Rename    map[string]string      `json:"rename"`
+	Drop      []string               `json:"drop"`
+	Constants map[string]interface{} `json:"constants"`
+}
+
+// LoadClaimMapping decodes a ClaimMapping from r. Unknown attributes are rejected, to catch typos
+func LoadClaimMapping(r io.Reader) (*ClaimMapping, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	cm := new(ClaimMapping)
+	if err := d.Decode(cm); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// Apply changes the response m of a token with the given claims. The claim objects are flattened first,
+// then the claims are copied, so they take precedence, the fields are renamed and dropped and finally the
+// constants are set. Claims missing in the token, and flattened claims that are not objects, are skipped.
+// A nil ClaimMapping leaves the response unchanged
+func (cm *ClaimMapping) Apply(claims jwt.MapClaims, m map[string]interface{}) {
+	if cm == nil {
+		return
+	}
+	for claim, prefix := range cm.Flatten {
+		v, _ := lookupClaim(claims, claim)
+		if obj, ok := v.(map[string]interface{}); ok {
+			for k, v := range obj {
+				m[prefix+k] = v
+			}
+		}
+	}
+	for field, claim := range cm.Claims {
+		if v, has := lookupClaim(claims, claim); has {
+			m[field] = v
+		}
+	}
+	for from, to := range cm.Rename {
+		if v, has := m[from]; has {
+			delete(m, from)
+			m[to] = v
+		}
+	}
+	for _, field := range cm.Drop {
+		delete(m, field)
+	}
+	for field, v := range cm.Constants {
+		m[field] = v
+	}
+}
+
+// lookupClaim returns the claim at path. A claim named like the whole path wins, otherwise the path is split
+// at a dot into the name of a claim object and the path of its member, preferring the longest name, so that
+// namespaced claims with dots in their name can be nested too
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	if v, has := claims[path]; has {
+		return v, true
+	}
+	for i := strings.LastIndexByte(path, '.'); i > 0; i = strings.LastIndexByte(path[:i], '.') {
+		if obj, ok := claims[path[:i]].(map[string]interface{}); ok {
+			if v, has := lookupClaim(obj, path[i+1:]); has {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
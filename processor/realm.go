@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrMissingRealmRuleClaim is returned when a realm rule doesn't name the claim it applies to
+var ErrMissingRealmRuleClaim = errors.New("Missing claim in realm rule")
+
+// A RealmRule selects the realm of a token from the string value of Claim. When Match is not empty the
+// value must match the regular expression. The realm is Realm when not empty, or the claim value itself
+type RealmRule struct {
+	Claim string `json:"claim"`
+	Match string `json:"match"`
+	Realm string `json:"realm"`
+
+	re *regexp.Regexp
+}
+
+// RealmRules determine the realm of tokens for identity providers with different conventions. They are
+// loaded from a JSON document like
+//
+//	{
+//	  "rules": [
+//	    {"claim": "sub", "match": "^stups_", "realm": "/services"},
+//	    {"claim": "https://identity.example.com/managed-id", "realm": "/employees"},
+//	    {"claim": "realm"}
+//	  ],
+//	  "default": "/customers"
+//	}
+//
+// The first matching rule wins. Default is used when no rule matches
+type RealmRules struct {
+	Rules   []*RealmRule `json:"rules"`
+	Default string       `json:"default"`
+}
+
+// LoadRealmRules decodes RealmRules from r and compiles their regular expressions. Unknown attributes
+// are rejected, to catch typos
+func LoadRealmRules(r io.Reader) (*RealmRules, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	rr := new(RealmRules)
+	if err := d.Decode(rr); err != nil {
+		return nil, err
+	}
+	for i, rule := range rr.Rules {
+		if rule.Claim == "" {
+			return nil, fmt.Errorf("rule %d: %v", i, ErrMissingRealmRuleClaim)
+		}
+		if rule.Match != "" {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i, err)
+			}
+			rule.re = re
+		}
+	}
+	return rr, nil
+}
+
+// Realm returns the realm for a token with the given claims and false if neither a rule matches nor
+// a default realm is set
+func (rr *RealmRules) Realm(claims jwt.MapClaims) (string, bool) {
+	for _, rule := range rr.Rules {
+		v, ok := claims[rule.Claim].(string)
+		if !ok || (rule.re != nil && !rule.re.MatchString(v)) {
+			continue
+		}
+		if rule.Realm != "" {
+			return rule.Realm, true
+		}
+		return v, true
+	}
+	return rr.Default, rr.Default != ""
+}
@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Targets of the token routes besides upstream URLs
+const (
+	RouteJWT      = "jwt"
+	RouteUpstream = "upstream"
+	RouteReject   = "reject"
+)
+
+// ErrInvalidRouteTarget is returned when the target of a token route is neither one of the Route*
+// constants nor an absolute http(s) URL
+var ErrInvalidRouteTarget = errors.New("Invalid token route target")
+
+// A TokenRoute sends the tokens that match all of its conditions to Target. Prefix is the start of the
+// token, Match a regular expression, MinLength and MaxLength limit the length of the token and Dots is
+// the number of dots in the token. Empty conditions match any token
+type TokenRoute struct {
+	Prefix    string `json:"prefix"`
+	Match     string `json:"match"`
+	MinLength int    `json:"min_length"`
+	MaxLength int    `json:"max_length"`
+	Dots      *int   `json:"dots"`
+	Target    string `json:"target"`
+
+	re *regexp.Regexp
+	// upstream is the parsed Target if it is a URL
+	upstream *url.URL
+}
+
+// TokenRoutes decide how tokens are validated instead of the default, which validates tokens with three
+// parts as JWT and sends all others to the upstream token info. They are loaded from a JSON document like
+//
+//	{
+//	  "routes": [
+//	    {"prefix": "legacy.", "target": "https://legacy-tokeninfo.example.org/oauth2/tokeninfo"},
+//	    {"match": "^[0-9a-f]{8}-[0-9a-f]{4}-", "target": "upstream"},
+//	    {"dots": 2, "max_length": 64, "target": "upstream"},
+//	    {"dots": 2, "target": "jwt"},
+//	    {"max_length": 8, "target": "reject"}
+//	  ]
+//	}
+//
+// Targets are "jwt" for local validation, "upstream" for the upstream token info, "reject" to reject the
+// token without validating it, or the URL of another upstream token info. The first matching route wins,
+// tokens without a matching route get the default
+type TokenRoutes struct {
+	Routes []*TokenRoute `json:"routes"`
+}
+
+// LoadTokenRoutes decodes TokenRoutes from r and compiles their regular expressions. Unknown attributes
+// are rejected, to catch typos
+func LoadTokenRoutes(r io.Reader) (*TokenRoutes, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	tr := new(TokenRoutes)
+	if err := d.Decode(tr); err != nil {
+		return nil, err
+	}
+	for i, route := range tr.Routes {
+		switch route.Target {
+		case RouteJWT, RouteUpstream, RouteReject:
+		default:
+			u, err := url.Parse(route.Target)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("route %d: %v %q", i, ErrInvalidRouteTarget, route.Target)
+			}
+			route.upstream = u
+		}
+		if route.Match != "" {
+			re, err := regexp.Compile(route.Match)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %v", i, err)
+			}
+			route.re = re
+		}
+	}
+	return tr, nil
+}
+
+// Route returns the first route that matches token, or nil
+func (tr *TokenRoutes) Route(token string) *TokenRoute {
+	for _, route := range tr.Routes {
+		if route.matches(token) {
+			return route
+		}
+	}
+	return nil
+}
+
+// Upstreams returns the URLs of the routes to other upstreams by their target
+func (tr *TokenRoutes) Upstreams() map[string]*url.URL {
+	urls := make(map[string]*url.URL)
+	for _, route := range tr.Routes {
+		if route.upstream != nil {
+			urls[route.Target] = route.upstream
+		}
+	}
+	return urls
+}
+
+func (route *TokenRoute) matches(token string) bool {
+	switch {
+	case !strings.HasPrefix(token, route.Prefix):
+		return false
+	case route.MinLength > 0 && len(token) < route.MinLength:
+		return false
+	case route.MaxLength > 0 && len(token) > route.MaxLength:
+		return false
+	case route.Dots != nil && strings.Count(token, ".") != *route.Dots:
+		return false
+	case route.re != nil && !route.re.MatchString(token):
+		return false
+	}
+	return true
+}
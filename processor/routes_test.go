@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadTokenRoutes(t *testing.T) {
+	for _, test := range []struct {
+		doc     string
+		wantErr bool
+	}{
+		{`{"routes": [{"prefix": "legacy.", "target": "https://legacy.example.org"}, {"dots": 2, "target": "jwt"}]}`, false},
+		{`{"routes": []}`, false},
+		{`{"routes": [{"target": "foo"}]}`, true},
+		{`{"routes": [{"target": "ftp://example.org"}]}`, true},
+		{`{"routes": [{"target": ""}]}`, true},
+		{`{"routes": [{"match": "(", "target": "jwt"}]}`, true},
+		{`{"routes": [{"suffix": "x", "target": "jwt"}]}`, true},
+		{`[]`, true},
+	} {
+		if _, err := LoadTokenRoutes(strings.NewReader(test.doc)); test.wantErr != (err != nil) {
+			t.Errorf("Unexpected error status for %s: %v", test.doc, err)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	tr, err := LoadTokenRoutes(strings.NewReader(`{
+		"routes": [
+			{"prefix": "legacy.", "target": "https://legacy.example.org/oauth2/tokeninfo"},
+			{"match": "^[0-9a-f]{8}-", "target": "upstream"},
+			{"dots": 2, "max_length": 20, "target": "upstream"},
+			{"dots": 2, "target": "jwt"},
+			{"min_length": 3, "max_length": 8, "target": "reject"},
+			{"prefix": "legacy.", "target": "https://legacy.example.org/oauth2/tokeninfo"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := tr.Upstreams(); len(u) != 1 || u["https://legacy.example.org/oauth2/tokeninfo"].Host != "legacy.example.org" {
+		t.Errorf("Wrong upstreams of the routes: %v", u)
+	}
+	for _, test := range []struct {
+		token string
+		want  string
+	}{
+		{"legacy.a.b", "https://legacy.example.org/oauth2/tokeninfo"},
+		{"0123abcd-foo", "upstream"},
+		{"a.b.c", "upstream"},
+		{"header.payload.signature", "jwt"},
+		{"abcd", "reject"},
+		{"ab", ""},
+		{"opaque-token-without-route", ""},
+	} {
+		var got string
+		if r := tr.Route(test.token); r != nil {
+			got = r.Target
+		}
+		if got != test.want {
+			t.Errorf("Wrong route for %q. Wanted %q, got %q", test.token, test.want, got)
+		}
+	}
+}
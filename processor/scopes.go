@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrInvalidCallerPolicy is returned for caller policies that are empty or have callers themselves
+var ErrInvalidCallerPolicy = errors.New("Invalid caller scope policy")
+
+// A ScopePolicy changes the scopes returned in Token Info responses. It is loaded from a JSON document like
+//
+//	{
+//	  "aliases": {"admin": ["read", "write", "delete"]},
+//	  "realms": {"/services": ["service"]},
+//	  "allow": ["uid", "read", "write", "service"],
+//	  "callers": {"billing": {"allow": ["uid", "read"]}}
+//	}
+//
+// Aliases are replaced by the scopes they stand for, Realms adds scopes to every token of a realm and,
+// when not empty, Allow is the list of the only scopes that are returned. Callers have their own policies,
+// which replace this one for their requests
+type ScopePolicy struct {
+	Aliases map[string][]string     `json:"aliases"`
+	Realms  map[string][]string     `json:"realms"`
+	Allow   []string                `json:"allow"`
+	Callers map[string]*ScopePolicy `json:"callers"`
+
+	allowed map[string]bool
+}
+
+// LoadScopePolicy decodes a ScopePolicy from r. Unknown attributes are rejected, to catch typos
+func LoadScopePolicy(r io.Reader) (*ScopePolicy, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	p := new(ScopePolicy)
+	if err := d.Decode(p); err != nil {
+		return nil, err
+	}
+	p.init()
+	for caller, c := range p.Callers {
+		if c == nil || len(c.Callers) > 0 {
+			return nil, fmt.Errorf("caller %q: %v", caller, ErrInvalidCallerPolicy)
+		}
+		c.init()
+	}
+	return p, nil
+}
+
+func (p *ScopePolicy) init() {
+	if len(p.Allow) > 0 {
+		p.allowed = make(map[string]bool, len(p.Allow))
+		for _, s := range p.Allow {
+			p.allowed[s] = true
+		}
+	}
+}
+
+// ForCaller returns the policy for the requests of the caller and the name of its variant of the responses,
+// which is the caller for callers with their own policy and empty otherwise. A nil ScopePolicy returns nil
+func (p *ScopePolicy) ForCaller(caller string) (*ScopePolicy, string) {
+	if p == nil {
+		return nil, ""
+	}
+	if c, has := p.Callers[caller]; has && caller != "" {
+		return c, caller
+	}
+	return p, ""
+}
+
+// Variants returns the names of the variants of the responses besides the default one, in order
+func (p *ScopePolicy) Variants() []string {
+	if p == nil {
+		return nil
+	}
+	variants := make([]string, 0, len(p.Callers))
+	for caller := range p.Callers {
+		variants = append(variants, caller)
+	}
+	sort.Strings(variants)
+	return variants
+}
+
+// Apply returns the scopes of a token of the given realm after expanding the aliases, adding the scopes
+// of the realm and dropping the scopes that are not allowed. Duplicates are removed and the order is kept.
+// A nil ScopePolicy returns the scopes unchanged
+func (p *ScopePolicy) Apply(scopes []string, realm string) []string {
+	if p == nil {
+		return scopes
+	}
+	result := make([]string, 0, len(scopes))
+	seen := make(map[string]bool)
+	add := func(s string) {
+		if seen[s] || (p.allowed != nil && !p.allowed[s]) {
+			return
+		}
+		seen[s] = true
+		result = append(result, s)
+	}
+	for _, s := range scopes {
+		if expanded, ok := p.Aliases[s]; ok {
+			for _, e := range expanded {
+				add(e)
+			}
+		} else {
+			add(s)
+		}
+	}
+	for _, s := range p.Realms[realm] {
+		add(s)
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+// Package requestid gives every request an ID to correlate the client side of a call with the logs. The ID
+// of the X-Request-Id header is kept if it is valid, otherwise a new one is generated. It is sent back in
+// the response and forwarded to the upstream calls made for the request
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// Header is the name of the request and response header with the ID
+const Header = "X-Request-Id"
+
+// maxLength limits the size of the IDs taken from clients, they are written to the logs as they are
+const maxLength = 128
+
+type contextKey struct{}
+
+type handler struct {
+	next http.Handler
+}
+
+// NewHandler returns an http.Handler that sets the ID of the Request in the X-Request-Id header of both the
+// Request and the response before calling next. The ID is also in the Request context, see FromContext
+func NewHandler(next http.Handler) http.Handler {
+	return &handler{next: next}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(Header)
+	if !valid(id) {
+		id = generate()
+		r.Header.Set(Header, id)
+	}
+	w.Header().Set(Header, id)
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+}
+
+// FromContext returns the request ID in ctx, or an empty string if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Inject writes the request ID of ctx, if any, to the headers of an outgoing request
+func Inject(ctx context.Context, h http.Header) {
+	if id := FromContext(ctx); id != "" {
+		h.Set(Header, id)
+	}
+}
+
+// valid accepts IDs of printable ASCII characters without spaces, so that they can't break the log lines
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// generate returns 16 random bytes, hex encoded
+func generate() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,63 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var gotHeader, gotContext string
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get(Header)
+		gotContext = FromContext(r.Context())
+	}))
+
+	for _, test := range []struct {
+		id       string
+		generate bool
+	}{
+		{"", true},
+		{"abc-123", false},
+		{"foo bar", true},
+		{"foo\nbar", true},
+		{"é", true},
+		{strings.Repeat("a", 128), false},
+		{strings.Repeat("a", 129), true},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+		if test.id != "" {
+			r.Header.Set(Header, test.id)
+		}
+		h.ServeHTTP(rw, r)
+
+		id := rw.Header().Get(Header)
+		if test.generate && (id == test.id || len(id) != 32) {
+			t.Errorf("Wanted a new ID for %q, got %q", test.id, id)
+		}
+		if !test.generate && id != test.id {
+			t.Errorf("Wrong ID. Wanted %q, got %q", test.id, id)
+		}
+		if gotHeader != id || gotContext != id {
+			t.Errorf("Request should have the ID %q, got header %q and context %q", id, gotHeader, gotContext)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	h := make(http.Header)
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	Inject(r.Context(), h)
+	if _, has := h[Header]; has {
+		t.Error("Header should not be set without an ID")
+	}
+
+	NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Inject(r.Context(), h)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if len(h.Get(Header)) != 32 {
+		t.Errorf("Wrong injected ID %q", h.Get(Header))
+	}
+}
@@ -0,0 +1,63 @@
+package revoke
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// Sizing of the revocation filter. Keys that pass the filter without being revoked cost a lookup in the cache
+const (
+	filterFalsePositiveRate = 0.01
+	minFilterCapacity       = 1 << 16
+)
+
+// bloomFilter tells whether a key may have been added, without false negatives. It's in front of the
+// revocation cache, so that the tokens that were never revoked don't wait for the cache. Keys are added by
+// the cache goroutine while the requests check them, so the bits are read and written atomically
+type bloomFilter struct {
+	bits     []uint64
+	hashes   uint64
+	capacity int
+}
+
+// newBloomFilter returns a filter for capacity keys with filterFalsePositiveRate. More keys can be added, at
+// the cost of more false positives
+func newBloomFilter(capacity int) *bloomFilter {
+	if capacity < minFilterCapacity {
+		capacity = minFilterCapacity
+	}
+	m := math.Ceil(-float64(capacity) * math.Log(filterFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(capacity)*math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (uint64(m)+63)/64), hashes: uint64(k), capacity: capacity}
+}
+
+func (f *bloomFilter) add(key string) {
+	h1, h2 := filterHashes(key)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		atomic.OrUint64(&f.bits[bit/64], 1<<(bit%64))
+	}
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	h1, h2 := filterHashes(key)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		if atomic.LoadUint64(&f.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterHashes returns the two hashes of key that are combined into the hashes of the filter
+func filterHashes(key string) (uint64, uint64) {
+	a, b := fnv.New64a(), fnv.New64()
+	a.Write([]byte(key))
+	b.Write([]byte(key))
+	// odd, so that the combined hashes don't repeat
+	return a.Sum64(), b.Sum64() | 1
+}
@@ -0,0 +1,28 @@
+package revoke
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(10000)
+	for i := 0; i < 10000; i++ {
+		f.add("revoked" + strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !f.mayContain("revoked" + strconv.Itoa(i)) {
+			t.Fatalf("Filter is missing the added key revoked%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 100000; i++ {
+		if f.mayContain("valid" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	// the filter is sized for minFilterCapacity keys, so the rate is far below filterFalsePositiveRate
+	if falsePositives > 100000*filterFalsePositiveRate {
+		t.Errorf("Too many false positives: %d of 100000", falsePositives)
+	}
+}
@@ -1,7 +1,8 @@
 package revoke
 
 import (
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/zalando/planb-tokeninfo/options"
@@ -16,6 +17,8 @@ type Cache struct {
 	ts           chan *request // timestamp
 	cName        chan *request // claim names
 	forceRefresh chan int      // expire from timestamp
+	// *bloomFilter with the keys of all revocations, so that most keys without a revocation are not looked up
+	filter atomic.Value
 }
 
 // request structure holds key-value/result pairs that are transferred through the cache channels.
@@ -23,6 +26,8 @@ type request struct {
 	key string
 	val interface{}
 	res chan interface{}
+	// pushed revocations don't move the timestamp used to poll the Revocation Provider
+	pushed bool
 }
 
 func incrementClaimCount(r *request, n map[string]int) {
@@ -33,6 +38,13 @@ func incrementClaimCount(r *request, n map[string]int) {
 
 // Return a new revocation Cache instance.
 func NewCache() *Cache {
+	return NewCacheWithSnapshot("")
+}
+
+// NewCacheWithSnapshot returns a new revocation Cache that moves the TOKEN revocations to the snapshot file at path
+// once there are many of them, so that they don't use memory. The revocations of an existing snapshot are loaded, so
+// that only the later ones are polled from the Revocation Provider. Without path all revocations stay in memory.
+func NewCacheWithSnapshot(path string) *Cache {
 
 	get := make(chan *request)
 	set := make(chan *request)
@@ -41,26 +53,27 @@ func NewCache() *Cache {
 	ts := make(chan *request)
 	cName := make(chan *request)
 	forceRefresh := make(chan int)
+	cache := &Cache{get: get, set: set, del: del, expire: expire, ts: ts, cName: cName, forceRefresh: forceRefresh}
+	s := newStore(path, cache.filter.Store)
 
 	go func() {
-		c := make(map[string]interface{}) // store revocations
-		n := make(map[string]int)         // store all claim names
-		t := 0                            // store last pull timestamp
+		c := s.c // store revocations
+		n := s.n // store all claim names
 
 		for {
 			select {
 			case r := <-set:
-				if r.val.(*Revocation).Type == REVOCATION_TYPE_FORCEREFRESH ||
-					r.val.(*Revocation).Data["revoked_at"].(int) > t {
-					t = r.val.(*Revocation).Data["revoked_at"].(int)
+				if !r.pushed && (r.val.(*Revocation).Type == REVOCATION_TYPE_FORCEREFRESH ||
+					r.val.(*Revocation).Data["revoked_at"].(int) > s.t) {
+					s.t = r.val.(*Revocation).Data["revoked_at"].(int)
 				}
-				if value := c[r.key]; value != nil {
+				if value := s.get(r.key); value != nil {
 					if value.(*Revocation).Data["issued_before"].(int) < r.val.(*Revocation).Data["issued_before"].(int) {
-						c[r.key] = r.val
+						s.set(r.key, r.val)
 						incrementClaimCount(r, n)
 					}
 				} else {
-					c[r.key] = r.val
+					s.set(r.key, r.val)
 					incrementClaimCount(r, n)
 				}
 			case r := <-del:
@@ -69,20 +82,23 @@ func NewCache() *Cache {
 					n[rev.(*Revocation).Data["names"].(string)] -= 1
 					updateClaimNames(n)
 				}
-				delete(c, r.key)
+				s.delete(r.key)
 			case r := <-forceRefresh:
 				for key, rev := range c {
 					if key != REVOCATION_TYPE_FORCEREFRESH && rev.(*Revocation).Data["revoked_at"].(int) >= r {
 						if rev.(*Revocation).Type == REVOCATION_TYPE_CLAIM {
 							n[rev.(*Revocation).Data["names"].(string)] -= 1
 						}
-						delete(c, key)
+						s.delete(key)
 					}
 				}
 				updateClaimNames(n)
+				if s.snapshot != nil || s.compacting {
+					s.refresh(r)
+				}
 			case r := <-ts:
-				if t != 0 {
-					r.res <- t
+				if s.t != 0 {
+					r.res <- s.t
 				} else {
 					r.res <- nil
 				}
@@ -94,24 +110,34 @@ func NewCache() *Cache {
 						if rev.(*Revocation).Type == REVOCATION_TYPE_CLAIM {
 							n[rev.(*Revocation).Data["names"].(string)] -= 1
 						}
-						delete(c, key)
+						s.delete(key)
 					}
 				}
 				updateClaimNames(n)
+				s.maintain()
 			case r := <-get:
-				r.res <- c[r.key]
+				r.res <- s.get(r.key)
+			case done := <-s.done:
+				s.swap(done)
 			}
 		}
 	}()
 
-	return &Cache{get: get, set: set, del: del, expire: expire, ts: ts, cName: cName, forceRefresh: forceRefresh}
+	return cache
 }
 
 // Returns the value of a key in the revocation cache. nil if the key does not exist.
 func (c *Cache) Get(key string) interface{} {
+	if !c.filter.Load().(*bloomFilter).mayContain(key) {
+		return nil
+	}
 	res := make(chan interface{})
 	c.get <- &request{key: key, res: res}
-	return <-res
+	r := <-res
+	if r == nil {
+		incCounter("planb.tokeninfo.revocation.filter.false_positives")
+	}
+	return r
 }
 
 // Returns the latest revocation timestamp from the cache. i.e. get the last timestamp where a new revocation was found.
@@ -149,7 +175,7 @@ func (c *Cache) Expire() {
 // Delete all elements in the cache that were inserted after the given timestamp parameter.
 // Used in case incorrect data was received from the Revocation Provider.
 func (c *Cache) ForceRefresh(ts int) {
-	if ts < int(time.Now().Add(-1*options.AppSettings.RevocationCacheTTL).Unix()) {
+	if ts < int(time.Now().Add(-1*options.Current().RevocationCacheTTL).Unix()) {
 		return
 	}
 	c.forceRefresh <- ts
@@ -161,21 +187,31 @@ func (c *Cache) ForceRefresh(ts int) {
 // REVOCATION_TYPE_GLOBAL stores the key as 'GLOBAL' as there can only be one golbal revocation.
 // REVOCATION_TYPE_FORCEREFRESH stores the key as 'FORCEREFRESH as there can only be one force refresh.
 func (c *Cache) Add(rev *Revocation) {
+	c.add(rev, false)
+}
+
+// Push inserts a revocation like Add, without changing the latest revocation timestamp. Revocations pushed to
+// this service don't tell which revocations of the Revocation Provider were already seen.
+func (c *Cache) Push(rev *Revocation) {
+	c.add(rev, true)
+}
+
+func (c *Cache) add(rev *Revocation, pushed bool) {
 	var hash string
 	switch rev.Type {
 	case REVOCATION_TYPE_TOKEN:
 		if _, ok := rev.Data["token_hash"]; !ok {
-			log.Println("Error adding revocation to cache: missing token_hash.")
+			slog.Warn("Error adding revocation to cache: missing token_hash")
 			return
 		}
 		hash = rev.Data["token_hash"].(string)
 	case REVOCATION_TYPE_CLAIM:
 		if _, ok := rev.Data["names"]; !ok {
-			log.Println("Error adding revocation to cache: missing claim names.")
+			slog.Warn("Error adding revocation to cache: missing claim names")
 			return
 		}
 		if _, ok := rev.Data["value_hash"]; !ok {
-			log.Println("Error adding revocation to cache: missing claim values hash.")
+			slog.Warn("Error adding revocation to cache: missing claim values hash")
 			return
 		}
 		hash = rev.Data["value_hash"].(string)
@@ -184,10 +220,10 @@ func (c *Cache) Add(rev *Revocation) {
 	case REVOCATION_TYPE_FORCEREFRESH:
 		hash = REVOCATION_TYPE_FORCEREFRESH
 	default:
-		log.Printf("Error adding revocation to cache. Unknown revocation type: %s", rev.Type)
+		slog.Warn("Error adding revocation to cache: unknown revocation type", "type", rev.Type)
 		return
 	}
-	c.set <- &request{key: hash, val: rev}
+	c.set <- &request{key: hash, val: rev, pushed: pushed}
 }
 
 // Remove an element from the cache based on its key.
@@ -199,7 +235,7 @@ func (c *Cache) Delete(key string) {
 // REVOCATION_CACHE_TTL.
 func isExpired(ts int) bool {
 
-	if time.Unix(int64(ts), 0).Add(options.AppSettings.RevocationCacheTTL).Before(time.Now()) {
+	if time.Unix(int64(ts), 0).Add(options.Current().RevocationCacheTTL).Before(time.Now()) {
 		return true
 	}
 
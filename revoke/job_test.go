@@ -1,15 +1,16 @@
 package revoke
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestScheduling(t *testing.T) {
-	c := 0
-	Schedule(time.Second, func() { c++ })
+	var c atomic.Int32
+	Schedule(time.Second, func() { c.Add(1) })
 	time.Sleep(time.Second * 2)
-	if c == 0 {
+	if c.Load() == 0 {
 		t.Error("Job is not being executed.")
 	}
 }
@@ -2,7 +2,7 @@ package revoke
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 )
@@ -90,18 +90,18 @@ func (j *jsonRevocation) toRevocation() (*Revocation, error) {
 	switch j.Type {
 	case REVOCATION_TYPE_TOKEN:
 		if !j.validToken() {
-			log.Printf("Invalid revocation data (TOKEN). TokenHash: %s, RevokedAt: %d", j.Data.TokenHash, j.RevokedAt)
+			slog.Warn("Invalid revocation data (TOKEN)", "token_hash", j.Data.TokenHash, "revoked_at", j.RevokedAt)
 			return nil, ErrInvalidRevocation
 		}
 		r.Data["token_hash"] = j.Data.TokenHash
 
 	case REVOCATION_TYPE_CLAIM:
 		if !j.validClaim() {
-			log.Printf("Invalid revocation data (CLAIM). ValueHash: %s, IssuedBefore: %d, RevokedAt: %d", j.Data.ValueHash, j.Data.IssuedBefore, j.RevokedAt)
+			slog.Warn("Invalid revocation data (CLAIM)", "value_hash", j.Data.ValueHash, "issued_before", j.Data.IssuedBefore, "revoked_at", j.RevokedAt)
 			return nil, ErrInvalidRevocation
 		}
 		if len(j.Data.Names) == 0 {
-			log.Println("Invalid revocation data (missing claim names).")
+			slog.Warn("Invalid revocation data (missing claim names)")
 			return nil, ErrMissingClaimName
 		}
 		r.Data["value_hash"] = j.Data.ValueHash
@@ -109,16 +109,16 @@ func (j *jsonRevocation) toRevocation() (*Revocation, error) {
 
 	case REVOCATION_TYPE_GLOBAL:
 		if !j.validGlobal() {
-			log.Printf("Invalid revocation data (GLOBAL). IssuedBefore: %d, RevokedAt: %d", j.Data.IssuedBefore, j.RevokedAt)
+			slog.Warn("Invalid revocation data (GLOBAL)", "issued_before", j.Data.IssuedBefore, "revoked_at", j.RevokedAt)
 			return nil, ErrInvalidRevocation
 		}
 	default:
-		log.Printf("Unsupported revocation type: %s", j.Type)
+		slog.Warn("Unsupported revocation type", "type", j.Type)
 		return nil, ErrUnsupportedType
 	}
 
 	if t := int(time.Now().Unix()); j.Data.IssuedBefore > t {
-		log.Printf("Invalid revocation data. IssuedBefore cannot be in the future. Now: %d, IssuedBefore: %d", t, j.Data.IssuedBefore)
+		slog.Warn("Invalid revocation data. IssuedBefore cannot be in the future", "now", t, "issued_before", j.Data.IssuedBefore)
 		return nil, ErrIssuedInFuture
 	}
 
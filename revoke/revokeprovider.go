@@ -6,11 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -21,18 +22,33 @@ import (
 
 var scheduleFunc = Schedule
 
+// Sources of the revocations passed to the OnApply function.
+const (
+	SourceProvider = "provider" // polled from the Revocation Provider
+	SourcePush     = "push"     // pushed to this instance
+	SourcePeer     = "peer"     // pushed to another instance
+)
+
 // Caching provider holds the URL to the Revocation Provider and a reference to the revocation cache.
 // The URL is set with an environment variable: REVOCATION_PROVIDER_URL.
 type CachingRevokeProvider struct {
-	url   string
-	cache *Cache
+	url     string
+	cache   *Cache
+	onPush  func(data []byte)
+	onApply func(source string, revs []*Revocation)
+	// unix nanoseconds of the last successful refresh
+	lastRefresh int64
 }
 
 // Return a new CachingRevokeProvider and start polling the Revocation Provider based on a set interval.
-// Uses the environemnt variables: REVOCATION_PROVIDER_URL and REVOCATION_PROVIDER_REFRESH_INTERVAL.
+// Uses the environemnt variables: REVOCATION_PROVIDER_URL, REVOCATION_PROVIDER_REFRESH_INTERVAL and
+// REVOCATION_SNAPSHOT_FILE. Without a URL nothing is polled, only the pushed revocations apply.
 func NewCachingRevokeProvider(u *url.URL) *CachingRevokeProvider {
-	crp := &CachingRevokeProvider{url: u.String(), cache: NewCache()}
-	scheduleFunc(options.AppSettings.RevocationProviderRefreshInterval, crp.RefreshRevocations)
+	if u == nil {
+		return &CachingRevokeProvider{cache: NewCacheWithSnapshot(options.Current().RevocationSnapshotFile)}
+	}
+	crp := &CachingRevokeProvider{url: u.String(), cache: NewCacheWithSnapshot(options.Current().RevocationSnapshotFile)}
+	scheduleFunc(options.Current().RevocationProviderRefreshInterval, crp.RefreshRevocations)
 	return crp
 }
 
@@ -40,38 +56,45 @@ func NewCachingRevokeProvider(u *url.URL) *CachingRevokeProvider {
 // condition (e.g. refresh cache from a specific timestamp); expires revocations older than the
 // REVOCATION_CACHE_TTL envionment variable.
 func (crp *CachingRevokeProvider) RefreshRevocations() {
+	settings := options.Current()
 	ts := crp.cache.GetLastTS()
 	if ts == 0 {
-		ts = int(time.Now().Add(-1 * options.AppSettings.RevocationCacheTTL).Unix())
+		ts = int(time.Now().Add(-1 * settings.RevocationCacheTTL).Unix())
 	}
-	ts = ts - int(options.AppSettings.RevocationRefreshTolerance.Seconds())
+	ts = ts - int(settings.RevocationRefreshTolerance.Seconds())
 
-	log.Printf("Checking for new revocations since %d...", ts)
+	slog.Debug("Checking for new revocations", "since", ts)
 
 	resp, err := breaker.Get("refreshRevocations", crp.url+"?from="+strconv.Itoa(ts))
 	if err != nil {
-		log.Println("Failed to get revocations. " + err.Error())
+		slog.Warn("Failed to get revocations", "error", err)
 		return
 	}
 
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to get revocations. Server returned status %s.", resp.Status)
+		slog.Warn("Failed to get revocations", "status", resp.Status)
 		return
 	}
 
-	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		slog.Warn("Failed to read revocation data", "error", err)
+		return
+	}
 
 	jr := &jsonRevoke{}
 	if err := json.Unmarshal(body, &jr); err != nil {
-		log.Println("Failed to unmarshall revocation data. " + err.Error())
+		slog.Warn("Failed to unmarshal revocation data", "error", err)
 		return
 	}
 
+	var added []*Revocation
 	if jr.Meta.RefreshTimestamp != 0 {
 		r := crp.cache.Get(REVOCATION_TYPE_FORCEREFRESH)
 		if r == nil || (r.(*Revocation).Data["revoked_at"] != jr.Meta.RefreshTimestamp) {
-			log.Printf("Force refreshing cache from %d...", jr.Meta.RefreshFrom)
+			slog.Info("Force refreshing the revocation cache", "from", jr.Meta.RefreshFrom)
 			crp.cache.ForceRefresh(jr.Meta.RefreshFrom)
 			rev := &Revocation{}
 			d := make(map[string]interface{})
@@ -80,23 +103,134 @@ func (crp *CachingRevokeProvider) RefreshRevocations() {
 			d["revoked_at"] = jr.Meta.RefreshTimestamp
 			rev.Data = d
 			crp.cache.Add(rev)
+			added = append(added, rev)
 		}
 
 	}
 
 	if len(jr.Revs) > 0 {
-		log.Printf("Received %d new revocations", len(jr.Revs))
+		slog.Info("Received new revocations", "revocations", len(jr.Revs))
 	}
 
 	for _, j := range jr.Revs {
 		r, err := j.toRevocation()
 		if err == nil {
 			crp.cache.Add(r)
+			added = append(added, r)
 		}
 	}
+	if len(added) > 0 && crp.onApply != nil {
+		crp.onApply(SourceProvider, added)
+	}
 
 	crp.cache.Expire()
+	atomic.StoreInt64(&crp.lastRefresh, time.Now().UnixNano())
+}
+
+// LastRefresh returns the time of the last successful refresh from the Revocation Provider, the zero time
+// before the first one
+func (crp *CachingRevokeProvider) LastRefresh() time.Time {
+	if ns := atomic.LoadInt64(&crp.lastRefresh); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Adds the revocations pushed in data, a JSON document with the revocations in the same format as the responses of
+// the Revocation Provider. Revocations without revoked_at or issued_before get the current time. Either all
+// revocations are added or, if any of them is invalid, none. The OnPush function is called with the added revocations.
+func (crp *CachingRevokeProvider) AddRevocations(data []byte) ([]*Revocation, error) {
+	return crp.addRevocations(data, true)
+}
+
+// Adds the revocations that were pushed to another instance, like AddRevocations without calling the OnPush function.
+func (crp *CachingRevokeProvider) ApplyRevocations(data []byte) ([]*Revocation, error) {
+	return crp.addRevocations(data, false)
+}
 
+// Sets the function called with the JSON document of the revocations added by AddRevocations and
+// RevokeIssuedBefore, for ex. to send them to other instances. It must be set before any revocation is pushed.
+func (crp *CachingRevokeProvider) OnPush(f func(data []byte)) {
+	crp.onPush = f
+}
+
+// Sets the function called with the revocations added to the cache and their source, for ex. to report them. The
+// revocations of the Revocation Provider are passed again when they are polled again. It must be set before the
+// first refresh.
+func (crp *CachingRevokeProvider) OnApply(f func(source string, revs []*Revocation)) {
+	crp.onApply = f
+}
+
+func (crp *CachingRevokeProvider) addRevocations(data []byte, notify bool) ([]*Revocation, error) {
+	jr := &jsonRevoke{}
+	if err := json.Unmarshal(data, jr); err != nil {
+		return nil, err
+	}
+
+	now := int(time.Now().Unix())
+	revs := make([]*Revocation, 0, len(jr.Revs))
+	for _, j := range jr.Revs {
+		if j == nil {
+			return nil, ErrInvalidRevocation
+		}
+		if j.RevokedAt == 0 {
+			j.RevokedAt = now
+		}
+		if j.Data.IssuedBefore == 0 {
+			j.Data.IssuedBefore = now
+		}
+		r, err := j.toRevocation()
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+
+	for _, r := range revs {
+		crp.cache.Push(r)
+	}
+	if len(revs) == 0 {
+		return revs, nil
+	}
+	slog.Info("Added pushed revocations", "revocations", len(revs))
+	if crp.onApply != nil {
+		source := SourcePeer
+		if notify {
+			source = SourcePush
+		}
+		crp.onApply(source, revs)
+	}
+	if notify && crp.onPush != nil {
+		// with the defaults filled in, so that every instance gets the same revocations
+		if b, err := json.Marshal(jr); err == nil {
+			crp.onPush(b)
+		}
+	}
+	return revs, nil
+}
+
+// Revokes all tokens issued before the Unix timestamp ts, like a GLOBAL revocation from the Revocation Provider. It
+// has no effect if there is already a later cut-off.
+func (crp *CachingRevokeProvider) RevokeIssuedBefore(ts int) error {
+	j := &jsonRevocation{Type: REVOCATION_TYPE_GLOBAL}
+	j.Data.IssuedBefore = ts
+	data, err := json.Marshal(&jsonRevoke{Revs: []*jsonRevocation{j}})
+	if err != nil {
+		return err
+	}
+	_, err = crp.AddRevocations(data)
+	return err
+}
+
+// Returns the Unix timestamp of the global cut-off: all tokens issued before it are revoked. It returns 0 if there is
+// no GLOBAL revocation.
+func (crp *CachingRevokeProvider) IssuedBefore() int {
+	if r := crp.cache.Get(REVOCATION_TYPE_GLOBAL); r != nil {
+		if v, ok := r.(*Revocation).Data["issued_before"].(int); ok {
+			return v
+		}
+	}
+	return 0
 }
 
 // Test if a JWT token is revoked by comparing the token type, the hash (cache key), and the issued at time (iat) of
@@ -107,15 +241,16 @@ func (crp *CachingRevokeProvider) RefreshRevocations() {
 func (crp *CachingRevokeProvider) IsJWTRevoked(j *jwt.Token) bool {
 
 	if j.Claims == nil {
-		log.Println("Token has no claims, cannot check revocation")
+		slog.Debug("Token has no claims, cannot check revocation")
 		return false
 	}
 	claims := j.Claims.(jwt.MapClaims)
-	if _, ok := claims["iat"]; !ok {
-		log.Println("JWT missing required field 'iat'")
+	fiat, ok := claims["iat"].(float64)
+	if !ok {
+		slog.Debug("JWT missing required numeric field 'iat'")
 		return false
 	}
-	iat := int(claims["iat"].(float64))
+	iat := int(fiat)
 
 	// check global revocation
 	if r := crp.cache.Get(REVOCATION_TYPE_GLOBAL); r != nil {
@@ -126,12 +261,9 @@ func (crp *CachingRevokeProvider) IsJWTRevoked(j *jwt.Token) bool {
 	}
 
 	// check token revocation
-	th := hashTokenClaim(j.Raw)
-	if r := crp.cache.Get(th); r != nil {
-		if val, ok := r.(*Revocation).Data["issued_before"]; ok && val.(int) > iat {
-			countRevocations(REVOCATION_TYPE_TOKEN)
-			return true
-		}
+	if crp.isTokenRevoked(j.Raw, iat) {
+		countRevocations(REVOCATION_TYPE_TOKEN)
+		return true
 	}
 
 	// check claim revocation
@@ -139,18 +271,9 @@ func (crp *CachingRevokeProvider) IsJWTRevoked(j *jwt.Token) bool {
 	// if multiple claim names, the values are appended with a '|' between and then hashed
 	cNames := crp.cache.GetClaimNames()
 	for _, cName := range cNames {
-		names := strings.Split(cName, "|")
-		var vals string
-		for _, n := range names {
-			val, ok := claims[n]
-			if !ok {
-				break
-			}
-			if vals == "" {
-				vals = val.(string)
-				continue
-			}
-			vals += "|" + val.(string)
+		vals, ok := claimValues(claims, strings.Split(cName, "|"))
+		if !ok {
+			continue
 		}
 		ch := hashTokenClaim(vals)
 		if r := crp.cache.Get(ch); r != nil {
@@ -164,6 +287,42 @@ func (crp *CachingRevokeProvider) IsJWTRevoked(j *jwt.Token) bool {
 	return false
 }
 
+// Test if the JWT j, decrypted from the JWE token, is revoked like IsJWTRevoked does. TOKEN revocations also match the
+// hash of the JWE token, the one the clients present.
+func (crp *CachingRevokeProvider) IsJWERevoked(j *jwt.Token, token string) bool {
+	if claims, ok := j.Claims.(jwt.MapClaims); ok {
+		if iat, ok := claims["iat"].(float64); ok && crp.isTokenRevoked(token, int(iat)) {
+			countRevocations(REVOCATION_TYPE_TOKEN)
+			return true
+		}
+	}
+	return crp.IsJWTRevoked(j)
+}
+
+// Tests if there is a TOKEN revocation for the token issued at iat.
+func (crp *CachingRevokeProvider) isTokenRevoked(token string, iat int) bool {
+	if r := crp.cache.Get(hashTokenClaim(token)); r != nil {
+		if val, ok := r.(*Revocation).Data["issued_before"]; ok && val.(int) > iat {
+			return true
+		}
+	}
+	return false
+}
+
+// Joins the values of the claims with the given names with a '|'. Returns false if any of the claims is missing or
+// is not a string, as such a token can never match the claim revocation.
+func claimValues(claims jwt.MapClaims, names []string) (string, bool) {
+	vals := make([]string, 0, len(names))
+	for _, n := range names {
+		val, ok := claims[n].(string)
+		if !ok {
+			return "", false
+		}
+		vals = append(vals, val)
+	}
+	return strings.Join(vals, "|"), true
+}
+
 // SHA256 Hashes and base64 URL encodes a token or claim value(s) using the salt provided in the envionment variable
 // REVOCATION_HASHING_SALT.
 func hashTokenClaim(h string) string {
@@ -172,7 +331,7 @@ func hashTokenClaim(h string) string {
 		return ""
 	}
 
-	salt := options.AppSettings.HashingSalt
+	salt := options.Current().HashingSalt
 	buf := []byte(salt + h)
 	hash := sha256.New()
 	hash.Write(buf)
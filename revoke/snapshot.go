@@ -0,0 +1,298 @@
+package revoke
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A snapshot keeps the TOKEN revocations on disk, in a compact file sorted by token hash, so that tens of millions
+// of them don't have to be kept in memory. They are found with a binary search in the file, which stays in the page
+// cache of the OS as far as the memory allows. The other revocations are few, they are stored at the end of the file
+// to be loaded again at startup, together with the last pull timestamp.
+//
+// The file starts with snapshotMagic, the number of token records and the last pull timestamp, followed by the token
+// records and a JSON array of the other revocations. Numbers are big endian.
+
+var snapshotMagic = []byte("PBREV001")
+
+const (
+	snapshotHeaderSize = 24 // magic, number of token records and last pull timestamp
+	snapshotRecordSize = 48 // SHA-256 token hash, issued_before and revoked_at
+)
+
+var errInvalidSnapshot = errors.New("Invalid revocation snapshot")
+
+// tokenRecord is a TOKEN revocation in a snapshot
+type tokenRecord struct {
+	hash         [sha256.Size]byte
+	issuedBefore int64
+	revokedAt    int64
+}
+
+// newTokenRecord returns the record of the revocation rev stored with key. It's false for the revocations that are
+// not TOKEN revocations and for token hashes that are not base64 encoded SHA-256 hashes, they stay in memory.
+func newTokenRecord(key string, rev *Revocation) (tokenRecord, bool) {
+	var r tokenRecord
+	if rev.Type != REVOCATION_TYPE_TOKEN {
+		return r, false
+	}
+	b, err := base64.URLEncoding.DecodeString(key)
+	if err != nil || len(b) != sha256.Size {
+		return r, false
+	}
+	copy(r.hash[:], b)
+	r.issuedBefore = int64(rev.Data["issued_before"].(int))
+	r.revokedAt = int64(rev.Data["revoked_at"].(int))
+	return r, true
+}
+
+func (r tokenRecord) key() string {
+	return base64.URLEncoding.EncodeToString(r.hash[:])
+}
+
+func (r tokenRecord) revocation() *Revocation {
+	return &Revocation{Type: REVOCATION_TYPE_TOKEN, Data: map[string]interface{}{
+		"token_hash":    r.key(),
+		"issued_before": int(r.issuedBefore),
+		"revoked_at":    int(r.revokedAt),
+	}}
+}
+
+func (r tokenRecord) marshal(b []byte) {
+	copy(b, r.hash[:])
+	binary.BigEndian.PutUint64(b[sha256.Size:], uint64(r.issuedBefore))
+	binary.BigEndian.PutUint64(b[sha256.Size+8:], uint64(r.revokedAt))
+}
+
+func unmarshalTokenRecord(b []byte) tokenRecord {
+	var r tokenRecord
+	copy(r.hash[:], b)
+	r.issuedBefore = int64(binary.BigEndian.Uint64(b[sha256.Size:]))
+	r.revokedAt = int64(binary.BigEndian.Uint64(b[sha256.Size+8:]))
+	return r
+}
+
+// storedRevocation is one of the other revocations of a snapshot, with its key in the cache
+type storedRevocation struct {
+	Key  string                 `json:"key"`
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// revocation returns the stored revocation with the integer values of the cache
+func (s *storedRevocation) revocation() *Revocation {
+	for k, v := range s.Data {
+		if f, ok := v.(float64); ok {
+			s.Data[k] = int(f)
+		}
+	}
+	return &Revocation{Type: s.Type, Data: s.Data}
+}
+
+type snapshot struct {
+	file   *os.File
+	count  int64
+	lastTS int
+}
+
+// openSnapshot opens the snapshot at path and returns it with its other revocations
+func openSnapshot(path string) (*snapshot, []*storedRevocation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, others, err := readSnapshot(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return s, others, nil
+}
+
+func readSnapshot(f *os.File) (*snapshot, []*storedRevocation, error) {
+	header := make([]byte, snapshotHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil || !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return nil, nil, errInvalidSnapshot
+	}
+	s := &snapshot{
+		file:   f,
+		count:  int64(binary.BigEndian.Uint64(header[8:])),
+		lastTS: int(binary.BigEndian.Uint64(header[16:])),
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	end := s.offset(s.count)
+	if s.count < 0 || info.Size() < end {
+		return nil, nil, errInvalidSnapshot
+	}
+	var others []*storedRevocation
+	if err := json.NewDecoder(io.NewSectionReader(f, end, info.Size()-end)).Decode(&others); err != nil {
+		return nil, nil, errInvalidSnapshot
+	}
+	return s, others, nil
+}
+
+func (s *snapshot) offset(i int64) int64 {
+	return snapshotHeaderSize + i*snapshotRecordSize
+}
+
+// get returns the TOKEN revocation with key or nil if there is none
+func (s *snapshot) get(key string) (*Revocation, error) {
+	hash, err := base64.URLEncoding.DecodeString(key)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, nil
+	}
+	b := make([]byte, snapshotRecordSize)
+	var readErr error
+	i := sort.Search(int(s.count), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		if _, readErr = s.file.ReadAt(b[:sha256.Size], s.offset(int64(i))); readErr != nil {
+			return true
+		}
+		return bytes.Compare(b[:sha256.Size], hash) >= 0
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	if int64(i) == s.count {
+		return nil, nil
+	}
+	if _, err := s.file.ReadAt(b, s.offset(int64(i))); err != nil {
+		return nil, err
+	}
+	if r := unmarshalTokenRecord(b); bytes.Equal(r.hash[:], hash) {
+		return r.revocation(), nil
+	}
+	return nil, nil
+}
+
+// records returns a reader of the token records in their order
+func (s *snapshot) records() *recordReader {
+	return &recordReader{r: bufio.NewReader(io.NewSectionReader(s.file, snapshotHeaderSize, s.count*snapshotRecordSize)), left: s.count}
+}
+
+func (s *snapshot) close() {
+	s.file.Close()
+}
+
+type recordReader struct {
+	r    *bufio.Reader
+	left int64
+	b    [snapshotRecordSize]byte
+}
+
+// next returns the next record. It's false after the last one
+func (rr *recordReader) next() (tokenRecord, bool, error) {
+	if rr == nil || rr.left == 0 {
+		return tokenRecord{}, false, nil
+	}
+	if _, err := io.ReadFull(rr.r, rr.b[:]); err != nil {
+		return tokenRecord{}, false, err
+	}
+	rr.left--
+	return unmarshalTokenRecord(rr.b[:]), true, nil
+}
+
+// writeSnapshot writes a snapshot to path with the token records of old that are accepted by keep, the records, which
+// must be sorted by hash, and the other revocations. For records with the same hash, the one with the later
+// issued_before is kept. add is called with the key of every written record. The file is replaced atomically and
+// the new snapshot is returned, opened.
+func writeSnapshot(path string, old *snapshot, records []tokenRecord, others []*storedRevocation, lastTS int, keep func(tokenRecord) bool, add func(key string)) (*snapshot, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	written := false
+	defer func() {
+		if !written {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	header := make([]byte, snapshotHeaderSize)
+	w.Write(header)
+	var count int64
+	b := make([]byte, snapshotRecordSize)
+	write := func(r tokenRecord) {
+		r.marshal(b)
+		w.Write(b)
+		add(r.key())
+		count++
+	}
+
+	var rr *recordReader
+	if old != nil {
+		rr = old.records()
+	}
+	o, ok, err := rr.next()
+	for err == nil && (ok || len(records) > 0) {
+		c := -1
+		switch {
+		case !ok:
+			c = 1
+		case len(records) > 0:
+			c = bytes.Compare(o.hash[:], records[0].hash[:])
+		}
+		switch {
+		case c < 0:
+			if keep(o) {
+				write(o)
+			}
+			o, ok, err = rr.next()
+		case c > 0:
+			write(records[0])
+			records = records[1:]
+		default:
+			if records[0].issuedBefore > o.issuedBefore || !keep(o) {
+				o = records[0]
+			}
+			write(o)
+			records = records[1:]
+			o, ok, err = rr.next()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(w).Encode(others); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint64(header[8:], uint64(count))
+	binary.BigEndian.PutUint64(header[16:], uint64(lastTS))
+	if _, err := f.WriteAt(header, 0); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	written = true
+	s, _, err := readSnapshot(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,140 @@
+package revoke
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tokenRevocation(token string, issuedBefore int, revokedAt int) (string, *Revocation) {
+	key := hashTokenClaim(token)
+	return key, &Revocation{Type: REVOCATION_TYPE_TOKEN, Data: map[string]interface{}{"token_hash": key, "issued_before": issuedBefore, "revoked_at": revokedAt}}
+}
+
+// compactNow writes the snapshot and swaps it in, like the cache goroutine does
+func compactNow(s *store) {
+	s.compact()
+	s.swap(<-s.done)
+}
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations")
+	now := int(time.Now().Unix())
+	s := newStore(path, func(interface{}) {})
+	k1, r1 := tokenRevocation("token1", now-10, now-10)
+	k2, r2 := tokenRevocation("token2", now-20, now-20)
+	k3, r3 := tokenRevocation("token3", 1, 1)
+	s.set(k1, r1)
+	s.set(k2, r2)
+	s.set(k3, r3)
+	s.set(REVOCATION_TYPE_GLOBAL, &Revocation{Type: REVOCATION_TYPE_GLOBAL, Data: map[string]interface{}{"issued_before": now - 100, "revoked_at": now - 5}})
+	s.set("claimhash", &Revocation{Type: REVOCATION_TYPE_CLAIM, Data: map[string]interface{}{"value_hash": "claimhash", "names": "sub", "issued_before": now - 30, "revoked_at": now - 30}})
+	s.t = now - 5
+	compactNow(s)
+
+	if len(s.c) != 2 || s.snapshot == nil || s.snapshot.count != 2 {
+		t.Fatalf("The unexpired TOKEN revocations should be moved to the snapshot, got %d in memory", len(s.c))
+	}
+	if r := s.get(k1); r == nil || r.(*Revocation).Data["issued_before"] != now-10 {
+		t.Errorf("Wrong revocation from the snapshot: %v", r)
+	}
+	if s.get(k3) != nil || !s.filter.mayContain(k2) {
+		t.Error("The expired revocation should be dropped and the others kept in the filter")
+	}
+
+	// newer revocations of the same token replace the ones of the snapshot, deleted ones are dropped
+	_, r1 = tokenRevocation("token1", now, now)
+	s.set(k1, r1)
+	s.delete(k2)
+	compactNow(s)
+
+	loaded := newStore(path, func(interface{}) {})
+	if loaded.t != now-5 || loaded.snapshot == nil || loaded.snapshot.count != 1 {
+		t.Fatalf("Wrong snapshot loaded, last pull %d", loaded.t)
+	}
+	if r := loaded.get(k1); r == nil || r.(*Revocation).Data["issued_before"] != now {
+		t.Errorf("The newer revocation should be in the snapshot, got %v", r)
+	}
+	if loaded.get(k2) != nil {
+		t.Error("The deleted revocation should not be in the snapshot")
+	}
+	if r := loaded.get(REVOCATION_TYPE_GLOBAL); r == nil || r.(*Revocation).Data["issued_before"] != now-100 {
+		t.Errorf("Wrong GLOBAL revocation from the snapshot: %v", r)
+	}
+	if loaded.n["sub"] != 1 {
+		t.Errorf("The claim names of the snapshot should be counted, got %v", loaded.n)
+	}
+}
+
+func TestCompactInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations")
+	now := int(time.Now().Unix())
+	s := newStore(path, func(interface{}) {})
+	k1, r1 := tokenRevocation("token1", now-10, now-10)
+	k2, r2 := tokenRevocation("token2", now-20, now-20)
+	s.set(k1, r1)
+	s.set(k2, r2)
+	s.compact()
+
+	// the revocations changed while the snapshot is written are kept
+	k3, r3 := tokenRevocation("token3", now, now)
+	s.set(k3, r3)
+	_, newer := tokenRevocation("token1", now, now)
+	s.set(k1, newer)
+	s.delete(k2)
+	s.compact()
+	if s.get(k1) != newer || s.get(k2) != nil || s.get(k3) != r3 {
+		t.Error("The revocations should be read from memory while the snapshot is written")
+	}
+	s.swap(<-s.done)
+	if s.snapshot == nil || s.snapshot.count != 2 || len(s.c) != 2 {
+		t.Fatalf("Wrong revocations after the swap, %d in memory", len(s.c))
+	}
+	if s.get(k1) != newer || s.get(k2) != nil || s.get(k3) != r3 || !s.filter.mayContain(k3) {
+		t.Error("The revocations changed while the snapshot was written should be kept")
+	}
+	if !s.compacting {
+		t.Fatal("The pending snapshot should be written after the first one")
+	}
+	s.swap(<-s.done)
+	if s.snapshot.count != 2 || len(s.c) != 0 || s.get(k1).(*Revocation).Data["issued_before"] != now || s.get(k2) != nil {
+		t.Errorf("Wrong revocations after the pending snapshot, %d in memory and %d in the snapshot", len(s.c), s.snapshot.count)
+	}
+
+	// a force refresh drops the revocations of the snapshot before it's written again
+	s.refresh(now)
+	if s.get(k1) != nil || s.get(k3) != nil {
+		t.Error("The revocations after the force refresh should be dropped")
+	}
+	s.swap(<-s.done)
+	if s.snapshot.count != 0 || s.refreshedFrom != 0 {
+		t.Errorf("The snapshot should be written without the dropped revocations, got %d", s.snapshot.count)
+	}
+}
+
+func TestCacheWithSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations")
+	now := int(time.Now().Unix())
+	s := newStore(path, func(interface{}) {})
+	key, rev := tokenRevocation("token", now, now)
+	s.set(key, rev)
+	s.t = now
+	compactNow(s)
+
+	c := NewCacheWithSnapshot(path)
+	if c.Get(key) == nil {
+		t.Error("Failed to find the revocation of the snapshot")
+	}
+	if c.Get(hashTokenClaim("other")) != nil {
+		t.Error("Found a revocation that is not in the snapshot")
+	}
+	if c.GetLastTS() != now {
+		t.Errorf("Wrong last pull timestamp. Wanted %d, got %d", now, c.GetLastTS())
+	}
+
+	os.WriteFile(path, []byte("invalid"), 0600)
+	if c := NewCacheWithSnapshot(path); c.Get(key) != nil || c.GetLastTS() != 0 {
+		t.Error("An invalid snapshot should be ignored")
+	}
+}
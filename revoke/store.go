@@ -0,0 +1,284 @@
+package revoke
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// maxMemoryTokens is the number of TOKEN revocations kept in memory before they are moved to the snapshot
+const maxMemoryTokens = 100000
+
+// maxSnapshotAge is the time after which the snapshot is written again, to drop the expired revocations
+const maxSnapshotAge = 24 * time.Hour
+
+// store holds the revocations of a Cache and keeps the filter with their keys up to date. With a snapshot path, the
+// TOKEN revocations are moved to the snapshot file once there are maxMemoryTokens of them. The snapshot is written in
+// the background and swapped in by the cache goroutine once it's done, with swap. Otherwise it's only used by the
+// cache goroutine.
+type store struct {
+	c       map[string]interface{} // revocations in memory
+	n       map[string]int         // claim names
+	t       int                    // last pull timestamp
+	filter  *bloomFilter
+	publish func(interface{})
+	// number of deleted keys that are still in the filter
+	stale    int
+	path     string
+	snapshot *snapshot
+	// keys deleted since the snapshot was written
+	deleted map[string]bool
+	written time.Time
+	// a snapshot is being written, another one is written after it when pending is set
+	compacting bool
+	pending    bool
+	done       chan *compaction
+	// the TOKEN revocations of the snapshot revoked at or after refreshedFrom were dropped by a force refresh,
+	// until a new snapshot is written without them
+	refreshedFrom int
+}
+
+// compaction is a snapshot written in the background, with the state of the store when it was started
+type compaction struct {
+	snap          *snapshot
+	filter        *bloomFilter
+	moved         map[string]interface{}
+	deleted       map[string]bool
+	refreshedFrom int
+	start         time.Time
+	err           error
+}
+
+// newStore returns a store with the revocations of the snapshot at path, if there is one. publish is called with
+// every new filter.
+func newStore(path string, publish func(interface{})) *store {
+	s := &store{c: make(map[string]interface{}), n: make(map[string]int), publish: publish, path: path, deleted: make(map[string]bool),
+		done: make(chan *compaction, 1)}
+	if path != "" {
+		s.load()
+	}
+	if err := s.rebuildFilter(); err != nil {
+		slog.Warn("Failed to read the revocation snapshot", "path", path, "error", err)
+		s.snapshot.close()
+		s.snapshot = nil
+		s.rebuildFilter()
+	}
+	return s
+}
+
+func (s *store) load() {
+	snap, others, err := openSnapshot(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to load the revocation snapshot", "path", s.path, "error", err)
+		return
+	}
+	s.snapshot, s.t, s.written = snap, snap.lastTS, time.Now()
+	for _, o := range others {
+		rev := o.revocation()
+		s.c[o.Key] = rev
+		incrementClaimCount(&request{val: rev}, s.n)
+	}
+	updateSnapshotGauge(snap.count)
+	slog.Info("Loaded the revocation snapshot", "path", s.path, "tokens", snap.count, "revocations", len(others), "last_pull", s.t)
+}
+
+// get returns the revocation with key, from memory or from the snapshot, or nil if there is none
+func (s *store) get(key string) interface{} {
+	if v := s.c[key]; v != nil {
+		return v
+	}
+	if s.snapshot == nil || s.deleted[key] {
+		return nil
+	}
+	rev, err := s.snapshot.get(key)
+	if err != nil {
+		slog.Warn("Failed to read the revocation snapshot", "path", s.path, "error", err)
+		return nil
+	}
+	if rev == nil {
+		return nil
+	}
+	if revokedAt := rev.Data["revoked_at"].(int); isExpired(revokedAt) || (s.refreshedFrom != 0 && revokedAt >= s.refreshedFrom) {
+		return nil
+	}
+	return rev
+}
+
+func (s *store) set(key string, rev interface{}) {
+	s.c[key] = rev
+	s.filter.add(key)
+	delete(s.deleted, key)
+}
+
+func (s *store) delete(key string) {
+	if _, ok := s.c[key]; ok {
+		delete(s.c, key)
+		s.stale++
+	}
+	if s.snapshot != nil || s.compacting {
+		s.deleted[key] = true
+	}
+}
+
+func (s *store) size() int {
+	if s.snapshot == nil {
+		return len(s.c)
+	}
+	return len(s.c) + int(s.snapshot.count)
+}
+
+// maintain moves the TOKEN revocations to a new snapshot when there are many of them in memory or when the snapshot
+// is old. Otherwise the filter is built again when too many of its keys were deleted or when it's full. Nothing is
+// done while a snapshot is written, the filter is built again when it's swapped in.
+func (s *store) maintain() {
+	if s.compacting {
+		return
+	}
+	if s.path != "" {
+		tokens := 0
+		for key, v := range s.c {
+			if _, ok := newTokenRecord(key, v.(*Revocation)); ok {
+				tokens++
+			}
+		}
+		if tokens >= maxMemoryTokens || (s.snapshot != nil && time.Since(s.written) > maxSnapshotAge) {
+			s.compact()
+			return
+		}
+	}
+	if s.stale > s.filter.capacity/4 || s.size() > s.filter.capacity {
+		if err := s.rebuildFilter(); err != nil {
+			slog.Warn("Failed to read the revocation snapshot", "path", s.path, "error", err)
+		}
+	}
+}
+
+// refresh drops the TOKEN revocations of the snapshot that were revoked at or after from, for a force refresh. They
+// are not returned anymore and the snapshot is written again without them.
+func (s *store) refresh(from int) {
+	if s.refreshedFrom == 0 || from < s.refreshedFrom {
+		s.refreshedFrom = from
+	}
+	s.compact()
+}
+
+// compact starts writing a new snapshot in the background, with the unexpired TOKEN revocations of the current
+// snapshot and of the memory. The revocations of the snapshot that were deleted or dropped by a force refresh are
+// left out. Another snapshot is written after the one that is being written, if any. Until swap is called with the
+// result from done, the revocations are still read from memory and from the current snapshot.
+func (s *store) compact() {
+	if s.compacting {
+		s.pending = true
+		return
+	}
+	c := &compaction{moved: make(map[string]interface{}), deleted: maps.Clone(s.deleted), refreshedFrom: s.refreshedFrom, start: time.Now()}
+	var records []tokenRecord
+	var others []*storedRevocation
+	for key, v := range s.c {
+		rev := v.(*Revocation)
+		if r, ok := newTokenRecord(key, rev); ok {
+			if !isExpired(int(r.revokedAt)) {
+				records = append(records, r)
+			}
+			c.moved[key] = v
+		} else {
+			others = append(others, &storedRevocation{Key: key, Type: rev.Type, Data: rev.Data})
+		}
+	}
+	keep := func(r tokenRecord) bool {
+		return !isExpired(int(r.revokedAt)) && (c.refreshedFrom == 0 || int(r.revokedAt) < c.refreshedFrom) && !c.deleted[r.key()]
+	}
+	old, lastTS := s.snapshot, s.t
+	c.filter = newBloomFilter(2 * s.size())
+	s.compacting = true
+	go func() {
+		sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i].hash[:], records[j].hash[:]) < 0 })
+		c.snap, c.err = writeSnapshot(s.path, old, records, others, lastTS, keep, c.filter.add)
+		s.done <- c
+	}()
+}
+
+// swap replaces the snapshot with the one written by c and removes the revocations moved to it from memory. The
+// revocations set or deleted while it was written are kept. The filter is built again with the remaining keys.
+func (s *store) swap(c *compaction) {
+	s.compacting = false
+	if c.err != nil {
+		slog.Warn("Failed to write the revocation snapshot", "path", s.path, "error", c.err)
+		incCounter("planb.tokeninfo.revocation.snapshot.failures")
+	} else {
+		if s.snapshot != nil {
+			s.snapshot.close()
+		}
+		s.snapshot, s.written = c.snap, time.Now()
+		// the keys deleted before the snapshot was started are not in it
+		for key := range c.deleted {
+			delete(s.deleted, key)
+		}
+		for key, v := range c.moved {
+			if s.c[key] == v {
+				delete(s.c, key)
+			}
+		}
+		for key := range s.c {
+			c.filter.add(key)
+		}
+		if s.refreshedFrom == c.refreshedFrom {
+			s.refreshedFrom = 0
+		}
+		s.filter, s.stale = c.filter, 0
+		s.publish(c.filter)
+		incCounter("planb.tokeninfo.revocation.snapshot.writes")
+		updateSnapshotGauge(c.snap.count)
+		slog.Info("Wrote the revocation snapshot", "path", s.path, "tokens", c.snap.count, "revocations", len(s.c), "duration", time.Since(c.start))
+	}
+	if s.pending {
+		s.pending = false
+		s.compact()
+	}
+}
+
+// rebuildFilter replaces the filter with a new one with all keys, from memory and from the snapshot. The filter is
+// kept when the snapshot can't be read, so that no key is missing.
+func (s *store) rebuildFilter() error {
+	filter := newBloomFilter(2 * s.size())
+	for key := range s.c {
+		filter.add(key)
+	}
+	if s.snapshot != nil {
+		rr := s.snapshot.records()
+		for {
+			r, ok, err := rr.next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			filter.add(r.key())
+		}
+	}
+	s.filter, s.stale = filter, 0
+	s.publish(filter)
+	return nil
+}
+
+func updateSnapshotGauge(tokens int64) {
+	if g, ok := metrics.DefaultRegistry.GetOrRegister("planb.tokeninfo.revocation.snapshot.tokens", metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(tokens)
+	}
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
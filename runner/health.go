@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/breaker"
+	"github.com/zalando/planb-tokeninfo/handlers/healthcheck"
+	"github.com/zalando/planb-tokeninfo/handlers/selftest"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/proxy"
+	"github.com/zalando/planb-tokeninfo/logging"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/revoke"
+)
+
+var (
+	errNoRevocations = errors.New("no revocations loaded yet")
+	errCircuitOpen   = errors.New("the circuit breaker of the upstream token info is open")
+)
+
+// healthDependencies returns the dependencies reported by "/health" next to the keys: the circuit breaker
+// of the upstream token info, the cache backend and the age of the revocations. The cache backend is pinged
+// with the cache check of the readiness, if it has one
+func healthDependencies(settings *options.Settings, checks map[string]healthcheck.Check, crp *revoke.CachingRevokeProvider) map[string]healthcheck.Dependency {
+	deps := make(map[string]healthcheck.Dependency)
+	// in DEV_MODE there may be no Revocation service to poll
+	if settings.RevocationProviderUrl != nil {
+		deps["revocations"] = func() (map[string]interface{}, error) {
+			last := crp.LastRefresh()
+			if last.IsZero() {
+				return nil, errNoRevocations
+			}
+			age := time.Since(last)
+			details := map[string]interface{}{"last_refresh": last.UTC().Format(time.RFC3339), "age": age.Round(time.Second).String()}
+			if age > settings.HealthRevocationsMaxAge {
+				return details, fmt.Errorf("revocations are older than %v", settings.HealthRevocationsMaxAge)
+			}
+			return details, nil
+		}
+	}
+	if settings.UpstreamTokenInfoURL == nil {
+		return deps
+	}
+	deps["upstream"] = func() (map[string]interface{}, error) {
+		details := map[string]interface{}{"circuit": breaker.State(tokeninfoproxy.ProxyCommand)}
+		if breaker.IsOpen(tokeninfoproxy.ProxyCommand) {
+			return details, errCircuitOpen
+		}
+		return details, nil
+	}
+	deps["cache"] = func() (map[string]interface{}, error) {
+		details := map[string]interface{}{"backend": settings.UpstreamCacheBackend}
+		if ping, ok := checks["cache"]; ok {
+			return details, ping()
+		}
+		return details, nil
+	}
+	return deps
+}
+
+// selftestCanary returns the canary token of "/health/selftest": the SELFTEST_TOKEN or tokens signed with the
+// key of SELFTEST_KEY_FILE. It returns nil when neither is set
+func selftestCanary(settings *options.Settings) selftest.Canary {
+	if settings.SelftestToken != "" {
+		return selftest.StaticCanary(settings.SelftestToken)
+	}
+	if settings.SelftestKeyFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(settings.SelftestKeyFile)
+	if err != nil {
+		logging.Fatal("Failed to read the self test key", "error", err)
+	}
+	canary, err := selftest.SignedCanary(data, settings.SelftestKeyID, settings.SelftestClaims)
+	if err != nil {
+		logging.Fatal("Failed to load the self test key", "error", err)
+	}
+	slog.Info("Self test tokens are signed with a key", "kid", settings.SelftestKeyID)
+	return canary
+}
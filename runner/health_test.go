@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/handlers/healthcheck"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/revoke"
+)
+
+func TestHealthDependencies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"meta": {}, "revocations": []}`))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	crp := revoke.NewCachingRevokeProvider(u)
+
+	settings := &options.Settings{HealthRevocationsMaxAge: time.Minute}
+	if _, ok := healthDependencies(settings, nil, crp)["revocations"]; ok {
+		t.Error("The revocations should only be reported with a Revocation Provider")
+	}
+	settings.RevocationProviderUrl = u
+	deps := healthDependencies(settings, nil, crp)
+	if _, ok := deps["upstream"]; ok {
+		t.Error("The upstream should only be reported with an upstream token info")
+	}
+	if _, err := deps["revocations"](); err != errNoRevocations {
+		t.Errorf("The revocations should fail before the first refresh, got %v", err)
+	}
+	crp.RefreshRevocations()
+	if details, err := deps["revocations"](); err != nil || details["age"] != "0s" {
+		t.Errorf("Wrong revocations after a refresh: %v %v", details, err)
+	}
+
+	pingErr := errors.New("connection refused")
+	settings.UpstreamTokenInfoURL = u
+	settings.UpstreamCacheBackend = "redis"
+	deps = healthDependencies(settings, map[string]healthcheck.Check{"cache": func() error { return pingErr }}, crp)
+	if details, err := deps["upstream"](); err != nil || details["circuit"] != "closed" {
+		t.Errorf("Wrong upstream dependency: %v %v", details, err)
+	}
+	if details, err := deps["cache"](); err != pingErr || details["backend"] != "redis" {
+		t.Errorf("The cache dependency should be checked with the cache check: %v %v", details, err)
+	}
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/logging"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/revoke"
+)
+
+// revocationEventsQueueSize limits the revocation events waiting to be produced, revocations are rare but come
+// in bursts after a force refresh
+const revocationEventsQueueSize = 10000
+
+// kafkaAuditBatchTimeout is the batch timeout of the audit writer. The audit log writes its events in batches
+// already, they are produced right away
+const kafkaAuditBatchTimeout = 10 * time.Millisecond
+
+// newKafkaTransport returns the transport to KAFKA_BROKERS with the TLS and SASL settings
+func newKafkaTransport(settings *options.Settings) *kafka.Transport {
+	t := &kafka.Transport{ClientID: "planb-tokeninfo", DialTimeout: settings.KafkaTimeout}
+	if settings.KafkaTLS {
+		var err error
+		if t.TLS, err = ht.LoadClientTLSConfig(settings.KafkaTLSCertFile, settings.KafkaTLSKeyFile, settings.KafkaTLSCAFile); err != nil {
+			logging.Fatal("Failed to load the Kafka TLS configuration", "error", err)
+		}
+	}
+	var err error
+	switch settings.KafkaSASLMechanism {
+	case "PLAIN":
+		t.SASL = plain.Mechanism{Username: settings.KafkaSASLUsername, Password: settings.KafkaSASLPassword}
+	case "SCRAM-SHA-256":
+		t.SASL, err = scram.Mechanism(scram.SHA256, settings.KafkaSASLUsername, settings.KafkaSASLPassword)
+	case "SCRAM-SHA-512":
+		t.SASL, err = scram.Mechanism(scram.SHA512, settings.KafkaSASLUsername, settings.KafkaSASLPassword)
+	}
+	if err != nil {
+		logging.Fatal("Failed to set up the Kafka SASL authentication", "error", err)
+	}
+	return t
+}
+
+// newKafkaWriter returns a writer of the messages to the topic at KAFKA_BROKERS, acknowledged by all the in-sync
+// replicas. The messages with a key go to the partition of its murmur2 hash, like with the Java client
+func newKafkaWriter(settings *options.Settings, t *kafka.Transport, topic string, batchTimeout time.Duration) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(settings.KafkaBrokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Murmur2Balancer{},
+		BatchSize:    settings.KafkaBatchSize,
+		BatchTimeout: batchTimeout,
+		WriteTimeout: settings.KafkaTimeout,
+		ReadTimeout:  settings.KafkaTimeout,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    t,
+	}
+}
+
+// revocationEvent is the message produced to KAFKA_REVOCATIONS_TOPIC for every revocation added to the cache
+type revocationEvent struct {
+	Time   string                 `json:"time"`
+	Host   string                 `json:"host"`
+	Source string                 `json:"source"`
+	Type   string                 `json:"type"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// publishRevocations produces the revocations applied by crp with w in the background, so that the propagation of
+// a revocation to every instance can be followed. Up to revocationEventsQueueSize events wait to be produced,
+// the others are dropped and counted in planb.kafka.dropped
+func publishRevocations(crp *revoke.CachingRevokeProvider, w *kafka.Writer) {
+	host, _ := os.Hostname()
+	var pending atomic.Int64
+	w.Async = true
+	w.Completion = func(msgs []kafka.Message, err error) {
+		pending.Add(-int64(len(msgs)))
+		if err != nil {
+			incCounter("planb.kafka.failures")
+			slog.Warn("Failed to produce the revocation events", "events", len(msgs), "topic", w.Topic, "error", err)
+		}
+	}
+	crp.OnApply(func(source string, revs []*revoke.Revocation) {
+		msgs := revocationMessages(host, source, revs, time.Now())
+		if pending.Add(int64(len(msgs))) > revocationEventsQueueSize {
+			pending.Add(-int64(len(msgs)))
+			gometrics.GetOrRegisterCounter("planb.kafka.dropped", gometrics.DefaultRegistry).Inc(int64(len(msgs)))
+			return
+		}
+		// never blocks, the errors are passed to the Completion function
+		w.WriteMessages(context.Background(), msgs...)
+	})
+}
+
+func revocationMessages(host string, source string, revs []*revoke.Revocation, now time.Time) []kafka.Message {
+	msgs := make([]kafka.Message, 0, len(revs))
+	for _, r := range revs {
+		b, err := json.Marshal(&revocationEvent{Time: now.UTC().Format(time.RFC3339Nano), Host: host, Source: source, Type: r.Type, Data: r.Data})
+		if err != nil {
+			slog.Warn("Failed to serialize a revocation event", "type", r.Type, "error", err)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Value: b})
+	}
+	return msgs
+}
@@ -0,0 +1,28 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/revoke"
+)
+
+func TestRevocationMessages(t *testing.T) {
+	revs := []*revoke.Revocation{
+		{Type: revoke.REVOCATION_TYPE_TOKEN, Data: map[string]interface{}{"token_hash": "abc", "issued_before": 1700000000, "revoked_at": 1700000000}},
+		{Type: revoke.REVOCATION_TYPE_GLOBAL, Data: map[string]interface{}{"issued_before": 1700000000, "revoked_at": 1700000000}},
+	}
+	msgs := revocationMessages("tokeninfo-1", revoke.SourcePush, revs, time.Unix(1700000000, 0))
+	want := []string{
+		`{"time":"2023-11-14T22:13:20Z","host":"tokeninfo-1","source":"push","type":"TOKEN","data":{"issued_before":1700000000,"revoked_at":1700000000,"token_hash":"abc"}}`,
+		`{"time":"2023-11-14T22:13:20Z","host":"tokeninfo-1","source":"push","type":"GLOBAL","data":{"issued_before":1700000000,"revoked_at":1700000000}}`,
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("Wanted %d messages, got %d", len(want), len(msgs))
+	}
+	for i, m := range msgs {
+		if string(m.Value) != want[i] || m.Key != nil {
+			t.Errorf("Wrong message. Wanted %s, got %s", want[i], m.Value)
+		}
+	}
+}
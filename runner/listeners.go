@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/requestid"
+	"github.com/zalando/planb-tokeninfo/tlscert"
+)
+
+// the groups of endpoints a listener can serve
+const (
+	tokenInfoHandlers = "tokeninfo"
+	healthHandlers    = "health"
+	metricsHandlers   = "metrics"
+	adminHandlers     = "admin"
+	debugHandlers     = "debug"
+)
+
+// listeners returns the groups of endpoints served at every address: the ones of LISTENERS or, without it,
+// the token info and health endpoints at LISTEN_ADDRESS and the metrics at METRICS_LISTEN_ADDRESS, unless they
+// are only sent to StatsD. The admin
+// and debug endpoints are also served at ADMIN_LISTEN_ADDRESS and DEBUG_LISTEN_ADDRESS, if set
+func listeners(settings *options.Settings) map[string][]string {
+	l := make(map[string][]string)
+	if len(settings.Listeners) > 0 {
+		for address, handlers := range settings.Listeners {
+			l[address] = append([]string(nil), handlers...)
+		}
+	} else {
+		l[settings.ListenAddress] = []string{tokenInfoHandlers, healthHandlers}
+		if hasMetricsSink(settings, "http") {
+			l[settings.MetricsListenAddress] = append(l[settings.MetricsListenAddress], metricsHandlers)
+		}
+	}
+	if settings.AdminListenAddress != "" {
+		l[settings.AdminListenAddress] = append(l[settings.AdminListenAddress], adminHandlers)
+	}
+	if settings.DebugListenAddress != "" {
+		l[settings.DebugListenAddress] = append(l[settings.DebugListenAddress], debugHandlers)
+	}
+	return l
+}
+
+// serveListeners serves the routes of the handler groups at every listener address. It returns when the
+// first listener fails
+func serveListeners(settings *options.Settings, routes map[string]map[string]http.Handler) error {
+	all := listeners(settings)
+	addresses := make([]string, 0, len(all))
+	for address := range all {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	errs := make(chan error, len(addresses))
+	for _, address := range addresses {
+		handlers := all[address]
+		mux := http.NewServeMux()
+		tokenInfo := false
+		for _, name := range handlers {
+			tokenInfo = tokenInfo || name == tokenInfoHandlers
+			for pattern, h := range routes[name] {
+				mux.Handle(pattern, h)
+			}
+		}
+		var h http.Handler = mux
+		if tokenInfo {
+			h = requestid.NewHandler(clientip.NewHandler(mux, settings.TrustedProxies))
+		}
+		l, err := listen(address, settings.UnixSocketMode)
+		if err != nil {
+			return fmt.Errorf("Failed to listen at %s: %v", address, err)
+		}
+		slog.Info("Serving endpoints", "handlers", strings.Join(handlers, ", "), "address", address)
+		go func() {
+			errs <- fmt.Errorf("Listener at %s failed: %v", address, serve(settings, l, h, tokenInfo))
+		}()
+	}
+	return <-errs
+}
+
+// listen opens a TCP listener or, for addresses starting with "unix:", a Unix socket with the file mode, if
+// not 0. A socket file left behind by a previous process is removed, the listener removes its own when closed.
+// Addresses starting with "systemd:" use a socket passed by systemd socket activation
+func listen(address string, mode os.FileMode) (net.Listener, error) {
+	if name := strings.TrimPrefix(address, systemdPrefix); name != address {
+		return systemdListener(name)
+	}
+	path := strings.TrimPrefix(address, "unix:")
+	if path == address {
+		return net.Listen("tcp", address)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil || mode == 0 {
+		return l, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serve serves plain HTTP or, for the token info endpoints on TCP listeners when a certificate is configured,
+// HTTPS and HTTP/2 with a certificate that is reloaded whenever the files change. h2c is only enabled for the
+// token info endpoints
+func serve(settings *options.Settings, l net.Listener, h http.Handler, tokenInfo bool) error {
+	server := &http.Server{Handler: h}
+	if !tokenInfo {
+		return server.Serve(l)
+	}
+	server.Protocols = ht.ServerProtocols(settings.ListenH2C)
+	if settings.TLSCertFile == "" || l.Addr().Network() == "unix" {
+		return server.Serve(l)
+	}
+
+	r, err := tlscert.NewReloader(settings.TLSCertFile, settings.TLSKeyFile, settings.TLSReloadInterval)
+	if err != nil {
+		return fmt.Errorf("Failed to load the TLS certificate: %v", err)
+	}
+	slog.Info("Serving TLS", "address", l.Addr(), "certificate", settings.TLSCertFile)
+	server.TLSConfig = r.TLSConfig()
+	if settings.TLSClientCAFile != "" {
+		pool, err := ht.LoadCertPool(settings.TLSClientCAFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load the TLS client CA: %v", err)
+		}
+		// clients without a certificate may still authenticate with an API key
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return server.ServeTLS(l, "", "")
+}
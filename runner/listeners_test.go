@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+func TestListeners(t *testing.T) {
+	for _, test := range []struct {
+		settings *options.Settings
+		want     map[string][]string
+	}{
+		{
+			&options.Settings{ListenAddress: ":9021", MetricsListenAddress: ":9020", MetricsSinks: []string{"http"}},
+			map[string][]string{":9021": {"tokeninfo", "health"}, ":9020": {"metrics"}},
+		},
+		{
+			&options.Settings{ListenAddress: ":9021", MetricsListenAddress: ":9021", MetricsSinks: []string{"http"}, AdminListenAddress: ":9022", DebugListenAddress: ":9022"},
+			map[string][]string{":9021": {"tokeninfo", "health", "metrics"}, ":9022": {"admin", "debug"}},
+		},
+		{
+			&options.Settings{ListenAddress: ":9021", MetricsListenAddress: ":9020", DebugListenAddress: "127.0.0.1:6060",
+				Listeners: map[string][]string{"unix:/run/tokeninfo.sock": {"tokeninfo"}, ":9022": {"health", "metrics"}}},
+			map[string][]string{"unix:/run/tokeninfo.sock": {"tokeninfo"}, ":9022": {"health", "metrics"}, "127.0.0.1:6060": {"debug"}},
+		},
+		{
+			&options.Settings{ListenAddress: ":9021", MetricsListenAddress: ":9020", MetricsSinks: []string{"statsd"}},
+			map[string][]string{":9021": {"tokeninfo", "health"}},
+		},
+	} {
+		if got := listeners(test.settings); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Wrong listeners. Wanted %v, got %v", test.want, got)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokeninfo.sock")
+	// a socket of a previous process is replaced
+	for i := 0; i < 2; i++ {
+		l, err := listen("unix:"+path, 0660)
+		if err != nil {
+			t.Fatal("Failed to listen on the socket: ", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil || fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0660 {
+			t.Errorf("Wrong socket file: %v %v", fi.Mode(), err)
+		}
+		if i == 0 {
+			// leave the file behind like a killed process
+			l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+			l.Close()
+			continue
+		}
+		l.Close()
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("The socket file should be removed when the listener is closed")
+		}
+	}
+}
+
+func TestActivatedFiles(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// the passed file descriptor is owned by the returned file
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1", "LISTEN_FDNAMES": "tokeninfo"}
+	getenv := func(key string) string { return env[key] }
+
+	if files := activatedFiles(43, getenv, fd); files != nil {
+		t.Errorf("Sockets passed to another process should be ignored, got %v", files)
+	}
+	files := activatedFiles(42, getenv, fd)
+	if files["tokeninfo"] == nil || files["0"] != files["tokeninfo"] {
+		t.Fatalf("The socket should be found by name and position, got %v", files)
+	}
+	sockets := &activatedSockets{files: files, listeners: make(map[*os.File]net.Listener)}
+	activated, err := sockets.listener("tokeninfo")
+	if err != nil {
+		t.Fatal("Failed to use the passed socket: ", err)
+	}
+	defer activated.Close()
+	if activated.Addr().String() != l.Addr().String() {
+		t.Errorf("Wrong socket. Wanted %v, got %v", l.Addr(), activated.Addr())
+	}
+	if byPosition, err := sockets.listener("0"); err != nil || byPosition != activated {
+		t.Errorf("The socket should be found again by position, got %v %v", byPosition, err)
+	}
+	if _, err := sockets.listener("metrics"); err == nil {
+		t.Error("Sockets that were not passed should not be found")
+	}
+}
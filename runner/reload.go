@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/options"
+)
+
+// reloadableHandler forwards every Request to a handler that can be replaced at any time
+type reloadableHandler struct {
+	current atomic.Value
+}
+
+func newReloadableHandler(h http.Handler) *reloadableHandler {
+	rh := new(reloadableHandler)
+	rh.set(h)
+	return rh
+}
+
+func (rh *reloadableHandler) set(h http.Handler) {
+	rh.current.Store(&h)
+}
+
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*rh.current.Load().(*http.Handler)).ServeHTTP(w, r)
+}
+
+// reloadOnSignal reloads the options whenever the process receives SIGHUP. Invalid options are logged
+// and the current ones are kept
+func reloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := options.Reload(); err != nil {
+				slog.Error("Failed to reload the options", "error", err)
+				incCounter("planb.config.reload.failures")
+				continue
+			}
+			slog.Info("Reloaded the options")
+			incCounter("planb.config.reloads")
+		}
+	}()
+}
+
+func incCounter(key string) {
+	if c, ok := gometrics.DefaultRegistry.GetOrRegister(key, gometrics.NewCounter).(gometrics.Counter); ok {
+		c.Inc(1)
+	}
+}
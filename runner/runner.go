@@ -2,55 +2,587 @@ package runner
 
 import (
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"os"
+	"slices"
 	"time"
 
 	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/audit"
+	"github.com/zalando/planb-tokeninfo/denylist"
+	"github.com/zalando/planb-tokeninfo/extauthz"
+	"github.com/zalando/planb-tokeninfo/handlers/accesslog"
+	"github.com/zalando/planb-tokeninfo/handlers/admin"
+	"github.com/zalando/planb-tokeninfo/handlers/batch"
+	"github.com/zalando/planb-tokeninfo/handlers/callerauth"
+	"github.com/zalando/planb-tokeninfo/handlers/chaos"
+	"github.com/zalando/planb-tokeninfo/handlers/cors"
+	"github.com/zalando/planb-tokeninfo/handlers/debug"
+	"github.com/zalando/planb-tokeninfo/handlers/devtoken"
+	"github.com/zalando/planb-tokeninfo/handlers/discovery"
 	"github.com/zalando/planb-tokeninfo/handlers/healthcheck"
+	"github.com/zalando/planb-tokeninfo/handlers/introspection"
 	"github.com/zalando/planb-tokeninfo/handlers/jwks"
+	"github.com/zalando/planb-tokeninfo/handlers/limits"
 	"github.com/zalando/planb-tokeninfo/handlers/metrics"
+	"github.com/zalando/planb-tokeninfo/handlers/policy"
+	"github.com/zalando/planb-tokeninfo/handlers/ratelimit"
+	"github.com/zalando/planb-tokeninfo/handlers/revocations"
+	"github.com/zalando/planb-tokeninfo/handlers/selftest"
+	"github.com/zalando/planb-tokeninfo/handlers/serializer"
+	"github.com/zalando/planb-tokeninfo/handlers/tokenexchange"
 	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
 	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/errorall"
 	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/jwt"
 	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/proxy"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo/verify"
+	"github.com/zalando/planb-tokeninfo/handlers/tokenreview"
+	"github.com/zalando/planb-tokeninfo/handlers/userinfo"
 	"github.com/zalando/planb-tokeninfo/ht"
+	"github.com/zalando/planb-tokeninfo/invalidation"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+	"github.com/zalando/planb-tokeninfo/keyloader/kms"
 	"github.com/zalando/planb-tokeninfo/keyloader/openid"
+	"github.com/zalando/planb-tokeninfo/keyloader/static"
+	"github.com/zalando/planb-tokeninfo/keyloader/vault"
+	"github.com/zalando/planb-tokeninfo/logging"
+	"github.com/zalando/planb-tokeninfo/metricsink"
 	"github.com/zalando/planb-tokeninfo/options"
+	"github.com/zalando/planb-tokeninfo/processor"
 	"github.com/zalando/planb-tokeninfo/revoke"
+	"github.com/zalando/planb-tokeninfo/tokencache"
+	"github.com/zalando/planb-tokeninfo/tracing"
+	"github.com/zalando/planb-tokeninfo/webhook"
 )
 
 var version string
 
-func setupMetrics(s *options.Settings) {
+func setupMetrics() {
 	gometrics.RegisterRuntimeMemStats(gometrics.DefaultRegistry)
 	go gometrics.CaptureRuntimeMemStats(gometrics.DefaultRegistry, 60*time.Second)
-	http.Handle("/metrics", metrics.Default)
-	go func() {
-		log.Printf("ERROR: %s", http.ListenAndServe(s.MetricsListenAddress, nil))
-	}()
+}
+
+// hasMetricsSink tells whether the metrics are sent to the sink of METRICS_SINKS
+func hasMetricsSink(settings *options.Settings, sink string) bool {
+	for _, s := range settings.MetricsSinks {
+		if s == sink {
+			return true
+		}
+	}
+	return false
+}
+
+// newKeyLoader returns the KeyLoader for the static keys or the default OpenID provider, merged with the keys
+// of the JWKS URLs, Vault and KMS, or, when there are OpenID providers per issuer, a KeyLoader that picks the
+// provider, or the SPIFFE trust bundle, with the token issuer. The DEV_MODE issuer, if not nil, is the only key source
+func newKeyLoader(settings *options.Settings, dev *devtoken.Issuer) keyloader.KeyLoader {
+	if dev != nil {
+		return dev
+	}
+	var kl keyloader.KeyLoader
+	var err error
+	switch {
+	case settings.StaticKeysFile != "":
+		slog.Info("Tokens are validated with the keys from a file", "file", settings.StaticKeysFile)
+		kl, err = static.NewFileLoader(settings.StaticKeysFile, settings.StaticKeysReloadInterval)
+	case settings.StaticKeys != "":
+		slog.Info("Tokens are validated with the keys from STATIC_KEYS")
+		kl, err = static.NewLoader([]byte(settings.StaticKeys))
+	case settings.OpenIDProviderConfigurationURL != nil:
+		kl = openid.NewCachingOpenIDProviderLoader(settings.OpenIDProviderConfigurationURL)
+	}
+	if err != nil {
+		logging.Fatal("Failed to load the static keys", "error", err)
+	}
+	if len(settings.JwksURLs) > 0 || settings.VaultKeysPath != "" || len(settings.AWSKMSKeys) > 0 || len(settings.GCPKMSKeys) > 0 {
+		var loaders []keyloader.KeyLoader
+		if kl != nil {
+			loaders = append(loaders, kl)
+		}
+		for _, u := range settings.JwksURLs {
+			slog.Info("Tokens are validated with the keys from a JWKS URL", "url", u)
+			loaders = append(loaders, openid.NewCachingJWKSLoader(u))
+		}
+		if settings.VaultKeysPath != "" {
+			vl, err := vault.NewLoader(vault.Config{
+				Address:                 settings.VaultAddress,
+				Namespace:               settings.VaultNamespace,
+				Path:                    settings.VaultKeysPath,
+				Engine:                  settings.VaultKeysEngine,
+				Field:                   settings.VaultKeysField,
+				AuthMethod:              settings.VaultAuthMethod,
+				AuthMount:               settings.VaultAuthMount,
+				Token:                   settings.VaultToken,
+				Role:                    settings.VaultAuthRole,
+				SecretID:                settings.VaultAuthSecretID,
+				ServiceAccountTokenFile: settings.VaultKubernetesTokenFile,
+				RefreshInterval:         settings.VaultRefreshInterval,
+			})
+			if err != nil {
+				logging.Fatal("Failed to load the keys from Vault", "error", err)
+			}
+			slog.Info("Tokens are validated with the keys from Vault", "path", settings.VaultKeysPath)
+			loaders = append(loaders, vl)
+		}
+		if len(settings.AWSKMSKeys) > 0 {
+			al, err := kms.NewAWSLoader(kms.AWSConfig{
+				Keys:            settings.AWSKMSKeys,
+				Region:          settings.AWSRegion,
+				Endpoint:        settings.AWSKMSEndpoint,
+				AccessKeyID:     settings.AWSAccessKeyID,
+				SecretAccessKey: settings.AWSSecretAccessKey,
+				SessionToken:    settings.AWSSessionToken,
+			})
+			if err != nil {
+				logging.Fatal("Failed to load the keys from AWS KMS", "error", err)
+			}
+			slog.Info("Tokens are validated with keys from AWS KMS", "keys", len(settings.AWSKMSKeys))
+			loaders = append(loaders, al)
+		}
+		if len(settings.GCPKMSKeys) > 0 {
+			gl, err := kms.NewGCPLoader(kms.GCPConfig{Keys: settings.GCPKMSKeys, CredentialsFile: settings.GCPCredentialsFile})
+			if err != nil {
+				logging.Fatal("Failed to load the keys from Cloud KMS", "error", err)
+			}
+			slog.Info("Tokens are validated with keys from Cloud KMS", "keys", len(settings.GCPKMSKeys))
+			loaders = append(loaders, gl)
+		}
+		kl = keyloader.NewMergedKeyLoader(loaders...)
+	}
+	if len(settings.OpenIDProviders) == 0 && len(settings.SPIFFEBundles) == 0 {
+		return kl
+	}
+
+	issuers := make(map[string]keyloader.KeyLoader)
+	for iss, u := range settings.OpenIDProviders {
+		slog.Info("Tokens of an issuer are validated with the keys of its OpenID provider", "issuer", iss, "url", u)
+		issuers[iss] = openid.NewCachingOpenIDProviderLoader(u)
+	}
+	for td, u := range settings.SPIFFEBundles {
+		slog.Info("JWT-SVIDs of a trust domain are validated with the keys of its bundle", "trust_domain", td, "url", u)
+		issuers["spiffe://"+td] = openid.NewCachingSPIFFEBundleLoader(u)
+	}
+	return keyloader.NewIssuerKeyLoader(kl, issuers)
+}
+
+// newJwtProcessors returns the JwtProcessors of the issuers: the ones with a custom realm or scope claim, replaced
+// by the Keycloak, Azure AD, Google and SPIFFE profiles for the same issuers
+func newJwtProcessors(settings *options.Settings) map[string]processor.JwtProcessor {
+	processors := maps.Clone(settings.JwtProcessors)
+	for iss := range settings.OpenIDProviders {
+		realm, scopeClaim := settings.IssuerRealms[iss], settings.IssuerScopeClaims[iss]
+		if realm != "" || scopeClaim != "" {
+			processors[iss] = jwthandler.NewIssuerProcessor(realm, scopeClaim)
+		}
+	}
+	for _, iss := range settings.KeycloakIssuers {
+		slog.Info("Tokens of an issuer are Keycloak tokens, with their roles as scopes", "issuer", iss)
+		processors[iss] = jwthandler.NewKeycloakProcessor(settings.IssuerRealms[iss])
+	}
+	for _, iss := range settings.AzureADIssuers {
+		slog.Info("Tokens of an issuer are Azure AD tokens, with their delegated scopes and application roles as scopes", "issuer", iss)
+		processors[iss] = jwthandler.NewAzureADProcessor(settings.IssuerRealms[iss], settings.AzureADAudiences)
+	}
+	if len(settings.GoogleIDTokenAudiences) > 0 {
+		slog.Info("Google ID tokens are accepted", "audiences", settings.GoogleIDTokenAudiences, "realm", settings.GoogleIDTokenRealm)
+		processors[jwthandler.GoogleIssuer] = jwthandler.NewGoogleProcessor(settings.GoogleIDTokenAudiences, settings.GoogleIDTokenRealm)
+	}
+	for td := range settings.SPIFFEBundles {
+		realm := settings.IssuerRealms["spiffe://"+td]
+		if realm == "" {
+			realm = settings.SPIFFERealm
+		}
+		processors["spiffe://"+td] = jwthandler.NewSPIFFEProcessor(td, settings.SPIFFEAudiences, realm)
+	}
+	return processors
+}
+
+// withJwtProcessors publishes a copy of settings with the processors and returns it. The processors need the
+// JWT handlers, so they can't be built by the options, and the published settings must not be modified
+func withJwtProcessors(settings *options.Settings, processors map[string]processor.JwtProcessor) *options.Settings {
+	s := *settings
+	s.JwtProcessors = processors
+	options.Replace(&s)
+	return &s
 }
 
 func Run(settings *options.Settings) {
-	log.Printf("Started server (%s) at %v, /metrics endpoint at %v\n",
-		version, settings.ListenAddress, settings.MetricsListenAddress)
+	logging.Setup(os.Stderr, settings.LogFormat)
+	logging.SetLevel(settings.LogLevel)
+	slog.Info("Started server", "version", version)
 	ht.UserAgent = fmt.Sprintf("%v/%s", os.Args[0], version)
-	setupMetrics(settings)
+	if algs := jwthandler.AllowedAlgorithms(settings.JwtAllowedAlgorithms); len(algs) == 0 {
+		logging.Fatal("None of JWT_ALLOWED_ALGORITHMS is supported", "algorithms", settings.JwtAllowedAlgorithms)
+	} else if len(settings.JwtAllowedAlgorithms) > 0 {
+		slog.Info("JWT tokens signed with these algorithms are accepted", "algorithms", algs)
+	}
+	for iss, ia := range settings.IssuerAlgorithms {
+		if algs := jwthandler.AllowedAlgorithms(ia); len(algs) == 0 {
+			logging.Fatal("None of the ISSUER_ALGORITHMS of an issuer is supported", "issuer", iss, "algorithms", ia)
+		} else {
+			slog.Info("JWT tokens of an issuer signed with these algorithms are accepted", "issuer", iss, "algorithms", algs)
+		}
+	}
 
-	var ph http.Handler
+	if settings.HTTPClientCertFile != "" || settings.HTTPClientCAFile != "" {
+		if err := ht.SetupClientTLS(settings.HTTPClientCertFile, settings.HTTPClientKeyFile, settings.HTTPClientCAFile); err != nil {
+			logging.Fatal("Failed to load the HTTP client TLS configuration", "error", err)
+		}
+	}
+	setupMetrics()
+	if hasMetricsSink(settings, "statsd") {
+		sink, err := metricsink.NewStatsD(settings.StatsDAddress, settings.StatsDPrefix, settings.StatsDTags, settings.StatsDFormat == "dogstatsd")
+		if err != nil {
+			logging.Fatal("Failed to set up the StatsD metrics", "error", err)
+		}
+		slog.Info("Sending metrics", "format", settings.StatsDFormat, "address", settings.StatsDAddress, "interval", settings.StatsDFlushInterval)
+		go metricsink.Run(sink, gometrics.DefaultRegistry, settings.StatsDFlushInterval)
+	}
+	if settings.TracingEnabled {
+		if _, err := tracing.Setup("planb-tokeninfo", version); err != nil {
+			logging.Fatal("Failed to set up tracing", "error", err)
+		}
+	}
+
+	var circuits []string
+	var cache tokencache.Cache
+	checks := make(map[string]healthcheck.Check)
 	if settings.UpstreamTokenInfoURL != nil {
-		ph = tokeninfoproxy.NewTokenInfoProxyHandler(settings.UpstreamTokenInfoURL, settings.UpstreamCacheMaxSize, settings.UpstreamCacheTTL, settings.UpstreamTimeout)
-	} else {
-		ph = errorall.NewErrorAllHandler()
+		var err error
+		cache, err = tokencache.New(settings.UpstreamCacheBackend, settings.UpstreamCacheMaxSize, settings.UpstreamCacheMaxBytes, settings.UpstreamCacheShards, settings.UpstreamCacheRedisURL)
+		if err != nil {
+			logging.Fatal("Failed to create the upstream cache", "error", err)
+		}
+		slog.Info("Tokens without a key are proxied to the upstream tokeninfo", "urls", settings.UpstreamTokenInfoURLs, "balancing", settings.UpstreamBalancing, "cache_ttl", settings.UpstreamCacheTTL, "cache_backend", settings.UpstreamCacheBackend)
+		if settings.UpstreamShadowURL != nil {
+			slog.Info("Upstream calls are duplicated to the candidate upstream", "url", settings.UpstreamShadowURL, "percentage", settings.UpstreamShadowPercentage)
+		}
+		if settings.UpstreamCanaryURL != nil {
+			slog.Info("Upstream calls are routed to the canary upstream", "url", settings.UpstreamCanaryURL, "percentage", settings.UpstreamCanaryPercentage)
+		}
+		circuits = append(circuits, tokeninfoproxy.ProxyCommand)
+		if settings.TokenRoutes != nil {
+			for _, target := range slices.Sorted(maps.Keys(settings.TokenRoutes.Upstreams())) {
+				circuits = append(circuits, tokeninfoproxy.RouteCommand(target))
+			}
+		}
+		checks["upstream"] = func() error { return tokeninfoproxy.Ping(settings.UpstreamTokenInfoURLs) }
+		if p, ok := cache.(tokencache.Pinger); ok {
+			checks["cache"] = p.Ping
+		}
+		if settings.UpstreamCacheSnapshotFile != "" {
+			restoreCache(cache, settings.UpstreamCacheSnapshotFile)
+		}
 	}
-	kl := openid.NewCachingOpenIDProviderLoader(settings.OpenIDProviderConfigurationURL)
+	dev := newDevIssuer(settings)
+	kl := newKeyLoader(settings, dev)
+	if settings.KeysPreloadTimeout > 0 {
+		// an instance that rejects every JWT token shouldn't look healthy to the orchestration
+		if err := keyloader.WaitForKeys(kl, settings.KeysPreloadTimeout); err != nil {
+			logging.Fatal("Failed to load the keys in time", "timeout", settings.KeysPreloadTimeout, "error", err)
+		}
+		slog.Info("Loaded the keys", "keys", len(kl.Keys()))
+	}
+	settings = withJwtProcessors(settings, newJwtProcessors(settings))
 	crp := revoke.NewCachingRevokeProvider(settings.RevocationProviderUrl)
-	jh := jwthandler.New(kl, crp)
+	if settings.KafkaRevocationsTopic != "" {
+		publishRevocations(crp, newKafkaWriter(settings, newKafkaTransport(settings), settings.KafkaRevocationsTopic, settings.KafkaLinger))
+		slog.Info("Applied revocations are produced to Kafka", "topic", settings.KafkaRevocationsTopic, "brokers", settings.KafkaBrokers)
+	}
+	if settings.InvalidationRedisURL != nil {
+		bus, err := invalidation.New(settings.InvalidationRedisURL, settings.InvalidationChannel)
+		if err != nil {
+			logging.Fatal("Failed to subscribe to the invalidation channel", "error", err)
+		}
+		// wrapped after the checks for optional interfaces above, the wrapper only has the Cache methods
+		cache = bus.Sync(cache, crp)
+		slog.Info("Cache invalidations and pushed revocations are shared", "channel", settings.InvalidationChannel, "redis", settings.InvalidationRedisURL.Redacted())
+	}
+
+	var dl denylist.List
+	if settings.JtiDenyListURL != nil {
+		var err error
+		dl, err = denylist.New(settings.JtiDenyListURL, settings.JtiDenyListRefreshInterval, settings.JtiDenyListRedisKey)
+		if err != nil {
+			logging.Fatal("Failed to load the JTI deny list", "error", err)
+		}
+		slog.Info("Token IDs are checked against the deny list", "url", settings.JtiDenyListURL.Redacted())
+	}
+
+	th := newReloadableHandler(newTokenInfoHandler(settings, cache, kl, crp, dl))
+	options.OnReload(func(s *options.Settings) {
+		logging.SetLevel(s.LogLevel)
+		th.set(newTokenInfoHandler(s, cache, kl, crp, dl))
+	})
+	reloadOnSignal()
+
+	// the self test is neither audited nor failed by CHAOS_MODE, it validates the canary token of this instance. The length of the tokens
+	// of batch, introspection, TokenReview and ext_authz requests is only checked here
+	vh := withChaos(settings, withWebhook(settings, withAudit(settings, withLimits(limits.Limits{TokenLength: settings.MaxTokenLength}, th))))
+	rl := limits.Limits{TokenLength: settings.MaxTokenLength, URLLength: settings.MaxURLLength, BodySize: settings.MaxRequestBodySize}
+	if settings.ExtAuthzListenAddress != "" {
+		// Envoy is the only caller of the gRPC service, it has no caller authentication
+		go serveExtAuthz(settings.ExtAuthzListenAddress, withRateLimit(settings, vh))
+	}
+
+	routes := map[string]map[string]http.Handler{
+		healthHandlers: {
+			"/health": healthcheck.NewHandlerWithDependencies(kl, version, healthDependencies(settings, checks, crp),
+				settings.HealthCriticalDependencies, circuits...),
+			"/health/alive": healthcheck.NewLivenessHandler(version),
+			"/health/ready": healthcheck.NewReadinessHandler(kl, version, settings.ReadinessFailureThreshold, checks),
+		},
+		metricsHandlers: {
+			"/metrics":            metrics.Default,
+			"/metrics/prometheus": metrics.DefaultPrometheus,
+		},
+		// not the default ServeMux, net/http/pprof and expvar register their handlers there
+		debugHandlers: {"/debug/": debug.NewHandler(settings.DebugSnapshotDir)},
+	}
+	if canary := selftestCanary(settings); canary != nil {
+		routes[healthHandlers]["/health/selftest"] = selftest.NewHandler(withSerializer(settings, th), canary, version)
+	}
+	if len(settings.AdminUsers) > 0 {
+		routes[adminHandlers] = map[string]http.Handler{"/admin/": admin.NewHandler(cache, crp, settings.AdminUsers)}
+	}
+
+	mux := make(map[string]http.Handler)
+	mux["/oauth2/tokeninfo"] = withCORS(settings, withSerializer(settings, withAccessLog(settings, tracing.NewHandler(withLimits(rl, withCallerAuth(settings, withRateLimit(settings, withETags(settings, vh)))), "/oauth2/tokeninfo"))))
+	// every token of a batch is charged to the rate limits, not the batch request
+	mux["/oauth2/tokeninfo/batch"] = withCORS(settings, withSerializer(settings, withAccessLog(settings, tracing.NewHandler(withLimits(limits.Limits{URLLength: settings.MaxURLLength}, withCallerAuth(settings, batch.NewHandler(withRateLimit(settings, vh), settings.BatchMaxTokens))), "/oauth2/tokeninfo/batch"))))
+	mux["/oauth2/introspect"] = withAccessLog(settings, tracing.NewHandler(withLimits(rl, withCallerAuth(settings, withRateLimit(settings, introspection.NewHandler(vh, settings.IntrospectionClients)))), "/oauth2/introspect"))
+	mux["/apis/authentication.k8s.io/v1/tokenreviews"] = withAccessLog(settings, tracing.NewHandler(withLimits(rl, withCallerAuth(settings, withRateLimit(settings, tokenreview.NewHandler(vh)))), "/apis/authentication.k8s.io/v1/tokenreviews"))
+	mux["/oauth2/userinfo"] = withCORS(settings, withAccessLog(settings, tracing.NewHandler(withLimits(rl, withCallerAuth(settings, withRateLimit(settings, userinfo.NewHandler(vh, tokenTarget, settings.UpstreamUserInfoURL, cache, settings.UserInfoCacheTTL, settings.UpstreamTimeout)))), "/oauth2/userinfo")))
+	if settings.UpstreamTokenExchangeURL != nil {
+		mux["/oauth2/token-exchange"] = withAccessLog(settings, tracing.NewHandler(withRateLimit(settings, tokenexchange.NewHandler(settings.UpstreamTokenExchangeURL, cache,
+			settings.TokenExchangeCacheTTL, settings.UpstreamTimeout, settings.UpstreamRetries, settings.UpstreamRetryBackoff)), "/oauth2/token-exchange"))
+	}
+	mux["/oauth2/connect/keys"] = jwks.NewHandler(kl)
+	if dev != nil {
+		mux["/oauth2/issue-test-token"] = withAccessLog(settings, devtoken.NewHandler(dev))
+	}
+	if len(settings.RevocationPushClients) > 0 {
+		mux["/revocations"] = withAccessLog(settings, tracing.NewHandler(revocations.NewHandler(crp, cache, settings.RevocationPushClients), "/revocations"))
+	}
+	mux["/.well-known/openid-configuration"] = discovery.NewOpenIDConfigurationHandler(settings)
+	mux["/.well-known/tokeninfo-configuration"] = discovery.NewTokenInfoConfigurationHandler(settings)
+	for pattern, h := range mux {
+		mux[pattern] = metrics.NewEndpointHandler(h, pattern)
+	}
+	routes[tokenInfoHandlers] = mux
+	logging.Fatal("Failed to serve", "error", serveListeners(settings, routes))
+}
+
+// newDevIssuer returns the issuer of the test tokens of DEV_MODE, or nil without it
+func newDevIssuer(settings *options.Settings) *devtoken.Issuer {
+	if !settings.DevMode {
+		return nil
+	}
+	dev, err := devtoken.NewIssuer()
+	if err != nil {
+		logging.Fatal("Failed to generate the key of the test tokens", "error", err)
+	}
+	slog.Warn("Development mode is enabled, anyone can get valid tokens from /oauth2/issue-test-token", "kid", dev.KeyID())
+	return dev
+}
+
+// newTokenInfoHandler returns the handler that validates JWT tokens and proxies the other tokens to the
+// upstream token info, if there is a cache for it. With OPA_URL, valid tokens must also be allowed by the policy.
+// It's built again with the new settings on every reload
+func newTokenInfoHandler(settings *options.Settings, cache tokencache.Cache, kl keyloader.KeyLoader, crp *revoke.CachingRevokeProvider, dl denylist.List) http.Handler {
+	ph := errorall.NewErrorAllHandler()
+	switch {
+	case cache != nil && settings.UpstreamShadowURL != nil:
+		ph = tokeninfoproxy.NewTokenInfoProxyHandlerWithShadow(settings.UpstreamTokenInfoURLs, settings.UpstreamShadowURL, cache, settings.UpstreamCacheTTL, settings.UpstreamTimeout)
+	case cache != nil:
+		ph = tokeninfoproxy.NewTokenInfoProxyHandlerWithUpstreams(settings.UpstreamTokenInfoURLs, cache, settings.UpstreamCacheTTL, settings.UpstreamTimeout)
+	}
+	jh := jwthandler.NewWithDenyList(kl, crp, dl)
+	if cache != nil && settings.JwtVerifyPercentage > 0 {
+		jh = verify.NewHandler(jh, ph, settings.JwtVerifyPercentage, settings.UpstreamTimeout)
+	}
+	th := tokeninfo.NewHandler(ph, jh)
+	if settings.TokenRoutes != nil {
+		// the options only accept routes to other upstreams together with an upstream token info, so there is a cache
+		others := make(map[string]http.Handler)
+		for target, u := range settings.TokenRoutes.Upstreams() {
+			others[target] = tokeninfoproxy.NewTokenInfoProxyHandlerForRoute(target, u, cache, settings.UpstreamCacheTTL, settings.UpstreamTimeout)
+		}
+		th = tokeninfo.NewTokenRouter(settings.TokenRoutes, jh, ph, others, th)
+	}
+	if settings.OPAURL != nil {
+		th = policy.NewHandler(th, policy.Config{URL: settings.OPAURL, Timeout: settings.OPATimeout, FailOpen: settings.OPAFailOpen})
+	}
+	if settings.MetricsRealms || len(settings.MetricsScopes) > 0 {
+		th = metrics.NewRealmHandler(th, settings.MetricsRealms, settings.MetricsScopes)
+	}
+	return th
+}
+
+// tokenTarget returns the target that the current token info handlers choose for the token
+func tokenTarget(token string) string {
+	settings := options.Current()
+	return tokeninfo.Target(token, settings.TokenRoutes, jwthandler.IsJWT(token, settings.JWEDecrypter != nil))
+}
+
+// serveExtAuthz serves the Envoy external authorization gRPC service on addr
+func serveExtAuthz(addr string, th http.Handler) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		logging.Fatal("Failed to listen for ext_authz", "error", err)
+	}
+	slog.Info("Serving the Envoy ext_authz gRPC service", "address", addr)
+	slog.Error("The Envoy ext_authz gRPC service stopped", "error", extauthz.NewGRPCServer(th).Serve(l))
+}
+
+// withRateLimit wraps h with the rate limiting middleware when any of the limits is configured
+func withRateLimit(settings *options.Settings, h http.Handler) http.Handler {
+	limits := ratelimit.Limits{Global: settings.RateLimitGlobal, PerIP: settings.RateLimitPerIP, PerToken: settings.RateLimitPerToken}
+	if limits == (ratelimit.Limits{}) {
+		return h
+	}
+	return ratelimit.NewHandler(h, limits)
+}
+
+// withLimits wraps h with the limits of the URL, body and access token sizes, unless all of them are disabled.
+// The batch endpoint has its own body size limit, for up to BATCH_MAX_TOKENS tokens
+func withLimits(l limits.Limits, h http.Handler) http.Handler {
+	if l == (limits.Limits{}) {
+		return h
+	}
+	return limits.NewHandler(h, l)
+}
+
+// withCallerAuth wraps h with the caller authentication when API keys or client certificate subjects are
+// configured
+func withCallerAuth(settings *options.Settings, h http.Handler) http.Handler {
+	if len(settings.CallerAPIKeys) == 0 && len(settings.CallerCertSubjects) == 0 {
+		return h
+	}
+	return callerauth.NewHandler(h, callerauth.Callers{APIKeys: settings.CallerAPIKeys, CertSubjects: settings.CallerCertSubjects})
+}
+
+// withCORS wraps h with the CORS middleware when allowed origins are configured. Preflight requests are
+// answered before the access log and the rate limits
+func withCORS(settings *options.Settings, h http.Handler) http.Handler {
+	if len(settings.CORSAllowedOrigins) == 0 {
+		return h
+	}
+	return cors.NewHandler(h, cors.Config{
+		Origins: settings.CORSAllowedOrigins,
+		Methods: settings.CORSAllowedMethods,
+		Headers: settings.CORSAllowedHeaders,
+		MaxAge:  settings.CORSMaxAge,
+	})
+}
 
-	mux := http.NewServeMux()
-	mux.Handle("/health", healthcheck.NewHandler(kl, version))
-	mux.Handle("/oauth2/tokeninfo", tokeninfo.NewHandler(ph, jh))
-	mux.Handle("/oauth2/connect/keys", jwks.NewHandler(kl))
-	log.Fatal(http.ListenAndServe(settings.ListenAddress, mux))
+// withETags wraps h with the answers to the conditional requests of RESPONSE_ETAGS. It's outside of the policy,
+// the audit log and the webhook, so that they see the real response of every request
+func withETags(settings *options.Settings, h http.Handler) http.Handler {
+	if !settings.ResponseETags {
+		return h
+	}
+	return tokeninfo.NewConditionalHandler(h)
+}
+
+// withSerializer wraps h with the re-encoding of the responses for the Accept header. With RESPONSE_CANONICAL_JSON
+// the JSON responses are re-encoded too
+func withSerializer(settings *options.Settings, h http.Handler) http.Handler {
+	if settings.ResponseCanonicalJSON {
+		return serializer.NewCanonicalHandler(h, settings.ResponseFieldOrder)
+	}
+	return serializer.NewHandler(h)
+}
+
+// withAudit wraps h with the audit log when an AUDIT_SINK is configured
+func withAudit(settings *options.Settings, h http.Handler) http.Handler {
+	var sink audit.Sink
+	switch settings.AuditSink {
+	case "file":
+		var err error
+		if sink, err = audit.NewFileSink(settings.AuditFile); err != nil {
+			logging.Fatal("Failed to open the audit log", "error", err)
+		}
+		slog.Info("Token validations are audited in a file", "file", settings.AuditFile)
+	case "http":
+		sink = audit.NewHTTPSink(settings.AuditURL)
+		slog.Info("Token validations are audited at a URL", "url", settings.AuditURL)
+	case "kafka":
+		sink = audit.NewKafkaSink(settings.AuditURL)
+		slog.Info("Token validations are audited in the Kafka topic of the REST proxy", "url", settings.AuditURL)
+	case "kafka-native":
+		sink = audit.NewKafkaNativeSink(newKafkaWriter(settings, newKafkaTransport(settings), settings.KafkaAuditTopic, kafkaAuditBatchTimeout))
+		slog.Info("Token validations are audited in Kafka", "topic", settings.KafkaAuditTopic, "brokers", settings.KafkaBrokers)
+	default:
+		return h
+	}
+	return audit.NewHandler(h, audit.NewLogger(sink, []byte(settings.AuditHMACKey), settings.AuditQueueSize, settings.AuditFlushInterval))
+}
+
+// withWebhook wraps h with the webhook notifications when a WEBHOOK_URL is configured
+func withWebhook(settings *options.Settings, h http.Handler) http.Handler {
+	if settings.WebhookURL == nil {
+		return h
+	}
+	slog.Info("Token validations are notified to a webhook", "conditions", settings.WebhookConditions, "url", settings.WebhookURL.Redacted())
+	notifier := webhook.NewNotifier(settings.WebhookURL.String(), settings.WebhookSecret, settings.WebhookFlushInterval)
+	return webhook.NewHandler(h, notifier, settings.WebhookConditions, settings.WebhookExpectedRealms)
+}
+
+// withChaos wraps h with the fault injection of CHAOS_MODE. The faults follow the changes of the settings at
+// runtime, so the consumers can test one failure after the other without restarts
+func withChaos(settings *options.Settings, h http.Handler) http.Handler {
+	if !settings.ChaosMode {
+		return h
+	}
+	slog.Warn("Chaos mode is enabled, faults are injected into the token validation responses", "latency", settings.ChaosLatency,
+		"latency_percentage", settings.ChaosLatencyPercentage, "error_percentage", settings.ChaosErrorPercentage, "malformed_percentage", settings.ChaosMalformedPercentage)
+	injector := chaos.NewInjector(chaosFaults(settings))
+	options.OnReload(func(s *options.Settings) { injector.Set(chaosFaults(s)) })
+	return chaos.NewHandler(h, injector)
+}
+
+func chaosFaults(s *options.Settings) chaos.Faults {
+	return chaos.Faults{
+		Latency:             s.ChaosLatency,
+		LatencyPercentage:   s.ChaosLatencyPercentage,
+		ErrorPercentage:     s.ChaosErrorPercentage,
+		ErrorStatus:         s.ChaosErrorStatus,
+		MalformedPercentage: s.ChaosMalformedPercentage,
+	}
+}
+
+// withAccessLog wraps h with the access log middleware when an access log destination is configured
+func withAccessLog(settings *options.Settings, h http.Handler) http.Handler {
+	if settings.AccessLogDestination == "" {
+		return h
+	}
+	return accesslog.NewHandler(h, accessLogWriter(settings.AccessLogDestination), settings.AccessLogSampleRate)
+}
+
+var accessLogOut io.Writer
+
+// accessLogWriter returns the writer for "stdout", "stderr" or a file path. The file is opened only once
+// and shared by all access logs
+func accessLogWriter(dest string) io.Writer {
+	if accessLogOut != nil {
+		return accessLogOut
+	}
+	switch dest {
+	case "stdout":
+		accessLogOut = os.Stdout
+	case "stderr":
+		accessLogOut = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			logging.Fatal("Failed to open the access log", "error", err)
+		}
+		accessLogOut = f
+	}
+	return accessLogOut
 }
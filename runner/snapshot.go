@@ -0,0 +1,39 @@
+package runner
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zalando/planb-tokeninfo/tokencache"
+)
+
+// restoreCache loads the entries saved at path by a previous process into the cache and saves them again
+// when the process is stopped with SIGINT or SIGTERM. Only the memory cache backend supports snapshots
+func restoreCache(cache tokencache.Cache, path string) {
+	s, ok := cache.(tokencache.Snapshotter)
+	if !ok {
+		slog.Warn("The upstream cache backend doesn't support snapshots, the snapshot file is not used", "file", path)
+		return
+	}
+	if n, err := tokencache.LoadSnapshot(s, path); err != nil {
+		slog.Warn("Failed to load the upstream cache snapshot", "file", path, "error", err)
+	} else {
+		slog.Info("Loaded the upstream cache snapshot", "file", path, "entries", n)
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		if n, err := tokencache.SaveSnapshot(s, path); err != nil {
+			slog.Warn("Failed to save the upstream cache snapshot", "file", path, "error", err)
+		} else {
+			slog.Info("Saved the upstream cache snapshot", "file", path, "entries", n)
+		}
+		// stop like the default handler of the signal would have done
+		signal.Reset(sig)
+		syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+	}()
+}
@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemdPrefix starts the addresses of sockets passed by systemd socket activation, followed by the
+// FileDescriptorName of the socket unit or the position of the socket
+const systemdPrefix = "systemd:"
+
+// the first file descriptor passed by systemd, after stdin, stdout and stderr
+const listenFDsStart = 3
+
+var (
+	activatedOnce sync.Once
+	activated     *activatedSockets
+)
+
+// activatedSockets are the sockets passed by systemd. Every socket gets a single listener, shared by the
+// addresses that refer to it by name and by position
+type activatedSockets struct {
+	mu        sync.Mutex
+	files     map[string]*os.File
+	listeners map[*os.File]net.Listener
+}
+
+// systemdListener returns the listener of the socket passed by systemd with the name or position
+func systemdListener(name string) (net.Listener, error) {
+	activatedOnce.Do(func() {
+		activated = &activatedSockets{files: activatedFiles(os.Getpid(), os.Getenv, listenFDsStart), listeners: make(map[*os.File]net.Listener)}
+		// the sockets must not be passed again to child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	return activated.listener(name)
+}
+
+func (a *activatedSockets) listener(name string) (net.Listener, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("no socket %q passed by systemd", name)
+	}
+	if l, ok := a.listeners[f]; ok {
+		return l, nil
+	}
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	// the listener has its own copy of the file descriptor, the file is not needed by later lookups
+	f.Close()
+	a.listeners[f] = l
+	return l, nil
+}
+
+// activatedFiles returns the files of the sockets passed with LISTEN_FDS to the process pid, by name and by
+// position. See sd_listen_fds(3)
+func activatedFiles(pid int, getenv func(string) string, first int) map[string]*os.File {
+	if p, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || p != pid {
+		return nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	files := make(map[string]*os.File, 2*n)
+	for i := 0; i < n; i++ {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(first+i), name)
+		files[strconv.Itoa(i)] = f
+		files[name] = f
+	}
+	return files
+}
@@ -0,0 +1,117 @@
+// Package tlscert loads the TLS certificate of the tokeninfo listener and reloads it when the
+// certificate or key files change on disk, so rotated certificates are used without a restart
+package tlscert
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+const (
+	metricsReloadSuccess  = "planb.tls.reload.success"
+	metricsReloadFailures = "planb.tls.reload.failures"
+)
+
+var scheduleFunc = keyloader.Schedule
+
+// A Reloader holds the current certificate of a cert/key file pair
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the certificate from certFile and keyFile and checks every interval if any of the
+// files changed. An error is returned only if the initial certificate can't be loaded; later failures
+// are logged and the previous certificate is kept
+func NewReloader(certFile string, keyFile string, interval time.Duration) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	scheduleFunc(interval, r.reload)
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It can be used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig returns a tls.Config that always serves the current certificate
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
+
+func (r *Reloader) reload() {
+	mt, err := r.lastModified()
+	if err != nil {
+		slog.Warn("Failed to check the TLS certificate files", "error", err)
+		incCounter(metricsReloadFailures)
+		return
+	}
+
+	r.mu.RLock()
+	changed := mt.After(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		slog.Warn("Failed to reload the TLS certificate, keeping the previous one", "error", err)
+		incCounter(metricsReloadFailures)
+		return
+	}
+	slog.Info("Reloaded the TLS certificate", "file", r.certFile)
+	incCounter(metricsReloadSuccess)
+}
+
+func (r *Reloader) load() error {
+	mt, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = mt
+	r.mu.Unlock()
+	return nil
+}
+
+// lastModified returns the most recent modification time of the certificate and key files
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,95 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/keyloader"
+)
+
+func init() {
+	scheduleFunc = func(_ time.Duration, _ keyloader.JobFunc) {}
+}
+
+func writeCertificate(t *testing.T, dir string, cn string, modTime time.Time) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate: ", err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+
+	cf, kf := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ioutil.WriteFile(cf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(kf, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	os.Chtimes(cf, modTime, modTime)
+	os.Chtimes(kf, modTime, modTime)
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	c, _ := r.GetCertificate(nil)
+	x, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal("Failed to parse certificate: ", err)
+	}
+	return x.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tlscert")
+	defer os.RemoveAll(dir)
+
+	if _, err := NewReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), time.Second); err == nil {
+		t.Error("Expected an error for missing certificate files")
+	}
+
+	start := time.Now().Add(-time.Hour)
+	writeCertificate(t, dir, "first", start)
+	r, err := NewReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), time.Second)
+	if err != nil {
+		t.Fatal("Failed to load certificate: ", err)
+	}
+	if cn := commonName(t, r); cn != "first" {
+		t.Errorf("Wrong certificate. Wanted %q, got %q", "first", cn)
+	}
+
+	// unchanged files are not reloaded
+	writeCertificate(t, dir, "same-time", start)
+	r.reload()
+	if cn := commonName(t, r); cn != "first" {
+		t.Errorf("Certificate should not be reloaded. Got %q", cn)
+	}
+
+	writeCertificate(t, dir, "second", start.Add(time.Minute))
+	r.reload()
+	if cn := commonName(t, r); cn != "second" {
+		t.Errorf("Certificate should be reloaded. Wanted %q, got %q", "second", cn)
+	}
+
+	// a broken key keeps the previous certificate
+	ioutil.WriteFile(filepath.Join(dir, "tls.key"), []byte("garbage"), 0600)
+	r.reload()
+	if cn := commonName(t, r); cn != "second" {
+		t.Errorf("Previous certificate should be kept. Got %q", cn)
+	}
+
+	if r.TLSConfig().GetCertificate == nil {
+		t.Error("TLS config is missing the certificate callback")
+	}
+}
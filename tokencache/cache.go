@@ -0,0 +1,117 @@
+/*
+Package tokencache implements the storage for Token Info responses received from the upstream.
+
+	Usage:
+
+	Create a new cache instance with the New() function, selecting one of the available backends
+
+		c, err := tokencache.New(tokencache.BackendMemory, 10000, 0, 16, nil)
+
+	Responses can then be stored for a given TTL and retrieved later
+
+		c.Set("token", []byte(`{"uid":"foo"}`), time.Minute)
+		...
+		if v, err := c.Get("token"); err == nil {
+			doSomethingWith(v)
+		}
+
+	Get returns ErrNotFound when there is no entry for the key and ErrExpired when there was one, but
+	its TTL has passed. Any other error comes from the backend itself.
+
+	Caches can be purged entirely and report statistics about their content and usage
+
+		s, err := c.Stats()
+		...
+		c.Purge()
+*/
+package tokencache
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the available cache backends
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+var (
+	// ErrNotFound is returned whenever there is no entry for the key in the cache
+	ErrNotFound = errors.New("Cache entry not found")
+	// ErrExpired is returned whenever the entry for the key is in the cache but its TTL has passed
+	ErrExpired = errors.New("Cache entry expired")
+)
+
+// A Cache stores Token Info responses with a TTL. Implementations must be safe for concurrent use. The ones
+// that run in the background, like the memory cache, also implement io.Closer and must be closed when they
+// are no longer used
+type Cache interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	// Purge deletes all the entries
+	Purge() error
+	Stats() (*Stats, error)
+}
+
+// Stats describes the content of a Cache and the results of the Get calls since it was created
+type Stats struct {
+	Size     int64   `json:"size"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+	// age of the oldest entry in seconds, 0 if the backend can't tell
+	OldestEntryAge float64 `json:"oldest_entry_age"`
+	// approximate memory used by the entries, 0 if the backend can't tell
+	Bytes int64 `json:"bytes"`
+}
+
+// counters keeps the number of hits and misses of a Cache
+type counters struct {
+	hits   int64
+	misses int64
+}
+
+// count registers the result of a Get call. Backend errors are neither hits nor misses
+func (c *counters) count(err error) {
+	switch err {
+	case nil:
+		atomic.AddInt64(&c.hits, 1)
+	case ErrNotFound, ErrExpired:
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+func (c *counters) stats(size int64, oldest time.Duration) *Stats {
+	s := &Stats{Size: size, Hits: atomic.LoadInt64(&c.hits), Misses: atomic.LoadInt64(&c.misses), OldestEntryAge: oldest.Seconds()}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+// New returns a Cache for the backend. The maxSize and maxBytes limits and the number of shards are used
+// by the memory backend, while the redisURL is mandatory for the redis backend
+func New(backend string, maxSize int64, maxBytes int64, shards int, redisURL *url.URL) (Cache, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewShardedMemoryCache(maxSize, maxBytes, shards), nil
+	case BackendRedis:
+		if redisURL == nil {
+			return nil, errors.New("Missing URL for the redis cache backend")
+		}
+		return NewRedisCache(redisURL)
+	default:
+		return nil, fmt.Errorf("Unsupported cache backend %q", backend)
+	}
+}
+
+// A Pinger is a Cache with a remote backend that can be checked for availability
+type Pinger interface {
+	Ping() error
+}
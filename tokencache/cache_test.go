@@ -0,0 +1,295 @@
+package tokencache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testCache(t *testing.T, c Cache) {
+	if _, err := c.Get("foo"); err != ErrNotFound {
+		t.Errorf("Expected %v for a missing key, got %v", ErrNotFound, err)
+	}
+
+	if err := c.Set("foo", []byte("bar"), time.Minute); err != nil {
+		t.Fatal("Failed to set key: ", err)
+	}
+
+	v, err := c.Get("foo")
+	if err != nil || string(v) != "bar" {
+		t.Errorf("Wrong value for key. Wanted %q, got %q (%v)", "bar", v, err)
+	}
+
+	if err := c.Delete("foo"); err != nil {
+		t.Fatal("Failed to delete key: ", err)
+	}
+
+	if _, err := c.Get("foo"); err != ErrNotFound {
+		t.Errorf("Expected %v for a deleted key, got %v", ErrNotFound, err)
+	}
+
+	c.Set("foo", []byte("bar"), time.Minute)
+	c.Set("baz", []byte("qux"), time.Minute)
+	s, err := c.Stats()
+	if err != nil {
+		t.Fatal("Failed to get stats: ", err)
+	}
+	if s.Size != 2 || s.Hits != 1 || s.Misses != 2 || s.HitRatio != float64(1)/3 {
+		t.Errorf("Wrong stats: %+v", s)
+	}
+
+	if err := c.Purge(); err != nil {
+		t.Fatal("Failed to purge: ", err)
+	}
+	if s, _ := c.Stats(); s.Size != 0 {
+		t.Errorf("Cache should be empty after a purge, got %d entries", s.Size)
+	}
+	if _, err := c.Get("baz"); err != ErrNotFound {
+		t.Errorf("Expected %v for a purged key, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemoryCache(10)
+	testCache(t, c)
+
+	c.Set("short", []byte("lived"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := c.Get("short"); err != ErrExpired {
+		t.Errorf("Expected %v for an expired key, got %v", ErrExpired, err)
+	}
+
+	c.Set("old", []byte("entry"), time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	c.Set("new", []byte("entry"), time.Minute)
+	if s, _ := c.Stats(); s.OldestEntryAge < 0.01 {
+		t.Errorf("Wrong age of the oldest entry: %v", s.OldestEntryAge)
+	}
+}
+
+func TestMemoryCacheLimits(t *testing.T) {
+	value := make([]byte, 100)
+	entrySize := int64(len("k0")+len(value)) + memoryEntryOverhead
+	for _, test := range []struct {
+		maxSize   int64
+		maxBytes  int64
+		wantSize  int64
+		wantBytes int64
+	}{
+		{3, 0, 3, 3 * entrySize},
+		{10, 2*entrySize + 1, 2, 2 * entrySize},
+		{10, entrySize - 1, 0, 0},
+	} {
+		c := NewMemoryCacheWithMaxBytes(test.maxSize, test.maxBytes)
+		for i := 0; i < 5; i++ {
+			if i == 4 {
+				// k3 becomes the most recently used entry and survives one more insert than k2
+				c.Get("k3")
+			}
+			c.Set(fmt.Sprintf("k%d", i), value, time.Minute)
+		}
+		s, _ := c.Stats()
+		if s.Size != test.wantSize || s.Bytes != test.wantBytes {
+			t.Errorf("Wrong stats with %d max entries and %d max bytes. Wanted %d entries and %d bytes, got %+v",
+				test.maxSize, test.maxBytes, test.wantSize, test.wantBytes, s)
+		}
+		if test.wantSize == 2 {
+			if _, err := c.Get("k3"); err != nil {
+				t.Error("Recently used entry should not be evicted: ", err)
+			}
+			if _, err := c.Get("k2"); err != ErrNotFound {
+				t.Errorf("Least recently used entry should be evicted, got %v", err)
+			}
+		}
+	}
+
+	c := NewMemoryCacheWithMaxBytes(10, 0)
+	c.Set("k0", value, time.Minute)
+	c.Set("k0", value[:50], time.Minute)
+	c.Delete("k0")
+	if s, _ := c.Stats(); s.Size != 0 || s.Bytes != 0 {
+		t.Errorf("Replaced and deleted entries should be accounted for, got %+v", s)
+	}
+}
+
+func TestShardedMemoryCache(t *testing.T) {
+	testCache(t, NewShardedMemoryCache(100, 0, 8))
+
+	c := NewShardedMemoryCache(16, 0, 4).(*memoryCache)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("k%d", i), []byte("v"), time.Minute)
+	}
+	s, _ := c.Stats()
+	if s.Size > 16 || s.Size < 4 {
+		t.Errorf("Wrong number of entries in the sharded cache: %d", s.Size)
+	}
+	for i, shard := range c.shards {
+		if n := shard.lru.Len(); n == 0 || n > 4 {
+			t.Errorf("Wrong number of entries in shard %d: %d", i, n)
+		}
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	defer func(d time.Duration) { janitorInterval = d }(janitorInterval)
+	janitorInterval = 5 * time.Millisecond
+	c := NewShardedMemoryCache(10, 0, 2)
+	c.Set("short", []byte("lived"), time.Millisecond)
+	c.Set("long", []byte("lived"), time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if s, _ := c.Stats(); s.Size != 1 || s.Misses != 0 {
+		t.Errorf("Expired entry should have been removed by the janitor, got %+v", s)
+	}
+}
+
+func TestCloseStopsJanitor(t *testing.T) {
+	defer func(d time.Duration) { janitorInterval = d }(janitorInterval)
+	janitorInterval = 5 * time.Millisecond
+	c := NewShardedMemoryCache(10, 0, 2)
+	closer, ok := c.(io.Closer)
+	if !ok {
+		t.Fatal("The memory cache should be an io.Closer")
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := closer.Close(); err != nil {
+		t.Error("Closing the cache twice should not fail: ", err)
+	}
+	c.Set("short", []byte("lived"), time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if s, _ := c.Stats(); s.Size != 1 {
+		t.Errorf("The janitor should have stopped, got %+v", s)
+	}
+}
+
+func benchmarkMemoryCache(shards int, b *testing.B) {
+	c := NewShardedMemoryCache(10000, 0, shards)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%064d", i)
+		c.Set(keys[i], []byte("value"), time.Hour)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%10 == 0 {
+				c.Set(keys[i%len(keys)], []byte("value"), time.Hour)
+			} else {
+				c.Get(keys[i%len(keys)])
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMemoryCache(b *testing.B) {
+	benchmarkMemoryCache(1, b)
+}
+
+func BenchmarkShardedMemoryCache(b *testing.B) {
+	benchmarkMemoryCache(16, b)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory: ", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	empty := NewMemoryCache(10)
+	if n, err := LoadSnapshot(empty.(Snapshotter), path); n != 0 || err != nil {
+		t.Errorf("Missing snapshot should leave the cache empty, got %d entries (%v)", n, err)
+	}
+
+	c := NewMemoryCache(10)
+	c.Set("k0", []byte("v0"), time.Minute)
+	c.Set("k1", []byte("v1"), time.Minute)
+	c.Set("short", []byte("lived"), 20*time.Millisecond)
+	c.Set("k2", []byte("v2"), time.Minute)
+	c.Get("k0")
+	if n, err := SaveSnapshot(c.(Snapshotter), path); n != 4 || err != nil {
+		t.Fatalf("Failed to save snapshot. Wanted 4 entries, got %d (%v)", n, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Snapshot should only be readable by the owner, got %v (%v)", fi.Mode(), err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	r := NewMemoryCache(2)
+	if n, err := LoadSnapshot(r.(Snapshotter), path); n != 3 || err != nil {
+		t.Errorf("Failed to load snapshot. Wanted 3 entries, got %d (%v)", n, err)
+	}
+	// the least recently used entry doesn't fit anymore
+	for key, want := range map[string]error{"k0": nil, "k2": nil, "k1": ErrNotFound, "short": ErrNotFound} {
+		if _, err := r.Get(key); err != want {
+			t.Errorf("Wrong result for restored key %q. Wanted %v, got %v", key, want, err)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte("garbage"), 0600)
+	if _, err := LoadSnapshot(NewMemoryCache(10).(Snapshotter), path); err == nil {
+		t.Error("Invalid snapshot should fail to load")
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("Failed to start redis server: ", err)
+	}
+	defer s.Close()
+
+	u, _ := url.Parse("redis://" + s.Addr())
+	c, err := NewRedisCache(u)
+	if err != nil {
+		t.Fatal("Failed to create redis cache: ", err)
+	}
+	s.Set("other-application", "value")
+	testCache(t, c)
+	if !s.Exists("other-application") {
+		t.Error("Keys from other applications should not be purged")
+	}
+
+	if err := c.(Pinger).Ping(); err != nil {
+		t.Error("Failed to ping redis server: ", err)
+	}
+
+	c.Set("short", []byte("lived"), time.Second)
+	if !s.Exists(redisKeyPrefix + "short") {
+		t.Error("Key is missing the prefix in redis")
+	}
+	s.FastForward(2 * time.Second)
+	if _, err := c.Get("short"); err != ErrNotFound {
+		t.Errorf("Expected %v for an expired key, got %v", ErrNotFound, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	u, _ := url.Parse("redis://localhost:6379")
+	for _, test := range []struct {
+		backend  string
+		redisURL *url.URL
+		wantErr  bool
+	}{
+		{"", nil, false},
+		{BackendMemory, nil, false},
+		{BackendRedis, nil, true},
+		{BackendRedis, u, false},
+		{"memcached", nil, true},
+	} {
+		_, err := New(test.backend, 10, 0, 4, test.redisURL)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected result for backend %q. Wanted error %t, got %v", test.backend, test.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package tokencache
+
+import (
+	"container/list"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// memoryEntryOverhead approximates the memory used by an entry besides its key and value: the entry
+// itself, its list element and its slot in the map
+const memoryEntryOverhead = 128
+
+// janitorInterval is how often every shard removes its expired entries
+var janitorInterval = time.Minute
+
+// memoryCache spreads the entries over shards by the hash of their key, so that concurrent calls for
+// different keys rarely wait for the same lock. Every shard has its own share of the limits and evicts
+// its own least recently used entries
+type memoryCache struct {
+	counters
+	shards []*memoryShard
+	// closed by Close to stop the janitors
+	stop      chan struct{}
+	closeOnce sync.Once
+	// totals over all shards, kept for the gauges
+	size  int64
+	bytes int64
+}
+
+type memoryShard struct {
+	cache    *memoryCache
+	mu       sync.Mutex
+	maxSize  int64
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element
+	// most recently used entries are at the front
+	lru *list.List
+}
+
+// the time an entry was stored is kept to report the age of the oldest entry
+type memoryEntry struct {
+	key     string
+	value   []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key)+len(e.value)) + memoryEntryOverhead
+}
+
+// NewMemoryCache returns a Cache that keeps at most maxSize entries in the process memory. Least
+// recently used entries are evicted first
+func NewMemoryCache(maxSize int64) Cache {
+	return NewMemoryCacheWithMaxBytes(maxSize, 0)
+}
+
+// NewMemoryCacheWithMaxBytes returns a Cache that keeps at most maxSize entries and, if maxBytes is
+// greater than 0, approximately maxBytes of keys and values in the process memory. Least recently used
+// entries are evicted first when any of the limits is reached
+func NewMemoryCacheWithMaxBytes(maxSize int64, maxBytes int64) Cache {
+	return NewShardedMemoryCache(maxSize, maxBytes, 1)
+}
+
+// NewShardedMemoryCache returns a Cache like NewMemoryCacheWithMaxBytes with the entries spread over the
+// given number of shards. Each shard gets an equal share of the limits, rounded up, and evicts its least
+// recently used entries on its own. Expired entries are removed by a janitor per shard until the cache is
+// closed
+func NewShardedMemoryCache(maxSize int64, maxBytes int64, shards int) Cache {
+	if shards < 1 {
+		shards = 1
+	}
+	n := int64(shards)
+	c := &memoryCache{shards: make([]*memoryShard, shards), stop: make(chan struct{})}
+	for i := range c.shards {
+		s := &memoryShard{cache: c, maxSize: (maxSize + n - 1) / n, entries: make(map[string]*list.Element), lru: list.New()}
+		if maxBytes > 0 {
+			s.maxBytes = (maxBytes + n - 1) / n
+		}
+		c.shards[i] = s
+		go s.janitor(janitorInterval, c.stop)
+	}
+	return c
+}
+
+// shard returns the shard of key, chosen by its FNV-1a hash
+func (c *memoryCache) shard(key string) *memoryShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+func (c *memoryCache) Get(key string) ([]byte, error) {
+	v, err := c.shard(key).get(key)
+	c.count(err)
+	return v, err
+}
+
+func (s *memoryShard) get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		s.remove(el)
+		s.cache.updateGauges()
+		return nil, ErrExpired
+	}
+	s.lru.MoveToFront(el)
+	return e.value, nil
+}
+
+// Set stores the value and evicts the least recently used entries of its shard until the shard is within
+// its limits again. Values bigger than the maximum bytes of a shard on their own are not stored
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(&memoryEntry{key: key, value: value, stored: now, expires: now.Add(ttl)})
+	c.updateGauges()
+	return nil
+}
+
+// add stores e as the most recently used entry and evicts entries as needed. The caller must hold the lock
+func (s *memoryShard) add(e *memoryEntry) {
+	if el, ok := s.entries[e.key]; ok {
+		s.remove(el)
+	}
+	if s.maxBytes > 0 && e.size() > s.maxBytes {
+		return
+	}
+	s.entries[e.key] = s.lru.PushFront(e)
+	s.bytes += e.size()
+	atomic.AddInt64(&s.cache.size, 1)
+	atomic.AddInt64(&s.cache.bytes, e.size())
+	for s.lru.Len() > 0 && (int64(s.lru.Len()) > s.maxSize || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		s.remove(s.lru.Back())
+		incCounter("planb.tokeninfo.proxy.cache.evictions")
+	}
+}
+
+func (c *memoryCache) Delete(key string) error {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+		c.updateGauges()
+	}
+	return nil
+}
+
+func (c *memoryCache) Purge() error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for s.lru.Len() > 0 {
+			s.remove(s.lru.Back())
+		}
+		s.mu.Unlock()
+	}
+	c.updateGauges()
+	return nil
+}
+
+func (c *memoryCache) Stats() (*Stats, error) {
+	now := time.Now()
+	var size, bytes int64
+	var oldest time.Duration
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for el := s.lru.Front(); el != nil; el = el.Next() {
+			e := el.Value.(*memoryEntry)
+			if age := now.Sub(e.stored); now.Before(e.expires) && age > oldest {
+				oldest = age
+			}
+		}
+		size += int64(s.lru.Len())
+		bytes += s.bytes
+		s.mu.Unlock()
+	}
+	st := c.stats(size, oldest)
+	st.Bytes = bytes
+	return st, nil
+}
+
+// Snapshot writes the entries that haven't expired yet, shard by shard and least recently used first, so
+// that restoring them keeps their order
+func (c *memoryCache) Snapshot(w io.Writer) (int, error) {
+	now := time.Now()
+	enc := json.NewEncoder(w)
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for el := s.lru.Back(); el != nil; el = el.Prev() {
+			e := el.Value.(*memoryEntry)
+			if !now.Before(e.expires) {
+				continue
+			}
+			if err := enc.Encode(snapshotEntry{Key: e.key, Value: e.value, Stored: e.stored, Expires: e.expires}); err != nil {
+				s.mu.Unlock()
+				return n, err
+			}
+			n++
+		}
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+// Restore adds the entries of a snapshot written by Snapshot. Entries that expired in the meantime are skipped
+func (c *memoryCache) Restore(r io.Reader) (int, error) {
+	defer c.updateGauges()
+	now := time.Now()
+	dec := json.NewDecoder(r)
+	n := 0
+	for {
+		var se snapshotEntry
+		if err := dec.Decode(&se); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if !now.Before(se.Expires) {
+			continue
+		}
+		s := c.shard(se.Key)
+		s.mu.Lock()
+		s.add(&memoryEntry{key: se.Key, value: se.Value, stored: se.Stored, expires: se.Expires})
+		s.mu.Unlock()
+		n++
+	}
+}
+
+// janitor removes the expired entries of the shard every interval. Without it, expired entries that are
+// never requested again would stay until they are evicted. It returns when stop is closed
+func (s *memoryShard) janitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.removeExpired(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close stops the janitors of the shards. The cache can still be used, but expired entries are only
+// removed when they are requested or evicted
+func (c *memoryCache) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (s *memoryShard) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := false
+	for el := s.lru.Front(); el != nil; {
+		next := el.Next()
+		if now.After(el.Value.(*memoryEntry).expires) {
+			s.remove(el)
+			removed = true
+		}
+		el = next
+	}
+	if removed {
+		s.cache.updateGauges()
+	}
+}
+
+// remove deletes the entry of el. The caller must hold the lock
+func (s *memoryShard) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*memoryEntry)
+	delete(s.entries, e.key)
+	s.bytes -= e.size()
+	atomic.AddInt64(&s.cache.size, -1)
+	atomic.AddInt64(&s.cache.bytes, -e.size())
+}
+
+// updateGauges reports the number of entries and the memory footprint of all shards
+func (c *memoryCache) updateGauges() {
+	updateGauge("planb.tokeninfo.proxy.cache.entries", atomic.LoadInt64(&c.size))
+	updateGauge("planb.tokeninfo.proxy.cache.bytes", atomic.LoadInt64(&c.bytes))
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
+
+func updateGauge(key string, value int64) {
+	if g, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewGauge).(metrics.Gauge); ok {
+		g.Update(value)
+	}
+}
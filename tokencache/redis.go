@@ -0,0 +1,94 @@
+package tokencache
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// all keys are prefixed so that a Redis instance can be shared with other applications
+const redisKeyPrefix = "planb-tokeninfo:"
+
+// number of keys fetched per SCAN call when purging or counting the entries
+const redisScanCount = 1000
+
+type redisCache struct {
+	counters
+	client *redis.Client
+}
+
+// NewRedisCache returns a Cache backed by the Redis server at u (for ex., redis://:password@host:6379/0).
+// The Redis server takes care of expiring the entries, so ErrExpired is never returned
+func NewRedisCache(u *url.URL) (Cache, error) {
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{client: redis.NewClient(opts)}, nil
+}
+
+func (c *redisCache) Get(key string) ([]byte, error) {
+	v, err := c.client.Get(redisKeyPrefix + key).Bytes()
+	if err == redis.Nil {
+		err = ErrNotFound
+	}
+	c.count(err)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (c *redisCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(redisKeyPrefix+key, value, ttl).Err()
+}
+
+func (c *redisCache) Delete(key string) error {
+	return c.client.Del(redisKeyPrefix + key).Err()
+}
+
+// Purge deletes the keys with the prefix of this application only, the Redis server may be shared
+func (c *redisCache) Purge() error {
+	return c.scan(func(keys []string) error {
+		return c.client.Del(keys...).Err()
+	})
+}
+
+// Stats counts the keys with the prefix of this application. Redis doesn't track when keys were created,
+// so the age of the oldest entry is always 0
+func (c *redisCache) Stats() (*Stats, error) {
+	var size int64
+	err := c.scan(func(keys []string) error {
+		size += int64(len(keys))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.stats(size, 0), nil
+}
+
+// scan calls f with every batch of keys with the prefix of this application
+func (c *redisCache) scan(f func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(cursor, redisKeyPrefix+"*", redisScanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := f(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Ping checks that the Redis server is reachable
+func (c *redisCache) Ping() error {
+	return c.client.Ping().Err()
+}
@@ -0,0 +1,59 @@
+package tokencache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A Snapshotter is a Cache whose entries can be saved and restored, so that they survive a restart of the
+// process. Snapshot and Restore return the number of entries written or read
+type Snapshotter interface {
+	Snapshot(w io.Writer) (int, error)
+	Restore(r io.Reader) (int, error)
+}
+
+// snapshotEntry is a single line of a snapshot. The values are base64 encoded by encoding/json
+type snapshotEntry struct {
+	Key     string    `json:"key"`
+	Value   []byte    `json:"value"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+// SaveSnapshot writes the entries of s to the file at path. The file is replaced atomically and is only
+// readable by the owner because it contains token info responses
+func SaveSnapshot(s Snapshotter, path string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	n, err := s.Snapshot(f)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LoadSnapshot restores the entries saved in the file at path into s. A missing file is not an error, the
+// cache stays empty
+func LoadSnapshot(s Snapshotter, path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
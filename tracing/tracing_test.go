@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestHandler(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	var outgoing http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "child")
+		Fail(span, errors.New("failed"))
+		span.End()
+
+		outgoing = make(http.Header)
+		Inject(r.Context(), outgoing)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+	req.Header.Set("traceparent", testTraceParent)
+	NewHandler(next, "tokeninfo").ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Wrong number of spans. Wanted 2, got %d", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "tokeninfo" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("Wrong server span %q (%v)", server.Name(), server.SpanKind())
+	}
+	if server.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Server span should continue the incoming trace, got %v", server.Parent().TraceID())
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("Server span should fail for status 502, got %v", server.Status())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() || child.Status().Code != codes.Error {
+		t.Errorf("Wrong child span: parent %v, status %v", child.Parent().SpanID(), child.Status())
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + server.SpanContext().SpanID().String() + "-01"
+	if outgoing.Get("traceparent") != want {
+		t.Errorf("Wrong outgoing traceparent. Wanted %q, got %q", want, outgoing.Get("traceparent"))
+	}
+}
+
+func TestPropagationWithoutSetup(t *testing.T) {
+	var outgoing http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = make(http.Header)
+		Inject(r.Context(), outgoing)
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/oauth2/tokeninfo", nil)
+	req.Header.Set("traceparent", testTraceParent)
+	NewHandler(next, "tokeninfo").ServeHTTP(httptest.NewRecorder(), req)
+
+	if outgoing.Get("traceparent") != testTraceParent {
+		t.Errorf("Incoming trace context should be propagated. Got %q", outgoing.Get("traceparent"))
+	}
+
+	h := make(http.Header)
+	Inject(context.Background(), h)
+	if len(h) != 0 {
+		t.Errorf("Nothing should be injected without a trace context. Got %v", h)
+	}
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zalando/planb-tokeninfo/clientip"
+	"github.com/zalando/planb-tokeninfo/handlers/tokeninfo"
+	jwthandler "github.com/zalando/planb-tokeninfo/handlers/tokeninfo/jwt"
+	"github.com/zalando/planb-tokeninfo/requestid"
+)
+
+const (
+	// responses bigger than this are not inspected for the uid, realm or error reason
+	maxInspectedBody = 64 * 1024
+	// maxSeenTokens limits the tokens remembered for the new_token condition, the oldest are forgotten first
+	maxSeenTokens = 100000
+)
+
+type handler struct {
+	next       http.Handler
+	notifier   *Notifier
+	conditions map[string]bool
+	realms     map[string]bool
+	seen       *seenTokens
+}
+
+// NewHandler returns an http.Handler that notifies the notifier of the token info responses of next that meet
+// one of the conditions: NewToken for a valid token not seen before for its uid, RevokedToken for a token
+// rejected because it was revoked, which requires the error reasons in the responses, and UnexpectedRealm for
+// a valid token of another realm than the expected realms
+func NewHandler(next http.Handler, notifier *Notifier, conditions []string, realms []string) http.Handler {
+	h := &handler{next: next, notifier: notifier, conditions: make(map[string]bool), realms: make(map[string]bool),
+		seen: newSeenTokens(maxSeenTokens)}
+	for _, c := range conditions {
+		h.conditions[c] = true
+	}
+	for _, r := range realms {
+		h.realms[r] = true
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(rw, r)
+
+	var body struct {
+		UID         string `json:"uid"`
+		Realm       string `json:"realm"`
+		ErrorReason string `json:"error_reason"`
+	}
+	if json.Unmarshal(rw.body.Bytes(), &body) != nil {
+		return
+	}
+	// the next handler already parsed the form, so the body is not read again here
+	token := tokeninfo.AccessTokenFromRequest(r)
+	if token == "" {
+		return
+	}
+	hash := tokeninfo.HashPrefix(token)
+	var conditions []string
+	if rw.status == http.StatusOK {
+		if h.conditions[NewToken] && body.UID != "" && h.seen.add(body.UID+" "+hash) {
+			conditions = append(conditions, NewToken)
+		}
+		if h.conditions[UnexpectedRealm] && !h.realms[body.Realm] {
+			conditions = append(conditions, UnexpectedRealm)
+		}
+	} else if h.conditions[RevokedToken] && body.ErrorReason == jwthandler.ReasonRevoked {
+		conditions = append(conditions, RevokedToken)
+	}
+	for _, c := range conditions {
+		h.notifier.Notify(&Notification{
+			Condition: c,
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			RequestID: requestid.FromContext(r.Context()),
+			TokenHash: hash,
+			UID:       body.UID,
+			Realm:     body.Realm,
+			CallerIP:  clientip.FromRequest(r),
+		})
+	}
+}
+
+// seenTokens is a set of a limited size, it forgets the oldest keys first
+type seenTokens struct {
+	mu   sync.Mutex
+	keys map[string]bool
+	ring []string
+	next int
+}
+
+func newSeenTokens(size int) *seenTokens {
+	return &seenTokens{keys: make(map[string]bool, size), ring: make([]string, size)}
+}
+
+// add returns true if the key was not in the set
+func (s *seenTokens) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys[key] {
+		return false
+	}
+	delete(s.keys, s.ring[s.next])
+	s.ring[s.next] = key
+	s.next = (s.next + 1) % len(s.ring)
+	s.keys[key] = true
+	return true
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.body.Len()+len(b) <= maxInspectedBody {
+		rw.body.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	n := &Notifier{queue: make(chan *Notification, 10)}
+	status, body := http.StatusOK, ""
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+	h := NewHandler(next, n, []string{NewToken, RevokedToken, UnexpectedRealm}, []string{"/services"})
+
+	for _, test := range []struct {
+		token  string
+		status int
+		body   string
+		want   []string
+	}{
+		{"foo", http.StatusOK, `{"uid":"alice","realm":"/services"}`, []string{NewToken}},
+		{"foo", http.StatusOK, `{"uid":"alice","realm":"/services"}`, nil},
+		{"bar", http.StatusOK, `{"uid":"alice","realm":"/services"}`, []string{NewToken}},
+		{"foo", http.StatusOK, `{"uid":"bob","realm":"/employees"}`, []string{NewToken, UnexpectedRealm}},
+		{"foo", http.StatusOK, `{"uid":"bob","realm":"/employees"}`, []string{UnexpectedRealm}},
+		{"baz", http.StatusUnauthorized, `{"error":"invalid_token","error_reason":"revoked"}`, []string{RevokedToken}},
+		{"baz", http.StatusUnauthorized, `{"error":"invalid_token","error_reason":"expired"}`, nil},
+		{"", http.StatusBadRequest, `{"error":"invalid_request"}`, nil},
+	} {
+		status, body = test.status, test.body
+		r, _ := http.NewRequest("GET", "/oauth2/tokeninfo", nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("The response should be passed on, got %d %q", w.Code, w.Body.String())
+		}
+
+		var got []string
+		for len(n.queue) > 0 {
+			nt := <-n.queue
+			if nt.TokenHash == "" || nt.CallerIP != "10.0.0.1" || nt.Time == "" {
+				t.Errorf("Missing details in %+v", nt)
+			}
+			got = append(got, nt.Condition)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Wrong notifications for %s %s. Wanted %v, got %v", test.token, test.body, test.want, got)
+		}
+	}
+}
+
+func TestSeenTokens(t *testing.T) {
+	s := newSeenTokens(2)
+	for _, test := range []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"a", false},
+		{"c", true},
+		// the oldest key is forgotten
+		{"a", true},
+		{"c", false},
+	} {
+		if got := s.add(test.key); got != test.want {
+			t.Errorf("Wrong result for %s. Wanted %v, got %v", test.key, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package webhook notifies a webhook of the token validations that security operations want to know about
+// as they happen: new tokens of a uid, revoked tokens used again and tokens of unexpected realms
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/zalando/planb-tokeninfo/ht"
+)
+
+// the conditions of the notifications
+const (
+	NewToken        = "new_token"
+	RevokedToken    = "revoked_token"
+	UnexpectedRealm = "unexpected_realm"
+)
+
+const (
+	// SignatureHeader has the HMAC-SHA256 of the body with the secret, as "sha256=" and the hex encoded HMAC
+	SignatureHeader = "X-Tokeninfo-Signature"
+	// maxBatchSize is the maximum number of notifications in a request
+	maxBatchSize = 100
+	// queueSize limits the notifications waiting to be sent
+	queueSize = 1000
+	// postTimeout is the timeout of the requests to the webhook
+	postTimeout = 10 * time.Second
+)
+
+// A Notification tells that a token validation met a condition
+type Notification struct {
+	Condition string `json:"condition"`
+	Time      string `json:"time"`
+	RequestID string `json:"request_id,omitempty"`
+	TokenHash string `json:"token_hash,omitempty"`
+	UID       string `json:"uid,omitempty"`
+	Realm     string `json:"realm,omitempty"`
+	CallerIP  string `json:"caller_ip,omitempty"`
+}
+
+// A Notifier posts the notifications in batches to a webhook, as {"notifications": [...]}. When too many
+// notifications are waiting, the new ones are dropped instead of slowing down the validations
+type Notifier struct {
+	url      string
+	secret   []byte
+	client   *http.Client
+	queue    chan *Notification
+	interval time.Duration
+}
+
+// NewNotifier returns a Notifier that posts the notifications to url at least every interval. With a secret,
+// the requests are signed in the SignatureHeader
+func NewNotifier(url string, secret string, interval time.Duration) *Notifier {
+	n := &Notifier{url: url, secret: []byte(secret), client: &http.Client{Timeout: postTimeout, Transport: ht.NewTransport()},
+		queue: make(chan *Notification, queueSize), interval: interval}
+	go n.run()
+	return n
+}
+
+// Notify queues the notification without waiting. It's counted in planb.webhook.dropped when the queue is full
+func (n *Notifier) Notify(nt *Notification) {
+	select {
+	case n.queue <- nt:
+		incCounter("planb.webhook.notifications." + nt.Condition)
+	default:
+		incCounter("planb.webhook.dropped")
+	}
+}
+
+func (n *Notifier) run() {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	batch := make([]*Notification, 0, maxBatchSize)
+	for {
+		select {
+		case nt := <-n.queue:
+			batch = append(batch, nt)
+			if len(batch) < maxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := n.post(batch); err != nil {
+			incCounter("planb.webhook.failures")
+			slog.Warn("Failed to send webhook notifications", "notifications", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (n *Notifier) post(batch []*Notification) error {
+	body, err := json.Marshal(map[string]interface{}{"notifications": batch})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", ht.UserAgent)
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", n.url, resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the value of the SignatureHeader for the body, for ex. to check the requests in the webhook
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func incCounter(key string) {
+	if c, ok := metrics.DefaultRegistry.GetOrRegister(key, metrics.NewCounter).(metrics.Counter); ok {
+		c.Inc(1)
+	}
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestNotifier(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	n := NewNotifier(server.URL, "secret", 10*time.Millisecond)
+	n.Notify(&Notification{Condition: NewToken, UID: "foo"})
+	n.Notify(&Notification{Condition: RevokedToken, TokenHash: "abc"})
+	select {
+	case r := <-requests:
+		body := <-bodies
+		var got struct {
+			Notifications []*Notification `json:"notifications"`
+		}
+		if err := json.Unmarshal(body, &got); err != nil || len(got.Notifications) != 2 || got.Notifications[1].TokenHash != "abc" {
+			t.Errorf("Wrong notifications: %s", body)
+		}
+		if sig := r.Header.Get(SignatureHeader); sig != Sign([]byte("secret"), body) || len(sig) != 71 {
+			t.Errorf("Wrong signature: %q", sig)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The notifications should be sent after the interval")
+	}
+}
+
+func TestDropped(t *testing.T) {
+	dropped := metrics.GetOrRegisterCounter("planb.webhook.dropped", metrics.DefaultRegistry)
+	before := dropped.Count()
+	// without the goroutine sending them, the notifications are never taken from the queue
+	n := &Notifier{queue: make(chan *Notification, 1)}
+	n.Notify(&Notification{Condition: NewToken})
+	n.Notify(&Notification{Condition: NewToken})
+	if dropped.Count() != before+1 {
+		t.Errorf("The notification should be dropped when the queue is full, got %d", dropped.Count()-before)
+	}
+}